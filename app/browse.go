@@ -0,0 +1,303 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/fatih/color"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// browseHelp describes the commands of the line mode of `fissile browse`
+const browseHelp = `Commands:
+  ls [path]      list the entries below the current (or given) entry
+  cd <path>      change to an entry; ".." is the parent, "/" the top
+  show [path]    show the YAML preview of the current (or given) entry
+  find <text>    list the paths of all entries whose name contains the text
+  help           show this help
+  quit           leave the browser`
+
+// browseNode is an entry of the tree navigated by `fissile browse`
+type browseNode struct {
+	name     string
+	value    interface{} // Shown by the show command
+	parent   *browseNode
+	children []*browseNode
+}
+
+// add appends a child entry to the node and returns it
+func (n *browseNode) add(name string, value interface{}) *browseNode {
+	child := &browseNode{name: name, value: value, parent: n}
+	n.children = append(n.children, child)
+	return child
+}
+
+// path returns the absolute path of the node
+func (n *browseNode) path() string {
+	if n.parent == nil {
+		return "/"
+	}
+	return path.Join(n.parent.path(), n.name)
+}
+
+// lookup returns the node at the given path, relative to this node unless
+// it is absolute
+func (n *browseNode) lookup(nodePath string) *browseNode {
+	node := n
+	if strings.HasPrefix(nodePath, "/") {
+		for node.parent != nil {
+			node = node.parent
+		}
+	}
+	for _, name := range strings.Split(nodePath, "/") {
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if node.parent != nil {
+				node = node.parent
+			}
+			continue
+		}
+		var next *browseNode
+		for _, child := range node.children {
+			if child.name == name {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// find returns all nodes below this one whose name contains the text,
+// ignoring case
+func (n *browseNode) find(text string) []*browseNode {
+	var result []*browseNode
+	for _, child := range n.children {
+		if strings.Contains(strings.ToLower(child.name), strings.ToLower(text)) {
+			result = append(result, child)
+		}
+		result = append(result, child.find(text)...)
+	}
+	return result
+}
+
+// newBrowseTree returns the tree of the loaded releases (with their jobs and
+// properties) and instance groups (with their jobs and links)
+func newBrowseTree(roleManifest *model.RoleManifest) *browseNode {
+	root := &browseNode{}
+
+	releases := root.add("releases", nil)
+	for _, release := range roleManifest.LoadedReleases {
+		var jobNames []string
+		for _, job := range release.Jobs {
+			jobNames = append(jobNames, job.Name)
+		}
+		releaseNode := releases.add(release.Name, map[string]interface{}{
+			"name":        release.Name,
+			"version":     release.Version,
+			"type":        release.ReleaseType(),
+			"commit_hash": release.CommitHash,
+			"path":        release.Path,
+			"jobs":        jobNames,
+		})
+		jobs := releaseNode.add("jobs", nil)
+		for _, job := range release.Jobs {
+			var templates []string
+			for _, template := range job.Templates {
+				templates = append(templates, template.DestinationPath)
+			}
+			sort.Strings(templates)
+			var packages []string
+			for _, pkg := range job.Packages {
+				packages = append(packages, pkg.Name)
+			}
+			var providers []string
+			for name := range job.AvailableProviders {
+				providers = append(providers, name)
+			}
+			sort.Strings(providers)
+			var consumers []string
+			for _, consumer := range job.DesiredConsumers {
+				consumers = append(consumers, fmt.Sprintf("%s (%s)", consumer.Name, consumer.Type))
+			}
+			jobNode := jobs.add(job.Name, map[string]interface{}{
+				"name":        job.Name,
+				"version":     job.Version,
+				"description": job.Description,
+				"fingerprint": job.Fingerprint,
+				"templates":   templates,
+				"packages":    packages,
+				"provides":    providers,
+				"consumes":    consumers,
+			})
+			properties := jobNode.add("properties", nil)
+			for _, property := range job.Properties {
+				properties.add(property.Name, map[string]interface{}{
+					"description": property.Description,
+					"default":     property.Default,
+				})
+			}
+		}
+	}
+
+	instanceGroups := root.add("instance-groups", nil)
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		var jobNames []string
+		for _, jobReference := range instanceGroup.JobReferences {
+			jobNames = append(jobNames, jobReference.Name)
+		}
+		instanceGroupNode := instanceGroups.add(instanceGroup.Name, map[string]interface{}{
+			"name":        instanceGroup.Name,
+			"type":        instanceGroup.Type,
+			"description": instanceGroup.Description,
+			"tags":        instanceGroup.Tags,
+			"jobs":        jobNames,
+			"run":         instanceGroup.Run,
+		})
+		jobs := instanceGroupNode.add("jobs", nil)
+		for _, jobReference := range instanceGroup.JobReferences {
+			jobNode := jobs.add(jobReference.Name, map[string]interface{}{
+				"name":       jobReference.Name,
+				"release":    jobReference.ReleaseName,
+				"properties": jobReference.ContainerProperties,
+			})
+			links := jobNode.add("links", nil)
+
+			var linkNames []string
+			for name := range jobReference.ResolvedConsumes {
+				linkNames = append(linkNames, name)
+			}
+			sort.Strings(linkNames)
+			for _, name := range linkNames {
+				consumer := jobReference.ResolvedConsumes[name]
+				links.add(name, map[string]interface{}{
+					"consumes":       consumer.Name,
+					"type":           consumer.Type,
+					"instance_group": consumer.RoleName,
+					"job":            consumer.JobName,
+					"service_name":   consumer.ServiceName,
+				})
+			}
+
+			linkNames = nil
+			for name := range jobReference.ResolvedConsumedBy {
+				linkNames = append(linkNames, name)
+			}
+			sort.Strings(linkNames)
+			for _, name := range linkNames {
+				var consumers []string
+				for _, consumer := range jobReference.ResolvedConsumedBy[name] {
+					consumers = append(consumers, fmt.Sprintf("%s/%s", consumer.RoleName, consumer.JobName))
+				}
+				links.add(name+" (provided)", map[string]interface{}{
+					"provides":    name,
+					"consumed_by": consumers,
+				})
+			}
+		}
+	}
+
+	return root
+}
+
+// BrowseOptions are the options of Browse
+type BrowseOptions struct {
+	// LineMode reads commands line by line from the UI instead of running
+	// the terminal UI, e.g. if the standard input is not a terminal
+	LineMode bool
+}
+
+// Browse runs an interactive, read-only browser of the loaded role manifest:
+// releases, their jobs and properties, and instance groups, their jobs and
+// links are shown as a tree, can be searched, and are previewed as YAML.
+func (f *Fissile) Browse(opt BrowseOptions) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+
+	root := newBrowseTree(f.Manifest)
+	if !opt.LineMode {
+		return browseTerminal(root)
+	}
+
+	return f.browseLines(root)
+}
+
+// browseLines runs the browser in line mode, where the tree is navigated like
+// a file system with commands read from the UI
+func (f *Fissile) browseLines(current *browseNode) error {
+	f.UI.Println(browseHelp)
+
+	scanner := bufio.NewScanner(f.UI)
+	for {
+		f.UI.Printf("%s> ", color.CyanString(current.path()))
+		if !scanner.Scan() {
+			f.UI.Println()
+			return scanner.Err()
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		command, argument := fields[0], strings.Join(fields[1:], " ")
+
+		switch command {
+		case "ls", "cd", "show":
+			node := current
+			if argument != "" {
+				node = current.lookup(argument)
+			}
+			if node == nil {
+				f.UI.Println(color.RedString("No such entry %s", argument))
+				continue
+			}
+			switch command {
+			case "ls":
+				for _, child := range node.children {
+					if len(child.children) > 0 {
+						f.UI.Printf("%s/ (%d)\n", color.YellowString(child.name), len(child.children))
+					} else {
+						f.UI.Println(child.name)
+					}
+				}
+			case "cd":
+				current = node
+			case "show":
+				if node.value == nil {
+					f.UI.Println(color.RedString("Nothing to show for %s; use ls", node.path()))
+					continue
+				}
+				buf, err := yaml.Marshal(node.value)
+				if err != nil {
+					return err
+				}
+				f.UI.Printf("%s", buf)
+			}
+		case "find":
+			if argument == "" {
+				f.UI.Println(color.RedString("find requires the text to look for"))
+				continue
+			}
+			for _, node := range current.lookup("/").find(argument) {
+				f.UI.Println(node.path())
+			}
+		case "help":
+			f.UI.Println(browseHelp)
+		case "quit", "exit":
+			return nil
+		default:
+			f.UI.Println(color.RedString("Unknown command %s; use help", command))
+		}
+	}
+}
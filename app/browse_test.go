@@ -0,0 +1,135 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrowse(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	input := strings.Join([]string{
+		"ls",
+		"cd /instance-groups/myrole-clustered/jobs",
+		"ls",
+		"show tor",
+		"cd ../../../releases/tor/jobs/tor/properties",
+		"show tor.hostname",
+		"find hashmat",
+		"cd missing",
+		"bogus",
+		"quit",
+		"ls",
+	}, "\n")
+	output := &bytes.Buffer{}
+	ui := termui.New(strings.NewReader(input), output, nil)
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	require.NoError(t, f.LoadManifest())
+
+	require.NoError(t, f.Browse(BrowseOptions{LineMode: true}))
+
+	result := output.String()
+	assert.Contains(t, result, "instance-groups/ (2)")
+	assert.Contains(t, result, "release: tor")
+	assert.Contains(t, result, "default: localhost")
+	assert.Contains(t, result, "/releases/tor/jobs/hashmat")
+	assert.Contains(t, result, "No such entry missing")
+	assert.Contains(t, result, "Unknown command bogus")
+	assert.Equal(t, 1, strings.Count(result, "instance-groups/ (2)"), "Commands after quit must be ignored")
+}
+
+func TestBrowseTreeLookup(t *testing.T) {
+	t.Parallel()
+
+	root := &browseNode{}
+	a := root.add("a", nil)
+	b := a.add("b", "value")
+
+	assert.Equal(t, "/a/b", b.path())
+	assert.Equal(t, b, root.lookup("a/b"))
+	assert.Equal(t, a, b.lookup(".."))
+	assert.Equal(t, root, b.lookup("/"))
+	assert.Equal(t, b, b.lookup("/a/./b"))
+	assert.Nil(t, a.lookup("c"))
+	assert.Equal(t, []*browseNode{a}, root.find("A"))
+	assert.Equal(t, []*browseNode{b}, root.find("b"))
+}
+
+func TestParseBrowseKeys(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"up", "down", "j", "pgdn", "enter", "/", "ä", "esc"},
+		parseBrowseKeys([]byte("\x1b[A\x1bOBj\x1b[6~\r/ä\x1b")))
+}
+
+func TestBrowseTUI(t *testing.T) {
+	t.Parallel()
+
+	root := &browseNode{}
+	releases := root.add("releases", nil)
+	tor := releases.add("tor", map[string]string{"version": "1"})
+	jobs := tor.add("jobs", nil)
+	jobs.add("hashmat", map[string]string{"name": "hashmat"})
+	root.add("instance-groups", nil)
+
+	tui := newBrowseTUI(root)
+	assert.Equal(t, releases, tui.selected)
+
+	lines := tui.render(80, 5)
+	require.Len(t, lines, 5)
+	assert.Contains(t, lines[0], browseReverse+"▸ releases")
+	assert.Contains(t, lines[0], "/releases: 1 entries")
+	assert.Contains(t, lines[1], "  instance-groups")
+	assert.Contains(t, lines[4], "q quit")
+
+	// Expand releases, select tor
+	assert.False(t, tui.handleKey("right", 4))
+	assert.False(t, tui.handleKey("right", 4))
+	assert.Equal(t, tor, tui.selected)
+	lines = tui.render(80, 5)
+	assert.Contains(t, lines[0], "▾ releases")
+	assert.Contains(t, lines[1], browseReverse+"  ▸ tor")
+	assert.Contains(t, lines[0], "version: \"1\"")
+
+	// Collapse, then go to the parent
+	tui.handleKey("left", 4)
+	tui.handleKey("left", 4)
+	assert.Equal(t, releases, tui.selected)
+	tui.handleKey("down", 4)
+	assert.Equal(t, "instance-groups", tui.selected.name)
+
+	// Searching expands the parents of the match
+	for _, key := range []string{"/", "h", "a", "s", "h", "x", "backspace"} {
+		tui.handleKey(key, 4)
+	}
+	assert.Contains(t, tui.render(80, 5)[4], "/hash")
+	tui.handleKey("enter", 4)
+	assert.Equal(t, "/releases/tor/jobs/hashmat", tui.selected.path())
+	assert.True(t, tui.expanded[jobs])
+
+	tui.handleKey("/", 4)
+	tui.handleKey("z", 4)
+	tui.handleKey("enter", 4)
+	assert.Contains(t, tui.render(80, 5)[4], "No entry matching z")
+
+	assert.True(t, tui.handleKey("q", 4))
+}
+
+func TestFitBrowseCell(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "ab  ", fitBrowseCell("ab", 4))
+	assert.Equal(t, "abc…", fitBrowseCell("abcdef", 4))
+	assert.Equal(t, "", fitBrowseCell("abcdef", 0))
+}
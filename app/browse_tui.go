@@ -0,0 +1,365 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh/terminal"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// browseTUIHelp is the status line of the terminal UI of `fissile browse`
+const browseTUIHelp = "↑↓ move  → expand  ← collapse  / search  n next match  q quit"
+
+// Escape sequences of the terminal UI
+const (
+	browseEnterScreen = "\x1b[?1049h\x1b[?25l" // alternate screen, hide cursor
+	browseLeaveScreen = "\x1b[?25h\x1b[?1049l" // show cursor, main screen
+	browseHome        = "\x1b[H"
+	browseClearLine   = "\x1b[K"
+	browseReverse     = "\x1b[7m"
+	browseReset       = "\x1b[0m"
+)
+
+// browseKeys maps the escape sequences of the special keys to their names
+var browseKeys = map[string]string{
+	"\x1b[A":  "up",
+	"\x1b[B":  "down",
+	"\x1b[C":  "right",
+	"\x1b[D":  "left",
+	"\x1bOA":  "up",
+	"\x1bOB":  "down",
+	"\x1bOC":  "right",
+	"\x1bOD":  "left",
+	"\x1b[5~": "pgup",
+	"\x1b[6~": "pgdn",
+	"\x1b[H":  "home",
+	"\x1b[F":  "end",
+	"\r":      "enter",
+	"\n":      "enter",
+	"\x7f":    "backspace",
+	"\x08":    "backspace",
+	"\x03":    "ctrl-c",
+	"\x1b":    "esc",
+}
+
+// parseBrowseKeys splits the input read from a terminal in raw mode into the
+// names of the special keys, or the characters typed
+func parseBrowseKeys(input []byte) []string {
+	var keys []string
+	for len(input) > 0 {
+		matched := ""
+		for sequence := range browseKeys {
+			if len(sequence) > len(matched) && strings.HasPrefix(string(input), sequence) {
+				matched = sequence
+			}
+		}
+		if matched != "" {
+			keys = append(keys, browseKeys[matched])
+			input = input[len(matched):]
+			continue
+		}
+		r, size := utf8.DecodeRune(input)
+		keys = append(keys, string(r))
+		input = input[size:]
+	}
+	return keys
+}
+
+// browseRow is an entry of the tree shown by the terminal UI
+type browseRow struct {
+	node  *browseNode
+	depth int
+}
+
+// browseTUI is the state of the terminal UI of `fissile browse`: a tree of
+// the entries on the left, with the YAML preview of the selected entry on the
+// right, and a status line for the search.
+type browseTUI struct {
+	root     *browseNode
+	expanded map[*browseNode]bool
+	selected *browseNode
+	// offset is the first row of the tree shown
+	offset int
+	// searching is set while the query of a search is typed
+	searching bool
+	query     string
+	status    string
+}
+
+// newBrowseTUI returns the terminal UI for the tree, with the first entry
+// selected
+func newBrowseTUI(root *browseNode) *browseTUI {
+	tui := &browseTUI{root: root, expanded: map[*browseNode]bool{root: true}}
+	if len(root.children) > 0 {
+		tui.selected = root.children[0]
+	}
+	return tui
+}
+
+// rows returns the entries of the tree below expanded entries
+func (t *browseTUI) rows() []browseRow {
+	var rows []browseRow
+	var walk func(node *browseNode, depth int)
+	walk = func(node *browseNode, depth int) {
+		for _, child := range node.children {
+			rows = append(rows, browseRow{node: child, depth: depth})
+			if t.expanded[child] {
+				walk(child, depth+1)
+			}
+		}
+	}
+	walk(t.root, 0)
+	return rows
+}
+
+// selectedIndex returns the row of the selected entry
+func (t *browseTUI) selectedIndex(rows []browseRow) int {
+	for i, row := range rows {
+		if row.node == t.selected {
+			return i
+		}
+	}
+	return 0
+}
+
+// handleKey applies a key to the state; page is the number of rows of the
+// tree shown. It returns true when the browser is left.
+func (t *browseTUI) handleKey(key string, page int) bool {
+	if t.searching {
+		switch key {
+		case "enter":
+			t.searching = false
+			t.nextMatch()
+		case "esc", "ctrl-c":
+			t.searching = false
+			t.query = ""
+		case "backspace":
+			if t.query != "" {
+				_, size := utf8.DecodeLastRuneInString(t.query)
+				t.query = t.query[:len(t.query)-size]
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				t.query += key
+			}
+		}
+		return false
+	}
+
+	t.status = ""
+	rows := t.rows()
+	if len(rows) == 0 {
+		return key == "q" || key == "ctrl-c"
+	}
+	index := t.selectedIndex(rows)
+	move := func(to int) {
+		if to < 0 {
+			to = 0
+		}
+		if to >= len(rows) {
+			to = len(rows) - 1
+		}
+		t.selected = rows[to].node
+	}
+
+	switch key {
+	case "q", "ctrl-c":
+		return true
+	case "up", "k":
+		move(index - 1)
+	case "down", "j":
+		move(index + 1)
+	case "pgup":
+		move(index - page)
+	case "pgdn":
+		move(index + page)
+	case "home":
+		move(0)
+	case "end":
+		move(len(rows) - 1)
+	case "right", "l", "enter":
+		if len(t.selected.children) > 0 {
+			if t.expanded[t.selected] {
+				t.selected = t.selected.children[0]
+			} else {
+				t.expanded[t.selected] = true
+			}
+		}
+	case "left", "h":
+		if t.expanded[t.selected] {
+			delete(t.expanded, t.selected)
+		} else if t.selected.parent != t.root {
+			t.selected = t.selected.parent
+		}
+	case "/":
+		t.searching = true
+		t.query = ""
+	case "n":
+		t.nextMatch()
+	}
+	return false
+}
+
+// nextMatch selects the next entry (in the order of the tree, wrapping
+// around) whose name contains the query, expanding its parents
+func (t *browseTUI) nextMatch() {
+	if t.query == "" {
+		return
+	}
+	matches := t.root.find(t.query)
+	if len(matches) == 0 {
+		t.status = fmt.Sprintf("No entry matching %s", t.query)
+		return
+	}
+	all := t.root.find("")
+	position := map[*browseNode]int{}
+	for i, node := range all {
+		position[node] = i
+	}
+	match := matches[0]
+	for _, candidate := range matches {
+		if position[candidate] > position[t.selected] {
+			match = candidate
+			break
+		}
+	}
+	for parent := match.parent; parent != nil; parent = parent.parent {
+		t.expanded[parent] = true
+	}
+	t.selected = match
+	t.status = match.path()
+}
+
+// preview returns the lines of the preview of the selected entry: its YAML,
+// or the names of the entries below it
+func (t *browseTUI) preview() []string {
+	if t.selected == nil {
+		return nil
+	}
+	if t.selected.value == nil {
+		var names []string
+		for _, child := range t.selected.children {
+			names = append(names, child.name)
+		}
+		sort.Strings(names)
+		return append([]string{fmt.Sprintf("%s: %d entries", t.selected.path(), len(names)), ""}, names...)
+	}
+	buf, err := yaml.Marshal(t.selected.value)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+}
+
+// render returns the lines of the screen of the given size
+func (t *browseTUI) render(width, height int) []string {
+	rows := t.rows()
+	treeHeight := height - 1
+	if treeHeight < 1 {
+		treeHeight = 1
+	}
+	treeWidth := width * 2 / 5
+	if treeWidth < 20 {
+		treeWidth = 20
+	}
+	previewWidth := width - treeWidth - 3
+	if previewWidth < 0 {
+		previewWidth = 0
+	}
+
+	index := t.selectedIndex(rows)
+	if index < t.offset {
+		t.offset = index
+	}
+	if index >= t.offset+treeHeight {
+		t.offset = index - treeHeight + 1
+	}
+
+	preview := t.preview()
+	lines := make([]string, 0, height)
+	for i := 0; i < treeHeight; i++ {
+		var cell string
+		selected := false
+		if row := t.offset + i; row < len(rows) {
+			node := rows[row].node
+			marker := "  "
+			if len(node.children) > 0 {
+				marker = "▸ "
+				if t.expanded[node] {
+					marker = "▾ "
+				}
+			}
+			cell = strings.Repeat("  ", rows[row].depth) + marker + node.name
+			selected = node == t.selected
+		}
+		cell = fitBrowseCell(cell, treeWidth)
+		if selected {
+			cell = browseReverse + cell + browseReset
+		}
+		var previewLine string
+		if i < len(preview) {
+			previewLine = fitBrowseCell(preview[i], previewWidth)
+		}
+		lines = append(lines, strings.TrimRight(cell+" │ "+previewLine, " "))
+	}
+
+	status := browseTUIHelp
+	if t.searching {
+		status = "/" + t.query
+	} else if t.status != "" {
+		status = t.status
+	}
+	return append(lines, fitBrowseCell(status, width))
+}
+
+// fitBrowseCell truncates or pads the text to the given number of characters
+func fitBrowseCell(text string, width int) string {
+	text = strings.Replace(text, "\t", "    ", -1)
+	count := utf8.RuneCountInString(text)
+	if count > width {
+		runes := []rune(text)
+		if width < 1 {
+			return ""
+		}
+		return string(runes[:width-1]) + "…"
+	}
+	return text + strings.Repeat(" ", width-count)
+}
+
+// browseTerminal runs the terminal UI on the standard input and output,
+// which must be terminals
+func browseTerminal(root *browseNode) error {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	state, err := terminal.MakeRaw(in)
+	if err != nil {
+		return fmt.Errorf("Error switching the terminal to raw mode: %v", err)
+	}
+	defer terminal.Restore(in, state)
+	fmt.Fprint(os.Stdout, browseEnterScreen)
+	defer fmt.Fprint(os.Stdout, browseLeaveScreen)
+
+	tui := newBrowseTUI(root)
+	buf := make([]byte, 64)
+	for {
+		width, height, err := terminal.GetSize(out)
+		if err != nil || width < 1 || height < 1 {
+			width, height = 80, 24
+		}
+		lines := tui.render(width, height)
+		fmt.Fprint(os.Stdout, browseHome+strings.Join(lines, browseClearLine+"\r\n")+browseClearLine)
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, key := range parseBrowseKeys(buf[:n]) {
+			if tui.handleKey(key, height-1) {
+				return nil
+			}
+		}
+	}
+}
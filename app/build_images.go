@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"code.cloudfoundry.org/fissile/builder"
 	"code.cloudfoundry.org/fissile/docker"
 	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/oci"
 	"github.com/SUSE/stampy"
 	"github.com/fatih/color"
+	dockerclient "github.com/fsouza/go-dockerclient"
 )
 
 // BuildImagesOptions contains all option values for the `fissile build images` command.
@@ -19,9 +22,14 @@ type BuildImagesOptions struct {
 	Force                    bool
 	Labels                   map[string]string
 	NoBuild                  bool
+	OCILayout                string
+	OCIPush                  bool
+	OCIStemcellLayout        string
 	OutputDirectory          string
+	PackagesLayerCache       string
 	PatchPropertiesDirective string
 	Roles                    []string
+	SplitPackagesLayers      bool
 	Stemcell                 string
 	StemcellID               string
 	TagExtra                 string
@@ -36,7 +44,7 @@ func (f *Fissile) BuildImages(opt BuildImagesOptions) error {
 	if f.Manifest == nil || len(f.Manifest.LoadedReleases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
-	if errs := f.Validate(); len(errs) != 0 {
+	if errs := f.Validate().Failures(); len(errs) != 0 {
 		return errs
 	}
 
@@ -57,67 +65,183 @@ func (f *Fissile) BuildImages(opt BuildImagesOptions) error {
 		defer stampy.Stamp(f.Options.Metrics, "fissile", "create-images", "done")
 	}
 
-	if opt.StemcellID == "" {
-		imageManager, err := docker.NewImageManager()
+	if opt.OCILayout != "" {
+		return f.buildOCIImages(opt)
+	}
+
+	instanceGroups, err := f.Manifest.SelectInstanceGroups(opt.Roles)
+	if err != nil {
+		return err
+	}
+	instanceGroups = instanceGroups.WithoutZoneReplicas()
+
+	// Instance groups with stemcells of their own get packages layers built
+	// on those
+	baseImageNames := map[string]string{}
+	stemcells, stemcellGroups := instanceGroups.GroupByStemcell(opt.Stemcell)
+	for _, stemcell := range stemcells {
+		packagesImageBuilder, err := f.newPackagesImageBuilder(opt, stemcell)
 		if err != nil {
 			return err
 		}
 
-		stemcellImage, err := imageManager.FindImage(opt.Stemcell)
+		packageSets := []model.InstanceGroups{stemcellGroups[stemcell]}
+		if opt.SplitPackagesLayers {
+			packageSets = builder.GroupByPackageSet(stemcellGroups[stemcell])
+			f.UI.Printf("Building %s packages layers\n", color.YellowString("%d", len(packageSets)))
+		}
+
+		for _, packageSet := range packageSets {
+			if opt.OutputDirectory == "" {
+				err = f.buildPackagesImage(opt, packageSet, packagesImageBuilder)
+			} else {
+				err = f.buildPackagesTarball(opt, packageSet, packagesImageBuilder)
+			}
+			if err != nil {
+				return err
+			}
+
+			imageName, err := packagesImageBuilder.GetImageName(f.Manifest, packageSet, f)
+			if err != nil {
+				return err
+			}
+			for _, instanceGroup := range packageSet {
+				baseImageNames[instanceGroup.Name] = imageName
+			}
+		}
+	}
+
+	roleImageBuilder := f.newRoleImageBuilder(opt, "")
+	roleImageBuilder.BaseImageNames = baseImageNames
+	return roleImageBuilder.Build(instanceGroups)
+}
+
+// newPackagesImageBuilder returns the builder of the packages layers on top of
+// the stemcell image, with the packages compiled on it. The stemcell ID of the
+// options applies to the stemcell of the options only; the IDs of the other
+// stemcells are looked up in docker.
+func (f *Fissile) newPackagesImageBuilder(opt BuildImagesOptions, stemcell string) (*builder.PackagesImageBuilder, error) {
+	stemcellID := ""
+	if stemcell == opt.Stemcell {
+		stemcellID = opt.StemcellID
+	}
+	if stemcellID == "" {
+		imageManager, err := docker.NewImageManager()
+		if err != nil {
+			return nil, err
+		}
+
+		stemcellImage, err := imageManager.FindImage(stemcell)
 		if err != nil {
 			if _, ok := err.(docker.ErrImageNotFound); ok {
-				return fmt.Errorf("Stemcell %v", err)
+				return nil, fmt.Errorf("Stemcell %v", err)
 			}
-			return err
+			return nil, err
 		}
 
-		opt.StemcellID = stemcellImage.ID
+		stemcellID = stemcellImage.ID
 	}
 
-	packagesImageBuilder := &builder.PackagesImageBuilder{
+	return &builder.PackagesImageBuilder{
 		RepositoryPrefix:     f.Options.RepositoryPrefix,
-		StemcellImageName:    opt.Stemcell,
-		StemcellImageID:      opt.StemcellID,
-		CompiledPackagesPath: f.StemcellCompilationDir(opt.Stemcell),
+		StemcellImageName:    stemcell,
+		StemcellImageID:      stemcellID,
+		CompiledPackagesPath: f.StemcellCompilationDir(stemcell),
 		FissileVersion:       f.Version,
+	}, nil
+}
+
+// newRoleImageBuilder returns the builder of the role images on top of the
+// packages layer image
+func (f *Fissile) newRoleImageBuilder(opt BuildImagesOptions, imageName string) *builder.RoleImageBuilder {
+	return &builder.RoleImageBuilder{
+		BaseImageName:      imageName,
+		DarkOpinionsPaths:  f.Options.DarkOpinions,
+		DockerOrganization: f.Options.DockerOrganization,
+		DockerRegistry:     f.Options.DockerRegistry,
+		FissileVersion:     f.Version,
+		Force:              opt.Force,
+		Grapher:            f,
+		LightOpinionsPath:  f.Options.LightOpinions,
+		ManifestPath:       f.Manifest.ManifestFilePath,
+		MetricsPath:        f.Options.Metrics,
+		NoBuild:            opt.NoBuild,
+		OutputDirectory:    opt.OutputDirectory,
+		RepositoryPrefix:   f.Options.RepositoryPrefix,
+		TagExtra:           opt.TagExtra,
+		UI:                 f.UI,
+		WorkerCount:        f.Options.Workers,
+	}
+}
+
+// buildOCIImages assembles the role images as OCI images in the layout of the
+// options, without the help of docker. The stemcell image is read from the
+// OCI stemcell layout. This is experimental.
+func (f *Fissile) buildOCIImages(opt BuildImagesOptions) error {
+	if opt.OCIStemcellLayout == "" {
+		return fmt.Errorf("--oci-stemcell-layout is required to assemble OCI images")
 	}
 
 	instanceGroups, err := f.Manifest.SelectInstanceGroups(opt.Roles)
 	if err != nil {
 		return err
 	}
+	instanceGroups = instanceGroups.WithoutZoneReplicas()
 
-	if opt.OutputDirectory == "" {
-		err = f.buildPackagesImage(opt, instanceGroups, packagesImageBuilder)
-	} else {
-		err = f.buildPackagesTarball(opt, instanceGroups, packagesImageBuilder)
+	stemcells, _ := instanceGroups.GroupByStemcell(opt.Stemcell)
+	if len(stemcells) > 1 {
+		return fmt.Errorf("OCI images can only be assembled for instance groups of a single stemcell; select them with --roles")
+	}
+	stemcellName := opt.Stemcell
+	if len(stemcells) == 1 && stemcells[0] != opt.Stemcell {
+		stemcellName = stemcells[0]
+		opt.StemcellID = ""
 	}
+
+	stemcellLayout, err := oci.OpenLayout(opt.OCIStemcellLayout)
 	if err != nil {
 		return err
 	}
+	stemcell, err := stemcellLayout.Image(stemcellName)
+	if err != nil {
+		return fmt.Errorf("Stemcell %v", err)
+	}
+	if opt.StemcellID == "" {
+		opt.StemcellID = stemcell.Descriptor.Digest.String()
+	}
+
+	packagesImageBuilder := &builder.PackagesImageBuilder{
+		RepositoryPrefix:     f.Options.RepositoryPrefix,
+		StemcellImageName:    stemcellName,
+		StemcellImageID:      opt.StemcellID,
+		CompiledPackagesPath: f.StemcellCompilationDir(stemcellName),
+		FissileVersion:       f.Version,
+	}
 
 	imageName, err := packagesImageBuilder.GetImageName(f.Manifest, instanceGroups, f)
 	if err != nil {
 		return err
 	}
+	if opt.NoBuild {
+		f.UI.Println("Skipping OCI image assembly because of --no-build flag.")
+		return nil
+	}
 
-	roleImageBuilder := &builder.RoleImageBuilder{
-		BaseImageName:      imageName,
-		DarkOpinionsPath:   f.Options.DarkOpinions,
-		DockerOrganization: f.Options.DockerOrganization,
-		DockerRegistry:     f.Options.DockerRegistry,
-		FissileVersion:     f.Version,
-		Force:              opt.Force,
-		Grapher:            f,
-		LightOpinionsPath:  f.Options.LightOpinions,
-		ManifestPath:       f.Manifest.ManifestFilePath,
-		MetricsPath:        f.Options.Metrics,
-		NoBuild:            opt.NoBuild,
-		OutputDirectory:    opt.OutputDirectory,
-		RepositoryPrefix:   f.Options.RepositoryPrefix,
-		TagExtra:           opt.TagExtra,
-		UI:                 f.UI,
-		WorkerCount:        f.Options.Workers,
+	layout, err := oci.NewLayout(opt.OCILayout)
+	if err != nil {
+		return fmt.Errorf("Error creating OCI layout %s: %v", opt.OCILayout, err)
+	}
+
+	f.UI.Printf("Assembling OCI packages layer of %s ...\n", color.YellowString(imageName))
+	roleImageBuilder := f.newRoleImageBuilder(opt, imageName)
+	roleImageBuilder.OCIBase, err = packagesImageBuilder.NewOCIBaseImage(layout, stemcellLayout, instanceGroups, opt.Labels)
+	if err != nil {
+		return err
+	}
+	if opt.OCIPush {
+		roleImageBuilder.OCIPush = func(imageName string, image *oci.Image) error {
+			return oci.PushImage(layout, image, imageName, f.Options.DockerUsername, f.Options.DockerPassword, f.httpOptions())
+		}
 	}
 
 	return roleImageBuilder.Build(instanceGroups)
@@ -149,14 +273,25 @@ func (f *Fissile) buildPackagesImage(
 			f.UI.Printf("Packages layer %s already exists. Skipping ...\n", color.YellowString(imageName))
 			return nil
 		}
+
+		if opt.PackagesLayerCache != "" && !opt.NoBuild {
+			cachedName := packagesLayerCacheName(opt.PackagesLayerCache, imageName)
+			err := dockerManager.PullImage(cachedName, f.packagesLayerCacheAuth(opt.PackagesLayerCache))
+			if err == nil {
+				f.UI.Printf("Pulled packages layer %s from the cache\n", color.YellowString(imageName))
+				return dockerManager.TagImage(cachedName, imageName)
+			}
+			f.UI.Printf("Packages layer %s is not cached: %v\n", color.YellowString(imageName), err)
+		}
 	}
 
-	hasImage, err := dockerManager.HasImage(opt.Stemcell)
+	stemcell := packagesImageBuilder.StemcellImageName
+	hasImage, err := dockerManager.HasImage(stemcell)
 	if err != nil {
 		return fmt.Errorf("Error looking up stemcell image %s: %v", imageName, err)
 	}
 	if !hasImage {
-		return fmt.Errorf("Failed to find stemcell image %s. Did you pull it?", opt.Stemcell)
+		return fmt.Errorf("Failed to find stemcell image %s. Did you pull it?", stemcell)
 	}
 
 	if opt.NoBuild {
@@ -176,9 +311,38 @@ func (f *Fissile) buildPackagesImage(
 	}
 	f.UI.Println(color.GreenString("Done."))
 
+	if opt.PackagesLayerCache != "" {
+		cachedName := packagesLayerCacheName(opt.PackagesLayerCache, imageName)
+		f.UI.Printf("Pushing packages layer %s to the cache ...\n", color.YellowString(cachedName))
+		err = dockerManager.TagImage(imageName, cachedName)
+		if err != nil {
+			return err
+		}
+		_, err = dockerManager.PushImage(cachedName, f.packagesLayerCacheAuth(opt.PackagesLayerCache), nil)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// packagesLayerCacheName returns the name of a packages layer image in the
+// cache repository
+func packagesLayerCacheName(cache, imageName string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(cache, "/"), imageName)
+}
+
+// packagesLayerCacheAuth returns the credentials for the registry of the
+// cache repository; they are those of the docker registry
+func (f *Fissile) packagesLayerCacheAuth(cache string) dockerclient.AuthConfiguration {
+	return dockerclient.AuthConfiguration{
+		Username:      f.Options.DockerUsername,
+		Password:      f.Options.DockerPassword,
+		ServerAddress: strings.SplitN(cache, "/", 2)[0],
+	}
+}
+
 // buildPackagesTarball builds a tarball snapshot of the build context
 // for the docker image for the packages layer where all packages are included.
 func (f *Fissile) buildPackagesTarball(
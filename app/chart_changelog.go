@@ -0,0 +1,329 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ChartChangelog describes the differences between two generated helm charts,
+// e.g. for the release notes of a new version. Values keys are dotted paths
+// below .Values.
+type ChartChangelog struct {
+	FromVersion           string        `json:"fromVersion,omitempty" yaml:"fromVersion,omitempty"`
+	ToVersion             string        `json:"toVersion,omitempty" yaml:"toVersion,omitempty"`
+	AddedInstanceGroups   []string      `json:"addedInstanceGroups" yaml:"addedInstanceGroups"`
+	RemovedInstanceGroups []string      `json:"removedInstanceGroups" yaml:"removedInstanceGroups"`
+	ChangedImages         []ImageChange `json:"changedImages" yaml:"changedImages"`
+	AddedValues           []string      `json:"addedValues" yaml:"addedValues"`
+	RemovedValues         []string      `json:"removedValues" yaml:"removedValues"`
+	ChangedDefaults       []ValueChange `json:"changedDefaults" yaml:"changedDefaults"`
+}
+
+// ImageChange describes the changed images of an instance group
+type ImageChange struct {
+	InstanceGroup string   `json:"instanceGroup" yaml:"instanceGroup"`
+	From          []string `json:"from" yaml:"from"`
+	To            []string `json:"to" yaml:"to"`
+}
+
+// ValueChange describes the changed default of a values key
+type ValueChange struct {
+	Key  string      `json:"key" yaml:"key"`
+	From interface{} `json:"from" yaml:"from"`
+	To   interface{} `json:"to" yaml:"to"`
+}
+
+// chartSummary holds the parts of a generated helm chart relevant to the
+// changelog
+type chartSummary struct {
+	version string
+	// images maps the instance groups to the images of their containers
+	images map[string][]string
+	// values maps the dotted values keys to their defaults
+	values map[string]interface{}
+}
+
+// nonRoleTemplates are the templates with containers which are not generated
+// for an instance group
+var nonRoleTemplates = map[string]bool{
+	"image-prepull.yaml":            true,
+	"post-deploy-verification.yaml": true,
+}
+
+var (
+	imageLinePattern = regexp.MustCompile(`^\s*(?:-\s+)?image:\s*(.+?)\s*$`)
+	// imageTemplatePattern matches the registry and organization of the
+	// image names of unrendered charts
+	imageTemplatePattern = regexp.MustCompile(`\{\{[^}]*\}\}/`)
+)
+
+// DiffCharts compares the helm charts generated into two directories and
+// reports the changelog between them in the output format.
+func (f *Fissile) DiffCharts(fromDir, toDir string) error {
+	changelog, err := diffCharts(fromDir, toDir)
+	if err != nil {
+		return err
+	}
+	return f.reportChartChangelog(changelog)
+}
+
+func (f *Fissile) reportChartChangelog(changelog *ChartChangelog) error {
+	switch f.Options.OutputFormat {
+	case OutputFormatHuman:
+		if changelog.FromVersion != "" || changelog.ToVersion != "" {
+			f.UI.Printf("Changes from %s to %s\n", changelog.FromVersion, changelog.ToVersion)
+		}
+		printKeys := func(title string, keys []string) {
+			if len(keys) > 0 {
+				f.UI.Println(title)
+				for _, key := range keys {
+					f.UI.Printf("  %s\n", key)
+				}
+			}
+		}
+		printKeys(color.GreenString("Added instance groups:"), changelog.AddedInstanceGroups)
+		printKeys(color.RedString("Removed instance groups:"), changelog.RemovedInstanceGroups)
+		if len(changelog.ChangedImages) > 0 {
+			f.UI.Println(color.BlueString("Changed images:"))
+			for _, change := range changelog.ChangedImages {
+				f.UI.Printf("  %s:\n    %s\n    %s\n", change.InstanceGroup,
+					strings.Join(change.From, ", "), strings.Join(change.To, ", "))
+			}
+		}
+		printKeys(color.GreenString("Added values:"), changelog.AddedValues)
+		printKeys(color.RedString("Removed values:"), changelog.RemovedValues)
+		if len(changelog.ChangedDefaults) > 0 {
+			f.UI.Println(color.BlueString("Changed defaults:"))
+			for _, change := range changelog.ChangedDefaults {
+				f.UI.Printf("  %s: %v -> %v\n", change.Key, change.From, change.To)
+			}
+		}
+	case OutputFormatJSON:
+		buf, err := json.MarshalIndent(changelog, "", "  ")
+		if err != nil {
+			return err
+		}
+		f.UI.Printf("%s\n", buf)
+	case OutputFormatYAML:
+		buf, err := yaml.Marshal(changelog)
+		if err != nil {
+			return err
+		}
+		f.UI.Printf("%s", buf)
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, or yaml", f.Options.OutputFormat)
+	}
+	return nil
+}
+
+// diffCharts returns the changelog between the charts in the directories
+func diffCharts(fromDir, toDir string) (*ChartChangelog, error) {
+	from, err := readChartSummary(fromDir)
+	if err != nil {
+		return nil, err
+	}
+	to, err := readChartSummary(toDir)
+	if err != nil {
+		return nil, err
+	}
+
+	changelog := &ChartChangelog{
+		FromVersion:           from.version,
+		ToVersion:             to.version,
+		AddedInstanceGroups:   []string{},
+		RemovedInstanceGroups: []string{},
+		ChangedImages:         []ImageChange{},
+		AddedValues:           []string{},
+		RemovedValues:         []string{},
+		ChangedDefaults:       []ValueChange{},
+	}
+
+	for _, name := range sortedKeys(from.images, to.images) {
+		fromImages, inFrom := from.images[name]
+		toImages, inTo := to.images[name]
+		switch {
+		case !inFrom:
+			changelog.AddedInstanceGroups = append(changelog.AddedInstanceGroups, name)
+		case !inTo:
+			changelog.RemovedInstanceGroups = append(changelog.RemovedInstanceGroups, name)
+		case !reflect.DeepEqual(fromImages, toImages):
+			changelog.ChangedImages = append(changelog.ChangedImages, ImageChange{
+				InstanceGroup: name,
+				From:          fromImages,
+				To:            toImages,
+			})
+		}
+	}
+
+	for _, key := range sortedKeys(from.values, to.values) {
+		fromValue, inFrom := from.values[key]
+		toValue, inTo := to.values[key]
+		switch {
+		case !inFrom:
+			changelog.AddedValues = append(changelog.AddedValues, key)
+		case !inTo:
+			changelog.RemovedValues = append(changelog.RemovedValues, key)
+		case !reflect.DeepEqual(fromValue, toValue):
+			changelog.ChangedDefaults = append(changelog.ChangedDefaults, ValueChange{
+				Key:  key,
+				From: fromValue,
+				To:   toValue,
+			})
+		}
+	}
+
+	return changelog, nil
+}
+
+// sortedKeys returns the sorted union of the keys of the maps, which must all
+// have string keys
+func sortedKeys(maps ...interface{}) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range maps {
+		for _, key := range reflect.ValueOf(m).MapKeys() {
+			if !seen[key.String()] {
+				seen[key.String()] = true
+				keys = append(keys, key.String())
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readChartSummary reads the chart version, the images of the instance
+// groups and the values defaults of the chart in the directory
+func readChartSummary(dir string) (*chartSummary, error) {
+	summary := &chartSummary{
+		images: map[string][]string{},
+		values: map[string]interface{}{},
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a chart directory", dir)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if err == nil {
+		var chart struct {
+			Version string `yaml:"version"`
+		}
+		if err := yaml.Unmarshal(contents, &chart); err != nil {
+			return nil, fmt.Errorf("Error parsing %s: %v", filepath.Join(dir, "Chart.yaml"), err)
+		}
+		summary.version = chart.Version
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	valuesPath := filepath.Join(dir, "values.yaml")
+	contents, err = ioutil.ReadFile(valuesPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading values file %s: %v", valuesPath, err)
+	}
+	var values map[interface{}]interface{}
+	if err := yaml.Unmarshal(contents, &values); err != nil {
+		return nil, fmt.Errorf("Error parsing values file %s: %v", valuesPath, err)
+	}
+	flattenValues("", values, summary.values)
+
+	templates, err := filepath.Glob(filepath.Join(dir, "templates", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, template := range templates {
+		name := filepath.Base(template)
+		if nonRoleTemplates[name] || strings.HasPrefix(name, "_") {
+			continue
+		}
+		images, err := readTemplateImages(template)
+		if err != nil {
+			return nil, err
+		}
+		if len(images) > 0 {
+			summary.images[strings.TrimSuffix(name, ".yaml")] = images
+		}
+	}
+
+	return summary, nil
+}
+
+// readTemplateImages returns the sorted images of the containers in the
+// template, without the templated registry and organization
+func readTemplateImages(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	seen := map[string]bool{}
+	var images []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		match := imageLinePattern.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		image := imageTemplatePattern.ReplaceAllString(strings.Trim(match[1], `"'`), "")
+		if !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading template %s: %v", path, err)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// flattenValues adds the leaves of the values map to the flattened map,
+// keyed by their dotted paths. Lists and empty maps are leaves.
+func flattenValues(prefix string, values map[interface{}]interface{}, flattened map[string]interface{}) {
+	for key, value := range values {
+		path := fmt.Sprintf("%s%v", prefix, key)
+		if mapping, ok := value.(map[interface{}]interface{}); ok && len(mapping) > 0 {
+			flattenValues(path+".", mapping, flattened)
+			continue
+		}
+		flattened[path] = stringKeyedValue(value)
+	}
+}
+
+// stringKeyedValue converts the maps in the value to have string keys, so
+// that the changelog can be written as JSON
+func stringKeyedValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, element := range value {
+			result[fmt.Sprintf("%v", key)] = stringKeyedValue(element)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, element := range value {
+			result[i] = stringKeyedValue(element)
+		}
+		return result
+	default:
+		return value
+	}
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestChart(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	}
+}
+
+func TestDiffCharts(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	workDir, err := ioutil.TempDir("", "fissile-chart-changelog-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	fromDir := filepath.Join(workDir, "from")
+	writeTestChart(t, fromDir, map[string]string{
+		"Chart.yaml": "name: scf\nversion: 1.0.0\n",
+		"values.yaml": `
+env:
+  # The user
+  NATS_USER: admin
+  DOMAIN: ~
+  REMOVED: gone
+sizing:
+  nats:
+    count: 1
+    capabilities: []
+`,
+		"templates/nats.yaml": `
+spec:
+  template:
+    spec:
+      containers:
+      - image: "{{ .Values.kube.registry.hostname }}/{{ .Values.kube.organization }}/fissile-nats:abc"
+        name: "nats"
+`,
+		"templates/api.yaml": `
+containers:
+- image: "{{ .Values.kube.registry.hostname }}/{{ .Values.kube.organization }}/fissile-api:123"
+`,
+		"templates/image-prepull.yaml": `
+- image: "{{ .Values.kube.registry.hostname }}/{{ .Values.kube.organization }}/fissile-nats:abc"
+`,
+		"templates/secrets.yaml": "kind: Secret\n",
+	})
+
+	toDir := filepath.Join(workDir, "to")
+	writeTestChart(t, toDir, map[string]string{
+		"Chart.yaml": "name: scf\nversion: 1.1.0\n",
+		"values.yaml": `
+env:
+  NATS_USER: nats
+  DOMAIN: ~
+  NEW_KEY: true
+sizing:
+  nats:
+    count: 1
+    capabilities: [NET_ADMIN]
+`,
+		"templates/nats.yaml": `
+containers:
+- image: "{{ .Values.kube.registry.hostname }}/{{ .Values.kube.organization }}/fissile-nats:def"
+`,
+		"templates/router.yaml": `
+containers:
+- image: "{{ .Values.kube.registry.hostname }}/{{ .Values.kube.organization }}/fissile-router:456"
+`,
+		"templates/image-prepull.yaml": `
+- image: "{{ .Values.kube.registry.hostname }}/{{ .Values.kube.organization }}/fissile-nats:def"
+`,
+	})
+
+	changelog, err := diffCharts(fromDir, toDir)
+	require.NoError(t, err)
+	assert.Equal("1.0.0", changelog.FromVersion)
+	assert.Equal("1.1.0", changelog.ToVersion)
+	assert.Equal([]string{"router"}, changelog.AddedInstanceGroups)
+	assert.Equal([]string{"api"}, changelog.RemovedInstanceGroups)
+	assert.Equal([]ImageChange{
+		{InstanceGroup: "nats", From: []string{"fissile-nats:abc"}, To: []string{"fissile-nats:def"}},
+	}, changelog.ChangedImages)
+	assert.Equal([]string{"env.NEW_KEY"}, changelog.AddedValues)
+	assert.Equal([]string{"env.REMOVED"}, changelog.RemovedValues)
+	assert.Equal([]ValueChange{
+		{Key: "env.NATS_USER", From: "admin", To: "nats"},
+		{Key: "sizing.nats.capabilities", From: []interface{}{}, To: []interface{}{"NET_ADMIN"}},
+	}, changelog.ChangedDefaults)
+
+	out := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, out, nil))
+	f.Options.OutputFormat = OutputFormatJSON
+	require.NoError(t, f.DiffCharts(fromDir, toDir))
+	var decoded ChartChangelog
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(changelog.AddedValues, decoded.AddedValues)
+
+	_, err = diffCharts(fromDir, filepath.Join(workDir, "missing"))
+	assert.Error(err)
+}
@@ -0,0 +1,510 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/fatih/color"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ClusterCheckStatus is the outcome of a single cluster compatibility check
+type ClusterCheckStatus string
+
+// These are the possible outcomes of a cluster compatibility check
+const (
+	ClusterCheckPass = ClusterCheckStatus("pass")
+	ClusterCheckWarn = ClusterCheckStatus("warn")
+	ClusterCheckFail = ClusterCheckStatus("fail")
+)
+
+// ClusterCheck is a single entry of the cluster compatibility report
+type ClusterCheck struct {
+	Name    string             `json:"name" yaml:"name"`
+	Status  ClusterCheckStatus `json:"status" yaml:"status"`
+	Message string             `json:"message" yaml:"message"`
+}
+
+// ClusterInfo describes the parts of a Kubernetes cluster that are relevant
+// to installing the helm chart.
+type ClusterInfo struct {
+	APIVersions    []string
+	StorageClasses []string
+	// NamespaceExists is false if the target namespace has not been created yet
+	NamespaceExists bool
+	// PodSecurityLevel is the pod security admission level enforced on the
+	// target namespace, if any
+	PodSecurityLevel string
+	// AllocatableCPU is the sum of allocatable CPU of all nodes, in millicores
+	AllocatableCPU float64
+	// AllocatableMemory is the sum of allocatable memory of all nodes, in MiB
+	AllocatableMemory float64
+}
+
+// podSecurityEnforceLabel is the namespace label selecting the enforced
+// pod security admission level
+const podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+
+// baselineCapabilities are the capabilities that may be added to containers
+// under the baseline pod security standard
+var baselineCapabilities = map[string]bool{
+	"AUDIT_WRITE": true, "CHOWN": true, "DAC_OVERRIDE": true, "FOWNER": true,
+	"FSETID": true, "KILL": true, "MKNOD": true, "NET_BIND_SERVICE": true,
+	"SETFCAP": true, "SETGID": true, "SETPCAP": true, "SETUID": true, "SYS_CHROOT": true,
+}
+
+// CheckCluster compares the requirements of the loaded role manifest with the
+// cluster selected by kubeconfig (the kubectl default if empty), and prints a
+// pass/warn/fail report. An optional helm values file supplies the storage
+// class, pod security policy, and instance count settings. It returns an
+// error if any check fails.
+func (f *Fissile) CheckCluster(kubeconfig, namespace, valuesPath string) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+
+	values := map[string]interface{}{}
+	if valuesPath != "" {
+		contents, err := ioutil.ReadFile(valuesPath)
+		if err != nil {
+			return fmt.Errorf("Error reading values file %s: %v", valuesPath, err)
+		}
+		err = yaml.Unmarshal(contents, &values)
+		if err != nil {
+			return fmt.Errorf("Error parsing values file %s: %v", valuesPath, err)
+		}
+	}
+
+	info, err := LoadClusterInfo(kubeconfig, namespace)
+	if err != nil {
+		return err
+	}
+
+	checks := checkCluster(f.Manifest, info, values)
+	err = f.reportClusterChecks(checks)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, check := range checks {
+		if check.Status == ClusterCheckFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d cluster checks failed", failed)
+	}
+	return nil
+}
+
+func (f *Fissile) reportClusterChecks(checks []ClusterCheck) error {
+	switch f.Options.OutputFormat {
+	case OutputFormatHuman:
+		for _, check := range checks {
+			var status string
+			switch check.Status {
+			case ClusterCheckPass:
+				status = color.GreenString("[PASS]")
+			case ClusterCheckWarn:
+				status = color.YellowString("[WARN]")
+			default:
+				status = color.RedString("[FAIL]")
+			}
+			f.UI.Printf("%s %s: %s\n", status, check.Name, check.Message)
+		}
+	case OutputFormatJSON:
+		buf, err := json.MarshalIndent(checks, "", "  ")
+		if err != nil {
+			return err
+		}
+		f.UI.Printf("%s\n", buf)
+	case OutputFormatYAML:
+		buf, err := yaml.Marshal(checks)
+		if err != nil {
+			return err
+		}
+		f.UI.Printf("%s", buf)
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, or yaml", f.Options.OutputFormat)
+	}
+	return nil
+}
+
+// LoadClusterInfo queries the cluster via kubectl.
+func LoadClusterInfo(kubeconfig, namespace string) (*ClusterInfo, error) {
+	kubectl := func(args ...string) ([]byte, error) {
+		if kubeconfig != "" {
+			args = append([]string{"--kubeconfig", kubeconfig}, args...)
+		}
+		var stderr bytes.Buffer
+		cmd := exec.Command("kubectl", args...)
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("Error running kubectl %s: %v: %s",
+				strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+		}
+		return output, nil
+	}
+
+	type objectList struct {
+		Items []struct {
+			Metadata struct {
+				Name   string            `json:"name"`
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+			Status struct {
+				Allocatable map[string]string `json:"allocatable"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	getList := func(args ...string) (*objectList, error) {
+		output, err := kubectl(append([]string{"get", "--output", "json"}, args...)...)
+		if err != nil {
+			return nil, err
+		}
+		var list objectList
+		err = json.Unmarshal(output, &list)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing kubectl output: %v", err)
+		}
+		return &list, nil
+	}
+
+	info := &ClusterInfo{}
+
+	output, err := kubectl("api-versions")
+	if err != nil {
+		return nil, err
+	}
+	info.APIVersions = strings.Fields(string(output))
+
+	storageClasses, err := getList("storageclasses")
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range storageClasses.Items {
+		info.StorageClasses = append(info.StorageClasses, item.Metadata.Name)
+	}
+
+	namespaces, err := getList("namespaces", "--field-selector", "metadata.name="+namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range namespaces.Items {
+		info.NamespaceExists = true
+		info.PodSecurityLevel = item.Metadata.Labels[podSecurityEnforceLabel]
+	}
+
+	nodes, err := getList("nodes")
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range nodes.Items {
+		cpu, err := parseQuantity(item.Status.Allocatable["cpu"])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid allocatable cpu for node %s: %v", item.Metadata.Name, err)
+		}
+		memory, err := parseQuantity(item.Status.Allocatable["memory"])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid allocatable memory for node %s: %v", item.Metadata.Name, err)
+		}
+		info.AllocatableCPU += cpu * 1000
+		info.AllocatableMemory += memory / (1024 * 1024)
+	}
+
+	return info, nil
+}
+
+var quantityPattern = regexp.MustCompile(`^([0-9.eE+-]+?)(m|k|M|G|T|P|E|Ki|Mi|Gi|Ti|Pi|Ei)?$`)
+
+// parseQuantity converts a Kubernetes resource quantity (e.g. "3500m" or
+// "16Gi") into base units. An empty quantity is zero.
+func parseQuantity(quantity string) (float64, error) {
+	if quantity == "" {
+		return 0, nil
+	}
+	match := quantityPattern.FindStringSubmatch(quantity)
+	if match == nil {
+		return 0, fmt.Errorf("Invalid quantity %q", quantity)
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid quantity %q: %v", quantity, err)
+	}
+	multipliers := map[string]float64{
+		"":   1,
+		"m":  1e-3,
+		"k":  1e3,
+		"M":  1e6,
+		"G":  1e9,
+		"T":  1e12,
+		"P":  1e15,
+		"E":  1e18,
+		"Ki": 1 << 10,
+		"Mi": 1 << 20,
+		"Gi": 1 << 30,
+		"Ti": 1 << 40,
+		"Pi": 1 << 50,
+		"Ei": 1 << 60,
+	}
+	return value * multipliers[match[2]], nil
+}
+
+// checkCluster runs all compatibility checks of the role manifest against the
+// cluster information.
+func checkCluster(roleManifest *model.RoleManifest, info *ClusterInfo, values map[string]interface{}) []ClusterCheck {
+	var checks []ClusterCheck
+	checks = append(checks, checkClusterAPIVersions(roleManifest, info, values)...)
+	checks = append(checks, checkClusterStorageClasses(roleManifest, info, values)...)
+	checks = append(checks, checkClusterPodSecurity(roleManifest, info))
+	checks = append(checks, checkClusterResources(roleManifest, info, values)...)
+	return checks
+}
+
+// lookupValue returns the value at the given path in the helm values, or nil
+func lookupValue(values map[string]interface{}, path ...string) interface{} {
+	var current interface{} = values
+	for _, key := range path {
+		switch mapping := current.(type) {
+		case map[string]interface{}:
+			current = mapping[key]
+		case map[interface{}]interface{}:
+			current = mapping[key]
+		default:
+			return nil
+		}
+	}
+	return current
+}
+
+func checkClusterAPIVersions(roleManifest *model.RoleManifest, info *ClusterInfo, values map[string]interface{}) []ClusterCheck {
+	available := map[string]bool{}
+	for _, apiVersion := range info.APIVersions {
+		available[apiVersion] = true
+	}
+
+	type requirement struct {
+		apiVersion string
+		fallback   string
+		reason     string
+		optional   bool
+	}
+	requirements := []requirement{
+		{"apps/v1", "apps/v1beta1", "stateful sets and deployments", false},
+		{"rbac.authorization.k8s.io/v1", "", "RBAC roles; they are skipped without it", true},
+	}
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		if instanceGroup.Type == model.RoleTypeBoshTask {
+			requirements = append(requirements, requirement{"batch/v1", "", "jobs", false})
+			break
+		}
+	}
+	for name := range roleManifest.Configuration.Authorization.PodSecurityPolicies {
+		if lookupValue(values, "kube", "psp", name) == nil {
+			requirements = append(requirements, requirement{"policy/v1beta1", "extensions/v1beta1", "pod security policies", false})
+			break
+		}
+	}
+
+	var checks []ClusterCheck
+	for _, req := range requirements {
+		check := ClusterCheck{Name: "API version " + req.apiVersion}
+		switch {
+		case available[req.apiVersion]:
+			check.Status = ClusterCheckPass
+			check.Message = fmt.Sprintf("Available for %s", req.reason)
+		case req.fallback != "" && available[req.fallback]:
+			check.Status = ClusterCheckWarn
+			check.Message = fmt.Sprintf("Not available; falling back to %s for %s", req.fallback, req.reason)
+		case req.optional:
+			check.Status = ClusterCheckWarn
+			check.Message = fmt.Sprintf("Not available for %s", req.reason)
+		default:
+			check.Status = ClusterCheckFail
+			check.Message = fmt.Sprintf("Not available, but required for %s", req.reason)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func checkClusterStorageClasses(roleManifest *model.RoleManifest, info *ClusterInfo, values map[string]interface{}) []ClusterCheck {
+	available := map[string]bool{}
+	for _, storageClass := range info.StorageClasses {
+		available[storageClass] = true
+	}
+
+	// Storage classes used by persistent and shared volumes, defaulting to
+	// the values from MakeBasicValues
+	used := map[string]string{}
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		if instanceGroup.Run == nil {
+			continue
+		}
+		for _, volume := range instanceGroup.Run.Volumes {
+			switch volume.Type {
+			case model.VolumeTypePersistent, model.VolumeTypeShared:
+				key := string(volume.Type)
+				used[key] = key
+				if storageClass, ok := lookupValue(values, "kube", "storage_class", key).(string); ok {
+					used[key] = storageClass
+				}
+			}
+		}
+	}
+
+	var keys []string
+	for key := range used {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var checks []ClusterCheck
+	for _, key := range keys {
+		check := ClusterCheck{Name: fmt.Sprintf("Storage class %s", used[key])}
+		if available[used[key]] {
+			check.Status = ClusterCheckPass
+			check.Message = fmt.Sprintf("Available for %s volumes", key)
+		} else {
+			check.Status = ClusterCheckFail
+			check.Message = fmt.Sprintf("Not found; set kube.storage_class.%s to one of: %s",
+				key, strings.Join(info.StorageClasses, ", "))
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func checkClusterPodSecurity(roleManifest *model.RoleManifest, info *ClusterInfo) ClusterCheck {
+	check := ClusterCheck{Name: "Pod security"}
+
+	var privileged, capabilities, sysctls []string
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		if instanceGroup.Run == nil {
+			continue
+		}
+		if instanceGroup.Run.Privileged {
+			privileged = append(privileged, instanceGroup.Name)
+			continue
+		}
+		hasCapabilities := false
+		for _, capability := range instanceGroup.Run.Capabilities {
+			if !baselineCapabilities[capability] {
+				capabilities = append(capabilities, instanceGroup.Name)
+				hasCapabilities = true
+				break
+			}
+		}
+		// The baseline level only allows the safe sysctls
+		if !hasCapabilities && !instanceGroup.IsColocated() && len(instanceGroup.UnsafeSysctls()) > 0 {
+			sysctls = append(sysctls, instanceGroup.Name)
+		}
+	}
+
+	switch info.PodSecurityLevel {
+	case "", "privileged":
+		check.Status = ClusterCheckPass
+		if info.NamespaceExists {
+			check.Message = "No restrictions enforced on the namespace"
+		} else {
+			check.Message = "The namespace does not exist yet"
+		}
+	case "baseline":
+		rejected := append(append(privileged, capabilities...), sysctls...)
+		if len(rejected) > 0 {
+			sort.Strings(rejected)
+			check.Status = ClusterCheckFail
+			check.Message = fmt.Sprintf("The baseline level enforced on the namespace rejects instance groups %s",
+				strings.Join(rejected, ", "))
+		} else {
+			check.Status = ClusterCheckPass
+			check.Message = "All instance groups satisfy the baseline level enforced on the namespace"
+		}
+	default:
+		check.Status = ClusterCheckFail
+		check.Message = fmt.Sprintf("The %s level enforced on the namespace rejects containers running as root",
+			info.PodSecurityLevel)
+	}
+	return check
+}
+
+// instanceCount returns the number of instances of the instance group
+// deployed by helm with the values: the count of its sizing values, or the
+// default count of the role manifest (for high availability if config.HA is
+// set)
+func instanceCount(instanceGroup *model.InstanceGroup, values map[string]interface{}) int {
+	sizing := strings.Replace(instanceGroup.Name, "-", "_", -1)
+	if count, ok := lookupValue(values, "sizing", sizing, "count").(int); ok {
+		return count
+	}
+	if ha, ok := lookupValue(values, "config", "HA").(bool); ok && ha {
+		return instanceGroup.Run.Scaling.HA
+	}
+	return instanceGroup.Run.Scaling.Min
+}
+
+// checkClusterResources compares the resources requested by the instances
+// deployed with the values with the allocatable resources of the cluster; the
+// messages also report the resources requested at the maximum scale.
+func checkClusterResources(roleManifest *model.RoleManifest, info *ClusterInfo, values map[string]interface{}) []ClusterCheck {
+	var cpu, memory, maxCPU, maxMemory float64
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		if instanceGroup.Run == nil || instanceGroup.IsColocated() || instanceGroup.Type == model.RoleTypeBoshTask {
+			continue
+		}
+		count, maxCount := 1, 1
+		if instanceGroup.Run.Scaling != nil {
+			count = instanceCount(instanceGroup, values)
+			maxCount = instanceGroup.Run.Scaling.Max
+			if count > maxCount {
+				maxCount = count
+			}
+		}
+		for _, container := range append([]*model.InstanceGroup{instanceGroup}, instanceGroup.GetColocatedRoles()...) {
+			if container.Run == nil {
+				continue
+			}
+			if container.Run.Memory != nil && container.Run.Memory.Request != nil {
+				memory += float64(count) * float64(*container.Run.Memory.Request)
+				maxMemory += float64(maxCount) * float64(*container.Run.Memory.Request)
+			}
+			if container.Run.CPU != nil && container.Run.CPU.Request != nil {
+				cpu += float64(count) * 1000 * *container.Run.CPU.Request
+				maxCPU += float64(maxCount) * 1000 * *container.Run.CPU.Request
+			}
+		}
+	}
+
+	check := func(name, unit string, requested, maxRequested, allocatable float64) ClusterCheck {
+		result := ClusterCheck{
+			Name: name + " requests",
+			Message: fmt.Sprintf("%.0f%s requested (%.0f%s at maximum scale) of %.0f%s allocatable",
+				requested, unit, maxRequested, unit, allocatable, unit),
+		}
+		switch {
+		case requested > allocatable:
+			result.Status = ClusterCheckFail
+		case requested > 0.8*allocatable:
+			result.Status = ClusterCheckWarn
+		default:
+			result.Status = ClusterCheckPass
+		}
+		return result
+	}
+
+	return []ClusterCheck{
+		check("CPU", "m", cpu, maxCPU, info.AllocatableCPU),
+		check("Memory", "Mi", memory, maxMemory, info.AllocatableMemory),
+	}
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checkClusterTestManifest() *model.RoleManifest {
+	memory := int64(1024)
+	cpu := 0.5
+	return &model.RoleManifest{
+		InstanceGroups: model.InstanceGroups{
+			&model.InstanceGroup{
+				Name: "database",
+				Type: model.RoleTypeBosh,
+				Run: &model.RoleRun{
+					Scaling:      &model.RoleRunScaling{Min: 2, Max: 3},
+					Capabilities: []string{"SYS_RESOURCE"},
+					Memory:       &model.RoleRunMemory{Request: &memory},
+					CPU:          &model.RoleRunCPU{Request: &cpu},
+					Volumes: []*model.RoleRunVolume{
+						&model.RoleRunVolume{Type: model.VolumeTypePersistent, Tag: "data"},
+					},
+				},
+			},
+		},
+		Configuration: &model.Configuration{
+			Authorization: model.ConfigurationAuthorization{
+				PodSecurityPolicies: map[string]*model.PodSecurityPolicy{
+					"privileged": &model.PodSecurityPolicy{},
+				},
+			},
+		},
+	}
+}
+
+func checkClusterStatuses(checks []ClusterCheck) map[string]ClusterCheckStatus {
+	statuses := map[string]ClusterCheckStatus{}
+	for _, check := range checks {
+		statuses[check.Name] = check.Status
+	}
+	return statuses
+}
+
+func TestCheckClusterPass(t *testing.T) {
+	t.Parallel()
+
+	info := &ClusterInfo{
+		APIVersions:       []string{"apps/v1", "policy/v1beta1", "rbac.authorization.k8s.io/v1", "v1"},
+		StorageClasses:    []string{"fast"},
+		NamespaceExists:   true,
+		AllocatableCPU:    4000,
+		AllocatableMemory: 8192,
+	}
+	values := map[string]interface{}{
+		"kube": map[interface{}]interface{}{
+			"storage_class": map[interface{}]interface{}{"persistent": "fast"},
+		},
+	}
+
+	assert.Equal(t, map[string]ClusterCheckStatus{
+		"API version apps/v1":                      ClusterCheckPass,
+		"API version rbac.authorization.k8s.io/v1": ClusterCheckPass,
+		"API version policy/v1beta1":               ClusterCheckPass,
+		"Storage class fast":                       ClusterCheckPass,
+		"Pod security":                             ClusterCheckPass,
+		"CPU requests":                             ClusterCheckPass,
+		"Memory requests":                          ClusterCheckPass,
+	}, checkClusterStatuses(checkCluster(checkClusterTestManifest(), info, values)))
+}
+
+func TestCheckClusterFail(t *testing.T) {
+	t.Parallel()
+
+	info := &ClusterInfo{
+		APIVersions:       []string{"apps/v1beta1", "v1"},
+		StorageClasses:    []string{"fast"},
+		NamespaceExists:   true,
+		PodSecurityLevel:  "baseline",
+		AllocatableCPU:    1100,
+		AllocatableMemory: 1024,
+	}
+
+	checks := checkCluster(checkClusterTestManifest(), info, nil)
+	assert.Equal(t, map[string]ClusterCheckStatus{
+		"API version apps/v1":                      ClusterCheckWarn,
+		"API version rbac.authorization.k8s.io/v1": ClusterCheckWarn,
+		"API version policy/v1beta1":               ClusterCheckFail,
+		"Storage class persistent":                 ClusterCheckFail,
+		"Pod security":                             ClusterCheckFail,
+		"CPU requests":                             ClusterCheckWarn,
+		"Memory requests":                          ClusterCheckFail,
+	}, checkClusterStatuses(checks))
+
+	for _, check := range checks {
+		if check.Name == "Memory requests" {
+			assert.Equal(t, "2048Mi requested (3072Mi at maximum scale) of 1024Mi allocatable", check.Message)
+		}
+	}
+}
+
+func TestCheckClusterResourcesCount(t *testing.T) {
+	t.Parallel()
+
+	roleManifest := checkClusterTestManifest()
+	roleManifest.InstanceGroups[0].Run.Scaling.HA = 3
+	info := &ClusterInfo{AllocatableCPU: 4000, AllocatableMemory: 8192}
+
+	for _, sample := range []struct {
+		desc    string
+		values  map[string]interface{}
+		message string
+	}{
+		{"default count", nil, "2048Mi requested (3072Mi at maximum scale) of 8192Mi allocatable"},
+		{"HA count", map[string]interface{}{
+			"config": map[interface{}]interface{}{"HA": true},
+		}, "3072Mi requested (3072Mi at maximum scale) of 8192Mi allocatable"},
+		{"count of the values", map[string]interface{}{
+			"sizing": map[interface{}]interface{}{"database": map[interface{}]interface{}{"count": 1}},
+		}, "1024Mi requested (3072Mi at maximum scale) of 8192Mi allocatable"},
+	} {
+		checks := checkClusterResources(roleManifest, info, sample.values)
+		require.Len(t, checks, 2)
+		assert.Equal(t, sample.message, checks[1].Message, sample.desc)
+	}
+}
+
+func TestCheckClusterPodSecuritySysctls(t *testing.T) {
+	t.Parallel()
+
+	roleManifest := checkClusterTestManifest()
+	roleManifest.InstanceGroups[0].Run.Capabilities = nil
+	info := &ClusterInfo{PodSecurityLevel: "baseline"}
+
+	check := checkClusterPodSecurity(roleManifest, info)
+	assert.Equal(t, ClusterCheckPass, check.Status)
+
+	roleManifest.InstanceGroups[0].Run.Sysctls = []*model.RoleRunSysctl{{Name: "net.ipv4.tcp_syncookies", Value: "1"}}
+	check = checkClusterPodSecurity(roleManifest, info)
+	assert.Equal(t, ClusterCheckPass, check.Status)
+
+	roleManifest.InstanceGroups[0].Run.Sysctls = []*model.RoleRunSysctl{{Name: "net.core.somaxconn", Value: "1024"}}
+	check = checkClusterPodSecurity(roleManifest, info)
+	assert.Equal(t, ClusterCheckFail, check.Status)
+	assert.Equal(t, "The baseline level enforced on the namespace rejects instance groups database", check.Message)
+}
+
+func TestCheckClusterPSPOverride(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]interface{}{
+		"kube": map[interface{}]interface{}{
+			"psp": map[interface{}]interface{}{"privileged": "existing-psp"},
+		},
+	}
+	statuses := checkClusterStatuses(checkCluster(checkClusterTestManifest(), &ClusterInfo{}, values))
+	assert.NotContains(t, statuses, "API version policy/v1beta1")
+}
+
+func TestParseQuantity(t *testing.T) {
+	t.Parallel()
+
+	for quantity, expected := range map[string]float64{
+		"":           0,
+		"4":          4,
+		"3500m":      3.5,
+		"16Gi":       16 * 1024 * 1024 * 1024,
+		"16374584Ki": 16374584 * 1024,
+		"1G":         1e9,
+		"1e3":        1000,
+	} {
+		actual, err := parseQuantity(quantity)
+		require.NoError(t, err, quantity)
+		assert.Equal(t, expected, actual, quantity)
+	}
+
+	_, err := parseQuantity("lots")
+	assert.Error(t, err)
+}
@@ -0,0 +1,220 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/builder"
+	"code.cloudfoundry.org/fissile/docker"
+	"github.com/fatih/color"
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// roleImageLabel is set on all role images built by fissile
+	roleImageLabel = "instance_group"
+	// fissileVersionLabel is set on the packages layer images built by
+	// fissile, and inherited by the role images
+	fissileVersionLabel = "version.generator.fissile"
+	// packagesImageLabel is set on the role images to the name of their
+	// packages layer image
+	packagesImageLabel = "packages_image"
+	// compilationContainerSuffix ends the names of all package compilation
+	// containers
+	compilationContainerSuffix = "-gkp"
+)
+
+// DockerPruneOptions contains all option values for the `fissile docker prune` command.
+type DockerPruneOptions struct {
+	DryRun bool
+	// Keep is the number of most recent images to keep per repository
+	Keep     int
+	TagExtra string
+}
+
+// dockerPruner is the interface to shim around docker.ImageManager for the unit test
+type dockerPruner interface {
+	ListContainers(dockerclient.ListContainersOptions) ([]dockerclient.APIContainers, error)
+	ListImages(dockerclient.ListImagesOptions) ([]dockerclient.APIImages, error)
+	ListVolumes() ([]dockerclient.Volume, error)
+	RemoveContainer(string) error
+	RemoveImage(string) error
+	RemoveVolume(string) error
+}
+
+var (
+	// newDockerPruner is a stub to be replaced by the unit test
+	newDockerPruner = func() (dockerPruner, error) { return docker.NewImageManager() }
+)
+
+// DockerPrune removes old images built by fissile from the docker daemon,
+// keeping the opt.Keep most recent images of each repository as well as the
+// role images referenced by the current role manifest, and the packages
+// layers of the role images kept. Stopped package
+// compilation containers and their volumes are removed as well.
+func (f *Fissile) DockerPrune(opt DockerPruneOptions) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+	if opt.Keep < 0 {
+		return fmt.Errorf("Invalid number of images to keep %d", opt.Keep)
+	}
+
+	opinions, err := f.LoadOpinions()
+	if err != nil {
+		return err
+	}
+	current := map[string]bool{}
+	for _, instanceGroup := range f.Manifest.InstanceGroups {
+		devVersion, err := instanceGroup.GetRoleDevVersion(opinions, opt.TagExtra, f.Version, f)
+		if err != nil {
+			return err
+		}
+		current[builder.GetRoleDevImageName(f.Options.DockerRegistry, f.Options.DockerOrganization,
+			f.Options.RepositoryPrefix, instanceGroup, devVersion)] = true
+	}
+
+	pruner, err := newDockerPruner()
+	if err != nil {
+		return fmt.Errorf("Error connecting to docker: %v", err)
+	}
+
+	remove := func(kind, name string, removeFunc func(string) error) {
+		if opt.DryRun {
+			f.UI.Printf("Would remove %s %s\n", kind, color.YellowString(name))
+			return
+		}
+		f.UI.Printf("Removing %s %s\n", kind, color.YellowString(name))
+		if err := removeFunc(name); err != nil {
+			f.UI.Println(color.RedString("Failed to remove %s %s: %v", kind, name, err))
+		}
+	}
+
+	images, err := pruner.ListImages(dockerclient.ListImagesOptions{All: false})
+	if err != nil {
+		return err
+	}
+	for _, image := range pruneImages(images, current, opt.Keep) {
+		remove("image", image, pruner.RemoveImage)
+	}
+
+	containers, err := pruner.ListContainers(dockerclient.ListContainersOptions{All: true})
+	if err != nil {
+		return err
+	}
+	volumes, err := pruner.ListVolumes()
+	if err != nil {
+		return err
+	}
+	prunedContainers, prunedVolumes := pruneCompilation(containers, volumes)
+	for _, container := range prunedContainers {
+		remove("container", container, pruner.RemoveContainer)
+	}
+	for _, volume := range prunedVolumes {
+		remove("volume", volume, pruner.RemoveVolume)
+	}
+
+	return nil
+}
+
+// pruneImages returns the names of the fissile images to remove; these are
+// all but the keep most recent tags in each repository, skipping the images
+// in current and the packages layers of the role images kept. Untagged
+// fissile images are always removed, by ID.
+func pruneImages(images []dockerclient.APIImages, current map[string]bool, keep int) []string {
+	type taggedImage struct {
+		name     string
+		created  int64
+		packages string
+	}
+	repositories := map[string][]taggedImage{}
+	var pruned []string
+
+	for _, image := range images {
+		if _, ok := image.Labels[fissileVersionLabel]; !ok {
+			if _, ok := image.Labels[roleImageLabel]; !ok {
+				continue
+			}
+		}
+		tagged := false
+		for _, repoTag := range image.RepoTags {
+			colon := strings.LastIndex(repoTag, ":")
+			if colon == -1 || repoTag == "<none>:<none>" {
+				continue
+			}
+			tagged = true
+			repository := repoTag[:colon]
+			repositories[repository] = append(repositories[repository],
+				taggedImage{repoTag, image.Created, image.Labels[packagesImageLabel]})
+		}
+		if !tagged {
+			pruned = append(pruned, image.ID)
+		}
+	}
+
+	kept := map[string]bool{}
+	var candidates []string
+	for _, tags := range repositories {
+		sort.SliceStable(tags, func(i, j int) bool { return tags[i].created > tags[j].created })
+		for i, tag := range tags {
+			if i >= keep && !current[tag.name] {
+				candidates = append(candidates, tag.name)
+			} else if tag.packages != "" {
+				kept[tag.packages] = true
+			}
+		}
+	}
+	for _, candidate := range candidates {
+		if !kept[candidate] {
+			pruned = append(pruned, candidate)
+		}
+	}
+
+	sort.Strings(pruned)
+	return pruned
+}
+
+// pruneCompilation returns the IDs of the stopped package compilation
+// containers, and the names of the compilation volumes that do not belong to
+// a remaining container.
+func pruneCompilation(containers []dockerclient.APIContainers, volumes []dockerclient.Volume) ([]string, []string) {
+	var prunedContainers []string
+	remaining := map[string]bool{}
+	for _, container := range containers {
+		isCompilation := false
+		for _, name := range container.Names {
+			if strings.HasSuffix(name, compilationContainerSuffix) {
+				isCompilation = true
+			}
+		}
+		if isCompilation && container.State != "running" {
+			prunedContainers = append(prunedContainers, container.ID)
+			continue
+		}
+		for _, name := range container.Names {
+			remaining[strings.TrimLeft(name, "/")] = true
+		}
+	}
+
+	// Compilation volumes are named volume_<container name>_<mount name>, see
+	// docker.ImageManager.RunInContainer
+	var prunedVolumes []string
+	for _, volume := range volumes {
+		if !strings.HasPrefix(volume.Name, "volume_") {
+			continue
+		}
+		end := strings.LastIndex(volume.Name, compilationContainerSuffix+"_")
+		if end == -1 {
+			continue
+		}
+		containerName := volume.Name[len("volume_") : end+len(compilationContainerSuffix)]
+		if !remaining[containerName] {
+			prunedVolumes = append(prunedVolumes, volume.Name)
+		}
+	}
+
+	sort.Strings(prunedContainers)
+	sort.Strings(prunedVolumes)
+	return prunedContainers, prunedVolumes
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"testing"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneImages(t *testing.T) {
+	t.Parallel()
+
+	role := map[string]string{roleImageLabel: "nats", fissileVersionLabel: "1.0"}
+	currentRole := map[string]string{roleImageLabel: "nats", fissileVersionLabel: "1.0",
+		packagesImageLabel: "fissile-role-packages:p0"}
+	packages := map[string]string{fissileVersionLabel: "1.0"}
+	images := []dockerclient.APIImages{
+		{ID: "n1", RepoTags: []string{"org/fissile-nats:v1"}, Created: 1, Labels: role},
+		{ID: "n2", RepoTags: []string{"org/fissile-nats:v2"}, Created: 2, Labels: role},
+		{ID: "n3", RepoTags: []string{"org/fissile-nats:v3"}, Created: 3, Labels: role},
+		{ID: "n4", RepoTags: []string{"org/fissile-nats:v4"}, Created: 4, Labels: role},
+		{ID: "p0", RepoTags: []string{"fissile-role-packages:p0"}, Created: 0, Labels: packages},
+		{ID: "p1", RepoTags: []string{"fissile-role-packages:p1"}, Created: 1, Labels: packages},
+		{ID: "p2", RepoTags: []string{"fissile-role-packages:p2"}, Created: 2, Labels: packages},
+		{ID: "dangling", RepoTags: []string{"<none>:<none>"}, Created: 5, Labels: role},
+		{ID: "other", RepoTags: []string{"opensuse:42.3"}, Created: 0},
+	}
+	current := map[string]bool{"org/fissile-nats:v1": true}
+
+	assert.Equal(t, []string{
+		"dangling",
+		"fissile-role-packages:p0",
+		"org/fissile-nats:v2",
+	}, pruneImages(images, current, 2))
+
+	// The packages layer of a role image kept is kept as well
+	images[0].Labels = currentRole
+	assert.Equal(t, []string{
+		"dangling",
+		"org/fissile-nats:v2",
+	}, pruneImages(images, current, 2))
+}
+
+func TestPruneCompilation(t *testing.T) {
+	t.Parallel()
+
+	containers := []dockerclient.APIContainers{
+		{ID: "stopped", Names: []string{"/stemcell-1-0-nats-1-pkg-gnatsd-gkp"}, State: "exited"},
+		{ID: "running", Names: []string{"/stemcell-1-0-nats-1-pkg-ruby-gkp"}, State: "running"},
+		{ID: "unrelated", Names: []string{"/web"}, State: "exited"},
+	}
+	volumes := []dockerclient.Volume{
+		{Name: "volume_stemcell-1-0-nats-1-pkg-gnatsd-gkp_source"},
+		{Name: "volume_stemcell-1-0-nats-1-pkg-ruby-gkp_source"},
+		{Name: "volume_stemcell-1-0-nats-1-pkg-gone-gkp_source"},
+		{Name: "data"},
+	}
+
+	prunedContainers, prunedVolumes := pruneCompilation(containers, volumes)
+	assert.Equal(t, []string{"stopped"}, prunedContainers)
+	assert.Equal(t, []string{
+		"volume_stemcell-1-0-nats-1-pkg-gnatsd-gkp_source",
+		"volume_stemcell-1-0-nats-1-pkg-gone-gkp_source",
+	}, prunedVolumes)
+}
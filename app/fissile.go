@@ -1,12 +1,17 @@
 package app
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 
 	"code.cloudfoundry.org/fissile/builder"
 	"code.cloudfoundry.org/fissile/compilator"
@@ -18,9 +23,12 @@ import (
 	"code.cloudfoundry.org/fissile/model/releaseresolver"
 	"code.cloudfoundry.org/fissile/scripts/compilation"
 	"code.cloudfoundry.org/fissile/util"
+	"code.cloudfoundry.org/fissile/validation"
+	"github.com/Masterminds/semver"
 	"github.com/SUSE/stampy"
 	"github.com/SUSE/termui"
 	"github.com/fatih/color"
+	workerLib "github.com/jimmysawczuk/worker"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -43,6 +51,22 @@ type Fissile struct {
 	Options   FissileOptions
 	cmdErr    error
 	graphFile *os.File
+	// graphMutex serializes the writes to the graphFile by concurrent jobs
+	graphMutex sync.Mutex
+	// rewrittenFiles collects the files rewritten by a regeneration of the
+	// kube configs while watching their inputs
+	rewrittenFiles *[]string
+	// writtenFiles collects all files written by a generation of the kube
+	// configs while watching their inputs, rewritten or not, so that stale
+	// ones are removed
+	writtenFiles map[string]bool
+	// capturedDocuments collects the streamed documents instead of writing
+	// them, while diffing the kube configs of role manifests
+	capturedDocuments *[]kube.StreamDocument
+	// progress writes the events of the compilation of packages, if they
+	// are requested in a machine-readable format
+	progress     *compilator.ProgressWriter
+	progressFile *os.File
 }
 
 // FissileOptions contains the values of all global fissile application options.
@@ -58,13 +82,20 @@ type FissileOptions struct {
 	DockerOrganization string
 	DockerUsername     string
 	DockerPassword     string
+	ContainerBackend   string
+	HTTPProxy          string
+	CACertFile         string
 	RepositoryPrefix   string
 	Workers            int
+	AutoWorkers        bool
 	LightOpinions      string
-	DarkOpinions       string
+	DarkOpinions       []string
+	RuntimeConfigs     []string
+	DefaultsFiles      []string
 	OutputFormat       string
 	Metrics            string
 	Verbose            bool
+	ValidatorPlugins   []string
 }
 
 // NewFissileApplication creates a new app.Fissile.
@@ -78,6 +109,7 @@ func NewFissileApplication(version string, ui *termui.UI) *Fissile {
 // Cleanup is a destructor.
 func (f *Fissile) Cleanup() {
 	f.GraphEnd()
+	f.ProgressEnd()
 }
 
 // CompilationDir returns the path to the compilation directory.
@@ -90,6 +122,24 @@ func (f *Fissile) StemcellCompilationDir(stemcell string) string {
 	return filepath.Join(f.CompilationDir(), util.Hash(stemcell))
 }
 
+// LoadOpinions loads the opinions in use by fissile; a light opinions patch is
+// applied over the spec defaults of the loaded role manifest.
+func (f *Fissile) LoadOpinions() (*model.Opinions, error) {
+	var defaults map[string]interface{}
+	if f.Manifest != nil {
+		defaults = model.SpecDefaults(f.Manifest.InstanceGroups)
+	}
+	return model.NewOpinionsWithDefaults(defaults, f.Options.LightOpinions, f.Options.DarkOpinions...)
+}
+
+// httpOptions returns the proxy and CA settings of requests to remote servers
+func (f *Fissile) httpOptions() util.HTTPOptions {
+	return util.HTTPOptions{
+		Proxy:      f.Options.HTTPProxy,
+		CACertFile: f.Options.CACertFile,
+	}
+}
+
 // LoadManifest loads the manifest in use by fissile.
 func (f *Fissile) LoadManifest() error {
 	roleManifest, err := loader.LoadRoleManifest(
@@ -101,18 +151,56 @@ func (f *Fissile) LoadManifest() error {
 				ReleaseVersions:  f.Options.ReleaseVersions,
 				BOSHCacheDir:     f.Options.CacheDir,
 				FinalReleasesDir: f.Options.FinalReleasesDir,
+				HTTPOptions:      f.httpOptions(),
 			},
-			Grapher: f,
+			Grapher:        f,
+			RuntimeConfigs: f.Options.RuntimeConfigs,
+			DefaultsFiles:  f.Options.DefaultsFiles,
 		},
 	)
+	if errs, ok := err.(validation.ErrorList); ok {
+		return &ManifestError{Errors: errs}
+	}
 	if err != nil {
 		return fmt.Errorf("Error loading role manifest: %v", err)
 	}
 
 	f.Manifest = roleManifest
+	f.checkMinimumVersion()
 	return nil
 }
 
+// checkMinimumVersion warns when the role manifest requires a newer version
+// of fissile. Builds without a proper version are not checked.
+func (f *Fissile) checkMinimumVersion() {
+	if f.Manifest.MinimumFissileVersion == "" {
+		return
+	}
+	current, err := ParseFissileVersion(f.Version)
+	if err != nil {
+		return
+	}
+	minimum, err := semver.NewVersion(f.Manifest.MinimumFissileVersion)
+	if err != nil {
+		return
+	}
+	if current.LessThan(minimum) {
+		f.UI.Println(color.YellowString("Warning: the role manifest requires fissile %s or newer, but this is %s; see `fissile version --check`",
+			minimum, current))
+	}
+}
+
+// ManifestError is returned by LoadManifest when the role manifest fails
+// validation, giving access to the individual validation errors.
+type ManifestError struct {
+	Errors validation.ErrorList
+}
+
+// Error implements the error interface.
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("Error loading role manifest: %v", e.Errors)
+}
+
 // ListPackages will list all BOSH packages within a list of releases.
 func (f *Fissile) ListPackages() error {
 	if f.Manifest == nil || len(f.Manifest.LoadedReleases) == 0 {
@@ -329,7 +417,7 @@ func (f *Fissile) collectPropertyDefaults() model.PropertyDefaults {
 }
 
 // Compile will compile a list of dev BOSH releases.
-func (f *Fissile) Compile(stemcellImageName string, targetPath, roleManifestPath, metricsPath string, instanceGroupNames, releaseNames []string, workerCount int, dockerNetworkMode string, withoutDocker, verbose bool, packageCacheConfigFilename string, streamPackages bool) error {
+func (f *Fissile) Compile(stemcellImageName string, targetPath, roleManifestPath, metricsPath string, instanceGroupNames, releaseNames []string, workerCount int, autoWorkers bool, dockerNetworkMode string, withoutDocker, verbose bool, packageCacheConfigFilename string, cacheReadOnly, streamPackages bool) error {
 	if f.Manifest == nil || len(f.Manifest.LoadedReleases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
@@ -339,11 +427,6 @@ func (f *Fissile) Compile(stemcellImageName string, targetPath, roleManifestPath
 		defer stampy.Stamp(metricsPath, "fissile", "compile-packages", "done")
 	}
 
-	dockerManager, err := docker.NewImageManager()
-	if err != nil {
-		return fmt.Errorf("Error connecting to docker: %v", err)
-	}
-
 	releases, err := f.getReleasesByName(releaseNames)
 	if err != nil {
 		return err
@@ -354,33 +437,159 @@ func (f *Fissile) Compile(stemcellImageName string, targetPath, roleManifestPath
 		f.UI.Printf("         %s (%s)\n", color.YellowString(release.Name), color.MagentaString(release.Version))
 	}
 
-	packageStorage, err := compilator.NewPackageStorageFromConfig(packageCacheConfigFilename, targetPath, stemcellImageName)
+	instanceGroups, err := f.Manifest.SelectInstanceGroups(instanceGroupNames)
+	if err != nil {
+		return fmt.Errorf("Error selecting packages to build: %v", err)
+	}
+
+	// The packages of instance groups with stemcells of their own are
+	// compiled on those, into the compilation directories of the stemcells
+	stemcells, stemcellGroups := instanceGroups.GroupByStemcell(stemcellImageName)
+	if len(stemcells) == 0 {
+		stemcells = []string{stemcellImageName}
+		stemcellGroups[stemcellImageName] = instanceGroups
+	}
+	if withoutDocker && len(stemcells) > 1 {
+		// Compiling without docker uses the OS of the host, which can only
+		// stand in for a single stemcell
+		return fmt.Errorf("Compiling without docker supports a single stemcell, but the instance groups use %s",
+			strings.Join(stemcells, ", "))
+	}
+	for _, stemcell := range stemcells {
+		stemcellTargetPath := targetPath
+		if stemcell != stemcellImageName {
+			stemcellTargetPath = f.StemcellCompilationDir(stemcell)
+		}
+		if len(stemcells) > 1 {
+			f.UI.Printf("Compiling on stemcell %s:\n", color.YellowString(stemcell))
+		}
+
+		comp, err := f.newCompilator(stemcell, stemcellTargetPath, metricsPath, autoWorkers, dockerNetworkMode, withoutDocker, packageCacheConfigFilename, cacheReadOnly, streamPackages)
+		if err != nil {
+			return err
+		}
+
+		if err := comp.Compile(workerCount, releases, stemcellGroups[stemcell], verbose); err != nil {
+			return fmt.Errorf("Error compiling packages: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// CompilePackageOptions contains the options for compiling a single package
+type CompilePackageOptions struct {
+	// Package is the package to compile, as <release>/<package>
+	Package                    string
+	Force                      bool
+	StemcellImageName          string
+	TargetPath                 string
+	MetricsPath                string
+	WorkerCount                int
+	AutoWorkers                bool
+	DockerNetworkMode          string
+	WithoutDocker              bool
+	Verbose                    bool
+	PackageCacheConfigFilename string
+	CacheReadOnly              bool
+	StreamPackages             bool
+}
+
+// CompilePackage compiles a single package of the loaded releases, along with
+// the dependencies it needs that are not compiled yet.
+func (f *Fissile) CompilePackage(opt CompilePackageOptions) error {
+	if f.Manifest == nil || len(f.Manifest.LoadedReleases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	parts := strings.Split(opt.Package, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("Invalid package %q; must be given as <release>/<package>", opt.Package)
+	}
+
+	releases, err := f.getReleasesByName([]string{parts[0]})
+	if err != nil {
+		return err
+	}
+	pkg, err := releases[0].LookupPackage(parts[1])
+	if err != nil {
+		return err
+	}
+
+	if opt.MetricsPath != "" {
+		stampy.Stamp(opt.MetricsPath, "fissile", "compile-packages", "start")
+		defer stampy.Stamp(opt.MetricsPath, "fissile", "compile-packages", "done")
+	}
+
+	f.UI.Printf("%s %s/%s (%s)\n",
+		color.GreenString("Compiling package"),
+		color.YellowString(releases[0].Name),
+		color.YellowString(pkg.Name),
+		color.MagentaString(pkg.Version))
+
+	comp, err := f.newCompilator(opt.StemcellImageName, opt.TargetPath, opt.MetricsPath, opt.AutoWorkers, opt.DockerNetworkMode, opt.WithoutDocker, opt.PackageCacheConfigFilename, opt.CacheReadOnly, opt.StreamPackages)
 	if err != nil {
 		return err
 	}
+
+	if err := comp.CompilePackage(opt.WorkerCount, pkg, opt.Force, opt.Verbose); err != nil {
+		return fmt.Errorf("Error compiling packages: %v", err)
+	}
+
+	return nil
+}
+
+// newCompilator returns the compilator for compiling packages, either in
+// docker containers or in a mount namespace. Packages found in the package
+// cache are downloaded instead of compiled; compiled packages are uploaded to
+// it, unless the cache is read-only.
+func (f *Fissile) newCompilator(stemcellImageName, targetPath, metricsPath string, autoWorkers bool, dockerNetworkMode string, withoutDocker bool, packageCacheConfigFilename string, cacheReadOnly, streamPackages bool) (*compilator.Compilator, error) {
+	dockerManager, err := docker.NewImageManager()
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to docker: %v", err)
+	}
+
+	packageStorage, err := compilator.NewPackageStorageFromConfig(packageCacheConfigFilename, targetPath, stemcellImageName)
+	if err != nil {
+		return nil, err
+	}
+	if packageStorage != nil {
+		if cacheReadOnly {
+			packageStorage.ReadOnly = true
+		}
+		if !withoutDocker {
+			stemcellImage, err := dockerManager.FindImage(stemcellImageName)
+			if err != nil {
+				return nil, err
+			}
+			packageStorage.SetStemcellImageID(stemcellImage.ID)
+		}
+	}
+	if err := compilator.CheckStemcellPlatform(f.UI, dockerManager, stemcellImageName, withoutDocker); err != nil {
+		return nil, err
+	}
+
 	var comp *compilator.Compilator
 	if withoutDocker {
 		comp, err = compilator.NewMountNSCompilator(targetPath, metricsPath, stemcellImageName, compilation.LinuxBase, f.Version, f.UI, f, packageStorage)
 		if err != nil {
-			return fmt.Errorf("Error creating a new compilator: %v", err)
+			return nil, fmt.Errorf("Error creating a new compilator: %v", err)
 		}
 	} else {
 		comp, err = compilator.NewDockerCompilator(dockerManager, targetPath, metricsPath, stemcellImageName, compilation.LinuxBase, f.Version, dockerNetworkMode, false, f.UI, f, packageStorage, streamPackages)
 		if err != nil {
-			return fmt.Errorf("Error creating a new compilator: %v", err)
+			return nil, fmt.Errorf("Error creating a new compilator: %v", err)
 		}
 	}
 
-	instanceGroups, err := f.Manifest.SelectInstanceGroups(instanceGroupNames)
-	if err != nil {
-		return fmt.Errorf("Error selecting packages to build: %v", err)
-	}
-
-	if err := comp.Compile(workerCount, releases, instanceGroups, verbose); err != nil {
-		return fmt.Errorf("Error compiling packages: %v", err)
+	if autoWorkers {
+		if err := comp.EnableWorkerAutoTuning(); err != nil {
+			return nil, fmt.Errorf("Error auto-tuning the workers: %v", err)
+		}
 	}
+	comp.SetProgressWriter(f.progress)
 
-	return nil
+	return comp, nil
 }
 
 // CleanCache inspects the compilation cache and removes all packages
@@ -415,7 +624,7 @@ func (f *Fissile) CleanCache() error {
 	removed := 0
 	for _, cache := range cached {
 		key := filepath.Base(cache)
-		if _, ok := referenced[key]; ok {
+		if _, ok := referenced[key]; ok || key == compilator.DurationHistoryFile {
 			continue
 		}
 
@@ -466,12 +675,12 @@ func (f *Fissile) ListRoleImages(existingOnDocker, withVirtualSize bool, tagExtr
 		}
 	}
 
-	opinions, err := model.NewOpinions(f.Options.LightOpinions, f.Options.DarkOpinions)
+	opinions, err := f.LoadOpinions()
 	if err != nil {
 		return fmt.Errorf("Error loading opinions: %v", err)
 	}
 
-	for _, instanceGroup := range f.Manifest.InstanceGroups {
+	for _, instanceGroup := range f.Manifest.InstanceGroups.WithoutZoneReplicas() {
 		devVersion, err := instanceGroup.GetRoleDevVersion(opinions, tagExtra, f.Version, f)
 		if err != nil {
 			return fmt.Errorf("Error creating instance group checksum: %v", err)
@@ -676,10 +885,134 @@ func compareHashes(v1Hash, v2Hash keyHash) *HashDiffs {
 }
 
 // GenerateKube will create a set of configuration files suitable for deployment
-// on Kubernetes.
-func (f *Fissile) GenerateKube(settings kube.ExportSettings) error {
+// on Kubernetes. Multiple export profiles (e.g. a helm chart and plain
+// Kubernetes configs) can be generated from the loaded role manifest in a
+// single run; each profile needs its own output directory.
+func (f *Fissile) GenerateKube(profiles ...kube.ExportSettings) error {
+	outputDirs := map[string]bool{}
+	for _, settings := range profiles {
+		outputDir, err := filepath.Abs(settings.OutputDir)
+		if err != nil {
+			return err
+		}
+		if outputDirs[outputDir] {
+			return fmt.Errorf("Multiple export profiles use the output directory %s", settings.OutputDir)
+		}
+		outputDirs[outputDir] = true
+
+		if settings.StreamOutput != "" {
+			if settings.CreateHelmChart && settings.Render == nil {
+				return fmt.Errorf("A helm chart cannot be written as a single stream")
+			}
+			if settings.CreateKustomization {
+				return fmt.Errorf("A kustomization cannot be written for a single stream")
+			}
+			if settings.CreateHelperScripts && settings.StreamOutput == kube.StreamOutputStdout {
+				return fmt.Errorf("Helper scripts cannot be written with a stream to standard output")
+			}
+		}
+		if settings.QuarksDeployment != "" && (settings.CreateHelmChart || settings.Render != nil) {
+			return fmt.Errorf("The custom resources of the cf-operator cannot be written as a helm chart or rendered with values")
+		}
+		if settings.CreateApplyPlan {
+			if settings.CreateHelmChart || settings.Render != nil || settings.StreamOutput != "" {
+				return fmt.Errorf("An apply plan can only be written for plain Kubernetes configuration files")
+			}
+			if settings.InstanceGroupFilter.Active() {
+				return fmt.Errorf("An apply plan cannot be written for a subset of the instance groups")
+			}
+		}
+	}
+
+	for _, settings := range profiles {
+		err := f.generateKubeProfile(settings)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kubeProfile holds the state of the generation of the configuration files
+// of a single kube export profile.
+type kubeProfile struct {
+	*Fissile
+	// generatedFiles lists the files written for the profile
+	generatedFiles []string
+	// renderer interpolates the templates, if the profile writes plain
+	// Kubernetes configuration files with values applied; rendererUI reports
+	// on the rendered objects, keeping a stream on standard output clean
+	renderer   *kube.Renderer
+	rendererUI *termui.UI
+	// linter checks the templates of the helm chart, if they are linted
+	linter *kube.TemplateLinter
+	// streamDocuments collects the documents, if the profile writes them as
+	// a single stream instead of files
+	streamDocuments []kube.StreamDocument
+	streaming       bool
+	// clusterScoped collects the cluster-scoped resources, if they are
+	// audited
+	clusterScoped []kube.ClusterScopedResource
+	auditing      bool
+	// policyWorkloads collects the workloads creating pods, if a policy
+	// bundle is written
+	policyWorkloads []*kube.WorkloadSecurity
+	policyBundle    bool
+	// applyPlanSteps collects the steps of the apply plan, if one is written
+	// for the output directory
+	applyPlanSteps []kube.ApplyPlanStep
+	applyPlanDir   string
+}
+
+// generateKubeProfile writes the configuration files for a single export
+// profile.
+func (f *Fissile) generateKubeProfile(settings kube.ExportSettings) error {
+	p := &kubeProfile{
+		Fissile:      f,
+		streaming:    settings.StreamOutput != "",
+		auditing:     settings.AuditClusterScope,
+		policyBundle: settings.PolicyBundle,
+	}
+	if settings.CreateApplyPlan {
+		p.applyPlanDir = settings.OutputDir
+	}
+	return p.generate(settings)
+}
+
+// generate writes the configuration files of the profile.
+func (p *kubeProfile) generate(settings kube.ExportSettings) error {
 	var err error
-	settings.RoleManifest = f.Manifest
+	settings.RoleManifest = p.Manifest
+	err = settings.InstanceGroupFilter.Validate(settings.RoleManifest)
+	if err != nil {
+		return err
+	}
+	if settings.Render != nil {
+		// The templates of the helm chart are rendered with the values
+		settings.CreateHelmChart = true
+		p.renderer, err = kube.NewRenderer(settings)
+		if err != nil {
+			return err
+		}
+		p.rendererUI = p.UI
+		// Keep a stream on standard output clean
+		if settings.StreamOutput == kube.StreamOutputStdout {
+			p.rendererUI = termui.New(os.Stdin, os.Stderr, nil)
+		} else if settings.Render.Environ != nil {
+			p.reportValuesFromEnv(p.renderer.EnvOverrides())
+		}
+	}
+
+	if settings.LintTemplates && settings.CreateHelmChart && settings.Render == nil {
+		p.linter, err = kube.NewTemplateLinter(settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	if settings.InstanceGroupFilter.Active() {
+		return p.generateFilteredKubeProfile(settings)
+	}
 
 	cvs := model.MakeMapOfVariables(settings.RoleManifest)
 	for key, value := range cvs {
@@ -694,22 +1027,46 @@ func (f *Fissile) GenerateKube(settings kube.ExportSettings) error {
 		return err
 	}
 
-	err = f.generateSecrets("secrets.yaml", secrets, settings)
+	if kube.SelectSecretBackend(kube.SecretBackendKubernetes, settings, secrets) {
+		err = p.generateSecrets("secrets.yaml", secrets, settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	externalSecrets, err := kube.MakeExternalSecrets(settings)
+	if err != nil {
+		return err
+	}
+	if externalSecrets != nil {
+		err = p.generateSecrets("external-secrets.yaml", externalSecrets, settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	providerClass, err := kube.MakeSecretProviderClass(cvs, settings)
 	if err != nil {
 		return err
 	}
+	if providerClass != nil && kube.SelectSecretBackend(kube.SecretBackendVaultCSI, settings, providerClass) {
+		err = p.generateSecrets("secret-provider-class.yaml", providerClass, settings)
+		if err != nil {
+			return err
+		}
+	}
 
 	registryCredentials, err := kube.MakeRegistryCredentials(settings)
 	if err != nil {
 		return err
 	}
 
-	err = f.generateSecrets("registry-secret.yaml", registryCredentials, settings)
+	err = p.generateSecrets("registry-secret.yaml", registryCredentials, settings)
 	if err != nil {
 		return err
 	}
 
-	err = f.generateAuth(settings)
+	err = p.generateAuth(settings)
 	if err != nil {
 		return err
 	}
@@ -719,117 +1076,595 @@ func (f *Fissile) GenerateKube(settings kube.ExportSettings) error {
 		return err
 	}
 
-	err = f.generateSecrets("deployment-manifest-secret.yaml", manifestSecret, settings)
+	err = p.generateSecrets("deployment-manifest-secret.yaml", manifestSecret, settings)
 	if err != nil {
 		return err
 	}
 
 	if settings.CreateHelmChart {
-		values := kube.MakeValues(settings)
-		err = f.writeHelmNode(settings.OutputDir, "values.yaml", values)
+		// The values and helpers are already part of the renderer, and the
+		// integration snippets refer to the helm chart
+		if p.renderer == nil {
+			values := kube.MakeValues(settings)
+			err = p.writeHelmNode(settings.OutputDir, "values.yaml", values)
+			if err != nil {
+				return err
+			}
+
+			err = p.generateValuesSchema(settings)
+			if err != nil {
+				return err
+			}
+
+			if settings.HelmVersion == kube.HelmVersion3 {
+				err = p.generateHelm3Metadata(settings)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = p.generateHelmHelpers("_fissileHelpers.yaml", settings)
+			if err != nil {
+				return err
+			}
+
+			err = p.generateIntegrationSnippets(settings)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = p.generateImagePrePull(settings)
 		if err != nil {
 			return err
 		}
 
-		err = f.generateHelmHelpers("_fissileHelpers.yaml", settings)
+		err = p.generateBoshDNSAliases(settings)
 		if err != nil {
 			return err
 		}
-	}
 
-	return f.generateKubeRoles(settings)
-}
+		err = p.generateExportedProviders(settings)
+		if err != nil {
+			return err
+		}
 
-// generateHelmHelpers will write out helm helper files.
-func (f *Fissile) generateHelmHelpers(fileName string, settings kube.ExportSettings) error {
-	if !settings.CreateHelmChart {
-		panic("generateHelmHelpers called when not generating helm chart")
-	}
-	outputDir := filepath.Join(settings.OutputDir, "templates")
-	return f.writeHelmNode(outputDir, fileName, kube.GetHelmTemplateHelpers()...)
-}
+		err = p.generateGrafanaDashboard(settings)
+		if err != nil {
+			return err
+		}
 
-func (f *Fissile) generateSecrets(fileName string, secrets helm.Node, settings kube.ExportSettings) error {
-	subDir := "secrets"
-	if settings.CreateHelmChart {
-		subDir = "templates"
-	}
-	secretsDir := filepath.Join(settings.OutputDir, subDir)
-	err := os.MkdirAll(secretsDir, 0755)
-	if err != nil {
-		return err
-	}
-	return f.writeHelmNode(secretsDir, fileName, secrets)
-}
+		err = p.generateVerificationJob(settings)
+		if err != nil {
+			return err
+		}
 
-func (f *Fissile) generateAuth(settings kube.ExportSettings) error {
-	subDir := "auth"
-	if settings.CreateHelmChart {
-		subDir = "templates"
+		err = p.generateInstanceGroupMigration(settings)
+		if err != nil {
+			return err
+		}
+
+		err = p.generateResourceQuota(settings)
+		if err != nil {
+			return err
+		}
 	}
-	authDir := filepath.Join(settings.OutputDir, subDir)
-	err := os.MkdirAll(authDir, 0755)
+
+	err = p.generateKubeRoles(settings)
 	if err != nil {
 		return err
 	}
 
-	// Generate accounts (and any associated role bindings / cluster role bindings)
-	for accountName, accountSpec := range settings.RoleManifest.Configuration.Authorization.Accounts {
-		// Ignore accounts referenced by a single instance group. These are not
-		// written as their own files, but as part of the instance group.
-		if len(accountSpec.UsedBy) < 2 {
-			continue
+	if settings.LocalVolumes != nil {
+		err = p.generateLocalVolumes(settings)
+		if err != nil {
+			return err
 		}
+	}
 
-		nodes, err := kube.NewRBACAccount(accountName, settings.RoleManifest.Configuration, settings)
+	if settings.QuarksDeployment != "" {
+		err = p.generateBOSHDeployment(settings)
 		if err != nil {
 			return err
 		}
-		err = f.writeHelmNode(authDir, fmt.Sprintf("account-%s.yaml", accountName), nodes...)
+	}
+
+	if p.auditing {
+		err = p.auditClusterScope(settings)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Generate roles
-	for roleName, roleSpec := range settings.RoleManifest.Configuration.Authorization.Roles {
-		var accountNames []string
-		for accountName := range settings.RoleManifest.Configuration.Authorization.RoleUsedBy[roleName] {
-			accountNames = append(accountNames, fmt.Sprintf("- %s", accountName))
-		}
-		if len(accountNames) < 1 {
-			panic(fmt.Sprintf("Role \"%s\" used by no accounts", roleName))
-		}
-		if len(accountNames) < 2 {
-			// Ignore roles referenced by a single account. These are not written as their own files,
-			// but as part of the account.
-			continue
+	if p.policyBundle {
+		err = p.generatePolicyBundle(settings)
+		if err != nil {
+			return err
 		}
-		sort.Strings(accountNames)
+	}
 
-		node, err := kube.NewRBACRole(roleName, kube.RBACRoleKindRole, roleSpec, settings)
+	if settings.CreateHelperScripts {
+		err = p.generateHelperScripts(settings)
 		if err != nil {
 			return err
 		}
-		node.Set(helm.Comment(fmt.Sprintf("Role \"%s\" used by accounts:\n%s", roleName, strings.Join(accountNames, "\n"))))
-		err = f.writeHelmNode(authDir, fmt.Sprintf("auth-role-%s.yaml", roleName), node)
+	}
+
+	if settings.CreateApplyPlan {
+		err = p.writeApplyPlan(settings)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Generate cluster roles
-	for roleName, roleSpec := range settings.RoleManifest.Configuration.Authorization.ClusterRoles {
-		var accountNames []string
-		for accountName := range settings.RoleManifest.Configuration.Authorization.ClusterRoleUsedBy[roleName] {
-			accountNames = append(accountNames, fmt.Sprintf("- %s", accountName))
-		}
-		if len(accountNames) < 1 {
-			panic(fmt.Sprintf("Cluster role \"%s\" used by no accounts", roleName))
-		}
-		if len(accountNames) < 2 {
-			// Ignore cluster roles referenced by a single account. These are not written as their own files,
-			// but as part of the account.
+	if settings.CreateKustomization && !settings.CreateHelmChart {
+		return p.generateKustomization(settings)
+	}
+	if p.streaming {
+		return p.writeStream(settings.StreamOutput)
+	}
+	return nil
+}
+
+// generateFilteredKubeProfile writes the configuration files of the instance
+// groups selected by the filter of the profile, and the RBAC resources they
+// share with other instance groups. All other files are left alone.
+func (p *kubeProfile) generateFilteredKubeProfile(settings kube.ExportSettings) error {
+	err := p.generateAuth(settings)
+	if err != nil {
+		return err
+	}
+
+	err = p.generateKubeRoles(settings)
+	if err != nil {
+		return err
+	}
+
+	if p.auditing {
+		err = p.auditClusterScope(settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.streaming {
+		return p.writeStream(settings.StreamOutput)
+	}
+	return nil
+}
+
+// reportValuesFromEnv prints the keys of the values set from environment
+// variables, with their types; the values may be secrets, so they are not shown
+func (f *Fissile) reportValuesFromEnv(overrides []kube.ValuesOverride) {
+	f.UI.Printf("Values from the environment: %s\n", color.CyanString("%d", len(overrides)))
+	for _, override := range overrides {
+		f.UI.Printf("  %s (%s, from %s)\n", color.CyanString(override.Key), override.Type(), override.Variable)
+	}
+}
+
+// auditClusterScope lists the cluster-scoped resources of the profile, and
+// fails unless the role manifest allows all of their kinds.
+func (p *kubeProfile) auditClusterScope(settings kube.ExportSettings) error {
+	// Keep a stream on standard output clean
+	if settings.StreamOutput != kube.StreamOutputStdout {
+		p.UI.Printf("Cluster-scoped resources: %s\n", color.CyanString("%d", len(p.clusterScoped)))
+		for _, resource := range p.clusterScoped {
+			p.UI.Printf("  %s %s (%s)\n", resource.Kind, color.CyanString(resource.Name), resource.Template)
+		}
+	}
+	return kube.AuditClusterScope(p.clusterScoped, settings.RoleManifest.Configuration.ClusterScoped)
+}
+
+// writeStream writes the collected documents of the profile as a single
+// multi-document YAML stream, suitable for `kubectl apply -f -`.
+func (p *kubeProfile) writeStream(outputPath string) error {
+	if p.capturedDocuments != nil {
+		*p.capturedDocuments = append(*p.capturedDocuments, p.streamDocuments...)
+		return nil
+	}
+	stream := kube.MakeStream(p.streamDocuments)
+	if outputPath == kube.StreamOutputStdout {
+		_, err := p.UI.Print(string(stream))
+		return err
+	}
+	p.UI.Printf("Writing config stream %s\n", color.CyanString(outputPath))
+	return ioutil.WriteFile(outputPath, stream, 0644)
+}
+
+// generateHelm3Metadata writes the Chart.yaml of a helm 3 chart
+func (p *kubeProfile) generateHelm3Metadata(settings kube.ExportSettings) error {
+	chart, err := kube.MakeChartMetadata(settings)
+	if err != nil {
+		return err
+	}
+	return p.writeHelmNode(settings.OutputDir, "Chart.yaml", chart)
+}
+
+// generateValuesSchema writes the values.schema.json of a helm chart, next to
+// the values.yaml
+func (f *Fissile) generateValuesSchema(settings kube.ExportSettings) error {
+	schema, err := kube.MakeValuesSchema(settings)
+	if err != nil {
+		return err
+	}
+	outputPath := filepath.Join(settings.OutputDir, "values.schema.json")
+	f.UI.Printf("Writing values schema %s\n", color.CyanString(outputPath))
+	return ioutil.WriteFile(outputPath, schema, 0644)
+}
+
+// generatePolicyBundle writes the summary of the security-relevant settings
+// of the workloads written for the profile, and the exemptions they need from
+// the constraints of the Gatekeeper policy library, if any.
+func (p *kubeProfile) generatePolicyBundle(settings kube.ExportSettings) error {
+	outputDir := filepath.Join(settings.OutputDir, kube.PolicyBundleDir)
+	err := os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	summary := kube.MakePolicySummary(settings, p.policyWorkloads)
+	buf, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	outputPath := filepath.Join(outputDir, kube.PolicySummaryFile)
+	p.UI.Printf("Writing policy summary %s\n", color.CyanString(outputPath))
+	err = ioutil.WriteFile(outputPath, append(buf, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+
+	requests := kube.MakePolicyExemptionRequests(summary)
+	if len(requests) == 0 {
+		return nil
+	}
+	buf, err = yaml.Marshal(map[string]interface{}{"exemption_requests": requests})
+	if err != nil {
+		return err
+	}
+	outputPath = filepath.Join(outputDir, kube.PolicyExemptionsFile)
+	p.UI.Printf("Writing policy exemption requests %s\n", color.CyanString(outputPath))
+	return ioutil.WriteFile(outputPath, buf, 0644)
+}
+
+// generateHelperScripts writes out the kubectl helper scripts for the
+// instance groups. They are not Kubernetes resources, and so are not tracked
+// in the generated files.
+func (f *Fissile) generateHelperScripts(settings kube.ExportSettings) error {
+	scripts, err := kube.MakeHelperScripts(settings)
+	if err != nil {
+		return err
+	}
+	outputDir := filepath.Join(settings.OutputDir, kube.HelperScriptsDir)
+	err = os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		outputPath := filepath.Join(outputDir, name)
+		f.UI.Printf("Writing script %s\n", color.CyanString(outputPath))
+		err = ioutil.WriteFile(outputPath, []byte(scripts[name]), 0755)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateKustomization writes a kustomization.yaml listing all the files
+// generated for the profile, so the output can be consumed by kustomize.
+func (p *kubeProfile) generateKustomization(settings kube.ExportSettings) error {
+	var resources []string
+	for _, outputPath := range p.generatedFiles {
+		resource, err := filepath.Rel(settings.OutputDir, outputPath)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, filepath.ToSlash(resource))
+	}
+	return p.writeHelmNode(settings.OutputDir, "kustomization.yaml", kube.MakeKustomization(resources))
+}
+
+// generateHelmHelpers will write out helm helper files.
+func (p *kubeProfile) generateHelmHelpers(fileName string, settings kube.ExportSettings) error {
+	if !settings.CreateHelmChart {
+		panic("generateHelmHelpers called when not generating helm chart")
+	}
+	outputDir := filepath.Join(settings.OutputDir, "templates")
+	helpers := append(kube.GetHelmTemplateHelpers(), kube.GetHelmExtensionHelpers(settings.ExtensionSnippets)...)
+	err := p.writeHelmNode(outputDir, fileName, helpers...)
+	if err != nil || len(settings.ExtensionSnippets) == 0 {
+		return err
+	}
+
+	// The snippets are templates spanning multiple lines, and are copied as they are
+	outputPath := filepath.Join(outputDir, "_fissileExtensions.tpl")
+	p.UI.Printf("Writing config %s\n", color.CyanString(outputPath))
+	return ioutil.WriteFile(outputPath, []byte(kube.MakeExtensionSnippetsTemplate(settings.ExtensionSnippets)), 0644)
+}
+
+// generateImagePrePull writes out the DaemonSet pre-pulling all role images,
+// and the hook job waiting for it.
+func (p *kubeProfile) generateImagePrePull(settings kube.ExportSettings) error {
+	nodes, err := kube.NewImagePrePull(settings, p.Fissile)
+	if err != nil {
+		return err
+	}
+	outputDir := filepath.Join(settings.OutputDir, "templates")
+	return p.writeHelmNode(outputDir, "image-prepull.yaml", nodes...)
+}
+
+// generateBoshDNSAliases writes out the CoreDNS configuration resolving BOSH
+// DNS addresses, if any instance group has a service.
+func (p *kubeProfile) generateBoshDNSAliases(settings kube.ExportSettings) error {
+	configMap, err := kube.MakeBoshDNSAliases(settings)
+	if err != nil || configMap == nil {
+		return err
+	}
+	outputDir := filepath.Join(settings.OutputDir, "templates")
+	return p.writeHelmNode(outputDir, "bosh-dns-aliases.yaml", configMap)
+}
+
+// generateExportedProviders writes out the ConfigMap documenting the
+// services of the exported BOSH link providers, if there are any.
+func (p *kubeProfile) generateExportedProviders(settings kube.ExportSettings) error {
+	configMap, err := kube.MakeExportedProviders(settings)
+	if err != nil || configMap == nil {
+		return err
+	}
+	outputDir := filepath.Join(settings.OutputDir, "templates")
+	return p.writeHelmNode(outputDir, "exported-providers.yaml", configMap)
+}
+
+// generateGrafanaDashboard writes out the ConfigMap holding the Grafana
+// dashboard of the instance groups.
+func (p *kubeProfile) generateGrafanaDashboard(settings kube.ExportSettings) error {
+	configMap, err := kube.MakeGrafanaDashboard(settings)
+	if err != nil {
+		return err
+	}
+	outputDir := filepath.Join(settings.OutputDir, "templates")
+	return p.writeHelmNode(outputDir, "grafana-dashboard.yaml", configMap)
+}
+
+// generateVerificationJob writes out the post-deploy verification job and
+// its RBAC resources.
+func (p *kubeProfile) generateVerificationJob(settings kube.ExportSettings) error {
+	nodes, err := kube.NewVerificationJob(settings)
+	if err != nil {
+		return err
+	}
+	outputDir := filepath.Join(settings.OutputDir, "templates")
+	return p.writeHelmNode(outputDir, "post-deploy-verification.yaml", nodes...)
+}
+
+// generateInstanceGroupMigration writes out the pre-upgrade job migrating
+// renamed instance groups, if there are any.
+func (p *kubeProfile) generateInstanceGroupMigration(settings kube.ExportSettings) error {
+	nodes, err := kube.NewInstanceGroupMigrationJob(settings)
+	if err != nil || nodes == nil {
+		return err
+	}
+	outputDir := filepath.Join(settings.OutputDir, "templates")
+	return p.writeHelmNode(outputDir, "instance-group-migration.yaml", nodes...)
+}
+
+// generateResourceQuota writes out the resource quota and limit range of the
+// namespace.
+func (p *kubeProfile) generateResourceQuota(settings kube.ExportSettings) error {
+	nodes, err := kube.NewResourceQuota(settings)
+	if err != nil {
+		return err
+	}
+	outputDir := filepath.Join(settings.OutputDir, "templates")
+	return p.writeHelmNode(outputDir, "resource-quota.yaml", nodes...)
+}
+
+// generateLocalVolumes writes the local persistent volumes backing the claims
+// of the stateful sets, into the templates of a helm chart or the volumes
+// directory.
+func (p *kubeProfile) generateLocalVolumes(settings kube.ExportSettings) error {
+	nodes, err := kube.NewLocalPersistentVolumes(settings)
+	if err != nil {
+		return err
+	}
+	outputDir := filepath.Join(settings.OutputDir, "volumes")
+	if settings.CreateHelmChart {
+		outputDir = filepath.Join(settings.OutputDir, "templates")
+	}
+	err = os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return err
+	}
+	return p.writeHelmNode(outputDir, kube.LocalVolumesFile, nodes...)
+}
+
+// generateBOSHDeployment writes the BOSHDeployment of the role manifest for
+// the cf-operator, into a directory of its own: it deploys the instance groups
+// by itself, instead of the QuarksStatefulSets and QuarksJobs.
+func (p *kubeProfile) generateBOSHDeployment(settings kube.ExportSettings) error {
+	nodes, err := kube.NewBOSHDeployment(settings)
+	if err != nil {
+		return err
+	}
+	outputDir := filepath.Join(settings.OutputDir, "bosh-deployment")
+	err = p.makeOutputDir(outputDir)
+	if err != nil {
+		return err
+	}
+	return p.writeHelmNode(outputDir, kube.BOSHDeploymentFile, nodes...)
+}
+
+// generateIntegrationSnippets writes out the requested helmfile/terraform
+// snippets referencing the helm chart into the integration directory.
+func (p *kubeProfile) generateIntegrationSnippets(settings kube.ExportSettings) error {
+	err := kube.ValidateIntegrationDir(settings.IntegrationSnippets, settings.OutputDir, settings.IntegrationDir)
+	if err != nil || len(settings.IntegrationSnippets) == 0 {
+		return err
+	}
+	// The snippets are not part of the chart, so they are written even
+	// when the chart is streamed
+	err = os.MkdirAll(settings.IntegrationDir, 0755)
+	if err != nil {
+		return err
+	}
+	for _, snippet := range settings.IntegrationSnippets {
+		switch snippet {
+		case kube.IntegrationHelmfile:
+			helmfile, err := kube.MakeHelmfile(settings)
+			if err != nil {
+				return err
+			}
+			var content bytes.Buffer
+			err = helm.NewEncoder(&content, helm.EmptyLines(true)).Encode(helmfile)
+			if err != nil {
+				return err
+			}
+			err = p.writeIntegrationFile(filepath.Join(settings.IntegrationDir, "helmfile.yaml"), content.Bytes())
+			if err != nil {
+				return err
+			}
+		case kube.IntegrationTerraform:
+			release, err := kube.MakeTerraformRelease(settings)
+			if err != nil {
+				return err
+			}
+			err = p.writeIntegrationFile(filepath.Join(settings.IntegrationDir, "helm_release.tf"), []byte(release))
+			if err != nil {
+				return err
+			}
+		default:
+			return kube.ValidateIntegrationSnippets([]string{snippet})
+		}
+	}
+	return nil
+}
+
+// writeIntegrationFile writes an integration snippet. Unlike the chart
+// templates, it is neither linted, rendered nor streamed.
+func (p *kubeProfile) writeIntegrationFile(outputPath string, content []byte) error {
+	p.UI.Printf("Writing integration snippet %s\n", color.CyanString(outputPath))
+	p.generatedFiles = append(p.generatedFiles, outputPath)
+	return p.writeOutputFile(outputPath, content)
+}
+
+func (p *kubeProfile) generateSecrets(fileName string, secrets helm.Node, settings kube.ExportSettings) error {
+	subDir := "secrets"
+	if settings.CreateHelmChart {
+		subDir = "templates"
+	}
+	secretsDir := filepath.Join(settings.OutputDir, subDir)
+	err := p.makeOutputDir(secretsDir)
+	if err != nil {
+		return err
+	}
+	return p.writeHelmNode(secretsDir, fileName, secrets)
+}
+
+func (p *kubeProfile) generateAuth(settings kube.ExportSettings) error {
+	subDir := "auth"
+	if settings.CreateHelmChart {
+		subDir = "templates"
+	}
+	authDir := filepath.Join(settings.OutputDir, subDir)
+	err := p.makeOutputDir(authDir)
+	if err != nil {
+		return err
+	}
+
+	// With an instance group filter, only the resources used by the selected
+	// instance groups are written
+	filter := settings.InstanceGroupFilter
+	accounts := settings.RoleManifest.Configuration.Authorization.Accounts
+	accountSelected := func(accountName string) bool {
+		for instanceGroupName := range accounts[accountName].UsedBy {
+			if filter.Selects(instanceGroupName) {
+				return true
+			}
+		}
+		return false
+	}
+	anyAccountSelected := func(accountNames map[string]struct{}) bool {
+		for accountName := range accountNames {
+			if accountSelected(accountName) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Generate accounts (and any associated role bindings / cluster role bindings)
+	for accountName, accountSpec := range accounts {
+		// Ignore accounts referenced by a single instance group. These are not
+		// written as their own files, but as part of the instance group.
+		if len(accountSpec.UsedBy) < 2 {
+			continue
+		}
+		if !accountSelected(accountName) {
+			continue
+		}
+
+		nodes, err := kube.NewRBACAccount(accountName, settings.RoleManifest.Configuration, settings)
+		if err != nil {
+			return err
+		}
+		err = p.writeHelmNode(authDir, fmt.Sprintf("account-%s.yaml", accountName), nodes...)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Generate roles
+	for roleName, roleSpec := range settings.RoleManifest.Configuration.Authorization.Roles {
+		var accountNames []string
+		for accountName := range settings.RoleManifest.Configuration.Authorization.RoleUsedBy[roleName] {
+			accountNames = append(accountNames, fmt.Sprintf("- %s", accountName))
+		}
+		if len(accountNames) < 1 {
+			panic(fmt.Sprintf("Role \"%s\" used by no accounts", roleName))
+		}
+		if len(accountNames) < 2 {
+			// Ignore roles referenced by a single account. These are not written as their own files,
+			// but as part of the account.
+			continue
+		}
+		if filter.Active() && !anyAccountSelected(settings.RoleManifest.Configuration.Authorization.RoleUsedBy[roleName]) {
+			continue
+		}
+		sort.Strings(accountNames)
+
+		node, err := kube.NewRBACRole(roleName, kube.RBACRoleKindRole, roleSpec, settings)
+		if err != nil {
+			return err
+		}
+		node.Set(helm.Comment(fmt.Sprintf("Role \"%s\" used by accounts:\n%s", roleName, strings.Join(accountNames, "\n"))))
+		err = p.writeHelmNode(authDir, fmt.Sprintf("auth-role-%s.yaml", roleName), node)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Generate cluster roles
+	for roleName, roleSpec := range settings.RoleManifest.Configuration.Authorization.ClusterRoles {
+		var accountNames []string
+		for accountName := range settings.RoleManifest.Configuration.Authorization.ClusterRoleUsedBy[roleName] {
+			accountNames = append(accountNames, fmt.Sprintf("- %s", accountName))
+		}
+		_, aggregated := settings.RoleManifest.Configuration.Authorization.AggregateTo[roleName]
+		if len(accountNames) < 1 && !aggregated {
+			panic(fmt.Sprintf("Cluster role \"%s\" used by no accounts", roleName))
+		}
+		if len(accountNames) == 1 {
+			// Ignore cluster roles referenced by a single account. These are not written as their own files,
+			// but as part of the account.
+			continue
+		}
+		if filter.Active() && !anyAccountSelected(settings.RoleManifest.Configuration.Authorization.ClusterRoleUsedBy[roleName]) {
 			continue
 		}
 		sort.Strings(accountNames)
@@ -838,8 +1673,13 @@ func (f *Fissile) generateAuth(settings kube.ExportSettings) error {
 		if err != nil {
 			return err
 		}
-		node.Set(helm.Comment(fmt.Sprintf("Cluster role \"%s\" used by accounts:\n%s", roleName, strings.Join(accountNames, "\n"))))
-		err = f.writeHelmNode(authDir, fmt.Sprintf("auth-cluster-role-%s.yaml", roleName), node)
+		if len(accountNames) < 1 {
+			// Cluster roles only aggregated into the builtin roles are not bound to any account
+			node.Set(helm.Comment(fmt.Sprintf("Cluster role \"%s\" aggregated into builtin cluster roles", roleName)))
+		} else {
+			node.Set(helm.Comment(fmt.Sprintf("Cluster role \"%s\" used by accounts:\n%s", roleName, strings.Join(accountNames, "\n"))))
+		}
+		err = p.writeHelmNode(authDir, fmt.Sprintf("auth-cluster-role-%s.yaml", roleName), node)
 		if err != nil {
 			return err
 		}
@@ -852,7 +1692,7 @@ func (f *Fissile) generateAuth(settings kube.ExportSettings) error {
 		if err != nil {
 			return err
 		}
-		err = f.writeHelmNode(authDir, fmt.Sprintf("auth-psp-%s.yaml", pspName), node)
+		err = p.writeHelmNode(authDir, fmt.Sprintf("auth-psp-%s.yaml", pspName), node)
 		if err != nil {
 			return err
 		}
@@ -861,24 +1701,173 @@ func (f *Fissile) generateAuth(settings kube.ExportSettings) error {
 	return nil
 }
 
-func (f *Fissile) writeHelmNode(dirName, fileName string, nodes ...helm.Node) error {
+func (p *kubeProfile) writeHelmNode(dirName, fileName string, nodes ...helm.Node) error {
 	outputPath := filepath.Join(dirName, fileName)
-	f.UI.Printf("Writing config %s\n", color.CyanString(outputPath))
+	if p.auditing {
+		p.clusterScoped = append(p.clusterScoped,
+			kube.ClusterScopedResources(path.Join(filepath.Base(dirName), fileName), nodes...)...)
+	}
+	if p.policyBundle {
+		p.policyWorkloads = append(p.policyWorkloads, kube.PolicyWorkloads(nodes...)...)
+	}
+	if p.streaming {
+		return p.addStreamDocuments(path.Join(filepath.Base(dirName), fileName), nodes...)
+	}
+	if p.renderer != nil {
+		return p.writeRenderedNode(outputPath, path.Join(filepath.Base(dirName), fileName), nodes...)
+	}
+	if p.linter != nil && filepath.Base(dirName) == "templates" && !strings.HasPrefix(fileName, "_") {
+		err := p.linter.Lint(path.Join("templates", fileName), nodes...)
+		if err != nil {
+			return fmt.Errorf("Error linting the helm chart: %v", err)
+		}
+	}
+	p.UI.Printf("Writing config %s\n", color.CyanString(outputPath))
+	p.generatedFiles = append(p.generatedFiles, outputPath)
+	if p.applyPlanDir != "" {
+		err := p.addApplyPlanStep(outputPath, nodes...)
+		if err != nil {
+			return err
+		}
+	}
+
+	var content bytes.Buffer
+	err := helm.NewEncoder(&content, helm.EmptyLines(true)).EncodeAll(nodes...)
+	if err != nil {
+		return err
+	}
+	return p.writeOutputFile(outputPath, content.Bytes())
+}
+
+// writeOutputFile writes a generated file, unless it already has the content,
+// so that only the files affected by changed inputs are rewritten. While
+// watching the inputs, the rewritten files are recorded.
+func (f *Fissile) writeOutputFile(outputPath string, content []byte) error {
+	if f.writtenFiles != nil {
+		f.writtenFiles[outputPath] = true
+	}
+	existing, err := ioutil.ReadFile(outputPath)
+	if err == nil && bytes.Equal(existing, content) {
+		return nil
+	}
+	if f.rewrittenFiles != nil {
+		*f.rewrittenFiles = append(*f.rewrittenFiles, outputPath)
+	}
+	return ioutil.WriteFile(outputPath, content, 0644)
+}
+
+// addApplyPlanStep records the step of the apply plan applying the file
+func (p *kubeProfile) addApplyPlanStep(outputPath string, nodes ...helm.Node) error {
+	file, err := filepath.Rel(p.applyPlanDir, outputPath)
+	if err != nil {
+		return err
+	}
+	step, ok := kube.NewApplyPlanStep(filepath.ToSlash(file), p.Manifest, nodes...)
+	if ok {
+		p.applyPlanSteps = append(p.applyPlanSteps, step)
+	}
+	return nil
+}
+
+// writeApplyPlan writes the apply plan of the profile, and the script
+// executing it, into the output directory. Files written afterwards, like
+// the kustomization, are not part of the plan.
+func (p *kubeProfile) writeApplyPlan(settings kube.ExportSettings) error {
+	plan := kube.MakeApplyPlan(p.applyPlanSteps)
+	p.applyPlanDir = ""
+
+	buf, err := yaml.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	outputPath := filepath.Join(settings.OutputDir, kube.ApplyPlanFile)
+	p.UI.Printf("Writing apply plan %s\n", color.CyanString(outputPath))
+	err = ioutil.WriteFile(outputPath, buf, 0644)
+	if err != nil {
+		return err
+	}
 
-	outputFile, err := os.Create(outputPath)
+	script, err := kube.MakeApplyScript(plan, settings)
 	if err != nil {
 		return err
 	}
+	outputPath = filepath.Join(settings.OutputDir, kube.ApplyScriptFile)
+	p.UI.Printf("Writing apply script %s\n", color.CyanString(outputPath))
+	return ioutil.WriteFile(outputPath, []byte(script), 0755)
+}
 
+// writeRenderedNode writes the nodes interpolated with the values of the
+// renderer. Templates rendering to no resources are not written.
+func (p *kubeProfile) writeRenderedNode(outputPath, templateName string, nodes ...helm.Node) error {
+	output, err := p.renderer.Render(templateName, nodes...)
+	if err != nil {
+		return err
+	}
+	if kube.IsEmptyRender(output) {
+		return nil
+	}
+	err = p.checkRenderedObjectSizes(outputPath, output)
+	if err != nil {
+		return err
+	}
+	p.UI.Printf("Writing rendered config %s\n", color.CyanString(outputPath))
+	p.generatedFiles = append(p.generatedFiles, outputPath)
+	return p.writeOutputFile(outputPath, output)
+}
+
+// checkRenderedObjectSizes warns about the rendered objects of the file
+// approaching the size limits of Kubernetes, and fails if any exceeds them,
+// rather than leaving it to the API server when applying them
+func (p *kubeProfile) checkRenderedObjectSizes(name string, output []byte) error {
+	warnings, err := p.renderer.CheckObjectSizes(output)
+	for _, warning := range warnings {
+		p.rendererUI.Println(color.YellowString("Warning: %s: %s", name, warning))
+	}
+	if err != nil {
+		return fmt.Errorf("Error rendering %s: %v", name, err)
+	}
+	return nil
+}
+
+// addStreamDocuments collects the nodes as documents of the stream, each
+// interpolated with the values of the renderer, if there is one.
+func (p *kubeProfile) addStreamDocuments(templateName string, nodes ...helm.Node) error {
 	for _, node := range nodes {
-		err = helm.NewEncoder(outputFile, helm.EmptyLines(true)).Encode(node)
-		if err != nil {
-			_ = outputFile.Close()
-			return err
+		var content bytes.Buffer
+		if p.renderer != nil {
+			output, err := p.renderer.Render(templateName, node)
+			if err != nil {
+				return err
+			}
+			if kube.IsEmptyRender(output) {
+				continue
+			}
+			err = p.checkRenderedObjectSizes(templateName, output)
+			if err != nil {
+				return err
+			}
+			content.Write(output)
+		} else {
+			err := helm.NewEncoder(&content, helm.EmptyLines(true)).Encode(node)
+			if err != nil {
+				return err
+			}
 		}
+		p.streamDocuments = append(p.streamDocuments, kube.StreamDocument{
+			Kind:    kube.NodeKind(node),
+			Content: content.Bytes(),
+		})
 	}
-	err = outputFile.Close()
-	return err
+	return nil
+}
+
+// makeOutputDir creates a directory of the output tree, unless the
+// documents are written as a single stream.
+func (p *kubeProfile) makeOutputDir(dirName string) error {
+	if p.streaming {
+		return nil
+	}
+	return os.MkdirAll(dirName, 0755)
 }
 
 func (f *Fissile) generateBoshTaskRole(instanceGroup *model.InstanceGroup, settings kube.ExportSettings) ([]helm.Node, error) {
@@ -886,7 +1875,13 @@ func (f *Fissile) generateBoshTaskRole(instanceGroup *model.InstanceGroup, setti
 	var node helm.Node
 	var err error
 
-	if instanceGroup.HasTag(model.RoleTagStopOnFailure) {
+	if settings.QuarksDeployment != "" {
+		// QuarksJobs wrap jobs, even for tasks stopping on failure
+		node, err = kube.NewJob(instanceGroup, settings, f)
+		if err == nil {
+			node, err = kube.NewQuarksJob(instanceGroup, node, settings)
+		}
+	} else if instanceGroup.HasTag(model.RoleTagStopOnFailure) {
 		node, err = kube.NewPod(instanceGroup, settings, f)
 	} else {
 		node, err = kube.NewJob(instanceGroup, settings, f)
@@ -895,6 +1890,10 @@ func (f *Fissile) generateBoshTaskRole(instanceGroup *model.InstanceGroup, setti
 	if err != nil {
 		return nil, err
 	}
+	if kube.IsSecretGenerationJob(instanceGroup) {
+		// Vault backends don't need the generated secrets
+		kube.SelectSecretBackend(kube.SecretBackendKubernetes, settings, node)
+	}
 
 	authNodes, err := f.generateAuthCoupledToRole(instanceGroup, settings)
 	if err != nil {
@@ -937,7 +1936,15 @@ func (f *Fissile) instanceGroupHasStorage(instanceGroup *model.InstanceGroup) bo
 	return false
 }
 
-func (f *Fissile) generateKubeRoles(settings kube.ExportSettings) error {
+// generateKubeRoles writes the resources of the instance groups, one file
+// each. The resources are generated by up to f.Options.Workers instance groups
+// at a time, and written in the order of the role manifest as soon as the
+// instance group and all before it are done; written resources are released,
+// so that huge role manifests don't hold the resources of all instance groups
+// in memory at once. Once an instance group fails, no further files are
+// written; the errors of all instance groups are reported together.
+func (p *kubeProfile) generateKubeRoles(settings kube.ExportSettings) error {
+	var instanceGroups model.InstanceGroups
 	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
 		if instanceGroup.IsColocated() {
 			continue
@@ -945,54 +1952,185 @@ func (f *Fissile) generateKubeRoles(settings kube.ExportSettings) error {
 		if settings.CreateHelmChart && instanceGroup.Run.FlightStage == model.FlightStageManual {
 			continue
 		}
-
-		subDir := string(instanceGroup.Type)
-		if settings.CreateHelmChart {
-			subDir = "templates"
+		if !settings.InstanceGroupFilter.Selects(instanceGroup.Name) {
+			continue
 		}
-		roleTypeDir := filepath.Join(settings.OutputDir, subDir)
-		err := os.MkdirAll(roleTypeDir, 0755)
-		if err != nil {
-			return err
+		if kube.IsSecretGenerationJob(instanceGroup) &&
+			(!kube.SelectSecretBackend(kube.SecretBackendKubernetes, settings) || !kube.NeedsSecretGeneration(settings)) {
+			continue
 		}
-
 		switch instanceGroup.Type {
-		case model.RoleTypeBoshTask:
-			nodes, err := f.generateBoshTaskRole(instanceGroup, settings)
-			if err != nil {
-				return err
-			}
+		case model.RoleTypeBoshTask, model.RoleTypeBosh:
+			instanceGroups = append(instanceGroups, instanceGroup)
+		}
+	}
 
-			err = f.writeHelmNode(roleTypeDir, fmt.Sprintf("%s.yaml", instanceGroup.Name), nodes...)
+	workers := p.Options.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	workerLib.MaxJobs = workers
+	worker := workerLib.NewWorker()
+	resultsCh := make(chan kubeRoleResult)
+	for index, instanceGroup := range instanceGroups {
+		worker.Add(kubeRoleJob{
+			index:         index,
+			instanceGroup: instanceGroup,
+			settings:      settings,
+			fissile:       p.Fissile,
+			resultsCh:     resultsCh,
+		})
+	}
+
+	go worker.RunUntilDone()
+
+	// pending holds the results of the instance groups done before the
+	// ones preceding them, until they can be written
+	pending := map[int]kubeRoleResult{}
+	next := 0
+	errs := map[int]error{}
+	for range instanceGroups {
+		result := <-resultsCh
+		if result.err != nil {
+			errs[result.index] = result.err
+		}
+		if len(errs) > 0 {
+			// Keep receiving, so that the remaining workers finish
+			pending = nil
+			continue
+		}
+		pending[result.index] = result
+		for result, ok := pending[next]; ok; result, ok = pending[next] {
+			delete(pending, next)
+			next++
+			err := p.writeKubeRole(instanceGroups[result.index], result.nodes, settings)
 			if err != nil {
-				return err
+				errs[result.index] = err
+				break
 			}
+		}
+	}
 
-		case model.RoleTypeBosh:
-			statefulSet, deps, err := kube.NewStatefulSet(instanceGroup, settings, f)
-			if err != nil {
-				return err
+	if len(errs) == 1 {
+		for _, err := range errs {
+			return err
+		}
+	}
+	if len(errs) > 1 {
+		var failures []string
+		for index, instanceGroup := range instanceGroups {
+			if err, ok := errs[index]; ok {
+				failures = append(failures, fmt.Sprintf("%s: %v", instanceGroup.Name, err))
 			}
+		}
+		return fmt.Errorf("Failed to generate %d instance groups:\n%s", len(errs), strings.Join(failures, "\n"))
+	}
+	return nil
+}
 
-			authNodes, err := f.generateAuthCoupledToRole(instanceGroup, settings)
-			if err != nil {
-				return err
-			}
+// writeKubeRole writes the resources of an instance group into its file
+func (p *kubeProfile) writeKubeRole(instanceGroup *model.InstanceGroup, nodes []helm.Node, settings kube.ExportSettings) error {
+	subDir := string(instanceGroup.Type)
+	if settings.CreateHelmChart {
+		subDir = "templates"
+	}
+	roleTypeDir := filepath.Join(settings.OutputDir, subDir)
+	err := p.makeOutputDir(roleTypeDir)
+	if err != nil {
+		return err
+	}
+	return p.writeHelmNode(roleTypeDir, fmt.Sprintf("%s.yaml", instanceGroup.Name), nodes...)
+}
 
-			nodes := authNodes
-			if deps != nil {
-				nodes = append(nodes, deps)
-			}
-			nodes = append(nodes, statefulSet)
+// kubeRoleJob generates the resources of an instance group for
+// generateKubeRoles
+type kubeRoleJob struct {
+	index         int
+	instanceGroup *model.InstanceGroup
+	settings      kube.ExportSettings
+	fissile       *Fissile
+	resultsCh     chan<- kubeRoleResult
+}
 
-			err = f.writeHelmNode(roleTypeDir, fmt.Sprintf("%s.yaml", instanceGroup.Name), nodes...)
-			if err != nil {
-				return err
-			}
+// kubeRoleResult holds the resources generated by the kubeRoleJob of the
+// instance group at index
+type kubeRoleResult struct {
+	index int
+	nodes []helm.Node
+	err   error
+}
+
+func (j kubeRoleJob) Run() {
+	nodes, err := j.fissile.generateKubeRole(j.instanceGroup, j.settings)
+	j.resultsCh <- kubeRoleResult{index: j.index, nodes: nodes, err: err}
+}
+
+// generateKubeRole returns the resources of a bosh or bosh-task instance
+// group. It must be safe to run concurrently for different instance groups.
+func (f *Fissile) generateKubeRole(instanceGroup *model.InstanceGroup, settings kube.ExportSettings) ([]helm.Node, error) {
+	if instanceGroup.Type == model.RoleTypeBoshTask {
+		return f.generateBoshTaskRole(instanceGroup, settings)
+	}
+
+	statefulSet, deps, err := kube.NewStatefulSet(instanceGroup, settings, f)
+	if err != nil {
+		return nil, err
+	}
+	if settings.QuarksDeployment != "" {
+		statefulSet, err = kube.NewQuarksStatefulSet(instanceGroup, statefulSet, settings)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	authNodes, err := f.generateAuthCoupledToRole(instanceGroup, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := authNodes
+	if deps != nil {
+		nodes = append(nodes, deps)
+	}
+	nodes = append(nodes, statefulSet)
+
+	pdb, err := kube.NewPodDisruptionBudget(instanceGroup, settings)
+	if err != nil {
+		return nil, err
+	}
+	if pdb != nil {
+		nodes = append(nodes, pdb)
+	}
+
+	if settings.CreateHelmChart {
+		vpa, err := kube.NewVerticalPodAutoscaler(instanceGroup, settings)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, vpa)
+
+		monitor, err := kube.NewPrometheusMonitor(instanceGroup, settings)
+		if err != nil {
+			return nil, err
+		}
+		if monitor != nil {
+			nodes = append(nodes, monitor)
+		}
+
+		networkPolicy, err := kube.NewNetworkPolicy(instanceGroup, settings)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, networkPolicy)
+
+		istioResources, err := kube.NewIstioResources(instanceGroup, settings)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, istioResources...)
+	}
+
+	return nodes, nil
 }
 
 // GraphBegin will start logging hash information to the given file.
@@ -1016,6 +2154,48 @@ func (f *Fissile) GraphBegin(outputPath string) error {
 	return nil
 }
 
+// ProgressBegin starts writing the events of the compilation of packages in
+// the given format, to the output path, or to stdout if it is empty. The
+// human format is the text written to the UI anyway. When the events are
+// written to stdout, the text output is moved to stderr.
+func (f *Fissile) ProgressBegin(format, outputPath string) error {
+	if err := compilator.ValidateProgressFormat(format); err != nil {
+		return err
+	}
+	if format == compilator.ProgressFormatHuman {
+		return nil
+	}
+	if outputPath == "" {
+		f.progress = compilator.NewProgressWriter(os.Stdout)
+		f.UI = termui.New(os.Stdin, os.Stderr, nil)
+		return nil
+	}
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	f.progressFile = file
+	f.progress = compilator.NewProgressWriter(file)
+	return nil
+}
+
+// ProgressWriter returns the writer of the compilation progress events, or
+// nil if no events are written
+func (f *Fissile) ProgressWriter() *compilator.ProgressWriter {
+	return f.progress
+}
+
+// ProgressEnd stops writing the compilation progress events
+func (f *Fissile) ProgressEnd() error {
+	f.progress = nil
+	if f.progressFile == nil {
+		return nil
+	}
+	err := f.progressFile.Close()
+	f.progressFile = nil
+	return err
+}
+
 // GraphEnd will stop logging hash information.
 func (f *Fissile) GraphEnd() error {
 	if f.graphFile == nil {
@@ -1033,6 +2213,8 @@ func (f *Fissile) GraphEnd() error {
 
 // GraphNode adds a node to the hash debugging graph; this implements model.ModelGrapher.
 func (f *Fissile) GraphNode(nodeName string, attrs map[string]string) error {
+	f.graphMutex.Lock()
+	defer f.graphMutex.Unlock()
 	if f.graphFile == nil {
 		return nil
 	}
@@ -1048,6 +2230,8 @@ func (f *Fissile) GraphNode(nodeName string, attrs map[string]string) error {
 
 // GraphEdge adds an edge to the hash debugging graph; this implements model.ModelGrapher.
 func (f *Fissile) GraphEdge(fromNode, toNode string, attrs map[string]string) error {
+	f.graphMutex.Lock()
+	defer f.graphMutex.Unlock()
 	if f.graphFile == nil {
 		return nil
 	}
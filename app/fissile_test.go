@@ -7,15 +7,17 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"code.cloudfoundry.org/fissile/kube"
 	"code.cloudfoundry.org/fissile/model"
 	"code.cloudfoundry.org/fissile/model/loader"
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/SUSE/termui"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -385,7 +387,7 @@ func TestGenerateAuth(t *testing.T) {
 		OutputDir:    outDir,
 		RoleManifest: roleManifest,
 	}
-	err = f.generateAuth(settings)
+	err = (&kubeProfile{Fissile: f}).generateAuth(settings)
 	require.NoError(t, err)
 
 	samples := map[string][]string{
@@ -571,7 +573,7 @@ func TestGenerateAuth(t *testing.T) {
 					err = yaml.Unmarshal([]byte(actualChunks[i]), &actual)
 					assert.NoError(t, err, "Failed to unmarshal actual results")
 
-					testhelpers.IsYAMLSubset(assert.New(t), expected, actual)
+					yamltest.IsYAMLSubset(assert.New(t), expected, actual)
 				})
 			}
 		})
@@ -743,6 +745,23 @@ func TestFissileGetReleasesByName(t *testing.T) {
 	}
 }
 
+func TestFissileCompileWithoutDockerStemcells(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	require.NoError(t, f.LoadManifest())
+	f.Manifest.InstanceGroups[0].Stemcell = "splatform/fissile-stemcell-cflinuxfs3:latest"
+
+	err = f.Compile("splatform/fissile-stemcell-opensuse:42.3", "", "", "", nil, nil, 1, false, "", true, false, "", false, false)
+	assert.EqualError(t, err, "Compiling without docker supports a single stemcell, but the instance groups use "+
+		"splatform/fissile-stemcell-cflinuxfs3:latest, splatform/fissile-stemcell-opensuse:42.3")
+}
+
 func TestFissileGenerateKubeRoles(t *testing.T) {
 	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
 	workDir, err := os.Getwd()
@@ -763,7 +782,7 @@ func TestFissileGenerateKubeRoles(t *testing.T) {
 	require.NoError(t, err)
 	defer os.RemoveAll(outDir)
 
-	err = f.generateKubeRoles(kube.ExportSettings{OutputDir: outDir, RoleManifest: roleManifest})
+	err = (&kubeProfile{Fissile: f}).generateKubeRoles(kube.ExportSettings{OutputDir: outDir, RoleManifest: roleManifest})
 	assert.NoError(t, err)
 
 	for _, name := range []string{"myrole-deployment.yaml", "myrole-clustered.yaml"} {
@@ -772,3 +791,640 @@ func TestFissileGenerateKubeRoles(t *testing.T) {
 		assert.NoError(t, err, "Failed to find output %s", name)
 	}
 }
+
+func TestFissileGenerateKubeRendered(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml")
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+
+	err = f.LoadManifest()
+	require.NoError(t, err, "Failed to load release from %s", f.Options.Releases[0])
+
+	outDir, err := ioutil.TempDir("", "fissile-test-generate-kube-rendered")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	err = f.GenerateKube(kube.ExportSettings{
+		OutputDir: outDir,
+		Render: &kube.RenderOptions{
+			Values: map[string]interface{}{
+				"kube": map[interface{}]interface{}{"registry": map[interface{}]interface{}{"hostname": "registry.example.com"}},
+			},
+			ReleaseName:  "my-release",
+			Namespace:    "my-namespace",
+			ChartName:    "my-chart",
+			ChartVersion: "1.0.0",
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(outDir, "values.yaml"))
+	assert.True(t, os.IsNotExist(err), "Rendered output should not include values.yaml")
+
+	for _, name := range []string{"myrole-deployment.yaml", "secrets.yaml"} {
+		contents, err := ioutil.ReadFile(filepath.Join(outDir, "templates", name))
+		if assert.NoError(t, err, "Failed to find output %s", name) {
+			assert.NotContains(t, string(contents), "{{", "Output %s is not rendered", name)
+		}
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(outDir, "templates", "myrole-deployment.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(contents), "registry.example.com/")
+
+	err = f.GenerateKube(kube.ExportSettings{
+		OutputDir: outDir,
+		Render: &kube.RenderOptions{
+			ReleaseName:     "my-release",
+			Namespace:       "my-namespace",
+			ChartName:       "my-chart",
+			ChartVersion:    "1.0.0",
+			ObjectSizeLimit: 256,
+		},
+	})
+	if assert.Error(t, err, "Objects above the size limit should fail the generation") {
+		assert.Contains(t, err.Error(), "above the limit of 0.2KiB")
+	}
+}
+
+func TestFissileGenerateKubeStream(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml")
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+
+	err = f.LoadManifest()
+	require.NoError(t, err, "Failed to load release from %s", f.Options.Releases[0])
+
+	outDir, err := ioutil.TempDir("", "fissile-test-generate-kube-stream")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	streamPath := filepath.Join(outDir, "stream.yaml")
+	err = f.GenerateKube(kube.ExportSettings{OutputDir: outDir, StreamOutput: streamPath})
+	require.NoError(t, err)
+
+	entries, err := ioutil.ReadDir(outDir)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 1, "Only the stream should be written") {
+		assert.Equal(t, "stream.yaml", entries[0].Name())
+	}
+
+	contents, err := ioutil.ReadFile(streamPath)
+	require.NoError(t, err)
+	var kinds []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.HasPrefix(line, "kind: ") {
+			kinds = append(kinds, strings.TrimPrefix(line, "kind: "))
+		}
+	}
+	assert.Equal(t, []string{`"Secret"`, `"Secret"`, `"Secret"`, `"StatefulSet"`, `"StatefulSet"`}, kinds)
+
+	err = f.GenerateKube(kube.ExportSettings{OutputDir: outDir, StreamOutput: streamPath, CreateHelmChart: true})
+	assert.EqualError(t, err, "A helm chart cannot be written as a single stream")
+}
+
+func TestFissileGenerateKubeQuarks(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml")
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+
+	err = f.LoadManifest()
+	require.NoError(t, err, "Failed to load release from %s", f.Options.Releases[0])
+
+	outDir, err := ioutil.TempDir("", "fissile-test-generate-kube-quarks")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	err = f.GenerateKube(kube.ExportSettings{OutputDir: outDir, QuarksDeployment: "cf"})
+	require.NoError(t, err)
+
+	contents, err := ioutil.ReadFile(filepath.Join(outDir, "bosh", "myrole-deployment.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(contents), `kind: "QuarksStatefulSet"`)
+
+	contents, err = ioutil.ReadFile(filepath.Join(outDir, "bosh-deployment", kube.BOSHDeploymentFile))
+	require.NoError(t, err)
+	assert.Contains(t, string(contents), `kind: "BOSHDeployment"`)
+
+	err = f.GenerateKube(kube.ExportSettings{OutputDir: outDir, QuarksDeployment: "cf", CreateHelmChart: true})
+	assert.EqualError(t, err, "The custom resources of the cf-operator cannot be written as a helm chart or rendered with values")
+}
+
+func TestFissileGenerateKubeApplyPlan(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml")
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+
+	err = f.LoadManifest()
+	require.NoError(t, err, "Failed to load release from %s", f.Options.Releases[0])
+
+	outDir, err := ioutil.TempDir("", "fissile-test-generate-kube-apply-plan")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	err = f.GenerateKube(kube.ExportSettings{OutputDir: outDir, CreateApplyPlan: true, CreateKustomization: true})
+	require.NoError(t, err)
+
+	contents, err := ioutil.ReadFile(filepath.Join(outDir, kube.ApplyPlanFile))
+	require.NoError(t, err)
+	var plan kube.ApplyPlan
+	require.NoError(t, yaml.Unmarshal(contents, &plan))
+	var files []string
+	for _, step := range plan.Steps {
+		files = append(files, step.File)
+	}
+	assert.Equal(t, []string{
+		"secrets/secrets.yaml",
+		"secrets/registry-secret.yaml",
+		"secrets/deployment-manifest-secret.yaml",
+		"bosh/myrole-deployment.yaml",
+		"bosh/myrole-clustered.yaml",
+	}, files)
+	assert.Empty(t, plan.Steps[0].Wait)
+	assert.Equal(t, []kube.ApplyPlanWait{
+		{Resource: "statefulset/myrole-deployment", Condition: kube.WaitStatefulSetReady},
+	}, plan.Steps[3].Wait)
+
+	info, err := os.Stat(filepath.Join(outDir, kube.ApplyScriptFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	err = f.GenerateKube(kube.ExportSettings{OutputDir: outDir, CreateApplyPlan: true, CreateHelmChart: true})
+	assert.EqualError(t, err, "An apply plan can only be written for plain Kubernetes configuration files")
+}
+
+func TestFissileWatchKube(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	manifestDir, err := ioutil.TempDir("", "fissile-test-watch-kube")
+	require.NoError(t, err)
+	defer os.RemoveAll(manifestDir)
+
+	manifest, err := ioutil.ReadFile(filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml"))
+	require.NoError(t, err)
+	manifestPath := filepath.Join(manifestDir, "two-roles.yml")
+	require.NoError(t, ioutil.WriteFile(manifestPath, manifest, 0644))
+	script, err := ioutil.ReadFile(filepath.Join(workDir, "../test-assets/role-manifests/app/scripts/myrole.sh"))
+	require.NoError(t, err)
+	scriptPath := filepath.Join(manifestDir, "scripts", "myrole.sh")
+	require.NoError(t, os.Mkdir(filepath.Dir(scriptPath), 0755))
+	require.NoError(t, ioutil.WriteFile(scriptPath, script, 0644))
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = manifestPath
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.LightOpinions = filepath.Join(workDir, "../test-assets/tor-opinions/opinions.yml")
+	f.Options.DarkOpinions = []string{filepath.Join(workDir, "../test-assets/tor-opinions/dark-opinions.yml")}
+
+	err = f.LoadManifest()
+	require.NoError(t, err, "Failed to load release from %s", f.Options.Releases[0])
+
+	// The output is below the manifest directory, and must not trigger
+	// regenerations itself
+	outDir := filepath.Join(manifestDir, "out")
+
+	ready := make(chan struct{})
+	regenerated := make(chan []string)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- f.WatchKube(kube.ExportSettings{OutputDir: outDir}, WatchKubeOptions{
+			Debounce:    50 * time.Millisecond,
+			Stop:        stop,
+			ready:       ready,
+			regenerated: regenerated,
+		})
+	}()
+
+	waitForRegeneration := func() []string {
+		select {
+		case rewritten := <-regenerated:
+			return rewritten
+		case err := <-done:
+			require.FailNow(t, "Watching stopped early", "%v", err)
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "Timed out waiting for the kube configs to be regenerated")
+		}
+		return nil
+	}
+
+	select {
+	case <-ready:
+	case err := <-done:
+		require.FailNow(t, "Watching failed", "%v", err)
+	}
+	_, err = os.Stat(filepath.Join(outDir, "bosh", "myrole-deployment.yaml"))
+	require.NoError(t, err, "The kube configs should be generated before watching")
+
+	// Scaling an instance group rewrites the instance groups, whose image
+	// tags change with the role manifest, but not the secrets
+	changed := strings.Replace(string(manifest), "max: 2", "max: 3", 1)
+	require.NoError(t, ioutil.WriteFile(manifestPath, []byte(changed), 0644))
+	assert.Equal(t, []string{
+		filepath.Join(outDir, "bosh", "myrole-clustered.yaml"),
+		filepath.Join(outDir, "bosh", "myrole-deployment.yaml"),
+	}, waitForRegeneration())
+
+	// Saving a script without changes rewrites nothing
+	require.NoError(t, ioutil.WriteFile(scriptPath, script, 0644))
+	assert.Empty(t, waitForRegeneration())
+
+	// Directories created while watching are watched as well
+	newDir := filepath.Join(manifestDir, "more-scripts")
+	require.NoError(t, os.Mkdir(newDir, 0755))
+	assert.Empty(t, waitForRegeneration())
+	require.NoError(t, ioutil.WriteFile(filepath.Join(newDir, "other.sh"), script, 0644))
+	assert.Empty(t, waitForRegeneration())
+
+	// Removing an instance group removes its configs
+	removed := changed[:strings.Index(changed, "- name: myrole-clustered")]
+	require.NoError(t, ioutil.WriteFile(manifestPath, []byte(removed), 0644))
+	waitForRegeneration()
+	_, err = os.Stat(filepath.Join(outDir, "bosh", "myrole-clustered.yaml"))
+	assert.True(t, os.IsNotExist(err), "The configs of the removed instance group should be removed")
+	_, err = os.Stat(filepath.Join(outDir, "bosh", "myrole-deployment.yaml"))
+	assert.NoError(t, err)
+
+	close(stop)
+	assert.NoError(t, <-done)
+}
+
+func TestFissileGenerateKubeAuditClusterScope(t *testing.T) {
+	output := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, output, nil)
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/generate-auth.yml")
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+
+	err = f.LoadManifest()
+	require.NoError(t, err, "Failed to load release from %s", f.Options.Releases[0])
+
+	outDir, err := ioutil.TempDir("", "fissile-test-generate-kube-audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	settings := kube.ExportSettings{OutputDir: outDir, AuditClusterScope: true}
+	err = f.GenerateKube(settings)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Cluster-scoped resources not allowed by configuration.cluster_scoped")
+		assert.Contains(t, err.Error(), "ClusterRole nonprivileged (bosh/non-default.yaml)")
+		assert.Contains(t, err.Error(), "PodSecurityPolicy nonprivileged")
+	}
+	assert.Contains(t, output.String(), "Cluster-scoped resources:")
+
+	f.Manifest.Configuration.ClusterScoped = []string{"ClusterRole", "ClusterRoleBinding", "PodSecurityPolicy"}
+	assert.NoError(t, f.GenerateKube(settings))
+}
+
+func TestFissileGenerateKubePolicyBundle(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/generate-auth.yml")
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+
+	err = f.LoadManifest()
+	require.NoError(t, err, "Failed to load release from %s", f.Options.Releases[0])
+
+	outDir, err := ioutil.TempDir("", "fissile-test-generate-kube-policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	err = f.GenerateKube(kube.ExportSettings{OutputDir: outDir, PolicyBundle: true})
+	require.NoError(t, err)
+
+	buf, err := ioutil.ReadFile(filepath.Join(outDir, kube.PolicyBundleDir, kube.PolicySummaryFile))
+	require.NoError(t, err)
+	var summary kube.PolicySummary
+	require.NoError(t, json.Unmarshal(buf, &summary))
+	assert.NotEmpty(t, summary.Workloads)
+
+	buf, err = ioutil.ReadFile(filepath.Join(outDir, kube.PolicyBundleDir, kube.PolicyExemptionsFile))
+	require.NoError(t, err)
+	assert.Contains(t, string(buf), "constraint: K8sPSPReadOnlyRootFilesystem")
+}
+
+func TestFissileGenerateKubeHelm3(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/generate-auth.yml")
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+
+	err = f.LoadManifest()
+	require.NoError(t, err, "Failed to load release from %s", f.Options.Releases[0])
+
+	outDir, err := ioutil.TempDir("", "fissile-test-generate-helm3")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	settings := kube.ExportSettings{
+		OutputDir:       outDir,
+		CreateHelmChart: true,
+		HelmVersion:     kube.HelmVersion3,
+		ChartName:       "tor",
+		ChartVersion:    "1.0.0",
+		LintTemplates:   true,
+	}
+	require.NoError(t, f.GenerateKube(settings))
+
+	buf, err := ioutil.ReadFile(filepath.Join(outDir, "Chart.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(buf), "apiVersion: \"v2\"")
+	assert.Contains(t, string(buf), "name: \"tor\"")
+
+	buf, err = ioutil.ReadFile(filepath.Join(outDir, "values.schema.json"))
+	require.NoError(t, err)
+	assert.True(t, json.Valid(buf), "The values schema should be JSON")
+
+	settings.HelmVersion = kube.HelmVersion2
+	settings.OutputDir, err = ioutil.TempDir("", "fissile-test-generate-helm2")
+	require.NoError(t, err)
+	defer os.RemoveAll(settings.OutputDir)
+	require.NoError(t, f.GenerateKube(settings))
+	_, err = os.Stat(filepath.Join(settings.OutputDir, "Chart.yaml"))
+	assert.True(t, os.IsNotExist(err), "Helm 2 charts should not have a generated Chart.yaml")
+	_, err = os.Stat(filepath.Join(settings.OutputDir, "values.schema.json"))
+	assert.NoError(t, err, "Helm 2 charts should have a values schema as well")
+}
+
+func TestFissileGenerateKubeSecretBackend(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/generate-auth.yml")
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+
+	err = f.LoadManifest()
+	require.NoError(t, err, "Failed to load release from %s", f.Options.Releases[0])
+	f.Manifest.Variables = append(f.Manifest.Variables, &model.VariableDefinition{
+		Name:      "VAULT_SECRET",
+		CVOptions: model.CVOptions{Type: model.CVTypeUser, Secret: true, Internal: true},
+	})
+
+	outDir, err := ioutil.TempDir("", "fissile-test-generate-vault")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	// Charts support all backends, and pass the linter with each
+	settings := kube.ExportSettings{
+		OutputDir:       outDir,
+		CreateHelmChart: true,
+		SecretBackend:   kube.SecretBackendVaultCSI,
+		LintTemplates:   true,
+	}
+	require.NoError(t, f.GenerateKube(settings))
+	buf, err := ioutil.ReadFile(filepath.Join(outDir, "templates", "secret-provider-class.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(buf), `{{- if eq .Values.kube.secrets.backend "vault-csi" }}`)
+	buf, err = ioutil.ReadFile(filepath.Join(outDir, "templates", "secrets.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(buf), `{{- if eq .Values.kube.secrets.backend "kubernetes" }}`)
+	buf, err = ioutil.ReadFile(filepath.Join(outDir, "values.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(buf), `backend: "vault-csi"`)
+
+	// Plain configs only have the resources of the backend
+	settings = kube.ExportSettings{
+		OutputDir:     filepath.Join(outDir, "kube"),
+		SecretBackend: kube.SecretBackendVaultAgent,
+	}
+	require.NoError(t, os.Mkdir(settings.OutputDir, 0755))
+	require.NoError(t, f.GenerateKube(settings))
+	for _, name := range []string{"secrets.yaml", "secret-provider-class.yaml"} {
+		_, err = os.Stat(filepath.Join(settings.OutputDir, "secrets", name))
+		assert.True(t, os.IsNotExist(err), "The vault-agent backend doesn't need %s", name)
+	}
+}
+
+func TestFissileGenerateKubeInstanceGroupFilter(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/generate-auth.yml")
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+
+	err = f.LoadManifest()
+	require.NoError(t, err, "Failed to load release from %s", f.Options.Releases[0])
+
+	outDir, err := ioutil.TempDir("", "fissile-test-generate-filter")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	settings := kube.ExportSettings{
+		OutputDir:           outDir,
+		InstanceGroupFilter: kube.InstanceGroupFilter{Include: []string{"missing"}},
+	}
+	assert.EqualError(t, f.GenerateKube(settings), "Instance group filter: unknown instance group missing")
+
+	settings.InstanceGroupFilter = kube.InstanceGroupFilter{Include: []string{"non-default"}}
+	require.NoError(t, f.GenerateKube(settings))
+
+	_, err = os.Stat(filepath.Join(outDir, "bosh", "non-default.yaml"))
+	assert.NoError(t, err, "The selected instance group should be generated")
+	_, err = os.Stat(filepath.Join(outDir, "auth", "auth-psp-nonprivileged.yaml"))
+	assert.NoError(t, err, "The pod security policies should be generated")
+	for _, name := range []string{"bosh/default.yaml", "secrets/secrets.yaml"} {
+		_, err = os.Stat(filepath.Join(outDir, name))
+		assert.True(t, os.IsNotExist(err), "%s should not be generated", name)
+	}
+
+	settings.InstanceGroupFilter = kube.InstanceGroupFilter{Exclude: []string{"non-default"}}
+	require.NoError(t, f.GenerateKube(settings))
+	_, err = os.Stat(filepath.Join(outDir, "bosh", "default.yaml"))
+	assert.NoError(t, err, "Instance groups that are not excluded should be generated")
+}
+
+func TestFissileGenerateKubeWorkers(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/generate-auth.yml")
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+
+	err = f.LoadManifest()
+	require.NoError(t, err, "Failed to load release from %s", f.Options.Releases[0])
+
+	// The files generated concurrently must not depend on the number of workers
+	generated := map[int]map[string]string{}
+	for _, workers := range []int{1, 4} {
+		outDir, err := ioutil.TempDir("", "fissile-test-generate-workers")
+		require.NoError(t, err)
+		defer os.RemoveAll(outDir)
+
+		f.Options.Workers = workers
+		require.NoError(t, f.GenerateKube(kube.ExportSettings{OutputDir: outDir}))
+
+		generated[workers] = map[string]string{}
+		err = filepath.Walk(outDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			generated[workers][strings.TrimPrefix(path, outDir)] = string(contents)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	assert.Contains(t, generated[4], "/bosh/default.yaml")
+	assert.Equal(t, generated[1], generated[4])
+}
+
+// writeManyGroupsManifest writes a role manifest with the number of instance
+// groups of the tor job into the directory, and returns its path
+func writeManyGroupsManifest(b *testing.B, dir string, count int) string {
+	var manifest bytes.Buffer
+	manifest.WriteString("---\ninstance_groups:\n")
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&manifest, `- name: group-%03d
+  jobs:
+  - name: tor
+    release: tor
+    properties:
+      bosh_containerization:
+        run:
+          scaling:
+            min: 1
+            max: 3
+`, i)
+	}
+	path := filepath.Join(dir, "many-groups.yml")
+	require.NoError(b, ioutil.WriteFile(path, manifest.Bytes(), 0644))
+	return path
+}
+
+// samplePeakHeap samples the heap in use until stopped, and sends its
+// maximum to peak
+func samplePeakHeap(stop <-chan struct{}, peak chan<- uint64) {
+	var max uint64
+	var stats runtime.MemStats
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		runtime.ReadMemStats(&stats)
+		if stats.HeapInuse > max {
+			max = stats.HeapInuse
+		}
+		select {
+		case <-stop:
+			peak <- max
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// BenchmarkGenerateKubeManyGroups compares the peak heap of generating the
+// resources of role manifests with growing numbers of instance groups. Written
+// as files, the resources of the instance groups are released once written,
+// so that the peak heap stays about flat; streams keep the documents until
+// they are written, ordered by kind.
+func BenchmarkGenerateKubeManyGroups(b *testing.B) {
+	for _, count := range []int{25, 100, 400} {
+		for _, stream := range []bool{false, true} {
+			name := fmt.Sprintf("Groups%d/Files", count)
+			if stream {
+				name = fmt.Sprintf("Groups%d/Stream", count)
+			}
+			b.Run(name, func(b *testing.B) {
+				benchmarkGenerateKubeManyGroups(b, count, stream)
+			})
+		}
+	}
+}
+
+// benchmarkGenerateKubeManyGroups generates the resources of a role manifest
+// with count instance groups, and reports the peak heap in use
+func benchmarkGenerateKubeManyGroups(b *testing.B, count int, stream bool) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	workDir, err := os.Getwd()
+	require.NoError(b, err)
+
+	tempDir, err := ioutil.TempDir("", "fissile-bench-many-groups")
+	require.NoError(b, err)
+	defer os.RemoveAll(tempDir)
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = writeManyGroupsManifest(b, tempDir, count)
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.Workers = 4
+	require.NoError(b, f.LoadManifest())
+
+	outDir := filepath.Join(tempDir, "out")
+	settings := kube.ExportSettings{
+		OutputDir:       outDir,
+		UseMemoryLimits: true,
+		UseCPULimits:    true,
+	}
+	if stream {
+		settings.StreamOutput = filepath.Join(tempDir, "stream.yaml")
+	}
+
+	var peak uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		require.NoError(b, os.RemoveAll(outDir))
+		runtime.GC()
+		stop := make(chan struct{})
+		peakCh := make(chan uint64)
+		go samplePeakHeap(stop, peakCh)
+		b.StartTimer()
+
+		require.NoError(b, f.GenerateKube(settings))
+
+		b.StopTimer()
+		close(stop)
+		if sample := <-peakCh; sample > peak {
+			peak = sample
+		}
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(peak), "peak-heap-bytes")
+	b.ReportMetric(float64(peak)/float64(count), "peak-heap-bytes/group")
+}
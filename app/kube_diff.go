@@ -0,0 +1,292 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/kube"
+	"github.com/fatih/color"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DiffKubeOptions selects the versions of the role manifest to compare. Each
+// version is the role manifest at the path (defaulting to the role manifest of
+// the fissile options), as checked out or at the git revision, if any.
+type DiffKubeOptions struct {
+	FromRoleManifest string
+	FromRevision     string
+	ToRoleManifest   string
+	ToRevision       string
+	// Settings are the export settings both versions are generated with;
+	// with render options, the helm chart is rendered instead of the plain
+	// Kubernetes configs
+	Settings kube.ExportSettings
+}
+
+// KubeDiff describes the differences between the resources generated for two
+// versions of a role manifest. Resources are named <kind>/<name>.
+type KubeDiff struct {
+	AddedResources   []string         `json:"addedResources" yaml:"addedResources"`
+	RemovedResources []string         `json:"removedResources" yaml:"removedResources"`
+	ChangedResources []ResourceChange `json:"changedResources" yaml:"changedResources"`
+}
+
+// ResourceChange describes a changed resource, with the paths of its changed
+// fields, e.g. spec.template.spec.containers[0].image
+type ResourceChange struct {
+	Resource string   `json:"resource" yaml:"resource"`
+	Paths    []string `json:"paths" yaml:"paths"`
+}
+
+var (
+	// runGitCommand runs git in the directory, returning its standard
+	// output; it is a stub to be replaced by the unit test
+	runGitCommand = func(dir string, args ...string) ([]byte, error) {
+		var stderr bytes.Buffer
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("Error running git %s: %v: %s",
+				strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+		}
+		return output, nil
+	}
+)
+
+// DiffKube generates the kube configs of two versions of the role manifest in
+// memory, and reports the resources added, removed and changed between them in
+// the output format, to review the effects of changes to the role manifest.
+// Both versions are loaded with the releases, opinions and other inputs of the
+// fissile options.
+func (f *Fissile) DiffKube(opt DiffKubeOptions) error {
+	if opt.FromRoleManifest == "" {
+		opt.FromRoleManifest = f.Options.RoleManifest
+	}
+	if opt.ToRoleManifest == "" {
+		opt.ToRoleManifest = f.Options.RoleManifest
+	}
+	if opt.FromRoleManifest == opt.ToRoleManifest && opt.FromRevision == opt.ToRevision {
+		return fmt.Errorf("Nothing to compare; specify two role manifests, or a git revision to compare the role manifest with")
+	}
+
+	from, err := f.generateKubeResources(opt.FromRoleManifest, opt.FromRevision, opt.Settings)
+	if err != nil {
+		return err
+	}
+	to, err := f.generateKubeResources(opt.ToRoleManifest, opt.ToRevision, opt.Settings)
+	if err != nil {
+		return err
+	}
+	return f.reportKubeDiff(diffKubeResources(from, to))
+}
+
+func (f *Fissile) reportKubeDiff(diff *KubeDiff) error {
+	switch f.Options.OutputFormat {
+	case OutputFormatHuman:
+		printResources := func(title string, resources []string) {
+			if len(resources) > 0 {
+				f.UI.Println(title)
+				for _, resource := range resources {
+					f.UI.Printf("  %s\n", resource)
+				}
+			}
+		}
+		printResources(color.GreenString("Added resources:"), diff.AddedResources)
+		printResources(color.RedString("Removed resources:"), diff.RemovedResources)
+		if len(diff.ChangedResources) > 0 {
+			f.UI.Println(color.BlueString("Changed resources:"))
+			for _, change := range diff.ChangedResources {
+				f.UI.Printf("  %s\n", change.Resource)
+				for _, path := range change.Paths {
+					f.UI.Printf("    %s\n", path)
+				}
+			}
+		}
+		f.UI.Printf("%d added, %d removed, %d changed\n",
+			len(diff.AddedResources), len(diff.RemovedResources), len(diff.ChangedResources))
+	case OutputFormatJSON:
+		buf, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		f.UI.Printf("%s\n", buf)
+	case OutputFormatYAML:
+		buf, err := yaml.Marshal(diff)
+		if err != nil {
+			return err
+		}
+		f.UI.Printf("%s", buf)
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, or yaml", f.Options.OutputFormat)
+	}
+	return nil
+}
+
+// generateKubeResources loads the role manifest, at the git revision if any,
+// and returns the resources generated for it, by <kind>/<name>. The loaded
+// role manifest is restored afterwards.
+func (f *Fissile) generateKubeResources(roleManifestPath, revision string, settings kube.ExportSettings) (map[string]interface{}, error) {
+	if revision != "" {
+		checkedOut, err := roleManifestAtRevision(roleManifestPath, revision)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(checkedOut)
+		roleManifestPath = checkedOut
+	}
+
+	savedPath, savedManifest := f.Options.RoleManifest, f.Manifest
+	defer func() { f.Options.RoleManifest, f.Manifest = savedPath, savedManifest }()
+	f.Options.RoleManifest = roleManifestPath
+	if err := f.LoadManifest(); err != nil {
+		return nil, err
+	}
+
+	documents := []kube.StreamDocument{}
+	f.capturedDocuments = &documents
+	defer func() { f.capturedDocuments = nil }()
+	// The stream is captured instead of written; streaming to standard
+	// output only keeps the rendering warnings off it
+	settings.StreamOutput = kube.StreamOutputStdout
+	if err := f.GenerateKube(settings); err != nil {
+		return nil, err
+	}
+	return parseKubeResources(documents)
+}
+
+// roleManifestAtRevision writes the role manifest at the git revision into a
+// temporary file next to it, so that the files it refers to are found, and
+// returns its path. The caller removes the file.
+func roleManifestAtRevision(roleManifestPath, revision string) (string, error) {
+	dir, name := filepath.Split(roleManifestPath)
+	if dir == "" {
+		dir = "."
+	}
+	contents, err := runGitCommand(dir, "show", fmt.Sprintf("%s:./%s", revision, name))
+	if err != nil {
+		return "", err
+	}
+	file, err := ioutil.TempFile(dir, fmt.Sprintf(".%s.*", name))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := file.Write(contents); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// parseKubeResources returns the resources of the documents by <kind>/<name>.
+// The items of lists are resources of their own.
+func parseKubeResources(documents []kube.StreamDocument) (map[string]interface{}, error) {
+	resources := map[string]interface{}{}
+	for _, document := range documents {
+		decoder := yaml.NewDecoder(bytes.NewReader(document.Content))
+		for {
+			var content interface{}
+			err := decoder.Decode(&content)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing the generated %s: %v", document.Kind, err)
+			}
+			object, ok := stringKeyedValue(content).(map[string]interface{})
+			if !ok {
+				continue
+			}
+			objects := []interface{}{object}
+			if object["kind"] == "List" {
+				objects, _ = object["items"].([]interface{})
+			}
+			for _, item := range objects {
+				item, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				metadata, _ := item["metadata"].(map[string]interface{})
+				resources[fmt.Sprintf("%v/%v", item["kind"], metadata["name"])] = item
+			}
+		}
+	}
+	return resources, nil
+}
+
+// diffKubeResources returns the differences between the resources
+func diffKubeResources(from, to map[string]interface{}) *KubeDiff {
+	diff := &KubeDiff{
+		AddedResources:   []string{},
+		RemovedResources: []string{},
+		ChangedResources: []ResourceChange{},
+	}
+	for _, name := range sortedKeys(from, to) {
+		fromResource, inFrom := from[name]
+		toResource, inTo := to[name]
+		switch {
+		case !inFrom:
+			diff.AddedResources = append(diff.AddedResources, name)
+		case !inTo:
+			diff.RemovedResources = append(diff.RemovedResources, name)
+		default:
+			var paths []string
+			diffKubeValues("", fromResource, toResource, &paths)
+			if len(paths) > 0 {
+				diff.ChangedResources = append(diff.ChangedResources, ResourceChange{
+					Resource: name,
+					Paths:    paths,
+				})
+			}
+		}
+	}
+	return diff
+}
+
+// diffKubeValues adds the paths of the differences between the values below
+// the path. Lists of different lengths are changed as a whole.
+func diffKubeValues(path string, from, to interface{}, paths *[]string) {
+	switch fromValue := from.(type) {
+	case map[string]interface{}:
+		toValue, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for _, key := range sortedKeys(fromValue, toValue) {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			fromElement, inFrom := fromValue[key]
+			toElement, inTo := toValue[key]
+			if inFrom != inTo {
+				*paths = append(*paths, keyPath)
+				continue
+			}
+			diffKubeValues(keyPath, fromElement, toElement, paths)
+		}
+		return
+	case []interface{}:
+		toValue, ok := to.([]interface{})
+		if !ok || len(fromValue) != len(toValue) {
+			break
+		}
+		for i := range fromValue {
+			diffKubeValues(fmt.Sprintf("%s[%d]", path, i), fromValue[i], toValue[i], paths)
+		}
+		return
+	}
+	if !reflect.DeepEqual(from, to) {
+		*paths = append(*paths, path)
+	}
+}
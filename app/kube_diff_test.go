@@ -0,0 +1,131 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffKubeValues(t *testing.T) {
+	t.Parallel()
+
+	from := map[string]interface{}{
+		"kind": "StatefulSet",
+		"spec": map[string]interface{}{
+			"replicas": 1,
+			"template": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"image": "nats:1", "ports": []interface{}{1, 2}},
+				},
+				"removed": true,
+			},
+		},
+	}
+	to := map[string]interface{}{
+		"kind": "StatefulSet",
+		"spec": map[string]interface{}{
+			"replicas": 2,
+			"template": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"image": "nats:2", "ports": []interface{}{1}},
+				},
+				"added": map[string]interface{}{"key": "value"},
+			},
+		},
+	}
+
+	var paths []string
+	diffKubeValues("", from, to, &paths)
+	assert.Equal(t, []string{
+		"spec.replicas",
+		"spec.template.added",
+		"spec.template.containers[0].image",
+		"spec.template.containers[0].ports",
+		"spec.template.removed",
+	}, paths)
+
+	paths = nil
+	diffKubeValues("", from, from, &paths)
+	assert.Empty(t, paths)
+}
+
+func TestDiffKube(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	manifestDir, err := ioutil.TempDir("", "fissile-test-diff-kube")
+	require.NoError(t, err)
+	defer os.RemoveAll(manifestDir)
+
+	manifest, err := ioutil.ReadFile(filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml"))
+	require.NoError(t, err)
+	fromPath := filepath.Join(manifestDir, "from.yml")
+	require.NoError(t, ioutil.WriteFile(fromPath, manifest, 0644))
+	script, err := ioutil.ReadFile(filepath.Join(workDir, "../test-assets/role-manifests/app/scripts/myrole.sh"))
+	require.NoError(t, err)
+	scriptPath := filepath.Join(manifestDir, "scripts", "myrole.sh")
+	require.NoError(t, os.Mkdir(filepath.Dir(scriptPath), 0755))
+	require.NoError(t, ioutil.WriteFile(scriptPath, script, 0644))
+
+	// The new version scales the first instance group, and drops the second
+	changed := string(manifest)
+	changed = strings.Replace(changed, "min: 1", "min: 2", 1)
+	changed = changed[:strings.Index(changed, "- name: myrole-clustered")]
+	toPath := filepath.Join(manifestDir, "to.yml")
+	require.NoError(t, ioutil.WriteFile(toPath, []byte(changed), 0644))
+
+	out := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, out, nil))
+	f.Options.RoleManifest = fromPath
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.OutputFormat = OutputFormatJSON
+
+	err = f.DiffKube(DiffKubeOptions{ToRoleManifest: toPath})
+	require.NoError(t, err)
+
+	var diff KubeDiff
+	require.NoError(t, json.Unmarshal(out.Bytes(), &diff), out.String())
+	assert.Empty(diff.AddedResources)
+	assert.Contains(diff.RemovedResources, "StatefulSet/myrole-clustered")
+	if assert.Len(diff.ChangedResources, 1) {
+		assert.Equal("StatefulSet/myrole-deployment", diff.ChangedResources[0].Resource)
+		assert.Contains(diff.ChangedResources[0].Paths, "spec.replicas")
+	}
+	assert.Equal(fromPath, f.Options.RoleManifest, "The role manifest should be restored")
+	assert.Nil(f.Manifest)
+
+	err = f.DiffKube(DiffKubeOptions{})
+	assert.EqualError(err, "Nothing to compare; specify two role manifests, or a git revision to compare the role manifest with")
+
+	// A git revision of the role manifest is checked out next to it
+	defer func(saved func(string, ...string) ([]byte, error)) { runGitCommand = saved }(runGitCommand)
+	var gitArgs []string
+	runGitCommand = func(dir string, args ...string) ([]byte, error) {
+		assert.Equal(manifestDir+"/", dir)
+		gitArgs = args
+		return []byte(changed), nil
+	}
+	out.Reset()
+	err = f.DiffKube(DiffKubeOptions{FromRevision: "HEAD~1"})
+	require.NoError(t, err)
+	assert.Equal([]string{"show", "HEAD~1:./from.yml"}, gitArgs)
+	diff = KubeDiff{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &diff), out.String())
+	assert.Contains(diff.AddedResources, "StatefulSet/myrole-clustered")
+	assert.Empty(diff.RemovedResources)
+
+	entries, err := ioutil.ReadDir(manifestDir)
+	require.NoError(t, err)
+	assert.Len(entries, 3, "The checked out role manifest should be removed")
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/fatih/color"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Formats of light opinions files
+const (
+	// OpinionsFormatTree is a tree of properties, as in a BOSH deployment manifest
+	OpinionsFormatTree = "tree"
+	// OpinionsFormatPatch is a JSON Patch (RFC 6902) against the spec defaults
+	OpinionsFormatPatch = "patch"
+)
+
+// MigrateOpinions converts the light opinions file to the given format, and
+// writes the result to outputPath. Opinions in patch form are applied over
+// the defaults of the job specs of the loaded releases.
+func (f *Fissile) MigrateOpinions(format, outputPath string) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+
+	light, err := model.ReadLightOpinions(f.Options.LightOpinions, model.SpecDefaults(f.Manifest.InstanceGroups))
+	if err != nil {
+		return err
+	}
+
+	var converted interface{}
+	switch format {
+	case OpinionsFormatTree:
+		converted = light
+	case OpinionsFormatPatch:
+		converted = model.OpinionsToPatch(light)
+	default:
+		return fmt.Errorf("Invalid opinions format %q; must be one of %q or %q", format, OpinionsFormatTree, OpinionsFormatPatch)
+	}
+
+	contents, err := yaml.Marshal(converted)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(outputPath, append([]byte("---\n"), contents...), 0644)
+	if err != nil {
+		return err
+	}
+
+	f.UI.Printf("Wrote opinions as %s to %s\n", format, color.CyanString(outputPath))
+	return nil
+}
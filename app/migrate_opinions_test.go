@@ -0,0 +1,55 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestMigrateOpinions(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	outDir, err := ioutil.TempDir("", "fissile-migrate-opinions-")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	ui := termui.New(&bytes.Buffer{}, &bytes.Buffer{}, nil)
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.LightOpinions = filepath.Join(workDir, "../test-assets/tor-opinions/opinions.yml")
+	f.Options.DarkOpinions = []string{filepath.Join(workDir, "../test-assets/tor-opinions/dark-opinions.yml")}
+	require.NoError(t, f.LoadManifest())
+
+	readFile := func(path string) interface{} {
+		contents, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		var result interface{}
+		require.NoError(t, yaml.Unmarshal(contents, &result))
+		return result
+	}
+
+	patchFile := filepath.Join(outDir, "patch.yml")
+	require.NoError(t, f.MigrateOpinions(OpinionsFormatPatch, patchFile))
+	assert.Equal(t, []interface{}{
+		map[interface{}]interface{}{"op": "add", "path": "/properties/ntp_conf", "value": "zip.conf"},
+		map[interface{}]interface{}{"op": "add", "path": "/properties/tor.client_keys", "value": "client_key_value"},
+	}, readFile(patchFile))
+
+	treeFile := filepath.Join(outDir, "tree.yml")
+	f.Options.LightOpinions = patchFile
+	require.NoError(t, f.MigrateOpinions(OpinionsFormatTree, treeFile))
+	assert.Equal(t, readFile(filepath.Join(workDir, "../test-assets/tor-opinions/opinions.yml")), readFile(treeFile))
+
+	assert.EqualError(t, f.MigrateOpinions("json", treeFile), `Invalid opinions format "json"; must be one of "tree" or "patch"`)
+}
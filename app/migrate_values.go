@@ -0,0 +1,204 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/kube"
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/fatih/color"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ValuesMigration summarizes the changes made while migrating a helm values
+// file to the current role manifest. All keys are dotted paths below .Values.
+type ValuesMigration struct {
+	Renamed map[string]string
+	Removed []string
+	Added   []string
+}
+
+// MigrateValues reads the helm values file at fromPath, written for an older
+// version of the chart, and writes an updated values file to outputPath.
+// Variables are renamed according to their previous names, values for
+// variables that no longer exist are dropped, and newly required variables
+// are either prompted for (if interactive) or added as empty placeholders.
+func (f *Fissile) MigrateValues(fromPath, outputPath string, interactive bool) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+
+	contents, err := ioutil.ReadFile(fromPath)
+	if err != nil {
+		return fmt.Errorf("Error reading values file %s: %v", fromPath, err)
+	}
+	values := map[string]interface{}{}
+	err = yaml.Unmarshal(contents, &values)
+	if err != nil {
+		return fmt.Errorf("Error parsing values file %s: %v", fromPath, err)
+	}
+
+	fill := func(required kube.RequiredValue) interface{} {
+		if !interactive {
+			return nil
+		}
+		description := ""
+		if required.Description != "" {
+			description = fmt.Sprintf(" (%s)", required.Description)
+		}
+		value := f.UI.Prompt("Value for %s%s", required.Key(), description)
+		if value == "" {
+			return nil
+		}
+		return value
+	}
+
+	migration, err := migrateValues(values, kube.ExportSettings{RoleManifest: f.Manifest}, fill)
+	if err != nil {
+		return err
+	}
+
+	contents, err = yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(outputPath, contents, 0644)
+	if err != nil {
+		return err
+	}
+
+	f.reportValuesMigration(migration)
+	f.UI.Printf("Wrote migrated values to %s\n", color.CyanString(outputPath))
+	return nil
+}
+
+func (f *Fissile) reportValuesMigration(migration *ValuesMigration) {
+	if len(migration.Renamed) > 0 {
+		f.UI.Println(color.BlueString("Renamed keys:"))
+		oldKeys := make([]string, 0, len(migration.Renamed))
+		for oldKey := range migration.Renamed {
+			oldKeys = append(oldKeys, oldKey)
+		}
+		sort.Strings(oldKeys)
+		for _, oldKey := range oldKeys {
+			f.UI.Printf("  %s -> %s\n", oldKey, migration.Renamed[oldKey])
+		}
+	}
+	if len(migration.Removed) > 0 {
+		f.UI.Println(color.RedString("Removed keys (no longer used by the chart):"))
+		for _, key := range migration.Removed {
+			f.UI.Printf("  %s\n", key)
+		}
+	}
+	if len(migration.Added) > 0 {
+		f.UI.Println(color.GreenString("Newly required keys:"))
+		for _, key := range migration.Added {
+			f.UI.Printf("  %s\n", key)
+		}
+	}
+}
+
+// migrateValues updates the env and secrets sections of the values map in
+// place. The fill function supplies values for required variables that have
+// not been set; returning nil leaves an empty placeholder.
+func migrateValues(values map[string]interface{}, settings kube.ExportSettings, fill func(kube.RequiredValue) interface{}) (*ValuesMigration, error) {
+	migration := &ValuesMigration{Renamed: map[string]string{}}
+
+	// Map all current and previous variable names to the current variable,
+	// skipping variables that cannot be set via values.yaml
+	current := model.MakeMapOfVariables(settings.RoleManifest)
+	for name, variable := range current {
+		if strings.HasPrefix(name, "KUBE_SIZING_") || variable.CVOptions.Type == model.CVTypeEnv ||
+			(variable.CVOptions.Immutable && variable.Type != "") {
+			delete(current, name)
+		}
+	}
+	variables := map[string]*model.VariableDefinition{}
+	for _, variable := range current {
+		for _, previousName := range variable.CVOptions.PreviousNames {
+			variables[previousName] = variable
+		}
+	}
+	for name, variable := range current {
+		variables[name] = variable
+	}
+
+	sections := map[string]map[interface{}]interface{}{}
+	for _, section := range []string{"env", "secrets"} {
+		sections[section] = map[interface{}]interface{}{}
+		if values[section] == nil {
+			continue
+		}
+		if _, ok := values[section].(map[interface{}]interface{}); !ok {
+			return nil, fmt.Errorf("Values key %s must be a map, found %T", section, values[section])
+		}
+	}
+
+	type oldValue struct {
+		section string
+		name    string
+		value   interface{}
+	}
+	var oldValues []oldValue
+	for _, section := range []string{"env", "secrets"} {
+		mapping, _ := values[section].(map[interface{}]interface{})
+		for name, value := range mapping {
+			oldValues = append(oldValues, oldValue{section, fmt.Sprintf("%v", name), value})
+		}
+	}
+	// Process current names first so they take precedence over previous names
+	isCurrent := func(name string) bool {
+		return variables[name] != nil && variables[name].Name == name
+	}
+	sort.Slice(oldValues, func(i, j int) bool {
+		iCurrent := isCurrent(oldValues[i].name)
+		jCurrent := isCurrent(oldValues[j].name)
+		if iCurrent != jCurrent {
+			return iCurrent
+		}
+		if oldValues[i].section != oldValues[j].section {
+			return oldValues[i].section < oldValues[j].section
+		}
+		return oldValues[i].name < oldValues[j].name
+	})
+
+	for _, old := range oldValues {
+		oldKey := old.section + "." + old.name
+		variable, ok := variables[old.name]
+		if !ok {
+			migration.Removed = append(migration.Removed, oldKey)
+			continue
+		}
+		newSection := "env"
+		if variable.CVOptions.Secret {
+			newSection = "secrets"
+		}
+		if _, ok := sections[newSection][variable.Name]; ok {
+			// The current name has already been set explicitly
+			migration.Removed = append(migration.Removed, oldKey)
+			continue
+		}
+		sections[newSection][variable.Name] = old.value
+		if newKey := newSection + "." + variable.Name; newKey != oldKey {
+			migration.Renamed[oldKey] = newKey
+		}
+	}
+
+	for _, required := range kube.RequiredValues(settings) {
+		if sections[required.Section][required.Name] != nil {
+			continue
+		}
+		sections[required.Section][required.Name] = fill(required)
+		migration.Added = append(migration.Added, required.Key())
+	}
+
+	for section, mapping := range sections {
+		values[section] = mapping
+	}
+	sort.Strings(migration.Removed)
+
+	return migration, nil
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/kube"
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func migrateValuesTestManifest() *model.RoleManifest {
+	return &model.RoleManifest{
+		Variables: model.Variables{
+			&model.VariableDefinition{
+				Name:      "NATS_USER",
+				CVOptions: model.CVOptions{PreviousNames: []string{"NATS_USR"}},
+			},
+			&model.VariableDefinition{
+				Name:      "NATS_PASSWORD",
+				CVOptions: model.CVOptions{Secret: true, PreviousNames: []string{"NATS_PASS"}},
+			},
+			&model.VariableDefinition{
+				Name:      "NEW_REQUIRED",
+				CVOptions: model.CVOptions{Required: true, Description: "Something new"},
+			},
+		},
+	}
+}
+
+func TestMigrateValues(t *testing.T) {
+	t.Parallel()
+
+	var values map[string]interface{}
+	err := yaml.Unmarshal([]byte(`
+env:
+  NATS_USR: nats
+  NATS_PASS: wrong-section
+  GONE: value
+secrets:
+  NATS_PASSWORD: explicit
+kube:
+  organization: example
+`), &values)
+	require.NoError(t, err)
+
+	var filled []string
+	fill := func(required kube.RequiredValue) interface{} {
+		filled = append(filled, required.Key())
+		return nil
+	}
+
+	migration, err := migrateValues(values, kube.ExportSettings{RoleManifest: migrateValuesTestManifest()}, fill)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"env.NATS_USR": "env.NATS_USER"}, migration.Renamed)
+	assert.Equal(t, []string{"env.GONE", "env.NATS_PASS"}, migration.Removed)
+	assert.Equal(t, []string{"env.NEW_REQUIRED"}, migration.Added)
+	assert.Equal(t, []string{"env.NEW_REQUIRED"}, filled)
+
+	env := values["env"].(map[interface{}]interface{})
+	assert.Equal(t, "nats", env["NATS_USER"])
+	assert.Contains(t, env, "NEW_REQUIRED")
+	assert.Nil(t, env["NEW_REQUIRED"])
+	assert.NotContains(t, env, "GONE")
+	assert.NotContains(t, env, "NATS_USR")
+
+	secrets := values["secrets"].(map[interface{}]interface{})
+	assert.Equal(t, "explicit", secrets["NATS_PASSWORD"])
+
+	assert.Equal(t, map[interface{}]interface{}{"organization": "example"}, values["kube"])
+}
+
+func TestMigrateValuesInvalidSection(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]interface{}{"env": "not a map"}
+	_, err := migrateValues(values, kube.ExportSettings{RoleManifest: migrateValuesTestManifest()}, nil)
+	assert.Error(t, err)
+}
+
+func TestMigrateValuesInteractive(t *testing.T) {
+	t.Parallel()
+
+	workDir, err := ioutil.TempDir("", "fissile-migrate-values")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	fromPath := filepath.Join(workDir, "old-values.yaml")
+	outputPath := filepath.Join(workDir, "values.yaml")
+	require.NoError(t, ioutil.WriteFile(fromPath, []byte("env:\n  NATS_USR: nats\n"), 0644))
+
+	ui := termui.New(bytes.NewBufferString("answer\n"), ioutil.Discard, nil)
+	f := NewFissileApplication(".", ui)
+	f.Manifest = migrateValuesTestManifest()
+
+	require.NoError(t, f.MigrateValues(fromPath, outputPath, true))
+
+	contents, err := ioutil.ReadFile(outputPath)
+	require.NoError(t, err)
+	var values map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(contents, &values))
+	assert.Equal(t, map[interface{}]interface{}{
+		"NATS_USER":    "nats",
+		"NEW_REQUIRED": "answer",
+	}, values["env"])
+}
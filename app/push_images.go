@@ -0,0 +1,225 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"code.cloudfoundry.org/fissile/builder"
+	"code.cloudfoundry.org/fissile/docker"
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/fatih/color"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	workerLib "github.com/jimmysawczuk/worker"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PushImagesOptions contains all option values for the `fissile push images` command.
+type PushImagesOptions struct {
+	// DigestsFile is the path of a YAML file to write the pushed digests to
+	DigestsFile string
+	Parallelism int
+	// Retries is the number of times a failed push is retried
+	Retries  int
+	Roles    []string
+	TagExtra string
+}
+
+// PushedImage records the digest of a pushed role image
+type PushedImage struct {
+	InstanceGroup string `json:"instance_group" yaml:"instance_group"`
+	Image         string `json:"image" yaml:"image"`
+	Digest        string `json:"digest" yaml:"digest"`
+}
+
+// imagePusher is the interface to shim around docker.ImageManager for the unit test
+type imagePusher interface {
+	PushImage(imageName string, auth dockerclient.AuthConfiguration, progress func(docker.PushProgress)) (string, error)
+}
+
+var (
+	// newImagePusher is a stub to be replaced by the unit test
+	newImagePusher = func() (imagePusher, error) { return docker.NewImageManager() }
+)
+
+// PushImages pushes the role images of the selected instance groups to the
+// docker registry, using up to opt.Parallelism concurrent pushes. Failed
+// pushes are retried; as the registry keeps the layers that were pushed
+// successfully, only the failed layers are uploaded again.
+func (f *Fissile) PushImages(opt PushImagesOptions) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+	if opt.Parallelism < 1 {
+		return fmt.Errorf("Invalid parallelism %d", opt.Parallelism)
+	}
+
+	instanceGroups, err := f.Manifest.SelectInstanceGroups(opt.Roles)
+	if err != nil {
+		return err
+	}
+	instanceGroups = instanceGroups.WithoutZoneReplicas()
+
+	opinions, err := f.LoadOpinions()
+	if err != nil {
+		return err
+	}
+
+	pusher, err := newImagePusher()
+	if err != nil {
+		return fmt.Errorf("Error connecting to docker: %v", err)
+	}
+
+	auth := dockerclient.AuthConfiguration{
+		Username:      f.Options.DockerUsername,
+		Password:      f.Options.DockerPassword,
+		ServerAddress: f.Options.DockerRegistry,
+	}
+
+	var mutex sync.Mutex
+	workerLib.MaxJobs = opt.Parallelism
+	worker := workerLib.NewWorker()
+	resultsCh := make(chan pushImageResult)
+	for _, instanceGroup := range instanceGroups {
+		devVersion, err := instanceGroup.GetRoleDevVersion(opinions, opt.TagExtra, f.Version, f)
+		if err != nil {
+			return err
+		}
+		imageName := builder.GetRoleDevImageName(f.Options.DockerRegistry, f.Options.DockerOrganization,
+			f.Options.RepositoryPrefix, instanceGroup, devVersion)
+
+		worker.Add(pushImageJob{
+			instanceGroup: instanceGroup,
+			imageName:     imageName,
+			retries:       opt.Retries,
+			pusher:        pusher,
+			auth:          auth,
+			ui:            f,
+			mutex:         &mutex,
+			resultsCh:     resultsCh,
+		})
+	}
+
+	go worker.RunUntilDone()
+
+	var pushed []PushedImage
+	var failed []string
+	for range instanceGroups {
+		result := <-resultsCh
+		if result.err != nil {
+			failed = append(failed, result.image.InstanceGroup)
+		} else {
+			pushed = append(pushed, result.image)
+		}
+	}
+
+	sort.Slice(pushed, func(i, j int) bool { return pushed[i].InstanceGroup < pushed[j].InstanceGroup })
+	if len(pushed) > 0 {
+		f.UI.Println(color.GreenString("Pushed images:"))
+		for _, image := range pushed {
+			f.UI.Printf("  %s@%s\n", image.Image, image.Digest)
+		}
+	}
+
+	if opt.DigestsFile != "" {
+		contents, err := yaml.Marshal(map[string][]PushedImage{"images": pushed})
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(opt.DigestsFile, contents, 0644)
+		if err != nil {
+			return fmt.Errorf("Error writing digests file %s: %v", opt.DigestsFile, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("Failed to push images for instance groups %v", failed)
+	}
+	return nil
+}
+
+// pushImageResult is the outcome of a pushImageJob
+type pushImageResult struct {
+	image PushedImage
+	err   error
+}
+
+// pushImageJob pushes a single role image, retrying on failure
+type pushImageJob struct {
+	instanceGroup *model.InstanceGroup
+	imageName     string
+	retries       int
+	pusher        imagePusher
+	auth          dockerclient.AuthConfiguration
+	ui            *Fissile
+	mutex         *sync.Mutex
+	resultsCh     chan<- pushImageResult
+}
+
+func (j pushImageJob) printf(format string, args ...interface{}) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.ui.UI.Printf(format, args...)
+}
+
+func (j pushImageJob) Run() {
+	result := PushedImage{InstanceGroup: j.instanceGroup.Name, Image: j.imageName}
+
+	var err error
+	for attempt := 0; attempt <= j.retries; attempt++ {
+		if attempt > 0 {
+			j.printf("%s: retrying push (attempt %d of %d) after error: %v\n",
+				color.YellowString(j.instanceGroup.Name), attempt+1, j.retries+1, err)
+		} else {
+			j.printf("%s: pushing %s\n", color.YellowString(j.instanceGroup.Name), j.imageName)
+		}
+		result.Digest, err = j.pusher.PushImage(j.imageName, j.auth, newLayerProgress(j))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		j.printf("%s: %s\n", color.YellowString(j.instanceGroup.Name), color.RedString("%v", err))
+	}
+	j.resultsCh <- pushImageResult{image: result, err: err}
+}
+
+// newLayerProgress returns a progress function printing the status changes of
+// each layer, and the upload progress in steps of 25%.
+func newLayerProgress(j pushImageJob) func(docker.PushProgress) {
+	lastStatus := map[string]string{}
+	lastQuarter := map[string]int64{}
+	return func(progress docker.PushProgress) {
+		if progress.Total > 0 {
+			quarter := 4 * progress.Current / progress.Total
+			if progress.Status == lastStatus[progress.Layer] && quarter == lastQuarter[progress.Layer] {
+				return
+			}
+			lastStatus[progress.Layer] = progress.Status
+			lastQuarter[progress.Layer] = quarter
+			j.printf("%s: layer %s %s %d%% of %s\n", color.YellowString(j.instanceGroup.Name),
+				progress.Layer, progress.Status, 25*quarter, formatBytes(progress.Total))
+			return
+		}
+		if progress.Status == lastStatus[progress.Layer] {
+			return
+		}
+		lastStatus[progress.Layer] = progress.Status
+		j.printf("%s: layer %s %s\n", color.YellowString(j.instanceGroup.Name), progress.Layer, progress.Status)
+	}
+}
+
+// formatBytes returns a human readable size
+func formatBytes(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/docker"
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/SUSE/termui"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// fakeImagePusher fails the first push of every image listed in failures
+type fakeImagePusher struct {
+	mutex    sync.Mutex
+	failures map[string]int
+	pushes   map[string]int
+}
+
+func (p *fakeImagePusher) PushImage(imageName string, auth dockerclient.AuthConfiguration, progress func(docker.PushProgress)) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.pushes[imageName]++
+	progress(docker.PushProgress{Layer: "abc", Status: "Pushing", Current: 60, Total: 100})
+	if p.failures[imageName] >= p.pushes[imageName] {
+		return "", fmt.Errorf("broken pipe")
+	}
+	progress(docker.PushProgress{Layer: "abc", Status: "Pushed"})
+	return "sha256:" + filepath.Base(imageName), nil
+}
+
+func TestPushImages(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "fissile-push-images")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	opinionsPath := filepath.Join(workDir, "opinions.yml")
+	require.NoError(t, ioutil.WriteFile(opinionsPath, []byte("{}"), 0644))
+	digestsPath := filepath.Join(workDir, "digests.yml")
+
+	output := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, output, nil))
+	f.Options.LightOpinions = opinionsPath
+	f.Options.DarkOpinions = []string{opinionsPath}
+	f.Options.DockerOrganization = "org"
+	f.Manifest = &model.RoleManifest{
+		InstanceGroups: model.InstanceGroups{
+			&model.InstanceGroup{Name: "flaky"},
+			&model.InstanceGroup{Name: "broken"},
+			&model.InstanceGroup{Name: "stable"},
+		},
+	}
+
+	var images []string
+	for _, instanceGroup := range f.Manifest.InstanceGroups {
+		devVersion, err := instanceGroup.GetRoleDevVersion(nil, "", f.Version, f)
+		require.NoError(t, err)
+		images = append(images, "org/"+instanceGroup.Name+":"+devVersion)
+	}
+
+	pusher := &fakeImagePusher{
+		failures: map[string]int{images[0]: 1, images[1]: 10},
+		pushes:   map[string]int{},
+	}
+	defer func(original func() (imagePusher, error)) { newImagePusher = original }(newImagePusher)
+	newImagePusher = func() (imagePusher, error) { return pusher, nil }
+
+	err = f.PushImages(PushImagesOptions{
+		DigestsFile: digestsPath,
+		Parallelism: 2,
+		Retries:     2,
+	})
+	assert.EqualError(t, err, "Failed to push images for instance groups [broken]")
+
+	assert.Equal(t, map[string]int{images[0]: 2, images[1]: 3, images[2]: 1}, pusher.pushes)
+	assert.Contains(t, output.String(), "flaky: layer abc Pushing 50% of 100.0 B")
+	assert.Contains(t, output.String(), "flaky: retrying push (attempt 2 of 3) after error: broken pipe")
+
+	contents, err := ioutil.ReadFile(digestsPath)
+	require.NoError(t, err)
+	var digests map[string][]PushedImage
+	require.NoError(t, yaml.Unmarshal(contents, &digests))
+	assert.Equal(t, []PushedImage{
+		{InstanceGroup: "flaky", Image: images[0], Digest: "sha256:" + filepath.Base(images[0])},
+		{InstanceGroup: "stable", Image: images[2], Digest: "sha256:" + filepath.Base(images[2])},
+	}, digests["images"])
+}
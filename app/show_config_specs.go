@@ -0,0 +1,58 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/fissile/builder"
+	"github.com/fatih/color"
+)
+
+// ShowConfigSpecsOptions contains all option values for the `fissile show config-specs` command.
+type ShowConfigSpecsOptions struct {
+	Role      string
+	OutputDir string
+	// LinkFixtures is the path of the file supplying sample link data
+	LinkFixtures string
+}
+
+// ShowConfigSpecs writes the config specs of the jobs of an instance group
+// (the properties, links, and networks the job templates are rendered with
+// by configgin) to <output dir>/<job>/config_spec.json, exactly as they are
+// written into the role image. Link fixtures, if given, are added to the
+// consumed links for rendering the templates locally.
+func (f *Fissile) ShowConfigSpecs(opt ShowConfigSpecsOptions) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+	instanceGroup := f.Manifest.LookupInstanceGroup(opt.Role)
+	if instanceGroup == nil {
+		return fmt.Errorf("Instance group %s not found", opt.Role)
+	}
+
+	fixtures, err := f.loadLinkFixtures(opt.LinkFixtures, instanceGroup)
+	if err != nil {
+		return err
+	}
+
+	for _, jobReference := range instanceGroup.JobReferences {
+		configJSON, err := jobReference.WriteConfigs(instanceGroup, f.Options.LightOpinions, f.Options.DarkOpinions, fixtures)
+		if err != nil {
+			return fmt.Errorf("Error writing the config spec of job %s: %v", jobReference.Name, err)
+		}
+
+		jobDir := filepath.Join(opt.OutputDir, jobReference.Name)
+		if err := os.MkdirAll(jobDir, 0755); err != nil {
+			return err
+		}
+		path := filepath.Join(jobDir, builder.JobConfigSpecFilename)
+		if err := ioutil.WriteFile(path, configJSON, 0644); err != nil {
+			return err
+		}
+		f.UI.Printf("Wrote config spec of job %s to %s\n", color.YellowString(jobReference.Name), color.CyanString(path))
+	}
+
+	return nil
+}
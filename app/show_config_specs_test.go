@@ -0,0 +1,108 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShowConfigSpecs(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.LightOpinions = filepath.Join(workDir, "../test-assets/tor-opinions/opinions.yml")
+	f.Options.DarkOpinions = []string{filepath.Join(workDir, "../test-assets/tor-opinions/dark-opinions.yml")}
+	require.NoError(t, f.LoadManifest())
+
+	outputDir, err := ioutil.TempDir("", "fissile-config-specs")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	assert.EqualError(t, f.ShowConfigSpecs(ShowConfigSpecsOptions{Role: "missing", OutputDir: outputDir}),
+		"Instance group missing not found")
+	require.NoError(t, f.ShowConfigSpecs(ShowConfigSpecsOptions{Role: "myrole-clustered", OutputDir: outputDir}))
+
+	contents, err := ioutil.ReadFile(filepath.Join(outputDir, "tor", "config_spec.json"))
+	require.NoError(t, err)
+	var config struct {
+		Job struct {
+			Name string `json:"name"`
+		} `json:"job"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	require.NoError(t, json.Unmarshal(contents, &config))
+	assert.Equal(t, "myrole-clustered", config.Job.Name)
+	assert.Contains(t, config.Properties, "tor")
+}
+
+func TestShowConfigSpecsLinkFixtures(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/ntp-links.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/ntp-release")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.LightOpinions = filepath.Join(workDir, "../test-assets/tor-opinions/opinions.yml")
+	f.Options.DarkOpinions = []string{filepath.Join(workDir, "../test-assets/tor-opinions/dark-opinions.yml")}
+	require.NoError(t, f.LoadManifest())
+
+	providerJob := f.Manifest.LookupInstanceGroup("ntp-server").LookupJob("ntpd").Job
+	provides := providerJob.AvailableProviders["ntp-server"]
+	provides.Properties = []string{"ntp_conf", "with.json.default"}
+	providerJob.AvailableProviders["ntp-server"] = provides
+
+	outputDir, err := ioutil.TempDir("", "fissile-config-specs")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	err = f.ShowConfigSpecs(ShowConfigSpecsOptions{
+		Role:         "ntp-client",
+		OutputDir:    outputDir,
+		LinkFixtures: filepath.Join(workDir, "../test-assets/link-fixtures/ntp-links-invalid.yml"),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "links.ntp-missing: Not found")
+
+	require.NoError(t, f.ShowConfigSpecs(ShowConfigSpecsOptions{
+		Role:         "ntp-client",
+		OutputDir:    outputDir,
+		LinkFixtures: filepath.Join(workDir, "../test-assets/link-fixtures/ntp-links.yml"),
+	}))
+
+	contents, err := ioutil.ReadFile(filepath.Join(outputDir, "ntpd", "config_spec.json"))
+	require.NoError(t, err)
+	var config struct {
+		Consumes map[string]struct {
+			Role    string                 `json:"role"`
+			Fixture map[string]interface{} `json:"fixture"`
+		} `json:"consumes"`
+	}
+	require.NoError(t, json.Unmarshal(contents, &config))
+	require.Contains(t, config.Consumes, "ntp-server")
+	link := config.Consumes["ntp-server"]
+	assert.Equal(t, "ntp-server", link.Role)
+	assert.Equal(t, "ntp.example.com", link.Fixture["address"])
+	assert.Len(t, link.Fixture["instances"], 2)
+	assert.Equal(t, map[string]interface{}{
+		"ntp_conf": "server ntp.example.com",
+		"with": map[string]interface{}{
+			"json": map[string]interface{}{
+				"default": map[string]interface{}{"other": "fixture"},
+			},
+		},
+	}, link.Fixture["properties"])
+}
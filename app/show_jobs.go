@@ -0,0 +1,334 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/util"
+	"github.com/fatih/color"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ShowJobsOptions contains all option values for the `fissile show jobs` command.
+type ShowJobsOptions struct {
+	Role string
+	// LinkFixtures is the path of the file supplying sample link data
+	LinkFixtures string
+}
+
+// renderedJob is the preview of a job of an instance group, as shown by
+// `fissile show jobs`
+type renderedJob struct {
+	Name    string `json:"name" yaml:"name"`
+	Release string `json:"release" yaml:"release"`
+	Monit   string `json:"monit" yaml:"monit"`
+	// Templates maps the destination paths to the rendered templates
+	Templates map[string]string `json:"templates" yaml:"templates"`
+}
+
+// templateRenderer renders the ERB template in templatePath with the BOSH
+// instance spec in specPath
+type templateRenderer func(specPath, templatePath string) (string, error)
+
+var (
+	// renderTemplate is a stub to be replaced by the unit test
+	renderTemplate templateRenderer = renderTemplateWithRuby
+)
+
+// jobRenderScript implements the subset of the BOSH template evaluation
+// context used by job templates: spec, name, index, p, if_p, link, and
+// if_link. It is called with the paths of the spec and the template.
+const jobRenderScript = `
+require 'erb'
+require 'json'
+require 'ostruct'
+
+module Properties
+  def lookup(name)
+    value = @properties
+    name.split('.').each do |key|
+      return [false, nil] unless value.is_a?(Hash) && value.key?(key)
+      value = value[key]
+    end
+    [!value.nil?, value]
+  end
+
+  def p(*args)
+    names = Array(args[0])
+    names.each do |name|
+      found, value = lookup(name)
+      return value if found
+    end
+    return args[1] if args.length > 1
+    raise "Can't find property '#{names.join("', or '")}'"
+  end
+
+  def if_p(*names)
+    values = names.map do |name|
+      found, value = lookup(name)
+      return ElseBlock.new(self, false) unless found
+      value
+    end
+    yield(*values)
+    ElseBlock.new(self, true)
+  end
+end
+
+class ElseBlock
+  def initialize(context, taken)
+    @context = context
+    @taken = taken
+  end
+
+  def else
+    yield unless @taken
+  end
+
+  def else_if_p(*names, &block)
+    @taken ? self : @context.if_p(*names, &block)
+  end
+end
+
+class Link
+  include Properties
+  attr_reader :instances, :address
+
+  def initialize(link)
+    @properties = link['properties'] || {}
+    @instances = (link['instances'] || []).map { |instance| OpenStruct.new(instance) }
+    @address = link['address']
+  end
+end
+
+class Context
+  include Properties
+  attr_reader :spec, :name, :index
+
+  def initialize(spec)
+    @spec = JSON.parse(JSON.generate(spec), object_class: OpenStruct)
+    @name = spec['name']
+    @index = spec['index']
+    @properties = spec['properties'] || {}
+    @links = spec['links'] || {}
+  end
+
+  def link(name)
+    raise "Can't find link '#{name}'" unless @links.key?(name)
+    Link.new(@links[name])
+  end
+
+  def if_link(name)
+    return ElseBlock.new(self, false) unless @links.key?(name)
+    yield Link.new(@links[name])
+    ElseBlock.new(self, true)
+  end
+
+  def render(template)
+    ERB.new(template, trim_mode: '-').result(binding)
+  end
+end
+
+print Context.new(JSON.parse(File.read(ARGV[0]))).render(File.read(ARGV[1]))
+`
+
+// ShowJobs renders the templates and monit files of the jobs of an instance
+// group locally, using the property defaults and opinions, and fake link
+// data, or the link fixtures if given. This requires ruby.
+func (f *Fissile) ShowJobs(opt ShowJobsOptions) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+	instanceGroup := f.Manifest.LookupInstanceGroup(opt.Role)
+	if instanceGroup == nil {
+		return fmt.Errorf("Instance group %s not found", opt.Role)
+	}
+
+	opinions, err := f.LoadOpinions()
+	if err != nil {
+		return err
+	}
+
+	fixtures, err := f.loadLinkFixtures(opt.LinkFixtures, instanceGroup)
+	if err != nil {
+		return err
+	}
+
+	tempDir, err := ioutil.TempDir("", "fissile-show-jobs")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	var jobs []renderedJob
+	for _, jobReference := range instanceGroup.JobReferences {
+		job, err := f.renderJob(instanceGroup, jobReference, opinions, fixtures, tempDir)
+		if err != nil {
+			return fmt.Errorf("Error rendering job %s: %v", jobReference.Name, err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	switch f.Options.OutputFormat {
+	case OutputFormatHuman:
+		for _, job := range jobs {
+			f.UI.Println(color.GreenString("Job %s (release %s)",
+				color.YellowString(job.Name), color.MagentaString(job.Release)))
+			f.UI.Println(color.CyanString("--- monit"))
+			f.UI.Println(job.Monit)
+
+			var destinations []string
+			for destination := range job.Templates {
+				destinations = append(destinations, destination)
+			}
+			sort.Strings(destinations)
+			for _, destination := range destinations {
+				f.UI.Println(color.CyanString("--- %s", destination))
+				f.UI.Println(job.Templates[destination])
+			}
+		}
+	case OutputFormatJSON:
+		buf, err := util.JSONMarshal(jobs)
+		if err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s", buf)
+	case OutputFormatYAML:
+		buf, err := yaml.Marshal(jobs)
+		if err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s", buf)
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, or yaml", f.Options.OutputFormat)
+	}
+
+	return nil
+}
+
+// loadLinkFixtures loads the link fixtures from the path, if set, and checks
+// them against the links consumed by the instance group
+func (f *Fissile) loadLinkFixtures(path string, instanceGroup *model.InstanceGroup) (*model.LinkFixtures, error) {
+	if path == "" {
+		return nil, nil
+	}
+	fixtures, err := model.LoadLinkFixtures(path)
+	if err != nil {
+		return nil, err
+	}
+	if errs := fixtures.Validate(f.Manifest, instanceGroup); len(errs) != 0 {
+		return nil, fmt.Errorf("Invalid link fixtures %s:\n%s", path, errs.Error())
+	}
+	return fixtures, nil
+}
+
+// renderJob renders the templates and the monit file of a job
+func (f *Fissile) renderJob(instanceGroup *model.InstanceGroup, jobReference *model.JobReference, opinions *model.Opinions, fixtures *model.LinkFixtures, tempDir string) (renderedJob, error) {
+	job := renderedJob{
+		Name:      jobReference.Name,
+		Release:   jobReference.Release.Name,
+		Templates: map[string]string{},
+	}
+
+	spec, err := f.jobRenderSpec(instanceGroup, jobReference, opinions, fixtures)
+	if err != nil {
+		return job, err
+	}
+	specJSON, err := util.JSONMarshal(spec)
+	if err != nil {
+		return job, err
+	}
+	specPath := filepath.Join(tempDir, jobReference.Name+"-spec.json")
+	if err := ioutil.WriteFile(specPath, specJSON, 0600); err != nil {
+		return job, err
+	}
+
+	jobDir, err := jobReference.Job.Extract(tempDir)
+	if err != nil {
+		return job, err
+	}
+	job.Monit, err = renderTemplate(specPath, filepath.Join(jobDir, "monit"))
+	if err != nil {
+		return job, fmt.Errorf("monit: %v", err)
+	}
+
+	for _, template := range jobReference.Templates {
+		templatePath := filepath.Join(jobDir, "templates", template.SourcePath)
+		destination := filepath.Join("/var/vcap/jobs", jobReference.Name, template.DestinationPath)
+		job.Templates[destination], err = renderTemplate(specPath, templatePath)
+		if err != nil {
+			return job, fmt.Errorf("%s: %v", template.SourcePath, err)
+		}
+	}
+
+	return job, nil
+}
+
+// jobRenderSpec returns the BOSH instance spec to render the templates of a
+// job with, for the first instance of the instance group. Each consumed link
+// is faked as a single instance of the providing instance group, with all
+// properties of the providing job, unless the link fixtures supply its data.
+func (f *Fissile) jobRenderSpec(instanceGroup *model.InstanceGroup, jobReference *model.JobReference, opinions *model.Opinions, fixtures *model.LinkFixtures) (map[string]interface{}, error) {
+	properties, err := jobReference.GetPropertiesForJob(opinions)
+	if err != nil {
+		return nil, err
+	}
+
+	links := map[string]interface{}{}
+	for _, consumes := range jobReference.ResolvedConsumes {
+		linkProperties := map[string]interface{}{}
+		if provider := f.Manifest.LookupInstanceGroup(consumes.RoleName); provider != nil {
+			if providerJob := provider.LookupJob(consumes.JobName); providerJob != nil {
+				linkProperties, err = providerJob.GetPropertiesForJob(opinions)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		links[consumes.Name] = fixtures.LinkSpec(consumes, linkProperties)
+	}
+
+	address := fmt.Sprintf("%s-0.%s-set", instanceGroup.Name, instanceGroup.Name)
+	return map[string]interface{}{
+		"deployment": "fissile",
+		"name":       instanceGroup.Name,
+		"job":        map[string]interface{}{"name": instanceGroup.Name},
+		"index":      0,
+		"id":         instanceGroup.Name + "-0",
+		"az":         "az0",
+		"bootstrap":  true,
+		"address":    address,
+		"ip":         "127.0.0.1",
+		"networks": map[string]interface{}{
+			"default": map[string]interface{}{
+				"ip":              "127.0.0.1",
+				"dns_record_name": address,
+			},
+		},
+		"properties": properties,
+		"links":      links,
+	}, nil
+}
+
+// renderTemplateWithRuby renders a template using the local ruby installation
+func renderTemplateWithRuby(specPath, templatePath string) (string, error) {
+	ruby, err := exec.LookPath("ruby")
+	if err != nil {
+		return "", fmt.Errorf("ruby is required to render job templates: %v", err)
+	}
+	cmd := exec.Command(ruby, "-e", jobRenderScript, specPath, templatePath)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShowJobs(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, buf, nil)
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.LightOpinions = filepath.Join(workDir, "../test-assets/tor-opinions/opinions.yml")
+	f.Options.DarkOpinions = []string{filepath.Join(workDir, "../test-assets/tor-opinions/dark-opinions.yml")}
+	require.NoError(t, f.LoadManifest())
+
+	assert.EqualError(t, f.ShowJobs(ShowJobsOptions{Role: "missing"}), "Instance group missing not found")
+
+	t.Run("Spec", func(t *testing.T) {
+		var spec map[string]interface{}
+		renderTemplate = func(specPath, templatePath string) (string, error) {
+			contents, err := ioutil.ReadFile(specPath)
+			if err != nil {
+				return "", err
+			}
+			require.NoError(t, json.Unmarshal(contents, &spec))
+			return filepath.Base(templatePath), nil
+		}
+		defer func() { renderTemplate = renderTemplateWithRuby }()
+
+		f.Options.OutputFormat = OutputFormatJSON
+		buf.Reset()
+		require.NoError(t, f.ShowJobs(ShowJobsOptions{Role: "myrole-deployment"}))
+
+		assert.Equal(t, "myrole-deployment", spec["name"])
+		assert.Equal(t, "myrole-deployment-0.myrole-deployment-set", spec["address"])
+		assert.Equal(t, "localhost", spec["properties"].(map[string]interface{})["tor"].(map[string]interface{})["hostname"])
+
+		var jobs []renderedJob
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &jobs))
+		require.Len(t, jobs, 1)
+		assert.Equal(t, "tor", jobs[0].Name)
+		assert.Equal(t, "monit", jobs[0].Monit)
+		assert.Equal(t, "torrc.erb", jobs[0].Templates["/var/vcap/jobs/tor/config/torrc"])
+	})
+
+	t.Run("Ruby", func(t *testing.T) {
+		if _, err := exec.LookPath("ruby"); err != nil {
+			t.Skip("ruby is not available")
+		}
+		f.Options.OutputFormat = OutputFormatHuman
+		buf.Reset()
+		require.NoError(t, f.ShowJobs(ShowJobsOptions{Role: "myrole-deployment"}))
+		assert.Contains(t, buf.String(), "/var/vcap/jobs/tor/config/torrc")
+	})
+}
+
+func TestShowJobsLinkFixtures(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	ui := termui.New(&bytes.Buffer{}, &bytes.Buffer{}, nil)
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/ntp-links.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/ntp-release")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.LightOpinions = filepath.Join(workDir, "../test-assets/tor-opinions/opinions.yml")
+	f.Options.DarkOpinions = []string{filepath.Join(workDir, "../test-assets/tor-opinions/dark-opinions.yml")}
+	f.Options.OutputFormat = OutputFormatJSON
+	require.NoError(t, f.LoadManifest())
+
+	var spec map[string]interface{}
+	renderTemplate = func(specPath, templatePath string) (string, error) {
+		contents, err := ioutil.ReadFile(specPath)
+		if err != nil {
+			return "", err
+		}
+		require.NoError(t, json.Unmarshal(contents, &spec))
+		return filepath.Base(templatePath), nil
+	}
+	defer func() { renderTemplate = renderTemplateWithRuby }()
+
+	t.Run("Fake", func(t *testing.T) {
+		require.NoError(t, f.ShowJobs(ShowJobsOptions{Role: "ntp-client"}))
+
+		link := spec["links"].(map[string]interface{})["ntp-server"].(map[string]interface{})
+		assert.Equal(t, "ntp-server-ntpd", link["address"])
+		require.Len(t, link["instances"], 1)
+		instance := link["instances"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "ntp-server-0", instance["id"])
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		err := f.ShowJobs(ShowJobsOptions{
+			Role:         "ntp-client",
+			LinkFixtures: filepath.Join(workDir, "../test-assets/link-fixtures/ntp-links-invalid.yml"),
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "links.ntp-missing: Not found")
+		assert.Contains(t, err.Error(), "links.ntp-server.properties.with.json.default: Invalid value")
+	})
+
+	t.Run("Fixtures", func(t *testing.T) {
+		providerJob := f.Manifest.LookupInstanceGroup("ntp-server").LookupJob("ntpd").Job
+		provides := providerJob.AvailableProviders["ntp-server"]
+		provides.Properties = []string{"ntp_conf", "with.json.default"}
+		providerJob.AvailableProviders["ntp-server"] = provides
+
+		require.NoError(t, f.ShowJobs(ShowJobsOptions{
+			Role:         "ntp-client",
+			LinkFixtures: filepath.Join(workDir, "../test-assets/link-fixtures/ntp-links.yml"),
+		}))
+
+		link := spec["links"].(map[string]interface{})["ntp-server"].(map[string]interface{})
+		assert.Equal(t, "ntp.example.com", link["address"])
+		properties := link["properties"].(map[string]interface{})
+		assert.Equal(t, "server ntp.example.com", properties["ntp_conf"])
+		assert.Equal(t, map[string]interface{}{
+			"json": map[string]interface{}{
+				"default": map[string]interface{}{"key": "value", "other": "fixture"},
+			},
+		}, properties["with"], "fixture properties should be merged over those of the provider")
+		assert.Contains(t, properties, "tor")
+		instances := link["instances"].([]interface{})
+		require.Len(t, instances, 2)
+		assert.Equal(t, map[string]interface{}{
+			"name":      "ntp-server",
+			"index":     float64(1),
+			"id":        "ntp-server-1",
+			"az":        "az1",
+			"address":   "10.0.0.2",
+			"bootstrap": false,
+		}, instances[1])
+	})
+}
@@ -0,0 +1,257 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"code.cloudfoundry.org/fissile/kube"
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/fatih/color"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ShowSizingOptions contains all option values for the `fissile show sizing` command.
+type ShowSizingOptions struct {
+	// ValuesFile is the helm values file of the profile to size; the chart
+	// defaults are used if empty
+	ValuesFile string
+	// NodeCPU is the allocatable CPU of a node, in millicores
+	NodeCPU float64
+	// NodeMemory is the allocatable memory of a node, in MiB
+	NodeMemory float64
+}
+
+// Sizing is the resource footprint of an instance group, or of all of them,
+// as shown by `fissile show sizing`. CPU is in millicores, memory in MiB, and
+// storage in G, matching the units of the sizing values.
+type Sizing struct {
+	Name          string  `json:"name" yaml:"name"`
+	Replicas      int     `json:"replicas" yaml:"replicas"`
+	CPURequest    float64 `json:"cpu_request" yaml:"cpu_request"`
+	CPULimit      float64 `json:"cpu_limit" yaml:"cpu_limit"`
+	MemoryRequest float64 `json:"memory_request" yaml:"memory_request"`
+	MemoryLimit   float64 `json:"memory_limit" yaml:"memory_limit"`
+	Storage       float64 `json:"storage" yaml:"storage"`
+}
+
+// SizingReport is the output of `fissile show sizing`
+type SizingReport struct {
+	InstanceGroups []Sizing `json:"instance_groups" yaml:"instance_groups"`
+	Total          Sizing   `json:"total" yaml:"total"`
+	// Nodes is the minimum number of nodes fitting the total requests
+	Nodes int `json:"nodes" yaml:"nodes"`
+}
+
+// sizingStatefulSet is the part of a rendered stateful set the sizing is
+// computed from
+type sizingStatefulSet struct {
+	Spec struct {
+		Replicas int `yaml:"replicas"`
+		Template struct {
+			Spec struct {
+				Containers []struct {
+					Resources struct {
+						Requests map[string]string `yaml:"requests"`
+						Limits   map[string]string `yaml:"limits"`
+					} `yaml:"resources"`
+				} `yaml:"containers"`
+			} `yaml:"spec"`
+		} `yaml:"template"`
+		VolumeClaimTemplates []struct {
+			Spec struct {
+				Resources struct {
+					Requests map[string]string `yaml:"requests"`
+				} `yaml:"resources"`
+			} `yaml:"spec"`
+		} `yaml:"volumeClaimTemplates"`
+	} `yaml:"spec"`
+}
+
+// ShowSizing prints the resource requests, limits and volume sizes of all
+// long-running instance groups of the helm chart, for the values of the
+// given profile, with their totals and an estimate of the number of nodes
+// required. The numbers are taken from the stateful sets rendered with the
+// internal template engine; errands only run briefly and are not included.
+func (f *Fissile) ShowSizing(opt ShowSizingOptions) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+	if opt.NodeCPU <= 0 || opt.NodeMemory <= 0 {
+		return fmt.Errorf("The node CPU and memory must be positive")
+	}
+
+	opinions, err := f.LoadOpinions()
+	if err != nil {
+		return err
+	}
+	settings := kube.ExportSettings{
+		RoleManifest:    f.Manifest,
+		Opinions:        opinions,
+		Registry:        f.Options.DockerRegistry,
+		Organization:    f.Options.DockerOrganization,
+		Repository:      f.Options.RepositoryPrefix,
+		FissileVersion:  f.Version,
+		UseMemoryLimits: true,
+		UseCPULimits:    true,
+		CreateHelmChart: true,
+		Render: &kube.RenderOptions{
+			ReleaseName:  "fissile",
+			Namespace:    "default",
+			ChartName:    "fissile",
+			ChartVersion: "0.0.0",
+		},
+	}
+	if opt.ValuesFile != "" {
+		settings.Render.Values, err = kube.ReadValuesFile(opt.ValuesFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	report, err := f.sizingReport(settings, opt)
+	if err != nil {
+		return err
+	}
+	return f.printSizingReport(report)
+}
+
+// sizingReport renders the stateful sets of the instance groups, and
+// aggregates their resources
+func (f *Fissile) sizingReport(settings kube.ExportSettings, opt ShowSizingOptions) (*SizingReport, error) {
+	renderer, err := kube.NewRenderer(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := renderSecretsTemplate(renderer, settings); err != nil {
+		return nil, err
+	}
+
+	report := &SizingReport{Total: Sizing{Name: "total"}}
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		if instanceGroup.IsColocated() || instanceGroup.Type != model.RoleTypeBosh {
+			continue
+		}
+		statefulSet, _, err := kube.NewStatefulSet(instanceGroup, settings, f)
+		if err != nil {
+			return nil, err
+		}
+		output, err := renderer.Render(fmt.Sprintf("templates/%s.yaml", instanceGroup.Name), statefulSet)
+		if err != nil {
+			return nil, err
+		}
+		if kube.IsEmptyRender(output) {
+			// The feature of the instance group is disabled
+			continue
+		}
+
+		sizing, err := instanceGroupSizing(instanceGroup.Name, output)
+		if err != nil {
+			return nil, err
+		}
+		report.InstanceGroups = append(report.InstanceGroups, sizing)
+		report.Total.Replicas += sizing.Replicas
+		report.Total.CPURequest += sizing.CPURequest
+		report.Total.CPULimit += sizing.CPULimit
+		report.Total.MemoryRequest += sizing.MemoryRequest
+		report.Total.MemoryLimit += sizing.MemoryLimit
+		report.Total.Storage += sizing.Storage
+	}
+
+	report.Nodes = int(math.Ceil(math.Max(
+		report.Total.CPURequest/opt.NodeCPU,
+		report.Total.MemoryRequest/opt.NodeMemory)))
+	return report, nil
+}
+
+// renderSecretsTemplate renders the secrets of the chart, which the pod
+// templates include for their config checksum
+func renderSecretsTemplate(renderer *kube.Renderer, settings kube.ExportSettings) error {
+	cvs := model.MakeMapOfVariables(settings.RoleManifest)
+	for key, value := range cvs {
+		if !value.CVOptions.Secret {
+			delete(cvs, key)
+		}
+	}
+	secrets, err := kube.MakeSecrets(cvs, settings)
+	if err != nil {
+		return err
+	}
+	_, err = renderer.Render("templates/secrets.yaml", secrets)
+	return err
+}
+
+// instanceGroupSizing sums the resources of all replicas of a rendered
+// stateful set
+func instanceGroupSizing(name string, output []byte) (Sizing, error) {
+	sizing := Sizing{Name: name}
+	var statefulSet sizingStatefulSet
+	if err := yaml.Unmarshal(output, &statefulSet); err != nil {
+		return sizing, fmt.Errorf("Error parsing the stateful set of %s: %v", name, err)
+	}
+	sizing.Replicas = statefulSet.Spec.Replicas
+
+	add := func(total *float64, quantity string, unit float64) error {
+		value, err := parseQuantity(quantity)
+		if err != nil {
+			return fmt.Errorf("Instance group %s: %v", name, err)
+		}
+		*total += float64(sizing.Replicas) * value / unit
+		return nil
+	}
+	for _, container := range statefulSet.Spec.Template.Spec.Containers {
+		resources := container.Resources
+		for _, err := range []error{
+			add(&sizing.CPURequest, resources.Requests["cpu"], 1e-3),
+			add(&sizing.CPULimit, resources.Limits["cpu"], 1e-3),
+			add(&sizing.MemoryRequest, resources.Requests["memory"], 1<<20),
+			add(&sizing.MemoryLimit, resources.Limits["memory"], 1<<20),
+		} {
+			if err != nil {
+				return sizing, err
+			}
+		}
+	}
+	for _, claim := range statefulSet.Spec.VolumeClaimTemplates {
+		if err := add(&sizing.Storage, claim.Spec.Resources.Requests["storage"], 1e9); err != nil {
+			return sizing, err
+		}
+	}
+	return sizing, nil
+}
+
+func (f *Fissile) printSizingReport(report *SizingReport) error {
+	switch f.Options.OutputFormat {
+	case OutputFormatHuman:
+		line := "%-30s %8v %12v %12v %14v %14v %12v\n"
+		f.UI.Printf(line, "INSTANCE GROUP", "REPLICAS", "CPU REQUEST", "CPU LIMIT",
+			"MEMORY REQUEST", "MEMORY LIMIT", "STORAGE")
+		printSizing := func(sizing Sizing) {
+			f.UI.Printf(line, sizing.Name, sizing.Replicas,
+				fmt.Sprintf("%.0fm", sizing.CPURequest), fmt.Sprintf("%.0fm", sizing.CPULimit),
+				fmt.Sprintf("%.0fMi", sizing.MemoryRequest), fmt.Sprintf("%.0fMi", sizing.MemoryLimit),
+				fmt.Sprintf("%.0fG", sizing.Storage))
+		}
+		for _, sizing := range report.InstanceGroups {
+			printSizing(sizing)
+		}
+		printSizing(report.Total)
+		f.UI.Printf("\nEstimated nodes: %s\n", color.CyanString("%d", report.Nodes))
+	case OutputFormatJSON:
+		buf, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		f.UI.Printf("%s\n", buf)
+	case OutputFormatYAML:
+		buf, err := yaml.Marshal(report)
+		if err != nil {
+			return err
+		}
+		f.UI.Printf("%s", buf)
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, or yaml", f.Options.OutputFormat)
+	}
+	return nil
+}
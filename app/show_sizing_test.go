@@ -0,0 +1,96 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShowSizing(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, output, nil))
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/two-roles.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.LightOpinions = filepath.Join(workDir, "../test-assets/tor-opinions/opinions.yml")
+	f.Options.DarkOpinions = []string{filepath.Join(workDir, "../test-assets/tor-opinions/dark-opinions.yml")}
+	f.Options.OutputFormat = OutputFormatJSON
+	require.NoError(t, f.LoadManifest())
+
+	valuesFile, err := ioutil.TempFile("", "fissile-show-sizing-values")
+	require.NoError(t, err)
+	defer os.Remove(valuesFile.Name())
+	_, err = valuesFile.WriteString(`
+config:
+  memory: {requests: true, limits: true}
+  cpu: {requests: true}
+sizing:
+  myrole_deployment:
+    count: 2
+    memory: {request: 512, limit: 1024}
+    cpu: {request: 1500}
+  myrole_clustered:
+    memory: {request: 3072}
+`)
+	require.NoError(t, err)
+	require.NoError(t, valuesFile.Close())
+
+	err = f.ShowSizing(ShowSizingOptions{ValuesFile: valuesFile.Name(), NodeCPU: 2000, NodeMemory: 2048})
+	require.NoError(t, err)
+
+	var report SizingReport
+	require.NoError(t, json.Unmarshal(output.Bytes(), &report))
+	assert.Equal(t, []Sizing{
+		{Name: "myrole-deployment", Replicas: 2, CPURequest: 3000, MemoryRequest: 1024, MemoryLimit: 2048},
+		{Name: "myrole-clustered", Replicas: 1, MemoryRequest: 3072},
+	}, report.InstanceGroups)
+	assert.Equal(t, Sizing{Name: "total", Replicas: 3, CPURequest: 3000, MemoryRequest: 4096, MemoryLimit: 2048}, report.Total)
+	assert.Equal(t, 2, report.Nodes, "Memory requests should need two nodes")
+
+	assert.EqualError(t, f.ShowSizing(ShowSizingOptions{NodeMemory: 2048}), "The node CPU and memory must be positive")
+}
+
+func TestInstanceGroupSizing(t *testing.T) {
+	sizing, err := instanceGroupSizing("myrole", []byte(`---
+kind: StatefulSet
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - resources:
+          requests: {cpu: 250m, memory: 1Gi}
+          limits: {memory: 2Gi}
+      - resources:
+          requests: {cpu: "1"}
+  volumeClaimTemplates:
+  - spec:
+      resources:
+        requests: {storage: 20G}
+  - spec:
+      resources:
+        requests: {storage: 5G}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, Sizing{
+		Name:          "myrole",
+		Replicas:      3,
+		CPURequest:    3750,
+		MemoryRequest: 3072,
+		MemoryLimit:   6144,
+		Storage:       75,
+	}, sizing)
+
+	_, err = instanceGroupSizing("myrole", []byte("spec: {replicas: 1, template: {spec: {containers: [{resources: {limits: {cpu: lots}}}]}}}"))
+	assert.EqualError(t, err, `Instance group myrole: Invalid quantity "lots"`)
+}
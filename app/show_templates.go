@@ -0,0 +1,94 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/util"
+	"github.com/fatih/color"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ShowTemplatesOptions contains all option values for the `fissile show templates` command.
+type ShowTemplatesOptions struct {
+	Role string
+}
+
+// parsedTemplate is a configuration template of an instance group, as shown
+// by `fissile show templates`
+type parsedTemplate struct {
+	Property  string   `json:"property" yaml:"property"`
+	Global    bool     `json:"global" yaml:"global"`
+	Variables []string `json:"variables" yaml:"variables"`
+	Error     string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ShowTemplates lists the configuration templates of an instance group, with
+// the variables they reference, and the errors parsing them, if any. It fails
+// if any template is invalid, after listing them all.
+func (f *Fissile) ShowTemplates(opt ShowTemplatesOptions) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+	instanceGroup := f.Manifest.LookupInstanceGroup(opt.Role)
+	if instanceGroup == nil {
+		return fmt.Errorf("Instance group %s not found", opt.Role)
+	}
+
+	templates := []parsedTemplate{}
+	invalid := 0
+	for _, template := range instanceGroup.ParseTemplates() {
+		parsed := parsedTemplate{
+			Property:  template.Property,
+			Global:    template.IsGlobal,
+			Variables: template.Variables,
+		}
+		if parsed.Variables == nil {
+			parsed.Variables = []string{}
+		}
+		if template.Err != nil {
+			parsed.Error = template.Err.Error()
+			invalid++
+		}
+		templates = append(templates, parsed)
+	}
+
+	switch f.Options.OutputFormat {
+	case OutputFormatHuman:
+		for _, template := range templates {
+			scope := "instance group"
+			if template.Global {
+				scope = "global"
+			}
+			f.UI.Printf("%s (%s)\n", color.YellowString(template.Property), scope)
+			if template.Error != "" {
+				f.UI.Printf("  %s %s\n", color.RedString("invalid:"), template.Error)
+			} else if len(template.Variables) == 0 {
+				f.UI.Println("  no variables")
+			} else {
+				f.UI.Printf("  variables: %s\n", color.CyanString(strings.Join(template.Variables, ", ")))
+			}
+		}
+	case OutputFormatJSON:
+		buf, err := util.JSONMarshal(templates)
+		if err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s", buf)
+	case OutputFormatYAML:
+		buf, err := yaml.Marshal(templates)
+		if err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s", buf)
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, or yaml", f.Options.OutputFormat)
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d of the %d templates of instance group %s are invalid", invalid, len(templates), opt.Role)
+	}
+	return nil
+}
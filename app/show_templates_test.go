@@ -0,0 +1,52 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShowTemplates(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, buf, nil)
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/tor-valid-instance-group-template-type.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	require.NoError(t, f.LoadManifest())
+
+	assert.EqualError(t, f.ShowTemplates(ShowTemplatesOptions{Role: "missing"}), "Instance group missing not found")
+
+	f.Options.OutputFormat = OutputFormatJSON
+	require.NoError(t, f.ShowTemplates(ShowTemplatesOptions{Role: "foorole"}))
+	var templates []parsedTemplate
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &templates))
+	assert.Equal(t, []parsedTemplate{
+		{Property: "properties.tor.hashed_control_password", Global: true, Variables: []string{"PELERINUL"}},
+		{Property: "properties.tor.hostname", Global: true, Variables: []string{"FOO"}},
+		{Property: "properties.tor.private_key", Global: true, Variables: []string{"BAR", "HOME"}},
+	}, templates)
+
+	instanceGroup := f.Manifest.LookupInstanceGroup("foorole")
+	instanceGroup.Configuration.Templates["properties.tor.client_keys"] = model.ConfigurationTemplate{Value: "a\n((#BAR))"}
+	buf.Reset()
+	assert.EqualError(t, f.ShowTemplates(ShowTemplatesOptions{Role: "foorole"}),
+		"1 of the 4 templates of instance group foorole are invalid")
+	templates = nil
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &templates))
+	assert.Equal(t, parsedTemplate{
+		Property:  "properties.tor.client_keys",
+		Variables: []string{},
+		Error:     "line 2: Section BAR has no closing tag",
+	}, templates[0])
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/util"
+	"github.com/fatih/color"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ShowVariableOptions contains all option values for the `fissile show variable` command.
+type ShowVariableOptions struct {
+	Name string
+}
+
+// defaultSourceRoleManifest is the source shown for defaults set by the role
+// manifest itself
+const defaultSourceRoleManifest = "role manifest"
+
+// shownVariable is a variable of the role manifest with the source of its
+// default, as shown by `fissile show variable`. The defaults of secrets are
+// not shown.
+type shownVariable struct {
+	Name    string      `json:"name" yaml:"name"`
+	Default interface{} `json:"default,omitempty" yaml:"default,omitempty"`
+	Secret  bool        `json:"secret,omitempty" yaml:"secret,omitempty"`
+	Source  string      `json:"source,omitempty" yaml:"source,omitempty"`
+}
+
+// ShowVariable lists the variables of the role manifest, or just the named
+// one, with their defaults and where they are set: by the role manifest, or
+// by one of the defaults files overriding it.
+func (f *Fissile) ShowVariable(opt ShowVariableOptions) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+
+	variables := []shownVariable{}
+	for _, cv := range f.Manifest.Variables {
+		if opt.Name != "" && cv.Name != opt.Name {
+			continue
+		}
+		variable := shownVariable{Name: cv.Name, Secret: cv.CVOptions.Secret}
+		if cv.CVOptions.Default != nil {
+			variable.Source = cv.CVOptions.DefaultSource
+			if variable.Source == "" {
+				variable.Source = defaultSourceRoleManifest
+			}
+			if !variable.Secret {
+				variable.Default = cv.CVOptions.Default
+			}
+		}
+		variables = append(variables, variable)
+	}
+	if opt.Name != "" && len(variables) == 0 {
+		return fmt.Errorf("Variable %s not found", opt.Name)
+	}
+
+	switch f.Options.OutputFormat {
+	case OutputFormatHuman:
+		for _, variable := range variables {
+			f.UI.Printf("%s", color.YellowString(variable.Name))
+			switch {
+			case variable.Source == "":
+				f.UI.Println(": no default")
+			case variable.Secret:
+				f.UI.Printf(": secret default from %s\n", color.CyanString(variable.Source))
+			default:
+				f.UI.Printf(" = %v (from %s)\n", variable.Default, color.CyanString(variable.Source))
+			}
+		}
+	case OutputFormatJSON:
+		buf, err := util.JSONMarshal(variables)
+		if err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s", buf)
+	case OutputFormatYAML:
+		buf, err := yaml.Marshal(variables)
+		if err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s", buf)
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, or yaml", f.Options.OutputFormat)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShowVariable(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, buf, nil)
+	f := NewFissileApplication(".", ui)
+	defaultsDir := filepath.Join(workDir, "../test-assets/defaults-files")
+	f.Options.RoleManifest = filepath.Join(defaultsDir, "role-manifest.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	staging := filepath.Join(defaultsDir, "staging.env")
+	f.Options.DefaultsFiles = []string{staging}
+	require.NoError(t, f.LoadManifest())
+
+	assert.EqualError(t, f.ShowVariable(ShowVariableOptions{Name: "MISSING"}), "Variable MISSING not found")
+
+	f.Options.OutputFormat = OutputFormatJSON
+	require.NoError(t, f.ShowVariable(ShowVariableOptions{}))
+	var variables []shownVariable
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &variables))
+	assert.Equal(t, []shownVariable{
+		{Name: "CLIENT_KEYS", Default: "alice", Source: "role manifest"},
+		{Name: "HOSTNAME", Default: "staging.onion", Source: staging},
+		{Name: "PASSWORD", Secret: true, Source: staging},
+		{Name: "PRIVATE_KEY", Default: "base-key",
+			Source: filepath.Join(defaultsDir, "base.yml")},
+	}, variables)
+
+	f.Options.OutputFormat = OutputFormatHuman
+	buf.Reset()
+	require.NoError(t, f.ShowVariable(ShowVariableOptions{Name: "HOSTNAME"}))
+	assert.Equal(t, "HOSTNAME = staging.onion (from "+staging+")\n", buf.String())
+}
@@ -0,0 +1,224 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/model/releaseresolver"
+	"code.cloudfoundry.org/fissile/util"
+	"github.com/fatih/color"
+)
+
+// Groupings of the jobs of a skeleton role manifest into instance groups
+const (
+	// ManifestGroupByJob generates one instance group per job
+	ManifestGroupByJob = "job"
+	// ManifestGroupByRelease generates one instance group per release, with
+	// all its jobs
+	ManifestGroupByRelease = "release"
+)
+
+// secretPropertyPattern matches the names of properties likely to be secrets
+var secretPropertyPattern = regexp.MustCompile(`(?i)(password|secret|token|private_key)`)
+
+// variableNameInvalidChars matches the characters of property names not
+// allowed in variable names
+var variableNameInvalidChars = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// skeletonInstanceGroup is an instance group of a skeleton role manifest
+type skeletonInstanceGroup struct {
+	name string
+	jobs []*model.Job
+}
+
+// ShowSkeletonManifestOptions contains all option values for the `fissile show skeleton-manifest` command.
+type ShowSkeletonManifestOptions struct {
+	// GroupBy is the grouping of the jobs into instance groups, one of
+	// ManifestGroupByJob or ManifestGroupByRelease
+	GroupBy string
+	// OutputFile is the path to write the skeleton to; it must not exist yet
+	OutputFile string
+}
+
+// ShowSkeletonManifest writes a skeleton role manifest for the loaded
+// releases to the output file. The jobs are grouped into instance groups as
+// requested; the properties of the jobs without defaults are declared as
+// required variables, described by the job specs. The run sections are
+// placeholders, marked as TODO.
+func (f *Fissile) ShowSkeletonManifest(opt ShowSkeletonManifestOptions) error {
+	groupBy, outputPath := opt.GroupBy, opt.OutputFile
+	if len(f.Options.Releases) == 0 {
+		return fmt.Errorf("Releases required to generate a role manifest")
+	}
+	if _, err := os.Stat(outputPath); err == nil {
+		return fmt.Errorf("Role manifest %s already exists; write the skeleton to another file with --output-file", outputPath)
+	}
+
+	releases, err := releaseresolver.LoadReleasesFromDisk(model.ReleaseOptions{
+		ReleasePaths:    f.Options.Releases,
+		ReleaseNames:    f.Options.ReleaseNames,
+		ReleaseVersions: f.Options.ReleaseVersions,
+		BOSHCacheDir:    f.Options.CacheDir,
+	})
+	if err != nil {
+		return err
+	}
+
+	skeleton, err := makeSkeletonManifest(releases, groupBy)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := helm.NewEncoder(&buf, helm.EmptyLines(true)).Encode(skeleton); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(outputPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	f.UI.Printf("Wrote skeleton role manifest to %s\n", color.CyanString(outputPath))
+	return nil
+}
+
+// makeSkeletonManifest returns the skeleton role manifest of the releases
+func makeSkeletonManifest(releases []*model.Release, groupBy string) (*helm.Mapping, error) {
+	groups, err := groupSkeletonJobs(releases, groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceGroups := helm.NewList()
+	for _, group := range groups {
+		jobs := helm.NewList()
+		for i, job := range group.jobs {
+			containerization := helm.NewMapping()
+			if i == 0 {
+				run := helm.NewMapping()
+				run.Add("scaling", helm.NewMapping("min", 1, "max", 1))
+				run.Add("memory", 256)
+				run.Add("virtual-cpus", 1)
+				containerization.Add("run", run,
+					helm.Comment("TODO: set the scaling, memory and CPUs of the instance group"))
+			}
+			containerization.Add("ports", helm.NewList(),
+				helm.Comment("TODO: list the ports the job listens on"))
+			jobs.Add(helm.NewMapping(
+				"name", job.Name,
+				"release", job.Release.Name,
+				"properties", helm.NewMapping("bosh_containerization", containerization)))
+		}
+		instanceGroups.Add(helm.NewMapping("name", group.name, "jobs", jobs))
+	}
+
+	variables, templates := skeletonVariables(groups)
+
+	var names []string
+	for _, release := range releases {
+		names = append(names, fmt.Sprintf("%s (%s)", release.Name, release.Version))
+	}
+	skeleton := helm.NewMapping()
+	skeleton.Set(helm.Comment(fmt.Sprintf("Skeleton role manifest of the releases %s, generated by fissile", strings.Join(names, ", "))))
+	skeleton.Add("instance_groups", instanceGroups)
+	skeleton.Add("configuration", helm.NewMapping("templates", templates),
+		helm.Comment("TODO: map the variables and the other properties the deployment needs to the job properties"))
+	skeleton.Add("variables", variables,
+		helm.Comment("The properties of the jobs without defaults; TODO: give them defaults, or generators for secrets"))
+	return skeleton, nil
+}
+
+// groupSkeletonJobs groups the jobs of the releases into instance groups
+func groupSkeletonJobs(releases []*model.Release, groupBy string) ([]skeletonInstanceGroup, error) {
+	var groups []skeletonInstanceGroup
+	switch groupBy {
+	case ManifestGroupByJob:
+		// Jobs of the same name in several releases are told apart by
+		// their release
+		releasesOfJob := map[string]int{}
+		for _, release := range releases {
+			for _, job := range release.Jobs {
+				releasesOfJob[job.Name]++
+			}
+		}
+		for _, release := range releases {
+			for _, job := range release.Jobs {
+				name := job.Name
+				if releasesOfJob[job.Name] > 1 {
+					name = release.Name + "-" + job.Name
+				}
+				groups = append(groups, skeletonInstanceGroup{name: util.ConvertNameToKey(name), jobs: []*model.Job{job}})
+			}
+		}
+	case ManifestGroupByRelease:
+		for _, release := range releases {
+			if len(release.Jobs) > 0 {
+				groups = append(groups, skeletonInstanceGroup{name: util.ConvertNameToKey(release.Name), jobs: release.Jobs})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Invalid grouping %q; must be one of %q or %q", groupBy, ManifestGroupByJob, ManifestGroupByRelease)
+	}
+	return groups, nil
+}
+
+// skeletonVariables returns the required variables of the jobs, i.e. those
+// for their properties without defaults, and the templates mapping them to
+// the properties
+func skeletonVariables(groups []skeletonInstanceGroup) (*helm.List, *helm.Mapping) {
+	descriptions := map[string]string{}
+	for _, group := range groups {
+		for _, job := range group.jobs {
+			for _, property := range job.Properties {
+				if property.Default != nil {
+					continue
+				}
+				if descriptions[property.Name] == "" {
+					descriptions[property.Name] = strings.TrimSpace(property.Description)
+				}
+			}
+		}
+	}
+	var properties []string
+	for name := range descriptions {
+		properties = append(properties, name)
+	}
+	sort.Strings(properties)
+
+	variables := helm.NewList()
+	templates := helm.NewMapping()
+	used := map[string]bool{}
+	for _, property := range properties {
+		// Properties differing only in their special characters, like
+		// a.b and a_b, are told apart by a suffix
+		name := skeletonVariableName(property)
+		for suffix := 2; used[name]; suffix++ {
+			name = fmt.Sprintf("%s_%d", skeletonVariableName(property), suffix)
+		}
+		used[name] = true
+		description := descriptions[property]
+		if description == "" {
+			description = fmt.Sprintf("TODO: describe %s", property)
+		}
+		options := helm.NewMapping("description", description, "required", true)
+		if secretPropertyPattern.MatchString(property) {
+			options.Add("secret", true)
+		}
+		variables.Add(helm.NewMapping("name", name, "options", options))
+		templates.Add("properties."+property, fmt.Sprintf(`"((%s))"`, name))
+	}
+	return variables, templates
+}
+
+// skeletonVariableName returns the name of the variable of a job property,
+// e.g. NATS_PASSWORD for nats.password
+func skeletonVariableName(property string) string {
+	name := variableNameInvalidChars.ReplaceAllString(strings.ToUpper(property), "_")
+	return strings.Trim(name, "_")
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShowSkeletonManifest(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	outDir, err := ioutil.TempDir("", "fissile-skeleton-manifest-")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	ui := termui.New(&bytes.Buffer{}, &bytes.Buffer{}, nil)
+	f := NewFissileApplication(".", ui)
+	f.Options.Releases = []string{
+		filepath.Join(workDir, "../test-assets/tor-boshrelease"),
+		filepath.Join(workDir, "../test-assets/ntp-release"),
+	}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+
+	// The skeleton is a valid role manifest
+	f.Options.RoleManifest = filepath.Join(outDir, "role-manifest.yml")
+	require.NoError(t, f.ShowSkeletonManifest(ShowSkeletonManifestOptions{GroupBy: ManifestGroupByJob, OutputFile: f.Options.RoleManifest}))
+	require.NoError(t, f.LoadManifest())
+
+	var names []string
+	for _, instanceGroup := range f.Manifest.InstanceGroups {
+		names = append(names, instanceGroup.Name)
+	}
+	assert.Equal(t, []string{"tor", "hashmat", "new-hostname", "ntpd"}, names)
+
+	var variable *model.VariableDefinition
+	for _, v := range f.Manifest.Variables {
+		if v.Name == "TOR_PRIVATE_KEY" {
+			variable = v
+		}
+	}
+	if assert.NotNil(t, variable) {
+		assert.Equal(t, "The private key for this hidden service.", variable.CVOptions.Description)
+		assert.True(t, variable.CVOptions.Required)
+		assert.True(t, variable.CVOptions.Secret)
+	}
+	assert.Equal(t, `"((TOR_PRIVATE_KEY))"`, f.Manifest.Configuration.Templates["properties.tor.private_key"].Value)
+
+	contents, err := ioutil.ReadFile(f.Options.RoleManifest)
+	require.NoError(t, err)
+	assert.Contains(t, string(contents), "# TODO: set the scaling, memory and CPUs of the instance group")
+
+	err = f.ShowSkeletonManifest(ShowSkeletonManifestOptions{GroupBy: ManifestGroupByJob, OutputFile: f.Options.RoleManifest})
+	assert.EqualError(t, err, "Role manifest "+f.Options.RoleManifest+" already exists; write the skeleton to another file with --output-file")
+
+	f.Options.RoleManifest = filepath.Join(outDir, "by-release.yml")
+	require.NoError(t, f.ShowSkeletonManifest(ShowSkeletonManifestOptions{GroupBy: ManifestGroupByRelease, OutputFile: f.Options.RoleManifest}))
+	require.NoError(t, f.LoadManifest())
+	names = nil
+	for _, instanceGroup := range f.Manifest.InstanceGroups {
+		names = append(names, instanceGroup.Name)
+	}
+	assert.Equal(t, []string{"tor", "ntp"}, names)
+	assert.Len(t, f.Manifest.InstanceGroups[0].JobReferences, 3)
+
+	err = f.ShowSkeletonManifest(ShowSkeletonManifestOptions{GroupBy: "vm", OutputFile: filepath.Join(outDir, "by-vm.yml")})
+	assert.EqualError(t, err, `Invalid grouping "vm"; must be one of "job" or "release"`)
+}
+
+func TestSkeletonVariablesUniqueNames(t *testing.T) {
+	job := &model.Job{Properties: []*model.JobProperty{
+		{Name: "nats.user"},
+		{Name: "nats_user"},
+		{Name: "nats-user"},
+		{Name: "nats.port", Default: 4222},
+	}}
+	variables, templates := skeletonVariables([]skeletonInstanceGroup{{name: "nats", jobs: []*model.Job{job}}})
+
+	var names []string
+	for _, variable := range variables.Values() {
+		names = append(names, variable.(*helm.Mapping).Get("name").String())
+	}
+	assert.Equal(t, []string{"NATS_USER", "NATS_USER_2", "NATS_USER_3"}, names)
+	assert.Equal(t, `"((NATS_USER_2))"`, templates.Get("properties.nats.user").String())
+}
@@ -1,11 +1,15 @@
 package app
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"sort"
 	"strings"
 
 	"code.cloudfoundry.org/fissile/model"
 	"code.cloudfoundry.org/fissile/validation"
+	"github.com/fatih/color"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -28,16 +32,183 @@ func (f *Fissile) Validate() validation.ErrorList {
 	return allErrs
 }
 
+// ValidateValues checks a user supplied helm values file against the role
+// manifest and prints a warning for every deprecated variable that is set.
+// The values of variables with validation rules must follow them.
+func (f *Fissile) ValidateValues(valuesPath string) error {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+
+	contents, err := ioutil.ReadFile(valuesPath)
+	if err != nil {
+		return fmt.Errorf("Error reading values file %s: %v", valuesPath, err)
+	}
+	values := map[string]interface{}{}
+	err = yaml.Unmarshal(contents, &values)
+	if err != nil {
+		return fmt.Errorf("Error parsing values file %s: %v", valuesPath, err)
+	}
+
+	for _, cv := range f.Manifest.Variables {
+		if cv.CVOptions.Deprecated == nil {
+			continue
+		}
+		section := "env"
+		if cv.CVOptions.Secret {
+			section = "secrets"
+		}
+		mapping, _ := values[section].(map[interface{}]interface{})
+		if mapping[cv.Name] == nil {
+			continue
+		}
+		f.UI.Println(color.YellowString("Warning: %s.%s is set in %s; %s",
+			section, cv.Name, valuesPath, cv.CVOptions.Deprecated.Notice(cv.Name)))
+	}
+
+	allErrs := f.validateValuesVariables(values).WithCode(validation.CodeValuesVariables)
+	allErrs = append(allErrs, f.validateValuesProperties(values["properties"]).WithCode(validation.CodeValuesProperties)...)
+	if len(allErrs) > 0 {
+		return allErrs
+	}
+	return nil
+}
+
+// validateValuesVariables checks the values of the variables against their
+// validation rules; the values of secrets are not included in the errors.
+func (f *Fissile) validateValuesVariables(values map[string]interface{}) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	for _, cv := range f.Manifest.Variables {
+		if cv.CVOptions.Validation == nil {
+			continue
+		}
+		section := "env"
+		if cv.CVOptions.Secret {
+			section = "secrets"
+		}
+		mapping, _ := values[section].(map[interface{}]interface{})
+		value := mapping[cv.Name]
+		if value == nil {
+			continue
+		}
+		if err := cv.CVOptions.Validation.Check(value); err != nil {
+			if cv.CVOptions.Secret {
+				value = "redacted"
+			}
+			allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("%s.%s", section, cv.Name), value, err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// validateValuesProperties checks that the job property overrides under
+// .Values.properties.<instance group>.<job> refer to existing instance groups,
+// jobs, and properties declared in the job specs.
+func (f *Fissile) validateValuesProperties(properties interface{}) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	if properties == nil {
+		return allErrs
+	}
+
+	groups, ok := properties.(map[interface{}]interface{})
+	if !ok {
+		return append(allErrs, validation.Invalid("properties", properties, "Expected a map of instance groups"))
+	}
+	for groupKey, jobsValue := range groups {
+		groupName := fmt.Sprintf("%v", groupKey)
+		instanceGroup := f.Manifest.LookupInstanceGroup(groupName)
+		if instanceGroup == nil {
+			allErrs = append(allErrs, validation.NotFound(fmt.Sprintf("properties.%s", groupName),
+				fmt.Sprintf("instance group %s not found", groupName)))
+			continue
+		}
+		jobs, ok := jobsValue.(map[interface{}]interface{})
+		if !ok {
+			allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("properties.%s", groupName), jobsValue, "Expected a map of jobs"))
+			continue
+		}
+		for jobKey, propertiesValue := range jobs {
+			jobName := fmt.Sprintf("%v", jobKey)
+			field := fmt.Sprintf("properties.%s.%s", groupName, jobName)
+			var job *model.Job
+			for _, jobReference := range instanceGroup.JobReferences {
+				if jobReference.Name == jobName {
+					job = jobReference.Job
+				}
+			}
+			if job == nil {
+				allErrs = append(allErrs, validation.NotFound(field,
+					fmt.Sprintf("job %s not found in instance group %s", jobName, groupName)))
+				continue
+			}
+			for _, name := range model.FlattenPropertyNames("", propertiesValue) {
+				if !jobHasProperty(job, name) {
+					allErrs = append(allErrs, validation.NotFound(field+"."+name,
+						fmt.Sprintf("property %s not found in the spec of job %s", name, jobName)))
+				}
+			}
+		}
+	}
+
+	sort.Slice(allErrs, func(i, j int) bool { return allErrs[i].Field < allErrs[j].Field })
+	return allErrs
+}
+
+// jobHasProperty checks if the dotted property name is declared in the job
+// spec, either directly, as part of a hash valued property, or as the parent
+// of declared properties.
+func jobHasProperty(job *model.Job, name string) bool {
+	for _, property := range job.Properties {
+		if property.Name == name ||
+			strings.HasPrefix(name, property.Name+".") ||
+			strings.HasPrefix(property.Name, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportValidationErrors prints the validation errors in the selected output
+// format. The human readable output is grouped by instance group or top-level
+// key, and includes the error codes.
+func (f *Fissile) ReportValidationErrors(errs validation.ErrorList) error {
+	switch f.Options.OutputFormat {
+	case OutputFormatHuman:
+		names, groups := errs.Groups()
+		for _, name := range names {
+			f.UI.Println(color.YellowString("%s:", name))
+			for _, err := range groups[name] {
+				if err.Warning {
+					f.UI.Printf("  %s %s\n", color.YellowString("[%s]", err.Code()), err.Error())
+				} else {
+					f.UI.Printf("  %s %s\n", color.RedString("[%s]", err.Code()), err.Error())
+				}
+			}
+		}
+	case OutputFormatJSON:
+		buf, err := json.MarshalIndent(errs, "", "  ")
+		if err != nil {
+			return err
+		}
+		f.UI.Printf("%s\n", buf)
+	default:
+		return fmt.Errorf("Invalid output format '%s' for validation errors, expected one of human or json", f.Options.OutputFormat)
+	}
+
+	return nil
+}
+
 type validator struct {
 	errOut        chan<- *validation.Error
 	f             *Fissile
 	lightOpinions map[string]string
 	darkOpinions  map[string]string
+	darkConflicts validation.ErrorList
 	variableUsage map[string]int
 }
 
 func newValidator(f *Fissile, errOut chan<- *validation.Error) (*validator, *validation.Error) {
-	opinions, err := model.NewOpinions(f.Options.LightOpinions, f.Options.DarkOpinions)
+	opinions, err := f.LoadOpinions()
 	if err != nil {
 		return nil, validation.GeneralError("Light are dark opinions could not be read", err)
 	}
@@ -47,6 +218,7 @@ func newValidator(f *Fissile, errOut chan<- *validation.Error) (*validator, *val
 		f:             f,
 		lightOpinions: model.FlattenOpinions(opinions.Light, false),
 		darkOpinions:  model.FlattenOpinions(opinions.Dark, false),
+		darkConflicts: opinions.DarkConflicts,
 		variableUsage: make(map[string]int),
 	}, nil
 }
@@ -87,6 +259,9 @@ func (v *validator) validate() {
 	// No dark opinions must have defaults in light opinions
 	v.checkForDarkInTheLight()
 
+	// Layered dark opinions files must not disagree
+	v.checkForDarkOpinionConflicts()
+
 	// No duplicates must exist between role manifest and light
 	// opinions
 	v.checkForDuplicatesBetweenManifestAndLight()
@@ -105,8 +280,8 @@ func (v *validator) validate() {
 		propertyDefaults := instanceGroup.CollectPropertyDefaults()
 		for propertyName, templateDef := range instanceGroup.Configuration.Templates {
 			if !templateDef.IsGlobal {
-				v.checkForUndefinedProperty(label, propertyName, propertyDefaults)
-				v.checkForUndefinedVariable(label, propertyName, templateDef.Value)
+				v.checkForUndefinedProperty(templateLabel(label, templateDef), propertyName, propertyDefaults)
+				v.checkForUndefinedVariable(templateLabel(label, templateDef), propertyName, templateDef.Value)
 			}
 		}
 		v.checkForSortedProperties(
@@ -130,7 +305,66 @@ func (v *validator) validate() {
 		if variableUsageCount == 0 {
 			v.errOut <- validation.NotFound(
 				"variables",
-				fmt.Sprintf("No templates using '%s'", variableName))
+				fmt.Sprintf("No templates using '%s'", variableName)).WithCode(validation.CodeUnusedVariable)
+		}
+	}
+	v.checkDeploymentManifestReferences()
+	v.checkClusterDomainAssumptions()
+
+	// Site policies
+	v.checkValidatorPlugins()
+}
+
+// deploymentManifestPath is where the deployment-manifest secret is mounted
+// into the containers
+const deploymentManifestPath = "/opt/fissile/config/deployment-manifest.yml"
+
+// checkDeploymentManifestReferences warns about instance groups not mounting
+// the deployment manifest, with job templates referencing it anyway
+func (v *validator) checkDeploymentManifestReferences() {
+	for _, instanceGroup := range v.f.Manifest.InstanceGroups {
+		if !instanceGroup.HasTag(model.RoleTagNoDeploymentManifest) {
+			continue
+		}
+		for _, jobReference := range instanceGroup.JobReferences {
+			for _, template := range jobReference.Templates {
+				if !strings.Contains(template.Content, deploymentManifestPath) {
+					continue
+				}
+				err := validation.Invalid(
+					fmt.Sprintf("instance_groups[%s].jobs[%s]", instanceGroup.Name, jobReference.Name),
+					template.SourcePath,
+					fmt.Sprintf("Template references %s, which is not mounted for instance groups tagged %s",
+						deploymentManifestPath, model.RoleTagNoDeploymentManifest)).WithCode(validation.CodeDeploymentManifestMount)
+				err.Warning = true
+				v.errOut <- err
+			}
+		}
+	}
+}
+
+// defaultClusterDomain is the default cluster domain of Kubernetes, which
+// clusters may change
+const defaultClusterDomain = "cluster.local"
+
+// checkClusterDomainAssumptions warns about variable defaults and light
+// opinions assuming the default cluster domain; URLs of services should use a
+// url template, or KUBERNETES_CLUSTER_DOMAIN, instead.
+func (v *validator) checkClusterDomainAssumptions() {
+	for _, cv := range v.f.Manifest.Variables {
+		if ok, value := cv.Value(); ok && strings.Contains(value, defaultClusterDomain) {
+			err := validation.Invalid(fmt.Sprintf("variables[%s].options.default", cv.Name), value,
+				fmt.Sprintf("Default assumes the cluster domain %s; use a url_template instead", defaultClusterDomain)).WithCode(validation.CodeClusterDomain)
+			err.Warning = true
+			v.errOut <- err
+		}
+	}
+	for property, opinion := range v.lightOpinions {
+		if strings.Contains(opinion, defaultClusterDomain) {
+			err := validation.Invalid(property, opinion,
+				fmt.Sprintf("Light opinion assumes the cluster domain %s; use a template with KUBERNETES_CLUSTER_DOMAIN instead", defaultClusterDomain)).WithCode(validation.CodeClusterDomain)
+			err.Warning = true
+			v.errOut <- err
 		}
 	}
 }
@@ -145,7 +379,7 @@ func (v *validator) checkForSortedProperties(label string, propertyOrder yaml.Ma
 			if key < previous {
 				v.errOut <- validation.Forbidden(
 					fmt.Sprintf("%s[%s]", label, previous),
-					fmt.Sprintf("Template key does not sort before '%s'", key))
+					fmt.Sprintf("Template key does not sort before '%s'", key)).WithCode(validation.CodeUnsortedTemplates)
 			}
 		}
 		previous = key
@@ -176,7 +410,7 @@ func (v *validator) checkForUndefinedProperty(label, propertyName string, knownP
 
 		v.errOut <- validation.NotFound(
 			fmt.Sprintf("%s[%s]", label, propertyName),
-			"In any used BOSH job")
+			"In any used BOSH job").WithCode(validation.CodeUndefinedProperty)
 	}
 }
 
@@ -217,10 +451,10 @@ func (v *validator) checkForSortedVariables(variables model.Variables) {
 		if cv.Name < previousName {
 			v.errOut <- validation.Invalid("variables",
 				previousName,
-				fmt.Sprintf("Does not sort before '%s'", cv.Name))
+				fmt.Sprintf("Does not sort before '%s'", cv.Name)).WithCode(validation.CodeUnsortedVariables)
 		} else if cv.Name == previousName {
 			v.errOut <- validation.Invalid("variables",
-				previousName, "Appears more than once")
+				previousName, "Appears more than once").WithCode(validation.CodeUnsortedVariables)
 		}
 		previousName = cv.Name
 	}
@@ -239,7 +473,7 @@ func (v *validator) checkForUndefinedVariable(label, propertyName, templateValue
 		if _, ok := v.variableUsage[variable]; !ok {
 			v.errOut <- validation.NotFound(
 				fmt.Sprintf("%s[%s]", label, propertyName),
-				fmt.Sprintf("No declaration of variable '%s'", variable))
+				fmt.Sprintf("No declaration of variable '%s'", variable)).WithCode(validation.CodeUndefinedVariable)
 		}
 		// Unconditionally increment the usage counter; for variables that
 		// are undeclared, this means we won't emit the same warning twice.
@@ -252,7 +486,7 @@ func (v *validator) checkForUndefinedVariable(label, propertyName, templateValue
 func (v *validator) checkForUntemplatedDarkOpinions() {
 	for property := range v.darkOpinions {
 		if _, ok := v.f.Manifest.Configuration.Templates[property]; !ok {
-			v.errOut <- validation.NotFound(property, "Dark opinion is missing template in role-manifest")
+			v.errOut <- validation.NotFound(property, "Dark opinion is missing template in role-manifest").WithCode(validation.CodeUntemplatedDarkOpinion)
 		}
 	}
 
@@ -263,11 +497,28 @@ func (v *validator) checkForUntemplatedDarkOpinions() {
 func (v *validator) checkForDarkInTheLight() {
 	for property := range v.darkOpinions {
 		if _, ok := v.lightOpinions[property]; ok {
-			v.errOut <- validation.Forbidden(property, "Dark opinion found in light opinions")
+			v.errOut <- validation.Forbidden(property, "Dark opinion found in light opinions").WithCode(validation.CodeDarkOpinionInLight)
 		}
 	}
 }
 
+// checkForDarkOpinionConflicts reports all dark opinions which are given
+// differently by several dark opinions files
+func (v *validator) checkForDarkOpinionConflicts() {
+	for _, conflict := range v.darkConflicts {
+		v.errOut <- conflict.WithCode(validation.CodeDarkOpinionConflict)
+	}
+}
+
+// templateLabel returns the label for errors about a template; templates
+// not written in the role manifest are labelled with their source instead
+func templateLabel(label string, template model.ConfigurationTemplate) string {
+	if template.Source != "" {
+		return template.Source
+	}
+	return label
+}
+
 // checkTemplateInvalidExpansion reports all templates with syntax errors
 func (v *validator) checkTemplateInvalidExpansion() {
 	// seenGlobalProperties keeps track of which global properties we've already
@@ -285,11 +536,12 @@ func (v *validator) checkTemplateInvalidExpansion() {
 				seenGlobalProperties[property] = struct{}{}
 				prefix = "configuration.templates"
 			}
+			prefix = templateLabel(prefix, template)
 			if _, err := model.ParseTemplate(template.Value); err != nil {
 				v.errOut <- validation.Invalid(
 					fmt.Sprintf("%s[%s]", prefix, property),
 					template.Value,
-					fmt.Sprintf("Template expansion error: %v", err))
+					fmt.Sprintf("Template expansion error: %v", err)).WithCode(validation.CodeTemplateExpansion)
 			}
 		}
 	}
@@ -321,14 +573,16 @@ func (v *validator) checkForDuplicatesBetweenManifestAndLight() {
 					// The role manifest _could_ override the opinion, if the
 					// opinion has literal values that needs to be run through
 					// mustache.
+					// Templates failing to parse are reported by
+					// checkTemplateInvalidExpansion
 					varsInTemplate, err := model.ParseTemplate(template.Value)
-					if err != nil || len(varsInTemplate) == 0 {
+					if err == nil && len(varsInTemplate) == 0 {
 						v.errOut <- validation.Forbidden(fmt.Sprintf("%s[%s]", prefix, property),
-							"Role-manifest duplicates opinion, remove from manifest")
+							"Role-manifest duplicates opinion, remove from manifest").WithCode(validation.CodeDuplicateOpinion)
 					}
 				} else if template.IsGlobal {
 					v.errOut <- validation.Forbidden(fmt.Sprintf("%s[%s]", prefix, property),
-						"Role-manifest overrides opinion, remove opinion")
+						"Role-manifest overrides opinion, remove opinion").WithCode(validation.CodeDuplicateOpinion)
 				}
 			}
 		}
@@ -357,7 +611,7 @@ func (v *validator) checkLightDefaults(propertyDefaults model.PropertyDefaults)
 
 		if _, ok := pInfo.Defaults[opinion]; ok {
 			v.errOut <- validation.Forbidden(property,
-				fmt.Sprintf("Light opinion matches default of '%v'", opinion))
+				fmt.Sprintf("Light opinion matches default of '%v'", opinion)).WithCode(validation.CodeLightOpinionDefault)
 		}
 	}
 }
@@ -379,7 +633,7 @@ func (v *validator) checkNonConstantTemplates() {
 		if len(varsInTemplate) == 0 {
 			v.errOut <- validation.Forbidden(
 				fmt.Sprintf("configuration.templates[%s]", key),
-				"Templates used as constants are not allowed")
+				"Templates used as constants are not allowed").WithCode(validation.CodeConstantTemplate)
 		}
 	}
 }
@@ -2,6 +2,7 @@ package app
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -9,6 +10,8 @@ import (
 	"sort"
 	"testing"
 
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/validation"
 	"github.com/SUSE/termui"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -81,7 +84,7 @@ func TestValidation(t *testing.T) {
 				f.Options.RoleManifest = roleManifestPath
 				f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
 				f.Options.LightOpinions = lightOpinions.Name()
-				f.Options.DarkOpinions = darkOpinions.Name()
+				f.Options.DarkOpinions = []string{darkOpinions.Name()}
 				f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
 
 				err = f.LoadManifest()
@@ -136,6 +139,31 @@ func TestInvalidTemplateKeys(t *testing.T) {
 	assert.Contains(t, err.Error(), `global template key: Invalid value: 1: Template key must be a string`)
 }
 
+func TestRuntimeConfigTemplateErrors(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	runtimeConfigPath := filepath.Join(workDir, "../test-assets/runtime-configs/tor-addons-undefined.yml")
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/validation/tor-validation-ok.yml")
+	f.Options.Releases = append(f.Options.Releases, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.LightOpinions = filepath.Join(workDir, "../test-assets/tor-opinions/opinions.yml")
+	f.Options.DarkOpinions = []string{filepath.Join(workDir, "../test-assets/tor-opinions/dark-opinions.yml")}
+	f.Options.RuntimeConfigs = []string{runtimeConfigPath}
+
+	require.NoError(t, f.LoadManifest())
+	errs := f.Validate()
+
+	source := "runtime_config[" + runtimeConfigPath + "].addons[tor-settings]"
+	assert.Contains(t, errs.ErrorStrings(),
+		source+"[properties.tor.client_keys]: Not found: \"No declaration of variable 'UNDECLARED'\"")
+	assert.Contains(t, errs.ErrorStrings(),
+		source+"[properties.tor.no_such_property]: Not found: \"In any used BOSH job\"")
+}
+
 func TestBadScriptReferences(t *testing.T) {
 	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
 
@@ -215,3 +243,214 @@ func TestNonStringInstanceGroupTemplate(t *testing.T) {
 	err = f.LoadManifest()
 	assert.NoError(t, err)
 }
+
+func TestValidateValuesDeprecated(t *testing.T) {
+	t.Parallel()
+
+	workDir, err := ioutil.TempDir("", "fissile-validate-values")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	valuesPath := filepath.Join(workDir, "values.yaml")
+	require.NoError(t, ioutil.WriteFile(valuesPath, []byte("env:\n  OLD: value\n  UNSET: ~\n"), 0644))
+
+	output := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, output, nil)
+	f := NewFissileApplication(".", ui)
+	f.Manifest = &model.RoleManifest{
+		Variables: model.Variables{
+			&model.VariableDefinition{
+				Name:      "OLD",
+				CVOptions: model.CVOptions{Deprecated: &model.CVDeprecation{Message: "Use NEW instead"}},
+			},
+			&model.VariableDefinition{
+				Name:      "UNSET",
+				CVOptions: model.CVOptions{Deprecated: &model.CVDeprecation{}},
+			},
+		},
+	}
+
+	require.NoError(t, f.ValidateValues(valuesPath))
+	assert.Contains(t, output.String(), "env.OLD is set in "+valuesPath+"; OLD is deprecated: Use NEW instead")
+	assert.NotContains(t, output.String(), "UNSET")
+}
+
+func TestValidateValuesVariables(t *testing.T) {
+	t.Parallel()
+
+	workDir, err := ioutil.TempDir("", "fissile-validate-values")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	valuesPath := filepath.Join(workDir, "values.yaml")
+	values := "env:\n  PORT: 80\n  LOG_LEVEL: info\nsecrets:\n  TOKEN: short\n"
+	require.NoError(t, ioutil.WriteFile(valuesPath, []byte(values), 0644))
+
+	ui := termui.New(&bytes.Buffer{}, &bytes.Buffer{}, nil)
+	f := NewFissileApplication(".", ui)
+	min := 1024.0
+	f.Manifest = &model.RoleManifest{
+		Variables: model.Variables{
+			&model.VariableDefinition{
+				Name:      "PORT",
+				CVOptions: model.CVOptions{Validation: &model.CVValidation{Type: model.CVValidationTypeInteger, Min: &min}},
+			},
+			&model.VariableDefinition{
+				Name:      "LOG_LEVEL",
+				CVOptions: model.CVOptions{Validation: &model.CVValidation{Enum: []string{"debug", "info"}}},
+			},
+			&model.VariableDefinition{
+				Name:      "TOKEN",
+				CVOptions: model.CVOptions{Secret: true, Validation: &model.CVValidation{Pattern: `.{16,}`}},
+			},
+		},
+	}
+
+	err = f.ValidateValues(valuesPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `env.PORT: Invalid value: 80: must be at least 1024`)
+	assert.Contains(t, err.Error(), `secrets.TOKEN: Invalid value: "redacted": must match .{16,}`)
+	assert.NotContains(t, err.Error(), "LOG_LEVEL")
+	assert.NotContains(t, err.Error(), "short")
+	for _, item := range err.(validation.ErrorList) {
+		assert.Equal(t, validation.CodeValuesVariables, item.Code())
+	}
+}
+
+func TestValidateValuesProperties(t *testing.T) {
+	t.Parallel()
+
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	f := NewFissileApplication(".", ui)
+	job := &model.Job{
+		Name: "tor",
+		Properties: []*model.JobProperty{
+			&model.JobProperty{Name: "tor.hostname"},
+			&model.JobProperty{Name: "tor.hashed_control_password"},
+			&model.JobProperty{Name: "tor.extra"},
+		},
+	}
+	f.Manifest = &model.RoleManifest{
+		InstanceGroups: model.InstanceGroups{
+			&model.InstanceGroup{
+				Name:          "myrole",
+				JobReferences: model.JobReferences{&model.JobReference{Name: "tor", Job: job}},
+			},
+		},
+	}
+
+	var values map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+properties:
+  myrole:
+    tor:
+      tor:
+        hostname: example.com
+        extra:
+          nested: allowed
+        unknown: value
+    missing-job: {}
+  missing-role: {}
+`), &values))
+
+	errs := f.validateValuesProperties(values["properties"])
+	actual := []string{}
+	for _, err := range errs {
+		actual = append(actual, err.Error())
+	}
+	assert.Equal(t, []string{
+		`properties.missing-role: Not found: "instance group missing-role not found"`,
+		`properties.myrole.missing-job: Not found: "job missing-job not found in instance group myrole"`,
+		`properties.myrole.tor.tor.unknown: Not found: "property tor.unknown not found in the spec of job tor"`,
+	}, actual)
+
+	assert.Empty(t, f.validateValuesProperties(nil))
+}
+
+func TestReportValidationErrors(t *testing.T) {
+	t.Parallel()
+
+	errs := validation.ErrorList{
+		validation.Invalid("variables", "FOO", "bad"),
+		validation.NotFound("instance_groups[nats].run.memory", "x"),
+	}
+
+	t.Run("human", func(t *testing.T) {
+		t.Parallel()
+		output := &bytes.Buffer{}
+		f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, output, nil))
+		f.Options.OutputFormat = OutputFormatHuman
+
+		require.NoError(t, f.ReportValidationErrors(errs))
+		assert.Equal(t, "instance_groups[nats]:\n"+
+			"  [FISSILE-V001] instance_groups[nats].run.memory: Not found: \"x\"\n"+
+			"variables:\n"+
+			"  [FISSILE-V004] variables: Invalid value: \"FOO\": bad\n",
+			output.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		output := &bytes.Buffer{}
+		f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, output, nil))
+		f.Options.OutputFormat = OutputFormatJSON
+
+		require.NoError(t, f.ReportValidationErrors(errs))
+		var actual []map[string]interface{}
+		require.NoError(t, json.Unmarshal(output.Bytes(), &actual))
+		require.Len(t, actual, 2)
+		assert.Equal(t, "FISSILE-V004", actual[0]["code"])
+		assert.Equal(t, "FISSILE-V001", actual[1]["code"])
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		t.Parallel()
+		f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+		f.Options.OutputFormat = OutputFormatYAML
+		assert.Error(t, f.ReportValidationErrors(errs))
+	})
+}
+
+func TestDeploymentManifestReferences(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	opinions, err := ioutil.TempFile("", "fissile-deployment-manifest-*.yml")
+	require.NoError(t, err)
+	defer os.Remove(opinions.Name())
+	_, err = opinions.WriteString("properties: {}\n")
+	require.NoError(t, err)
+	require.NoError(t, opinions.Close())
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/validation/tor-validation-ok.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.LightOpinions = opinions.Name()
+	f.Options.DarkOpinions = []string{opinions.Name()}
+
+	err = f.LoadManifest()
+	require.NoError(t, err)
+
+	instanceGroup := f.Manifest.LookupInstanceGroup("myrole")
+	require.NotNil(t, instanceGroup)
+	instanceGroup.Tags = append(instanceGroup.Tags, model.RoleTagNoDeploymentManifest)
+	assert.Empty(t, f.Validate(), "Tag should be accepted without templates reading the manifest")
+
+	job := instanceGroup.JobReferences[0].Job
+	job.Templates = append(job.Templates, &model.JobTemplate{
+		SourcePath: "manifest.erb",
+		Job:        job,
+		Content:    "cat /opt/fissile/config/deployment-manifest.yml",
+	})
+
+	errs := f.Validate()
+	require.Len(t, errs, 1)
+	assert.True(t, errs[0].Warning)
+	assert.Equal(t, `instance_groups[myrole].jobs[new_hostname]: Invalid value: "manifest.erb": `+
+		`Template references /opt/fissile/config/deployment-manifest.yml, which is not mounted for instance groups tagged no-deployment-manifest`,
+		errs[0].Error())
+	assert.Empty(t, errs.Failures())
+}
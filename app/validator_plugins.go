@@ -0,0 +1,131 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/util"
+	"code.cloudfoundry.org/fissile/validation"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// validatorPluginResult is the output of a validator plugin
+type validatorPluginResult struct {
+	Errors   []validatorPluginError `json:"errors"`
+	Warnings []validatorPluginError `json:"warnings"`
+}
+
+// validatorPluginError is an error or warning reported by a validator plugin
+type validatorPluginError struct {
+	Code   string      `json:"code"`
+	Field  string      `json:"field"`
+	Value  interface{} `json:"value"`
+	Detail string      `json:"detail"`
+}
+
+// checkValidatorPlugins runs the validator plugins of the options. Each one
+// is an executable receiving the loaded role manifest as JSON on its standard
+// input, and writing the site policy violations it found as JSON to its
+// standard output:
+//
+//	{"errors": [{"code": "ACME-001", "field": "instance_groups[nats]", "detail": "..."}],
+//	 "warnings": [...]}
+func (v *validator) checkValidatorPlugins() {
+	if len(v.f.Options.ValidatorPlugins) == 0 {
+		return
+	}
+
+	input, err := v.f.validatorPluginInput()
+	if err != nil {
+		v.errOut <- validation.InternalError("validator_plugins", err).WithCode(validation.CodeValidatorPlugin)
+		return
+	}
+
+	for _, plugin := range v.f.Options.ValidatorPlugins {
+		field := fmt.Sprintf("validator_plugins[%s]", plugin)
+		result, err := runValidatorPlugin(plugin, input)
+		if err != nil {
+			v.errOut <- validation.InternalError(field, err).WithCode(validation.CodeValidatorPlugin)
+			continue
+		}
+		for _, pluginErr := range result.Errors {
+			v.errOut <- pluginErr.toValidationError(field, false)
+		}
+		for _, pluginErr := range result.Warnings {
+			v.errOut <- pluginErr.toValidationError(field, true)
+		}
+	}
+}
+
+// toValidationError converts the plugin error; errors without a field are
+// reported for the plugin itself
+func (e validatorPluginError) toValidationError(pluginField string, warning bool) *validation.Error {
+	field := e.Field
+	if field == "" {
+		field = pluginField
+	}
+	return validation.PluginError(e.Code, field, e.Value, e.Detail, warning)
+}
+
+// runValidatorPlugin runs the plugin executable on the input and parses its
+// output
+func runValidatorPlugin(plugin string, input []byte) (*validatorPluginResult, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(plugin)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Error running validator plugin: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var result validatorPluginResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("Error parsing the output of the validator plugin: %v", err)
+	}
+	return &result, nil
+}
+
+// validatorPluginInput returns the JSON document describing the loaded role
+// manifest passed to the validator plugins. It has the layout of the role
+// manifest, with the resolved run properties of each instance group added.
+func (f *Fissile) validatorPluginInput() ([]byte, error) {
+	manifest := struct {
+		InstanceGroups []interface{}   `yaml:"instance_groups"`
+		Configuration  interface{}     `yaml:"configuration"`
+		Variables      interface{}     `yaml:"variables"`
+		Features       map[string]bool `yaml:"features"`
+	}{
+		Configuration: f.Manifest.Configuration,
+		Variables:     f.Manifest.Variables,
+		Features:      f.Manifest.Features,
+	}
+
+	for _, instanceGroup := range f.Manifest.InstanceGroups {
+		// Round trip through YAML to get the layout of the role manifest
+		contents, err := yaml.Marshal(instanceGroup)
+		if err != nil {
+			return nil, fmt.Errorf("Error serializing instance group %s: %v", instanceGroup.Name, err)
+		}
+		var data map[interface{}]interface{}
+		if err := yaml.Unmarshal(contents, &data); err != nil {
+			return nil, fmt.Errorf("Error serializing instance group %s: %v", instanceGroup.Name, err)
+		}
+		data["run"] = instanceGroup.Run
+		manifest.InstanceGroups = append(manifest.InstanceGroups, data)
+	}
+
+	contents, err := yaml.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("Error serializing the role manifest: %v", err)
+	}
+	var data interface{}
+	if err := yaml.Unmarshal(contents, &data); err != nil {
+		return nil, fmt.Errorf("Error serializing the role manifest: %v", err)
+	}
+	return util.JSONMarshal(data)
+}
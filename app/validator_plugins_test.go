@@ -0,0 +1,77 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatorPlugins(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	tempDir, err := ioutil.TempDir("", "fissile-validator-plugins")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	opinionsPath := filepath.Join(tempDir, "opinions.yml")
+	require.NoError(t, ioutil.WriteFile(opinionsPath, []byte("properties: {}\n"), 0644))
+
+	// The plugin checks that it was passed the instance group as JSON
+	pluginPath := filepath.Join(tempDir, "plugin.sh")
+	require.NoError(t, ioutil.WriteFile(pluginPath, []byte(`#!/bin/sh
+if grep -q '"name":"myrole"'; then
+	echo '{"errors": [{"code": "ACME-001", "field": "instance_groups[myrole].name", "value": "myrole", "detail": "Names need a team prefix"}],'
+	echo ' "warnings": [{"code": "ACME-002", "detail": "Consider adding a description"}]}'
+else
+	echo '{}'
+fi
+`), 0755))
+
+	failingPluginPath := filepath.Join(tempDir, "failing.sh")
+	require.NoError(t, ioutil.WriteFile(failingPluginPath, []byte("#!/bin/sh\necho broken >&2\nexit 3\n"), 0755))
+
+	f := NewFissileApplication(".", ui)
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/app/validation/tor-validation-ok.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.LightOpinions = opinionsPath
+	f.Options.DarkOpinions = []string{opinionsPath}
+	f.Options.ValidatorPlugins = []string{pluginPath}
+
+	err = f.LoadManifest()
+	require.NoError(t, err)
+
+	t.Run("ReportsErrorsAndWarnings", func(t *testing.T) {
+		errs := f.Validate()
+		require.Len(t, errs, 2)
+
+		assert.Equal(t, "ACME-001", errs[0].Code())
+		assert.Equal(t, `instance_groups[myrole].name: Policy violation: "myrole": Names need a team prefix`, errs[0].Error())
+		assert.False(t, errs[0].Warning)
+
+		assert.Equal(t, "ACME-002", errs[1].Code())
+		assert.Equal(t, "validator_plugins["+pluginPath+"]", errs[1].Field)
+		assert.True(t, errs[1].Warning)
+
+		assert.Len(t, errs.Failures(), 1)
+	})
+
+	t.Run("FailingPlugin", func(t *testing.T) {
+		f.Options.ValidatorPlugins = []string{failingPluginPath}
+		defer func() { f.Options.ValidatorPlugins = []string{pluginPath} }()
+
+		errs := f.Validate()
+		require.Len(t, errs, 1)
+		assert.Equal(t, "FISSILE-V174", errs[0].Code())
+		assert.Contains(t, errs[0].Error(), "exit status 3: broken")
+	})
+}
@@ -0,0 +1,397 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/fissile/builder"
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/kube"
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/fatih/color"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// VerifyChartOptions contains all option values for the `fissile verify chart` command.
+type VerifyChartOptions struct {
+	// ChartDir is the directory of the helm chart to install
+	ChartDir string
+	// ValuesFiles are the helm values files used for the installation
+	ValuesFiles []string
+	// ClusterName is the name of the kind cluster to create
+	ClusterName string
+	// NodeImage is the kind node image, selecting the Kubernetes version
+	NodeImage string
+	Namespace string
+	Release   string
+	// Errands are the names of the bosh-task instance groups whose jobs must
+	// complete successfully
+	Errands []string
+	// LogsDir is the directory the logs are collected into on failure
+	LogsDir  string
+	TagExtra string
+	// Timeout applies to each step waiting for the cluster
+	Timeout time.Duration
+	// KeepCluster skips deleting the cluster at the end
+	KeepCluster bool
+}
+
+var (
+	// runVerifyCommand runs an external command, returning its standard
+	// output; it is a stub to be replaced by the unit test
+	runVerifyCommand = func(name string, args ...string) ([]byte, error) {
+		var stderr bytes.Buffer
+		cmd := exec.Command(name, args...)
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("Error running %s %s: %v: %s",
+				name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+		}
+		return output, nil
+	}
+)
+
+// VerifyChart installs the helm chart into an ephemeral kind cluster: it
+// creates the cluster, loads the role images built by `fissile build images`
+// into it, installs the chart and waits for all instance groups to become
+// ready, and then waits for the jobs of the errands to complete. The jobs of
+// manual errands, such as smoke tests, aren't part of the chart; they are
+// rendered with the values of the installation and created. The logs of
+// all pods are collected into opt.LogsDir if any step fails. The cluster is
+// deleted at the end, unless opt.KeepCluster is set.
+func (f *Fissile) VerifyChart(opt VerifyChartOptions) (err error) {
+	if f.Manifest == nil {
+		return fmt.Errorf("Role manifest not loaded")
+	}
+	if _, err := os.Stat(filepath.Join(opt.ChartDir, "Chart.yaml")); err != nil {
+		return fmt.Errorf("Invalid helm chart directory %s: %v", opt.ChartDir, err)
+	}
+	for _, errand := range opt.Errands {
+		instanceGroup := f.Manifest.LookupInstanceGroup(errand)
+		if instanceGroup == nil {
+			return fmt.Errorf("Errand %s not found in the role manifest", errand)
+		}
+		if instanceGroup.Type != model.RoleTypeBoshTask || instanceGroup.Run == nil {
+			return fmt.Errorf("Errand %s is not a bosh-task instance group", errand)
+		}
+	}
+
+	images, err := f.verifyChartImages(opt)
+	if err != nil {
+		return err
+	}
+
+	timeout := fmt.Sprintf("%ds", int(opt.Timeout.Seconds()))
+	context := "kind-" + opt.ClusterName
+	kubectl := func(args ...string) ([]byte, error) {
+		return runVerifyCommand("kubectl", append([]string{"--context", context, "--namespace", opt.Namespace}, args...)...)
+	}
+
+	f.UI.Printf("Creating kind cluster %s\n", color.CyanString(opt.ClusterName))
+	args := []string{"create", "cluster", "--name", opt.ClusterName, "--wait", timeout}
+	if opt.NodeImage != "" {
+		args = append(args, "--image", opt.NodeImage)
+	}
+	if _, err := runVerifyCommand("kind", args...); err != nil {
+		return err
+	}
+	defer func() {
+		if opt.KeepCluster {
+			f.UI.Printf("Keeping kind cluster %s (context %s)\n", color.CyanString(opt.ClusterName), context)
+			return
+		}
+		f.UI.Printf("Deleting kind cluster %s\n", color.CyanString(opt.ClusterName))
+		if _, deleteErr := runVerifyCommand("kind", "delete", "cluster", "--name", opt.ClusterName); deleteErr != nil && err == nil {
+			err = deleteErr
+		}
+	}()
+
+	for _, image := range images {
+		f.UI.Printf("Loading image %s\n", color.CyanString(image))
+		if _, err := runVerifyCommand("kind", "load", "docker-image", image, "--name", opt.ClusterName); err != nil {
+			return err
+		}
+	}
+
+	err = f.verifyChartInstall(opt, context, timeout, kubectl)
+	if err != nil {
+		f.UI.Println(color.RedString("Verification failed: %v", err))
+		if opt.LogsDir != "" {
+			f.collectVerifyChartLogs(opt.LogsDir, kubectl)
+		}
+		return err
+	}
+
+	f.UI.Println(color.GreenString("Verification succeeded"))
+	return nil
+}
+
+// verifyChartImages returns the names of the images of all instance groups
+func (f *Fissile) verifyChartImages(opt VerifyChartOptions) ([]string, error) {
+	opinions, err := f.LoadOpinions()
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, instanceGroup := range f.Manifest.InstanceGroups.WithoutZoneReplicas() {
+		devVersion, err := instanceGroup.GetRoleDevVersion(opinions, opt.TagExtra, f.Version, f)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, builder.GetRoleDevImageName(f.Options.DockerRegistry, f.Options.DockerOrganization,
+			f.Options.RepositoryPrefix, instanceGroup, devVersion))
+	}
+	return images, nil
+}
+
+// verifyChartInstall installs the chart, waiting for the instance groups to
+// become ready, and then waits for the errands to complete
+func (f *Fissile) verifyChartInstall(opt VerifyChartOptions, context, timeout string, kubectl func(args ...string) ([]byte, error)) error {
+	f.UI.Printf("Installing helm chart %s as release %s\n", color.CyanString(opt.ChartDir), color.CyanString(opt.Release))
+	args := []string{"install", opt.Release, opt.ChartDir,
+		"--kube-context", context,
+		"--namespace", opt.Namespace,
+		"--create-namespace",
+		"--wait",
+		"--timeout", timeout,
+	}
+	for _, valuesFile := range opt.ValuesFiles {
+		args = append(args, "--values", valuesFile)
+	}
+	if _, err := runVerifyCommand("helm", args...); err != nil {
+		return err
+	}
+
+	var chartErrands, manualErrands []string
+	for _, errand := range opt.Errands {
+		if f.Manifest.LookupInstanceGroup(errand).Run.FlightStage == model.FlightStageManual {
+			manualErrands = append(manualErrands, errand)
+		} else {
+			chartErrands = append(chartErrands, errand)
+		}
+	}
+	if err := f.verifyChartErrands(opt, chartErrands, timeout, kubectl); err != nil {
+		return err
+	}
+	return f.runVerifyChartErrands(opt, manualErrands, timeout, kubectl)
+}
+
+// verifyChartErrands waits for the jobs installed by the chart for the
+// errands to complete
+func (f *Fissile) verifyChartErrands(opt VerifyChartOptions, errands []string, timeout string, kubectl func(args ...string) ([]byte, error)) error {
+	if len(errands) == 0 {
+		return nil
+	}
+
+	output, err := kubectl("get", "jobs", "--output", "json",
+		"--selector", "app.kubernetes.io/instance="+opt.Release)
+	if err != nil {
+		return err
+	}
+	var jobs struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec struct {
+				Template struct {
+					Metadata struct {
+						Labels map[string]string `json:"labels"`
+					} `json:"metadata"`
+				} `json:"template"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(output, &jobs); err != nil {
+		return fmt.Errorf("Error parsing kubectl output: %v", err)
+	}
+
+	for _, errand := range errands {
+		var names []string
+		for _, job := range jobs.Items {
+			if job.Spec.Template.Metadata.Labels[kube.RoleNameLabel] == errand {
+				names = append(names, job.Metadata.Name)
+			}
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("No job found for errand %s; is its feature enabled?", errand)
+		}
+		for _, name := range names {
+			f.UI.Printf("Waiting for errand %s (job %s)\n", color.CyanString(errand), name)
+			if _, err := kubectl("wait", "job/"+name, "--for", "condition=complete", "--timeout", timeout); err != nil {
+				return fmt.Errorf("Errand %s did not complete: %v", errand, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runVerifyChartErrands creates the jobs of the manual errands, rendered
+// with the values of the installation, and waits for them to complete
+func (f *Fissile) runVerifyChartErrands(opt VerifyChartOptions, errands []string, timeout string, kubectl func(args ...string) ([]byte, error)) error {
+	if len(errands) == 0 {
+		return nil
+	}
+
+	settings, renderer, err := f.verifyChartRenderer(opt)
+	if err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "fissile-verify-chart")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	for _, errand := range errands {
+		instanceGroup := f.Manifest.LookupInstanceGroup(errand)
+		job, err := kube.NewJob(instanceGroup, settings, f)
+		if err != nil {
+			return err
+		}
+		output, err := renderer.Render(fmt.Sprintf("templates/%s.yaml", errand), job)
+		if err != nil {
+			return err
+		}
+		if kube.IsEmptyRender(output) {
+			return fmt.Errorf("No job rendered for errand %s; is its feature enabled?", errand)
+		}
+		var rendered struct {
+			Metadata struct {
+				Name string `yaml:"name"`
+			} `yaml:"metadata"`
+		}
+		if err := yaml.Unmarshal(output, &rendered); err != nil {
+			return fmt.Errorf("Error parsing the job of errand %s: %v", errand, err)
+		}
+		path := filepath.Join(dir, errand+".yaml")
+		if err := ioutil.WriteFile(path, output, 0644); err != nil {
+			return err
+		}
+
+		f.UI.Printf("Running errand %s (job %s)\n", color.CyanString(errand), rendered.Metadata.Name)
+		if _, err := kubectl("create", "--filename", path); err != nil {
+			return err
+		}
+		if _, err := kubectl("wait", "job/"+rendered.Metadata.Name, "--for", "condition=complete", "--timeout", timeout); err != nil {
+			return fmt.Errorf("Errand %s did not complete: %v", errand, err)
+		}
+	}
+	return nil
+}
+
+// verifyChartRenderer returns the settings and the renderer for the
+// templates of the chart, with the values of the installation applied
+func (f *Fissile) verifyChartRenderer(opt VerifyChartOptions) (kube.ExportSettings, *kube.Renderer, error) {
+	var chart struct {
+		Name    string `yaml:"name"`
+		Version string `yaml:"version"`
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(opt.ChartDir, "Chart.yaml"))
+	if err != nil {
+		return kube.ExportSettings{}, nil, err
+	}
+	if err := yaml.Unmarshal(contents, &chart); err != nil {
+		return kube.ExportSettings{}, nil, fmt.Errorf("Error parsing Chart.yaml: %v", err)
+	}
+
+	opinions, err := f.LoadOpinions()
+	if err != nil {
+		return kube.ExportSettings{}, nil, err
+	}
+	settings := kube.ExportSettings{
+		RoleManifest:    f.Manifest,
+		Opinions:        opinions,
+		Registry:        f.Options.DockerRegistry,
+		Organization:    f.Options.DockerOrganization,
+		Repository:      f.Options.RepositoryPrefix,
+		TagExtra:        opt.TagExtra,
+		FissileVersion:  f.Version,
+		UseMemoryLimits: true,
+		UseCPULimits:    true,
+		CreateHelmChart: true,
+		Render: &kube.RenderOptions{
+			ReleaseName:  opt.Release,
+			Namespace:    opt.Namespace,
+			ChartName:    chart.Name,
+			ChartVersion: chart.Version,
+		},
+	}
+
+	// Manual errands have no sizing values in the chart, so they get their
+	// defaults here, before the values files are applied
+	sizing := helm.NewMapping()
+	for _, errand := range opt.Errands {
+		instanceGroup := f.Manifest.LookupInstanceGroup(errand)
+		if instanceGroup.Run.FlightStage == model.FlightStageManual {
+			sizing.Add(strings.Replace(errand, "-", "_", -1), kube.MakeSizingValues(instanceGroup, settings))
+		}
+	}
+	values, err := kube.ValuesFromNode(helm.NewMapping("sizing", sizing))
+	if err != nil {
+		return kube.ExportSettings{}, nil, err
+	}
+	for _, valuesFile := range opt.ValuesFiles {
+		fileValues, err := kube.ReadValuesFile(valuesFile)
+		if err != nil {
+			return kube.ExportSettings{}, nil, err
+		}
+		values = kube.MergeValues(values, fileValues)
+	}
+	settings.Render.Values = values
+
+	renderer, err := kube.NewRenderer(settings)
+	if err != nil {
+		return kube.ExportSettings{}, nil, err
+	}
+	if err := renderSecretsTemplate(renderer, settings); err != nil {
+		return kube.ExportSettings{}, nil, err
+	}
+	return settings, renderer, nil
+}
+
+// collectVerifyChartLogs writes the state of the namespace, and the logs of
+// all containers of all pods, into logsDir. Failures are only reported, as
+// the logs are collected after the verification already failed.
+func (f *Fissile) collectVerifyChartLogs(logsDir string, kubectl func(args ...string) ([]byte, error)) {
+	f.UI.Printf("Collecting logs into %s\n", color.CyanString(logsDir))
+	warn := func(err error) {
+		f.UI.Println(color.YellowString("Error collecting logs: %v", err))
+	}
+	if err := os.MkdirAll(logsDir, 0755); err != nil {
+		warn(err)
+		return
+	}
+	write := func(name string, args ...string) {
+		output, err := kubectl(args...)
+		if err != nil {
+			warn(err)
+			return
+		}
+		if err := ioutil.WriteFile(filepath.Join(logsDir, name), output, 0644); err != nil {
+			warn(err)
+		}
+	}
+
+	write("resources.txt", "get", "all", "--output", "wide")
+	write("events.txt", "get", "events", "--sort-by", ".lastTimestamp")
+	write("pods.txt", "describe", "pods")
+
+	output, err := kubectl("get", "pods", "--output", "jsonpath={.items[*].metadata.name}")
+	if err != nil {
+		warn(err)
+		return
+	}
+	for _, pod := range strings.Fields(string(output)) {
+		write(pod+".log", "logs", pod, "--all-containers")
+	}
+}
@@ -0,0 +1,199 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVerifyCommands records the commands run by VerifyChart, returning the
+// output (or error) configured for a matching prefix
+type fakeVerifyCommands struct {
+	commands []string
+	outputs  map[string]string
+	failures map[string]bool
+}
+
+func (c *fakeVerifyCommands) run(name string, args ...string) ([]byte, error) {
+	command := strings.Join(append([]string{name}, args...), " ")
+	c.commands = append(c.commands, command)
+	for prefix := range c.failures {
+		if strings.HasPrefix(command, prefix) {
+			return nil, fmt.Errorf("%s failed", prefix)
+		}
+	}
+	for prefix, output := range c.outputs {
+		if strings.HasPrefix(command, prefix) {
+			return []byte(output), nil
+		}
+	}
+	return nil, nil
+}
+
+func TestVerifyChart(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "fissile-verify-chart")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	opinionsPath := filepath.Join(workDir, "opinions.yml")
+	require.NoError(t, ioutil.WriteFile(opinionsPath, []byte("{}"), 0644))
+	chartDir := filepath.Join(workDir, "helm")
+	require.NoError(t, os.MkdirAll(chartDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(chartDir, "Chart.yaml"), []byte("name: test\n"), 0644))
+	logsDir := filepath.Join(workDir, "logs")
+
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	f.Options.LightOpinions = opinionsPath
+	f.Options.DarkOpinions = []string{opinionsPath}
+	f.Options.DockerOrganization = "org"
+	f.Manifest = &model.RoleManifest{
+		InstanceGroups: model.InstanceGroups{
+			&model.InstanceGroup{Name: "server", Type: model.RoleTypeBosh, Run: &model.RoleRun{}},
+			&model.InstanceGroup{Name: "smoke-tests", Type: model.RoleTypeBoshTask,
+				Run: &model.RoleRun{FlightStage: model.FlightStagePostFlight}},
+			&model.InstanceGroup{Name: "manual", Type: model.RoleTypeBoshTask,
+				Run: &model.RoleRun{FlightStage: model.FlightStageManual}},
+		},
+	}
+
+	var images []string
+	for _, instanceGroup := range f.Manifest.InstanceGroups {
+		devVersion, err := instanceGroup.GetRoleDevVersion(nil, "", f.Version, f)
+		require.NoError(t, err)
+		images = append(images, "org/"+instanceGroup.Name+":"+devVersion)
+	}
+
+	opt := VerifyChartOptions{
+		ChartDir:    chartDir,
+		ValuesFiles: []string{"values.yml"},
+		ClusterName: "ci",
+		Namespace:   "ns",
+		Release:     "rel",
+		Errands:     []string{"smoke-tests"},
+		LogsDir:     logsDir,
+		Timeout:     5 * time.Minute,
+	}
+	jobs := `{"items": [
+		{"metadata": {"name": "server-1"}, "spec": {"template": {"metadata": {"labels": {"app.kubernetes.io/component": "server"}}}}},
+		{"metadata": {"name": "smoke-tests-1"}, "spec": {"template": {"metadata": {"labels": {"app.kubernetes.io/component": "smoke-tests"}}}}}
+	]}`
+	kubectl := "kubectl --context kind-ci --namespace ns "
+
+	defer func(original func(string, ...string) ([]byte, error)) { runVerifyCommand = original }(runVerifyCommand)
+
+	t.Run("Success", func(t *testing.T) {
+		commands := &fakeVerifyCommands{outputs: map[string]string{kubectl + "get jobs": jobs}}
+		runVerifyCommand = commands.run
+
+		require.NoError(t, f.VerifyChart(opt))
+		assert.Equal(t, []string{
+			"kind create cluster --name ci --wait 300s",
+			"kind load docker-image " + images[0] + " --name ci",
+			"kind load docker-image " + images[1] + " --name ci",
+			"kind load docker-image " + images[2] + " --name ci",
+			"helm install rel " + chartDir + " --kube-context kind-ci --namespace ns --create-namespace --wait --timeout 300s --values values.yml",
+			kubectl + "get jobs --output json --selector app.kubernetes.io/instance=rel",
+			kubectl + "wait job/smoke-tests-1 --for condition=complete --timeout 300s",
+			"kind delete cluster --name ci",
+		}, commands.commands)
+		_, err := os.Stat(logsDir)
+		assert.True(t, os.IsNotExist(err), "Logs should only be collected on failure")
+	})
+
+	t.Run("ErrandFailure", func(t *testing.T) {
+		commands := &fakeVerifyCommands{
+			outputs: map[string]string{
+				kubectl + "get jobs":                    jobs,
+				kubectl + "get pods --output jsonpath=": "server-0 smoke-tests-1-abcde",
+				kubectl + "logs server-0":               "server logs",
+			},
+			failures: map[string]bool{kubectl + "wait": true},
+		}
+		runVerifyCommand = commands.run
+
+		err := f.VerifyChart(opt)
+		assert.EqualError(t, err, "Errand smoke-tests did not complete: "+kubectl+"wait failed")
+		assert.Equal(t, "kind delete cluster --name ci", commands.commands[len(commands.commands)-1])
+
+		contents, err := ioutil.ReadFile(filepath.Join(logsDir, "server-0.log"))
+		require.NoError(t, err)
+		assert.Equal(t, "server logs", string(contents))
+		assert.FileExists(t, filepath.Join(logsDir, "smoke-tests-1-abcde.log"))
+		assert.FileExists(t, filepath.Join(logsDir, "pods.txt"))
+	})
+
+	t.Run("KeepCluster", func(t *testing.T) {
+		commands := &fakeVerifyCommands{failures: map[string]bool{"helm install": true}}
+		runVerifyCommand = commands.run
+
+		keepOpt := opt
+		keepOpt.KeepCluster = true
+		keepOpt.LogsDir = ""
+		assert.EqualError(t, f.VerifyChart(keepOpt), "helm install failed")
+		assert.NotContains(t, commands.commands, "kind delete cluster --name ci")
+	})
+
+	t.Run("InvalidErrand", func(t *testing.T) {
+		commands := &fakeVerifyCommands{}
+		runVerifyCommand = commands.run
+
+		invalidOpt := opt
+		invalidOpt.Errands = []string{"server"}
+		assert.EqualError(t, f.VerifyChart(invalidOpt), "Errand server is not a bosh-task instance group")
+		assert.Empty(t, commands.commands)
+	})
+}
+
+func TestVerifyChartManualErrand(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	chartDir, err := ioutil.TempDir("", "fissile-verify-chart")
+	require.NoError(t, err)
+	defer os.RemoveAll(chartDir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(chartDir, "Chart.yaml"), []byte("name: tor\nversion: 1.0.0\n"), 0644))
+
+	f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	f.Options.RoleManifest = filepath.Join(workDir, "../test-assets/role-manifests/kube/quarks.yml")
+	f.Options.Releases = []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")}
+	f.Options.CacheDir = filepath.Join(workDir, "../test-assets/bosh-cache")
+	f.Options.LightOpinions = filepath.Join(workDir, "../test-assets/test-opinions/opinions.yml")
+	f.Options.DarkOpinions = []string{filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml")}
+	require.NoError(t, f.LoadManifest())
+
+	defer func(original func(string, ...string) ([]byte, error)) { runVerifyCommand = original }(runVerifyCommand)
+	commands := &fakeVerifyCommands{}
+	runVerifyCommand = commands.run
+
+	require.NoError(t, f.VerifyChart(VerifyChartOptions{
+		ChartDir:    chartDir,
+		ClusterName: "ci",
+		Namespace:   "ns",
+		Release:     "rel",
+		Errands:     []string{"smoke-tests"},
+		Timeout:     5 * time.Minute,
+	}))
+
+	kubectl := "kubectl --context kind-ci --namespace ns "
+	var created []string
+	for _, command := range commands.commands {
+		if strings.HasPrefix(command, kubectl+"create --filename ") {
+			created = append(created, command)
+		}
+	}
+	require.Len(t, created, 1, "The job of the manual errand should be created")
+	assert.True(t, strings.HasSuffix(created[0], "/smoke-tests.yaml"))
+	assert.Contains(t, commands.commands, kubectl+"wait job/smoke-tests-1 --for condition=complete --timeout 300s")
+	assert.NotContains(t, commands.commands, kubectl+"get jobs --output json --selector app.kubernetes.io/instance=rel",
+		"The jobs of the chart should not be looked up for manual errands")
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/util"
+	"github.com/Masterminds/semver"
+	"github.com/fatih/color"
+)
+
+// VersionCheckOptions contains all option values for the `fissile version --check` command.
+type VersionCheckOptions struct {
+	// Feed is the URL of the latest release, in the format of the GitHub
+	// releases API
+	Feed string
+	// Update replaces the running binary with the latest release
+	Update bool
+	// PublicKey is the base64-encoded ed25519 key the releases are signed with
+	PublicKey   string
+	HTTPOptions util.HTTPOptions
+}
+
+// releaseFeedEntry is the latest release as returned by the release feed
+type releaseFeedEntry struct {
+	TagName string             `json:"tag_name"`
+	HTMLURL string             `json:"html_url"`
+	Assets  []releaseFeedAsset `json:"assets"`
+}
+
+// releaseFeedAsset is a file attached to a release
+type releaseFeedAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+var (
+	// executablePath is a stub to be replaced by the unit test
+	executablePath = os.Executable
+
+	versionRegexp = regexp.MustCompile(`[0-9]+\.[0-9]+\.[0-9]+.*$`)
+)
+
+// ParseFissileVersion returns the semantic version of a fissile version
+// string, which is prefixed with the artifact name, e.g. fissile-7.0.0+12.g3a2b1c
+func ParseFissileVersion(version string) (*semver.Version, error) {
+	match := versionRegexp.FindString(version)
+	if match == "" {
+		return nil, fmt.Errorf("Invalid fissile version %s", version)
+	}
+	return semver.NewVersion(match)
+}
+
+// CheckVersion compares the version of fissile with the latest release
+// listed in the release feed. With opt.Update, the running binary is replaced
+// by the release archive for this platform once its signature is verified.
+func (f *Fissile) CheckVersion(opt VersionCheckOptions) error {
+	current, err := ParseFissileVersion(f.Version)
+	if err != nil {
+		return err
+	}
+
+	transport, err := util.NewHTTPTransport(opt.HTTPOptions)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: transport}
+
+	buf, err := httpGet(client, opt.Feed)
+	if err != nil {
+		return fmt.Errorf("Error reading the release feed: %v", err)
+	}
+	var release releaseFeedEntry
+	if err := json.Unmarshal(buf, &release); err != nil {
+		return fmt.Errorf("Error parsing the release feed %s: %v", opt.Feed, err)
+	}
+	latest, err := ParseFissileVersion(release.TagName)
+	if err != nil {
+		return fmt.Errorf("Error parsing the latest release: %v", err)
+	}
+
+	if !latest.GreaterThan(current) {
+		f.UI.Printf("fissile %s is up to date\n", color.GreenString(current.String()))
+		return nil
+	}
+	f.UI.Printf("A newer fissile version %s is available (this is %s): %s\n",
+		color.YellowString(latest.String()), current.String(), release.HTMLURL)
+	if !opt.Update {
+		return nil
+	}
+
+	if opt.PublicKey == "" {
+		return fmt.Errorf("Updating requires the public key the releases are signed with")
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(opt.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("Invalid public key; expected a base64-encoded ed25519 key")
+	}
+
+	suffix := fmt.Sprintf(".%s-%s.tgz", runtime.GOOS, runtime.GOARCH)
+	var archiveURL, signatureURL string
+	for _, asset := range release.Assets {
+		if strings.HasSuffix(asset.Name, suffix) {
+			archiveURL = asset.URL
+		} else if strings.HasSuffix(asset.Name, suffix+".sig") {
+			signatureURL = asset.URL
+		}
+	}
+	if archiveURL == "" || signatureURL == "" {
+		return fmt.Errorf("Release %s has no signed archive for %s-%s", release.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+
+	archive, err := httpGet(client, archiveURL)
+	if err != nil {
+		return fmt.Errorf("Error downloading release %s: %v", release.TagName, err)
+	}
+	signature, err := httpGet(client, signatureURL)
+	if err != nil {
+		return fmt.Errorf("Error downloading the signature of release %s: %v", release.TagName, err)
+	}
+	signature, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("Invalid signature of release %s: %v", release.TagName, err)
+	}
+	if !ed25519.Verify(publicKey, archive, signature) {
+		return fmt.Errorf("The signature of release %s does not match its archive", release.TagName)
+	}
+
+	executable, err := executablePath()
+	if err != nil {
+		return err
+	}
+	if err := replaceExecutable(executable, archive); err != nil {
+		return fmt.Errorf("Error updating %s: %v", executable, err)
+	}
+	f.UI.Printf("Updated %s to fissile %s\n", color.CyanString(executable), color.GreenString(latest.String()))
+	return nil
+}
+
+// httpGet returns the body of a successful GET request
+func httpGet(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// replaceExecutable extracts the fissile binary from a release archive, and
+// atomically replaces the executable with it
+func replaceExecutable(executable string, archive []byte) error {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return fmt.Errorf("The release archive does not contain fissile")
+		}
+		if err != nil {
+			return err
+		}
+		if filepath.Base(header.Name) != "fissile" || header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		temp, err := ioutil.TempFile(filepath.Dir(executable), ".fissile-update")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(temp.Name())
+		if _, err := io.Copy(temp, reader); err != nil {
+			temp.Close()
+			return err
+		}
+		if err := temp.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(temp.Name(), 0755); err != nil {
+			return err
+		}
+		return os.Rename(temp.Name(), executable)
+	}
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFissileVersion(t *testing.T) {
+	t.Parallel()
+
+	version, err := ParseFissileVersion("fissile-7.0.0+12.g3a2b1c")
+	require.NoError(t, err)
+	assert.Equal(t, "7.0.0+12.g3a2b1c", version.String())
+
+	version, err = ParseFissileVersion("v7.1.2")
+	require.NoError(t, err)
+	assert.Equal(t, "7.1.2", version.String())
+
+	_, err = ParseFissileVersion("0")
+	assert.EqualError(t, err, "Invalid fissile version 0")
+}
+
+// makeReleaseArchive returns a gzipped tarball holding a fissile binary with
+// the given content
+func makeReleaseArchive(t *testing.T, content string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(gz)
+	require.NoError(t, writer.WriteHeader(&tar.Header{
+		Name:     "fissile",
+		Mode:     0755,
+		Size:     int64(len(content)),
+		Typeflag: tar.TypeReg,
+	}))
+	_, err := writer.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestCheckVersion(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	archive := makeReleaseArchive(t, "new fissile")
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, archive))
+	platform := fmt.Sprintf("%s-%s", runtime.GOOS, runtime.GOARCH)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/latest", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{
+			"tag_name": "7.1.0",
+			"html_url": "https://example.com/fissile/7.1.0",
+			"assets": [
+				{"name": "fissile-7.1.0.%[1]s.tgz", "browser_download_url": "%[2]s/archive"},
+				{"name": "fissile-7.1.0.%[1]s.tgz.sig", "browser_download_url": "%[2]s/signature"}
+			]}`, platform, server.URL)
+	})
+	mux.HandleFunc("/archive", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	})
+	mux.HandleFunc("/signature", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, signature)
+	})
+
+	dir, err := ioutil.TempDir("", "fissile-version-check")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	executable := filepath.Join(dir, "fissile")
+	executablePath = func() (string, error) { return executable, nil }
+	defer func() { executablePath = os.Executable }()
+
+	newFissile := func(version string) (*Fissile, *bytes.Buffer) {
+		output := &bytes.Buffer{}
+		f := NewFissileApplication(version, termui.New(&bytes.Buffer{}, output, nil))
+		return f, output
+	}
+	opt := VersionCheckOptions{
+		Feed:      server.URL + "/latest",
+		PublicKey: base64.StdEncoding.EncodeToString(publicKey),
+	}
+
+	t.Run("UpToDate", func(t *testing.T) {
+		f, output := newFissile("fissile-7.1.0+0.gabcdef")
+		require.NoError(t, f.CheckVersion(opt))
+		assert.Contains(t, output.String(), "is up to date")
+	})
+
+	t.Run("Check", func(t *testing.T) {
+		f, output := newFissile("fissile-7.0.0+12.gabcdef")
+		require.NoError(t, f.CheckVersion(opt))
+		assert.Contains(t, output.String(), "A newer fissile version 7.1.0 is available (this is 7.0.0+12.gabcdef): https://example.com/fissile/7.1.0")
+		_, err := os.Stat(executable)
+		assert.True(t, os.IsNotExist(err), "Should not update without --update")
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		require.NoError(t, ioutil.WriteFile(executable, []byte("old fissile"), 0755))
+		f, _ := newFissile("fissile-7.0.0+12.gabcdef")
+
+		updateOpt := opt
+		updateOpt.Update = true
+		updateOpt.PublicKey = ""
+		assert.EqualError(t, f.CheckVersion(updateOpt), "Updating requires the public key the releases are signed with")
+
+		otherKey, _, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		updateOpt.PublicKey = base64.StdEncoding.EncodeToString(otherKey)
+		assert.EqualError(t, f.CheckVersion(updateOpt), "The signature of release 7.1.0 does not match its archive")
+		content, err := ioutil.ReadFile(executable)
+		require.NoError(t, err)
+		assert.Equal(t, "old fissile", string(content))
+
+		updateOpt.PublicKey = opt.PublicKey
+		require.NoError(t, f.CheckVersion(updateOpt))
+		content, err = ioutil.ReadFile(executable)
+		require.NoError(t, err)
+		assert.Equal(t, "new fissile", string(content))
+	})
+}
+
+func TestCheckMinimumVersion(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		version string
+		warn    bool
+	}{
+		{"fissile-7.0.0+12.gabcdef", true},
+		{"fissile-7.1.0+0.gabcdef", false},
+		{"0", false},
+	} {
+		output := &bytes.Buffer{}
+		f := NewFissileApplication(tc.version, termui.New(&bytes.Buffer{}, output, nil))
+		f.Manifest = &model.RoleManifest{MinimumFissileVersion: "7.1.0"}
+		f.checkMinimumVersion()
+		if tc.warn {
+			assert.Contains(t, output.String(), "the role manifest requires fissile 7.1.0 or newer, but this is 7.0.0+12.gabcdef", tc.version)
+		} else {
+			assert.Empty(t, output.String(), tc.version)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/fissile/kube"
+	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultWatchDebounce is the time the inputs of the kube configs have to be
+// left alone before they are regenerated, so that saving several files at
+// once regenerates them only once
+const DefaultWatchDebounce = 500 * time.Millisecond
+
+// WatchKubeOptions contains the options of watching the inputs of the kube
+// configs
+type WatchKubeOptions struct {
+	Debounce time.Duration
+	// Stop ends watching once closed; fissile watches until it is
+	// interrupted otherwise
+	Stop <-chan struct{}
+	// ready is closed once the inputs are watched, and regenerated is
+	// notified after each regeneration, for the tests
+	ready       chan<- struct{}
+	regenerated chan<- []string
+}
+
+// WatchKube generates the kube configs, and regenerates them whenever the
+// role manifest, the files next to it (like its scripts), the opinions, or
+// the defaults files and runtime configs change. The role manifest and the
+// opinions are reloaded for each regeneration; only the output files with
+// changed contents are rewritten, and listed in a summary, and the files no
+// longer generated, like the ones of removed instance groups, are removed.
+// Errors loading the changed inputs are reported, and the configs are
+// regenerated once the inputs are fixed.
+func (f *Fissile) WatchKube(settings kube.ExportSettings, opt WatchKubeOptions) error {
+	if settings.StreamOutput == kube.StreamOutputStdout {
+		return fmt.Errorf("Watching requires the kube configs to be written to files")
+	}
+	if opt.Debounce == 0 {
+		opt.Debounce = DefaultWatchDebounce
+	}
+
+	f.writtenFiles = map[string]bool{}
+	defer func() { f.writtenFiles = nil }()
+	if err := f.GenerateKube(settings); err != nil {
+		return err
+	}
+	outputs := f.writtenFiles
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	inputs, err := f.watchKubeInputs(watcher, settings.OutputDir)
+	if err != nil {
+		return err
+	}
+	f.UI.Println(color.GreenString("Watching the inputs of the kube configs for changes"))
+	if opt.ready != nil {
+		close(opt.ready)
+	}
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-opt.Stop:
+			return nil
+		case err := <-watcher.Errors:
+			return fmt.Errorf("Error watching the inputs of the kube configs: %v", err)
+		case event := <-watcher.Events:
+			if !inputs.affectedBy(event.Name) {
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 {
+				// Watch the directories created below the role manifest
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := inputs.watchDir(watcher, filepath.Clean(event.Name)); err != nil {
+						return fmt.Errorf("Error watching the inputs of the kube configs: %v", err)
+					}
+				}
+			}
+			debounce = time.After(opt.Debounce)
+		case <-debounce:
+			debounce = nil
+			written, rewritten, err := f.regenerateKube(settings)
+			if err != nil {
+				f.UI.Println(color.RedString("Error regenerating the kube configs: %v", err))
+			} else {
+				removed, err := removeStaleOutputs(outputs, written)
+				if err != nil {
+					f.UI.Println(color.RedString("Error removing stale kube configs: %v", err))
+				}
+				outputs = written
+				f.reportRewrittenFiles(rewritten, removed)
+			}
+			if opt.regenerated != nil {
+				opt.regenerated <- rewritten
+			}
+		}
+	}
+}
+
+// watchedKubeInputs are the inputs of the kube configs
+type watchedKubeInputs struct {
+	// files are the input files outside of the directories
+	files map[string]bool
+	// dirs are the directories all files of which are inputs
+	dirs []string
+	// manifestDir is the directory of the role manifest, whose
+	// subdirectories are watched as well
+	manifestDir string
+	// outputDir is excluded from the dirs, if it is below them
+	outputDir string
+}
+
+// affectedBy checks whether a change of the file affects the kube configs
+func (inputs watchedKubeInputs) affectedBy(name string) bool {
+	name = filepath.Clean(name)
+	if inputs.files[name] {
+		return true
+	}
+	if inputs.outputDir != "" && isBelow(name, inputs.outputDir) {
+		return false
+	}
+	for _, dir := range inputs.dirs {
+		if isBelow(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBelow checks whether the path is within the directory
+func isBelow(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// watchKubeInputs adds the inputs of the kube configs to the watcher: the
+// directory of the role manifest with all its subdirectories, except for
+// hidden ones and the output directory, and the directories of the other
+// input files. Files are watched via their directories, so that editors
+// replacing them are noticed.
+func (f *Fissile) watchKubeInputs(watcher *fsnotify.Watcher, outputDir string) (watchedKubeInputs, error) {
+	inputs := watchedKubeInputs{files: map[string]bool{}}
+	manifestDir, err := filepath.Abs(filepath.Dir(f.Options.RoleManifest))
+	if err != nil {
+		return inputs, err
+	}
+	absOutputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return inputs, err
+	}
+	// Output written next to the role manifest triggers one more
+	// regeneration, which doesn't rewrite anything
+	if absOutputDir != manifestDir && isBelow(absOutputDir, manifestDir) {
+		inputs.outputDir = absOutputDir
+	}
+	inputs.manifestDir = manifestDir
+	inputs.dirs = append(inputs.dirs, manifestDir)
+	if err := inputs.watchDir(watcher, manifestDir); err != nil {
+		return inputs, err
+	}
+
+	var files []string
+	if f.Options.LightOpinions != "" {
+		files = append(files, f.Options.LightOpinions)
+	}
+	files = append(files, f.Options.DarkOpinions...)
+	files = append(files, f.Options.DefaultsFiles...)
+	files = append(files, f.Options.RuntimeConfigs...)
+	watchedDirs := map[string]bool{}
+	for _, file := range files {
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return inputs, err
+		}
+		inputs.files[absFile] = true
+		dir := filepath.Dir(absFile)
+		if isBelow(dir, manifestDir) || watchedDirs[dir] {
+			continue
+		}
+		watchedDirs[dir] = true
+		if err := watcher.Add(dir); err != nil {
+			return inputs, err
+		}
+	}
+
+	return inputs, nil
+}
+
+// watchDir adds the directory below the role manifest and its subdirectories
+// to the watcher, except for hidden ones and the output directory
+func (inputs watchedKubeInputs) watchDir(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if inputs.outputDir != "" && isBelow(path, inputs.outputDir) {
+			return filepath.SkipDir
+		}
+		if path != inputs.manifestDir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// regenerateKube reloads the role manifest and the opinions, and generates the
+// kube configs again. It returns all written files, and the rewritten ones.
+func (f *Fissile) regenerateKube(settings kube.ExportSettings) (map[string]bool, []string, error) {
+	rewritten := []string{}
+	f.rewrittenFiles = &rewritten
+	f.writtenFiles = map[string]bool{}
+	defer func() { f.rewrittenFiles = nil }()
+
+	if err := f.LoadManifest(); err != nil {
+		return nil, nil, err
+	}
+	opinions, err := f.LoadOpinions()
+	if err != nil {
+		return nil, nil, err
+	}
+	settings.Opinions = opinions
+
+	if err := f.GenerateKube(settings); err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(rewritten)
+	return f.writtenFiles, rewritten, nil
+}
+
+// removeStaleOutputs removes the files written by the previous generation of
+// the kube configs that the current one didn't write, e.g. the ones of
+// removed instance groups. It returns the removed files.
+func removeStaleOutputs(previous, current map[string]bool) ([]string, error) {
+	var removed []string
+	for file := range previous {
+		if current[file] {
+			continue
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed = append(removed, file)
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// reportRewrittenFiles prints the summary of a regeneration
+func (f *Fissile) reportRewrittenFiles(rewritten, removed []string) {
+	if len(rewritten) == 0 && len(removed) == 0 {
+		f.UI.Println(color.GreenString("Regenerated the kube configs; no files changed"))
+		return
+	}
+	if len(rewritten) > 0 {
+		f.UI.Println(color.GreenString("Regenerated the kube configs; rewrote %d file(s):", len(rewritten)))
+		for _, file := range rewritten {
+			f.UI.Printf("  %s\n", color.CyanString(file))
+		}
+	}
+	if len(removed) > 0 {
+		f.UI.Println(color.GreenString("Regenerated the kube configs; removed %d stale file(s):", len(removed)))
+		for _, file := range removed {
+			f.UI.Printf("  %s\n", color.CyanString(file))
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package builder
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/oci"
+	"github.com/fatih/color"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// OCIBaseImage is the base of role images assembled directly as OCI images
+// instead of being built by docker: the stemcell image, plus a layer holding
+// the compiled packages.
+type OCIBaseImage struct {
+	// Layout is the image layout the role images are written to; it holds
+	// all blobs of the base image
+	Layout   *oci.Layout
+	Stemcell *oci.Image
+	Packages oci.Layer
+	// Labels are the labels of the packages layer
+	Labels map[string]string
+}
+
+// NewOCIBaseImage writes the layers of the stemcell image of the stemcell
+// layout, and the layer of the compiled packages used by the instance groups,
+// to the layout. The stemcell image is looked up by StemcellImageName.
+func (p *PackagesImageBuilder) NewOCIBaseImage(layout, stemcellLayout *oci.Layout, instanceGroups model.InstanceGroups, labels map[string]string) (*OCIBaseImage, error) {
+	stemcell, err := stemcellLayout.Image(p.StemcellImageName)
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range stemcell.Manifest.Layers {
+		if err := layout.CopyBlob(stemcellLayout, layer); err != nil {
+			return nil, fmt.Errorf("Error copying the stemcell layer %s: %v", layer.Digest, err)
+		}
+	}
+
+	packagesLayer, err := layout.WriteLayer(rebaseBuildContext(
+		p.NewDockerPopulator(instanceGroups, labels, true), "packages-src", "var/vcap/packages/.src"))
+	if err != nil {
+		return nil, fmt.Errorf("Error writing the packages layer: %v", err)
+	}
+
+	baseLabels := map[string]string{}
+	for label, value := range labels {
+		baseLabels[label] = value
+	}
+	versionLabel := strings.SplitN(p.fissileVersionLabel(), "=", 2)
+	baseLabels[versionLabel[0]] = versionLabel[1]
+	for _, instanceGroup := range instanceGroups {
+		for _, jobReference := range instanceGroup.JobReferences {
+			for _, pkg := range jobReference.Packages {
+				baseLabels["fingerprint."+pkg.Fingerprint] = pkg.Name
+			}
+		}
+	}
+
+	return &OCIBaseImage{
+		Layout:   layout,
+		Stemcell: stemcell,
+		Packages: packagesLayer,
+		Labels:   baseLabels,
+	}, nil
+}
+
+// assembleOCIImage writes the role image of the instance group to the layout
+// of the base image, on top of the stemcell and packages layers. The result
+// is equivalent to building the Dockerfile of the role.
+func (r *RoleImageBuilder) assembleOCIImage(instanceGroup *model.InstanceGroup, roleImageName string) (*oci.Image, error) {
+	base := r.OCIBase
+
+	roleLayer, err := base.Layout.WriteLayer(rebaseBuildContext(r.NewDockerPopulator(instanceGroup), "root", ""))
+	if err != nil {
+		return nil, fmt.Errorf("Error writing the role layer: %v", err)
+	}
+
+	config := base.Stemcell.Config
+	config.Created = nil
+	config.History = nil
+	config.RootFS.DiffIDs = append(append([]digest.Digest{}, config.RootFS.DiffIDs...), base.Packages.DiffID, roleLayer.DiffID)
+	config.Config.Labels = map[string]string{}
+	for label, value := range base.Stemcell.Config.Config.Labels {
+		config.Config.Labels[label] = value
+	}
+	for label, value := range base.Labels {
+		config.Config.Labels[label] = value
+	}
+	config.Config.Labels["instance_group"] = instanceGroup.Name
+	config.Config.Entrypoint = []string{"/usr/bin/dumb-init", "/opt/fissile/run.sh"}
+	config.Config.Cmd = nil
+
+	layers := append(append([]v1.Descriptor{}, base.Stemcell.Manifest.Layers...), base.Packages.Descriptor, roleLayer.Descriptor)
+	return base.Layout.WriteImage(roleImageName, config, layers)
+}
+
+// pushOCIImage pushes the role image of the layout of the base image
+func (r *RoleImageBuilder) pushOCIImage(roleImageName string, image *oci.Image) error {
+	r.UI.Printf("Pushing OCI image %s...\n", color.YellowString(roleImageName))
+	if err := r.OCIPush(roleImageName, image); err != nil {
+		return fmt.Errorf("Error pushing image %s: %s", roleImageName, err.Error())
+	}
+	return nil
+}
+
+// rebaseBuildContext returns a populator of a layer, holding the files below
+// the prefix of the docker build context written by the given populator,
+// moved to the target directory. Other files, e.g. the Dockerfile, are
+// dropped.
+func rebaseBuildContext(populate func(*tar.Writer) error, prefix, target string) func(*tar.Writer) error {
+	return func(layerWriter *tar.Writer) error {
+		reader, writer := io.Pipe()
+		go func() {
+			contextWriter := tar.NewWriter(writer)
+			err := populate(contextWriter)
+			if err == nil {
+				err = contextWriter.Close()
+			}
+			writer.CloseWithError(err)
+		}()
+
+		contextReader := tar.NewReader(reader)
+		for {
+			header, err := contextReader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				reader.CloseWithError(err)
+				return err
+			}
+
+			name := path.Clean(header.Name)
+			if name != prefix && !strings.HasPrefix(name, prefix+"/") {
+				continue
+			}
+			name = path.Join(target, strings.TrimPrefix(strings.TrimPrefix(name, prefix), "/"))
+			if name == "" || name == "." {
+				continue
+			}
+			header.Name = name
+
+			if err := layerWriter.WriteHeader(header); err != nil {
+				reader.CloseWithError(err)
+				return err
+			}
+			if _, err := io.Copy(layerWriter, contextReader); err != nil {
+				reader.CloseWithError(err)
+				return err
+			}
+		}
+	}
+}
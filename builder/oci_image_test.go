@@ -0,0 +1,64 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebaseBuildContext(t *testing.T) {
+	assert := assert.New(t)
+
+	context := func(tarWriter *tar.Writer) error {
+		for name, contents := range map[string]string{
+			"Dockerfile":                "FROM scratch",
+			"root/opt/fissile/run.sh":   "#!/bin/sh",
+			"root/var/vcap/jobs-src/x":  "job",
+			"rootless/file":             "ignored",
+			"packages-src/abc/bin/ruby": "ruby",
+		} {
+			err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))})
+			if err != nil {
+				return err
+			}
+			if _, err := tarWriter.Write([]byte(contents)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	read := func(populate func(*tar.Writer) error) map[string]string {
+		buffer := &bytes.Buffer{}
+		tarWriter := tar.NewWriter(buffer)
+		require.NoError(t, populate(tarWriter))
+		require.NoError(t, tarWriter.Close())
+
+		files := map[string]string{}
+		tarReader := tar.NewReader(buffer)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				return files
+			}
+			require.NoError(t, err)
+			contents := &bytes.Buffer{}
+			_, err = io.Copy(contents, tarReader)
+			require.NoError(t, err)
+			files[header.Name] = contents.String()
+		}
+	}
+
+	assert.Equal(map[string]string{
+		"opt/fissile/run.sh":  "#!/bin/sh",
+		"var/vcap/jobs-src/x": "job",
+	}, read(rebaseBuildContext(context, "root", "")))
+
+	assert.Equal(map[string]string{
+		"var/vcap/packages/.src/abc/bin/ruby": "ruby",
+	}, read(rebaseBuildContext(context, "packages-src", "var/vcap/packages/.src")))
+}
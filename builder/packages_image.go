@@ -263,3 +263,35 @@ func (p *PackagesImageBuilder) GetImageName(roleManifest *model.RoleManifest, in
 
 	return result, nil
 }
+
+// GroupByPackageSet partitions the instance groups by the set of packages
+// they use, so that each set can get a packages layer image of its own. A
+// change to the packages of one set then leaves the layers, and the role
+// images, of the other sets alone. The partitions are in the order of their
+// first instance group.
+func GroupByPackageSet(instanceGroups model.InstanceGroups) []model.InstanceGroups {
+	var result []model.InstanceGroups
+	indexBySet := map[string]int{}
+	for _, instanceGroup := range instanceGroups {
+		fingerprints := map[string]bool{}
+		for _, jobReference := range instanceGroup.JobReferences {
+			for _, pkg := range jobReference.Packages {
+				fingerprints[pkg.Fingerprint] = true
+			}
+		}
+		var sorted []string
+		for fingerprint := range fingerprints {
+			sorted = append(sorted, fingerprint)
+		}
+		sort.Strings(sorted)
+		set := strings.Join(sorted, ",")
+
+		if index, ok := indexBySet[set]; ok {
+			result[index] = append(result[index], instanceGroup)
+			continue
+		}
+		indexBySet[set] = len(result)
+		result = append(result, model.InstanceGroups{instanceGroup})
+	}
+	return result
+}
@@ -426,3 +426,42 @@ func TestGetRolePackageImageName(t *testing.T) {
 		assert.NotEqual(t, oldImageName, newImageName, "Changing package name should change package layer hash")
 	})
 }
+
+func TestGroupByPackageSet(t *testing.T) {
+	t.Parallel()
+
+	ruby := &model.Package{Name: "ruby", Fingerprint: "ruby-fp"}
+	golang := &model.Package{Name: "golang", Fingerprint: "golang-fp"}
+	newInstanceGroup := func(name string, jobs ...*model.Job) *model.InstanceGroup {
+		instanceGroup := &model.InstanceGroup{Name: name}
+		for _, job := range jobs {
+			instanceGroup.JobReferences = append(instanceGroup.JobReferences, &model.JobReference{Job: job, Name: job.Name})
+		}
+		return instanceGroup
+	}
+	rubyJob := &model.Job{Name: "api", Packages: model.Packages{ruby}}
+	goJob := &model.Job{Name: "router", Packages: model.Packages{golang}}
+	bothJob := &model.Job{Name: "worker", Packages: model.Packages{golang, ruby}}
+
+	instanceGroups := model.InstanceGroups{
+		newInstanceGroup("api", rubyJob),
+		newInstanceGroup("router", goJob),
+		newInstanceGroup("mixed", goJob, rubyJob),
+		newInstanceGroup("worker", bothJob),
+		newInstanceGroup("api-2", rubyJob, rubyJob),
+	}
+
+	var names [][]string
+	for _, packageSet := range GroupByPackageSet(instanceGroups) {
+		var setNames []string
+		for _, instanceGroup := range packageSet {
+			setNames = append(setNames, instanceGroup.Name)
+		}
+		names = append(names, setNames)
+	}
+	assert.Equal(t, [][]string{
+		{"api", "api-2"},
+		{"router"},
+		{"mixed", "worker"},
+	}, names)
+}
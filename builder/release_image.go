@@ -28,6 +28,8 @@ import (
 
 // ReleasesImageBuilder represents a builder of docker release images.
 type ReleasesImageBuilder struct {
+	AutoWorkers            bool
+	CacheReadOnly          bool
 	CompilationCacheConfig string
 	CompilationDir         string
 	DockerNetworkMode      string
@@ -40,6 +42,7 @@ type ReleasesImageBuilder struct {
 	MetricsPath            string
 	NoBuild                bool
 	OutputDirectory        string
+	Progress               *compilator.ProgressWriter
 	RepositoryPrefix       string
 	StemcellName           string
 	StreamPackages         bool
@@ -212,6 +215,9 @@ func (j releaseBuildJob) CompileRelease() error {
 	if err != nil {
 		return err
 	}
+	if packageStorage != nil && r.CacheReadOnly {
+		packageStorage.ReadOnly = true
+	}
 	var comp *compilator.Compilator
 	if r.WithoutDocker {
 		comp, err = compilator.NewMountNSCompilator(
@@ -232,6 +238,16 @@ func (j releaseBuildJob) CompileRelease() error {
 		if err != nil {
 			return fmt.Errorf("Error connecting to docker: %s", err.Error())
 		}
+		if err := compilator.CheckStemcellPlatform(r.UI, dockerManager, r.StemcellName, false); err != nil {
+			return err
+		}
+		if packageStorage != nil {
+			stemcellImage, err := dockerManager.FindImage(r.StemcellName)
+			if err != nil {
+				return err
+			}
+			packageStorage.SetStemcellImageID(stemcellImage.ID)
+		}
 
 		comp, err = compilator.NewDockerCompilator(
 			dockerManager,
@@ -252,6 +268,13 @@ func (j releaseBuildJob) CompileRelease() error {
 		}
 	}
 
+	if r.AutoWorkers {
+		if err := comp.EnableWorkerAutoTuning(); err != nil {
+			return fmt.Errorf("Error auto-tuning the workers: %s", err.Error())
+		}
+	}
+	comp.SetProgressWriter(r.Progress)
+
 	err = comp.Compile(j.builder.WorkerCount, model.Releases{j.release}, nil, j.builder.Verbose)
 	if err != nil {
 		return fmt.Errorf("Error compiling packages: %s", err.Error())
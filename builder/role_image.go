@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
@@ -13,6 +14,7 @@ import (
 
 	"code.cloudfoundry.org/fissile/docker"
 	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/oci"
 	"code.cloudfoundry.org/fissile/scripts/dockerfiles"
 	"code.cloudfoundry.org/fissile/util"
 	"github.com/SUSE/stampy"
@@ -23,8 +25,10 @@ import (
 )
 
 const (
-	binPrefix             = "bin"
-	jobConfigSpecFilename = "config_spec.json"
+	binPrefix = "bin"
+	// JobConfigSpecFilename is the name of the file holding the config spec
+	// of a job in the role images, next to its templates
+	JobConfigSpecFilename = "config_spec.json"
 )
 
 var (
@@ -41,8 +45,11 @@ type dockerImageBuilder interface {
 
 // RoleImageBuilder represents a builder of docker role images
 type RoleImageBuilder struct {
-	BaseImageName      string
-	DarkOpinionsPath   string
+	BaseImageName string
+	// BaseImageNames overrides BaseImageName by instance group name, if the
+	// packages layer images are split by package set
+	BaseImageNames     map[string]string
+	DarkOpinionsPaths  []string
 	DockerOrganization string
 	DockerRegistry     string
 	FissileVersion     string
@@ -52,6 +59,8 @@ type RoleImageBuilder struct {
 	ManifestPath       string
 	MetricsPath        string
 	NoBuild            bool
+	OCIBase            *OCIBaseImage
+	OCIPush            func(imageName string, image *oci.Image) error
 	OutputDirectory    string
 	RepositoryPrefix   string
 	TagExtra           string
@@ -119,13 +128,14 @@ func (r *RoleImageBuilder) NewDockerPopulator(instanceGroup *model.InstanceGroup
 				return err
 			}
 
-			// Write spec into <ROOT_DIR>/var/vcap/job-src/<JOB>/config_spec.json
-			configJSON, err := jobReference.WriteConfigs(instanceGroup, r.LightOpinionsPath, r.DarkOpinionsPath)
+			// Write spec into <ROOT_DIR>/var/vcap/job-src/<JOB>/config_spec.json;
+			// the links are resolved at runtime, never from link fixtures
+			configJSON, err := jobReference.WriteConfigs(instanceGroup, r.LightOpinionsPath, r.DarkOpinionsPaths, nil)
 			if err != nil {
 				return err
 			}
 			util.WriteToTarStream(tarWriter, configJSON, tar.Header{
-				Name: filepath.Join("root/var/vcap/jobs-src", jobReference.Name, jobConfigSpecFilename),
+				Name: filepath.Join("root/var/vcap/jobs-src", jobReference.Name, JobConfigSpecFilename),
 			})
 		}
 
@@ -196,6 +206,25 @@ func (r *RoleImageBuilder) NewDockerPopulator(instanceGroup *model.InstanceGroup
 			return err
 		}
 
+		// Copy the additional runtime scripts of the role manifest; the
+		// ones replacing the generated scripts were rendered above
+		runtimeScripts, err := instanceGroup.Manifest().GetRuntimeScriptPaths()
+		if err != nil {
+			return err
+		}
+		for name, path := range runtimeScripts {
+			if isRuntimeScriptName(name) {
+				continue
+			}
+			err := util.CopyFileToTarStream(tarWriter, path, &tar.Header{
+				Name: filepath.Join("root/opt/fissile", name),
+				Mode: 0755,
+			})
+			if err != nil {
+				return fmt.Errorf("Error writing runtime script %s: %s", name, err)
+			}
+		}
+
 		// Create env2conf templates file in /opt/fissile/env2conf.yml
 		configTemplatesBytes, err := yaml.Marshal(instanceGroup.Configuration.Templates)
 		if err != nil {
@@ -237,7 +266,7 @@ func (r *RoleImageBuilder) generateRunScript(instanceGroup *model.InstanceGroup,
 	}
 	sort.Strings(secrets)
 
-	asset, err := dockerfiles.Asset(assetName)
+	asset, err := runtimeScriptSource(instanceGroup, assetName)
 	if err != nil {
 		return nil, err
 	}
@@ -269,6 +298,32 @@ func (r *RoleImageBuilder) generateRunScript(instanceGroup *model.InstanceGroup,
 	return output.Bytes(), nil
 }
 
+// runtimeScriptSource returns the template of a runtime script, from the
+// runtime scripts directory of the role manifest if it overrides it
+func runtimeScriptSource(instanceGroup *model.InstanceGroup, assetName string) ([]byte, error) {
+	if roleManifest := instanceGroup.Manifest(); roleManifest != nil {
+		paths, err := roleManifest.GetRuntimeScriptPaths()
+		if err != nil {
+			return nil, err
+		}
+		if path, ok := paths[assetName]; ok {
+			return ioutil.ReadFile(path)
+		}
+	}
+	return dockerfiles.Asset(assetName)
+}
+
+// isRuntimeScriptName returns true if the file replaces a generated runtime
+// script
+func isRuntimeScriptName(name string) bool {
+	for _, scriptName := range model.RuntimeScriptNames {
+		if name == scriptName {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *RoleImageBuilder) generateJobsConfig(instanceGroup *model.InstanceGroup) ([]byte, error) {
 	jobsConfig := make(map[string]map[string]interface{})
 
@@ -307,6 +362,15 @@ func (r *RoleImageBuilder) generateJobsConfig(instanceGroup *model.InstanceGroup
 	return jsonOut, nil
 }
 
+// baseImageName returns the name of the packages layer image the role image
+// of the instance group is built on
+func (r *RoleImageBuilder) baseImageName(instanceGroup *model.InstanceGroup) string {
+	if name, ok := r.BaseImageNames[instanceGroup.Name]; ok {
+		return name
+	}
+	return r.BaseImageName
+}
+
 // generateDockerfile builds a docker file for a given role.
 func (r *RoleImageBuilder) generateDockerfile(instanceGroup *model.InstanceGroup, outputFile io.Writer) error {
 	asset, err := dockerfiles.Asset("Dockerfile-role")
@@ -317,7 +381,7 @@ func (r *RoleImageBuilder) generateDockerfile(instanceGroup *model.InstanceGroup
 	dockerfileTemplate := template.New("Dockerfile-role")
 
 	context := map[string]interface{}{
-		"base_image":     r.BaseImageName,
+		"base_image":     r.baseImageName(instanceGroup),
 		"instance_group": instanceGroup,
 		"licenses":       instanceGroup.JobReferences[0].Release.License.Files,
 	}
@@ -347,7 +411,7 @@ func (j roleBuildJob) Run() {
 	}
 
 	j.resultsCh <- func() error {
-		opinions, err := model.NewOpinions(j.builder.LightOpinionsPath, j.builder.DarkOpinionsPath)
+		opinions, err := model.NewOpinionsWithDefaults(j.instanceGroup.SpecDefaults(), j.builder.LightOpinionsPath, j.builder.DarkOpinionsPaths...)
 		if err != nil {
 			return err
 		}
@@ -358,7 +422,7 @@ func (j roleBuildJob) Run() {
 		}
 
 		if j.builder.Grapher != nil {
-			_ = j.builder.Grapher.GraphEdge(j.builder.BaseImageName, devVersion, nil)
+			_ = j.builder.Grapher.GraphEdge(j.builder.baseImageName(j.instanceGroup), devVersion, nil)
 		}
 
 		var roleImageName string
@@ -373,7 +437,25 @@ func (j roleBuildJob) Run() {
 		}
 
 		if !j.builder.Force {
-			if j.builder.OutputDirectory == "" {
+			if j.builder.OCIBase != nil {
+				if hasImage, err := j.builder.OCIBase.Layout.HasImage(roleImageName); err != nil {
+					return err
+				} else if hasImage {
+					j.builder.UI.Printf("Skipping assembly of role image %s because it exists\n", color.YellowString(j.instanceGroup.Name))
+					if j.builder.OCIPush == nil {
+						return nil
+					}
+					if j.builder.NoBuild {
+						j.builder.UI.Printf("Skipping push of role image %s because of flag\n", color.YellowString(roleImageName))
+						return nil
+					}
+					image, err := j.builder.OCIBase.Layout.Image(roleImageName)
+					if err != nil {
+						return err
+					}
+					return j.builder.pushOCIImage(roleImageName, image)
+				}
+			} else if j.builder.OutputDirectory == "" {
 				if hasImage, err := j.dockerManager.HasImage(roleImageName); err != nil {
 					return err
 				} else if hasImage {
@@ -410,7 +492,19 @@ func (j roleBuildJob) Run() {
 			return nil
 		}
 
-		if j.builder.OutputDirectory == "" {
+		if j.builder.OCIBase != nil {
+			j.builder.UI.Printf("Assembling OCI image of %s...\n", color.YellowString(j.instanceGroup.Name))
+
+			image, err := j.builder.assembleOCIImage(j.instanceGroup, roleImageName)
+			if err != nil {
+				return fmt.Errorf("Error assembling image: %s", err.Error())
+			}
+			if j.builder.OCIPush != nil {
+				if err := j.builder.pushOCIImage(roleImageName, image); err != nil {
+					return err
+				}
+			}
+		} else if j.builder.OutputDirectory == "" {
 			j.builder.UI.Printf("Building docker image of %s...\n", color.YellowString(j.instanceGroup.Name))
 
 			log := new(bytes.Buffer)
@@ -453,9 +547,13 @@ func (r *RoleImageBuilder) Build(instanceGroups model.InstanceGroups) error {
 		return fmt.Errorf("Invalid worker count %d", r.WorkerCount)
 	}
 
-	dockerManager, err := newDockerImageBuilder()
-	if err != nil {
-		return fmt.Errorf("Error connecting to docker: %s", err.Error())
+	var dockerManager dockerImageBuilder
+	var err error
+	if r.OCIBase == nil {
+		dockerManager, err = newDockerImageBuilder()
+		if err != nil {
+			return fmt.Errorf("Error connecting to docker: %s", err.Error())
+		}
 	}
 
 	if r.OutputDirectory != "" {
@@ -498,6 +596,21 @@ func (r *RoleImageBuilder) Build(instanceGroups model.InstanceGroups) error {
 
 // GetRoleDevImageName generates a docker image name to be used as a dev role image
 func GetRoleDevImageName(registry, organization, repositoryPrefix string, instanceGroup *model.InstanceGroup, version string) string {
+	imageName := getRoleDevImageRepository(registry, organization, repositoryPrefix, instanceGroup)
+	return fmt.Sprintf("%s:%s", imageName, util.SanitizeDockerName(version))
+}
+
+// GetRoleDevImageDigestName generates a docker image name referencing the
+// image of the instance group by its digest, e.g. "sha256:...", instead of
+// its tag
+func GetRoleDevImageDigestName(registry, organization, repositoryPrefix string, instanceGroup *model.InstanceGroup, digest string) string {
+	imageName := getRoleDevImageRepository(registry, organization, repositoryPrefix, instanceGroup)
+	return fmt.Sprintf("%s@%s", imageName, digest)
+}
+
+// getRoleDevImageRepository returns the name of the image of the instance
+// group without a tag or digest
+func getRoleDevImageRepository(registry, organization, repositoryPrefix string, instanceGroup *model.InstanceGroup) string {
 	var imageName string
 	if registry != "" {
 		imageName = registry + "/"
@@ -507,7 +620,5 @@ func GetRoleDevImageName(registry, organization, repositoryPrefix string, instan
 		imageName += util.SanitizeDockerName(organization) + "/"
 	}
 
-	imageName += util.SanitizeDockerName(util.PrefixString(instanceGroup.Name, repositoryPrefix, "-"))
-
-	return fmt.Sprintf("%s:%s", imageName, util.SanitizeDockerName(version))
+	return imageName + util.SanitizeDockerName(util.PrefixString(instanceGroup.ImageName(), repositoryPrefix, "-"))
 }
@@ -16,8 +16,10 @@ import (
 
 	"code.cloudfoundry.org/fissile/model"
 	"code.cloudfoundry.org/fissile/model/loader"
+	"code.cloudfoundry.org/fissile/oci"
 	"github.com/SUSE/termui"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func newRoleImageBuilder(manifestPath, lightOpinionsPath, darkOpinionsPath string) *RoleImageBuilder {
@@ -32,7 +34,7 @@ func newRoleImageBuilder(manifestPath, lightOpinionsPath, darkOpinionsPath strin
 		FissileVersion:    "6.28.30",
 		TagExtra:          "",
 		LightOpinionsPath: lightOpinionsPath,
-		DarkOpinionsPath:  darkOpinionsPath,
+		DarkOpinionsPaths: []string{darkOpinionsPath},
 		ManifestPath:      manifestPath,
 		UI:                ui,
 		Grapher:           nil,
@@ -82,6 +84,14 @@ func TestGenerateRoleImageDockerfile(t *testing.T) {
 	assert.NoError(err)
 	dockerfileString = dockerfileContents.String()
 	assert.Contains(dockerfileString, "MAINTAINER", "dev mode should generate a maintainer layer")
+
+	dockerfileContents.Reset()
+	roleImageBuilder.BaseImageNames = map[string]string{roleManifest.InstanceGroups[0].Name: "split-packages:1234"}
+	err = roleImageBuilder.generateDockerfile(roleManifest.InstanceGroups[0], &dockerfileContents)
+	assert.NoError(err)
+	assert.Contains(dockerfileContents.String(), "FROM split-packages:1234", "the packages layer of the instance group should be used")
+	assert.Contains(dockerfileContents.String(), `LABEL "packages_image"="split-packages:1234"`,
+		"the role image should reference its packages layer")
 }
 
 func TestGenerateRoleImageRunScript(t *testing.T) {
@@ -148,6 +158,49 @@ func TestGenerateRoleImageRunScript(t *testing.T) {
 	}
 }
 
+func TestGenerateRoleImageRunScriptOverride(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/builder/tor-good.yml")
+	roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{releasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../test-assets/.final_releases")},
+		ValidationOptions: model.RoleManifestValidationOptions{
+			AllowMissingScripts: true,
+		}})
+	require.NoError(t, err)
+
+	scriptsDir, err := ioutil.TempDir("", "fissile-runtime-scripts")
+	require.NoError(t, err)
+	defer os.RemoveAll(scriptsDir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(scriptsDir, "run.sh"),
+		[]byte("#!/bin/bash\necho {{ .instance_group.Name }}\nsource /opt/fissile/helper.sh\n"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(scriptsDir, "helper.sh"), []byte("true\n"), 0644))
+	roleManifest.RuntimeScripts, err = filepath.Rel(filepath.Dir(roleManifestPath), scriptsDir)
+	require.NoError(t, err)
+
+	torOpinionsDir := filepath.Join(workDir, "../test-assets/tor-opinions")
+	roleImageBuilder := newRoleImageBuilder(roleManifestPath,
+		filepath.Join(torOpinionsDir, "opinions.yml"), filepath.Join(torOpinionsDir, "dark-opinions.yml"))
+
+	runScriptContents, err := roleImageBuilder.generateRunScript(roleManifest.InstanceGroups[0], "run.sh")
+	if assert.NoError(err) {
+		assert.Equal("#!/bin/bash\necho myrole\nsource /opt/fissile/helper.sh\n", string(runScriptContents))
+	}
+
+	preStopScriptContents, err := roleImageBuilder.generateRunScript(roleManifest.InstanceGroups[0], "pre-stop.sh")
+	if assert.NoError(err) {
+		assert.Contains(string(preStopScriptContents), "Running pre-stop script", "Scripts which are not overridden should be generated")
+	}
+}
+
 func TestGenerateRoleImageJobsConfig(t *testing.T) {
 	assert := assert.New(t)
 
@@ -433,7 +486,7 @@ func TestBuildRoleImages(t *testing.T) {
 		RepositoryPrefix:   "test-repository",
 		ManifestPath:       roleManifestPath,
 		LightOpinionsPath:  lightOpinionsPath,
-		DarkOpinionsPath:   darkOpinionsPath,
+		DarkOpinionsPaths:  []string{darkOpinionsPath},
 		MetricsPath:        "",
 		TagExtra:           "deadbeef",
 		FissileVersion:     "6.28.30",
@@ -534,6 +587,55 @@ func TestBuildRoleImages(t *testing.T) {
 	assert.Regexp(regexp.MustCompile(expected), string(contents))
 }
 
+func TestBuildRoleImagesOCIPush(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/builder/tor-good.yml")
+	roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{filepath.Join(workDir, "../test-assets/tor-boshrelease")},
+			BOSHCacheDir:     filepath.Join(workDir, "../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../test-assets/.final_releases"),
+		}})
+	require.NoError(t, err)
+
+	layoutDir, err := ioutil.TempDir("", "fissile-oci-push")
+	require.NoError(t, err)
+	defer os.RemoveAll(layoutDir)
+	layout, err := oci.NewLayout(layoutDir)
+	require.NoError(t, err)
+
+	torOpinionsDir := filepath.Join(workDir, "../test-assets/tor-opinions")
+	roleImageBuilder := newRoleImageBuilder(roleManifestPath,
+		filepath.Join(torOpinionsDir, "opinions.yml"),
+		filepath.Join(torOpinionsDir, "dark-opinions.yml"))
+	roleImageBuilder.WorkerCount = 1
+	roleImageBuilder.OCIBase = &OCIBaseImage{Layout: layout, Stemcell: &oci.Image{}}
+
+	var pushed []string
+	roleImageBuilder.OCIPush = func(imageName string, image *oci.Image) error {
+		pushed = append(pushed, imageName)
+		return nil
+	}
+
+	require.NoError(t, roleImageBuilder.Build(roleManifest.InstanceGroups))
+	assembled := pushed
+	assert.Len(assembled, len(roleManifest.InstanceGroups))
+
+	// Images which already exist are pushed without being assembled again
+	pushed = nil
+	require.NoError(t, roleImageBuilder.Build(roleManifest.InstanceGroups))
+	assert.Equal(assembled, pushed)
+
+	pushed = nil
+	roleImageBuilder.NoBuild = true
+	require.NoError(t, roleImageBuilder.Build(roleManifest.InstanceGroups))
+	assert.Empty(pushed)
+}
+
 func TestGetRoleDevImageName(t *testing.T) {
 	assert := assert.New(t)
 
@@ -565,4 +667,10 @@ func TestGetRoleDevImageName(t *testing.T) {
 	expected = "test-registry:9000/test-org/test-repository-foorole:a886ed76c6d6e5a96ad5c37fb208368a430a29d770f1d149a78e1e6e8091eb12"
 	imageName = GetRoleDevImageName(reg, org, repo, &instanceGroup, version)
 	assert.Equal(expected, imageName)
+
+	// Test with a digest
+	digest := "sha256:" + version
+	expected = "test-registry:9000/test-org/test-repository-foorole@sha256:a886ed76c6d6e5a96ad5c37fb208368a430a29d770f1d149a78e1e6e8091eb12"
+	imageName = GetRoleDevImageDigestName(reg, org, repo, &instanceGroup, digest)
+	assert.Equal(expected, imageName)
 }
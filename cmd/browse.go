@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"os"
+
+	"code.cloudfoundry.org/fissile/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	flagBrowseLineMode bool
+)
+
+// browseCmd represents the browse command
+var browseCmd = &cobra.Command{
+	Use:   "browse",
+	Short: "Interactively explores the role manifest and its releases.",
+	Long: `
+Starts a read-only browser of the loaded role manifest. The releases (with
+their jobs and properties) and the instance groups (with their jobs and links)
+are shown as a tree, with a YAML preview of the selected entry next to it.
+
+Use the arrow keys (or h, j, k, l) to move through the tree and expand or
+collapse entries, / to search for entries by name, n to jump to the next
+match, and q to quit.
+
+With --line-mode, or if the standard input or output is not a terminal, the
+tree is navigated like a file system instead, with commands read line by line:
+ls and cd to navigate, find to search, and show for the YAML preview.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flagBrowseLineMode = browseViper.GetBool("line-mode")
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.Browse(app.BrowseOptions{
+			LineMode: flagBrowseLineMode ||
+				!terminal.IsTerminal(int(os.Stdin.Fd())) ||
+				!terminal.IsTerminal(int(os.Stdout.Fd())),
+		})
+	},
+}
+var browseViper = viper.New()
+
+func init() {
+	initViper(browseViper)
+
+	RootCmd.AddCommand(browseCmd)
+
+	browseCmd.PersistentFlags().BoolP(
+		"line-mode",
+		"",
+		false,
+		"Read commands line by line instead of running the terminal UI",
+	)
+
+	browseViper.BindPFlags(browseCmd.PersistentFlags())
+}
@@ -1,18 +1,35 @@
 package cmd
 
 import (
+	"path/filepath"
+
 	"code.cloudfoundry.org/fissile/kube"
-	"code.cloudfoundry.org/fissile/model"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	flagBuildHelmOutputDir       string
-	flagBuildHelmUseMemoryLimits bool
-	flagBuildHelmUseCPULimits    bool
-	flagBuildHelmTagExtra        string
-	flagBuildHelmAuthType        string
+	flagBuildHelmOutputDir         string
+	flagBuildHelmUseMemoryLimits   bool
+	flagBuildHelmUseCPULimits      bool
+	flagBuildHelmTagExtra          string
+	flagBuildHelmAuthType          string
+	flagBuildHelmIntegration       []string
+	flagBuildHelmIntegrationDir    string
+	flagBuildHelmHelperScripts     bool
+	flagBuildHelmSecretStringData  bool
+	flagBuildHelmSecretBackend     string
+	flagBuildHelmExtensionsDir     string
+	flagBuildHelmAuditClusterScope bool
+	flagBuildHelmPolicyBundle      bool
+	flagBuildHelmInclude           []string
+	flagBuildHelmExclude           []string
+	flagBuildHelmLocalVolumes      string
+	flagBuildHelmImageDigests      string
+	flagBuildHelmHelmVersion       int
+	flagBuildHelmChartName         string
+	flagBuildHelmChartVersion      string
+	flagBuildHelmLint              bool
 )
 
 // buildHelmCmd represents the helm command
@@ -26,8 +43,59 @@ var buildHelmCmd = &cobra.Command{
 		flagBuildHelmUseCPULimits = buildHelmViper.GetBool("use-cpu-limits")
 		flagBuildHelmTagExtra = buildHelmViper.GetString("tag-extra")
 		flagBuildHelmAuthType = buildHelmViper.GetString("auth-type")
+		flagBuildHelmIntegration = buildHelmViper.GetStringSlice("integration-snippets")
+		flagBuildHelmIntegrationDir = buildHelmViper.GetString("integration-dir")
+		flagBuildHelmHelperScripts = buildHelmViper.GetBool("helper-scripts")
+		flagBuildHelmSecretStringData = buildHelmViper.GetBool("secret-string-data")
+		flagBuildHelmSecretBackend = buildHelmViper.GetString("secret-backend")
+		flagBuildHelmExtensionsDir = buildHelmViper.GetString("extension-snippets")
+		flagBuildHelmAuditClusterScope = buildHelmViper.GetBool("audit-cluster-scope")
+		flagBuildHelmPolicyBundle = buildHelmViper.GetBool("policy-bundle")
+		flagBuildHelmImageDigests = buildHelmViper.GetString("image-digests")
+		flagBuildHelmLocalVolumes = buildHelmViper.GetString("local-volumes")
+		flagBuildHelmInclude = buildHelmViper.GetStringSlice("include")
+		flagBuildHelmExclude = buildHelmViper.GetStringSlice("exclude")
+		flagBuildHelmHelmVersion = buildHelmViper.GetInt("helm-version")
+		flagBuildHelmChartName = buildHelmViper.GetString("chart-name")
+		flagBuildHelmChartVersion = buildHelmViper.GetString("chart-version")
+		flagBuildHelmLint = buildHelmViper.GetBool("lint")
 
-		err := fissile.GraphBegin(buildViper.GetString("output-graph"))
+		err := kube.ValidateIntegrationSnippets(flagBuildHelmIntegration)
+		if err != nil {
+			return err
+		}
+
+		err = kube.ValidateIntegrationDir(flagBuildHelmIntegration, flagBuildHelmOutputDir, flagBuildHelmIntegrationDir)
+		if err != nil {
+			return err
+		}
+
+		err = kube.ValidateHelmVersion(flagBuildHelmHelmVersion)
+		if err != nil {
+			return err
+		}
+
+		err = kube.ValidateSecretBackend(flagBuildHelmSecretBackend)
+		if err != nil {
+			return err
+		}
+		if flagBuildHelmChartName == "" {
+			outputDir, err := filepath.Abs(flagBuildHelmOutputDir)
+			if err != nil {
+				return err
+			}
+			flagBuildHelmChartName = filepath.Base(outputDir)
+		}
+
+		var extensionSnippets map[string]string
+		if flagBuildHelmExtensionsDir != "" {
+			extensionSnippets, err = kube.ReadExtensionSnippets(flagBuildHelmExtensionsDir)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = fissile.GraphBegin(buildViper.GetString("output-graph"))
 		if err != nil {
 			return err
 		}
@@ -37,10 +105,7 @@ var buildHelmCmd = &cobra.Command{
 			return err
 		}
 
-		opinions, err := model.NewOpinions(
-			fissile.Options.LightOpinions,
-			fissile.Options.DarkOpinions,
-		)
+		opinions, err := fissile.LoadOpinions()
 		if err != nil {
 			return err
 		}
@@ -59,6 +124,38 @@ var buildHelmCmd = &cobra.Command{
 			CreateHelmChart: true,
 			TagExtra:        flagBuildHelmTagExtra,
 			AuthType:        flagBuildHelmAuthType,
+
+			IntegrationSnippets: flagBuildHelmIntegration,
+			IntegrationDir:      flagBuildHelmIntegrationDir,
+			CreateHelperScripts: flagBuildHelmHelperScripts,
+			SecretStringData:    flagBuildHelmSecretStringData,
+			SecretBackend:       flagBuildHelmSecretBackend,
+			ExtensionSnippets:   extensionSnippets,
+			AuditClusterScope:   flagBuildHelmAuditClusterScope,
+			PolicyBundle:        flagBuildHelmPolicyBundle,
+			HelmVersion:         flagBuildHelmHelmVersion,
+			ChartName:           flagBuildHelmChartName,
+			ChartVersion:        flagBuildHelmChartVersion,
+			LintTemplates:       flagBuildHelmLint,
+		}
+
+		if flagBuildHelmImageDigests != "" {
+			settings.ImageDigests, err = kube.ReadImageDigests(flagBuildHelmImageDigests)
+			if err != nil {
+				return err
+			}
+		}
+
+		settings.InstanceGroupFilter = kube.InstanceGroupFilter{
+			Include: flagBuildHelmInclude,
+			Exclude: flagBuildHelmExclude,
+		}
+
+		if flagBuildHelmLocalVolumes != "" {
+			settings.LocalVolumes, err = kube.ReadLocalVolumes(flagBuildHelmLocalVolumes)
+			if err != nil {
+				return err
+			}
 		}
 
 		return fissile.GenerateKube(settings)
@@ -113,5 +210,117 @@ func init() {
 		"Sets the Kubernetes auth type",
 	)
 
+	buildHelmCmd.PersistentFlags().StringSliceP(
+		"integration-snippets",
+		"",
+		nil,
+		"Additional integration snippets to write into the integration directory; any of \"helmfile\" (helmfile.yaml) or \"terraform\" (helm_release.tf)",
+	)
+
+	buildHelmCmd.PersistentFlags().StringP(
+		"integration-dir",
+		"",
+		"",
+		"Directory to write the integration snippets to; required with --integration-snippets, and must not be inside the chart",
+	)
+
+	buildHelmCmd.PersistentFlags().BoolP(
+		"helper-scripts",
+		"",
+		false,
+		"Write kubectl helper scripts for the instance groups into the bin directory of the chart",
+	)
+
+	buildHelmCmd.PersistentFlags().BoolP(
+		"secret-string-data",
+		"",
+		false,
+		"Write non-binary secret values as stringData instead of base64-encoded data",
+	)
+
+	buildHelmCmd.PersistentFlags().StringP(
+		"secret-backend",
+		"",
+		kube.SecretBackendKubernetes,
+		"Backend the containers get the secrets from: kubernetes, vault-agent (Vault Agent injector) or vault-csi (secrets store CSI driver)",
+	)
+
+	buildHelmCmd.PersistentFlags().StringP(
+		"extension-snippets",
+		"",
+		"",
+		"Directory of template snippets overriding the extension points of the pod templates, named after the extension point, e.g. extraVolumes.yaml",
+	)
+
+	buildHelmCmd.PersistentFlags().BoolP(
+		"audit-cluster-scope",
+		"",
+		false,
+		"List the cluster-scoped resources (e.g. cluster roles and pod security policies) that would be created, and fail unless configuration.cluster_scoped of the role manifest allows their kinds",
+	)
+
+	buildHelmCmd.PersistentFlags().BoolP(
+		"policy-bundle",
+		"",
+		false,
+		"Write a summary of the security-relevant settings of the workloads (capabilities, host access, privileges) and the exemptions they need from the Gatekeeper policy library to the policy directory",
+	)
+
+	buildHelmCmd.PersistentFlags().StringP(
+		"image-digests",
+		"",
+		"",
+		"Path of a digests file written by fissile push images --digests-file; the role images are referenced by these digests instead of their tags",
+	)
+
+	buildHelmCmd.PersistentFlags().StringP(
+		"local-volumes",
+		"",
+		"",
+		"Path of a file mapping the persistent volumes of the instance groups onto directories of the nodes; local persistent volumes bound to the claims are written for them",
+	)
+
+	buildHelmCmd.PersistentFlags().StringSliceP(
+		"include",
+		"",
+		nil,
+		"Only (re)generate the named instance groups, and the RBAC resources they use; all other files are left alone",
+	)
+
+	buildHelmCmd.PersistentFlags().StringSliceP(
+		"exclude",
+		"",
+		nil,
+		"Don't (re)generate the named instance groups; like --include, only instance groups and RBAC resources are generated",
+	)
+
+	buildHelmCmd.PersistentFlags().IntP(
+		"helm-version",
+		"",
+		kube.HelmVersion2,
+		"Major version of helm the chart is generated for; helm 3 charts include an apiVersion v2 Chart.yaml",
+	)
+
+	buildHelmCmd.PersistentFlags().StringP(
+		"chart-name",
+		"",
+		"",
+		"Name of the chart in the Chart.yaml of helm 3 charts; defaults to the name of the output directory",
+	)
+
+	buildHelmCmd.PersistentFlags().StringP(
+		"chart-version",
+		"",
+		"0.1.0",
+		"Semantic version of the chart in the Chart.yaml of helm 3 charts",
+	)
+
+	buildHelmCmd.PersistentFlags().BoolP(
+		"lint",
+		"",
+		true,
+		"Parse and execute the templates with the default values while writing them, and fail on invalid template expressions instead of leaving them to helm install",
+	)
+
 	buildHelmViper.BindPFlags(buildHelmCmd.PersistentFlags())
 }
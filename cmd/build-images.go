@@ -31,6 +31,23 @@ the image.
 
 The ` + "`--patch-properties-release`" + ` flag is used to distinguish the patchProperties release/job spec
 from other specs.  At most one is allowed.
+
+The experimental ` + "`--oci-layout`" + ` flag assembles the images as an OCI image layout in
+the given directory instead of building them with docker, so that no docker daemon
+is needed. The stemcell image is then read from the OCI image layout given by
+` + "`--oci-stemcell-layout`" + `, and the images can be pushed straight to their registry
+with ` + "`--oci-push`" + `. Images already in the layout are pushed as well, unless
+` + "`--no-build`" + ` is given.
+
+By default, the packages of all instance groups are added to a single packages
+layer image the role images are built on, so that changing any package rebuilds
+all role images. With ` + "`--split-packages-layers`" + `, a packages layer is built
+for each set of packages used by the instance groups instead, and a change only
+rebuilds the images of the instance groups using the changed package. Each
+layer is named after the fingerprints of its packages, and is built on the
+existing layer sharing most of them. Given ` + "`--packages-layer-cache`" + `,
+a registry repository, missing packages layers are pulled from it, and layers
+built are pushed to it, so that they are shared by CI runs.
 	`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var opt app.BuildImagesOptions
@@ -39,6 +56,11 @@ from other specs.  At most one is allowed.
 		opt.Force = buildImagesViper.GetBool("force")
 		opt.PatchPropertiesDirective = buildImagesViper.GetString("patch-properties-release")
 		opt.OutputDirectory = buildImagesViper.GetString("output-directory")
+		opt.SplitPackagesLayers = buildImagesViper.GetBool("split-packages-layers")
+		opt.PackagesLayerCache = buildImagesViper.GetString("packages-layer-cache")
+		opt.OCILayout = buildImagesViper.GetString("oci-layout")
+		opt.OCIStemcellLayout = buildImagesViper.GetString("oci-stemcell-layout")
+		opt.OCIPush = buildImagesViper.GetBool("oci-push")
 		opt.Stemcell = buildImagesViper.GetString("stemcell")
 		opt.StemcellID = buildImagesViper.GetString("stemcell-id")
 		opt.TagExtra = buildImagesViper.GetString("tag-extra")
@@ -59,6 +81,19 @@ from other specs.  At most one is allowed.
 			return err
 		}
 
+		if opt.OCILayout != "" && opt.OutputDirectory != "" {
+			return fmt.Errorf("--oci-layout and --output-directory are mutually exclusive")
+		}
+		if opt.OCIPush && opt.OCILayout == "" {
+			return fmt.Errorf("--oci-push requires --oci-layout")
+		}
+		if opt.SplitPackagesLayers && opt.OCILayout != "" {
+			return fmt.Errorf("--split-packages-layers and --oci-layout are mutually exclusive")
+		}
+		if opt.PackagesLayerCache != "" && (opt.OCILayout != "" || opt.OutputDirectory != "") {
+			return fmt.Errorf("--packages-layer-cache requires building the images with docker")
+		}
+
 		if opt.OutputDirectory != "" && !opt.Force {
 			fissile.UI.Printf("--force required when --output-directory is set\n")
 			opt.Force = true
@@ -110,6 +145,41 @@ func init() {
 		"Output the result as tar files in the given directory rather than building with docker",
 	)
 
+	buildImagesCmd.PersistentFlags().StringP(
+		"oci-layout",
+		"",
+		"",
+		"Assemble the images as an OCI image layout in the given directory rather than building with docker (experimental)",
+	)
+
+	buildImagesCmd.PersistentFlags().StringP(
+		"oci-stemcell-layout",
+		"",
+		"",
+		"OCI image layout holding the stemcell image, for use with --oci-layout",
+	)
+
+	buildImagesCmd.PersistentFlags().BoolP(
+		"oci-push",
+		"",
+		false,
+		"Push the images assembled with --oci-layout to their registry",
+	)
+
+	buildImagesCmd.PersistentFlags().BoolP(
+		"split-packages-layers",
+		"",
+		false,
+		"Build a packages layer image for each set of packages used by the instance groups, rather than one for all of them",
+	)
+
+	buildImagesCmd.PersistentFlags().StringP(
+		"packages-layer-cache",
+		"",
+		"",
+		"Registry repository to pull missing packages layer images from, and to push the built ones to",
+	)
+
 	buildImagesCmd.PersistentFlags().StringP(
 		"stemcell",
 		"s",
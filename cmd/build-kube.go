@@ -1,17 +1,42 @@
 package cmd
 
 import (
+	"os"
+
+	"code.cloudfoundry.org/fissile/app"
 	"code.cloudfoundry.org/fissile/kube"
-	"code.cloudfoundry.org/fissile/model"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	flagBuildKubeOutputDir       string
-	flagBuildKubeUseMemoryLimits bool
-	flagBuildKubeUseCPULimits    bool
-	flagBuildKubeTagExtra        string
+	flagBuildKubeOutputDir         string
+	flagBuildKubeUseMemoryLimits   bool
+	flagBuildKubeUseCPULimits      bool
+	flagBuildKubeTagExtra          string
+	flagBuildKubeHelperScripts     bool
+	flagBuildKubeSecretStringData  bool
+	flagBuildKubeSecretBackend     string
+	flagBuildKubeStreamOutput      string
+	flagBuildKubeValues            string
+	flagBuildKubeValuesFromEnv     bool
+	flagBuildKubeReleaseName       string
+	flagBuildKubeNamespace         string
+	flagBuildKubeChartName         string
+	flagBuildKubeChartVersion      string
+	flagBuildKubeKubeVersion       string
+	flagBuildKubeAPIVersions       []string
+	flagBuildKubeAuditClusterScope bool
+	flagBuildKubePolicyBundle      bool
+	flagBuildKubeInclude           []string
+	flagBuildKubeExclude           []string
+	flagBuildKubeLocalVolumes      string
+	flagBuildKubeImageDigests      string
+	flagBuildKubeQuarksDeployment  string
+	flagBuildKubeApplyPlan         bool
+	flagBuildKubeWatch             bool
+	flagBuildKubeObjectSizeWarning int
+	flagBuildKubeObjectSizeLimit   int
 )
 
 // buildKubeCmd represents the kube command
@@ -24,8 +49,36 @@ var buildKubeCmd = &cobra.Command{
 		flagBuildKubeUseMemoryLimits = buildKubeViper.GetBool("use-memory-limits")
 		flagBuildKubeUseCPULimits = buildKubeViper.GetBool("use-cpu-limits")
 		flagBuildKubeTagExtra = buildKubeViper.GetString("tag-extra")
+		flagBuildKubeHelperScripts = buildKubeViper.GetBool("helper-scripts")
+		flagBuildKubeSecretStringData = buildKubeViper.GetBool("secret-string-data")
+		flagBuildKubeSecretBackend = buildKubeViper.GetString("secret-backend")
+		flagBuildKubeStreamOutput = buildKubeViper.GetString("stream-output")
+		flagBuildKubeValues = buildKubeViper.GetString("values")
+		flagBuildKubeValuesFromEnv = buildKubeViper.GetBool("values-from-env")
+		flagBuildKubeReleaseName = buildKubeViper.GetString("render-release-name")
+		flagBuildKubeNamespace = buildKubeViper.GetString("render-namespace")
+		flagBuildKubeChartName = buildKubeViper.GetString("render-chart-name")
+		flagBuildKubeChartVersion = buildKubeViper.GetString("render-chart-version")
+		flagBuildKubeKubeVersion = buildKubeViper.GetString("render-kube-version")
+		flagBuildKubeAPIVersions = buildKubeViper.GetStringSlice("render-api-versions")
+		flagBuildKubeAuditClusterScope = buildKubeViper.GetBool("audit-cluster-scope")
+		flagBuildKubePolicyBundle = buildKubeViper.GetBool("policy-bundle")
+		flagBuildKubeImageDigests = buildKubeViper.GetString("image-digests")
+		flagBuildKubeLocalVolumes = buildKubeViper.GetString("local-volumes")
+		flagBuildKubeInclude = buildKubeViper.GetStringSlice("include")
+		flagBuildKubeExclude = buildKubeViper.GetStringSlice("exclude")
+		flagBuildKubeQuarksDeployment = buildKubeViper.GetString("quarks-deployment")
+		flagBuildKubeApplyPlan = buildKubeViper.GetBool("apply-plan")
+		flagBuildKubeWatch = buildKubeViper.GetBool("watch")
+		flagBuildKubeObjectSizeWarning = buildKubeViper.GetInt("object-size-warning")
+		flagBuildKubeObjectSizeLimit = buildKubeViper.GetInt("object-size-limit")
 
-		err := fissile.GraphBegin(buildViper.GetString("output-graph"))
+		err := kube.ValidateSecretBackend(flagBuildKubeSecretBackend)
+		if err != nil {
+			return err
+		}
+
+		err = fissile.GraphBegin(buildViper.GetString("output-graph"))
 		if err != nil {
 			return err
 		}
@@ -35,10 +88,7 @@ var buildKubeCmd = &cobra.Command{
 			return err
 		}
 
-		opinions, err := model.NewOpinions(
-			fissile.Options.LightOpinions,
-			fissile.Options.DarkOpinions,
-		)
+		opinions, err := fissile.LoadOpinions()
 		if err != nil {
 			return err
 		}
@@ -56,8 +106,63 @@ var buildKubeCmd = &cobra.Command{
 			Opinions:        opinions,
 			CreateHelmChart: false,
 			TagExtra:        flagBuildKubeTagExtra,
+
+			CreateHelperScripts: flagBuildKubeHelperScripts,
+			SecretStringData:    flagBuildKubeSecretStringData,
+			SecretBackend:       flagBuildKubeSecretBackend,
+			StreamOutput:        flagBuildKubeStreamOutput,
+			AuditClusterScope:   flagBuildKubeAuditClusterScope,
+			PolicyBundle:        flagBuildKubePolicyBundle,
+			QuarksDeployment:    flagBuildKubeQuarksDeployment,
+			CreateApplyPlan:     flagBuildKubeApplyPlan,
+		}
+
+		if flagBuildKubeImageDigests != "" {
+			settings.ImageDigests, err = kube.ReadImageDigests(flagBuildKubeImageDigests)
+			if err != nil {
+				return err
+			}
+		}
+
+		settings.InstanceGroupFilter = kube.InstanceGroupFilter{
+			Include: flagBuildKubeInclude,
+			Exclude: flagBuildKubeExclude,
 		}
 
+		if flagBuildKubeLocalVolumes != "" {
+			settings.LocalVolumes, err = kube.ReadLocalVolumes(flagBuildKubeLocalVolumes)
+			if err != nil {
+				return err
+			}
+		}
+
+		if flagBuildKubeValues != "" || flagBuildKubeValuesFromEnv {
+			settings.Render = &kube.RenderOptions{
+				Values:       map[string]interface{}{},
+				ReleaseName:  flagBuildKubeReleaseName,
+				Namespace:    flagBuildKubeNamespace,
+				ChartName:    flagBuildKubeChartName,
+				ChartVersion: flagBuildKubeChartVersion,
+				KubeVersion:  flagBuildKubeKubeVersion,
+				APIVersions:  flagBuildKubeAPIVersions,
+
+				ObjectSizeWarning: flagBuildKubeObjectSizeWarning,
+				ObjectSizeLimit:   flagBuildKubeObjectSizeLimit,
+			}
+			if flagBuildKubeValues != "" {
+				settings.Render.Values, err = kube.ReadValuesFile(flagBuildKubeValues)
+				if err != nil {
+					return err
+				}
+			}
+			if flagBuildKubeValuesFromEnv {
+				settings.Render.Environ = os.Environ()
+			}
+		}
+
+		if flagBuildKubeWatch {
+			return fissile.WatchKube(settings, app.WatchKubeOptions{})
+		}
 		return fissile.GenerateKube(settings)
 	},
 }
@@ -96,5 +201,166 @@ func init() {
 		"Additional information to use in computing the image tags",
 	)
 
+	buildKubeCmd.PersistentFlags().BoolP(
+		"helper-scripts",
+		"",
+		false,
+		"Write kubectl helper scripts for the instance groups into the bin directory",
+	)
+
+	buildKubeCmd.PersistentFlags().BoolP(
+		"secret-string-data",
+		"",
+		false,
+		"Write non-binary secret values as stringData instead of base64-encoded data",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"secret-backend",
+		"",
+		kube.SecretBackendKubernetes,
+		"Backend the containers get the secrets from: kubernetes, vault-agent (Vault Agent injector) or vault-csi (secrets store CSI driver)",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"stream-output",
+		"",
+		"",
+		"Write all resources as a single multi-document YAML file in dependency order, instead of a directory tree; use - for standard output",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"values",
+		"",
+		"",
+		"Path to a helm values file; if set, the configuration files are rendered with these values instead of containing templates",
+	)
+
+	buildKubeCmd.PersistentFlags().BoolP(
+		"values-from-env",
+		"",
+		false,
+		"Render the configuration files like --values, with the values set by FISSILE_VALUES_<PATH> environment variables on top, e.g. FISSILE_VALUES_NAME_PREFIX__ENABLED=true for name_prefix.enabled",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"render-release-name",
+		"",
+		"fissile",
+		"Name of the release the configuration files are rendered for, with --values",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"render-namespace",
+		"",
+		"default",
+		"Namespace the configuration files are rendered for, with --values",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"render-chart-name",
+		"",
+		"fissile",
+		"Chart name the configuration files are rendered for, with --values",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"render-chart-version",
+		"",
+		"0.0.0",
+		"Chart version the configuration files are rendered for, with --values",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"render-kube-version",
+		"",
+		kube.DefaultRenderKubeVersion,
+		"Kubernetes version (<major>.<minor>) of the cluster the configuration files are rendered for, with --values",
+	)
+
+	buildKubeCmd.PersistentFlags().StringSliceP(
+		"render-api-versions",
+		"",
+		nil,
+		"API versions (e.g. monitoring.coreos.com/v1) enabled on the cluster the configuration files are rendered for, with --values; defaults to those of a plain cluster",
+	)
+
+	buildKubeCmd.PersistentFlags().BoolP(
+		"audit-cluster-scope",
+		"",
+		false,
+		"List the cluster-scoped resources (e.g. cluster roles and pod security policies) that would be created, and fail unless configuration.cluster_scoped of the role manifest allows their kinds",
+	)
+
+	buildKubeCmd.PersistentFlags().BoolP(
+		"policy-bundle",
+		"",
+		false,
+		"Write a summary of the security-relevant settings of the workloads (capabilities, host access, privileges) and the exemptions they need from the Gatekeeper policy library to the policy directory",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"image-digests",
+		"",
+		"",
+		"Path of a digests file written by fissile push images --digests-file; the role images are referenced by these digests instead of their tags",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"local-volumes",
+		"",
+		"",
+		"Path of a file mapping the persistent volumes of the instance groups onto directories of the nodes; local persistent volumes bound to the claims are written for them",
+	)
+
+	buildKubeCmd.PersistentFlags().StringSliceP(
+		"include",
+		"",
+		nil,
+		"Only (re)generate the named instance groups, and the RBAC resources they use; all other files are left alone",
+	)
+
+	buildKubeCmd.PersistentFlags().StringSliceP(
+		"exclude",
+		"",
+		nil,
+		"Don't (re)generate the named instance groups; like --include, only instance groups and RBAC resources are generated",
+	)
+
+	buildKubeCmd.PersistentFlags().StringP(
+		"quarks-deployment",
+		"",
+		"",
+		"Name of a BOSH deployment for the cf-operator; if set, the instance groups are written as QuarksStatefulSets and QuarksJobs, along with a BOSHDeployment",
+	)
+
+	buildKubeCmd.PersistentFlags().BoolP(
+		"apply-plan",
+		"",
+		false,
+		"Write apply-plan.yaml, listing the configuration files in dependency order with the workloads to wait for, and apply.sh executing it with kubectl",
+	)
+
+	buildKubeCmd.PersistentFlags().BoolP(
+		"watch",
+		"",
+		false,
+		"Keep running, and regenerate the configuration files whenever the role manifest, the files next to it (like its scripts) or the opinions change; only changed files are rewritten",
+	)
+
+	buildKubeCmd.PersistentFlags().IntP(
+		"object-size-warning",
+		"",
+		kube.DefaultObjectSizeWarning,
+		"Warn about rendered objects larger than this many bytes, with --values; 0 disables the warnings",
+	)
+
+	buildKubeCmd.PersistentFlags().IntP(
+		"object-size-limit",
+		"",
+		kube.DefaultObjectSizeLimit,
+		"Fail if a rendered object is larger than this many bytes, with --values, instead of when applying it; 0 disables the check",
+	)
+
 	buildKubeViper.BindPFlags(buildKubeCmd.PersistentFlags())
 }
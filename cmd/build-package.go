@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/fissile/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// buildPackageCmd represents the package command
+var buildPackageCmd = &cobra.Command{
+	Use:   "package <release>/<package>",
+	Short: "Builds a single BOSH package and its dependencies.",
+	Long: `
+This command compiles a single package of a BOSH release, e.g. while iterating on
+it during release development. Only the package itself and those of its
+(transitive) dependencies that are not compiled yet are compiled; other packages
+of the releases are ignored.
+
+The package is skipped if it is already compiled or available from the package
+cache, unless ` + "`--force`" + ` is given to compile it again.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flagBuildPackageStemcell := buildPackageViper.GetString("stemcell")
+
+		err := fissile.ProgressBegin(buildViper.GetString("progress-format"), buildViper.GetString("progress-file"))
+		if err != nil {
+			return err
+		}
+
+		err = fissile.GraphBegin(buildViper.GetString("output-graph"))
+		if err != nil {
+			return err
+		}
+
+		err = fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.CompilePackage(app.CompilePackageOptions{
+			Package:                    args[0],
+			Force:                      buildPackageViper.GetBool("force"),
+			StemcellImageName:          flagBuildPackageStemcell,
+			TargetPath:                 fissile.StemcellCompilationDir(flagBuildPackageStemcell),
+			MetricsPath:                fissile.Options.Metrics,
+			WorkerCount:                fissile.Options.Workers,
+			AutoWorkers:                fissile.Options.AutoWorkers,
+			DockerNetworkMode:          buildPackageViper.GetString("docker-network-mode"),
+			WithoutDocker:              buildPackageViper.GetBool("without-docker"),
+			Verbose:                    fissile.Options.Verbose,
+			PackageCacheConfigFilename: buildPackageViper.GetString("compilation-cache-config"),
+			CacheReadOnly:              buildPackageViper.GetBool("cache-read-only"),
+			StreamPackages:             buildPackageViper.GetBool("stream-packages"),
+		})
+	},
+}
+
+var buildPackageViper = viper.New()
+
+func init() {
+	initViper(buildPackageViper)
+
+	buildCmd.AddCommand(buildPackageCmd)
+
+	buildPackageCmd.PersistentFlags().BoolP(
+		"force",
+		"",
+		false,
+		"Compile the package even if it is already compiled or available from the package cache.",
+	)
+
+	buildPackageCmd.PersistentFlags().BoolP(
+		"without-docker",
+		"",
+		false,
+		"Build without docker; this may adversely affect your system.  Only supported on Linux, and requires CAP_SYS_ADMIN.",
+	)
+
+	buildPackageCmd.PersistentFlags().StringP(
+		"docker-network-mode",
+		"",
+		"",
+		"Specify network mode to be used when building with docker. e.g. \"--docker-network-mode host\" is equivalent to \"docker run --network=host\"",
+	)
+
+	buildPackageCmd.PersistentFlags().StringP(
+		"stemcell",
+		"s",
+		"",
+		"The source stemcell",
+	)
+
+	buildPackageCmd.PersistentFlags().StringP(
+		"compilation-cache-config",
+		"",
+		filepath.Join(os.Getenv("HOME"), ".fissile", "package-cache.yaml"),
+		"Points to a file containing configuration for a compiled package cache or contains the configuration as valid yaml",
+	)
+
+	buildPackageCmd.PersistentFlags().BoolP(
+		"cache-read-only",
+		"",
+		false,
+		"Only download packages from the compiled package cache; compiled packages are not uploaded to it, e.g. for builds of pull requests",
+	)
+
+	buildPackageCmd.PersistentFlags().BoolP(
+		"stream-packages",
+		"",
+		defaultStreamPackages,
+		"If true, fissile will stream packages to the docker daemon for compilation, instead of mounting volumes; the default outside Linux",
+	)
+
+	buildPackageViper.BindPFlags(buildPackageCmd.PersistentFlags())
+}
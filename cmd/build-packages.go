@@ -26,7 +26,19 @@ will most likely be left behind.
 Compiled packages are stored in ` + "`<work-dir>/compilation`" + `. Fissile uses the
 package's fingerprint as part of the directory structure. This means that if the
 same package (with the same version) is used by multiple releases, it will only be
-compiled once.
+compiled once. The duration of each compilation is recorded there as well, in
+` + "`compile-durations.yml`" + `, so that later runs can start the packages heading the
+longest chains of compile time (e.g. ruby) first.
+
+With ` + "`--workers auto`" + `, packages are only compiled concurrently while the CPUs and
+memory of the host (or the limits of the container fissile runs in) allow it. The
+memory a package needs is estimated from earlier runs recorded with ` + "`--metrics`" + `;
+the peak memory of each compilation is recorded, from the memory cgroup of the
+compilation container when building with docker.
+
+Packages compiled on the same stemcell image are downloaded from the package
+cache configured by ` + "`--compilation-cache-config`" + `, and compiled packages are
+uploaded to it, unless ` + "`--cache-read-only`" + ` is given.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		flagBuildPackagesRoles := buildPackagesViper.GetString("roles")
@@ -35,9 +47,15 @@ compiled once.
 		flagBuildPackagesDockerNetworkMode := buildPackagesViper.GetString("docker-network-mode")
 		flagBuildPackagesStemcell := buildPackagesViper.GetString("stemcell")
 		flagBuildCompilationCacheConfig := buildPackagesViper.GetString("compilation-cache-config")
+		flagBuildPackagesCacheReadOnly := buildPackagesViper.GetBool("cache-read-only")
 		flagBuildPackagesStreamPackages := buildPackagesViper.GetBool("stream-packages")
 
-		err := fissile.GraphBegin(buildViper.GetString("output-graph"))
+		err := fissile.ProgressBegin(buildViper.GetString("progress-format"), buildViper.GetString("progress-file"))
+		if err != nil {
+			return err
+		}
+
+		err = fissile.GraphBegin(buildViper.GetString("output-graph"))
 		if err != nil {
 			return err
 		}
@@ -55,10 +73,12 @@ compiled once.
 			strings.FieldsFunc(flagBuildPackagesRoles, func(r rune) bool { return r == ',' }),
 			strings.FieldsFunc(flagBuildPackagesOnlyReleases, func(r rune) bool { return r == ',' }),
 			fissile.Options.Workers,
+			fissile.Options.AutoWorkers,
 			flagBuildPackagesDockerNetworkMode,
 			flagBuildPackagesWithoutDocker,
 			fissile.Options.Verbose,
 			flagBuildCompilationCacheConfig,
+			flagBuildPackagesCacheReadOnly,
 			flagBuildPackagesStreamPackages,
 		)
 	},
@@ -90,7 +110,7 @@ func init() {
 		"without-docker",
 		"",
 		false,
-		"Build without docker; this may adversely affect your system.  Only supported on Linux, and requires CAP_SYS_ADMIN.",
+		"Build without docker; this may adversely affect your system.  Only supported on Linux, for a single stemcell, and requires CAP_SYS_ADMIN.",
 	)
 
 	buildPackagesCmd.PersistentFlags().StringP(
@@ -115,10 +135,17 @@ func init() {
 	)
 
 	buildPackagesCmd.PersistentFlags().BoolP(
-		"stream-packages",
+		"cache-read-only",
 		"",
 		false,
-		"If true, fissile will stream packages to the docker daemon for compilation, instead of mounting volumes",
+		"Only download packages from the compiled package cache; compiled packages are not uploaded to it, e.g. for builds of pull requests",
+	)
+
+	buildPackagesCmd.PersistentFlags().BoolP(
+		"stream-packages",
+		"",
+		defaultStreamPackages,
+		"If true, fissile will stream packages to the docker daemon for compilation, instead of mounting volumes; the default outside Linux",
 	)
 
 	buildPackagesViper.BindPFlags(buildPackagesCmd.PersistentFlags())
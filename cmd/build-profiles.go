@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/kube"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	flagBuildProfilesHelmOutputDir      string
+	flagBuildProfilesKubeOutputDir      string
+	flagBuildProfilesKustomizeOutputDir string
+	flagBuildProfilesUseMemoryLimits    bool
+	flagBuildProfilesUseCPULimits       bool
+	flagBuildProfilesTagExtra           string
+	flagBuildProfilesAuthType           string
+)
+
+// buildProfilesCmd represents the profiles command
+var buildProfilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "Creates a Helm chart and Kubernetes configuration files in one run.",
+	Long: `
+Generates any combination of a Helm chart, plain Kubernetes configuration files,
+and Kubernetes configuration files with a kustomization.yaml from a single load
+of the role manifest. Each output is written to its own directory; outputs
+without a directory are skipped.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flagBuildProfilesHelmOutputDir = buildProfilesViper.GetString("helm-output-dir")
+		flagBuildProfilesKubeOutputDir = buildProfilesViper.GetString("kube-output-dir")
+		flagBuildProfilesKustomizeOutputDir = buildProfilesViper.GetString("kustomize-output-dir")
+		flagBuildProfilesUseMemoryLimits = buildProfilesViper.GetBool("use-memory-limits")
+		flagBuildProfilesUseCPULimits = buildProfilesViper.GetBool("use-cpu-limits")
+		flagBuildProfilesTagExtra = buildProfilesViper.GetString("tag-extra")
+		flagBuildProfilesAuthType = buildProfilesViper.GetString("auth-type")
+
+		if flagBuildProfilesHelmOutputDir == "" && flagBuildProfilesKubeOutputDir == "" && flagBuildProfilesKustomizeOutputDir == "" {
+			return fmt.Errorf("At least one of --helm-output-dir, --kube-output-dir, or --kustomize-output-dir must be specified")
+		}
+
+		err := fissile.GraphBegin(buildViper.GetString("output-graph"))
+		if err != nil {
+			return err
+		}
+
+		err = fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		opinions, err := fissile.LoadOpinions()
+		if err != nil {
+			return err
+		}
+
+		base := kube.ExportSettings{
+			Registry:        fissile.Options.DockerRegistry,
+			Username:        fissile.Options.DockerUsername,
+			Password:        fissile.Options.DockerPassword,
+			Organization:    fissile.Options.DockerOrganization,
+			Repository:      fissile.Options.RepositoryPrefix,
+			UseMemoryLimits: flagBuildProfilesUseMemoryLimits,
+			UseCPULimits:    flagBuildProfilesUseCPULimits,
+			FissileVersion:  fissile.Version,
+			Opinions:        opinions,
+			TagExtra:        flagBuildProfilesTagExtra,
+		}
+
+		var profiles []kube.ExportSettings
+		if flagBuildProfilesHelmOutputDir != "" {
+			settings := base
+			settings.OutputDir = flagBuildProfilesHelmOutputDir
+			settings.CreateHelmChart = true
+			settings.AuthType = flagBuildProfilesAuthType
+			profiles = append(profiles, settings)
+		}
+		if flagBuildProfilesKubeOutputDir != "" {
+			settings := base
+			settings.OutputDir = flagBuildProfilesKubeOutputDir
+			profiles = append(profiles, settings)
+		}
+		if flagBuildProfilesKustomizeOutputDir != "" {
+			settings := base
+			settings.OutputDir = flagBuildProfilesKustomizeOutputDir
+			settings.CreateKustomization = true
+			profiles = append(profiles, settings)
+		}
+
+		return fissile.GenerateKube(profiles...)
+	},
+}
+var buildProfilesViper = viper.New()
+
+func init() {
+	initViper(buildProfilesViper)
+
+	buildCmd.AddCommand(buildProfilesCmd)
+
+	buildProfilesCmd.PersistentFlags().StringP(
+		"helm-output-dir",
+		"",
+		"",
+		"Helm chart files will be written to this directory",
+	)
+
+	buildProfilesCmd.PersistentFlags().StringP(
+		"kube-output-dir",
+		"",
+		"",
+		"Kubernetes configuration files will be written to this directory",
+	)
+
+	buildProfilesCmd.PersistentFlags().StringP(
+		"kustomize-output-dir",
+		"",
+		"",
+		"Kubernetes configuration files and a kustomization.yaml will be written to this directory",
+	)
+
+	buildProfilesCmd.PersistentFlags().BoolP(
+		"use-memory-limits",
+		"",
+		true,
+		"Include memory limits when generating configuration files",
+	)
+
+	buildProfilesCmd.PersistentFlags().BoolP(
+		"use-cpu-limits",
+		"",
+		true,
+		"Include cpu limits when generating configuration files",
+	)
+
+	buildProfilesCmd.PersistentFlags().StringP(
+		"tag-extra",
+		"",
+		"",
+		"Additional information to use in computing the image tags",
+	)
+
+	buildProfilesCmd.PersistentFlags().StringP(
+		"auth-type",
+		"",
+		"",
+		"Sets the Kubernetes auth type for the Helm chart",
+	)
+
+	buildProfilesViper.BindPFlags(buildProfilesCmd.PersistentFlags())
+}
@@ -21,7 +21,14 @@ var buildReleaseImagesCmd = &cobra.Command{
 This command goes through builds a Docker image for each specified release.
 	`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		err := fissile.ProgressBegin(buildViper.GetString("progress-format"), buildViper.GetString("progress-file"))
+		if err != nil {
+			return err
+		}
+
 		imgBuilder := &builder.ReleasesImageBuilder{
+			AutoWorkers:            fissile.Options.AutoWorkers,
+			CacheReadOnly:          buildReleaseImagesViper.GetBool("cache-read-only"),
 			CompilationCacheConfig: buildReleaseImagesViper.GetString("compilation-cache-config"),
 			DockerNetworkMode:      buildPackagesViper.GetString("docker-network-mode"),
 			DockerOrganization:     fissile.Options.DockerOrganization,
@@ -33,6 +40,7 @@ This command goes through builds a Docker image for each specified release.
 			MetricsPath:            fissile.Options.Metrics,
 			NoBuild:                buildReleaseImagesViper.GetBool("no-build"),
 			OutputDirectory:        buildReleaseImagesViper.GetString("output-directory"),
+			Progress:               fissile.ProgressWriter(),
 			RepositoryPrefix:       fissile.Options.RepositoryPrefix,
 			StemcellName:           buildReleaseImagesViper.GetString("stemcell"),
 			StreamPackages:         buildPackagesViper.GetBool("stream-packages"),
@@ -141,6 +149,13 @@ func init() {
 		"Points to a file containing configuration for a compiled package cache or contains the configuration as valid yaml",
 	)
 
+	buildReleaseImagesCmd.PersistentFlags().BoolP(
+		"cache-read-only",
+		"",
+		false,
+		"Only download packages from the compiled package cache; compiled packages are not uploaded to it, e.g. for builds of pull requests",
+	)
+
 	buildReleaseImagesCmd.PersistentFlags().StringP(
 		"name",
 		"",
@@ -186,8 +201,8 @@ func init() {
 	buildReleaseImagesCmd.PersistentFlags().BoolP(
 		"stream-packages",
 		"",
-		false,
-		"If true, fissile will stream packages to the docker daemon for compilation, instead of mounting volumes",
+		defaultStreamPackages,
+		"If true, fissile will stream packages to the docker daemon for compilation, instead of mounting volumes; the default outside Linux",
 	)
 
 	buildReleaseImagesCmd.PersistentFlags().BoolP(
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"runtime"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -14,10 +16,21 @@ This command has various subcommands to build artifacts.
 
 The ` + "`--output-graph`" + ` flag is used to generate a graphviz-style DOT
 language file for troubleshooting purposes.
+
+With ` + "`--progress-format json`" + `, the subcommands compiling packages write an
+event per line as each package waits on its dependencies, starts, finishes or
+fails, with the duration of its compilation, so that builds can be tracked
+without scraping the terminal output. The events are written to
+` + "`--progress-file`" + `, or to stdout, with the text output moved to stderr.
 	`,
 }
 var buildViper = viper.New()
 
+// defaultStreamPackages is the default of --stream-packages. Outside Linux,
+// docker runs its containers in a VM, where mounting the volumes of the host
+// is slow, so packages are streamed to the daemon instead.
+var defaultStreamPackages = runtime.GOOS != "linux"
+
 func init() {
 	initViper(buildViper)
 
@@ -30,5 +43,19 @@ func init() {
 		"Output a graphviz graph to the given file name",
 	)
 
+	buildCmd.PersistentFlags().StringP(
+		"progress-format",
+		"",
+		"human",
+		"Format of the compilation progress; one of human or json",
+	)
+
+	buildCmd.PersistentFlags().StringP(
+		"progress-file",
+		"",
+		"",
+		"Write the json compilation progress to the given file name instead of stdout",
+	)
+
 	buildViper.BindPFlags(buildCmd.PersistentFlags())
 }
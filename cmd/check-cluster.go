@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	flagCheckClusterKubeconfig string
+	flagCheckClusterNamespace  string
+	flagCheckClusterValues     string
+)
+
+// checkClusterCmd represents the cluster command
+var checkClusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Checks whether the helm chart can be installed into a Kubernetes cluster.",
+	Long: `
+Queries the cluster selected by the kubeconfig (using kubectl) and reports
+whether it meets the requirements of the role manifest:
+
+- the API versions used by the generated resources are available
+- the storage classes used by persistent and shared volumes exist
+- the pod security level enforced on the namespace admits all instance groups
+- the resource requests of all instances fit the allocatable capacity
+
+Each check passes, warns, or fails; the command fails if any check fails.
+Storage class, pod security policy, and instance count settings are read from
+--values; the resources requested at the maximum scale are reported as well.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flagCheckClusterKubeconfig = checkClusterViper.GetString("kubeconfig")
+		flagCheckClusterNamespace = checkClusterViper.GetString("namespace")
+		flagCheckClusterValues = checkClusterViper.GetString("values")
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.CheckCluster(
+			flagCheckClusterKubeconfig,
+			flagCheckClusterNamespace,
+			flagCheckClusterValues,
+		)
+	},
+}
+var checkClusterViper = viper.New()
+
+func init() {
+	initViper(checkClusterViper)
+
+	checkCmd.AddCommand(checkClusterCmd)
+
+	checkClusterCmd.PersistentFlags().StringP(
+		"kubeconfig",
+		"",
+		"",
+		"Path to the kubeconfig file; uses the kubectl default if empty",
+	)
+
+	checkClusterCmd.PersistentFlags().StringP(
+		"namespace",
+		"",
+		"default",
+		"Namespace the helm chart will be installed into",
+	)
+
+	checkClusterCmd.PersistentFlags().StringP(
+		"values",
+		"",
+		"",
+		"Path to the helm values file that will be used for the installation",
+	)
+
+	checkClusterViper.BindPFlags(checkClusterCmd.PersistentFlags())
+}
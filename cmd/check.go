@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Has subcommands that check the environment the chart will be installed in.",
+}
+
+func init() {
+	RootCmd.AddCommand(checkCmd)
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// completionManifestCmd represents the completion manifest command, an alias
+// of show skeleton-manifest
+var completionManifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Writes a skeleton role manifest for the releases; same as `show skeleton-manifest`.",
+	Long:  showSkeletonManifestCmd.Long,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return showSkeletonManifest(completionManifestViper)
+	},
+}
+
+var completionManifestViper = viper.New()
+
+func init() {
+	initViper(completionManifestViper)
+
+	completionCmd.AddCommand(completionManifestCmd)
+
+	initSkeletonManifestFlags(completionManifestCmd, completionManifestViper)
+}
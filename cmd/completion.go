@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// completionCmd represents the completion command
+var completionCmd = &cobra.Command{
+	Use:   "completion",
+	Short: "Has subcommands that generate configuration to be completed by hand.",
+	Long: `
+These commands generate configuration as a starting point, to be completed by
+hand. For shell completion scripts, see ` + "`fissile docs autocomplete`" + `.
+`,
+}
+
+func init() {
+	RootCmd.AddCommand(completionCmd)
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// diffChartCmd represents the diff chart command
+var diffChartCmd = &cobra.Command{
+	Use:   "chart <old-chart-dir> <new-chart-dir>",
+	Short: "Prints a changelog of the differences between two generated helm charts.",
+	Long: `
+This command compares two helm charts generated by ` + "`fissile build helm`" + `, e.g. for two
+release versions, and prints a changelog suitable for inclusion in release notes:
+
+- added and removed instance groups
+- instance groups with changed images
+- added and removed values keys
+- values keys with changed defaults
+
+Use ` + "`--output json`" + ` or ` + "`--output yaml`" + ` for a machine-readable changelog. The chart
+versions are read from the Chart.yaml files, if present.
+`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return fissile.DiffCharts(args[0], args[1])
+	},
+}
+
+func init() {
+	diffCmd.AddCommand(diffChartCmd)
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/app"
+	"code.cloudfoundry.org/fissile/kube"
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	flagDiffKubeFromRevision string
+	flagDiffKubeToRevision   string
+	flagDiffKubeValues       string
+)
+
+// diffKubeCmd represents the diff kube command
+var diffKubeCmd = &cobra.Command{
+	Use:   "kube [<old-role-manifest> [<new-role-manifest>]]",
+	Short: "Prints the differences between the Kubernetes resources generated for two role manifests.",
+	Long: `
+This command generates the Kubernetes configuration files for two versions of a role
+manifest in memory, and prints the resources added, removed and changed between
+them, with the paths of the changed fields, to review the effects of changes to
+the role manifest before merging them.
+
+The versions default to the role manifest given by --role-manifest; either version
+can be read from a git revision with --from-revision and --to-revision, e.g.
+
+    fissile diff kube --from-revision origin/master
+
+compares the role manifest of the master branch with the one checked out. Files
+referred to by the role manifest, like scripts, are always read from the working
+tree. Both versions are generated with the same releases and opinions.
+
+With --values, the helm chart is rendered with the values instead, which makes the
+resources depending on values, like optional ones, part of the comparison. Use
+` + "`--output json`" + ` or ` + "`--output yaml`" + ` for a machine-readable report.
+`,
+	Args: cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flagDiffKubeFromRevision = diffKubeViper.GetString("from-revision")
+		flagDiffKubeToRevision = diffKubeViper.GetString("to-revision")
+		flagDiffKubeValues = diffKubeViper.GetString("values")
+
+		opinions, err := model.NewOpinions(
+			fissile.Options.LightOpinions,
+			fissile.Options.DarkOpinions...,
+		)
+		if err != nil {
+			return err
+		}
+
+		opt := app.DiffKubeOptions{
+			FromRevision: flagDiffKubeFromRevision,
+			ToRevision:   flagDiffKubeToRevision,
+			Settings: kube.ExportSettings{
+				Registry:        fissile.Options.DockerRegistry,
+				Username:        fissile.Options.DockerUsername,
+				Password:        fissile.Options.DockerPassword,
+				Organization:    fissile.Options.DockerOrganization,
+				Repository:      fissile.Options.RepositoryPrefix,
+				UseMemoryLimits: true,
+				UseCPULimits:    true,
+				FissileVersion:  fissile.Version,
+				Opinions:        opinions,
+			},
+		}
+		if len(args) > 0 {
+			opt.FromRoleManifest = args[0]
+		}
+		if len(args) > 1 {
+			opt.ToRoleManifest = args[1]
+		}
+		if opt.FromRoleManifest == "" && opt.FromRevision == "" && opt.ToRevision == "" {
+			return fmt.Errorf("Specify the role manifests to compare, or --from-revision")
+		}
+
+		if flagDiffKubeValues != "" {
+			values, err := kube.ReadValuesFile(flagDiffKubeValues)
+			if err != nil {
+				return err
+			}
+			opt.Settings.Render = &kube.RenderOptions{
+				Values:       values,
+				ReleaseName:  "fissile",
+				Namespace:    "default",
+				ChartName:    "fissile",
+				ChartVersion: "0.0.0",
+			}
+		}
+
+		return fissile.DiffKube(opt)
+	},
+}
+var diffKubeViper = viper.New()
+
+func init() {
+	initViper(diffKubeViper)
+
+	diffCmd.AddCommand(diffKubeCmd)
+
+	diffKubeCmd.PersistentFlags().StringP(
+		"from-revision",
+		"",
+		"",
+		"Git revision to read the old role manifest at",
+	)
+
+	diffKubeCmd.PersistentFlags().StringP(
+		"to-revision",
+		"",
+		"",
+		"Git revision to read the new role manifest at; defaults to the working tree",
+	)
+
+	diffKubeCmd.PersistentFlags().StringP(
+		"values",
+		"",
+		"",
+		"Path to a helm values file; if set, the helm chart rendered with these values is compared instead of the plain Kubernetes configuration files",
+	)
+
+	diffKubeViper.BindPFlags(diffKubeCmd.PersistentFlags())
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"code.cloudfoundry.org/fissile/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// dockerPruneCmd represents the prune command
+var dockerPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Removes old images and compilation leftovers from the docker daemon.",
+	Long: `
+Removes images built by fissile (role images and packages layers), keeping the
+--keep most recent images of each repository and the role images of the
+current role manifest, along with the packages layers of the role images kept.
+Untagged fissile images are always removed.
+
+Stopped package compilation containers, and compilation volumes that do not
+belong to a remaining container, are removed as well.
+
+Use --dry-run to list what would be removed.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var opt app.DockerPruneOptions
+
+		opt.DryRun = dockerPruneViper.GetBool("dry-run")
+		opt.Keep = dockerPruneViper.GetInt("keep")
+		opt.TagExtra = dockerPruneViper.GetString("tag-extra")
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.DockerPrune(opt)
+	},
+}
+var dockerPruneViper = viper.New()
+
+func init() {
+	initViper(dockerPruneViper)
+
+	dockerCmd.AddCommand(dockerPruneCmd)
+
+	dockerPruneCmd.PersistentFlags().IntP(
+		"keep",
+		"",
+		3,
+		"Number of most recent images to keep for each repository",
+	)
+
+	dockerPruneCmd.PersistentFlags().BoolP(
+		"dry-run",
+		"",
+		false,
+		"List the images, containers, and volumes to remove without removing them",
+	)
+
+	dockerPruneCmd.PersistentFlags().StringP(
+		"tag-extra",
+		"",
+		"",
+		"Additional information used in computing the image tags of the current role images",
+	)
+
+	dockerPruneViper.BindPFlags(dockerPruneCmd.PersistentFlags())
+}
@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// dockerCmd represents the docker command
+var dockerCmd = &cobra.Command{
+	Use:   "docker",
+	Short: "Has subcommands that manage the docker daemon used by builds.",
+}
+
+func init() {
+	RootCmd.AddCommand(dockerCmd)
+}
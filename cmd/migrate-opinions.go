@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	flagMigrateOpinionsFormat string
+	flagMigrateOpinionsOutput string
+)
+
+// migrateOpinionsCmd represents the opinions command
+var migrateOpinionsCmd = &cobra.Command{
+	Use:   "opinions",
+	Short: "Converts the light opinions between the tree and the patch format.",
+	Long: `
+Reads the light opinions file and writes it in the requested format:
+
+- tree: the properties as in a BOSH deployment manifest
+- patch: a JSON Patch (RFC 6902) against the defaults of the job specs
+
+Opinions in patch form are applied over the spec defaults of the releases, so
+converting them to a tree shows the effective light opinions.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flagMigrateOpinionsFormat = migrateOpinionsViper.GetString("format")
+		flagMigrateOpinionsOutput = migrateOpinionsViper.GetString("output-file")
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.MigrateOpinions(
+			flagMigrateOpinionsFormat,
+			flagMigrateOpinionsOutput,
+		)
+	},
+}
+var migrateOpinionsViper = viper.New()
+
+func init() {
+	initViper(migrateOpinionsViper)
+
+	migrateCmd.AddCommand(migrateOpinionsCmd)
+
+	migrateOpinionsCmd.PersistentFlags().StringP(
+		"format",
+		"",
+		"tree",
+		"Format to convert the light opinions to; either \"tree\" or \"patch\"",
+	)
+
+	migrateOpinionsCmd.PersistentFlags().StringP(
+		"output-file",
+		"",
+		"opinions-converted.yml",
+		"Path to write the converted opinions to",
+	)
+
+	migrateOpinionsViper.BindPFlags(migrateOpinionsCmd.PersistentFlags())
+}
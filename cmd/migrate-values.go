@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	flagMigrateValuesFrom        string
+	flagMigrateValuesOutput      string
+	flagMigrateValuesInteractive bool
+)
+
+// migrateValuesCmd represents the values command
+var migrateValuesCmd = &cobra.Command{
+	Use:   "values",
+	Short: "Migrates a helm values file written for an older version of the chart.",
+	Long: `
+Reads a helm values file written for a previous version of the chart and writes
+an updated copy for the current role manifest:
+
+- values for renamed variables are moved to their new names (see previous_names)
+- values for variables that no longer exist are dropped and reported
+- newly required variables are added as empty placeholders, or prompted for
+  when running with --interactive
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flagMigrateValuesFrom = migrateValuesViper.GetString("from")
+		flagMigrateValuesOutput = migrateValuesViper.GetString("output-file")
+		flagMigrateValuesInteractive = migrateValuesViper.GetBool("interactive")
+
+		if flagMigrateValuesFrom == "" {
+			return fmt.Errorf("--from must be specified")
+		}
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.MigrateValues(
+			flagMigrateValuesFrom,
+			flagMigrateValuesOutput,
+			flagMigrateValuesInteractive,
+		)
+	},
+}
+var migrateValuesViper = viper.New()
+
+func init() {
+	initViper(migrateValuesViper)
+
+	migrateCmd.AddCommand(migrateValuesCmd)
+
+	migrateValuesCmd.PersistentFlags().StringP(
+		"from",
+		"",
+		"",
+		"Path to the values file of the previous chart version",
+	)
+
+	migrateValuesCmd.PersistentFlags().StringP(
+		"output-file",
+		"",
+		"values.yaml",
+		"Path to write the migrated values file to",
+	)
+
+	migrateValuesCmd.PersistentFlags().BoolP(
+		"interactive",
+		"",
+		false,
+		"Prompt for values of newly required variables instead of adding empty placeholders",
+	)
+
+	migrateValuesViper.BindPFlags(migrateValuesCmd.PersistentFlags())
+}
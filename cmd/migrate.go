@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Has subcommands that migrate user configuration to the current role manifest.",
+}
+
+func init() {
+	RootCmd.AddCommand(migrateCmd)
+}
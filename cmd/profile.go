@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"runtime/trace"
+
+	"github.com/spf13/viper"
+)
+
+// profiling holds the state of the profiles of fissile itself requested by
+// the --profile-cpu, --profile-mem, --trace and --pprof-address flags
+type profiling struct {
+	cpuFile   *os.File
+	memPath   string
+	traceFile *os.File
+	listener  net.Listener
+}
+
+var activeProfiling *profiling
+
+// startProfiling starts the profiles requested by the flags; they are
+// written by stopProfiling when the command is done.
+func startProfiling() error {
+	p := &profiling{memPath: viper.GetString("profile-mem")}
+	activeProfiling = p
+
+	if path := viper.GetString("profile-cpu"); path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("Error creating CPU profile %s: %v", path, err)
+		}
+		if err := runtimepprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return fmt.Errorf("Error starting CPU profile: %v", err)
+		}
+		p.cpuFile = file
+	}
+
+	if path := viper.GetString("trace"); path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("Error creating execution trace %s: %v", path, err)
+		}
+		if err := trace.Start(file); err != nil {
+			file.Close()
+			return fmt.Errorf("Error starting execution trace: %v", err)
+		}
+		p.traceFile = file
+	}
+
+	if address := viper.GetString("pprof-address"); address != "" {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return fmt.Errorf("Error listening for pprof requests on %s: %v", address, err)
+		}
+		p.listener = listener
+		fissile.UI.Printf("Serving pprof endpoints on http://%s/debug/pprof/\n", listener.Addr())
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		go http.Serve(listener, mux)
+	}
+
+	return nil
+}
+
+// stopProfiling stops the profiles started by startProfiling and writes
+// the memory profile. It is safe to call if no profiling was started.
+func stopProfiling() error {
+	p := activeProfiling
+	if p == nil {
+		return nil
+	}
+	activeProfiling = nil
+
+	var result error
+	keep := func(err error) {
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+
+	if p.listener != nil {
+		keep(p.listener.Close())
+	}
+
+	if p.traceFile != nil {
+		trace.Stop()
+		keep(p.traceFile.Close())
+	}
+
+	if p.cpuFile != nil {
+		runtimepprof.StopCPUProfile()
+		keep(p.cpuFile.Close())
+	}
+
+	if p.memPath != "" {
+		file, err := os.Create(p.memPath)
+		if err != nil {
+			return fmt.Errorf("Error creating memory profile %s: %v", p.memPath, err)
+		}
+		// Collect garbage so that the profile reflects the live objects
+		runtime.GC()
+		keep(runtimepprof.WriteHeapProfile(file))
+		keep(file.Close())
+	}
+
+	return result
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/fissile/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// pushImagesCmd represents the images command
+var pushImagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "Pushes the role images to the docker registry.",
+	Long: `
+Pushes the images built by ` + "`fissile build images`" + ` to the docker registry given by
+--docker-registry, --docker-organization, and the docker credentials.
+
+Up to --parallelism images are pushed concurrently, printing the progress of
+each layer. Failed pushes are retried; layers that were already pushed are not
+uploaded again. The digests of all pushed images are printed at the end, and
+can be written to a YAML file with --digests-file, for use with the
+--image-digests flag of ` + "`fissile build helm`" + ` and ` + "`fissile build kube`" + `.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var opt app.PushImagesOptions
+
+		opt.DigestsFile = pushImagesViper.GetString("digests-file")
+		opt.Parallelism = pushImagesViper.GetInt("parallelism")
+		opt.Retries = pushImagesViper.GetInt("retries")
+		opt.TagExtra = pushImagesViper.GetString("tag-extra")
+		opt.Roles = strings.FieldsFunc(pushImagesViper.GetString("roles"), func(r rune) bool { return r == ',' })
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.PushImages(opt)
+	},
+}
+var pushImagesViper = viper.New()
+
+func init() {
+	initViper(pushImagesViper)
+
+	pushCmd.AddCommand(pushImagesCmd)
+
+	// viper is busted w/ string slice, https://github.com/spf13/viper/issues/200
+	pushImagesCmd.PersistentFlags().StringP(
+		"roles",
+		"",
+		"",
+		"Push only images with the given instance group name; comma separated.",
+	)
+
+	pushImagesCmd.PersistentFlags().StringP(
+		"tag-extra",
+		"",
+		"",
+		"Additional information to use in computing the image tags",
+	)
+
+	pushImagesCmd.PersistentFlags().IntP(
+		"parallelism",
+		"",
+		4,
+		"Maximum number of images to push concurrently",
+	)
+
+	pushImagesCmd.PersistentFlags().IntP(
+		"retries",
+		"",
+		3,
+		"Number of times to retry a failed push",
+	)
+
+	pushImagesCmd.PersistentFlags().StringP(
+		"digests-file",
+		"",
+		"",
+		"Path of a YAML file to write the digests of the pushed images to",
+	)
+
+	pushImagesViper.BindPFlags(pushImagesCmd.PersistentFlags())
+}
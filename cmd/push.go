@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// pushCmd represents the push command
+var pushCmd = &cobra.Command{
+	Use:   "push",
+	Short: "Has subcommands that push build artifacts to a registry.",
+}
+
+func init() {
+	RootCmd.AddCommand(pushCmd)
+}
@@ -5,9 +5,13 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"code.cloudfoundry.org/fissile/app"
+	"code.cloudfoundry.org/fissile/compilator"
+	"code.cloudfoundry.org/fissile/docker"
+	"code.cloudfoundry.org/fissile/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -35,7 +39,11 @@ agent.
 			return err
 		}
 
-		return validateReleaseArgs()
+		if err := validateReleaseArgs(); err != nil {
+			return err
+		}
+
+		return startProfiling()
 	},
 }
 
@@ -45,7 +53,11 @@ func Execute(f *app.Fissile, v string) error {
 	fissile = f
 	version = v
 
-	return RootCmd.Execute()
+	err := RootCmd.Execute()
+	if profilingErr := stopProfiling(); err == nil {
+		err = profilingErr
+	}
+	return err
 }
 
 func init() {
@@ -144,11 +156,32 @@ func init() {
 		"Docker organization used when referencing image names",
 	)
 
-	RootCmd.PersistentFlags().IntP(
+	RootCmd.PersistentFlags().StringP(
+		"container-backend",
+		"",
+		docker.BackendDocker,
+		fmt.Sprintf("Container backend building the images and running the compilation containers; one of %s. Podman is reached through its Docker API socket, unless DOCKER_HOST is set.", strings.Join(docker.Backends, ", ")),
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"http-proxy",
+		"",
+		"",
+		"URL of the HTTP(S) proxy used to download releases; also passed to docker builds and compilation containers.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"ca-cert",
+		"",
+		"",
+		"Path to a PEM bundle of additional certificate authorities trusted when downloading releases, pushing OCI images to registries, and accessing the package cache; registries used through docker need the CA configured in the docker daemon.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
 		"workers",
 		"W",
-		0,
-		"Number of workers to use; zero means determine based on CPU count.",
+		"0",
+		"Number of workers to use; zero means determine based on CPU count, and \"auto\" adjusts the number of concurrent compilations to the CPUs and memory of the host.",
 	)
 
 	RootCmd.PersistentFlags().StringP(
@@ -162,7 +195,21 @@ func init() {
 		"dark-opinions",
 		"d",
 		"",
-		"Path to a BOSH deployment manifest file that contains properties that should not have opinionated defaults.",
+		"Paths to BOSH deployment manifest files that contain properties that should not have opinionated defaults (comma-separated); later files, e.g. per environment, take precedence.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"runtime-configs",
+		"",
+		"",
+		"Paths to BOSH runtime configs whose addon properties are merged into the instance groups they are placed on (comma-separated).",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"defaults-files",
+		"",
+		"",
+		"Paths to YAML or dotenv files overriding the defaults of the variables (comma-separated); later files take precedence, also over the defaults files of the role manifest.",
 	)
 
 	RootCmd.PersistentFlags().StringP(
@@ -176,7 +223,14 @@ func init() {
 		"output",
 		"o",
 		app.OutputFormatHuman,
-		"Choose output format, one of human, json, or yaml (currently only for 'show properties')",
+		"Choose output format, one of human, json, or yaml (currently only for 'show properties', and human or json for 'validate')",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"validator-plugins",
+		"",
+		"",
+		"Executables checking site policies during validation (comma-separated); see 'fissile validate'.",
 	)
 
 	RootCmd.PersistentFlags().BoolP(
@@ -186,6 +240,34 @@ func init() {
 		"Enable verbose output.",
 	)
 
+	RootCmd.PersistentFlags().StringP(
+		"profile-cpu",
+		"",
+		"",
+		"Path to a file to write a pprof CPU profile of fissile itself into.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"profile-mem",
+		"",
+		"",
+		"Path to a file to write a pprof memory profile of fissile itself into when the command is done.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"trace",
+		"",
+		"",
+		"Path to a file to write a Go execution trace of fissile into; package compilation is annotated with a task per package.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"pprof-address",
+		"",
+		"",
+		"Address, e.g. localhost:6060, to serve the pprof HTTP endpoints of fissile on while the command runs.",
+	)
+
 	viper.BindPFlags(RootCmd.PersistentFlags())
 }
 
@@ -226,12 +308,26 @@ func validateBasicFlags() error {
 	fissile.Options.DockerOrganization = viper.GetString("docker-organization")
 	fissile.Options.DockerUsername = viper.GetString("docker-username")
 	fissile.Options.DockerPassword = viper.GetString("docker-password")
-	fissile.Options.Workers = viper.GetInt("workers")
+	fissile.Options.ContainerBackend = viper.GetString("container-backend")
+	fissile.Options.HTTPProxy = viper.GetString("http-proxy")
+	fissile.Options.CACertFile = viper.GetString("ca-cert")
+	if workers := viper.GetString("workers"); workers == "auto" {
+		fissile.Options.AutoWorkers = true
+	} else {
+		var err error
+		fissile.Options.Workers, err = strconv.Atoi(workers)
+		if err != nil {
+			return fmt.Errorf("Invalid number of workers %q; must be a number or \"auto\"", workers)
+		}
+	}
 	fissile.Options.LightOpinions = viper.GetString("light-opinions")
-	fissile.Options.DarkOpinions = viper.GetString("dark-opinions")
+	fissile.Options.DarkOpinions = splitNonEmpty(viper.GetString("dark-opinions"), ",")
+	fissile.Options.RuntimeConfigs = splitNonEmpty(viper.GetString("runtime-configs"), ",")
+	fissile.Options.DefaultsFiles = splitNonEmpty(viper.GetString("defaults-files"), ",")
 	fissile.Options.OutputFormat = viper.GetString("output")
 	fissile.Options.Metrics = viper.GetString("metrics")
 	fissile.Options.Verbose = viper.GetBool("verbose")
+	fissile.Options.ValidatorPlugins = splitNonEmpty(viper.GetString("validator-plugins"), ",")
 
 	// Set defaults for empty flags
 	if fissile.Options.RoleManifest == "" {
@@ -242,25 +338,56 @@ func validateBasicFlags() error {
 		fissile.Options.LightOpinions = filepath.Join(fissile.Options.WorkDir, "opinions.yml")
 	}
 
-	if fissile.Options.DarkOpinions == "" {
-		fissile.Options.DarkOpinions = filepath.Join(fissile.Options.WorkDir, "dark-opinions.yml")
+	if len(fissile.Options.DarkOpinions) == 0 {
+		fissile.Options.DarkOpinions = []string{filepath.Join(fissile.Options.WorkDir, "dark-opinions.yml")}
 	}
 
-	if fissile.Options.Workers < 1 {
+	if fissile.Options.AutoWorkers {
+		fissile.Options.Workers = compilator.DetectHostResources().CPUs
+	} else if fissile.Options.Workers < 1 {
 		fissile.Options.Workers = runtime.NumCPU()
 	}
 
+	if err := docker.UseBackend(fissile.Options.ContainerBackend); err != nil {
+		return err
+	}
+
+	if fissile.Options.HTTPProxy != "" {
+		// Docker builds and compilation containers pick up the proxy from
+		// the environment of the fissile process
+		for _, name := range []string{"http_proxy", "https_proxy"} {
+			if err := os.Setenv(name, fissile.Options.HTTPProxy); err != nil {
+				return err
+			}
+		}
+	}
+
 	err := absolutePaths(
 		&fissile.Options.RoleManifest,
 		&fissile.Options.CacheDir,
 		&fissile.Options.WorkDir,
 		&fissile.Options.LightOpinions,
-		&fissile.Options.DarkOpinions,
 		&fissile.Options.Metrics,
 	)
+	if err == nil && fissile.Options.CACertFile != "" {
+		err = absolutePaths(&fissile.Options.CACertFile)
+	}
+	if err == nil {
+		// The stores of the package cache use the default transport
+		err = util.TrustCACertsByDefault(util.HTTPOptions{CACertFile: fissile.Options.CACertFile})
+	}
 	if err == nil {
 		fissile.Options.Releases, err = absolutePathsForArray(fissile.Options.Releases)
 	}
+	if err == nil {
+		fissile.Options.DarkOpinions, err = absolutePathsForArray(fissile.Options.DarkOpinions)
+	}
+	if err == nil {
+		fissile.Options.RuntimeConfigs, err = absolutePathsForArray(fissile.Options.RuntimeConfigs)
+	}
+	if err == nil {
+		fissile.Options.DefaultsFiles, err = absolutePathsForArray(fissile.Options.DefaultsFiles)
+	}
 	return err
 }
 
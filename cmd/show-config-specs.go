@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showConfigSpecsCmd represents the config-specs command
+var showConfigSpecsCmd = &cobra.Command{
+	Use:   "config-specs",
+	Short: "Writes the config specs of the jobs of an instance group.",
+	Long: `
+Writes the config spec of each job of the instance group given by --role to
+<output-dir>/<job>/config_spec.json. The config spec holds the properties
+(defaults merged with the opinions), links, and networks the job templates
+are rendered with, exactly as it is written into the role image.
+
+Sample link data for rendering the templates locally can be supplied by the
+YAML file given by --link-fixtures, in the format described by
+` + "`fissile show jobs`" + `; it is added to the consumed links as their fixture.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var opt app.ShowConfigSpecsOptions
+
+		opt.Role = showConfigSpecsViper.GetString("role")
+		opt.OutputDir = showConfigSpecsViper.GetString("output-dir")
+		opt.LinkFixtures = showConfigSpecsViper.GetString("link-fixtures")
+		if opt.Role == "" {
+			return fmt.Errorf("The instance group to write the config specs of is required (--role)")
+		}
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowConfigSpecs(opt)
+	},
+}
+
+var showConfigSpecsViper = viper.New()
+
+func init() {
+	initViper(showConfigSpecsViper)
+
+	showCmd.AddCommand(showConfigSpecsCmd)
+
+	showConfigSpecsCmd.PersistentFlags().StringP(
+		"role",
+		"",
+		"",
+		"Name of the instance group to write the config specs of",
+	)
+
+	showConfigSpecsCmd.PersistentFlags().StringP(
+		"output-dir",
+		"",
+		".",
+		"Config specs will be written to this directory",
+	)
+
+	showConfigSpecsCmd.PersistentFlags().StringP(
+		"link-fixtures",
+		"",
+		"",
+		"Path to a YAML file with sample data of the consumed links",
+	)
+
+	showConfigSpecsViper.BindPFlags(showConfigSpecsCmd.PersistentFlags())
+}
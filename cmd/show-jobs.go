@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showJobsCmd represents the jobs command
+var showJobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Renders the job templates of an instance group.",
+	Long: `
+Renders the configuration templates and monit files of all the jobs of the
+instance group given by --role, as they would be found in /var/vcap/jobs of its
+containers, and prints them for inspection.
+
+The templates are rendered locally, using the property defaults and opinions;
+links are faked as a single instance of the providing instance group. This
+requires ruby.
+
+Realistic link data can be supplied by the YAML file given by --link-fixtures,
+with the address, instances, and properties of each consumed link:
+
+	links:
+	  <link name>:
+	    address: db.example.com
+	    instances:
+	    - address: 10.0.0.1
+	    - address: 10.0.0.2
+	    properties:
+	      db:
+	        port: 5432
+
+The properties must be exported by the provider of the link; they are merged
+over the properties of the providing job.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var opt app.ShowJobsOptions
+
+		opt.Role = showJobsViper.GetString("role")
+		opt.LinkFixtures = showJobsViper.GetString("link-fixtures")
+		if opt.Role == "" {
+			return fmt.Errorf("The instance group to show the jobs of is required (--role)")
+		}
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowJobs(opt)
+	},
+}
+
+var showJobsViper = viper.New()
+
+func init() {
+	initViper(showJobsViper)
+
+	showCmd.AddCommand(showJobsCmd)
+
+	showJobsCmd.PersistentFlags().StringP(
+		"role",
+		"",
+		"",
+		"Name of the instance group to render the jobs of",
+	)
+
+	showJobsCmd.PersistentFlags().StringP(
+		"link-fixtures",
+		"",
+		"",
+		"Path to a YAML file with sample data of the consumed links",
+	)
+
+	showJobsViper.BindPFlags(showJobsCmd.PersistentFlags())
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"code.cloudfoundry.org/fissile/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showSizingCmd represents the sizing command
+var showSizingCmd = &cobra.Command{
+	Use:   "sizing",
+	Short: "Summarizes the resource footprint of the helm chart.",
+	Long: `
+Renders the stateful sets of the helm chart with the values given by --values
+(the chart defaults if not set), and prints the CPU and memory requests and
+limits, and the persistent volume sizes, of each instance group, summed over
+its replicas, along with the totals.
+
+The number of nodes is estimated from the total requests and the allocatable
+CPU and memory of a node. Errands only run briefly and are not included.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opt := app.ShowSizingOptions{
+			ValuesFile: showSizingViper.GetString("values"),
+			NodeCPU:    float64(showSizingViper.GetInt("node-cpu")),
+			NodeMemory: float64(showSizingViper.GetInt("node-memory")),
+		}
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowSizing(opt)
+	},
+}
+
+var showSizingViper = viper.New()
+
+func init() {
+	initViper(showSizingViper)
+
+	showCmd.AddCommand(showSizingCmd)
+
+	showSizingCmd.PersistentFlags().StringP(
+		"values",
+		"",
+		"",
+		"Path to the helm values file of the profile to size",
+	)
+
+	showSizingCmd.PersistentFlags().IntP(
+		"node-cpu",
+		"",
+		4000,
+		"Allocatable CPU of a node, in millicores",
+	)
+
+	showSizingCmd.PersistentFlags().IntP(
+		"node-memory",
+		"",
+		16384,
+		"Allocatable memory of a node, in MiB",
+	)
+
+	showSizingViper.BindPFlags(showSizingCmd.PersistentFlags())
+}
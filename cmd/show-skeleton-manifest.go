@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"code.cloudfoundry.org/fissile/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showSkeletonManifestCmd represents the skeleton-manifest command
+var showSkeletonManifestCmd = &cobra.Command{
+	Use:   "skeleton-manifest",
+	Short: "Writes a skeleton role manifest for the releases.",
+	Long: `
+Writes a role manifest using all the jobs of the releases, as a starting point
+for a new one. The jobs are grouped into instance groups by:
+
+- job: one instance group per job
+- release: one instance group per release, with all its jobs
+
+The properties of the jobs without defaults are declared as required
+variables, described by the job specs, and mapped to the properties by
+templates. The run sections are placeholders, marked with TODO comments.
+
+The skeleton is written to the role manifest path, unless --output-file is
+given; existing files are never overwritten.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return showSkeletonManifest(showSkeletonManifestViper)
+	},
+}
+
+var showSkeletonManifestViper = viper.New()
+
+func init() {
+	initViper(showSkeletonManifestViper)
+
+	showCmd.AddCommand(showSkeletonManifestCmd)
+
+	initSkeletonManifestFlags(showSkeletonManifestCmd, showSkeletonManifestViper)
+}
+
+// initSkeletonManifestFlags adds the flags of the skeleton manifest to the
+// command, which is either `show skeleton-manifest` or its alias
+// `completion manifest`
+func initSkeletonManifestFlags(cmd *cobra.Command, v *viper.Viper) {
+	cmd.PersistentFlags().StringP(
+		"group-by",
+		"",
+		"job",
+		"Grouping of the jobs into instance groups; either \"job\" or \"release\"",
+	)
+
+	cmd.PersistentFlags().StringP(
+		"output-file",
+		"",
+		"",
+		"Path to write the skeleton role manifest to; defaults to the role manifest path",
+	)
+
+	v.BindPFlags(cmd.PersistentFlags())
+}
+
+// showSkeletonManifest writes the skeleton manifest with the flags bound to
+// the viper
+func showSkeletonManifest(v *viper.Viper) error {
+	var opt app.ShowSkeletonManifestOptions
+
+	opt.GroupBy = v.GetString("group-by")
+	opt.OutputFile = v.GetString("output-file")
+	if opt.OutputFile == "" {
+		opt.OutputFile = fissile.Options.RoleManifest
+	}
+
+	return fissile.ShowSkeletonManifest(opt)
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showTemplatesCmd represents the templates command
+var showTemplatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Lists the configuration templates of an instance group.",
+	Long: `
+Lists every configuration template of the instance group given by --role,
+including the global templates of the role manifest, with the variables it
+references. Templates that fail to parse are listed with the error and the
+line it was found at, and make the command fail.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var opt app.ShowTemplatesOptions
+
+		opt.Role = showTemplatesViper.GetString("role")
+		if opt.Role == "" {
+			return fmt.Errorf("The instance group to show the templates of is required (--role)")
+		}
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowTemplates(opt)
+	},
+}
+
+var showTemplatesViper = viper.New()
+
+func init() {
+	initViper(showTemplatesViper)
+
+	showCmd.AddCommand(showTemplatesCmd)
+
+	showTemplatesCmd.PersistentFlags().StringP(
+		"role",
+		"",
+		"",
+		"Name of the instance group to list the templates of",
+	)
+
+	showTemplatesViper.BindPFlags(showTemplatesCmd.PersistentFlags())
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"code.cloudfoundry.org/fissile/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showVariableCmd represents the variable command
+var showVariableCmd = &cobra.Command{
+	Use:   "variable",
+	Short: "Shows the defaults of the variables and where they are set.",
+	Long: `
+Lists the variables of the role manifest, or just the one given by --name,
+with their defaults and the source of each: the role manifest, or the defaults
+file overriding it, from the defaults_files of the role manifest or
+--defaults-files. The defaults of secrets are not shown.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var opt app.ShowVariableOptions
+
+		opt.Name = showVariableViper.GetString("name")
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.ShowVariable(opt)
+	},
+}
+
+var showVariableViper = viper.New()
+
+func init() {
+	initViper(showVariableViper)
+
+	showCmd.AddCommand(showVariableCmd)
+
+	showVariableCmd.PersistentFlags().StringP(
+		"name",
+		"",
+		"",
+		"Name of the variable to show; all variables are shown by default",
+	)
+
+	showVariableViper.BindPFlags(showVariableCmd.PersistentFlags())
+}
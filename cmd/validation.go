@@ -1,7 +1,17 @@
 package cmd
 
 import (
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/app"
+	"code.cloudfoundry.org/fissile/validation"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	flagValidateValues   string
+	flagValidateSuppress string
 )
 
 // validateCmd represents the release command
@@ -9,22 +19,112 @@ var validateCmd = &cobra.Command{
 	Use:   "validate",
 	Short: "Validates all the configuration going into fissile.",
 	Long: `
-Displays a report of all validation checks.
+Displays a report of all validation checks, grouped by instance group or
+top-level key. Each error has a stable code (FISSILE-Vxxx) identifying the
+validation reporting it; use --output json for machine readable results.
+
+Only the role manifest, the opinions and the releases are loaded; neither
+docker nor compiled packages are needed, so this is suitable for linting on CI
+nodes without build infrastructure. All errors are reported at once: those in
+the structure of the role manifest (unknown instance group types, jobs missing
+from the releases, ...) together with those of the variables. Only the checks
+depending on a resolved manifest are left out until the structure is fixed.
+
+With --values, a helm values file is checked as well, and a warning is printed
+for every deprecated variable that is set in it. The values of variables are
+checked against their validation rules, and job property overrides under
+properties.<instance group>.<job> against the job specs.
+
+Known exceptions can be listed in a suppressions file passed via --suppress:
+
+  suppress:
+  - code: FISSILE-V001
+    field: instance_groups[nats].*   # optional, "*" matches anything
+    reason: Known issue
+
+Site policies can be checked by validator plugins, passed via the global
+--validator-plugins flag. Each plugin is an executable receiving the loaded
+role manifest as JSON on its standard input, and writing the violations it
+finds as JSON to its standard output:
+
+  {"errors": [{"code": "ACME-001", "field": "instance_groups[nats]", "detail": "..."}],
+   "warnings": [{"code": "ACME-002", "field": "instance_groups[nats]", "detail": "..."}]}
+
+Their errors are reported with the codes chosen by the plugin, and can be
+suppressed like all other errors; warnings don't fail the validation.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		flagValidateValues = validateViper.GetString("values")
+		flagValidateSuppress = validateViper.GetString("suppress")
+
+		var suppressions []validation.Suppression
+		if flagValidateSuppress != "" {
+			var err error
+			suppressions, err = validation.LoadSuppressions(flagValidateSuppress)
+			if err != nil {
+				return err
+			}
+		}
+
 		err := fissile.LoadManifest()
+		if manifestErr, ok := err.(*app.ManifestError); ok {
+			return reportValidationErrors(manifestErr.Errors, suppressions)
+		}
 		if err != nil {
 			return err
 		}
 
 		errs := fissile.Validate()
-		if len(errs) > 0 {
-			return errs
+		if flagValidateValues != "" {
+			err = fissile.ValidateValues(flagValidateValues)
+			if valuesErrs, ok := err.(validation.ErrorList); ok {
+				errs = append(errs, valuesErrs...)
+			} else if err != nil {
+				return err
+			}
 		}
-		return nil
+
+		return reportValidationErrors(errs, suppressions)
 	},
 }
+var validateViper = viper.New()
+
+// reportValidationErrors prints all errors not suppressed, and returns an
+// error if there are any left.
+func reportValidationErrors(errs validation.ErrorList, suppressions []validation.Suppression) error {
+	errs = errs.Suppress(suppressions)
+	if len(errs) == 0 && fissile.Options.OutputFormat == app.OutputFormatHuman {
+		return nil
+	}
+
+	err := fissile.ReportValidationErrors(errs)
+	if err != nil {
+		return err
+	}
+	if failures := errs.Failures(); len(failures) > 0 {
+		return fmt.Errorf("%d validation errors found", len(failures))
+	}
+	return nil
+}
 
 func init() {
+	initViper(validateViper)
+
 	RootCmd.AddCommand(validateCmd)
+
+	validateCmd.PersistentFlags().StringP(
+		"values",
+		"",
+		"",
+		"Path to a helm values file to check for deprecated variables, invalid values and unknown job properties",
+	)
+
+	validateCmd.PersistentFlags().StringP(
+		"suppress",
+		"",
+		"",
+		"Path to a YAML file listing validation error codes to suppress as known exceptions",
+	)
+
+	validateViper.BindPFlags(validateCmd.PersistentFlags())
 }
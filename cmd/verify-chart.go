@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/fissile/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// verifyChartCmd represents the chart command
+var verifyChartCmd = &cobra.Command{
+	Use:   "chart",
+	Short: "Installs the helm chart into an ephemeral kind cluster.",
+	Long: `
+Runs an end-to-end smoke test of the helm chart, for use in CI:
+
+- creates a throwaway kind cluster
+- loads the images built by ` + "`fissile build images`" + ` into it
+- installs the chart with the given --values files, waiting for all instance
+  groups to become ready
+- waits for the jobs of the --errands instance groups to complete; the jobs of
+  manual errands, which the chart doesn't contain, are rendered with the same
+  values and created first
+- collects the state of the namespace and the logs of all pods into --logs-dir
+  if any step fails
+- deletes the cluster, unless --keep-cluster is set
+
+Each waiting step is limited to --timeout seconds.  The command fails if any
+step fails.  It requires kind, kubectl and helm 3 in the PATH.  The values must
+make the chart use the loaded images, i.e. set kube.registry.hostname and
+kube.organization to match --docker-registry and --docker-organization.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var opt app.VerifyChartOptions
+
+		opt.ChartDir = verifyChartViper.GetString("chart-dir")
+		opt.ValuesFiles = splitNonEmpty(verifyChartViper.GetString("values"), ",")
+		opt.ClusterName = verifyChartViper.GetString("cluster-name")
+		opt.NodeImage = verifyChartViper.GetString("node-image")
+		opt.Namespace = verifyChartViper.GetString("namespace")
+		opt.Release = verifyChartViper.GetString("release")
+		opt.Errands = splitNonEmpty(verifyChartViper.GetString("errands"), ",")
+		opt.LogsDir = verifyChartViper.GetString("logs-dir")
+		opt.TagExtra = verifyChartViper.GetString("tag-extra")
+		opt.Timeout = time.Duration(verifyChartViper.GetInt("timeout")) * time.Second
+		opt.KeepCluster = verifyChartViper.GetBool("keep-cluster")
+
+		if opt.LogsDir == "" {
+			opt.LogsDir = filepath.Join(fissile.Options.WorkDir, "verify-logs")
+		}
+
+		err := fissile.LoadManifest()
+		if err != nil {
+			return err
+		}
+
+		return fissile.VerifyChart(opt)
+	},
+}
+var verifyChartViper = viper.New()
+
+func init() {
+	initViper(verifyChartViper)
+
+	verifyCmd.AddCommand(verifyChartCmd)
+
+	verifyChartCmd.PersistentFlags().StringP(
+		"chart-dir",
+		"",
+		".",
+		"Directory of the helm chart to install, as written by fissile build helm",
+	)
+
+	// viper is busted w/ string slice, https://github.com/spf13/viper/issues/200
+	verifyChartCmd.PersistentFlags().StringP(
+		"values",
+		"",
+		"",
+		"Helm values files to install the chart with; comma separated",
+	)
+
+	verifyChartCmd.PersistentFlags().StringP(
+		"cluster-name",
+		"",
+		"fissile-verify",
+		"Name of the kind cluster to create",
+	)
+
+	verifyChartCmd.PersistentFlags().StringP(
+		"node-image",
+		"",
+		"",
+		"Kind node image, selecting the Kubernetes version; uses the kind default if empty",
+	)
+
+	verifyChartCmd.PersistentFlags().StringP(
+		"namespace",
+		"",
+		"fissile-verify",
+		"Namespace to install the chart into",
+	)
+
+	verifyChartCmd.PersistentFlags().StringP(
+		"release",
+		"",
+		"fissile-verify",
+		"Name of the helm release",
+	)
+
+	verifyChartCmd.PersistentFlags().StringP(
+		"errands",
+		"",
+		"",
+		"Bosh-task instance groups whose jobs must complete successfully; comma separated",
+	)
+
+	verifyChartCmd.PersistentFlags().StringP(
+		"logs-dir",
+		"",
+		"",
+		"Directory to collect the logs into on failure; defaults to verify-logs in the work directory",
+	)
+
+	verifyChartCmd.PersistentFlags().StringP(
+		"tag-extra",
+		"",
+		"",
+		"Additional information to use in computing the image tags",
+	)
+
+	verifyChartCmd.PersistentFlags().IntP(
+		"timeout",
+		"",
+		600,
+		"Time in seconds each step may wait for the cluster",
+	)
+
+	verifyChartCmd.PersistentFlags().BoolP(
+		"keep-cluster",
+		"",
+		false,
+		"Keep the kind cluster after the verification, e.g. for debugging",
+	)
+
+	verifyChartViper.BindPFlags(verifyChartCmd.PersistentFlags())
+}
@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Has subcommands that verify the generated artifacts.",
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+}
@@ -1,15 +1,38 @@
 package cmd
 
 import (
+	"code.cloudfoundry.org/fissile/app"
+	"code.cloudfoundry.org/fissile/util"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // versionCmd represents the version command
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Displays fissile's version.",
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `
+Displays fissile's version. With --check, the version is compared with the
+latest release listed in the release feed (the GitHub releases API of fissile
+by default). With --update, a newer release is downloaded, its signature is
+verified with --public-key, and the running fissile binary is replaced.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		fissile.UI.Printf("%s\n", version)
+
+		if !versionViper.GetBool("check") && !versionViper.GetBool("update") {
+			return nil
+		}
+
+		return fissile.CheckVersion(app.VersionCheckOptions{
+			Feed:      versionViper.GetString("release-feed"),
+			Update:    versionViper.GetBool("update"),
+			PublicKey: versionViper.GetString("public-key"),
+			HTTPOptions: util.HTTPOptions{
+				Proxy:      viper.GetString("http-proxy"),
+				CACertFile: viper.GetString("ca-cert"),
+			},
+		})
 	},
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 		// We're simply overriding the root pre-run, since the docs commands
@@ -18,6 +41,40 @@ var versionCmd = &cobra.Command{
 	},
 }
 
+var versionViper = viper.New()
+
 func init() {
+	initViper(versionViper)
+
 	RootCmd.AddCommand(versionCmd)
+
+	versionCmd.PersistentFlags().BoolP(
+		"check",
+		"",
+		false,
+		"Check whether a newer fissile release is available",
+	)
+
+	versionCmd.PersistentFlags().BoolP(
+		"update",
+		"",
+		false,
+		"Replace the fissile binary with the latest release, if newer; implies --check",
+	)
+
+	versionCmd.PersistentFlags().StringP(
+		"release-feed",
+		"",
+		"https://api.github.com/repos/cloudfoundry-incubator/fissile/releases/latest",
+		"URL of the latest fissile release, in the format of the GitHub releases API",
+	)
+
+	versionCmd.PersistentFlags().StringP(
+		"public-key",
+		"",
+		"",
+		"Base64-encoded ed25519 public key the release archives are signed with; required by --update",
+	)
+
+	versionViper.BindPFlags(versionCmd.PersistentFlags())
 }
@@ -0,0 +1,323 @@
+package compilator
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SUSE/stampy"
+)
+
+const (
+	// defaultPackageMemory is the memory assumed to be used by the compilation
+	// of a package without historical metrics
+	defaultPackageMemory = 512 * 1024 * 1024
+
+	// memorySeriesPrefix prefixes the metrics series recording the peak
+	// memory used by the compilation of each package, in bytes
+	memorySeriesPrefix = "compile-packages::memory::"
+
+	// peakMemoryFile is written to the output directory of the compilation
+	// containers by the compilation script, holding the peak memory of the
+	// container in bytes
+	peakMemoryFile = ".fissile-peak-memory"
+)
+
+// HostResources describes the resources available for compiling packages,
+// i.e. those of the host, or those of the container fissile runs in.
+type HostResources struct {
+	CPUs int
+	// Memory is the available memory in bytes; zero if unknown
+	Memory uint64
+}
+
+// DetectHostResources returns the CPUs and the memory currently available to
+// fissile. When running inside a container, its limits are taken into account.
+func DetectHostResources() HostResources {
+	return detectHostResources("/")
+}
+
+// detectHostResources reads the resources from the /proc and /sys files
+// below the root directory
+func detectHostResources(root string) HostResources {
+	resources := HostResources{CPUs: runtime.NumCPU()}
+
+	if quota := readCgroupCPUs(root); quota > 0 && quota < resources.CPUs {
+		resources.CPUs = quota
+	}
+
+	resources.Memory = readMemAvailable(root)
+	if limit := readCgroupMemory(root); limit > 0 && (resources.Memory == 0 || limit < resources.Memory) {
+		resources.Memory = limit
+	}
+
+	return resources
+}
+
+// readMemAvailable returns the memory available on the host, according to
+// /proc/meminfo
+func readMemAvailable(root string) uint64 {
+	contents, err := ioutil.ReadFile(filepath.Join(root, "proc", "meminfo"))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kilobytes, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kilobytes * 1024
+	}
+	return 0
+}
+
+// readCgroupCPUs returns the number of CPUs the CPU quota of the cgroup
+// (v2, or v1) amounts to, rounded up; zero if there is no quota
+func readCgroupCPUs(root string) int {
+	var quota, period int64
+	if fields := strings.Fields(readCgroupFile(root, "cpu.max")); len(fields) == 2 {
+		quota, _ = strconv.ParseInt(fields[0], 10, 64)
+		period, _ = strconv.ParseInt(fields[1], 10, 64)
+	} else {
+		quota, _ = strconv.ParseInt(readCgroupFile(root, "cpu/cpu.cfs_quota_us"), 10, 64)
+		period, _ = strconv.ParseInt(readCgroupFile(root, "cpu/cpu.cfs_period_us"), 10, 64)
+	}
+	if quota <= 0 || period <= 0 {
+		return 0
+	}
+	return int((quota + period - 1) / period)
+}
+
+// readCgroupMemory returns the memory left below the memory limit of the
+// cgroup (v2, or v1); zero if there is no limit
+func readCgroupMemory(root string) uint64 {
+	limitFile, usageFile := "memory.max", "memory.current"
+	if readCgroupFile(root, limitFile) == "" {
+		limitFile, usageFile = "memory/memory.limit_in_bytes", "memory/memory.usage_in_bytes"
+	}
+	limit, err := strconv.ParseUint(readCgroupFile(root, limitFile), 10, 64)
+	if err != nil || limit == 0 {
+		// "max" means unlimited
+		return 0
+	}
+	usage, _ := strconv.ParseUint(readCgroupFile(root, usageFile), 10, 64)
+	if usage >= limit {
+		return 1
+	}
+	return limit - usage
+}
+
+// readCgroupFile returns the trimmed contents of a file of the cgroup
+// filesystem, or an empty string if it cannot be read
+func readCgroupFile(root, name string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(root, "sys", "fs", "cgroup", name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(contents))
+}
+
+// readPackageMemoryHistory returns the peak memory used by the compilation
+// of each package ("release/package") as recorded in the metrics file. The
+// largest value of several compilations is used.
+func readPackageMemoryHistory(metricsPath string) (map[string]uint64, error) {
+	history := map[string]uint64{}
+	if metricsPath == "" {
+		return history, nil
+	}
+
+	file, err := os.Open(metricsPath)
+	if os.IsNotExist(err) {
+		return history, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading metrics %s: %v", metricsPath, err)
+		}
+		if len(record) < 4 || !strings.HasPrefix(record[2], memorySeriesPrefix) {
+			continue
+		}
+		memory, err := strconv.ParseUint(record[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimPrefix(record[2], memorySeriesPrefix)
+		if memory > history[name] {
+			history[name] = memory
+		}
+	}
+	return history, nil
+}
+
+// recordPackageMemory stamps the peak memory used by the compilation of the
+// package into the metrics file, for later runs to estimate from
+func (c *Compilator) recordPackageMemory(releaseName, packageName string, memory uint64) {
+	if c.metricsPath == "" {
+		return
+	}
+	stampy.Stamp(c.metricsPath, "fissile", memorySeriesPrefix+releaseName+"/"+packageName, strconv.FormatUint(memory, 10))
+}
+
+// recordContainerMemory records the peak memory reported by the compilation
+// container of the package in the output directory, and removes the report
+// from the compiled package. Containers without a memory cgroup report none.
+func (c *Compilator) recordContainerMemory(releaseName, packageName, outputDir string) error {
+	path := filepath.Join(outputDir, peakMemoryFile)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if memory, err := strconv.ParseUint(strings.TrimSpace(string(contents)), 10, 64); err == nil {
+		c.recordPackageMemory(releaseName, packageName, memory)
+	}
+	return os.Remove(path)
+}
+
+// workerTuner limits the number of packages compiled at the same time, so
+// that they fit into the CPUs and memory of the host. The memory required by
+// each package is estimated from historical metrics, and the memory still
+// available is checked again whenever a package is about to be compiled.
+type workerTuner struct {
+	resources HostResources
+	estimates map[string]uint64
+	// available returns the memory available right now; zero if unknown
+	available func() uint64
+
+	mutex    sync.Mutex
+	running  int
+	reserved uint64
+	// released is closed (and replaced) whenever a compilation finishes
+	released chan struct{}
+}
+
+// newWorkerTuner returns a tuner for the given resources and estimates
+func newWorkerTuner(resources HostResources, estimates map[string]uint64, available func() uint64) *workerTuner {
+	return &workerTuner{
+		resources: resources,
+		estimates: estimates,
+		available: available,
+		released:  make(chan struct{}),
+	}
+}
+
+// estimate returns the memory the compilation of the package is expected to use
+func (t *workerTuner) estimate(name string) uint64 {
+	if memory, ok := t.estimates[name]; ok {
+		return memory
+	}
+	return defaultPackageMemory
+}
+
+// tryAcquire reserves the resources to compile the package, if they are
+// available. A package is always admitted when nothing else is compiling, so
+// that compilation makes progress even if the estimate exceeds the memory.
+// The returned channel is closed when resources are released.
+func (t *workerTuner) tryAcquire(name string) (bool, <-chan struct{}) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	memory := t.estimate(name)
+	admit := t.running == 0
+	if !admit && t.running < t.resources.CPUs {
+		admit = true
+		if t.resources.Memory > 0 {
+			admit = t.reserved+memory <= t.resources.Memory
+		}
+		if available := t.available(); admit && available > 0 {
+			admit = memory <= available
+		}
+	}
+	if !admit {
+		return false, t.released
+	}
+
+	t.running++
+	t.reserved += memory
+	return true, nil
+}
+
+// acquire waits until the resources to compile the package are available,
+// and reserves them. It returns false if aborted by the kill channel.
+func (t *workerTuner) acquire(name string, killCh <-chan struct{}, waiting func()) bool {
+	notified := false
+	for {
+		ok, released := t.tryAcquire(name)
+		if ok {
+			return true
+		}
+		if !notified {
+			waiting()
+			notified = true
+		}
+		// The memory available may also grow independently of fissile
+		select {
+		case <-killCh:
+			return false
+		case <-released:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// release frees the resources reserved for compiling the package
+func (t *workerTuner) release(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.running--
+	t.reserved -= t.estimate(name)
+	close(t.released)
+	t.released = make(chan struct{})
+}
+
+// EnableWorkerAutoTuning makes Compile determine the number of packages
+// compiled at the same time from the resources of the host, instead of the
+// given worker count. Packages known to use much memory, according to the
+// metrics of earlier runs, reduce the number of concurrent compilations.
+// Outside Linux, docker runs its containers in a VM, so the resources of the
+// docker daemon are used instead of those of the host.
+func (c *Compilator) EnableWorkerAutoTuning() error {
+	history, err := readPackageMemoryHistory(c.metricsPath)
+	if err != nil {
+		return err
+	}
+	if c.dockerManager != nil && runtime.GOOS != "linux" {
+		cpus, memory, err := c.dockerManager.ServerResources()
+		if err != nil {
+			return err
+		}
+		c.tuner = newWorkerTuner(HostResources{CPUs: cpus, Memory: memory}, history, func() uint64 {
+			return memory
+		})
+		return nil
+	}
+	c.tuner = newWorkerTuner(DetectHostResources(), history, func() uint64 {
+		return DetectHostResources().Memory
+	})
+	return nil
+}
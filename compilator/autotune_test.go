@@ -0,0 +1,174 @@
+package compilator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeHostFiles(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "fissile-autotune-")
+	require.NoError(t, err)
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	}
+	return root
+}
+
+func TestDetectHostResources(t *testing.T) {
+	t.Parallel()
+
+	meminfo := "MemTotal:       16000000 kB\nMemAvailable:    8000000 kB\n"
+
+	t.Run("Host", func(t *testing.T) {
+		t.Parallel()
+		root := writeHostFiles(t, map[string]string{"proc/meminfo": meminfo})
+		defer os.RemoveAll(root)
+
+		assert.Equal(t, HostResources{CPUs: runtime.NumCPU(), Memory: 8000000 * 1024}, detectHostResources(root))
+	})
+
+	t.Run("CgroupV1", func(t *testing.T) {
+		t.Parallel()
+		root := writeHostFiles(t, map[string]string{
+			"proc/meminfo":                               meminfo,
+			"sys/fs/cgroup/cpu/cpu.cfs_quota_us":         "150000\n",
+			"sys/fs/cgroup/cpu/cpu.cfs_period_us":        "100000\n",
+			"sys/fs/cgroup/memory/memory.limit_in_bytes": "2147483648\n",
+			"sys/fs/cgroup/memory/memory.usage_in_bytes": "1073741824\n",
+		})
+		defer os.RemoveAll(root)
+
+		resources := detectHostResources(root)
+		assert.Equal(t, minInt(2, runtime.NumCPU()), resources.CPUs)
+		assert.Equal(t, uint64(1073741824), resources.Memory)
+	})
+
+	t.Run("CgroupV2", func(t *testing.T) {
+		t.Parallel()
+		root := writeHostFiles(t, map[string]string{
+			"proc/meminfo":                 meminfo,
+			"sys/fs/cgroup/cpu.max":        "max 100000\n",
+			"sys/fs/cgroup/memory.max":     "max\n",
+			"sys/fs/cgroup/memory.current": "1073741824\n",
+		})
+		defer os.RemoveAll(root)
+
+		assert.Equal(t, HostResources{CPUs: runtime.NumCPU(), Memory: 8000000 * 1024}, detectHostResources(root))
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		t.Parallel()
+		root := writeHostFiles(t, nil)
+		defer os.RemoveAll(root)
+
+		assert.Equal(t, HostResources{CPUs: runtime.NumCPU()}, detectHostResources(root))
+	})
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestReadPackageMemoryHistory(t *testing.T) {
+	t.Parallel()
+
+	root := writeHostFiles(t, map[string]string{"metrics.csv": `2019-01-01 00:00:00,fissile,compile-packages::run::tor/tor,start
+2019-01-01 00:00:01,fissile,compile-packages::memory::tor/tor,1000
+2019-01-01 00:00:02,fissile,compile-packages::memory::tor/libevent,300
+2019-01-01 00:01:01,fissile,compile-packages::memory::tor/tor,2000
+2019-01-01 00:02:01,fissile,compile-packages::memory::tor/tor,1500
+`})
+	defer os.RemoveAll(root)
+
+	history, err := readPackageMemoryHistory(filepath.Join(root, "metrics.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"tor/tor": 2000, "tor/libevent": 300}, history)
+
+	history, err = readPackageMemoryHistory(filepath.Join(root, "missing.csv"))
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestRecordContainerMemory(t *testing.T) {
+	t.Parallel()
+
+	root := writeHostFiles(t, map[string]string{
+		"compiled/bin/tor":           "binary",
+		"compiled/" + peakMemoryFile: "4096\n",
+	})
+	defer os.RemoveAll(root)
+
+	c := &Compilator{metricsPath: filepath.Join(root, "metrics.csv")}
+	require.NoError(t, c.recordContainerMemory("tor", "tor", filepath.Join(root, "compiled")))
+	_, err := os.Stat(filepath.Join(root, "compiled", peakMemoryFile))
+	assert.True(t, os.IsNotExist(err), "The report should not be part of the package")
+	assert.FileExists(t, filepath.Join(root, "compiled", "bin", "tor"))
+
+	history, err := readPackageMemoryHistory(c.metricsPath)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"tor/tor": 4096}, history)
+
+	// Containers without a memory cgroup report nothing
+	require.NoError(t, c.recordContainerMemory("tor", "libevent", filepath.Join(root, "compiled")))
+}
+
+func TestWorkerTuner(t *testing.T) {
+	t.Parallel()
+
+	available := uint64(10000)
+	tuner := newWorkerTuner(HostResources{CPUs: 3, Memory: 10000},
+		map[string]uint64{"big": 6000, "small": 1000, "huge": 20000},
+		func() uint64 { return available })
+
+	ok, _ := tuner.tryAcquire("huge")
+	assert.True(t, ok, "a package is always admitted when nothing else compiles")
+	ok, _ = tuner.tryAcquire("small")
+	assert.False(t, ok, "the huge package reserves all memory")
+	tuner.release("huge")
+
+	ok, _ = tuner.tryAcquire("big")
+	assert.True(t, ok)
+	ok, _ = tuner.tryAcquire("small")
+	assert.True(t, ok)
+	ok, released := tuner.tryAcquire("big")
+	assert.False(t, ok, "the second big package exceeds the memory")
+
+	ok, _ = tuner.tryAcquire("small")
+	assert.True(t, ok)
+	ok, _ = tuner.tryAcquire("small")
+	assert.False(t, ok, "all CPUs are in use")
+
+	tuner.release("big")
+	select {
+	case <-released:
+	default:
+		assert.Fail(t, "release does not signal waiting packages")
+	}
+
+	available = 500
+	ok, _ = tuner.tryAcquire("small")
+	assert.False(t, ok, "the memory currently available is too little")
+
+	available = 10000
+	killCh := make(chan struct{})
+	close(killCh)
+	assert.True(t, tuner.acquire("small", killCh, func() {}))
+
+	waited := false
+	start := time.Now()
+	assert.False(t, tuner.acquire("small", killCh, func() { waited = true }))
+	assert.True(t, waited)
+	assert.True(t, time.Since(start) < time.Second)
+}
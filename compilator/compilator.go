@@ -3,13 +3,14 @@ package compilator
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime/trace"
 	"sort"
-	"strings"
 	"time"
 
 	"code.cloudfoundry.org/fissile/docker"
@@ -50,6 +51,7 @@ type Compilator struct {
 	compilePackage    func(*Compilator, *model.Package) error
 	packageStorage    *PackageStorage
 	streamPackages    bool
+	progress          *ProgressWriter
 
 	// signalDependencies is a map of
 	//    (package fingerprint) -> (channel to close when done)
@@ -67,14 +69,21 @@ type Compilator struct {
 	keepContainer      bool
 	ui                 *termui.UI
 	grapher            util.ModelGrapher
+
+	// tuner limits the concurrent compilations when auto-tuning the
+	// workers; see EnableWorkerAutoTuning
+	tuner *workerTuner
 }
 
 type compileJob struct {
 	workerPackage *workerLib.Package
 	pkg           *model.Package
 	compilator    *Compilator
+	force         bool
 	doneCh        chan<- compileResult
 	killCh        <-chan struct{}
+	// traceCtx is the context of the execution trace task of the run
+	traceCtx context.Context
 }
 
 // NewDockerCompilator will create an instance of the Compilator using docker
@@ -113,6 +122,35 @@ func NewDockerCompilator(
 	return compilator, nil
 }
 
+// CheckStemcellPlatform warns if the stemcell is built for another platform
+// than the packages are compiled on: that of the docker daemon, or the host
+// when compiling without docker. Stemcells that can't be inspected are not
+// checked when compiling without docker, nor when they aren't available
+// locally.
+func CheckStemcellPlatform(ui *termui.UI, dockerManager *docker.ImageManager, stemcellImageName string, withoutDocker bool) error {
+	compiler := docker.HostPlatform()
+	if !withoutDocker {
+		var err error
+		compiler, err = dockerManager.ServerPlatform()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := dockerManager.CheckStemcellPlatform(stemcellImageName, compiler)
+	switch err.(type) {
+	case nil, docker.ErrImageNotFound:
+		return nil
+	case docker.ErrPlatformMismatch:
+		ui.Println(color.YellowString("Warning: %v", err))
+		return nil
+	}
+	if withoutDocker {
+		return nil
+	}
+	return err
+}
+
 // NewMountNSCompilator will create an instance of the Compilator using a mount
 // namespace (Linux only)
 func NewMountNSCompilator(
@@ -126,6 +164,10 @@ func NewMountNSCompilator(
 	packageStorage *PackageStorage,
 ) (*Compilator, error) {
 
+	if !mountNSSupported {
+		return nil, fmt.Errorf("Compilation without docker is not supported on %s", docker.HostPlatform())
+	}
+
 	compilator := &Compilator{
 		hostWorkDir:        hostWorkDir,
 		metricsPath:        metricsPath,
@@ -180,6 +222,38 @@ func (c *Compilator) Compile(workerCount int, releases []*model.Release, instanc
 	if err != nil {
 		return fmt.Errorf("failed to remove compiled packages: %v", err)
 	}
+	return c.compilePackages(workerCount, packages, nil)
+}
+
+// CompilePackage compiles a single package, along with those of its
+// (transitive) dependencies that are not compiled yet. With force, the
+// package itself is compiled again even if it is already compiled, or
+// available from the package cache.
+func (c *Compilator) CompilePackage(workerCount int, target *model.Package, force, verbose bool) error {
+	packages := c.gatherPackageChain(target)
+
+	if force {
+		if err := os.RemoveAll(target.GetPackageCompiledDir(c.hostWorkDir)); err != nil {
+			return fmt.Errorf("failed to remove the compiled package %s: %v", target.Name, err)
+		}
+	}
+
+	packages, err := c.removeCompiledPackages(packages, verbose)
+	if err != nil {
+		return fmt.Errorf("failed to remove compiled packages: %v", err)
+	}
+
+	forced := map[string]bool{}
+	if force {
+		forced[target.Fingerprint] = true
+	}
+	return c.compilePackages(workerCount, packages, forced)
+}
+
+// compilePackages compiles the packages, which must not be compiled yet;
+// the forced ones (by fingerprint) skip the package cache.
+func (c *Compilator) compilePackages(workerCount int, packages model.Packages, forced map[string]bool) error {
+	var err error
 	if 0 == len(packages) {
 		c.ui.Println("No package needed to be built")
 		return nil
@@ -190,18 +264,35 @@ func (c *Compilator) Compile(workerCount int, releases []*model.Release, instanc
 	doneCh := make(chan compileResult)
 	killCh := make(chan struct{})
 
+	if c.tuner != nil {
+		workerCount = c.tuner.resources.CPUs
+		c.ui.Printf("Auto-tuning workers: up to %s, with %s of memory available\n",
+			color.YellowString("%d", workerCount),
+			color.YellowString("%d MiB", c.tuner.resources.Memory/1024/1024))
+	}
 	workerLib.MaxJobs = workerCount
 
 	worker := workerLib.NewWorker()
-	buckets := createDepBuckets(packages)
+	durations, err := readDurationHistory(c.hostWorkDir)
+	if err != nil {
+		c.ui.Println(color.YellowString("Ignoring the compile duration history: %v", err))
+	}
+	buckets := createDepBuckets(packages, durations)
+
+	// The scheduling shows up in execution traces (fissile --trace) as a
+	// task per package, below the task of the run
+	traceCtx, task := trace.NewTask(context.Background(), "compile-packages")
+	defer task.End()
 
 	// ... load it with the jobs to run ...
 	for _, pkg := range buckets {
 		worker.Add(compileJob{
 			pkg:        pkg,
 			compilator: c,
+			force:      forced[pkg.Fingerprint],
 			killCh:     killCh,
 			doneCh:     doneCh,
+			traceCtx:   traceCtx,
 		})
 	}
 
@@ -280,6 +371,11 @@ func (c *Compilator) gatherPackages(releases []*model.Release, instanceGroups mo
 func (j compileJob) Run() {
 	c := j.compilator
 
+	traceCtx, task := trace.NewTask(j.traceCtx, "compile-package")
+	defer task.End()
+	trace.Log(traceCtx, "package", j.pkg.Release.Name+"/"+j.pkg.Name)
+	waitRegion := trace.StartRegion(traceCtx, "wait")
+
 	// Metrics: Overall time for the specific job
 	var waitSeriesName string
 	var runSeriesName string
@@ -300,6 +396,11 @@ func (j compileJob) Run() {
 
 	// Time spent waiting
 	for _, dep := range j.pkg.Dependencies {
+		select {
+		case <-c.signalDependencies[dep.Fingerprint]:
+		default:
+			c.emitProgress(j.pkg, ProgressEvent{Event: ProgressEventWaiting, Dependency: dep.Name})
+		}
 		done := false
 		for !done {
 			select {
@@ -307,8 +408,10 @@ func (j compileJob) Run() {
 				c.ui.Printf("killed:  %s/%s\n",
 					color.MagentaString(j.pkg.Release.Name),
 					color.MagentaString(j.pkg.Name))
+				c.emitResult(j.pkg, ProgressEventFailed, time.Time{}, errWorkerAbort)
 				j.doneCh <- compileResult{pkg: j.pkg, err: errWorkerAbort}
 
+				waitRegion.End()
 				if c.metricsPath != "" {
 					stampy.Stamp(c.metricsPath, "fissile", waitSeriesName, "done")
 				}
@@ -328,31 +431,64 @@ func (j compileJob) Run() {
 		}
 	}
 
+	// Look up the package in the cache, waiting for other builds compiling it,
+	// before taking the host resources for compiling it
+	exists, locked, err := j.lookupCache()
+	if err != nil {
+		c.emitResult(j.pkg, ProgressEventFailed, time.Time{}, err)
+		j.doneCh <- compileResult{pkg: j.pkg, err: err}
+		waitRegion.End()
+		if c.metricsPath != "" {
+			stampy.Stamp(c.metricsPath, "fissile", waitSeriesName, "done")
+		}
+		return
+	}
+
+	// Wait for the host to have the resources to compile the package
+	if c.tuner != nil && !exists {
+		name := j.pkg.Release.Name + "/" + j.pkg.Name
+		acquired := c.tuner.acquire(name, j.killCh, func() {
+			c.ui.Printf("waiting: %s/%s - %s\n",
+				color.MagentaString(j.pkg.Release.Name),
+				color.MagentaString(j.pkg.Name),
+				color.MagentaString("host resources"))
+		})
+		if !acquired {
+			if locked {
+				if err := c.packageStorage.Unlock(j.pkg); err != nil {
+					c.ui.Println(color.YellowString("Error unlocking %s in the cache: %v", j.pkg.Name, err))
+				}
+			}
+			c.emitResult(j.pkg, ProgressEventFailed, time.Time{}, errWorkerAbort)
+			j.doneCh <- compileResult{pkg: j.pkg, err: errWorkerAbort}
+			waitRegion.End()
+			if c.metricsPath != "" {
+				stampy.Stamp(c.metricsPath, "fissile", waitSeriesName, "done")
+			}
+			return
+		}
+		defer c.tuner.release(name)
+	}
+
+	waitRegion.End()
 	if c.metricsPath != "" {
 		stampy.Stamp(c.metricsPath, "fissile", waitSeriesName, "done")
 	}
+	defer trace.StartRegion(traceCtx, "compile").End()
 
 	c.ui.Printf("compile: %s/%s\n",
 		color.MagentaString(j.pkg.Release.Name),
 		color.MagentaString(j.pkg.Name))
+	start := time.Now()
+	c.emitProgress(j.pkg, ProgressEvent{Event: ProgressEventStarted})
 
 	// Time spent in actual compilation
 	if c.metricsPath != "" {
 		stampy.Stamp(c.metricsPath, "fissile", runSeriesName, "start")
 	}
 
-	exists := false
-	if c.packageStorage != nil {
-		var err error
-		c.ui.Printf("cache: %s %s\n", color.MagentaString("searching for"), j.pkg.Name)
-		exists, err = c.packageStorage.Exists(j.pkg)
-		if err != nil {
-			j.doneCh <- compileResult{pkg: j.pkg, err: err}
-		}
-	}
-
-	// Check to see whether a package already exists in the configured cache
-	// and either download that package or compile and upload it
+	// Either download the package found in the configured cache, or
+	// compile it and upload it
 	if exists {
 		c.ui.Printf("cache: downloading %s/%s\n", j.pkg.Release.Name, j.pkg.Name)
 		currentProgress := 0
@@ -372,17 +508,29 @@ func (j compileJob) Run() {
 			c.ui.Println(color.RedString("Error downloading the package"))
 		}
 
+		c.emitResult(j.pkg, ProgressEventCached, start, downloadErr)
 		j.doneCh <- compileResult{pkg: j.pkg, err: downloadErr}
 
 	} else {
 		c.ui.Printf("compiling\n")
 		var workerErr error
+		compileStart := time.Now()
 		workerErr = c.compilePackage(c, j.pkg)
+		if workerErr == nil {
+			if err := c.recordPackageDuration(j.pkg, time.Since(compileStart)); err != nil {
+				c.ui.Println(color.YellowString("Error recording the compile duration of %s: %v", j.pkg.Name, err))
+			}
+		}
 
-		if workerErr == nil && c.packageStorage != nil && c.packageStorage.ReadOnly == false {
+		if workerErr == nil && c.packageStorage != nil && !c.packageStorage.ReadOnly {
 			c.ui.Printf("uploading\n")
 			workerErr = c.packageStorage.Upload(j.pkg)
 		}
+		if locked {
+			if err := c.packageStorage.Unlock(j.pkg); err != nil {
+				c.ui.Println(color.YellowString("Error unlocking %s in the cache: %v", j.pkg.Name, err))
+			}
+		}
 		if c.metricsPath != "" {
 			stampy.Stamp(c.metricsPath, "fissile", runSeriesName, "done")
 		}
@@ -391,16 +539,65 @@ func (j compileJob) Run() {
 			color.MagentaString(j.pkg.Release.Name),
 			color.MagentaString(j.pkg.Name))
 
+		c.emitResult(j.pkg, ProgressEventFinished, start, workerErr)
 		j.doneCh <- compileResult{pkg: j.pkg, err: workerErr}
 	}
 }
 
-func createDepBuckets(packages []*model.Package) []*model.Package {
-	var buckets []*model.Package
+// lookupCache checks the configured cache for the package. It returns whether
+// the package can be downloaded from the cache, and otherwise whether the
+// package was locked in the cache for compiling and uploading it. While
+// another build holds the lock of a package missing from the cache, it waits
+// for that build to upload the package instead of compiling it as well.
+func (j compileJob) lookupCache() (exists, locked bool, err error) {
+	c := j.compilator
+	storage := c.packageStorage
+	if storage == nil {
+		return false, false, nil
+	}
 
-	// ruby takes forever and has no deps,
-	// so optimize by moving ruby packages to the front
-	var rubies []*model.Package
+	for {
+		if !j.force {
+			c.ui.Printf("cache: %s %s\n", color.MagentaString("searching for"), j.pkg.Name)
+			exists, err = storage.Exists(j.pkg)
+			if err != nil {
+				return false, false, err
+			}
+			if exists {
+				return true, false, nil
+			}
+		}
+		busy, err := storage.Locked(j.pkg)
+		if err != nil {
+			return false, false, err
+		}
+		if !busy {
+			if storage.ReadOnly {
+				return false, false, nil
+			}
+			locked, err = storage.Lock(j.pkg)
+			if err != nil || locked {
+				return false, locked, err
+			}
+		}
+
+		select {
+		case <-j.killCh:
+			return false, false, errWorkerAbort
+		case <-time.After(packageLockPollInterval):
+			c.ui.Printf("waiting: %s/%s - %s\n",
+				color.MagentaString(j.pkg.Release.Name),
+				color.MagentaString(j.pkg.Name),
+				color.MagentaString("cache"))
+		}
+	}
+}
+
+// createDepBuckets returns the packages in the order to queue them, see
+// schedulePackages; the durations are the compile durations recorded for
+// earlier compilations of the packages.
+func createDepBuckets(packages []*model.Package, durations map[string]time.Duration) []*model.Package {
+	var buckets []*model.Package
 
 	// topological sort, ensuring that each package X is queued
 	// only after all of its dependencies.
@@ -493,21 +690,13 @@ func createDepBuckets(packages []*model.Package) []*model.Package {
 				depCount[usr.Fingerprint]--
 			}
 
-			// rubies are special, see notes at top of function
-			if strings.HasPrefix(pkg.Name, "ruby-2.") {
-				rubies = append(rubies, pkg)
-				continue
-			}
-
-			// queue regular
 			buckets = append(buckets, pkg)
 		}
 	}
 
-	// prepend rubies to get them out of the way first
-	buckets = append(rubies, buckets...)
-
-	return buckets
+	// Packages taking long (e.g. ruby) are moved to the front, as far as
+	// their dependencies allow
+	return schedulePackages(buckets, revDeps, durations)
 }
 
 func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
@@ -637,6 +826,11 @@ func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
 		return fmt.Errorf("Error - compilation for package %s exited with code %d", pkg.Name, exitCode)
 	}
 
+	err = c.recordContainerMemory(pkg.Release.Name, pkg.Name, pkg.GetPackageCompiledTempDir(c.hostWorkDir))
+	if err != nil {
+		return fmt.Errorf("Error reading the peak memory of the compilation of package %s: %v", pkg.Name, err)
+	}
+
 	return os.Rename(
 		pkg.GetPackageCompiledTempDir(c.hostWorkDir),
 		pkg.GetPackageCompiledDir(c.hostWorkDir))
@@ -803,6 +997,27 @@ func (c *Compilator) removeCompiledPackages(packages model.Packages, verbose boo
 	return culledPackages, nil
 }
 
+// gatherPackageChain gathers the package and all of its (transitive)
+// dependencies, dependencies first
+func (c *Compilator) gatherPackageChain(target *model.Package) model.Packages {
+	var packages model.Packages
+
+	var gather func(pkg *model.Package)
+	gather = func(pkg *model.Package) {
+		if _, known := c.signalDependencies[pkg.Fingerprint]; known {
+			return
+		}
+		c.signalDependencies[pkg.Fingerprint] = make(chan struct{})
+		for _, dep := range pkg.Dependencies {
+			gather(dep)
+		}
+		packages = append(packages, pkg)
+	}
+	gather(target)
+
+	return packages
+}
+
 // gatherPackagesFromInstanceGroups gathers the list of packages of the release, from a list of instance groups, as well as all needed dependencies
 // This happens to be a subset of release.Packages, which helps avoid compiling unneeded packages
 func (c *Compilator) gatherPackagesFromInstanceGroups(release *model.Release, instanceGroups model.InstanceGroups) []*model.Package {
@@ -14,6 +14,9 @@ import (
 	"github.com/fatih/color"
 )
 
+// mountNSSupported tells whether packages can be compiled without docker
+const mountNSSupported = true
+
 func (c *Compilator) compilePackageInMountNS(pkg *model.Package) (err error) {
 	// Prepare input dir (package plus deps)
 	if err := c.createCompilationDirStructure(pkg); err != nil {
@@ -79,6 +82,11 @@ func (c *Compilator) compilePackageInMountNS(pkg *model.Package) (err error) {
 		return fmt.Errorf("Error compiling package %s: %s", pkg.Name, err)
 	}
 
+	if usage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+		// Maxrss is in kilobytes on Linux
+		c.recordPackageMemory(pkg.Release.Name, pkg.Name, uint64(usage.Maxrss)*1024)
+	}
+
 	return os.Rename(
 		pkg.GetPackageCompiledTempDir(c.hostWorkDir),
 		pkg.GetPackageCompiledDir(c.hostWorkDir))
@@ -8,6 +8,9 @@ import (
 	"code.cloudfoundry.org/fissile/model"
 )
 
+// mountNSSupported tells whether packages can be compiled without docker
+const mountNSSupported = false
+
 func (c *Compilator) compilePackageInMountNS(pkg *model.Package) (err error) {
 	return fmt.Errorf("Compilation without docker is not supported outside Linux")
 }
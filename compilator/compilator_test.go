@@ -2,6 +2,7 @@ package compilator
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -172,6 +173,38 @@ func TestCompilationSkipCompiled(t *testing.T) {
 	<-waitCh
 }
 
+func TestCompilationSinglePackage(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := ioutil.TempDir("", "fissile-compile-package-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	release := genTestCase("ruby-2.5", "consul>go-1.4", "go-1.4")[0]
+	consul, err := release.LookupPackage("consul")
+	require.NoError(t, err)
+
+	compile := func(force bool) []string {
+		c, err := NewDockerCompilator(nil, workDir, "", "", "", "", "", false, ui, nil, nil, false)
+		require.NoError(t, err)
+
+		var compiled []string
+		c.compilePackage = func(c *Compilator, pkg *model.Package) error {
+			compiled = append(compiled, pkg.Name)
+			compiledDir := pkg.GetPackageCompiledDir(c.hostWorkDir)
+			require.NoError(t, os.MkdirAll(compiledDir, 0755))
+			return ioutil.WriteFile(filepath.Join(compiledDir, "contents"), []byte(pkg.Name), 0644)
+		}
+
+		require.NoError(t, c.CompilePackage(1, consul, force, false))
+		return compiled
+	}
+
+	assert.Equal([]string{"go-1.4", "consul"}, compile(false), "the dependency chain is compiled, but nothing else")
+	assert.Empty(compile(false), "compiled packages are skipped")
+	assert.Equal([]string{"consul"}, compile(true), "the target is compiled again when forced")
+}
+
 func TestCompilationRoleManifest(t *testing.T) {
 
 	c, err := NewDockerCompilator(nil, "", "", "", "", "", "", false, ui, nil, nil, false)
@@ -372,6 +405,63 @@ func TestCompilationMultipleErrors(t *testing.T) {
 	assert.NotNil(err)
 }
 
+func TestCompilationProgress(t *testing.T) {
+	saveIsPackageCompiled := isPackageCompiledHarness
+	defer func() {
+		isPackageCompiledHarness = saveIsPackageCompiled
+	}()
+
+	isPackageCompiledHarness = func(c *Compilator, pkg *model.Package) (bool, error) {
+		return false, nil
+	}
+
+	workDir, err := util.TempDir("", "fissile-tests")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	c, err := NewDockerCompilator(nil, workDir, "", "", "", "", "", false, ui, nil, nil, false)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	c.SetProgressWriter(NewProgressWriter(&buf))
+	c.compilePackage = func(c *Compilator, pkg *model.Package) error {
+		if pkg.Name == "consul" {
+			return fmt.Errorf("Intentional error compiling %s", pkg.Name)
+		}
+		return nil
+	}
+
+	release := genTestCase("consul>go-1.4", "go-1.4")
+	assert.Error(t, c.Compile(1, release, nil, false))
+
+	var events []ProgressEvent
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var event ProgressEvent
+		require.NoError(t, decoder.Decode(&event))
+		assert.Equal(t, "test-release", event.Release)
+		assert.False(t, event.Time.IsZero())
+		if event.Event == ProgressEventWaiting {
+			assert.Equal(t, "consul", event.Package)
+			assert.Equal(t, "go-1.4", event.Dependency)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	require.Len(t, events, 4)
+	assert.Equal(t, ProgressEventStarted, events[0].Event)
+	assert.Equal(t, "go-1.4", events[0].Package)
+	assert.Equal(t, ProgressEventFinished, events[1].Event)
+	assert.Equal(t, "go-1.4", events[1].Package)
+	assert.Empty(t, events[1].Error)
+	assert.Equal(t, ProgressEventStarted, events[2].Event)
+	assert.Equal(t, "consul", events[2].Package)
+	assert.Equal(t, ProgressEventFailed, events[3].Event)
+	assert.Equal(t, "consul", events[3].Package)
+	assert.Equal(t, "Intentional error compiling consul", events[3].Error)
+}
+
 func TestGetPackageStatusCompiled(t *testing.T) {
 	assert := assert.New(t)
 
@@ -673,7 +763,7 @@ func TestCreateDepBuckets(t *testing.T) {
 		},
 	}
 
-	buckets := createDepBuckets(packages)
+	buckets := createDepBuckets(packages, nil)
 	assert.Equal(t, len(buckets), 4)
 	assert.Equal(t, buckets[0].Name, "ruby-2.5") // Ruby should be first
 	assert.Equal(t, buckets[1].Name, "go-1.4")
@@ -708,7 +798,7 @@ func TestCreateDepBucketsOnChain(t *testing.T) {
 		},
 	}
 
-	buckets := createDepBuckets(packages)
+	buckets := createDepBuckets(packages, nil)
 	assert.Equal(t, len(buckets), 3)
 	assert.Equal(t, buckets[0].Name, "A")
 	assert.Equal(t, buckets[1].Name, "C")
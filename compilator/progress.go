@@ -0,0 +1,118 @@
+package compilator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// Formats of the compilation progress; human readable text is always written
+// to the UI, while JSON events are written in addition
+const (
+	ProgressFormatHuman = "human"
+	ProgressFormatJSON  = "json"
+)
+
+// Events of the compilation of a package
+const (
+	// ProgressEventWaiting is emitted for each dependency a package waits for
+	ProgressEventWaiting = "waiting"
+	// ProgressEventStarted is emitted once a package starts compiling, or
+	// downloading from the package cache
+	ProgressEventStarted = "started"
+	// ProgressEventCached is emitted once a package has been downloaded
+	// from the package cache
+	ProgressEventCached = "cached"
+	// ProgressEventFinished is emitted once a package has been compiled
+	ProgressEventFinished = "finished"
+	// ProgressEventFailed is emitted if a package failed to compile, or its
+	// compilation was aborted
+	ProgressEventFailed = "failed"
+)
+
+// ProgressEvent is an event of the compilation of a package, as written in
+// the JSON progress format; one event per line
+type ProgressEvent struct {
+	Time        time.Time `json:"time"`
+	Event       string    `json:"event"`
+	Release     string    `json:"release"`
+	Package     string    `json:"package"`
+	Fingerprint string    `json:"fingerprint"`
+	// Dependency is the package waited for by waiting events
+	Dependency string `json:"dependency,omitempty"`
+	// Duration is the time spent compiling or downloading the package, in
+	// seconds, for cached, finished and failed events
+	Duration float64 `json:"duration,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// ProgressWriter writes the events of the compilation as JSON lines. It is
+// safe for use by concurrent compilations.
+type ProgressWriter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewProgressWriter returns a ProgressWriter writing to w
+func NewProgressWriter(w io.Writer) *ProgressWriter {
+	return &ProgressWriter{encoder: json.NewEncoder(w)}
+}
+
+// ValidateProgressFormat checks that the progress format is supported
+func ValidateProgressFormat(format string) error {
+	switch format {
+	case ProgressFormatHuman, ProgressFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("Invalid progress format '%s', expected one of %s or %s", format, ProgressFormatHuman, ProgressFormatJSON)
+}
+
+// Write writes an event; writing to a nil ProgressWriter does nothing
+func (p *ProgressWriter) Write(event ProgressEvent) error {
+	if p == nil {
+		return nil
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.encoder.Encode(event)
+}
+
+// SetProgressWriter makes Compile write the events of the compilation of the
+// packages to the progress writer
+func (c *Compilator) SetProgressWriter(progress *ProgressWriter) {
+	c.progress = progress
+}
+
+// emitProgress writes an event of the compilation of the package, if a
+// progress writer is set. Failing to write progress doesn't fail the
+// compilation.
+func (c *Compilator) emitProgress(pkg *model.Package, event ProgressEvent) {
+	if c.progress == nil {
+		return
+	}
+	event.Time = time.Now()
+	event.Release = pkg.Release.Name
+	event.Package = pkg.Name
+	event.Fingerprint = pkg.Fingerprint
+	if err := c.progress.Write(event); err != nil {
+		c.ui.Printf("Error writing the compilation progress: %v\n", err)
+	}
+}
+
+// emitResult writes the event finishing the compilation of the package,
+// started at the given time
+func (c *Compilator) emitResult(pkg *model.Package, event string, start time.Time, err error) {
+	progressEvent := ProgressEvent{Event: event}
+	if !start.IsZero() {
+		progressEvent.Duration = time.Since(start).Seconds()
+	}
+	if err != nil {
+		progressEvent.Event = ProgressEventFailed
+		progressEvent.Error = err.Error()
+	}
+	c.emitProgress(pkg, progressEvent)
+}
@@ -0,0 +1,181 @@
+package compilator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/fissile/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// DurationHistoryFile is the file in the compilation directory recording
+	// how long the compilation of each package took, in seconds
+	DurationHistoryFile = "compile-durations.yml"
+
+	// defaultCompileDuration is the duration assumed for the compilation of
+	// packages without history
+	defaultCompileDuration = time.Minute
+
+	// rubyCompileDuration is the duration assumed for ruby packages without
+	// history; ruby takes forever, so it should be started early
+	rubyCompileDuration = 10 * time.Minute
+)
+
+// durationHistoryMutex serializes the updates of the duration history, as
+// several compilators may share a compilation directory
+var durationHistoryMutex sync.Mutex
+
+// packageHistoryKey returns the key of the package in the duration history.
+// It does not include the fingerprint, so that new versions of a package are
+// expected to take as long as the old ones.
+func packageHistoryKey(pkg *model.Package) string {
+	if pkg.Release == nil {
+		return pkg.Name
+	}
+	return pkg.Release.Name + "/" + pkg.Name
+}
+
+// readDurationHistory returns the recorded compile durations of the packages
+// in the compilation directory
+func readDurationHistory(workDir string) (map[string]time.Duration, error) {
+	durations := map[string]time.Duration{}
+	if workDir == "" {
+		return durations, nil
+	}
+
+	path := filepath.Join(workDir, DurationHistoryFile)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return durations, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var seconds map[string]float64
+	if err := yaml.Unmarshal(contents, &seconds); err != nil {
+		return nil, fmt.Errorf("Error parsing compile durations %s: %v", path, err)
+	}
+	for key, value := range seconds {
+		durations[key] = time.Duration(value * float64(time.Second))
+	}
+	return durations, nil
+}
+
+// recordPackageDuration adds the duration of the compilation of the package
+// to the history. It is averaged with the recorded one, to smooth out noise.
+func (c *Compilator) recordPackageDuration(pkg *model.Package, duration time.Duration) error {
+	if c.hostWorkDir == "" {
+		return nil
+	}
+
+	durationHistoryMutex.Lock()
+	defer durationHistoryMutex.Unlock()
+
+	durations, err := readDurationHistory(c.hostWorkDir)
+	if err != nil {
+		return err
+	}
+	key := packageHistoryKey(pkg)
+	if previous, ok := durations[key]; ok {
+		duration = (previous + duration) / 2
+	}
+	durations[key] = duration
+
+	seconds := make(map[string]float64, len(durations))
+	for key, value := range durations {
+		seconds[key] = value.Seconds()
+	}
+	contents, err := yaml.Marshal(seconds)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that readers never see a partial file
+	path := filepath.Join(c.hostWorkDir, DurationHistoryFile)
+	if err := ioutil.WriteFile(path+".tmp", contents, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// estimateCompileDuration returns how long the compilation of the package is
+// expected to take
+func estimateCompileDuration(pkg *model.Package, durations map[string]time.Duration) time.Duration {
+	if duration, ok := durations[packageHistoryKey(pkg)]; ok {
+		return duration
+	}
+	if strings.HasPrefix(pkg.Name, "ruby-2.") {
+		return rubyCompileDuration
+	}
+	return defaultCompileDuration
+}
+
+// schedulePackages reorders the topologically sorted packages, so that
+// the packages at the head of the longest chains of estimated compile time
+// are queued first (longest processing time first), while still queuing each
+// package only after its dependencies. Ties keep the topological order.
+func schedulePackages(sorted []*model.Package, revDeps map[string][]*model.Package, durations map[string]time.Duration) []*model.Package {
+	index := make(map[string]int, len(sorted))
+	for i, pkg := range sorted {
+		index[pkg.Fingerprint] = i
+	}
+
+	// The priority of a package is its own duration, plus the longest
+	// chain of durations of the packages (transitively) using it. Walk
+	// backwards, so that all users are handled before their dependencies.
+	priority := make(map[string]time.Duration, len(sorted))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		pkg := sorted[i]
+		var longest time.Duration
+		for _, usr := range revDeps[pkg.Fingerprint] {
+			if priority[usr.Fingerprint] > longest {
+				longest = priority[usr.Fingerprint]
+			}
+		}
+		priority[pkg.Fingerprint] = estimateCompileDuration(pkg, durations) + longest
+	}
+
+	depCount := make(map[string]int, len(sorted))
+	for _, usrs := range revDeps {
+		for _, usr := range usrs {
+			depCount[usr.Fingerprint]++
+		}
+	}
+
+	var ready []*model.Package
+	for _, pkg := range sorted {
+		if depCount[pkg.Fingerprint] == 0 {
+			ready = append(ready, pkg)
+		}
+	}
+
+	scheduled := make([]*model.Package, 0, len(sorted))
+	for len(ready) > 0 {
+		sort.SliceStable(ready, func(i, j int) bool {
+			pi, pj := priority[ready[i].Fingerprint], priority[ready[j].Fingerprint]
+			if pi != pj {
+				return pi > pj
+			}
+			return index[ready[i].Fingerprint] < index[ready[j].Fingerprint]
+		})
+		pkg := ready[0]
+		ready = ready[1:]
+		scheduled = append(scheduled, pkg)
+
+		for _, usr := range revDeps[pkg.Fingerprint] {
+			depCount[usr.Fingerprint]--
+			if depCount[usr.Fingerprint] == 0 {
+				ready = append(ready, usr)
+			}
+		}
+	}
+
+	return scheduled
+}
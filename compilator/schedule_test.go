@@ -0,0 +1,82 @@
+package compilator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateDepBucketsWithHistory(t *testing.T) {
+	t.Parallel()
+
+	release := &model.Release{Name: "test-release"}
+	golang := &model.Package{Release: release, Name: "golang", Fingerprint: "GO"}
+	packages := []*model.Package{
+		{Release: release, Name: "small", Fingerprint: "SM"},
+		golang,
+		{Release: release, Name: "ruby-2.5", Fingerprint: "RU"},
+		{Release: release, Name: "slow", Fingerprint: "SL", Dependencies: []*model.Package{golang}},
+		{Release: release, Name: "quick", Fingerprint: "QU", Dependencies: []*model.Package{golang}},
+	}
+
+	durations := map[string]time.Duration{
+		"test-release/small":    5 * time.Second,
+		"test-release/golang":   30 * time.Second,
+		"test-release/ruby-2.5": 4 * time.Minute,
+		"test-release/slow":     5 * time.Minute,
+		"test-release/quick":    10 * time.Second,
+	}
+
+	var names []string
+	for _, pkg := range createDepBuckets(packages, durations) {
+		names = append(names, pkg.Name)
+	}
+	// golang heads the longest chain (golang, slow), and slow is queued
+	// as soon as its dependency is
+	assert.Equal(t, []string{"golang", "slow", "ruby-2.5", "quick", "small"}, names)
+
+	names = nil
+	for _, pkg := range createDepBuckets(packages, nil) {
+		names = append(names, pkg.Name)
+	}
+	// Without history, ruby is still queued first
+	assert.Equal(t, []string{"ruby-2.5", "golang", "small", "slow", "quick"}, names)
+}
+
+func TestDurationHistory(t *testing.T) {
+	t.Parallel()
+
+	workDir, err := ioutil.TempDir("", "fissile-durations-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	c, err := NewDockerCompilator(nil, workDir, "", "", "", "", "", false, ui, nil, nil, false)
+	require.NoError(t, err)
+
+	durations, err := readDurationHistory(workDir)
+	require.NoError(t, err)
+	assert.Empty(t, durations)
+
+	release := &model.Release{Name: "test-release"}
+	pkg := &model.Package{Release: release, Name: "golang"}
+	require.NoError(t, c.recordPackageDuration(pkg, 10*time.Second))
+	require.NoError(t, c.recordPackageDuration(pkg, 20*time.Second))
+	require.NoError(t, c.recordPackageDuration(&model.Package{Release: release, Name: "ruby-2.5"}, time.Minute))
+
+	durations, err = readDurationHistory(workDir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		"test-release/golang":   15 * time.Second,
+		"test-release/ruby-2.5": time.Minute,
+	}, durations)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workDir, DurationHistoryFile), []byte("[nope"), 0644))
+	_, err = readDurationHistory(workDir)
+	assert.Error(t, err)
+}
@@ -2,6 +2,8 @@ package compilator
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -28,6 +30,16 @@ import (
 // DownloadProgressEvent represents a delegate for updating progress when downloading
 type DownloadProgressEvent = func(progressPercentage float64)
 
+const (
+	// packageLockTimeout is how long the lock of a package being compiled is
+	// honored; the locks of builds that died while compiling are taken over
+	// after it
+	packageLockTimeout = time.Hour
+	// packageLockPollInterval is how often a locked package is checked for
+	// having been uploaded
+	packageLockPollInterval = 5 * time.Second
+)
+
 // PackageStorage represents a compiled BOSH package location
 type PackageStorage struct {
 	location           stow.Location
@@ -36,7 +48,12 @@ type PackageStorage struct {
 	CompilationWorkDir string
 	container          stow.Container
 	ImageName          string
-	ReadOnly           bool
+	// StemcellHash keys the packages in the storage, together with their
+	// fingerprints; see SetStemcellImageID
+	StemcellHash string
+	ReadOnly     bool
+	// owner identifies the locks taken by this instance
+	owner string
 }
 
 type packageStorageConfig struct {
@@ -117,6 +134,8 @@ func NewPackageStorage(kind string, readOnlyMode bool, config stow.Config, compi
 	if err != nil {
 		return nil, err
 	}
+	owner := uuid.NewV4()
+	nameHash := sha256.Sum256([]byte(stemcellImageName))
 	p = &PackageStorage{
 		Kind:               kind,
 		Config:             config,
@@ -124,11 +143,20 @@ func NewPackageStorage(kind string, readOnlyMode bool, config stow.Config, compi
 		CompilationWorkDir: compilationWorkDir,
 		container:          stowContainer,
 		ImageName:          stemcellImageName,
+		StemcellHash:       hex.EncodeToString(nameHash[:]),
 		ReadOnly:           readOnlyMode,
+		owner:              owner.String(),
 	}
 	return p, nil
 }
 
+// SetStemcellImageID keys the packages in the storage by the ID of the
+// stemcell image instead of its name, so that packages compiled on an earlier
+// build of the same stemcell tag are not reused
+func (p *PackageStorage) SetStemcellImageID(imageID string) {
+	p.StemcellHash = strings.TrimPrefix(imageID, "sha256:")
+}
+
 // Exists checks whether a package already exists in the configured
 // stow cache
 func (p *PackageStorage) Exists(pack *model.Package) (bool, error) {
@@ -191,12 +219,10 @@ func (p *PackageStorage) Download(pack *model.Package, progressEvent DownloadPro
 	}
 
 	// Unpack the compiled contents
-	err = archiver.Tar.Open(
+	return archiver.Tar.Open(
 		path,
 		filepath.Join(p.CompilationWorkDir, pack.Fingerprint),
 	)
-
-	return nil
 }
 
 // Upload uploads a package to the configured cache
@@ -224,6 +250,7 @@ func (p *PackageStorage) Upload(pack *model.Package) error {
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
 	// Upload the compiled package
 	_, err = p.container.Put(
@@ -236,8 +263,129 @@ func (p *PackageStorage) Upload(pack *model.Package) error {
 	return err
 }
 
+// Lock marks the package as being compiled by this instance, so that other
+// builds sharing the storage wait for it to be uploaded instead of compiling
+// it as well. It returns false if another build holds the lock.
+//
+// Stores don't offer conditional writes through stow, so each build writes
+// its own lock candidate and gets the lock if its candidate is older than all
+// others. A build that lists the candidates before another build wrote its
+// candidate can't lose to it, as that candidate has a later modification
+// time. Builds whose candidates tie all back off, and retry when the caller
+// polls the lock again.
+func (p *PackageStorage) Lock(pack *model.Package) (bool, error) {
+	owner, err := p.lockOwner(pack)
+	if err != nil {
+		return false, err
+	}
+	if owner != "" && owner != p.owner {
+		return false, nil
+	}
+
+	_, err = p.container.Put(p.lockFilePath(pack, p.owner), strings.NewReader(p.owner), int64(len(p.owner)), nil)
+	if err != nil {
+		return false, fmt.Errorf("Failed to lock package %s in the cache: %s", pack.Name, err.Error())
+	}
+
+	candidates, err := p.lockCandidates(pack)
+	if err != nil {
+		return false, err
+	}
+	ownMod, ok := candidates[p.owner]
+	for candidate, lastMod := range candidates {
+		if candidate != p.owner && !ownMod.Before(lastMod) {
+			ok = false
+		}
+	}
+	if !ok {
+		return false, p.Unlock(pack)
+	}
+	return true, nil
+}
+
+// Locked checks whether another build is compiling the package
+func (p *PackageStorage) Locked(pack *model.Package) (bool, error) {
+	owner, err := p.lockOwner(pack)
+	if err != nil {
+		return false, err
+	}
+	return owner != "" && owner != p.owner, nil
+}
+
+// Unlock releases the lock taken on the package by Lock
+func (p *PackageStorage) Unlock(pack *model.Package) error {
+	items, _, err := p.container.Items(p.lockFilePath(pack, p.owner), "", math.MaxInt32)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.Name() != filepath.Base(p.lockFilePath(pack, p.owner)) {
+			continue
+		}
+		if err := p.container.RemoveItem(item.ID()); err != nil {
+			return fmt.Errorf("Failed to unlock package %s in the cache: %s", pack.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// lockOwner returns the owner of the lock of the package, or an empty string
+// if the package isn't locked: the owner of the oldest candidate, with ties
+// broken by the owner
+func (p *PackageStorage) lockOwner(pack *model.Package) (string, error) {
+	candidates, err := p.lockCandidates(pack)
+	if err != nil {
+		return "", err
+	}
+
+	var owner string
+	var ownerMod time.Time
+	for candidate, lastMod := range candidates {
+		if owner == "" || lastMod.Before(ownerMod) || (lastMod.Equal(ownerMod) && candidate < owner) {
+			owner = candidate
+			ownerMod = lastMod
+		}
+	}
+	return owner, nil
+}
+
+// lockCandidates returns the modification times of the lock candidates of
+// the package by owner. Candidates older than packageLockTimeout are stale
+// and ignored.
+func (p *PackageStorage) lockCandidates(pack *model.Package) (map[string]time.Time, error) {
+	prefix := p.lockFilePath(pack, "")
+	items, _, err := p.container.Items(prefix, "", math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := map[string]time.Time{}
+	for _, item := range items {
+		name := item.Name()
+		candidate := strings.TrimPrefix(name, filepath.Base(prefix))
+		if candidate == name || candidate == "" {
+			continue
+		}
+		lastMod, err := item.LastMod()
+		if err != nil {
+			return nil, err
+		}
+		if time.Since(lastMod) > packageLockTimeout {
+			continue
+		}
+		candidates[candidate] = lastMod
+	}
+	return candidates, nil
+}
+
+// lockFilePath returns the path of the lock candidate of the owner; without
+// an owner it's the prefix shared by all candidates of the package
+func (p *PackageStorage) lockFilePath(pack *model.Package, owner string) string {
+	return filepath.Join(p.StemcellHash, fmt.Sprintf("%s.lock.%s", pack.Fingerprint, owner))
+}
+
 func (p *PackageStorage) uploadedPackageFilePath(pack *model.Package) string {
-	return filepath.Join(p.ImageName, p.uploadedPackageFileName(pack))
+	return filepath.Join(p.StemcellHash, p.uploadedPackageFileName(pack))
 }
 
 func (p *PackageStorage) uploadedPackageFileName(pack *model.Package) string {
@@ -5,7 +5,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"code.cloudfoundry.org/fissile/docker"
 	"code.cloudfoundry.org/fissile/model"
@@ -154,3 +156,113 @@ func TestStorePackageExists(t *testing.T) {
 	assert.False(existsFalse)
 	assert.True(existsTrue)
 }
+
+func TestPackageStorageLock(t *testing.T) {
+	assert := assert.New(t)
+
+	containerDir, err := util.TempDir("", "fissile-stow-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(containerDir)
+
+	config := stow.ConfigMap{"path": containerDir}
+	imageName := "splatform/fissile-stemcell-opensuse:42.2"
+	first, err := NewPackageStorage("local", false, config, containerDir, "cache", imageName)
+	if !assert.NoError(err) {
+		return
+	}
+	second, err := NewPackageStorage("local", false, config, containerDir, "cache", imageName)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(first.StemcellHash, second.StemcellHash)
+
+	pack := &model.Package{Name: "ruby", Fingerprint: "abc123"}
+
+	locked, err := first.Lock(pack)
+	assert.NoError(err)
+	assert.True(locked, "the first lock should succeed")
+
+	locked, err = first.Locked(pack)
+	assert.NoError(err)
+	assert.False(locked, "a package isn't locked against the lock owner")
+
+	locked, err = second.Locked(pack)
+	assert.NoError(err)
+	assert.True(locked, "a package is locked against other builds")
+
+	locked, err = second.Lock(pack)
+	assert.NoError(err)
+	assert.False(locked, "a locked package can't be locked by other builds")
+
+	assert.NoError(second.Unlock(pack))
+	locked, err = second.Locked(pack)
+	assert.NoError(err)
+	assert.True(locked, "only the lock owner can unlock the package")
+
+	assert.NoError(first.Unlock(pack))
+	locked, err = second.Lock(pack)
+	assert.NoError(err)
+	assert.True(locked, "an unlocked package can be locked again")
+
+	second.SetStemcellImageID("sha256:0123456789")
+	assert.Equal("0123456789", second.StemcellHash)
+	locked, err = first.Locked(pack)
+	assert.NoError(err)
+	assert.True(locked)
+}
+
+func TestPackageStorageLockConcurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	containerDir, err := util.TempDir("", "fissile-stow-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(containerDir)
+
+	config := stow.ConfigMap{"path": containerDir}
+	imageName := "splatform/fissile-stemcell-opensuse:42.2"
+	pack := &model.Package{Name: "ruby", Fingerprint: "abc123"}
+
+	const builds = 8
+	results := make(chan bool, builds)
+	var wg sync.WaitGroup
+	for i := 0; i < builds; i++ {
+		storage, err := NewPackageStorage("local", false, config, containerDir, "cache", imageName)
+		if !assert.NoError(err) {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Retry like lookupCache, as builds whose candidates tie back off
+			for {
+				busy, err := storage.Locked(pack)
+				assert.NoError(err)
+				if busy {
+					results <- false
+					return
+				}
+				locked, err := storage.Lock(pack)
+				assert.NoError(err)
+				if locked || err != nil {
+					results <- locked
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	winners := 0
+	for locked := range results {
+		if locked {
+			winners++
+		}
+	}
+	assert.Equal(1, winners, "exactly one of the concurrent builds should get the lock")
+}
@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// Backend is a container backend building the images and running the
+// compilation containers. Compilation runs containers, and so fissile talks
+// to all backends through the Docker API; backends without one, like buildah,
+// are not supported. The role images can be assembled without any backend,
+// as an OCI image layout (see the oci package).
+type Backend interface {
+	// Name is the name of the backend, as selected by UseBackend
+	Name() string
+	// NewClient returns a client of the Docker API of the backend
+	NewClient() (*dockerclient.Client, error)
+	// Command is the command line tool of the backend, which runs commands in
+	// containers that are kept around
+	Command() string
+}
+
+// Container backends
+const (
+	BackendDocker = "docker"
+	BackendPodman = "podman"
+)
+
+// backends are the supported container backends, by name
+var backends = map[string]Backend{
+	BackendDocker: dockerBackend{},
+	BackendPodman: podmanBackend{},
+}
+
+// Backends lists the names of the supported container backends
+var Backends = []string{BackendDocker, BackendPodman}
+
+// backend is the container backend of the image managers, see UseBackend
+var backend Backend = dockerBackend{}
+
+// UseBackend selects the container backend of the image managers created
+// afterwards.
+func UseBackend(name string) error {
+	selected, ok := backends[name]
+	if !ok {
+		return fmt.Errorf("Unsupported container backend %q; expected one of %s", name, strings.Join(Backends, ", "))
+	}
+	backend = selected
+	return nil
+}
+
+// dockerBackend is the Docker daemon, found through the environment
+type dockerBackend struct{}
+
+func (dockerBackend) Name() string {
+	return BackendDocker
+}
+
+func (dockerBackend) NewClient() (*dockerclient.Client, error) {
+	return dockerclient.NewClientFromEnv()
+}
+
+func (dockerBackend) Command() string {
+	return "docker"
+}
+
+// podmanBackend is podman, which serves the Docker API on a socket of its own
+// (see `podman system service`) and works without root privileges. Unless
+// DOCKER_HOST is set, fissile connects to that socket.
+type podmanBackend struct{}
+
+func (podmanBackend) Name() string {
+	return BackendPodman
+}
+
+func (podmanBackend) NewClient() (*dockerclient.Client, error) {
+	if os.Getenv("DOCKER_HOST") != "" {
+		return dockerclient.NewClientFromEnv()
+	}
+	return dockerclient.NewClient(podmanEndpoint(os.Getenv("XDG_RUNTIME_DIR"), os.Geteuid() == 0))
+}
+
+func (podmanBackend) Command() string {
+	return "podman"
+}
+
+// podmanEndpoint returns the API socket of podman: the system-wide one for
+// root, and the rootless one in the runtime directory of other users
+func podmanEndpoint(runtimeDir string, root bool) string {
+	if root || runtimeDir == "" {
+		return "unix:///run/podman/podman.sock"
+	}
+	return "unix://" + filepath.Join(runtimeDir, "podman", "podman.sock")
+}
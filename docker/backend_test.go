@@ -0,0 +1,50 @@
+package docker
+
+import (
+	"os"
+	"testing"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPodmanEndpoint(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "unix:///run/user/1000/podman/podman.sock", podmanEndpoint("/run/user/1000", false))
+	assert.Equal(t, "unix:///run/podman/podman.sock", podmanEndpoint("/run/user/1000", true))
+	assert.Equal(t, "unix:///run/podman/podman.sock", podmanEndpoint("", false))
+}
+
+func TestUseBackend(t *testing.T) {
+	defer func() { backend = dockerBackend{} }()
+
+	err := UseBackend("buildah")
+	assert.EqualError(t, err, `Unsupported container backend "buildah"; expected one of docker, podman`)
+	assert.Equal(t, BackendDocker, backend.Name())
+
+	require.NoError(t, UseBackend(BackendPodman))
+	assert.Equal(t, BackendPodman, backend.Name())
+	assert.Equal(t, "podman", backend.Command())
+
+	dockerHost, hasDockerHost := os.LookupEnv("DOCKER_HOST")
+	defer func() {
+		if hasDockerHost {
+			os.Setenv("DOCKER_HOST", dockerHost)
+		} else {
+			os.Unsetenv("DOCKER_HOST")
+		}
+	}()
+	os.Unsetenv("DOCKER_HOST")
+
+	manager, err := NewImageManager()
+	require.NoError(t, err)
+	assert.Contains(t, manager.client.(*dockerclient.Client).Endpoint(), "podman.sock")
+
+	os.Setenv("DOCKER_HOST", "tcp://127.0.0.1:2375")
+	manager, err = NewImageManager()
+	require.NoError(t, err)
+	assert.Equal(t, "tcp://127.0.0.1:2375", manager.client.(*dockerclient.Client).Endpoint(),
+		"DOCKER_HOST should take precedence")
+}
@@ -44,13 +44,18 @@ type dockerClient interface {
 	CreateContainer(dockerclient.CreateContainerOptions) (*dockerclient.Container, error)
 	CreateVolume(dockerclient.CreateVolumeOptions) (*dockerclient.Volume, error)
 	ImageHistory(string) ([]dockerclient.ImageHistory, error)
+	Info() (*dockerclient.DockerInfo, error)
 	InspectImage(string) (*dockerclient.Image, error)
+	ListContainers(dockerclient.ListContainersOptions) ([]dockerclient.APIContainers, error)
 	ListImages(dockerclient.ListImagesOptions) ([]dockerclient.APIImages, error)
 	ListVolumes(dockerclient.ListVolumesOptions) ([]dockerclient.Volume, error)
+	PullImage(dockerclient.PullImageOptions, dockerclient.AuthConfiguration) error
+	PushImage(dockerclient.PushImageOptions, dockerclient.AuthConfiguration) error
 	RemoveContainer(dockerclient.RemoveContainerOptions) error
 	RemoveImage(string) error
 	RemoveVolume(string) error
 	StartContainer(string, *dockerclient.HostConfig) error
+	TagImage(string, dockerclient.TagImageOptions) error
 	WaitContainer(string) (int, error)
 	UploadToContainer(string, dockerclient.UploadToContainerOptions) error
 	DownloadFromContainer(string, dockerclient.DownloadFromContainerOptions) error
@@ -65,7 +70,7 @@ type ImageManager struct {
 func NewImageManager() (*ImageManager, error) {
 	manager := &ImageManager{}
 
-	client, err := dockerclient.NewClientFromEnv()
+	client, err := backend.NewClient()
 	manager.client = client
 
 	if err != nil {
@@ -425,6 +430,21 @@ func (d *ImageManager) ListImages(options dockerclient.ListImagesOptions) ([]doc
 	return d.client.ListImages(options)
 }
 
+// ListContainers will return a list of containers matching the options
+func (d *ImageManager) ListContainers(options dockerclient.ListContainersOptions) ([]dockerclient.APIContainers, error) {
+	return d.client.ListContainers(options)
+}
+
+// ListVolumes will return a list of all volumes
+func (d *ImageManager) ListVolumes() ([]dockerclient.Volume, error) {
+	return d.client.ListVolumes(dockerclient.ListVolumesOptions{})
+}
+
+// RemoveVolume will remove a volume from Docker
+func (d *ImageManager) RemoveVolume(name string) error {
+	return d.client.RemoveVolume(name)
+}
+
 // CreateImage will create a Docker image
 func (d *ImageManager) CreateImage(containerID string, repository string, tag string, message string, cmd []string) (*dockerclient.Image, error) {
 	cco := dockerclient.CommitContainerOptions{
@@ -688,12 +708,12 @@ func (d *ImageManager) RunInContainer(opts RunInContainerOpts) (int, *dockerclie
 		return exitCode, container, nil
 	}
 	// KeepContainer mode:
-	// Run the cmd with 'docker exec ...' so we can keep the container around.
+	// Run the cmd with 'docker exec ...' (or 'podman exec ...') so we can keep the container around.
 	// Note that this time we'll need to stop it if it doesn't fail
 	cmdArgs := append([]string{"exec", "-i", container.ID}, actualCmd...)
 
 	// Couldn't get this to work with dockerclient.Exec, so do it this way
-	execCmd := exec.Command("docker", cmdArgs...)
+	execCmd := exec.Command(backend.Command(), cmdArgs...)
 	execCmd.Stdout = opts.StdoutWriter
 	execCmd.Stderr = opts.StderrWriter
 	err = execCmd.Run()
@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"fmt"
+	"runtime"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// Platform is the operating system and CPU architecture binaries are built
+// for, named like GOOS and GOARCH
+type Platform struct {
+	OS           string
+	Architecture string
+}
+
+// String returns the platform as <os>/<architecture>, e.g. linux/arm64
+func (p Platform) String() string {
+	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+}
+
+// HostPlatform returns the platform fissile runs on
+func HostPlatform() Platform {
+	return Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+}
+
+// unameArchitectures maps the architectures reported by the docker daemon,
+// as given by `uname -m`, to their GOARCH names
+var unameArchitectures = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"armv7l":  "arm",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+// normalizeArchitecture returns the GOARCH name of an architecture
+func normalizeArchitecture(architecture string) string {
+	if goarch, ok := unameArchitectures[architecture]; ok {
+		return goarch
+	}
+	return architecture
+}
+
+// ServerPlatform returns the platform of the containers run by the docker
+// daemon. It differs from the host platform when the daemon runs in a VM, as
+// on macOS.
+func (d *ImageManager) ServerPlatform() (Platform, error) {
+	info, err := d.client.Info()
+	if err != nil {
+		return Platform{}, fmt.Errorf("Error getting the docker daemon info: %s", err.Error())
+	}
+	return Platform{OS: info.OSType, Architecture: normalizeArchitecture(info.Architecture)}, nil
+}
+
+// ServerResources returns the CPUs and the memory in bytes of the docker
+// daemon's machine
+func (d *ImageManager) ServerResources() (int, uint64, error) {
+	info, err := d.client.Info()
+	if err != nil {
+		return 0, 0, fmt.Errorf("Error getting the docker daemon info: %s", err.Error())
+	}
+	return info.NCPU, uint64(info.MemTotal), nil
+}
+
+// ImagePlatform returns the platform of the binaries of an image; images
+// without an architecture are assumed to be for amd64
+func ImagePlatform(image *dockerclient.Image) Platform {
+	platform := Platform{OS: image.OS, Architecture: normalizeArchitecture(image.Architecture)}
+	if platform.OS == "" {
+		platform.OS = "linux"
+	}
+	if platform.Architecture == "" {
+		platform.Architecture = "amd64"
+	}
+	return platform
+}
+
+// ErrPlatformMismatch is the error returned when the stemcell is built for
+// another platform than the packages are compiled on. Compiling in docker may
+// still work with emulation, if the daemon supports it, albeit slowly.
+type ErrPlatformMismatch struct {
+	Stemcell string
+	Image    Platform
+	Compiler Platform
+}
+
+func (e ErrPlatformMismatch) Error() string {
+	return fmt.Sprintf("Stemcell %s is built for %s, but packages are compiled on %s; use a stemcell for %s",
+		e.Stemcell, e.Image, e.Compiler, e.Compiler)
+}
+
+// CheckStemcellPlatform checks that the stemcell image is built for the
+// platform packages are compiled on: the server platform when compiling in
+// docker, and the host platform otherwise
+func (d *ImageManager) CheckStemcellPlatform(stemcellImageName string, compiler Platform) error {
+	image, err := d.FindImage(stemcellImageName)
+	if err != nil {
+		return err
+	}
+	return checkStemcellPlatform(stemcellImageName, ImagePlatform(image), compiler)
+}
+
+func checkStemcellPlatform(stemcellImageName string, stemcell, compiler Platform) error {
+	if stemcell != compiler {
+		return ErrPlatformMismatch{Stemcell: stemcellImageName, Image: stemcell, Compiler: compiler}
+	}
+	return nil
+}
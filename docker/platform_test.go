@@ -0,0 +1,33 @@
+package docker
+
+import (
+	"testing"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImagePlatform(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, Platform{OS: "linux", Architecture: "arm64"},
+		ImagePlatform(&dockerclient.Image{OS: "linux", Architecture: "aarch64"}))
+	assert.Equal(t, Platform{OS: "linux", Architecture: "arm64"},
+		ImagePlatform(&dockerclient.Image{OS: "linux", Architecture: "arm64"}))
+	assert.Equal(t, Platform{OS: "linux", Architecture: "amd64"},
+		ImagePlatform(&dockerclient.Image{}))
+	assert.Equal(t, "linux/amd64", ImagePlatform(&dockerclient.Image{Architecture: "x86_64"}).String())
+}
+
+func TestCheckStemcellPlatform(t *testing.T) {
+	t.Parallel()
+
+	amd64 := Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := Platform{OS: "linux", Architecture: "arm64"}
+
+	assert.NoError(t, checkStemcellPlatform("stemcell", arm64, arm64))
+
+	err := checkStemcellPlatform("stemcell", amd64, arm64)
+	assert.IsType(t, ErrPlatformMismatch{}, err)
+	assert.EqualError(t, err, "Stemcell stemcell is built for linux/amd64, but packages are compiled on linux/arm64; use a stemcell for linux/arm64")
+}
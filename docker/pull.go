@@ -0,0 +1,35 @@
+package docker
+
+import (
+	"fmt"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// PullImage pulls an image from its registry
+func (d *ImageManager) PullImage(imageName string, auth dockerclient.AuthConfiguration) error {
+	repository, tag := splitImageName(imageName)
+	err := d.client.PullImage(dockerclient.PullImageOptions{
+		Repository: repository,
+		Tag:        tag,
+	}, auth)
+	if err != nil {
+		return fmt.Errorf("Error pulling image %s: %v", imageName, err)
+	}
+	return nil
+}
+
+// TagImage tags an existing image with another name, replacing any image of
+// that name
+func (d *ImageManager) TagImage(imageName, targetName string) error {
+	repository, tag := splitImageName(targetName)
+	err := d.client.TagImage(imageName, dockerclient.TagImageOptions{
+		Repo:  repository,
+		Tag:   tag,
+		Force: true,
+	})
+	if err != nil {
+		return fmt.Errorf("Error tagging image %s as %s: %v", imageName, targetName, err)
+	}
+	return nil
+}
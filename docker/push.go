@@ -0,0 +1,127 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+// PushProgress describes the progress of pushing a single image layer
+type PushProgress struct {
+	// Layer is the (short) ID of the layer
+	Layer string
+	// Status is the status reported by the docker daemon, e.g. "Pushing",
+	// "Pushed", or "Layer already exists"
+	Status string
+	// Current and Total are the number of bytes pushed so far, and the size
+	// of the layer; both are zero if the status does not have progress.
+	Current int64
+	Total   int64
+}
+
+// pushMessage is a single message of the JSON stream of a push
+type pushMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error string `json:"error"`
+	Aux   *struct {
+		Tag    string `json:"Tag"`
+		Digest string `json:"Digest"`
+	} `json:"aux"`
+}
+
+// pushStreamWriter decodes the JSON stream of a push, forwarding layer
+// progress and recording the pushed digest and any error.
+type pushStreamWriter struct {
+	progress  func(PushProgress)
+	remainder bytes.Buffer
+	digest    string
+	err       error
+	mutex     sync.Mutex
+}
+
+func (w *pushStreamWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// Messages are separated by newlines; keep any partial message until the
+	// rest of it has been written.
+	w.remainder.Write(data)
+	for {
+		line, err := w.remainder.ReadBytes('\n')
+		if err != nil {
+			w.remainder.Write(line)
+			break
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var message pushMessage
+		if err := json.Unmarshal(line, &message); err != nil {
+			return len(data), fmt.Errorf("Error decoding push output %q: %v", line, err)
+		}
+		w.handle(message)
+	}
+	return len(data), nil
+}
+
+func (w *pushStreamWriter) handle(message pushMessage) {
+	switch {
+	case message.Error != "":
+		if w.err == nil {
+			w.err = fmt.Errorf("%s", message.Error)
+		}
+	case message.Aux != nil:
+		w.digest = message.Aux.Digest
+	case message.ID != "" && w.progress != nil:
+		w.progress(PushProgress{
+			Layer:   message.ID,
+			Status:  message.Status,
+			Current: message.ProgressDetail.Current,
+			Total:   message.ProgressDetail.Total,
+		})
+	}
+}
+
+// splitImageName splits an image name into the repository and the tag,
+// keeping any registry port with the repository.
+func splitImageName(imageName string) (string, string) {
+	colon := strings.LastIndex(imageName, ":")
+	if colon == -1 || strings.Contains(imageName[colon:], "/") {
+		return imageName, "latest"
+	}
+	return imageName[:colon], imageName[colon+1:]
+}
+
+// PushImage pushes an image to its registry, reporting the progress of each
+// layer to the progress function (which may be nil). Layers that already
+// exist in the registry are skipped by the docker daemon, so retrying a
+// failed push only uploads the layers that have not been pushed yet. It
+// returns the digest of the pushed image.
+func (d *ImageManager) PushImage(imageName string, auth dockerclient.AuthConfiguration, progress func(PushProgress)) (string, error) {
+	repository, tag := splitImageName(imageName)
+	stream := &pushStreamWriter{progress: progress}
+
+	err := d.client.PushImage(dockerclient.PushImageOptions{
+		Name:          repository,
+		Tag:           tag,
+		OutputStream:  stream,
+		RawJSONStream: true,
+	}, auth)
+	if err != nil {
+		return "", fmt.Errorf("Error pushing image %s: %v", imageName, err)
+	}
+	if stream.err != nil {
+		return "", fmt.Errorf("Error pushing image %s: %v", imageName, stream.err)
+	}
+	return stream.digest, nil
+}
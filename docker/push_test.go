@@ -0,0 +1,50 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushStreamWriter(t *testing.T) {
+	t.Parallel()
+
+	var progress []PushProgress
+	writer := &pushStreamWriter{progress: func(p PushProgress) { progress = append(progress, p) }}
+
+	stream := `{"status":"The push refers to repository [docker.io/org/nats]"}` + "\r\n" +
+		`{"status":"Pushing","progressDetail":{"current":512,"total":1024},"id":"abc"}` + "\r\n" +
+		`{"status":"Layer already exists","progressDetail":{},"id":"def"}` + "\r\n" +
+		`{"progressDetail":{},"aux":{"Tag":"1.0","Digest":"sha256:1234","Size":1234}}` + "\r\n"
+
+	// Split the stream in the middle of a message
+	_, err := writer.Write([]byte(stream[:100]))
+	assert.NoError(t, err)
+	_, err = writer.Write([]byte(stream[100:]))
+	assert.NoError(t, err)
+
+	assert.NoError(t, writer.err)
+	assert.Equal(t, "sha256:1234", writer.digest)
+	assert.Equal(t, []PushProgress{
+		{Layer: "abc", Status: "Pushing", Current: 512, Total: 1024},
+		{Layer: "def", Status: "Layer already exists"},
+	}, progress)
+
+	_, err = writer.Write([]byte(`{"errorDetail":{"message":"denied"},"error":"denied"}` + "\n"))
+	assert.NoError(t, err)
+	assert.EqualError(t, writer.err, "denied")
+}
+
+func TestSplitImageName(t *testing.T) {
+	t.Parallel()
+
+	for imageName, expected := range map[string][2]string{
+		"org/nats:1.0":               {"org/nats", "1.0"},
+		"registry:5000/org/nats:1.0": {"registry:5000/org/nats", "1.0"},
+		"registry:5000/org/nats":     {"registry:5000/org/nats", "latest"},
+		"nats":                       {"nats", "latest"},
+	} {
+		repository, tag := splitImageName(imageName)
+		assert.Equal(t, expected, [2]string{repository, tag}, imageName)
+	}
+}
@@ -33,6 +33,12 @@ options, like the max line length for comments, or the YAML indentation level:
 
   NewEncoder(os.Stdout, Indent(4), Wrap(80)).Encode(documentRoot)
 
+Several documents can be written into a single file with EncodeAll; the block
+action of each document root then guards the complete document, including its
+separator:
+
+  NewEncoder(os.Stdout).EncodeAll(service, statefulSet)
+
 Tricks:
 
 * Throw an error if the the configuration cannot possibly work
@@ -472,6 +478,44 @@ func (enc *Encoder) Encode(node Node) error {
 	return enc.err
 }
 
+// EncodeAll writes each node as a separate document to the stream, like
+// encodeDocument.
+func (enc *Encoder) EncodeAll(nodes ...Node) error {
+	for _, node := range nodes {
+		enc.encodeDocument(node)
+	}
+	return enc.err
+}
+
+// encodeDocument writes the node as a separate document to the stream. The
+// block action of a document root guards the whole document, including its
+// separator and comment, so that documents whose condition is not met leave
+// no empty document behind:
+//
+//   {{- if .Values.enabled }}
+//   ---
+//   # A comment
+//   kind: ConfigMap
+//   {{- end }}
+func (enc *Encoder) encodeDocument(node Node) error {
+	block := node.Block()
+	if block == "" {
+		return enc.Encode(node)
+	}
+	enc.pendingNewline = false
+	prefix := ""
+	fmt.Fprintf(enc, "{{- %s }}\n", block)
+	if enc.separator {
+		fmt.Fprintln(enc, "---")
+	}
+	if comment := node.Comment(); comment != "" {
+		enc.writeComment(&prefix, comment)
+	}
+	node.write(enc, prefix)
+	fmt.Fprintln(enc, "{{- end }}")
+	return enc.err
+}
+
 // Write implements the io.Writer interface. It just forwards to the embedded
 // writer until an error occurs. This allows for error checking just once at the
 // end of Encode().
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"text/template"
 
 	"github.com/stretchr/testify/assert"
 	yaml "gopkg.in/yaml.v2"
@@ -689,6 +690,66 @@ Mapping:
 	assert.Equal(t, expect, buffer.String())
 }
 
+func TestHelmEncodeAll(t *testing.T) {
+	plain := NewMapping("kind", "Secret")
+	guarded := NewMapping("kind", "ConfigMap")
+	guarded.Set(Block("if .first"), Comment("A comment"))
+	other := NewMapping("kind", "Service")
+	other.Set(Block("if .second"))
+
+	expect := `---
+kind: "Secret"
+{{- if .first }}
+---
+# A comment
+kind: "ConfigMap"
+{{- end }}
+{{- if .second }}
+---
+kind: "Service"
+{{- end }}
+`
+	buffer := &bytes.Buffer{}
+	enc := NewEncoder(buffer, EmptyLines(false))
+	assert.NoError(t, enc.EncodeAll(plain, guarded, other))
+	assert.Equal(t, expect, buffer.String())
+
+	// Documents whose condition is not met must not leave empty documents
+	tmpl, err := template.New("").Parse(buffer.String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, sample := range []struct {
+		values map[string]bool
+		expect string
+	}{
+		{map[string]bool{"first": true, "second": true}, "---\nkind: \"Secret\"\n---\n# A comment\nkind: \"ConfigMap\"\n---\nkind: \"Service\"\n"},
+		{map[string]bool{"first": false, "second": true}, "---\nkind: \"Secret\"\n---\nkind: \"Service\"\n"},
+		{map[string]bool{"first": false, "second": false}, "---\nkind: \"Secret\"\n"},
+	} {
+		rendered := &bytes.Buffer{}
+		if assert.NoError(t, tmpl.Execute(rendered, sample.values)) {
+			assert.Equal(t, sample.expect, rendered.String(), "%v", sample.values)
+		}
+	}
+}
+
+func TestHelmEncodeDocument(t *testing.T) {
+	plain := NewMapping("kind", "Secret")
+	guarded := NewMapping("kind", "ConfigMap")
+	guarded.Set(Block("if .first"), Comment("A comment"))
+
+	// Streaming the documents one at a time matches encoding them together
+	all := &bytes.Buffer{}
+	assert.NoError(t, NewEncoder(all).EncodeAll(plain, guarded))
+	streamed := &bytes.Buffer{}
+	enc := NewEncoder(streamed)
+	for _, node := range []Node{plain, guarded} {
+		assert.NoError(t, enc.encodeDocument(node))
+	}
+	assert.Equal(t, all.String(), streamed.String())
+}
+
 func TestHelmMultiLineScalar(t *testing.T) {
 	root := NewMapping("Scalar", "foo\nbar\nbaz")
 	list := NewList("one\ntwo\nthree")
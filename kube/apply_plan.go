@@ -0,0 +1,292 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// Files of the apply plan, below the output directory
+const (
+	ApplyPlanFile   = "apply-plan.yaml"
+	ApplyScriptFile = "apply.sh"
+)
+
+// Conditions the apply plan waits for after applying a workload
+const (
+	WaitStatefulSetReady    = "statefulset ready"
+	WaitDeploymentAvailable = "deployment available"
+	WaitJobComplete         = "job complete"
+	WaitPodSucceeded        = "pod succeeded"
+)
+
+const (
+	// applyPlanPreFlightRank ranks the pre-flight tasks after the secrets,
+	// but before all other workloads
+	applyPlanPreFlightRank  = streamWorkloadOrder - 1
+	applyPlanDefaultTimeout = "600s"
+)
+
+// ApplyPlan lists the generated files of plain Kubernetes configs in the
+// order they have to be applied in, with the conditions to wait for after
+// each of them, so that installs without helm don't race their dependencies.
+type ApplyPlan struct {
+	Steps []ApplyPlanStep `yaml:"steps"`
+}
+
+// ApplyPlanStep applies a generated file, and waits for its workloads
+type ApplyPlanStep struct {
+	// File is relative to the output directory
+	File string `yaml:"file"`
+	// Resources are the kind/name of the resources of the file
+	Resources []string        `yaml:"resources"`
+	Wait      []ApplyPlanWait `yaml:"wait,omitempty"`
+	rank      int
+	// provides are the instance groups of the workloads of the step,
+	// including their colocated containers, and consumes the instance
+	// groups they resolved links to
+	provides []string
+	consumes []string
+}
+
+// ApplyPlanWait is a condition of a workload to wait for
+type ApplyPlanWait struct {
+	// Resource is the kind/name of the workload, as understood by kubectl
+	Resource  string `yaml:"resource"`
+	Condition string `yaml:"condition"`
+}
+
+// NewApplyPlanStep returns the step of the apply plan applying the generated
+// file holding the nodes. The step is ranked like the resources of a stream,
+// by its last resource; jobs of pre-flight tasks are ranked before the other
+// workloads. Files of manual tasks aren't applied, and so have no step.
+func NewApplyPlanStep(file string, roleManifest *model.RoleManifest, nodes ...helm.Node) (ApplyPlanStep, bool) {
+	step := ApplyPlanStep{File: file}
+	for _, node := range applyPlanResources(nodes) {
+		kind := NodeKind(node)
+		var name string
+		if scalar, ok := node.Get("metadata", "name").(*helm.Scalar); ok {
+			name = scalar.String()
+		}
+		step.Resources = append(step.Resources, fmt.Sprintf("%s/%s", kind, name))
+
+		rank := streamRank(kind)
+		resource := fmt.Sprintf("%s/%s", strings.ToLower(kind), name)
+		switch kind {
+		case "StatefulSet":
+			step.addLinks(roleManifest, name)
+			step.Wait = append(step.Wait, ApplyPlanWait{Resource: resource, Condition: WaitStatefulSetReady})
+		case "Deployment":
+			step.addLinks(roleManifest, name)
+			step.Wait = append(step.Wait, ApplyPlanWait{Resource: resource, Condition: WaitDeploymentAvailable})
+		case "Job", "Pod":
+			instanceGroup := step.addLinks(roleManifest, name)
+			if instanceGroup != nil && instanceGroup.Run != nil {
+				switch instanceGroup.Run.FlightStage {
+				case model.FlightStageManual:
+					return step, false
+				case model.FlightStagePreFlight:
+					rank = applyPlanPreFlightRank
+				}
+			}
+			condition := WaitJobComplete
+			if kind == "Pod" {
+				condition = WaitPodSucceeded
+			}
+			step.Wait = append(step.Wait, ApplyPlanWait{Resource: resource, Condition: condition})
+		}
+		if rank > step.rank {
+			step.rank = rank
+		}
+	}
+	return step, len(step.Resources) > 0
+}
+
+// addLinks records the instance group of the workload as provided by the step,
+// along with its colocated containers, and the instance groups it consumes
+// links of. It returns the instance group, if any.
+func (step *ApplyPlanStep) addLinks(roleManifest *model.RoleManifest, name string) *model.InstanceGroup {
+	if roleManifest == nil {
+		return nil
+	}
+	instanceGroup := roleManifest.LookupInstanceGroup(name)
+	if instanceGroup == nil {
+		return nil
+	}
+	for _, candidate := range append(model.InstanceGroups{instanceGroup}, instanceGroup.GetColocatedRoles()...) {
+		step.provides = append(step.provides, candidate.Name)
+		for _, jobReference := range candidate.JobReferences {
+			for _, consumes := range jobReference.ResolvedConsumes {
+				step.consumes = append(step.consumes, consumes.RoleName)
+			}
+		}
+	}
+	sort.Strings(step.consumes)
+	return instanceGroup
+}
+
+// applyPlanResources returns the resources of the nodes, with the items of
+// lists in their place
+func applyPlanResources(nodes []helm.Node) []helm.Node {
+	var resources []helm.Node
+	for _, node := range nodes {
+		if kind, ok := node.Get("kind").(*helm.Scalar); ok && kind.String() == "List" {
+			if items, ok := node.Get("items").(*helm.List); ok {
+				resources = append(resources, applyPlanResources(items.Values())...)
+			}
+			continue
+		}
+		resources = append(resources, node)
+	}
+	return resources
+}
+
+// MakeApplyPlan returns the apply plan of the steps, in dependency order:
+// namespaces, RBAC resources, secrets, pre-flight tasks, workloads and finally
+// the remaining tasks. Workloads consuming links come after the workloads
+// providing them; otherwise steps of the same rank keep the order they were
+// generated in. Where links are cyclic, the consumer is only waited for once
+// the provider has been applied as well.
+func MakeApplyPlan(steps []ApplyPlanStep) *ApplyPlan {
+	sorted := append([]ApplyPlanStep{}, steps...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].rank < sorted[j].rank
+	})
+
+	providers := map[string]int{}
+	for i, step := range sorted {
+		for _, name := range step.provides {
+			providers[name] = i
+		}
+	}
+
+	// Depth-first search for the providers of each step; a provider still
+	// being visited closes a cycle, and the waits of the consumer are
+	// deferred until after that provider
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(sorted))
+	deferredTo := make([][]int, len(sorted))
+	var order []int
+	var visit func(i int)
+	visit = func(i int) {
+		if state[i] != unvisited {
+			return
+		}
+		state[i] = visiting
+		for _, name := range sorted[i].consumes {
+			provider, ok := providers[name]
+			if !ok || provider == i {
+				continue
+			}
+			if state[provider] == visiting {
+				deferredTo[i] = append(deferredTo[i], provider)
+				continue
+			}
+			visit(provider)
+		}
+		state[i] = visited
+		order = append(order, i)
+	}
+	for i := range sorted {
+		visit(i)
+	}
+
+	position := make([]int, len(sorted))
+	for pos, i := range order {
+		position[i] = pos
+	}
+	// The waits of each step end up after the last step they are deferred
+	// to, directly or via the steps those are deferred to
+	waitAt := make([]int, len(sorted))
+	copy(waitAt, position)
+	for changed := true; changed; {
+		changed = false
+		for i := range sorted {
+			for _, provider := range deferredTo[i] {
+				if waitAt[provider] > waitAt[i] {
+					waitAt[i] = waitAt[provider]
+					changed = true
+				}
+			}
+		}
+	}
+
+	plan := &ApplyPlan{}
+	for _, i := range order {
+		step := sorted[i]
+		step.Wait = nil
+		plan.Steps = append(plan.Steps, step)
+	}
+	for _, i := range order {
+		target := &plan.Steps[waitAt[i]]
+		target.Wait = append(target.Wait, sorted[i].Wait...)
+	}
+	return plan
+}
+
+// applyScriptWaitForPod is the shell function of the apply script waiting
+// for a pod to succeed; pods have no condition for that, so their phase is
+// polled until the timeout
+const applyScriptWaitForPod = `wait_for_pod_success() {
+  end=$(( $(date +%s) + ${TIMEOUT%s} ))
+  while true; do
+    phase="$(kubectl get --namespace "${NAMESPACE}" "$1" --output "jsonpath={.status.phase}")"
+    case "${phase}" in
+      Succeeded) return 0 ;;
+      Failed) echo "$1 failed" >&2; return 1 ;;
+    esac
+    if [ "$(date +%s)" -ge "${end}" ]; then
+      echo "Timed out waiting for $1 to succeed" >&2
+      return 1
+    fi
+    sleep 5
+  done
+}
+`
+
+// MakeApplyScript returns a shell script executing the apply plan with
+// kubectl. The namespace defaults to the name of the output directory and
+// the timeout of each wait to 600s; they can be overridden via the NAMESPACE
+// and TIMEOUT environment variables.
+func MakeApplyScript(plan *ApplyPlan, settings ExportSettings) (string, error) {
+	name, err := releaseName(settings)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "#!/bin/sh")
+	fmt.Fprintf(&buf, "# Applies the configuration files in the order of %s, waiting for\n", ApplyPlanFile)
+	fmt.Fprintln(&buf, "# the workloads of each before the next. Set NAMESPACE, and TIMEOUT in seconds")
+	fmt.Fprintln(&buf, "# (like 600s), to override the defaults.")
+	fmt.Fprintln(&buf, "set -o errexit -o nounset")
+	fmt.Fprintf(&buf, "NAMESPACE=\"${NAMESPACE:-%s}\"\n", name)
+	fmt.Fprintf(&buf, "TIMEOUT=\"${TIMEOUT:-%s}\"\n", applyPlanDefaultTimeout)
+	fmt.Fprintln(&buf, `dir="$(cd "$(dirname "$0")" && pwd)"`)
+	buf.WriteString(applyScriptWaitForPod)
+
+	for _, step := range plan.Steps {
+		fmt.Fprintf(&buf, "kubectl apply --namespace \"${NAMESPACE}\" --filename \"${dir}/%s\"\n", step.File)
+		for _, wait := range step.Wait {
+			switch wait.Condition {
+			case WaitStatefulSetReady, WaitDeploymentAvailable:
+				fmt.Fprintf(&buf, "kubectl rollout status --namespace \"${NAMESPACE}\" --timeout \"${TIMEOUT}\" %s\n", wait.Resource)
+			case WaitJobComplete:
+				fmt.Fprintf(&buf, "kubectl wait --namespace \"${NAMESPACE}\" --timeout \"${TIMEOUT}\" --for condition=complete %s\n", wait.Resource)
+			case WaitPodSucceeded:
+				fmt.Fprintf(&buf, "wait_for_pod_success %s\n", wait.Resource)
+			default:
+				return "", fmt.Errorf("Unknown wait condition %s of %s", wait.Condition, wait.Resource)
+			}
+		}
+	}
+	return buf.String(), nil
+}
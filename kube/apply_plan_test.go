@@ -0,0 +1,181 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newApplyPlanTestResource(kind, name string) *helm.Mapping {
+	resource := newTypeMeta("v1", kind)
+	resource.Add("metadata", helm.NewMapping("name", name))
+	return resource
+}
+
+func TestNewApplyPlanStep(t *testing.T) {
+	t.Parallel()
+
+	roleManifest := &model.RoleManifest{
+		InstanceGroups: model.InstanceGroups{
+			{Name: "pre", Run: &model.RoleRun{FlightStage: model.FlightStagePreFlight}},
+			{Name: "post", Run: &model.RoleRun{FlightStage: model.FlightStagePostFlight}},
+			{Name: "errand", Run: &model.RoleRun{FlightStage: model.FlightStageManual}},
+		},
+	}
+
+	t.Run("Workloads", func(t *testing.T) {
+		t.Parallel()
+		services := newTypeMeta("v1", "List")
+		services.Add("items", helm.NewList(newApplyPlanTestResource("Service", "db-set")))
+		step, ok := NewApplyPlanStep("bosh/db.yaml", roleManifest,
+			newApplyPlanTestResource("StatefulSet", "db"), services)
+		require.True(t, ok)
+		assert.Equal(t, "bosh/db.yaml", step.File)
+		assert.Equal(t, []string{"StatefulSet/db", "Service/db-set"}, step.Resources)
+		assert.Equal(t, []ApplyPlanWait{{Resource: "statefulset/db", Condition: WaitStatefulSetReady}}, step.Wait)
+		assert.Equal(t, streamWorkloadOrder, step.rank)
+	})
+
+	t.Run("Tasks", func(t *testing.T) {
+		t.Parallel()
+		step, ok := NewApplyPlanStep("bosh-task/pre.yaml", roleManifest, newApplyPlanTestResource("Job", "pre"))
+		require.True(t, ok)
+		assert.Equal(t, []ApplyPlanWait{{Resource: "job/pre", Condition: WaitJobComplete}}, step.Wait)
+		assert.Equal(t, applyPlanPreFlightRank, step.rank)
+
+		step, ok = NewApplyPlanStep("bosh-task/post.yaml", roleManifest, newApplyPlanTestResource("Pod", "post"))
+		require.True(t, ok)
+		assert.Equal(t, []ApplyPlanWait{{Resource: "pod/post", Condition: WaitPodSucceeded}}, step.Wait)
+		assert.Equal(t, streamRank("Pod"), step.rank)
+
+		_, ok = NewApplyPlanStep("bosh-task/errand.yaml", roleManifest, newApplyPlanTestResource("Job", "errand"))
+		assert.False(t, ok, "Manual tasks should not be applied")
+	})
+
+	t.Run("Links", func(t *testing.T) {
+		t.Parallel()
+		linked := &model.RoleManifest{
+			InstanceGroups: model.InstanceGroups{
+				{Name: "db"},
+				{Name: "api", JobReferences: model.JobReferences{{
+					Name: "api",
+					ResolvedConsumes: map[string]model.JobConsumesInfo{
+						"database": {JobLinkInfo: model.JobLinkInfo{RoleName: "db"}},
+					},
+				}}},
+			},
+		}
+		step, ok := NewApplyPlanStep("bosh/api.yaml", linked, newApplyPlanTestResource("StatefulSet", "api"))
+		require.True(t, ok)
+		assert.Equal(t, []string{"api"}, step.provides)
+		assert.Equal(t, []string{"db"}, step.consumes)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+		_, ok := NewApplyPlanStep("empty.yaml", roleManifest)
+		assert.False(t, ok)
+	})
+}
+
+func TestMakeApplyPlan(t *testing.T) {
+	t.Parallel()
+
+	plan := MakeApplyPlan([]ApplyPlanStep{
+		{File: "bosh-task/post.yaml", rank: streamRank("Job")},
+		{File: "bosh/first.yaml", rank: streamWorkloadOrder},
+		{File: "bosh-task/pre.yaml", rank: applyPlanPreFlightRank},
+		{File: "bosh/second.yaml", rank: streamWorkloadOrder},
+		{File: "secrets/secrets.yaml", rank: streamRank("Secret")},
+		{File: "auth/account-default.yaml", rank: streamRank("ServiceAccount")},
+	})
+
+	var files []string
+	for _, step := range plan.Steps {
+		files = append(files, step.File)
+	}
+	assert.Equal(t, []string{
+		"auth/account-default.yaml",
+		"secrets/secrets.yaml",
+		"bosh-task/pre.yaml",
+		"bosh/first.yaml",
+		"bosh/second.yaml",
+		"bosh-task/post.yaml",
+	}, files)
+}
+
+func TestMakeApplyPlanLinks(t *testing.T) {
+	t.Parallel()
+
+	wait := func(name string) []ApplyPlanWait {
+		return []ApplyPlanWait{{Resource: "statefulset/" + name, Condition: WaitStatefulSetReady}}
+	}
+	files := func(plan *ApplyPlan) []string {
+		var files []string
+		for _, step := range plan.Steps {
+			files = append(files, step.File)
+		}
+		return files
+	}
+
+	t.Run("Ordered", func(t *testing.T) {
+		t.Parallel()
+		plan := MakeApplyPlan([]ApplyPlanStep{
+			{File: "bosh/api.yaml", rank: streamWorkloadOrder, Wait: wait("api"), provides: []string{"api"}, consumes: []string{"db", "nats"}},
+			{File: "bosh/nats.yaml", rank: streamWorkloadOrder, Wait: wait("nats"), provides: []string{"nats"}},
+			{File: "bosh/db.yaml", rank: streamWorkloadOrder, Wait: wait("db"), provides: []string{"db"}, consumes: []string{"db"}},
+			{File: "secrets/secrets.yaml", rank: streamRank("Secret")},
+		})
+		assert.Equal(t, []string{
+			"secrets/secrets.yaml",
+			"bosh/db.yaml",
+			"bosh/nats.yaml",
+			"bosh/api.yaml",
+		}, files(plan))
+		assert.Equal(t, wait("api"), plan.Steps[3].Wait)
+	})
+
+	t.Run("Cyclic", func(t *testing.T) {
+		t.Parallel()
+		plan := MakeApplyPlan([]ApplyPlanStep{
+			{File: "bosh/a.yaml", rank: streamWorkloadOrder, Wait: wait("a"), provides: []string{"a"}, consumes: []string{"b"}},
+			{File: "bosh/b.yaml", rank: streamWorkloadOrder, Wait: wait("b"), provides: []string{"b"}, consumes: []string{"a"}},
+			{File: "bosh/c.yaml", rank: streamWorkloadOrder, Wait: wait("c"), provides: []string{"c"}, consumes: []string{"a"}},
+		})
+		assert.Equal(t, []string{"bosh/b.yaml", "bosh/a.yaml", "bosh/c.yaml"}, files(plan))
+		assert.Empty(t, plan.Steps[0].Wait, "b should not be waited for before a is applied")
+		assert.Equal(t, append(wait("b"), wait("a")...), plan.Steps[1].Wait)
+		assert.Equal(t, wait("c"), plan.Steps[2].Wait)
+	})
+}
+
+func TestMakeApplyScript(t *testing.T) {
+	t.Parallel()
+
+	plan := &ApplyPlan{Steps: []ApplyPlanStep{
+		{File: "secrets/secrets.yaml"},
+		{File: "bosh/db.yaml", Wait: []ApplyPlanWait{{Resource: "statefulset/db", Condition: WaitStatefulSetReady}}},
+		{File: "bosh-task/migrate.yaml", Wait: []ApplyPlanWait{{Resource: "job/migrate", Condition: WaitJobComplete}}},
+		{File: "bosh-task/check.yaml", Wait: []ApplyPlanWait{{Resource: "pod/check", Condition: WaitPodSucceeded}}},
+	}}
+
+	script, err := MakeApplyScript(plan, ExportSettings{OutputDir: "/tmp/my-release"})
+	require.NoError(t, err)
+	assert.Contains(t, script, `NAMESPACE="${NAMESPACE:-my-release}"`)
+	assert.Contains(t, script, `TIMEOUT="${TIMEOUT:-600s}"`)
+	assert.Contains(t, script, `kubectl apply --namespace "${NAMESPACE}" --filename "${dir}/secrets/secrets.yaml"
+kubectl apply --namespace "${NAMESPACE}" --filename "${dir}/bosh/db.yaml"
+kubectl rollout status --namespace "${NAMESPACE}" --timeout "${TIMEOUT}" statefulset/db
+kubectl apply --namespace "${NAMESPACE}" --filename "${dir}/bosh-task/migrate.yaml"
+kubectl wait --namespace "${NAMESPACE}" --timeout "${TIMEOUT}" --for condition=complete job/migrate
+kubectl apply --namespace "${NAMESPACE}" --filename "${dir}/bosh-task/check.yaml"
+wait_for_pod_success pod/check
+`)
+
+	plan.Steps[0].Wait = []ApplyPlanWait{{Resource: "secret/foo", Condition: "secret sealed"}}
+	_, err = MakeApplyScript(plan, ExportSettings{OutputDir: "/tmp/my-release"})
+	assert.EqualError(t, err, "Unknown wait condition secret sealed of secret/foo")
+}
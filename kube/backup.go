@@ -0,0 +1,100 @@
+package kube
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// Annotations of the pods configuring their Velero backups; see
+// https://velero.io/docs/main/backup-hooks/ and
+// https://velero.io/docs/main/file-system-backup/
+const (
+	veleroBackupVolumesAnnotation = "backup.velero.io/backup-volumes"
+	veleroPreHookAnnotation       = "pre.hook.backup.velero.io"
+	veleroPostHookAnnotation      = "post.hook.backup.velero.io"
+)
+
+// addBackupAnnotations adds the Velero annotations of the backup of the
+// instance group to the annotations of its pods: the volumes to include in
+// file system backups, and the hooks running the BBR scripts of its jobs.
+func addBackupAnnotations(annotations *helm.Mapping, role *model.InstanceGroup) error {
+	backup := role.Run.Backup
+	if backup == nil {
+		return nil
+	}
+
+	volumes := backup.Volumes
+	if len(volumes) == 0 {
+		for _, volume := range role.Run.Volumes {
+			if volume.Type == model.VolumeTypePersistent {
+				volumes = append(volumes, volume.Tag)
+			}
+		}
+	}
+	if len(volumes) > 0 {
+		annotations.Add(veleroBackupVolumesAnnotation, strings.Join(volumes, ","))
+	}
+
+	if len(backup.Jobs) == 0 {
+		return nil
+	}
+
+	// BBR locks all jobs before backing any of them up, and unlocks them in
+	// reverse order afterwards
+	var pre, post []string
+	for _, job := range backup.Jobs {
+		pre = append(pre, bbrScript(job, "pre-backup-lock", ""))
+	}
+	if backup.ArtifactPath != "" {
+		for _, job := range backup.Jobs {
+			pre = append(pre, bbrScript(job, "backup", fmt.Sprintf("%s/%s", strings.TrimRight(backup.ArtifactPath, "/"), job)))
+		}
+	}
+	for index := len(backup.Jobs) - 1; index >= 0; index-- {
+		post = append(post, bbrScript(backup.Jobs[index], "post-backup-unlock", ""))
+	}
+
+	for _, hook := range []struct {
+		prefix  string
+		scripts []string
+	}{
+		{veleroPreHookAnnotation, pre},
+		{veleroPostHookAnnotation, post},
+	} {
+		// Velero reads the command as a JSON list; keep the shell operators
+		// readable instead of escaping them for HTML
+		var command bytes.Buffer
+		encoder := json.NewEncoder(&command)
+		encoder.SetEscapeHTML(false)
+		err := encoder.Encode([]string{"/bin/sh", "-c", strings.Join(append([]string{"set -e"}, hook.scripts...), "\n")})
+		if err != nil {
+			return err
+		}
+		annotations.Add(hook.prefix+"/container", role.Name)
+		annotations.Add(hook.prefix+"/command", strings.TrimSpace(command.String()))
+		if backup.OnError != "" {
+			annotations.Add(hook.prefix+"/on-error", backup.OnError)
+		}
+		if backup.Timeout != "" {
+			annotations.Add(hook.prefix+"/timeout", backup.Timeout)
+		}
+	}
+
+	return nil
+}
+
+// bbrScript returns the shell command running the BBR script of a job, if
+// the job has it; backup scripts are given the directory of their artifacts.
+func bbrScript(job, script, artifactDirectory string) string {
+	path := fmt.Sprintf("/var/vcap/jobs/%s/bin/bbr/%s", job, script)
+	if artifactDirectory == "" {
+		return fmt.Sprintf("[ ! -x %s ] || %s", path, path)
+	}
+	return fmt.Sprintf("[ ! -x %s ] || { mkdir -p %s && BBR_ARTIFACT_DIRECTORY=%s/ %s; }",
+		path, artifactDirectory, artifactDirectory, path)
+}
@@ -0,0 +1,59 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPodBackupAnnotations(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Volumes", func(t *testing.T) {
+		t.Parallel()
+		role := podTemplateTestLoadRole(assert.New(t))
+		require.NotNil(t, role)
+		role.Run.Backup = &model.RoleRunBackup{}
+
+		podTemplate, err := NewPodTemplate(role, ExportSettings{}, nil)
+		require.NoError(t, err)
+		actual, err := RoundtripKube(podTemplate.Get("metadata", "annotations"))
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			backup.velero.io/backup-volumes: persistent-volume
+		`, actual)
+	})
+
+	t.Run("Hooks", func(t *testing.T) {
+		t.Parallel()
+		role := podTemplateTestLoadRole(assert.New(t))
+		require.NotNil(t, role)
+		role.Run.Backup = &model.RoleRunBackup{
+			Volumes:      []string{"persistent-volume", "shared-volume"},
+			Jobs:         []string{"tor", "new_hostname"},
+			ArtifactPath: "/mnt/persistent/bbr/",
+			Timeout:      "5m",
+			OnError:      model.BackupOnErrorFail,
+		}
+
+		podTemplate, err := NewPodTemplate(role, ExportSettings{CreateHelmChart: true}, nil)
+		require.NoError(t, err)
+		actual, err := RoundtripNode(podTemplate.Get("metadata", "annotations"), nil)
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			backup.velero.io/backup-volumes: persistent-volume,shared-volume
+			pre.hook.backup.velero.io/container: myrole
+			pre.hook.backup.velero.io/command: '["/bin/sh","-c","set -e\n[ ! -x /var/vcap/jobs/tor/bin/bbr/pre-backup-lock ] || /var/vcap/jobs/tor/bin/bbr/pre-backup-lock\n[ ! -x /var/vcap/jobs/new_hostname/bin/bbr/pre-backup-lock ] || /var/vcap/jobs/new_hostname/bin/bbr/pre-backup-lock\n[ ! -x /var/vcap/jobs/tor/bin/bbr/backup ] || { mkdir -p /mnt/persistent/bbr/tor && BBR_ARTIFACT_DIRECTORY=/mnt/persistent/bbr/tor/ /var/vcap/jobs/tor/bin/bbr/backup; }\n[ ! -x /var/vcap/jobs/new_hostname/bin/bbr/backup ] || { mkdir -p /mnt/persistent/bbr/new_hostname && BBR_ARTIFACT_DIRECTORY=/mnt/persistent/bbr/new_hostname/ /var/vcap/jobs/new_hostname/bin/bbr/backup; }"]'
+			pre.hook.backup.velero.io/on-error: Fail
+			pre.hook.backup.velero.io/timeout: 5m
+			post.hook.backup.velero.io/container: myrole
+			post.hook.backup.velero.io/command: '["/bin/sh","-c","set -e\n[ ! -x /var/vcap/jobs/new_hostname/bin/bbr/post-backup-unlock ] || /var/vcap/jobs/new_hostname/bin/bbr/post-backup-unlock\n[ ! -x /var/vcap/jobs/tor/bin/bbr/post-backup-unlock ] || /var/vcap/jobs/tor/bin/bbr/post-backup-unlock"]'
+			post.hook.backup.velero.io/on-error: Fail
+			post.hook.backup.velero.io/timeout: 5m
+		`, actual)
+		assert.Contains(t, actual, "checksum/config", "The backup annotations should be added to the helm annotations")
+	})
+}
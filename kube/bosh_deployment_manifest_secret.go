@@ -2,22 +2,35 @@ package kube
 
 import (
 	"fmt"
+	"strings"
 
 	"code.cloudfoundry.org/fissile/helm"
 )
 
+// deploymentManifestName is the name of the secret holding the deployment
+// manifest
+const deploymentManifestName = "deployment-manifest"
+
 // MakeBoshDeploymentManifestSecret generates a template for a secret that holds the content of a BOSH deployment manifest
 func MakeBoshDeploymentManifestSecret(settings ExportSettings) (helm.Node, error) {
 	value := ""
 	if settings.CreateHelmChart {
 		value = "{{ .Values.bosh | toYaml | b64enc }}"
+		if instanceGroups := propertiesInstanceGroups(settings); instanceGroups != "" {
+			// Job properties from .Values.properties are passed on to configgin as the
+			// instance_groups of the deployment manifest.
+			fail := `{{ fail "bosh.instance_groups cannot be used together with properties" }}`
+			value = fmt.Sprintf(`{{ if .Values.properties }}{{ if .Values.bosh.instance_groups }}%s{{ end }}`+
+				`{{ merge (dict "instance_groups" %s) .Values.bosh | toYaml | b64enc }}{{ else }}%s{{ end }}`,
+				fail, instanceGroups, value)
+		}
 	}
 
 	cb := NewConfigBuilder().
 		SetSettings(&settings).
 		SetAPIVersion("v1").
 		SetKind("Secret").
-		SetName("deployment-manifest")
+		SetName(deploymentManifestName)
 	secret, err := cb.Build()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
@@ -29,3 +42,32 @@ func MakeBoshDeploymentManifestSecret(settings ExportSettings) (helm.Node, error
 
 	return secret, nil
 }
+
+// propertiesInstanceGroups returns a template expression building the list of
+// BOSH deployment manifest instance groups from the job properties set under
+// .Values.properties.<instance group>.<job>. It returns an empty string when
+// there are no instance groups with jobs.
+func propertiesInstanceGroups(settings ExportSettings) string {
+	if settings.RoleManifest == nil {
+		return ""
+	}
+
+	var instanceGroups []string
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		if len(instanceGroup.JobReferences) == 0 {
+			continue
+		}
+		groupProperties := fmt.Sprintf(`(default (dict) (index .Values.properties %q))`, instanceGroup.Name)
+		var jobs []string
+		for _, jobReference := range instanceGroup.JobReferences {
+			properties := fmt.Sprintf(`(default (dict) (index %s %q))`, groupProperties, jobReference.Name)
+			jobs = append(jobs, fmt.Sprintf(`(dict "name" %q "properties" %s)`, jobReference.Name, properties))
+		}
+		instanceGroups = append(instanceGroups, fmt.Sprintf(`(dict "name" %q "jobs" (list %s))`,
+			instanceGroup.Name, strings.Join(jobs, " ")))
+	}
+	if len(instanceGroups) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("(list %s)", strings.Join(instanceGroups, " "))
+}
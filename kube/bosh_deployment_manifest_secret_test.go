@@ -4,8 +4,10 @@ import (
 	b64 "encoding/base64"
 	"testing"
 
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func TestMakeBoshDeploymentManifestSecretKube(t *testing.T) {
@@ -22,7 +24,7 @@ func TestMakeBoshDeploymentManifestSecretKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "v1"
 		data:
 		  deployment-manifest: ""
@@ -57,7 +59,7 @@ func TestMakeBoshDeploymentManifestSecretHelm(t *testing.T) {
 
 	payload := b64.StdEncoding.EncodeToString([]byte("foo: bar\ninstance_groups: []"))
 
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 	apiVersion: "v1"
 	data:
 	  deployment-manifest: `+payload+`
@@ -67,7 +69,7 @@ func TestMakeBoshDeploymentManifestSecretHelm(t *testing.T) {
 		labels:
 			app.kubernetes.io/component: deployment-manifest
 			app.kubernetes.io/instance: MyRelease
-			app.kubernetes.io/managed-by: Tiller
+			app.kubernetes.io/managed-by: fissile
 			app.kubernetes.io/name: MyChart
 			app.kubernetes.io/version: 1.22.333.4444
 			helm.sh/chart: MyChart-42.1_foo
@@ -75,3 +77,85 @@ func TestMakeBoshDeploymentManifestSecretHelm(t *testing.T) {
 	type: "Opaque"
 	`, actual)
 }
+
+func TestMakeBoshDeploymentManifestSecretHelmProperties(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	manifestSecret, err := MakeBoshDeploymentManifestSecret(ExportSettings{
+		CreateHelmChart: true,
+		RoleManifest: &model.RoleManifest{
+			InstanceGroups: model.InstanceGroups{
+				&model.InstanceGroup{
+					Name: "my-role",
+					JobReferences: model.JobReferences{
+						&model.JobReference{Name: "tor"},
+						&model.JobReference{Name: "ntpd"},
+					},
+				},
+			},
+		},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	manifestFromConfig := func(config map[string]interface{}) (interface{}, error) {
+		actual, err := RoundtripNode(manifestSecret, config)
+		if err != nil {
+			return nil, err
+		}
+		encoded := actual.(map[interface{}]interface{})["data"].(map[interface{}]interface{})["deployment-manifest"]
+		decoded, err := b64.StdEncoding.DecodeString(encoded.(string))
+		if err != nil {
+			return nil, err
+		}
+		var manifest interface{}
+		err = yaml.Unmarshal(decoded, &manifest)
+		return manifest, err
+	}
+
+	t.Run("Without properties", func(t *testing.T) {
+		t.Parallel()
+		manifest, err := manifestFromConfig(map[string]interface{}{"Values.bosh.foo": "bar"})
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLEqualString(assert, `---
+			foo: bar
+			instance_groups: []
+		`, manifest)
+	})
+
+	t.Run("With properties", func(t *testing.T) {
+		t.Parallel()
+		manifest, err := manifestFromConfig(map[string]interface{}{
+			"Values.properties.my-role.tor.tor.hostname": "example.com",
+		})
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLEqualString(assert, `---
+			instance_groups:
+			-	name: my-role
+				jobs:
+				-	name: tor
+					properties:
+						tor:
+							hostname: example.com
+				-	name: ntpd
+					properties: {}
+		`, manifest)
+	})
+
+	t.Run("With properties and instance groups", func(t *testing.T) {
+		t.Parallel()
+		_, err := manifestFromConfig(map[string]interface{}{
+			"Values.properties.my-role.tor.tor.hostname": "example.com",
+			"Values.bosh.instance_groups":                []interface{}{map[string]interface{}{"name": "my-role"}},
+		})
+		if assert.Error(err) {
+			assert.Contains(err.Error(), "bosh.instance_groups cannot be used together with properties")
+		}
+	})
+}
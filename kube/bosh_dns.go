@@ -0,0 +1,86 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// boshDNSServerKey is the name of the CoreDNS server block in the ConfigMap.
+// The .server suffix matches the coredns-custom convention used by several
+// Kubernetes distributions to import additional server blocks.
+const boshDNSServerKey = "bosh-dns.server"
+
+// MakeBoshDNSAliases returns a ConfigMap holding a CoreDNS server block that
+// resolves BOSH DNS addresses of instance groups to the generated services.
+// Addresses of the form <instance group>.<network>.<deployment>.bosh (with
+// an optional query prefix, e.g. q-s0) resolve to the headless service of the
+// instance group, and <index>.<instance group>.<network>.<deployment>.bosh
+// resolves to the pod with that index. It returns nil if no instance group
+// has a service.
+func MakeBoshDNSAliases(settings ExportSettings) (helm.Node, error) {
+	if !settings.CreateHelmChart {
+		return nil, fmt.Errorf("BOSH DNS aliases require a helm chart")
+	}
+
+	suffix := fmt.Sprintf(".{{ .Release.Namespace }}.svc.{{ %s }}", clusterDomainValue)
+	// The server block is a quoted string, so the names passed to the
+	// fissile.Name template are raw strings, which need no escaping
+	name := func(name string) string {
+		return fmt.Sprintf("{{ include `fissile.Name` (list $ `%s`) }}", name)
+	}
+
+	var rules bytes.Buffer
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		if !hasClusteringService(instanceGroup) {
+			continue
+		}
+		// The clustering service created by NewStatefulSet selects all pods of
+		// the instance group, just like the BOSH DNS instance group address.
+		service := name(instanceGroup.Name + "-set")
+		address := regexp.QuoteMeta(instanceGroup.Name) + `\.[^.]+\.[^.]+\.bosh\.?$`
+		fmt.Fprintf(&rules, "    rewrite stop name regex ^([0-9]+)\\.%s %s-{1}.%s%s answer auto\n",
+			address, name(instanceGroup.Name), service, suffix)
+		fmt.Fprintf(&rules, "    rewrite stop name regex ^(.*\\.)?%s %s%s answer auto\n",
+			address, service, suffix)
+	}
+	if rules.Len() == 0 {
+		return nil, nil
+	}
+
+	server := fmt.Sprintf("bosh:53 {\n    errors\n    cache 30\n%s    kubernetes {{ %s }}\n}\n", rules.String(), clusterDomainValue)
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("v1").
+		SetKind("ConfigMap").
+		SetName("bosh-dns-aliases").
+		AddModifier(helm.Comment("CoreDNS server block resolving BOSH DNS addresses of instance groups to services"))
+	configMap, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	configMap.Add("data", helm.NewMapping(boshDNSServerKey, server))
+
+	return configMap, nil
+}
+
+// hasClusteringService returns true if a headless service selecting all pods
+// of the instance group is generated, i.e. it is a BOSH role with any ports.
+func hasClusteringService(instanceGroup *model.InstanceGroup) bool {
+	if instanceGroup.Type != model.RoleTypeBosh || instanceGroup.IsColocated() {
+		return false
+	}
+	if instanceGroup.Run != nil && instanceGroup.Run.FlightStage == model.FlightStageManual {
+		return false
+	}
+	for _, job := range instanceGroup.JobReferences {
+		if len(job.ContainerProperties.BoshContainerization.Ports) > 0 {
+			return true
+		}
+	}
+	return false
+}
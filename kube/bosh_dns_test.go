@@ -0,0 +1,88 @@
+package kube
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeBoshDNSAliases(t *testing.T) {
+	t.Parallel()
+
+	_, err := MakeBoshDNSAliases(ExportSettings{})
+	assert.Error(t, err, "Should require a helm chart")
+
+	configMap, err := MakeBoshDNSAliases(testExportSettings(t, "bosh-dns.yml"))
+	require.NoError(t, err)
+	require.NotNil(t, configMap)
+
+	config := map[string]interface{}{
+		"Release.Namespace": "cf",
+	}
+	actual, err := RoundtripNode(configMap, config)
+	require.NoError(t, err)
+
+	mapping := actual.(map[interface{}]interface{})
+	assert.Equal(t, "ConfigMap", mapping["kind"])
+	server := mapping["data"].(map[interface{}]interface{})["bosh-dns.server"]
+	assert.Equal(t, `bosh:53 {
+    errors
+    cache 30
+    rewrite stop name regex ^([0-9]+)\.nats-server\.[^.]+\.[^.]+\.bosh\.?$ nats-server-{1}.nats-server-set.cf.svc.cluster.local answer auto
+    rewrite stop name regex ^(.*\.)?nats-server\.[^.]+\.[^.]+\.bosh\.?$ nats-server-set.cf.svc.cluster.local answer auto
+    kubernetes cluster.local
+}
+`, server)
+
+	// The cluster domain is that of the environment of the instance groups
+	config["Values.env.KUBERNETES_CLUSTER_DOMAIN"] = "example.org"
+	actual, err = RoundtripNode(configMap, config)
+	require.NoError(t, err)
+	server = actual.(map[interface{}]interface{})["data"].(map[interface{}]interface{})["bosh-dns.server"]
+	assert.Contains(t, server, " nats-server-set.cf.svc.example.org answer auto\n")
+	assert.Contains(t, server, "    kubernetes example.org\n")
+}
+
+func TestMakeBoshDNSAliasesNamePrefix(t *testing.T) {
+	t.Parallel()
+
+	configMap, err := MakeBoshDNSAliases(testExportSettings(t, "bosh-dns.yml"))
+	require.NoError(t, err)
+
+	config := map[string]interface{}{
+		"Release.Namespace":          "cf",
+		"Values.name_prefix.enabled": true,
+	}
+	actual, err := RoundtripNode(configMap, config)
+	require.NoError(t, err)
+
+	mapping := actual.(map[interface{}]interface{})
+	assert.Equal(t, "MyRelease-bosh-dns-aliases", mapping["metadata"].(map[interface{}]interface{})["name"])
+	server := mapping["data"].(map[interface{}]interface{})["bosh-dns.server"]
+	assert.Contains(t, server, " MyRelease-nats-server-{1}.MyRelease-nats-server-set.cf.svc.cluster.local answer auto\n")
+	assert.Contains(t, server, " MyRelease-nats-server-set.cf.svc.cluster.local answer auto\n")
+
+	// Long names are shortened like the names of the services themselves
+	prefix := strings.Repeat("long", 15)
+	config["Values.name_prefix.prefix"] = prefix
+	actual, err = RoundtripNode(configMap, config)
+	require.NoError(t, err)
+	server = actual.(map[interface{}]interface{})["data"].(map[interface{}]interface{})["bosh-dns.server"]
+	service := prefix + "-nats-server-set"
+	service = service[:54] + "-" + fmt.Sprintf("%x", sha256.Sum256([]byte(service)))[:8]
+	assert.Contains(t, server, " "+service+".cf.svc.cluster.local answer auto\n")
+}
+
+func TestMakeBoshDNSAliasesNoServices(t *testing.T) {
+	t.Parallel()
+
+	settings := testExportSettings(t, "bosh-dns.yml")
+	settings.RoleManifest.InstanceGroups = settings.RoleManifest.InstanceGroups[1:]
+	configMap, err := MakeBoshDNSAliases(settings)
+	assert.NoError(t, err)
+	assert.Nil(t, configMap)
+}
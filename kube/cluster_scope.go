@@ -0,0 +1,66 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/util"
+)
+
+// ClusterScopedResource is a cluster-scoped resource of the generated
+// configs. The name is the name of the template, i.e. it may be a helm
+// expression.
+type ClusterScopedResource struct {
+	Kind     string
+	Name     string
+	Template string
+}
+
+// ClusterScopedResources returns the cluster-scoped resources among the
+// nodes written to the given template, including the items of lists.
+// Resources are listed even if they are only created conditionally.
+func ClusterScopedResources(templateName string, nodes ...helm.Node) []ClusterScopedResource {
+	var resources []ClusterScopedResource
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		kind, ok := node.Get("kind").(*helm.Scalar)
+		if !ok {
+			continue
+		}
+		if kind.String() == "List" {
+			if items, ok := node.Get("items").(*helm.List); ok {
+				resources = append(resources, ClusterScopedResources(templateName, items.Values()...)...)
+			}
+			continue
+		}
+		if !util.StringInSlice(kind.String(), model.ClusterScopedKinds) {
+			continue
+		}
+		resource := ClusterScopedResource{Kind: kind.String(), Template: templateName}
+		if name, ok := node.Get("metadata", "name").(*helm.Scalar); ok {
+			resource.Name = name.String()
+		}
+		resources = append(resources, resource)
+	}
+	return resources
+}
+
+// AuditClusterScope returns an error naming the resources whose kinds are
+// not in the allowed list
+func AuditClusterScope(resources []ClusterScopedResource, allowed []string) error {
+	var forbidden []string
+	for _, resource := range resources {
+		if !util.StringInSlice(resource.Kind, allowed) {
+			forbidden = append(forbidden, fmt.Sprintf("%s %s (%s)", resource.Kind, resource.Name, resource.Template))
+		}
+	}
+	if len(forbidden) > 0 {
+		return fmt.Errorf("Cluster-scoped resources not allowed by configuration.cluster_scoped of the role manifest:\n%s",
+			strings.Join(forbidden, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterScopedResources(t *testing.T) {
+	t.Parallel()
+
+	resource := func(kind, name string) helm.Node {
+		return helm.NewMapping("kind", kind, "metadata", helm.NewMapping("name", name))
+	}
+	list := helm.NewMapping("kind", "List", "items", helm.NewList(
+		resource("ClusterRoleBinding", "binding"),
+		resource("RoleBinding", "namespaced"),
+	))
+
+	resources := ClusterScopedResources("templates/auth.yaml",
+		resource("ClusterRole", "reader"),
+		resource("Role", "writer"),
+		list,
+		helm.NewMapping("key", "value"),
+		nil)
+	assert.Equal(t, []ClusterScopedResource{
+		{Kind: "ClusterRole", Name: "reader", Template: "templates/auth.yaml"},
+		{Kind: "ClusterRoleBinding", Name: "binding", Template: "templates/auth.yaml"},
+	}, resources)
+
+	assert.NoError(t, AuditClusterScope(resources, []string{"ClusterRole", "ClusterRoleBinding"}))
+	assert.NoError(t, AuditClusterScope(nil, nil))
+	assert.EqualError(t, AuditClusterScope(resources, []string{"ClusterRoleBinding"}),
+		"Cluster-scoped resources not allowed by configuration.cluster_scoped of the role manifest:\n"+
+			"ClusterRole reader (templates/auth.yaml)")
+}
@@ -1,7 +1,6 @@
 package kube
 
 import (
-	"errors"
 	"fmt"
 
 	"code.cloudfoundry.org/fissile/helm"
@@ -44,42 +43,91 @@ func NewDeployment(instanceGroup *model.InstanceGroup, settings ExportSettings,
 	return deployment, svc, err
 }
 
-// getAffinityBlock returns an affinity block to add to a podspec
-func getAffinityBlock(instanceGroup *model.InstanceGroup) *helm.Mapping {
+// getAffinityBlock returns an affinity block to add to a podspec. It holds
+// the affinities of the role manifest; helm charts replace each kind of
+// affinity with the one in .Values.sizing.<instance group>.affinity, if set.
+func getAffinityBlock(instanceGroup *model.InstanceGroup, settings ExportSettings) (*helm.Mapping, error) {
 	affinity := helm.NewMapping()
 
-	if instanceGroup.Run != nil && instanceGroup.Run.Affinity != nil && instanceGroup.Run.Affinity.PodAntiAffinity != nil {
-		// Add pod anti affinity from role manifest
-		affinity.Add("podAntiAffinity", instanceGroup.Run.Affinity.PodAntiAffinity)
+	var manifestAffinity model.RoleRunAffinity
+	if instanceGroup.Run != nil && instanceGroup.Run.Affinity != nil {
+		manifestAffinity = *instanceGroup.Run.Affinity
 	}
 
-	// Add node affinity template to be filled in by values.yaml
 	roleName := makeVarName(instanceGroup.Name)
-	nodeCond := fmt.Sprintf("if .Values.sizing.%s.affinity.nodeAffinity", roleName)
-	nodeAffinity := fmt.Sprintf("{{ toJson .Values.sizing.%s.affinity.nodeAffinity }}", roleName)
-	affinity.Add("nodeAffinity", nodeAffinity, helm.Block(nodeCond))
+	for _, kind := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"podAntiAffinity", manifestAffinity.PodAntiAffinity},
+		{"podAffinity", manifestAffinity.PodAffinity},
+		{"nodeAffinity", manifestAffinity.NodeAffinity},
+	} {
+		if !settings.CreateHelmChart {
+			if kind.value != nil {
+				affinity.Add(kind.name, kind.value)
+			}
+			continue
+		}
+
+		override := fmt.Sprintf(".Values.sizing.%s.affinity.%s", roleName, kind.name)
+		if kind.value == nil {
+			affinity.Add(kind.name, fmt.Sprintf("{{ toJson %s }}", override), helm.Block("if "+override))
+			continue
+		}
+		value, err := util.JSONMarshal(kind.value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s of instance group %s: %v", kind.name, instanceGroup.Name, err)
+		}
+		affinity.Add(kind.name, fmt.Sprintf("{{ if %s }}{{ toJson %s }}{{ else }}%s{{ end }}", override, override, value))
+	}
 
-	return affinity
+	return affinity, nil
+}
+
+// addPodAffinity adds the affinity of the instance group to a pod spec
+func addPodAffinity(instanceGroup *model.InstanceGroup, podSpec *helm.Mapping, settings ExportSettings) error {
+	affinity, err := getAffinityBlock(instanceGroup, settings)
+	if err != nil {
+		return err
+	}
+	if len(affinity.Names()) == 0 {
+		return nil
+	}
+	var modifiers []helm.NodeModifier
+	if settings.CreateHelmChart && !hasManifestAffinity(instanceGroup) {
+		// Leave out the affinity unless the values set some
+		modifiers = append(modifiers, helm.Block(fmt.Sprintf("if .Values.sizing.%s.affinity", makeVarName(instanceGroup.Name))))
+	}
+	podSpec.Add("affinity", affinity, modifiers...)
+	podSpec.Sort()
+	return nil
+}
+
+// hasManifestAffinity returns true if the role manifest sets any affinity for
+// the instance group
+func hasManifestAffinity(instanceGroup *model.InstanceGroup) bool {
+	if instanceGroup.Run == nil || instanceGroup.Run.Affinity == nil {
+		return false
+	}
+	affinity := instanceGroup.Run.Affinity
+	return affinity.PodAntiAffinity != nil || affinity.PodAffinity != nil || affinity.NodeAffinity != nil
 }
 
 // addAffinityRules adds affinity rules to the pod spec
 func addAffinityRules(instanceGroup *model.InstanceGroup, spec *helm.Mapping, settings ExportSettings) error {
-	if instanceGroup.Run.Affinity != nil {
-		if instanceGroup.Run.Affinity.NodeAffinity != nil {
-			return errors.New("node affinity in role manifest not allowed")
-		}
+	podSpec := spec.Get("template", "spec").(*helm.Mapping)
 
-		if instanceGroup.Run.Affinity.PodAffinity != nil {
-			return errors.New("pod affinity in role manifest not supported")
-		}
+	err := addPodAffinity(instanceGroup, podSpec, settings)
+	if err != nil {
+		return err
 	}
 
-	if settings.CreateHelmChart {
-		podSpec := spec.Get("template", "spec").(*helm.Mapping)
-
-		podSpec.Add("affinity", getAffinityBlock(instanceGroup))
-		podSpec.Sort()
+	// Pin zone replicas to the nodes of their zone
+	if instanceGroup.Zone != nil {
+		podSpec.Add("nodeSelector", helm.NewMapping(ZoneLabel, instanceGroup.Zone.Label))
 	}
+	podSpec.Sort()
 
 	meta := spec.Get("template", "metadata").(*helm.Mapping)
 	if meta.Get("annotations") == nil {
@@ -137,6 +185,38 @@ func generalCheck(instanceGroup *model.InstanceGroup, controller *helm.Mapping,
 		}
 	}
 
+	// Deprecated variables can be configured to fail rendering once the chart
+	// version reaches the version they were announced to be removed in.
+	if settings.RoleManifest != nil {
+		for _, cv := range settings.RoleManifest.Variables {
+			deprecation := cv.CVOptions.Deprecated
+			if deprecation == nil || !deprecation.FailAfterRemoval || deprecation.RemovalVersion == "" {
+				continue
+			}
+			name := ".Values.env." + cv.Name
+			if cv.CVOptions.Secret {
+				name = ".Values.secrets." + cv.Name
+			}
+			fail := fmt.Sprintf(`{{ fail %q }}`, deprecation.Notice(cv.Name))
+			block := fmt.Sprintf(`if and %s (semverCompare ">=%s" .Chart.Version)`, notNil(name), deprecation.RemovalVersion)
+			controller.Add("_deprecated_"+cv.Name, fail, helm.Block(block))
+		}
+
+		// User supplied values must follow the validation rules of their
+		// variables
+		for _, cv := range settings.RoleManifest.Variables {
+			check := variableValidationCheck(cv)
+			if check == "" {
+				continue
+			}
+			name := ".Values.env." + cv.Name
+			if cv.CVOptions.Secret {
+				name = ".Values.secrets." + cv.Name
+			}
+			controller.Add("_validation_"+cv.Name, check, helm.Block("if "+notNil(name)))
+		}
+	}
+
 	controller.Sort()
 	return nil
 }
@@ -144,25 +224,31 @@ func generalCheck(instanceGroup *model.InstanceGroup, controller *helm.Mapping,
 // addFeatureCheck adds a conditional if a role is dependent on a feature flag,
 // such that the nodes will only be included when the feature is enabled.
 func addFeatureCheck(instanceGroup *model.InstanceGroup, nodes ...helm.Node) {
-	// default_feature, if_feature, and unless_feature are all mutually exclusive, so only one can be set
-	var nodeMod helm.NodeModifier
-	if instanceGroup.IfFeature != "" {
-		nodeMod = helm.Block(fmt.Sprintf("if .Values.enable.%s", instanceGroup.IfFeature))
-	} else if instanceGroup.DefaultFeature != "" {
-		nodeMod = helm.Block(fmt.Sprintf("if .Values.enable.%s", instanceGroup.DefaultFeature))
-	} else if instanceGroup.UnlessFeature != "" {
-		nodeMod = helm.Block(fmt.Sprintf("if not .Values.enable.%s", instanceGroup.UnlessFeature))
-	}
-	if nodeMod != nil {
+	block := featureCheckBlock(instanceGroup)
+	if block != "" {
 		for _, node := range nodes {
 			if node != nil {
-				node.Set(nodeMod)
+				node.Set(helm.Block(block))
 			}
 		}
 	}
 
 }
 
+// featureCheckBlock returns the block action checking whether the feature an
+// instance group depends on is enabled, or "" if it does not depend on one
+func featureCheckBlock(instanceGroup *model.InstanceGroup) string {
+	// default_feature, if_feature, and unless_feature are all mutually exclusive, so only one can be set
+	if instanceGroup.IfFeature != "" {
+		return fmt.Sprintf("if .Values.enable.%s", instanceGroup.IfFeature)
+	} else if instanceGroup.DefaultFeature != "" {
+		return fmt.Sprintf("if .Values.enable.%s", instanceGroup.DefaultFeature)
+	} else if instanceGroup.UnlessFeature != "" {
+		return fmt.Sprintf("if not .Values.enable.%s", instanceGroup.UnlessFeature)
+	}
+	return ""
+}
+
 func notNil(variable string) string {
 	return fmt.Sprintf(`(ne (typeOf %s) "<nil>")`, variable)
 }
@@ -8,7 +8,7 @@ import (
 	"code.cloudfoundry.org/fissile/helm"
 	"code.cloudfoundry.org/fissile/model"
 	"code.cloudfoundry.org/fissile/model/loader"
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -67,7 +67,7 @@ func TestNewDeploymentKube(t *testing.T) {
 	assert.Nil(svc)
 	assert.NotNil(deployment)
 	assert.Equal(deployment.Get("kind").String(), "Deployment")
-	assert.Equal(deployment.Get("metadata", "name").String(), "some-group")
+	assert.Equal(deployment.Get("metadata", "labels", RoleNameLabel).String(), "some-group")
 }
 
 func TestNewDeploymentHelm(t *testing.T) {
@@ -92,13 +92,14 @@ func TestNewDeploymentHelm(t *testing.T) {
 	assert.Nil(svc)
 	assert.NotNil(deployment)
 	assert.Equal(deployment.Get("kind").String(), "Deployment")
-	assert.Equal(deployment.Get("metadata", "name").String(), "some-group")
+	assert.Equal(deployment.Get("metadata", "labels", RoleNameLabel).String(), "some-group")
 
 	t.Run("Defaults", func(t *testing.T) {
 		t.Parallel()
 		config := map[string]interface{}{
 			"Values.sizing.some_group.count":                 nil,
 			"Values.sizing.some_group.affinity.nodeAffinity": "snafu",
+			"Values.sizing.some_group.debug.command":         nil,
 		}
 		_, err := RenderNode(deployment, config)
 		assert.NoError(err)
@@ -109,6 +110,7 @@ func TestNewDeploymentHelm(t *testing.T) {
 		config := map[string]interface{}{
 			"Values.sizing.some_group.count":                 "0",
 			"Values.sizing.some_group.affinity.nodeAffinity": "snafu",
+			"Values.sizing.some_group.debug.command":         nil,
 			"Values.kube.registry.hostname":                  "docker.suse.fake",
 			"Values.kube.organization":                       "splat",
 			"Values.env.KUBERNETES_CLUSTER_DOMAIN":           "cluster.local",
@@ -125,6 +127,7 @@ func TestNewDeploymentHelm(t *testing.T) {
 			"Values.config.HA_strict":                        "true",
 			"Values.sizing.some_group.count":                 "1",
 			"Values.sizing.some_group.affinity.nodeAffinity": "snafu",
+			"Values.sizing.some_group.debug.command":         nil,
 			"Values.kube.registry.hostname":                  "docker.suse.fake",
 			"Values.kube.organization":                       "splat",
 			"Values.env.KUBERNETES_CLUSTER_DOMAIN":           "cluster.local",
@@ -139,6 +142,7 @@ func TestNewDeploymentHelm(t *testing.T) {
 		config := map[string]interface{}{
 			"Values.sizing.some_group.count":                 "10",
 			"Values.sizing.some_group.affinity.nodeAffinity": "snafu",
+			"Values.sizing.some_group.debug.command":         nil,
 			"Values.kube.registry.hostname":                  "docker.suse.fake",
 			"Values.kube.organization":                       "splat",
 			"Values.env.KUBERNETES_CLUSTER_DOMAIN":           "cluster.local",
@@ -209,6 +213,7 @@ func TestNewDeploymentHelm(t *testing.T) {
 			"Values.config.use_istio":                        true,
 			"Values.sizing.some_group.count":                 "1",
 			"Values.sizing.some_group.affinity.nodeAffinity": "snafu",
+			"Values.sizing.some_group.debug.command":         nil,
 			"Values.kube.registry.hostname":                  "docker.suse.fake",
 			"Values.kube.registry.username":                  "", // no imagePullSecrets
 			"Values.kube.organization":                       "splat",
@@ -219,7 +224,7 @@ func TestNewDeploymentHelm(t *testing.T) {
 		if !assert.NoError(err) {
 			return
 		}
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "apps/v1"
 			kind: "Deployment"
 			metadata:
@@ -228,7 +233,7 @@ func TestNewDeploymentHelm(t *testing.T) {
 					app: "some-group"
 					app.kubernetes.io/component: some-group
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -246,7 +251,7 @@ func TestNewDeploymentHelm(t *testing.T) {
 							app: "some-group"
 							app.kubernetes.io/component: some-group
 							app.kubernetes.io/instance: MyRelease
-							app.kubernetes.io/managed-by: Tiller
+							app.kubernetes.io/managed-by: fissile
 							app.kubernetes.io/name: MyChart
 							app.kubernetes.io/version: 1.22.333.4444
 							helm.sh/chart: MyChart-42.1_foo
@@ -285,6 +290,8 @@ func TestNewDeploymentHelm(t *testing.T) {
 								value: "2048"
 							-	name: "VCAP_SOFT_NPROC"
 								value: "1024"
+							-	name: "TERMINATION_GRACE_PERIOD_SECONDS"
+								value: "600"
 							image: "docker.suse.fake/splat/the_repos-some-group:3b960ef56f837ae186cdd546d03750cca62676bc"
 							lifecycle:
 								preStop:
@@ -318,6 +325,170 @@ func TestNewDeploymentHelm(t *testing.T) {
 	})
 }
 
+func TestNewDeploymentHelmDeprecatedVariables(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	instanceGroup := deploymentTestLoad(assert, "some-group", "pod-with-valid-pod-anti-affinity.yml")
+	if instanceGroup == nil {
+		return
+	}
+
+	settings := ExportSettings{
+		CreateHelmChart: true,
+		Repository:      "the_repos",
+		RoleManifest: &model.RoleManifest{
+			Variables: model.Variables{
+				&model.VariableDefinition{
+					Name: "REMOVED",
+					CVOptions: model.CVOptions{
+						Deprecated: &model.CVDeprecation{
+							Message:          "Use NEW instead",
+							RemovalVersion:   "42.0.0",
+							FailAfterRemoval: true,
+						},
+					},
+				},
+				&model.VariableDefinition{
+					Name: "NOT_YET_REMOVED",
+					CVOptions: model.CVOptions{
+						Secret: true,
+						Deprecated: &model.CVDeprecation{
+							RemovalVersion:   "43.0.0",
+							FailAfterRemoval: true,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	deployment, _, err := NewDeployment(instanceGroup, settings, FakeGrapher{})
+	if !assert.NoError(err) {
+		return
+	}
+
+	t.Run("Unset", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.sizing.some_group.count":                 "1",
+			"Values.sizing.some_group.affinity.nodeAffinity": "snafu",
+			"Values.sizing.some_group.debug.command":         nil,
+		}
+		_, err := RenderNode(deployment, config)
+		assert.NoError(err)
+	})
+
+	t.Run("Set before removal", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.sizing.some_group.count":                 "1",
+			"Values.sizing.some_group.affinity.nodeAffinity": "snafu",
+			"Values.sizing.some_group.debug.command":         nil,
+			"Values.secrets.NOT_YET_REMOVED":                 "value",
+		}
+		_, err := RenderNode(deployment, config)
+		assert.NoError(err)
+	})
+
+	t.Run("Set after removal", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.sizing.some_group.count":                 "1",
+			"Values.sizing.some_group.affinity.nodeAffinity": "snafu",
+			"Values.sizing.some_group.debug.command":         nil,
+			"Values.env.REMOVED":                             "value",
+		}
+		_, err := RenderNode(deployment, config)
+		if assert.Error(err) {
+			assert.Contains(err.Error(),
+				"error calling fail: REMOVED is deprecated and will be removed in version 42.0.0: Use NEW instead")
+		}
+	})
+}
+
+func TestNewDeploymentHelmVariableValidation(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	instanceGroup := deploymentTestLoad(assert, "some-group", "pod-with-valid-pod-anti-affinity.yml")
+	if instanceGroup == nil {
+		return
+	}
+
+	min, max := 1024.0, 65535.0
+	settings := ExportSettings{
+		CreateHelmChart: true,
+		Repository:      "the_repos",
+		RoleManifest: &model.RoleManifest{
+			Variables: model.Variables{
+				&model.VariableDefinition{
+					Name: "PORT",
+					CVOptions: model.CVOptions{
+						Validation: &model.CVValidation{Type: model.CVValidationTypeInteger, Min: &min, Max: &max},
+					},
+				},
+				&model.VariableDefinition{
+					Name: "LOG_LEVEL",
+					CVOptions: model.CVOptions{
+						Validation: &model.CVValidation{Enum: []string{"debug", "info"}},
+					},
+				},
+				&model.VariableDefinition{
+					Name: "DOMAIN",
+					CVOptions: model.CVOptions{
+						Secret:     true,
+						Validation: &model.CVValidation{Pattern: `[a-z0-9.-]+`},
+					},
+				},
+			},
+		},
+	}
+
+	deployment, _, err := NewDeployment(instanceGroup, settings, FakeGrapher{})
+	if !assert.NoError(err) {
+		return
+	}
+
+	for _, testCase := range []struct {
+		name   string
+		values map[string]interface{}
+		err    string
+	}{
+		{"Unset", map[string]interface{}{}, ""},
+		{"Valid", map[string]interface{}{
+			"Values.env.PORT":       8080,
+			"Values.env.LOG_LEVEL":  "info",
+			"Values.secrets.DOMAIN": "example.com",
+		}, ""},
+		{"Valid string", map[string]interface{}{"Values.env.PORT": "8080"}, ""},
+		{"Not an integer", map[string]interface{}{"Values.env.PORT": 80.5}, "env.PORT must be an integer"},
+		{"Below min", map[string]interface{}{"Values.env.PORT": 80}, "env.PORT must be at least 1024"},
+		{"Above max", map[string]interface{}{"Values.env.PORT": "70000"}, "env.PORT must be at most 65535"},
+		{"Not in enum", map[string]interface{}{"Values.env.LOG_LEVEL": "trace"}, "env.LOG_LEVEL must be one of debug, info"},
+		{"Not matching", map[string]interface{}{"Values.secrets.DOMAIN": "Example.com"}, "secrets.DOMAIN must match [a-z0-9.-]+"},
+	} {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			config := map[string]interface{}{
+				"Values.sizing.some_group.count":                 "1",
+				"Values.sizing.some_group.affinity.nodeAffinity": "snafu",
+				"Values.sizing.some_group.debug.command":         nil,
+			}
+			for key, value := range testCase.values {
+				config[key] = value
+			}
+			_, err := RenderNode(deployment, config)
+			if testCase.err == "" {
+				assert.NoError(err)
+			} else if assert.Error(err) {
+				assert.Contains(err.Error(), "error calling fail: "+testCase.err)
+			}
+		})
+	}
+}
+
 func TestNewDeploymentIstioManagedHelm(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
@@ -340,7 +511,7 @@ func TestNewDeploymentIstioManagedHelm(t *testing.T) {
 	assert.Nil(svc)
 	assert.NotNil(deployment)
 	assert.Equal(deployment.Get("kind").String(), "Deployment")
-	assert.Equal(deployment.Get("metadata", "name").String(), "istio-managed-group")
+	assert.Equal(deployment.Get("metadata", "labels", RoleNameLabel).String(), "istio-managed-group")
 
 	t.Run("Configured", func(t *testing.T) {
 		t.Parallel()
@@ -348,6 +519,7 @@ func TestNewDeploymentIstioManagedHelm(t *testing.T) {
 			"Values.config.use_istio":                                 "true",
 			"Values.sizing.istio_managed_group.count":                 "1",
 			"Values.sizing.istio_managed_group.affinity.nodeAffinity": "snafu",
+			"Values.sizing.istio_managed_group.debug.command":         nil,
 			"Values.kube.registry.hostname":                           "docker.suse.fake",
 			"Values.kube.registry.username":                           "U",
 			"Values.kube.organization":                                "splat",
@@ -358,7 +530,7 @@ func TestNewDeploymentIstioManagedHelm(t *testing.T) {
 		if !assert.NoError(err) {
 			return
 		}
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "apps/v1"
 			kind: "Deployment"
 			metadata:
@@ -367,7 +539,7 @@ func TestNewDeploymentIstioManagedHelm(t *testing.T) {
 					app: "istio-managed-group"
 					app.kubernetes.io/component: istio-managed-group
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -387,7 +559,7 @@ func TestNewDeploymentIstioManagedHelm(t *testing.T) {
 							app: "istio-managed-group"
 							app.kubernetes.io/component: istio-managed-group
 							app.kubernetes.io/instance: MyRelease
-							app.kubernetes.io/managed-by: Tiller
+							app.kubernetes.io/managed-by: fissile
 							app.kubernetes.io/name: MyChart
 							app.kubernetes.io/version: 1.22.333.4444
 							helm.sh/chart: MyChart-42.1_foo
@@ -425,6 +597,8 @@ func TestNewDeploymentIstioManagedHelm(t *testing.T) {
 								value: "2048"
 							-	name: "VCAP_SOFT_NPROC"
 								value: "1024"
+							-	name: "TERMINATION_GRACE_PERIOD_SECONDS"
+								value: "600"
 							image: "docker.suse.fake/splat/the_repos-istio-managed-group:3b960ef56f837ae186cdd546d03750cca62676bc"
 							lifecycle:
 								preStop:
@@ -469,23 +643,43 @@ func TestGetAffinityBlock(t *testing.T) {
 		return
 	}
 
-	affinity := getAffinityBlock(instanceGroup)
+	affinity, err := getAffinityBlock(instanceGroup, ExportSettings{CreateHelmChart: true})
+	if !assert.NoError(err) {
+		return
+	}
 
 	assert.NotNil(affinity.Get("podAntiAffinity"))
 	assert.NotNil(affinity.Get("nodeAffinity"))
-	assert.Equal(affinity.Names(), []string{"podAntiAffinity", "nodeAffinity"})
+	assert.Equal(affinity.Names(), []string{"podAntiAffinity", "podAffinity", "nodeAffinity"})
+	assert.Equal(affinity.Get("podAffinity").Block(), "if .Values.sizing.some_group.affinity.podAffinity")
 	assert.Equal(affinity.Get("nodeAffinity").Block(), "if .Values.sizing.some_group.affinity.nodeAffinity")
 
+	// The affinity of the role manifest is the default of the values
+	actual, err := RoundtripNode(affinity, map[string]interface{}{
+		"Values.sizing.some_group.affinity.podAntiAffinity": "snafu",
+	})
+	if assert.NoError(err) {
+		assert.Equal(map[interface{}]interface{}{"podAntiAffinity": "snafu"}, actual)
+	}
+
+	affinity, err = getAffinityBlock(instanceGroup, ExportSettings{})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(affinity.Names(), []string{"podAntiAffinity"})
+
 	instanceGroup = deploymentTestLoad(assert, "some-group", "pod-with-no-pod-anti-affinity.yml")
 	if instanceGroup == nil {
 		return
 	}
 
-	affinity = getAffinityBlock(instanceGroup)
+	affinity, err = getAffinityBlock(instanceGroup, ExportSettings{CreateHelmChart: true})
+	if !assert.NoError(err) {
+		return
+	}
 
-	assert.Nil(affinity.Get("podAntiAffinity"))
-	assert.NotNil(affinity.Get("nodeAffinity"))
-	assert.Equal(affinity.Names(), []string{"nodeAffinity"})
+	assert.Equal(affinity.Names(), []string{"podAntiAffinity", "podAffinity", "nodeAffinity"})
+	assert.Equal(affinity.Get("podAntiAffinity").Block(), "if .Values.sizing.some_group.affinity.podAntiAffinity")
 	assert.Equal(affinity.Get("nodeAffinity").Block(), "if .Values.sizing.some_group.affinity.nodeAffinity")
 }
 
@@ -508,8 +702,6 @@ func TestAddAffinityRules(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
 
-	emptySpec := createEmptySpec()
-
 	//
 	// Test instance group with valid anti affinity
 	//
@@ -531,7 +723,7 @@ func TestAddAffinityRules(t *testing.T) {
 	//
 	// Test instance group with pod affinity defined
 	//
-	instanceGroup = deploymentTestLoad(assert, "some-group", "pod-with-invalid-pod-affinity.yml")
+	instanceGroup = deploymentTestLoad(assert, "some-group", "pod-with-pod-affinity.yml")
 	if instanceGroup == nil {
 		return
 	}
@@ -540,13 +732,13 @@ func TestAddAffinityRules(t *testing.T) {
 
 	err = addAffinityRules(instanceGroup, spec, settings)
 
-	assert.Error(err)
-	assert.Equal(spec, emptySpec)
+	assert.NotNil(spec.Get("template", "spec", "affinity", "podAffinity"))
+	assert.NoError(err)
 
 	//
 	// Test instance group with node affinity defined
 	//
-	instanceGroup = deploymentTestLoad(assert, "some-group", "pod-with-invalid-node-affinity.yml")
+	instanceGroup = deploymentTestLoad(assert, "some-group", "pod-with-node-affinity.yml")
 	if instanceGroup == nil {
 		return
 	}
@@ -555,8 +747,8 @@ func TestAddAffinityRules(t *testing.T) {
 
 	err = addAffinityRules(instanceGroup, spec, settings)
 
-	assert.Error(err)
-	assert.Equal(spec, emptySpec)
+	assert.NotNil(spec.Get("template", "spec", "affinity", "nodeAffinity"))
+	assert.NoError(err)
 
 	//
 	// Test instance group without anti affinity
@@ -570,12 +762,16 @@ func TestAddAffinityRules(t *testing.T) {
 
 	err = addAffinityRules(instanceGroup, spec, settings)
 
-	assert.Nil(spec.Get("template", "spec", "affinity", "podAntiAffinity"))
+	if assert.NotNil(spec.Get("template", "spec", "affinity", "podAntiAffinity")) {
+		assert.Equal("{{ toJson .Values.sizing.some_group.affinity.podAntiAffinity }}",
+			spec.Get("template", "spec", "affinity", "podAntiAffinity").String(),
+			"Only the override should be used without anti affinity in the role manifest")
+	}
 	assert.NotNil(spec.Get("template", "spec", "affinity", "nodeAffinity"))
 	assert.NoError(err)
 
 	//
-	// Not creating the helm chart should only add the annotation
+	// Not creating the helm chart should only add the affinity of the role manifest
 	//
 	instanceGroup = deploymentTestLoad(assert, "some-group", "pod-with-valid-pod-anti-affinity.yml")
 	if instanceGroup == nil {
@@ -587,7 +783,8 @@ func TestAddAffinityRules(t *testing.T) {
 	settings = ExportSettings{CreateHelmChart: false}
 
 	err = addAffinityRules(instanceGroup, spec, settings)
-	assert.Nil(spec.Get("template", "spec", "affinity", "podAntiAffinity"))
+	assert.NotNil(spec.Get("template", "spec", "affinity", "podAntiAffinity"))
+	assert.Nil(spec.Get("template", "spec", "affinity", "nodeAffinity"))
 	assert.NoError(err)
 }
 
@@ -611,7 +808,7 @@ func TestNewDeploymentWithEmptyDirVolume(t *testing.T) {
 	assert.Nil(svc)
 	assert.NotNil(deployment)
 	assert.Equal(deployment.Get("kind").String(), "Deployment")
-	assert.Equal(deployment.Get("metadata", "name").String(), "some-group")
+	assert.Equal(deployment.Get("metadata", "labels", RoleNameLabel).String(), "some-group")
 
 	t.Run("Defaults", func(t *testing.T) {
 		t.Parallel()
@@ -628,18 +825,20 @@ func TestNewDeploymentWithEmptyDirVolume(t *testing.T) {
 	t.Run("Configured", func(t *testing.T) {
 		t.Parallel()
 		config := map[string]interface{}{
-			"Values.sizing.some_group.affinity":    map[string]interface{}{},
-			"Values.sizing.some_group.count":       "1",
-			"Values.kube.registry.hostname":        "docker.suse.fake",
-			"Values.kube.organization":             "splat",
-			"Values.env.KUBERNETES_CLUSTER_DOMAIN": "cluster.local",
+			"Values.sizing.colocated.debug.command":  nil,
+			"Values.sizing.some_group.affinity":      map[string]interface{}{},
+			"Values.sizing.some_group.debug.command": nil,
+			"Values.sizing.some_group.count":         "1",
+			"Values.kube.registry.hostname":          "docker.suse.fake",
+			"Values.kube.organization":               "splat",
+			"Values.env.KUBERNETES_CLUSTER_DOMAIN":   "cluster.local",
 		}
 
 		actual, err := RoundtripNode(deployment, config)
 		if !assert.NoError(err) {
 			return
 		}
-		testhelpers.IsYAMLSubsetString(assert, `---
+		yamltest.IsYAMLSubsetString(assert, `---
 			apiVersion: "apps/v1"
 			kind: "Deployment"
 			spec:
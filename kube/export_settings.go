@@ -1,23 +1,111 @@
 package kube
 
 import (
+	"fmt"
+
 	"code.cloudfoundry.org/fissile/model"
 )
 
 // ExportSettings are configuration for creating Kubernetes configs
 type ExportSettings struct {
-	OutputDir       string
-	Repository      string
-	Registry        string
-	Username        string
-	Password        string
-	Organization    string
-	UseMemoryLimits bool
-	UseCPULimits    bool
-	FissileVersion  string
-	TagExtra        string
-	RoleManifest    *model.RoleManifest
-	Opinions        *model.Opinions
-	CreateHelmChart bool
-	AuthType        string
+	OutputDir           string
+	Repository          string
+	Registry            string
+	Username            string
+	Password            string
+	Organization        string
+	UseMemoryLimits     bool
+	UseCPULimits        bool
+	FissileVersion      string
+	TagExtra            string
+	RoleManifest        *model.RoleManifest
+	Opinions            *model.Opinions
+	CreateHelmChart     bool
+	AuthType            string
+	IntegrationSnippets []string
+	// IntegrationDir is the directory the integration snippets are written
+	// to; it must not be inside the chart, so they are not packaged with it
+	IntegrationDir      string
+	CreateKustomization bool
+	CreateHelperScripts bool
+	SecretStringData    bool
+	StreamOutput        string
+	Render              *RenderOptions
+	ExtensionSnippets   map[string]string
+	AuditClusterScope   bool
+	PolicyBundle        bool
+	// ImageDigests are the digests of the role images by instance group;
+	// if set, the images are referenced by digest
+	ImageDigests map[string]string
+	// HelmVersion is the major version of helm the chart is generated for;
+	// helm 3 charts have a Chart.yaml named ChartName, of version ChartVersion
+	HelmVersion  int
+	ChartName    string
+	ChartVersion string
+	// LocalVolumes maps the persistent volumes onto directories of the nodes,
+	// to write local persistent volumes for
+	LocalVolumes *LocalVolumes
+	// InstanceGroupFilter selects the instance groups to (re)generate; if it
+	// is active, nothing else but their RBAC resources is generated
+	InstanceGroupFilter InstanceGroupFilter
+	// QuarksDeployment is the name of the BOSH deployment of the custom
+	// resources of the cf-operator; if set, the instance groups are wrapped
+	// into QuarksStatefulSets and QuarksJobs, and a BOSHDeployment is written
+	QuarksDeployment string
+	// CreateApplyPlan writes an apply plan, listing the configuration files
+	// in dependency order with the workloads to wait for, and a script
+	// executing it with kubectl
+	CreateApplyPlan bool
+	// LintTemplates parses and executes the templates of a helm chart with
+	// its default values while writing them, failing on invalid expressions
+	LintTemplates bool
+	// SecretBackend is the backend the containers get the secret variables
+	// from; helm charts generated for a Vault backend support all backends
+	SecretBackend string
+}
+
+// InstanceGroupFilter selects instance groups by name. All instance groups
+// are selected unless some are included; excluded ones are never selected.
+type InstanceGroupFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Active returns true if the filter doesn't select all instance groups
+func (filter InstanceGroupFilter) Active() bool {
+	return len(filter.Include) > 0 || len(filter.Exclude) > 0
+}
+
+// Selects returns true if the named instance group is selected
+func (filter InstanceGroupFilter) Selects(name string) bool {
+	for _, excluded := range filter.Exclude {
+		if excluded == name {
+			return false
+		}
+	}
+	if len(filter.Include) == 0 {
+		return true
+	}
+	for _, included := range filter.Include {
+		if included == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an error if the filter names instance groups missing from
+// the role manifest, or colocated containers, which are generated as part of
+// other instance groups
+func (filter InstanceGroupFilter) Validate(roleManifest *model.RoleManifest) error {
+	for _, name := range append(append([]string{}, filter.Include...), filter.Exclude...) {
+		instanceGroup := roleManifest.LookupInstanceGroup(name)
+		if instanceGroup == nil {
+			return fmt.Errorf("Instance group filter: unknown instance group %s", name)
+		}
+		if instanceGroup.IsColocated() {
+			return fmt.Errorf("Instance group filter: %s is a colocated container; filter the instance groups using it instead", name)
+		}
+	}
+	return nil
 }
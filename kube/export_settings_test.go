@@ -0,0 +1,35 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceGroupFilter(t *testing.T) {
+	t.Parallel()
+
+	filter := InstanceGroupFilter{}
+	assert.False(t, filter.Active())
+	assert.True(t, filter.Selects("nats"))
+
+	filter = InstanceGroupFilter{Include: []string{"nats", "api"}, Exclude: []string{"api"}}
+	assert.True(t, filter.Active())
+	assert.True(t, filter.Selects("nats"))
+	assert.False(t, filter.Selects("api"), "Excluded instance groups should not be selected")
+	assert.False(t, filter.Selects("router"), "Instance groups not included should not be selected")
+
+	filter = InstanceGroupFilter{Exclude: []string{"api"}}
+	assert.True(t, filter.Selects("router"))
+	assert.False(t, filter.Selects("api"))
+
+	nats := &model.InstanceGroup{Name: "nats"}
+	sidecar := &model.InstanceGroup{Name: "sidecar", Type: model.RoleTypeColocatedContainer}
+	roleManifest := &model.RoleManifest{InstanceGroups: model.InstanceGroups{nats, sidecar}}
+	assert.NoError(t, InstanceGroupFilter{Include: []string{"nats"}}.Validate(roleManifest))
+	assert.EqualError(t, InstanceGroupFilter{Exclude: []string{"api"}}.Validate(roleManifest),
+		"Instance group filter: unknown instance group api")
+	assert.EqualError(t, InstanceGroupFilter{Include: []string{"sidecar"}}.Validate(roleManifest),
+		"Instance group filter: sidecar is a colocated container; filter the instance groups using it instead")
+}
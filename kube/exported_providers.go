@@ -0,0 +1,126 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// MakeExportedProviders returns a ConfigMap documenting the BOSH link
+// providers exported by the instance groups, so that other charts and
+// automation can find the services generated for them. It holds a YAML
+// document for each provider, keyed by its (aliased) name:
+//
+//	instance_group: nats-server
+//	job: nats
+//	type: nats
+//	service: nats-server-nats
+//	ports:
+//	- name: nats
+//	  port: 4222
+//	  protocol: TCP
+//
+// The service and ports are omitted for jobs without ports, which have no
+// service. It returns nil if no provider is exported.
+func MakeExportedProviders(settings ExportSettings) (helm.Node, error) {
+	if !settings.CreateHelmChart {
+		return nil, fmt.Errorf("Exported providers require a helm chart")
+	}
+
+	// The documents are quoted strings, so the name prefix of the services
+	// is spelled out instead of using the fissile.Name template
+	prefix := `{{ if .Values.name_prefix.enabled }}{{ default .Release.Name .Values.name_prefix.prefix }}-{{ end }}`
+
+	data := helm.NewMapping()
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		if instanceGroup.IsColocated() {
+			continue
+		}
+		if instanceGroup.Run != nil && instanceGroup.Run.FlightStage == model.FlightStageManual {
+			continue
+		}
+		for _, job := range instanceGroup.JobReferences {
+			var names []string
+			for name := range job.ExportedProvides {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				alias := name
+				if provider := job.ExportedProvides[name]; provider.Alias != "" {
+					alias = provider.Alias
+				}
+				var providerType string
+				if job.Job != nil {
+					providerType = job.Job.AvailableProviders[name].Type
+				}
+				var document bytes.Buffer
+				fmt.Fprintf(&document, "instance_group: %s\njob: %s\n", instanceGroup.Name, job.Name)
+				if providerType != "" {
+					fmt.Fprintf(&document, "type: %s\n", providerType)
+				}
+				// Jobs without ports have no service
+				if len(job.ContainerProperties.BoshContainerization.Ports) > 0 {
+					fmt.Fprintf(&document, "service: %s%s\n", prefix, jobServiceName(instanceGroup, job))
+					writeExportedPorts(&document, instanceGroup, job)
+				}
+
+				var modifiers []helm.NodeModifier
+				if block := featureCheckBlock(instanceGroup); block != "" {
+					modifiers = append(modifiers, helm.Block(block))
+				}
+				data.Add(alias+".yaml", document.String(), modifiers...)
+			}
+		}
+	}
+	if len(data.Names()) == 0 {
+		return nil, nil
+	}
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("v1").
+		SetKind("ConfigMap").
+		SetName("exported-providers").
+		AddModifier(helm.Comment("Services of the BOSH link providers exported by the instance groups"))
+	configMap, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	configMap.Add("data", data.Sort())
+
+	return configMap, nil
+}
+
+// writeExportedPorts writes the ports of the service of the job, matching
+// the ports generated by newService. Ports whose count is configurable are
+// listed once, with the first port number and the count.
+func writeExportedPorts(document *bytes.Buffer, instanceGroup *model.InstanceGroup, job *model.JobReference) {
+	document.WriteString("ports:\n")
+	for _, port := range job.ContainerProperties.BoshContainerization.Ports {
+		sizing := fmt.Sprintf(".Values.sizing.%s.ports.%s", makeVarName(instanceGroup.Name), makeVarName(port.Name))
+		portNumber := func(index int) string {
+			if port.PortIsConfigurable {
+				return fmt.Sprintf("{{ add (int %s.port) %d }}", sizing, index)
+			}
+			return fmt.Sprintf("%d", port.ExternalPort+index)
+		}
+
+		if port.CountIsConfigurable {
+			fmt.Fprintf(document, "- name: %s\n  port: %s\n  protocol: %s\n  count: {{ int %s.count }}\n",
+				port.KubeName(), portNumber(0), port.Protocol, sizing)
+			continue
+		}
+		for index := 0; index < port.Count; index++ {
+			name := port.KubeName()
+			if port.Max > 1 {
+				name = fmt.Sprintf("%s-%d", name, index)
+			}
+			fmt.Fprintf(document, "- name: %s\n  port: %s\n  protocol: %s\n", name, portNumber(index), port.Protocol)
+		}
+	}
+}
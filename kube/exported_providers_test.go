@@ -0,0 +1,67 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeExportedProviders(t *testing.T) {
+	t.Parallel()
+
+	_, err := MakeExportedProviders(ExportSettings{})
+	assert.Error(t, err, "Should require a helm chart")
+
+	configMap, err := MakeExportedProviders(testExportSettings(t, "exposed-ports.yml"))
+	require.NoError(t, err)
+	assert.Nil(t, configMap, "Should not be generated without exported providers")
+
+	configMap, err = MakeExportedProviders(testExportSettings(t, "exported-providers.yml"))
+	require.NoError(t, err)
+	require.NotNil(t, configMap)
+
+	t.Run("FeatureDisabled", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(configMap, map[string]interface{}{
+			"Values.sizing.nats_server.ports.route.port": 5000,
+			"Values.enable.database":                     false,
+		})
+		require.NoError(t, err)
+
+		mapping := actual.(map[interface{}]interface{})
+		assert.Equal(t, "exported-providers", mapping["metadata"].(map[interface{}]interface{})["name"])
+		assert.Equal(t, map[interface{}]interface{}{
+			"message-bus.yaml": `instance_group: nats-server
+job: ntpd
+type: ntpd
+service: nats-server-ntpd
+ports:
+- name: nats
+  port: 4222
+  protocol: TCP
+- name: route
+  port: 5000
+  protocol: TCP
+`,
+		}, mapping["data"])
+	})
+
+	t.Run("FeatureEnabled", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(configMap, map[string]interface{}{
+			"Values.sizing.nats_server.ports.route.port": 5000,
+			"Values.enable.database":                     true,
+			"Values.name_prefix.enabled":                 true,
+			"Release.Name":                               "rel",
+		})
+		require.NoError(t, err)
+
+		data := actual.(map[interface{}]interface{})["data"].(map[interface{}]interface{})
+		assert.Equal(t, `instance_group: database
+job: ntpd
+type: ntp
+`, data["ntp-client.yaml"])
+		assert.Contains(t, data["message-bus.yaml"], "service: rel-nats-server-ntpd\n")
+	})
+}
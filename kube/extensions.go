@@ -0,0 +1,152 @@
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+)
+
+// Extension points of the generated pod templates. Each one is a named
+// template in the helpers of the chart, producing YAML from the values by
+// default; a snippet can replace the definition to insert custom logic.
+const (
+	ExtensionExtraVolumes    = "extraVolumes"
+	ExtensionExtraEnv        = "extraEnv"
+	ExtensionExtraContainers = "extraContainers"
+	ExtensionPodAnnotations  = "podAnnotations"
+)
+
+// extensionValues maps the extension points to the values keys (below
+// `extensions`) read by their default definition.
+var extensionValues = map[string]string{
+	ExtensionExtraVolumes:    "extra_volumes",
+	ExtensionExtraEnv:        "extra_env",
+	ExtensionExtraContainers: "extra_containers",
+	ExtensionPodAnnotations:  "pod_annotations",
+}
+
+// ExtensionPoints returns the names of all extension points, sorted.
+func ExtensionPoints() []string {
+	var points []string
+	for point := range extensionValues {
+		points = append(points, point)
+	}
+	sort.Strings(points)
+	return points
+}
+
+// extensionTemplateName returns the name of the helper template implementing
+// the extension point.
+func extensionTemplateName(point string) string {
+	return "fissile.extension." + point
+}
+
+// extensionContext returns the template expression of the data passed to an
+// extension point. Besides the usual top level objects, it holds the name of
+// the instance group of the pod.
+func extensionContext(instanceGroupName string) string {
+	return fmt.Sprintf(`(dict "Values" $.Values "Release" $.Release "Chart" $.Chart "Capabilities" $.Capabilities "InstanceGroup" %q)`,
+		instanceGroupName)
+}
+
+// extensionListBlock returns the block action iterating over the list
+// elements produced by the extension point for the instance group. The list
+// is wrapped into a mapping, as `fromYaml` only parses mappings.
+func extensionListBlock(point, instanceGroupName string) helm.NodeModifier {
+	return helm.Block(fmt.Sprintf(`range (printf "items:\n%%s" (include %q %s | indent 2) | fromYaml).items`,
+		extensionTemplateName(point), extensionContext(instanceGroupName)))
+}
+
+// addExtensionListElements appends the elements of a list extension point
+// to the list, as JSON flow mappings.
+func addExtensionListElements(list *helm.List, point, instanceGroupName string) {
+	list.Add(helm.NewNode("{{ toJson . }}", extensionListBlock(point, instanceGroupName)))
+}
+
+// addExtensionMappingEntries adds the entries of a mapping extension point
+// to the mapping, with quoted values.
+func addExtensionMappingEntries(mapping *helm.Mapping, point, instanceGroupName string) {
+	mapping.Add("{{ $key }}", "{{ $value | quote }}",
+		helm.Block(fmt.Sprintf(`range $key, $value := (include %q %s | fromYaml)`,
+			extensionTemplateName(point), extensionContext(instanceGroupName))))
+}
+
+// GetHelmExtensionHelpers returns the helper templates with the default
+// definitions of the extension points, reading from the `extensions` values.
+// Extension points implemented by a snippet are left out; their definitions
+// are part of MakeExtensionSnippetsTemplate instead.
+func GetHelmExtensionHelpers(snippets map[string]string) []helm.Node {
+	var helpers []helm.Node
+	for _, point := range ExtensionPoints() {
+		if _, ok := snippets[point]; ok {
+			continue
+		}
+		name := extensionTemplateName(point)
+		key := extensionValues[point]
+		helpers = append(helpers, helm.NewNode(
+			fmt.Sprintf("{{ define %q }}{{- with .Values.extensions.%s }}{{ toYaml . }}{{ end }}{{ end }}", name, key),
+			helm.Comment(fmt.Sprintf("%s returns .Values.extensions.%s", name, key))))
+	}
+	return helpers
+}
+
+// MakeExtensionSnippetsTemplate returns a template file defining the extension
+// points implemented by the snippets. The snippets are copied verbatim, as
+// helm nodes cannot hold multi-line templates.
+func MakeExtensionSnippetsTemplate(snippets map[string]string) string {
+	var buf strings.Builder
+	for _, point := range ExtensionPoints() {
+		snippet, ok := snippets[point]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&buf, "{{- define %q }}\n%s{{ end }}\n", extensionTemplateName(point), snippet)
+	}
+	return buf.String()
+}
+
+// ReadExtensionSnippets reads the snippets overriding extension points from
+// a directory. Each file is named after the extension point it implements,
+// with a .yaml extension, e.g. extraVolumes.yaml.
+func ReadExtensionSnippets(dir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snippets := map[string]string{}
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		point := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		if _, ok := extensionValues[point]; !ok || filepath.Ext(file.Name()) != ".yaml" {
+			return nil, fmt.Errorf("Unknown extension snippet %s; must be one of %s with a .yaml extension",
+				filepath.Join(dir, file.Name()), strings.Join(ExtensionPoints(), ", "))
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		snippets[point] = string(contents)
+	}
+	return snippets, nil
+}
+
+// makeExtensionValues returns the values read by the default definitions of
+// the extension points.
+func makeExtensionValues() helm.Node {
+	return helm.NewNode(helm.NewMapping(
+		extensionValues[ExtensionExtraVolumes], helm.NewNode(helm.NewList(), helm.Comment("Volumes added to the pods of all instance groups")),
+		extensionValues[ExtensionExtraEnv], helm.NewNode(helm.NewList(), helm.Comment("Environment variables added to the main container of all instance groups")),
+		extensionValues[ExtensionExtraContainers], helm.NewNode(helm.NewList(), helm.Comment("Containers added to the pods of all instance groups")),
+		extensionValues[ExtensionPodAnnotations], helm.NewNode(helm.NewMapping(), helm.Comment("Annotations added to the pods of all instance groups")),
+	), helm.Comment(strings.Join(strings.Fields(`
+		Values of the extension points of the pod templates; a snippets directory
+		passed to fissile may replace how they are computed.
+	`), " ")))
+}
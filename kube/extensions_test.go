@@ -0,0 +1,67 @@
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestReadExtensionSnippets(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "fissile-extension-snippets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	snippet := "- name: {{ .InstanceGroup }}-extra\n  emptyDir: {}\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "extraVolumes.yaml"), []byte(snippet), 0644))
+	snippets, err := ReadExtensionSnippets(dir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{ExtensionExtraVolumes: snippet}, snippets)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "extraVolumes.yml"), []byte(snippet), 0644))
+	_, err = ReadExtensionSnippets(dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Unknown extension snippet")
+	assert.Contains(t, err.Error(), "extraContainers, extraEnv, extraVolumes, podAnnotations")
+}
+
+func TestExtensionSnippetOverride(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	role := podTestLoadRole(assert, "pre-role")
+	if role == nil {
+		return
+	}
+
+	settings := renderTestSettings(t, nil)
+	settings.RoleManifest = role.Manifest()
+	settings.Opinions = model.NewEmptyOpinions()
+	settings.ExtensionSnippets = map[string]string{
+		ExtensionExtraVolumes:   "- name: {{ .InstanceGroup }}-extra\n  emptyDir: {}\n",
+		ExtensionPodAnnotations: `release: {{ .Release.Name }}`,
+	}
+	renderer, err := NewRenderer(settings)
+	require.NoError(t, err)
+
+	_, err = renderer.Render("templates/secrets.yaml", renderTestSecrets(t, settings))
+	require.NoError(t, err)
+	podTemplate, err := NewPodTemplate(role, settings, nil)
+	require.NoError(t, err)
+	output, err := renderer.Render("templates/pod.yaml", podTemplate)
+	require.NoError(t, err)
+
+	var actual map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(output, &actual))
+	spec := actual["spec"].(map[interface{}]interface{})
+	volumes := spec["volumes"].([]interface{})
+	assert.Equal(map[interface{}]interface{}{"name": "pre-role-extra", "emptyDir": map[interface{}]interface{}{}}, volumes[len(volumes)-1])
+	annotations := actual["metadata"].(map[interface{}]interface{})["annotations"].(map[interface{}]interface{})
+	assert.Equal("my-release", annotations["release"])
+}
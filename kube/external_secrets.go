@@ -0,0 +1,211 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/util"
+)
+
+// externalSecretsName is the name of the Kubernetes secret the secret
+// variables read from the external secret store are synced into, and of the
+// resource syncing them
+const externalSecretsName = "external-secrets"
+
+// externalSecretsVolumeName is the name of the CSI volume of the secrets
+// synced by the SecretProviderClass
+const externalSecretsVolumeName = "external-secrets"
+
+// isExternalSecret returns true if the secret variable is read from the
+// external secret store of the role manifest
+func isExternalSecret(cv *model.VariableDefinition, roleManifest *model.RoleManifest) bool {
+	return cv.CVOptions.ExternalKey != "" && roleManifest != nil && roleManifest.ExternalSecrets != nil
+}
+
+// makeExternalSecretVar returns the environment variable referring to the
+// secret variable synced from the external secret store
+func makeExternalSecretVar(name string, settings ExportSettings) helm.Node {
+	secretKeyRef := helm.NewMapping(
+		"key", util.ConvertNameToKey(name),
+		"name", resourceName(externalSecretsName, settings))
+	return helm.NewMapping("name", name, "valueFrom", helm.NewMapping("secretKeyRef", secretKeyRef))
+}
+
+// NeedsSecretGeneration returns true unless the role manifest has an external
+// secret store, and all secret variables with a generator are read from it,
+// so that the secret generation job has nothing to do
+func NeedsSecretGeneration(settings ExportSettings) bool {
+	if settings.RoleManifest.ExternalSecrets == nil {
+		return true
+	}
+	for _, cv := range settings.RoleManifest.Variables {
+		if cv.CVOptions.Secret && cv.Type != "" && !isExternalSecret(cv, settings.RoleManifest) {
+			return true
+		}
+	}
+	return false
+}
+
+// MakeExternalSecrets returns the ExternalSecret or SecretProviderClass
+// syncing the secret variables with an external key from the external secret
+// store of the role manifest into the external-secrets Kubernetes secret, or
+// nil if there are none.
+func MakeExternalSecrets(settings ExportSettings) (helm.Node, error) {
+	external := settings.RoleManifest.ExternalSecrets
+	variables := settings.RoleManifest.ExternalSecretVariables()
+	if len(variables) == 0 {
+		return nil, nil
+	}
+	sort.Slice(variables, func(i, j int) bool { return variables[i].Name < variables[j].Name })
+
+	switch external.Type {
+	case model.ExternalSecretsTypeExternalSecret:
+		return makeExternalSecret(external, variables, settings)
+	case model.ExternalSecretsTypeSecretProviderClass:
+		return makeExternalSecretProviderClass(external, variables, settings)
+	}
+	return nil, fmt.Errorf("Unsupported external secrets type %s", external.Type)
+}
+
+// makeExternalSecret returns the ExternalSecret of external-secrets.io,
+// reading each variable from the key in the secret store
+func makeExternalSecret(external *model.ExternalSecrets, variables model.Variables, settings ExportSettings) (helm.Node, error) {
+	data := helm.NewList()
+	for _, cv := range variables {
+		data.Add(helm.NewMapping(
+			"secretKey", util.ConvertNameToKey(cv.Name),
+			"remoteRef", helm.NewMapping("key", cv.CVOptions.ExternalKey)))
+	}
+
+	storeKind := external.StoreKind
+	if storeKind == "" {
+		storeKind = "SecretStore"
+	}
+	refreshInterval := external.RefreshInterval
+	if refreshInterval == "" {
+		refreshInterval = "1h"
+	}
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("external-secrets.io/v1beta1").
+		SetKind("ExternalSecret").
+		SetName(externalSecretsName)
+	externalSecret, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	spec := helm.NewMapping()
+	spec.Add("refreshInterval", refreshInterval)
+	spec.Add("secretStoreRef", helm.NewMapping("name", external.Store, "kind", storeKind))
+	spec.Add("target", helm.NewMapping(
+		"name", resourceName(externalSecretsName, settings),
+		"creationPolicy", "Owner"))
+	spec.Add("data", data)
+	externalSecret.Add("spec", spec)
+
+	return externalSecret.Sort(), nil
+}
+
+// makeExternalSecretProviderClass returns the SecretProviderClass of the
+// secrets store CSI driver, listing each variable as an object of the
+// provider, aliased to its key in the synced secret
+func makeExternalSecretProviderClass(external *model.ExternalSecrets, variables model.Variables, settings ExportSettings) (helm.Node, error) {
+	parameters := helm.NewMapping()
+	for name, value := range external.Parameters {
+		parameters.Add(name, value)
+	}
+
+	var objects interface{}
+	data := helm.NewList()
+	switch external.Provider {
+	case model.ExternalSecretsProviderAWS:
+		var list []map[string]string
+		for _, cv := range variables {
+			list = append(list, map[string]string{
+				"objectName":  cv.CVOptions.ExternalKey,
+				"objectType":  "secretsmanager",
+				"objectAlias": util.ConvertNameToKey(cv.Name),
+			})
+		}
+		objects = list
+	case model.ExternalSecretsProviderAzure:
+		// Azure lists the objects as YAML documents in an array
+		var array []string
+		for _, cv := range variables {
+			array = append(array, fmt.Sprintf("objectName: %s\nobjectType: secret\nobjectAlias: %s\n",
+				cv.CVOptions.ExternalKey, util.ConvertNameToKey(cv.Name)))
+		}
+		objects = map[string][]string{"array": array}
+	default:
+		return nil, fmt.Errorf("Unsupported secret provider %s", external.Provider)
+	}
+	buf, err := json.Marshal(objects)
+	if err != nil {
+		return nil, err
+	}
+	parameters.Add("objects", string(buf))
+
+	for _, cv := range variables {
+		key := util.ConvertNameToKey(cv.Name)
+		data.Add(helm.NewMapping("objectName", key, "key", key))
+	}
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("secrets-store.csi.x-k8s.io/v1").
+		SetKind("SecretProviderClass").
+		SetName(externalSecretsName)
+	providerClass, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	spec := helm.NewMapping("provider", external.Provider)
+	spec.Add("parameters", parameters.Sort())
+	spec.Add("secretObjects", helm.NewList(helm.NewMapping(
+		"secretName", resourceName(externalSecretsName, settings),
+		"type", "Opaque",
+		"data", data)))
+	providerClass.Add("spec", spec)
+
+	return providerClass.Sort(), nil
+}
+
+// addExternalSecretsVolume mounts the CSI volume of the SecretProviderClass
+// into the containers of an instance group using secrets read from the
+// external secret store; the driver only syncs the secrets while they are
+// mounted
+func addExternalSecretsVolume(role *model.InstanceGroup, containers []*helm.Mapping, volumes *helm.List, settings ExportSettings) error {
+	external := role.Manifest().ExternalSecrets
+	if external == nil || external.Type != model.ExternalSecretsTypeSecretProviderClass {
+		return nil
+	}
+	used := false
+	for _, candidate := range append([]*model.InstanceGroup{role}, role.GetColocatedRoles()...) {
+		variables, err := candidate.GetVariablesForRole()
+		if err != nil {
+			return err
+		}
+		for _, cv := range variables {
+			if cv.CVOptions.Secret && isExternalSecret(cv, role.Manifest()) {
+				used = true
+			}
+		}
+	}
+	if !used {
+		return nil
+	}
+
+	volumes.Add(helm.NewMapping("name", externalSecretsVolumeName, "csi", helm.NewMapping(
+		"driver", "secrets-store.csi.k8s.io",
+		"readOnly", true,
+		"volumeAttributes", helm.NewMapping("secretProviderClass", resourceName(externalSecretsName, settings)))))
+	for _, container := range containers {
+		mount := helm.NewMapping("mountPath", "/mnt/external-secrets", "name", externalSecretsVolumeName, "readOnly", true)
+		container.Get("volumeMounts").(*helm.List).Add(mount)
+	}
+	return nil
+}
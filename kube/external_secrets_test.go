@@ -0,0 +1,156 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func externalSecretsTestSettings(t *testing.T, external *model.ExternalSecrets) ExportSettings {
+	settings := testExportSettings(t, "external-secrets.yml")
+	settings.CreateHelmChart = false
+	settings.RoleManifest.ExternalSecrets = external
+	return settings
+}
+
+func TestMakeExternalSecrets(t *testing.T) {
+	t.Parallel()
+
+	t.Run("None", func(t *testing.T) {
+		t.Parallel()
+		node, err := MakeExternalSecrets(externalSecretsTestSettings(t, nil))
+		require.NoError(t, err)
+		assert.Nil(t, node)
+	})
+
+	t.Run("ExternalSecret", func(t *testing.T) {
+		t.Parallel()
+		settings := externalSecretsTestSettings(t, &model.ExternalSecrets{
+			Type:      model.ExternalSecretsTypeExternalSecret,
+			Store:     "aws-secrets-manager",
+			StoreKind: "ClusterSecretStore",
+		})
+		node, err := MakeExternalSecrets(settings)
+		require.NoError(t, err)
+		actual, err := RoundtripKube(node)
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			apiVersion: external-secrets.io/v1beta1
+			kind: ExternalSecret
+			metadata:
+				name: external-secrets
+				labels:
+					app.kubernetes.io/component: external-secrets
+			spec:
+				refreshInterval: 1h
+				secretStoreRef:
+					name: aws-secrets-manager
+					kind: ClusterSecretStore
+				target:
+					name: external-secrets
+					creationPolicy: Owner
+				data:
+				-	secretKey: admin-password
+					remoteRef:
+						key: prod/admin
+				-	secretKey: db-password
+					remoteRef:
+						key: prod/db/password
+		`, actual)
+	})
+
+	t.Run("SecretProviderClassAWS", func(t *testing.T) {
+		t.Parallel()
+		settings := externalSecretsTestSettings(t, &model.ExternalSecrets{
+			Type:       model.ExternalSecretsTypeSecretProviderClass,
+			Provider:   model.ExternalSecretsProviderAWS,
+			Parameters: map[string]string{"region": "eu-central-1"},
+		})
+		settings.CreateHelmChart = true
+		node, err := MakeExternalSecrets(settings)
+		require.NoError(t, err)
+		actual, err := RoundtripNode(node, nil)
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			apiVersion: secrets-store.csi.x-k8s.io/v1
+			kind: SecretProviderClass
+			metadata:
+				name: external-secrets
+			spec:
+				provider: aws
+				parameters:
+					region: eu-central-1
+					objects: '[{"objectAlias":"admin-password","objectName":"prod/admin","objectType":"secretsmanager"},{"objectAlias":"db-password","objectName":"prod/db/password","objectType":"secretsmanager"}]'
+				secretObjects:
+				-	secretName: external-secrets
+					type: Opaque
+					data:
+					-	objectName: admin-password
+						key: admin-password
+					-	objectName: db-password
+						key: db-password
+		`, actual)
+	})
+
+	t.Run("SecretProviderClassAzure", func(t *testing.T) {
+		t.Parallel()
+		settings := externalSecretsTestSettings(t, &model.ExternalSecrets{
+			Type:       model.ExternalSecretsTypeSecretProviderClass,
+			Provider:   model.ExternalSecretsProviderAzure,
+			Parameters: map[string]string{"keyvaultName": "scf", "tenantId": "tenant"},
+		})
+		node, err := MakeExternalSecrets(settings)
+		require.NoError(t, err)
+		actual, err := RoundtripKube(node.Get("spec", "parameters"))
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			keyvaultName: scf
+			tenantId: tenant
+			objects: '{"array":["objectName: prod/admin\nobjectType: secret\nobjectAlias: admin-password\n","objectName: prod/db/password\nobjectType: secret\nobjectAlias: db-password\n"]}'
+		`, actual)
+	})
+}
+
+func TestExternalSecretVars(t *testing.T) {
+	t.Parallel()
+
+	settings := externalSecretsTestSettings(t, &model.ExternalSecrets{
+		Type:  model.ExternalSecretsTypeExternalSecret,
+		Store: "store",
+	})
+	ev, err := getEnvVarsFromConfigs(settings.RoleManifest.Variables, settings)
+	require.NoError(t, err)
+	actual, err := RoundtripKube(helm.NewNode(ev))
+	require.NoError(t, err)
+	secretNames := map[interface{}]interface{}{}
+	for _, envVar := range actual.([]interface{}) {
+		envVar := envVar.(map[interface{}]interface{})
+		if valueFrom, ok := envVar["valueFrom"].(map[interface{}]interface{}); ok && valueFrom["secretKeyRef"] != nil {
+			secretNames[envVar["name"]] = valueFrom["secretKeyRef"].(map[interface{}]interface{})["name"]
+		}
+	}
+	assert.Equal(t, map[interface{}]interface{}{
+		"DB_PASSWORD":    "external-secrets",
+		"ADMIN_PASSWORD": "external-secrets",
+		"LOCAL_SECRET":   "secrets",
+	}, secretNames)
+
+	cvs := model.CVMap{}
+	for _, cv := range settings.RoleManifest.Variables {
+		cvs[cv.Name] = cv
+	}
+	secrets, err := MakeSecrets(cvs, settings)
+	require.NoError(t, err)
+	actual, err = RoundtripKube(secrets.Get("data"))
+	require.NoError(t, err)
+	assert.Equal(t, map[interface{}]interface{}{"local-secret": ""}, actual,
+		"Only the secrets missing from the external store should be in the chart's secret")
+
+	assert.False(t, NeedsSecretGeneration(settings), "The only generated secret is read from the external store")
+	settings.RoleManifest.ExternalSecrets = nil
+	assert.True(t, NeedsSecretGeneration(settings))
+}
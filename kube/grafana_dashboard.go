@@ -0,0 +1,158 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// grafanaDashboardName is the name of the ConfigMap holding the dashboard
+const grafanaDashboardName = "grafana-dashboard"
+
+// grafanaPanelsPlaceholder marks the position of the panels in the
+// serialized dashboard, as they are wrapped in the feature blocks of their
+// instance groups and so can't be serialized along with it
+const grafanaPanelsPlaceholder = "FISSILE_PANELS"
+
+// MakeGrafanaDashboard returns a ConfigMap holding a Grafana dashboard for
+// the release, labelled for the dashboard loader sidecar of the Grafana chart.
+// It has a row for every instance group, showing the phases of its pods, their
+// restarts, and their CPU and memory usage compared to the requests from the
+// sizing values. The usage is taken from the cAdvisor metrics, and the pods
+// from kube-state-metrics. It is only created if
+// .Values.grafana_dashboard.enabled is set.
+func MakeGrafanaDashboard(settings ExportSettings) (helm.Node, error) {
+	if !settings.CreateHelmChart {
+		return nil, fmt.Errorf("Grafana dashboard requires a helm chart")
+	}
+
+	// The dashboard is a quoted string, so the name prefix of the pods is
+	// spelled out instead of using the fissile.Name template
+	prefix := `{{ if .Values.name_prefix.enabled }}{{ default .Release.Name .Values.name_prefix.prefix }}-{{ end }}`
+
+	var panels strings.Builder
+	overview, err := json.Marshal(grafanaPanel("Pods of the release", 0, 0, 24,
+		grafanaTarget(`sum by (phase) (kube_pod_status_phase{namespace="{{ .Release.Namespace }}", pod=~"`+prefix+`.*"})`, "phase")))
+	if err != nil {
+		return nil, err
+	}
+	panels.Write(overview)
+
+	row := 0
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		if instanceGroup.Type != model.RoleTypeBosh || instanceGroup.Run == nil ||
+			instanceGroup.IsColocated() || instanceGroup.Run.FlightStage == model.FlightStageManual {
+			continue
+		}
+		row++
+		y := row * 9
+		selector := fmt.Sprintf(`namespace="{{ .Release.Namespace }}", pod=~"%s%s-[0-9]+"`, prefix, instanceGroup.Name)
+		usage := fmt.Sprintf(`%s, container!="", container!="POD"`, selector)
+		sizing := fmt.Sprintf(".Values.sizing.%s", makeVarName(instanceGroup.Name))
+
+		cpu := grafanaPanel(instanceGroup.Name+" CPU (cores)", 12, y, 6,
+			grafanaTarget(fmt.Sprintf(`sum by (pod) (rate(container_cpu_usage_seconds_total{%s}[5m]))`, usage), "pod"))
+		memory := grafanaPanel(instanceGroup.Name+" memory (bytes)", 18, y, 6,
+			grafanaTarget(fmt.Sprintf(`sum by (pod) (container_memory_working_set_bytes{%s})`, usage), "pod"))
+		if settings.UseCPULimits {
+			cpu["targets"] = append(cpu["targets"].([]map[string]interface{}), grafanaRequestTarget(
+				fmt.Sprintf("and .Values.config.cpu.requests %s.cpu.request", sizing),
+				fmt.Sprintf("vector({{ %s.cpu.request }} / 1000)", sizing)))
+		}
+		if settings.UseMemoryLimits {
+			memory["targets"] = append(memory["targets"].([]map[string]interface{}), grafanaRequestTarget(
+				fmt.Sprintf("and .Values.config.memory.requests %s.memory.request", sizing),
+				fmt.Sprintf("vector({{ %s.memory.request }} * 1048576)", sizing)))
+		}
+
+		groupPanels := []map[string]interface{}{
+			grafanaPanel(instanceGroup.Name+" pods", 0, y, 6,
+				grafanaTarget(fmt.Sprintf(`sum by (phase) (kube_pod_status_phase{%s})`, selector), "phase")),
+			grafanaPanel(instanceGroup.Name+" restarts (1h)", 6, y, 6,
+				grafanaTarget(fmt.Sprintf(`sum by (pod) (increase(kube_pod_container_status_restarts_total{%s}[1h]))`, selector), "pod")),
+			cpu,
+			memory,
+		}
+
+		block := featureCheckBlock(instanceGroup)
+		if block != "" {
+			fmt.Fprintf(&panels, "{{ %s }}", block)
+		}
+		for _, panel := range groupPanels {
+			buf, err := json.Marshal(panel)
+			if err != nil {
+				return nil, err
+			}
+			panels.WriteString(",")
+			panels.Write(buf)
+		}
+		if block != "" {
+			panels.WriteString("{{ end }}")
+		}
+	}
+
+	dashboard, err := json.Marshal(map[string]interface{}{
+		"title":         "{{ .Release.Name }} instance groups",
+		"tags":          []string{"fissile"},
+		"editable":      true,
+		"schemaVersion": 16,
+		"refresh":       "1m",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"panels":        grafanaPanelsPlaceholder,
+	})
+	if err != nil {
+		return nil, err
+	}
+	document := strings.Replace(string(dashboard), fmt.Sprintf("%q", grafanaPanelsPlaceholder),
+		"["+panels.String()+"]", 1)
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("v1").
+		SetKind("ConfigMap").
+		SetName(grafanaDashboardName).
+		AddModifier(helm.Block("if .Values.grafana_dashboard.enabled")).
+		AddModifier(helm.Comment("Grafana dashboard of the instance groups, for the dashboard loader sidecar of Grafana"))
+	configMap, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	configMap.Get("metadata", "labels").(*helm.Mapping).Add("grafana_dashboard", "1")
+	configMap.Add("data", helm.NewMapping("{{ .Release.Name }}-instance-groups.json", document))
+
+	return configMap, nil
+}
+
+// grafanaPanel returns a time series panel of the given width, at the given
+// position of the dashboard grid
+func grafanaPanel(title string, x, y, width int, targets ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"title":      title,
+		"type":       "graph",
+		"datasource": "{{ .Values.grafana_dashboard.datasource }}",
+		"gridPos":    map[string]int{"x": x, "y": y, "w": width, "h": 8},
+		"targets":    targets,
+	}
+}
+
+// grafanaTarget returns a Prometheus query of a panel, with series named
+// after the given label
+func grafanaTarget(expr, legend string) map[string]interface{} {
+	return map[string]interface{}{
+		"expr": expr,
+		// Grafana uses the same delimiters as helm
+		"legendFormat": fmt.Sprintf("{{`{{%s}}`}}", legend),
+	}
+}
+
+// grafanaRequestTarget returns a query showing the request from the sizing
+// values. The query is empty, and so not shown, when the request is unset.
+func grafanaRequestTarget(condition, expr string) map[string]interface{} {
+	return map[string]interface{}{
+		"expr":         fmt.Sprintf("{{ if %s }}%s{{ end }}", condition, expr),
+		"legendFormat": "request",
+	}
+}
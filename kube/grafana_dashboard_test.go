@@ -0,0 +1,89 @@
+package kube
+
+import (
+	"encoding/json"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeGrafanaDashboard(t *testing.T) {
+	t.Parallel()
+
+	_, err := MakeGrafanaDashboard(ExportSettings{RoleManifest: &model.RoleManifest{}})
+	assert.Error(t, err, "Should require a helm chart")
+
+	settings := testExportSettings(t, "resource-quota.yml")
+	settings.UseMemoryLimits = true
+	settings.UseCPULimits = true
+	configMap, err := MakeGrafanaDashboard(settings)
+	require.NoError(t, err)
+
+	actual, err := RoundtripNodeWithValues(configMap, map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Nil(t, actual, "The dashboard should be disabled by default")
+
+	for _, extra := range []bool{false, true} {
+		extra := extra
+		t.Run("Dashboard", func(t *testing.T) {
+			t.Parallel()
+			actual, err := RoundtripNode(configMap, map[string]interface{}{
+				"Release.Namespace":              "scf",
+				"Values.grafana_dashboard":       map[string]interface{}{"enabled": true, "datasource": "metrics"},
+				"Values.enable.extra":            extra,
+				"Values.config.cpu.requests":     true,
+				"Values.config.memory.requests":  true,
+				"Values.sizing.main.cpu.request": 500,
+				"Values.sizing.main.memory":      map[string]interface{}{"request": 128},
+				"Values.sizing.optional":         map[string]interface{}{"cpu": map[string]interface{}{}, "memory": map[string]interface{}{}},
+			})
+			require.NoError(t, err)
+
+			metadata := actual.(map[interface{}]interface{})["metadata"].(map[interface{}]interface{})
+			assert.Equal(t, "1", metadata["labels"].(map[interface{}]interface{})["grafana_dashboard"])
+			data := actual.(map[interface{}]interface{})["data"].(map[interface{}]interface{})
+			require.Contains(t, data, "MyRelease-instance-groups.json")
+
+			var dashboard struct {
+				Title  string
+				Panels []struct {
+					Title      string
+					Datasource string
+					Targets    []struct {
+						Expr         string
+						LegendFormat string
+					}
+				}
+			}
+			require.NoError(t, json.Unmarshal([]byte(data["MyRelease-instance-groups.json"].(string)), &dashboard))
+			assert.Equal(t, "MyRelease instance groups", dashboard.Title)
+
+			var titles []string
+			for _, panel := range dashboard.Panels {
+				titles = append(titles, panel.Title)
+				assert.Equal(t, "metrics", panel.Datasource)
+			}
+			expected := []string{"Pods of the release", "main pods", "main restarts (1h)", "main CPU (cores)", "main memory (bytes)"}
+			if extra {
+				expected = append(expected, "optional pods", "optional restarts (1h)", "optional CPU (cores)", "optional memory (bytes)")
+			}
+			assert.Equal(t, expected, titles)
+
+			pods := dashboard.Panels[1].Targets[0]
+			assert.Equal(t, `sum by (phase) (kube_pod_status_phase{namespace="scf", pod=~"main-[0-9]+"})`, pods.Expr)
+			assert.Equal(t, "{{phase}}", pods.LegendFormat)
+
+			cpu := dashboard.Panels[3].Targets
+			require.Len(t, cpu, 2)
+			assert.Equal(t, `sum by (pod) (rate(container_cpu_usage_seconds_total{namespace="scf", pod=~"main-[0-9]+", container!="", container!="POD"}[5m]))`, cpu[0].Expr)
+			assert.Equal(t, "vector(500 / 1000)", cpu[1].Expr)
+			assert.Equal(t, "vector(128 * 1048576)", dashboard.Panels[4].Targets[1].Expr)
+
+			if extra {
+				assert.Empty(t, dashboard.Panels[7].Targets[1].Expr, "Requests without sizing values should not be shown")
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package kube
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"github.com/Masterminds/semver"
+)
+
+// Helm versions the charts can be generated for
+const (
+	HelmVersion2 = 2 // Charts without Chart.yaml, for helm 2 (the default)
+	HelmVersion3 = 3 // Charts with an apiVersion v2 Chart.yaml
+)
+
+// ValidateHelmVersion returns an error unless charts can be generated for
+// the helm version
+func ValidateHelmVersion(version int) error {
+	if version != HelmVersion2 && version != HelmVersion3 {
+		return fmt.Errorf("Unsupported helm version %d; use %d or %d", version, HelmVersion2, HelmVersion3)
+	}
+	return nil
+}
+
+// MakeChartMetadata returns the Chart.yaml of a helm 3 chart, of type
+// application
+func MakeChartMetadata(settings ExportSettings) (helm.Node, error) {
+	if settings.ChartName == "" {
+		return nil, fmt.Errorf("Helm %d charts require a chart name", HelmVersion3)
+	}
+	if _, err := semver.NewVersion(settings.ChartVersion); err != nil {
+		return nil, fmt.Errorf("Invalid chart version %q: %v", settings.ChartVersion, err)
+	}
+
+	chart := helm.NewMapping()
+	chart.Add("apiVersion", "v2")
+	chart.Add("name", settings.ChartName)
+	chart.Add("version", settings.ChartVersion)
+	chart.Add("description", fmt.Sprintf("Generated by fissile %s", settings.FissileVersion))
+	chart.Add("type", "application")
+	return chart, nil
+}
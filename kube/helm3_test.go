@@ -0,0 +1,43 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateHelmVersion(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, ValidateHelmVersion(HelmVersion2))
+	assert.NoError(t, ValidateHelmVersion(HelmVersion3))
+	assert.EqualError(t, ValidateHelmVersion(1), "Unsupported helm version 1; use 2 or 3")
+}
+
+func TestMakeChartMetadata(t *testing.T) {
+	t.Parallel()
+
+	settings := ExportSettings{HelmVersion: HelmVersion3, FissileVersion: "7.0.0"}
+	_, err := MakeChartMetadata(settings)
+	assert.EqualError(t, err, "Helm 3 charts require a chart name")
+
+	settings.ChartName = "my-chart"
+	settings.ChartVersion = "one"
+	_, err = MakeChartMetadata(settings)
+	assert.Error(t, err, "The chart version must be a semantic version")
+
+	settings.ChartVersion = "1.2.3+build.4"
+	chart, err := MakeChartMetadata(settings)
+	require.NoError(t, err)
+	actual, err := RoundtripNode(chart, nil)
+	require.NoError(t, err)
+	yamltest.IsYAMLEqualString(assert.New(t), `---
+		apiVersion: v2
+		name: my-chart
+		version: 1.2.3+build.4
+		description: Generated by fissile 7.0.0
+		type: application
+	`, actual)
+}
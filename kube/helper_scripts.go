@@ -0,0 +1,127 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// HelperScriptsDir is the directory below the output directory the helper
+// scripts are written to
+const HelperScriptsDir = "bin"
+
+// MakeHelperScripts returns shell scripts wrapping the kubectl invocations
+// commonly needed to operate the instance groups, by file name: shell-<group>
+// opens a shell in a pod, logs-<group> tails the logs of its jobs, and (for
+// plain Kubernetes configs only) run-<group> runs a task instance group. The
+// namespace and helm release default to the name of the output directory and
+// can be overridden via the NAMESPACE and RELEASE environment variables.
+func MakeHelperScripts(settings ExportSettings) (map[string]string, error) {
+	name, err := releaseName(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	scripts := map[string]string{}
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		if instanceGroup.IsColocated() || instanceGroup.Run == nil {
+			continue
+		}
+		if settings.CreateHelmChart && instanceGroup.Run.FlightStage == model.FlightStageManual {
+			continue
+		}
+
+		switch instanceGroup.Type {
+		case model.RoleTypeBosh:
+			scripts["shell-"+instanceGroup.Name] = makeShellScript(instanceGroup, name, settings)
+			scripts["logs-"+instanceGroup.Name] = makeJobLogsScript(instanceGroup, name, settings)
+		case model.RoleTypeBoshTask:
+			scripts["logs-"+instanceGroup.Name] = makeTaskLogsScript(instanceGroup, name, settings)
+			if !settings.CreateHelmChart {
+				scripts["run-"+instanceGroup.Name] = makeRunScript(instanceGroup, name, settings)
+			}
+		}
+	}
+
+	return scripts, nil
+}
+
+// writeHelperScriptHeader writes the preamble shared by all helper scripts,
+// defining NAMESPACE (and RELEASE for helm charts) and the pod selector of the
+// instance group
+func writeHelperScriptHeader(buf *bytes.Buffer, instanceGroup *model.InstanceGroup, name, description, usage string, settings ExportSettings) {
+	fmt.Fprintln(buf, "#!/bin/sh")
+	fmt.Fprintf(buf, "# Generated by fissile; %s\n", description)
+	fmt.Fprintf(buf, "# Usage: %s\n", usage)
+	fmt.Fprintln(buf, "set -o errexit -o nounset")
+	fmt.Fprintf(buf, "NAMESPACE=\"${NAMESPACE:-%s}\"\n", name)
+	selector := fmt.Sprintf("%s=%s", RoleNameLabel, instanceGroup.Name)
+	if settings.CreateHelmChart {
+		fmt.Fprintf(buf, "RELEASE=\"${RELEASE:-%s}\"\n", name)
+		selector += ",app.kubernetes.io/instance=${RELEASE}"
+	}
+	fmt.Fprintf(buf, "selector=\"%s\"\n", selector)
+}
+
+// writeFindPod writes the commands looking up the pod with the given index
+// (a shell expression) into the pod variable
+func writeFindPod(buf *bytes.Buffer, instanceGroup *model.InstanceGroup, index string) {
+	fmt.Fprintf(buf, "pod=\"$(kubectl get pods --namespace \"${NAMESPACE}\" --selector \"${selector}\" --output \"jsonpath={.items[%s].metadata.name}\")\"\n", index)
+	fmt.Fprintln(buf, `if [ -z "${pod}" ]; then`)
+	fmt.Fprintf(buf, "  echo \"No pod of instance group %s found in namespace ${NAMESPACE}\" >&2\n", instanceGroup.Name)
+	fmt.Fprintln(buf, "  exit 1")
+	fmt.Fprintln(buf, "fi")
+}
+
+func makeShellScript(instanceGroup *model.InstanceGroup, name string, settings ExportSettings) string {
+	var buf bytes.Buffer
+	writeHelperScriptHeader(&buf, instanceGroup, name,
+		fmt.Sprintf("open a shell in a pod of instance group %s", instanceGroup.Name),
+		fmt.Sprintf("shell-%s [index]", instanceGroup.Name), settings)
+	writeFindPod(&buf, instanceGroup, "${1:-0}")
+	fmt.Fprintf(&buf, "exec kubectl exec --namespace \"${NAMESPACE}\" --stdin --tty \"${pod}\" --container %s -- /bin/bash\n", instanceGroup.Name)
+	return buf.String()
+}
+
+func makeJobLogsScript(instanceGroup *model.InstanceGroup, name string, settings ExportSettings) string {
+	var jobs []string
+	for _, jobReference := range instanceGroup.JobReferences {
+		jobs = append(jobs, jobReference.Name)
+	}
+
+	var buf bytes.Buffer
+	writeHelperScriptHeader(&buf, instanceGroup, name,
+		fmt.Sprintf("tail the logs of the jobs of instance group %s", instanceGroup.Name),
+		fmt.Sprintf("logs-%s [job] [index]", instanceGroup.Name), settings)
+	fmt.Fprintln(&buf, `job="${1:-*}"`)
+	fmt.Fprintln(&buf, `case "${job}" in`)
+	fmt.Fprintf(&buf, "  \\*|%s) ;;\n", strings.Join(jobs, "|"))
+	fmt.Fprintf(&buf, "  *) echo \"Unknown job ${job}; expected one of: %s\" >&2; exit 1 ;;\n", strings.Join(jobs, " "))
+	fmt.Fprintln(&buf, "esac")
+	writeFindPod(&buf, instanceGroup, "${2:-0}")
+	fmt.Fprintf(&buf, "exec kubectl exec --namespace \"${NAMESPACE}\" \"${pod}\" --container %s -- sh -c \"tail -F /var/vcap/sys/log/${job}/*.log\"\n", instanceGroup.Name)
+	return buf.String()
+}
+
+func makeTaskLogsScript(instanceGroup *model.InstanceGroup, name string, settings ExportSettings) string {
+	var buf bytes.Buffer
+	writeHelperScriptHeader(&buf, instanceGroup, name,
+		fmt.Sprintf("follow the logs of task instance group %s", instanceGroup.Name),
+		fmt.Sprintf("logs-%s", instanceGroup.Name), settings)
+	fmt.Fprintf(&buf, "exec kubectl logs --namespace \"${NAMESPACE}\" --selector \"${selector}\" --container %s --follow\n", instanceGroup.Name)
+	return buf.String()
+}
+
+func makeRunScript(instanceGroup *model.InstanceGroup, name string, settings ExportSettings) string {
+	var buf bytes.Buffer
+	writeHelperScriptHeader(&buf, instanceGroup, name,
+		fmt.Sprintf("run task instance group %s, replacing any previous run", instanceGroup.Name),
+		fmt.Sprintf("run-%s", instanceGroup.Name), settings)
+	fmt.Fprintln(&buf, `dir="$(cd "$(dirname "$0")/.." && pwd)"`)
+	fmt.Fprintln(&buf, `kubectl delete --namespace "${NAMESPACE}" job,pod --selector "${selector}" --ignore-not-found`)
+	fmt.Fprintf(&buf, "kubectl apply --namespace \"${NAMESPACE}\" --filename \"${dir}/%s/%s.yaml\"\n", instanceGroup.Type, instanceGroup.Name)
+	fmt.Fprintf(&buf, "echo \"Started %s; follow its logs with logs-%s\"\n", instanceGroup.Name, instanceGroup.Name)
+	return buf.String()
+}
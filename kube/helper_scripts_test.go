@@ -0,0 +1,44 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeHelperScripts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		scripts, err := MakeHelperScripts(testExportSettings(t, "helper-scripts.yml"))
+		require.NoError(t, err)
+		assert.Len(t, scripts, 2)
+
+		shell := scripts["shell-main"]
+		assert.Contains(t, shell, `NAMESPACE="${NAMESPACE:-my-release}"`)
+		assert.Contains(t, shell, `RELEASE="${RELEASE:-my-release}"`)
+		assert.Contains(t, shell, `selector="app.kubernetes.io/component=main,app.kubernetes.io/instance=${RELEASE}"`)
+		assert.Contains(t, shell, `jsonpath={.items[${1:-0}].metadata.name}`)
+		assert.Contains(t, shell, `--container main -- /bin/bash`)
+
+		logs := scripts["logs-main"]
+		assert.Contains(t, logs, `  \*|tor|new_hostname) ;;`)
+		assert.Contains(t, logs, `tail -F /var/vcap/sys/log/${job}/*.log`)
+	})
+
+	t.Run("Kube", func(t *testing.T) {
+		t.Parallel()
+		settings := testExportSettings(t, "helper-scripts.yml")
+		settings.CreateHelmChart = false
+		scripts, err := MakeHelperScripts(settings)
+		require.NoError(t, err)
+		assert.Len(t, scripts, 4)
+
+		assert.NotContains(t, scripts["shell-main"], "RELEASE")
+		assert.Contains(t, scripts["shell-main"], `selector="app.kubernetes.io/component=main"`)
+		assert.Contains(t, scripts["logs-smoke-tests"], `kubectl logs --namespace "${NAMESPACE}" --selector "${selector}" --container smoke-tests --follow`)
+		assert.Contains(t, scripts["run-smoke-tests"], `--filename "${dir}/bosh-task/smoke-tests.yaml"`)
+	})
+}
@@ -0,0 +1,40 @@
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	digest "github.com/opencontainers/go-digest"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// imageDigestsFile is the format of the digests file written by
+// `fissile push images --digests-file`
+type imageDigestsFile struct {
+	Images []struct {
+		InstanceGroup string `yaml:"instance_group"`
+		Digest        string `yaml:"digest"`
+	} `yaml:"images"`
+}
+
+// ReadImageDigests reads the digests of the role images, by instance group,
+// from a digests file written by `fissile push images`.
+func ReadImageDigests(path string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file imageDigestsFile
+	if err := yaml.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("Error parsing image digests file %s: %v", path, err)
+	}
+
+	digests := map[string]string{}
+	for _, image := range file.Images {
+		if _, err := digest.Parse(image.Digest); err != nil {
+			return nil, fmt.Errorf("Invalid digest %q for instance group %s in %s: %v", image.Digest, image.InstanceGroup, path, err)
+		}
+		digests[image.InstanceGroup] = image.Digest
+	}
+	return digests, nil
+}
@@ -0,0 +1,38 @@
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadImageDigests(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "fissile-image-digests")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "digests.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`---
+images:
+- instance_group: nats
+  image: docker.io/splat/fissile-nats:abc
+  digest: sha256:a886ed76c6d6e5a96ad5c37fb208368a430a29d770f1d149a78e1e6e8091eb12
+`), 0644))
+	digests, err := ReadImageDigests(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"nats": "sha256:a886ed76c6d6e5a96ad5c37fb208368a430a29d770f1d149a78e1e6e8091eb12",
+	}, digests)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{images: [{instance_group: nats, digest: abc}]}`), 0644))
+	_, err = ReadImageDigests(path)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `Invalid digest "abc" for instance group nats`)
+	}
+}
@@ -0,0 +1,195 @@
+package kube
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/util"
+)
+
+// imagePrePullName is the name of the image pre-pull DaemonSet, and of the
+// hook job waiting for it
+const imagePrePullName = "image-prepull"
+
+// imagePrePullScript waits for the pre-pull DaemonSet to be ready on all
+// nodes. Running out of time only delays the release, as pulling the images
+// ahead of it is an optimization.
+const imagePrePullScript = `set -o nounset
+if ! kubectl rollout status daemonset "${DAEMONSET}" --namespace "${NAMESPACE}" --timeout "${TIMEOUT}s"; then
+  echo "The images were not pulled onto all nodes within ${TIMEOUT} seconds" >&2
+fi
+`
+
+// NewImagePrePull returns a DaemonSet that pulls the images of all instance
+// groups onto every node. Each image is run as an init container that exits
+// immediately; a pause container then keeps the pod alive so the images are
+// not garbage collected. As helm doesn't wait for DaemonSets, it is installed
+// as a pre-install and pre-upgrade hook along with a hook job waiting for it
+// to be ready on all nodes, for at most .Values.image_prepull.timeout
+// seconds. They are returned with the RBAC resources allowing the job to
+// watch the DaemonSet, and only created when .Values.image_prepull.enabled is
+// set.
+func NewImagePrePull(settings ExportSettings, grapher util.ModelGrapher) ([]helm.Node, error) {
+	if !settings.CreateHelmChart {
+		return nil, fmt.Errorf("Image pre-pull DaemonSet requires a helm chart")
+	}
+
+	rbacBlock := helm.Block(fmt.Sprintf(`if and .Values.image_prepull.enabled (%s) (%s)`,
+		`eq (printf "%s" .Values.kube.auth) "rbac"`,
+		`.Capabilities.APIVersions.Has "rbac.authorization.k8s.io/v1"`))
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("v1").
+		SetKind("ServiceAccount").
+		SetName(imagePrePullName).
+		AddModifier(rbacBlock).
+		AddModifier(helm.Comment("Service account of the job waiting for the image pre-pull DaemonSet"))
+	serviceAccount, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+
+	role, err := NewRBACRole(imagePrePullName, RBACRoleKindRole, model.AuthRole{
+		model.AuthRule{
+			APIGroups: []string{"apps", "extensions"},
+			Resources: []string{"daemonsets"},
+			Verbs:     []string{"get", "list", "watch"},
+		},
+	}, settings)
+	if err != nil {
+		return nil, err
+	}
+	role.Set(rbacBlock)
+
+	cb = NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("rbac.authorization.k8s.io/v1").
+		SetKind("RoleBinding").
+		SetName(imagePrePullName + "-binding").
+		AddModifier(rbacBlock)
+	binding, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	binding.Add("subjects", helm.NewList(helm.NewMapping(
+		"kind", "ServiceAccount",
+		"name", resourceName(imagePrePullName, settings))))
+	binding.Add("roleRef", helm.NewMapping(
+		"apiGroup", "rbac.authorization.k8s.io",
+		"kind", "Role",
+		"name", resourceName(imagePrePullName, settings)))
+
+	initContainers := helm.NewList()
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		if instanceGroup.Run == nil || instanceGroup.Run.FlightStage == model.FlightStageManual {
+			continue
+		}
+		image, err := getContainerImageName(instanceGroup, settings, grapher)
+		if err != nil {
+			return nil, err
+		}
+		container := helm.NewMapping(
+			"name", instanceGroup.Name,
+			"image", image,
+			"command", []string{"/bin/true"})
+		addFeatureCheck(instanceGroup, container)
+		initContainers.Add(container)
+	}
+
+	pause := helm.NewMapping(
+		"name", "pause",
+		"image", "{{ .Values.image_prepull.pause_image }}")
+
+	spec := helm.NewMapping()
+	spec.Add("initContainers", initContainers)
+	spec.Add("containers", helm.NewList(pause))
+	spec.Add("imagePullSecrets", helm.NewList(helm.NewMapping("name", resourceName(registryCredentialsName, settings))),
+		helm.Block(`if ne .Values.kube.registry.username ""`))
+	// Images must be pulled onto every node, including tainted ones
+	spec.Add("tolerations", helm.NewList(helm.NewMapping("operator", "Exists")))
+	spec.Add("terminationGracePeriodSeconds", 0)
+	spec.Sort()
+
+	podCB := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("v1").
+		SetKind("Pod").
+		SetName(imagePrePullName)
+	pod, err := podCB.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+
+	podTemplate := helm.NewMapping()
+	podTemplate.Add("metadata", pod.Get("metadata"))
+	podTemplate.Add("spec", spec)
+
+	daemonSetSpec := helm.NewMapping()
+	matchLabels := helm.NewMapping("skiff-role-name", imagePrePullName)
+	addInstanceSelector(matchLabels, settings)
+	daemonSetSpec.Add("selector", helm.NewMapping("matchLabels", matchLabels))
+	daemonSetSpec.Add("template", podTemplate)
+
+	cb = NewConfigBuilder().
+		SetSettings(&settings).
+		SetConditionalAPIVersion("apps/v1", "extensions/v1beta1").
+		SetKind("DaemonSet").
+		SetName(imagePrePullName).
+		AddModifier(helm.Block("if .Values.image_prepull.enabled")).
+		AddModifier(helm.Comment("Pulls the images of all instance groups onto every node"))
+	daemonSet, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	daemonSet.Add("spec", daemonSetSpec)
+
+	env := helm.NewList(
+		helm.NewMapping("name", "DAEMONSET", "value", resourceName(imagePrePullName, settings)),
+		helm.NewMapping("name", "TIMEOUT", "value", "{{ .Values.image_prepull.timeout | quote }}"),
+		helm.NewMapping("name", "NAMESPACE", "valueFrom",
+			helm.NewMapping("fieldRef", helm.NewMapping("fieldPath", "metadata.namespace"))))
+
+	container := helm.NewMapping(
+		"name", imagePrePullName,
+		"image", "{{ .Values.image_prepull.image }}",
+		"command", []string{"/bin/sh", "-c", imagePrePullScript},
+		"env", env)
+
+	jobSpec := helm.NewMapping()
+	jobSpec.Add("containers", helm.NewList(container))
+	jobSpec.Add("restartPolicy", "Never")
+	jobSpec.Add("serviceAccountName", resourceName(imagePrePullName, settings), authModeRBAC(settings))
+	jobSpec.Sort()
+
+	cb = NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("batch/v1").
+		SetKind("Job").
+		SetName(imagePrePullName).
+		AddModifier(helm.Block("if .Values.image_prepull.enabled")).
+		AddModifier(helm.Comment("Waits for the images to be pulled onto every node before installs and upgrades"))
+	job, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	job.Add("spec", helm.NewMapping(
+		"backoffLimit", 0,
+		"activeDeadlineSeconds", "{{ add (int .Values.image_prepull.timeout) 60 }}",
+		"template", helm.NewMapping("spec", jobSpec)))
+
+	// The DaemonSet and its RBAC resources are created before the job
+	for _, node := range []helm.Node{serviceAccount, role, binding, daemonSet} {
+		node.(*helm.Mapping).Get("metadata").(*helm.Mapping).Add("annotations", helm.NewMapping(
+			"helm.sh/hook", "pre-install,pre-upgrade",
+			"helm.sh/hook-weight", "-10",
+			"helm.sh/hook-delete-policy", "before-hook-creation"))
+	}
+	job.Get("metadata").(*helm.Mapping).Add("annotations", helm.NewMapping(
+		"helm.sh/hook", "pre-install,pre-upgrade",
+		"helm.sh/hook-weight", "-5",
+		"helm.sh/hook-delete-policy", "before-hook-creation"))
+
+	return []helm.Node{serviceAccount, role, binding, daemonSet, job}, nil
+}
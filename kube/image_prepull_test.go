@@ -0,0 +1,137 @@
+package kube
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/model/loader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewImagePrePull(t *testing.T) {
+	t.Parallel()
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	manifestPath := filepath.Join(workDir, "../test-assets/role-manifests/kube/pod-with-valid-pod-anti-affinity.yml")
+	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	manifest, err := loader.LoadRoleManifest(manifestPath, model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{releasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../test-assets/.final_releases")},
+		ValidationOptions: model.RoleManifestValidationOptions{
+			AllowMissingScripts: true,
+		}})
+	require.NoError(t, err)
+
+	settings := ExportSettings{
+		CreateHelmChart: true,
+		Repository:      "theRepo",
+		RoleManifest:    manifest,
+	}
+
+	_, err = NewImagePrePull(ExportSettings{RoleManifest: manifest}, FakeGrapher{})
+	assert.Error(t, err, "Should require a helm chart")
+
+	nodes, err := NewImagePrePull(settings, FakeGrapher{})
+	require.NoError(t, err)
+	require.Len(t, nodes, 5)
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.kube.auth": "rbac",
+		}
+		for _, node := range nodes {
+			actual, err := RoundtripNode(node, config)
+			require.NoError(t, err)
+			assert.Nil(t, actual)
+		}
+	})
+
+	t.Run("Hooks", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.image_prepull.enabled": true,
+			"Values.kube.auth":             "rbac",
+		}
+		for i, kind := range []string{"ServiceAccount", "Role", "RoleBinding", "DaemonSet", "Job"} {
+			actual, err := RoundtripNode(nodes[i], config)
+			require.NoError(t, err)
+			resource := actual.(map[interface{}]interface{})
+			assert.Equal(t, kind, resource["kind"])
+
+			annotations := resource["metadata"].(map[interface{}]interface{})["annotations"].(map[interface{}]interface{})
+			assert.Equal(t, "pre-install,pre-upgrade", annotations["helm.sh/hook"], kind)
+			assert.Equal(t, "before-hook-creation", annotations["helm.sh/hook-delete-policy"], kind)
+			if kind == "Job" {
+				assert.Equal(t, "-5", annotations["helm.sh/hook-weight"], "The job must wait for the DaemonSet to exist")
+			} else {
+				assert.Equal(t, "-10", annotations["helm.sh/hook-weight"], kind)
+			}
+		}
+	})
+
+	t.Run("DaemonSet", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.image_prepull.enabled":  true,
+			"Values.kube.registry.hostname": "docker.suse.com",
+			"Values.kube.organization":      "splatform",
+		}
+		actual, err := RoundtripNode(nodes[3], config)
+		require.NoError(t, err)
+
+		prePull := actual.(map[interface{}]interface{})
+		assert.Equal(t, "DaemonSet", prePull["kind"])
+
+		spec := prePull["spec"].(map[interface{}]interface{})["template"].(map[interface{}]interface{})["spec"].(map[interface{}]interface{})
+		initContainers := spec["initContainers"].([]interface{})
+		require.Len(t, initContainers, 2)
+		for i, name := range []string{"some-group", "istio-managed-group"} {
+			initContainer := initContainers[i].(map[interface{}]interface{})
+			assert.Equal(t, name, initContainer["name"])
+			assert.Regexp(t, "^docker.suse.com/splatform/theRepo-"+name+":", initContainer["image"])
+		}
+
+		containers := spec["containers"].([]interface{})
+		require.Len(t, containers, 1)
+		assert.Equal(t, "k8s.gcr.io/pause:3.1", containers[0].(map[interface{}]interface{})["image"])
+		assert.NotContains(t, spec, "imagePullSecrets")
+	})
+
+	t.Run("Job", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.image_prepull.enabled": true,
+			"Values.image_prepull.timeout": 300,
+			"Values.kube.auth":             "rbac",
+		}
+		actual, err := RoundtripNode(nodes[4], config)
+		require.NoError(t, err)
+
+		spec := actual.(map[interface{}]interface{})["spec"].(map[interface{}]interface{})
+		assert.Equal(t, 0, spec["backoffLimit"])
+		assert.Equal(t, 360, spec["activeDeadlineSeconds"])
+
+		podSpec := spec["template"].(map[interface{}]interface{})["spec"].(map[interface{}]interface{})
+		assert.Equal(t, "Never", podSpec["restartPolicy"])
+		assert.Equal(t, "image-prepull", podSpec["serviceAccountName"])
+
+		container := podSpec["containers"].([]interface{})[0].(map[interface{}]interface{})
+		assert.Equal(t, "bitnami/kubectl:1.14", container["image"])
+		assert.Contains(t, container["command"].([]interface{})[2], "kubectl rollout status daemonset")
+		env := map[interface{}]interface{}{}
+		for _, envVar := range container["env"].([]interface{}) {
+			envVar := envVar.(map[interface{}]interface{})
+			env[envVar["name"]] = envVar["value"]
+		}
+		assert.Equal(t, "image-prepull", env["DAEMONSET"])
+		assert.Equal(t, "300", env["TIMEOUT"])
+	})
+}
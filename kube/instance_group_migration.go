@@ -0,0 +1,235 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// migrationName is the name of the pre-upgrade job migrating renamed
+// instance groups, and of its service account
+const migrationName = "instance-group-migration"
+
+// migrationScript adopts the volumes of the stateful sets of renamed
+// instance groups. Each entry of MIGRATIONS is "old:new:claim,claim". The
+// old stateful set is scaled down and deleted, and the claims of its pods are
+// replaced by claims using the name of the new stateful set, bound to the
+// same persistent volumes. The volumes are retained while they are unbound.
+const migrationScript = `set -o errexit -o nounset
+for migration in ${MIGRATIONS}; do
+  old="${NAME_PREFIX}$(echo "${migration}" | cut -d: -f1)"
+  new="${NAME_PREFIX}$(echo "${migration}" | cut -d: -f2)"
+  claims="$(echo "${migration}" | cut -d: -f3 | tr , ' ')"
+  if ! kubectl get statefulset --namespace "${NAMESPACE}" "${old}" >/dev/null 2>&1; then
+    continue
+  fi
+  echo "Migrating instance group ${old} to ${new}"
+  kubectl scale statefulset --namespace "${NAMESPACE}" "${old}" --replicas 0
+  while kubectl get pods --namespace "${NAMESPACE}" --output name | grep -q "^pod/${old}-[0-9]*$"; do
+    sleep 5
+  done
+  kubectl delete statefulset --namespace "${NAMESPACE}" "${old}"
+  for claim in ${claims}; do
+    pvcs="$(kubectl get pvc --namespace "${NAMESPACE}" --output name | sed 's@^[^/]*/@@' | grep "^${claim}-${old}-[0-9]*$" || true)"
+    for pvc in ${pvcs}; do
+      target="${claim}-${new}-${pvc##*-}"
+      volume="$(kubectl get pvc --namespace "${NAMESPACE}" "${pvc}" --output 'jsonpath={.spec.volumeName}')"
+      mode="$(kubectl get pvc --namespace "${NAMESPACE}" "${pvc}" --output 'jsonpath={.spec.accessModes[0]}')"
+      size="$(kubectl get pvc --namespace "${NAMESPACE}" "${pvc}" --output 'jsonpath={.status.capacity.storage}')"
+      class="$(kubectl get pv "${volume}" --output 'jsonpath={.spec.storageClassName}')"
+      policy="$(kubectl get pv "${volume}" --output 'jsonpath={.spec.persistentVolumeReclaimPolicy}')"
+      kubectl patch pv "${volume}" --patch '{"spec":{"persistentVolumeReclaimPolicy":"Retain"}}'
+      kubectl delete pvc --namespace "${NAMESPACE}" "${pvc}"
+      kubectl patch pv "${volume}" --type json --patch '[{"op":"remove","path":"/spec/claimRef"}]'
+      kubectl create --namespace "${NAMESPACE}" --filename - <<EOF
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: ${target}
+spec:
+  accessModes: [${mode}]
+  storageClassName: "${class}"
+  volumeName: ${volume}
+  resources:
+    requests:
+      storage: ${size}
+EOF
+      kubectl patch pv "${volume}" --patch "{\"spec\":{\"persistentVolumeReclaimPolicy\":\"${policy}\"}}"
+      echo "Moved volume ${volume} from ${pvc} to ${target}"
+    done
+  done
+done
+`
+
+// NewInstanceGroupMigrationJob returns a Job migrating the stateful sets
+// and persistent volume claims of renamed instance groups, as listed in
+// their previous_names, to their current names. It runs as a pre-upgrade
+// hook, before the stateful sets of the current names are created, so that
+// these adopt the existing volumes. It is returned along with the RBAC
+// resources it requires, which are hooks as well, as they must exist before
+// the job runs. Nothing is returned if no instance group has been renamed.
+func NewInstanceGroupMigrationJob(settings ExportSettings) ([]helm.Node, error) {
+	if !settings.CreateHelmChart {
+		return nil, fmt.Errorf("Instance group migration job requires a helm chart")
+	}
+
+	var migrations []string
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		if instanceGroup.Run == nil || instanceGroup.Run.FlightStage == model.FlightStageManual {
+			continue
+		}
+		var claims []string
+		for _, volume := range instanceGroup.Run.Volumes {
+			if volume.Type == model.VolumeTypePersistent || volume.Type == model.VolumeTypeShared {
+				claims = append(claims, volume.Tag)
+			}
+		}
+		for _, previousName := range instanceGroup.PreviousNames {
+			migration := fmt.Sprintf(" %s:%s:%s", previousName, instanceGroup.Name, strings.Join(claims, ","))
+			if block := featureCheckBlock(instanceGroup); block != "" {
+				migration = fmt.Sprintf("{{ %s }}%s{{ end }}", block, migration)
+			}
+			migrations = append(migrations, migration)
+		}
+	}
+	if len(migrations) == 0 {
+		return nil, nil
+	}
+
+	rbacBlock := helm.Block(fmt.Sprintf(`if and (%s) (%s)`,
+		`eq (printf "%s" .Values.kube.auth) "rbac"`,
+		`.Capabilities.APIVersions.Has "rbac.authorization.k8s.io/v1"`))
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("v1").
+		SetKind("ServiceAccount").
+		SetName(migrationName).
+		AddModifier(rbacBlock).
+		AddModifier(helm.Comment("Service account of the instance group migration job"))
+	serviceAccount, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+
+	role, err := NewRBACRole(migrationName, RBACRoleKindRole, model.AuthRole{
+		model.AuthRule{
+			APIGroups: []string{"apps"},
+			Resources: []string{"statefulsets", "statefulsets/scale"},
+			Verbs:     []string{"get", "delete", "patch", "update"},
+		},
+		model.AuthRule{
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+			Verbs:     []string{"list"},
+		},
+		model.AuthRule{
+			APIGroups: []string{""},
+			Resources: []string{"persistentvolumeclaims"},
+			Verbs:     []string{"get", "list", "create", "delete"},
+		},
+	}, settings)
+	if err != nil {
+		return nil, err
+	}
+	role.Set(rbacBlock)
+
+	// Persistent volumes are not namespaced
+	clusterRole, err := NewRBACRole(migrationName, RBACRoleKindClusterRole, model.AuthRole{
+		model.AuthRule{
+			APIGroups: []string{""},
+			Resources: []string{"persistentvolumes"},
+			Verbs:     []string{"get", "patch"},
+		},
+	}, settings)
+	if err != nil {
+		return nil, err
+	}
+	clusterRole.Set(rbacBlock)
+
+	subjects := func() helm.Node {
+		return helm.NewList(helm.NewMapping(
+			"kind", "ServiceAccount",
+			"name", resourceName(migrationName, settings),
+			"namespace", "{{ .Release.Namespace }}"))
+	}
+
+	cb = NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("rbac.authorization.k8s.io/v1").
+		SetKind("RoleBinding").
+		SetName(migrationName + "-binding").
+		AddModifier(rbacBlock)
+	binding, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	binding.Add("subjects", subjects())
+	binding.Add("roleRef", helm.NewMapping(
+		"apiGroup", "rbac.authorization.k8s.io",
+		"kind", "Role",
+		"name", resourceName(migrationName, settings)))
+
+	cb = NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("rbac.authorization.k8s.io/v1").
+		SetKind("ClusterRoleBinding").
+		SetNameHelmExpression(resourceNameExpression(
+			fmt.Sprintf(`(printf "%%s-%s-cluster-binding" $.Release.Namespace)`, migrationName))).
+		AddModifier(rbacBlock)
+	clusterBinding, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	clusterBinding.Add("subjects", subjects())
+	clusterBinding.Add("roleRef", helm.NewMapping(
+		"apiGroup", "rbac.authorization.k8s.io",
+		"kind", "ClusterRole",
+		"name", clusterRoleNameExpression(migrationName)))
+
+	// The RBAC resources are created before the job
+	for _, node := range []helm.Node{serviceAccount, role, clusterRole, binding, clusterBinding} {
+		node.(*helm.Mapping).Get("metadata").(*helm.Mapping).Add("annotations", helm.NewMapping(
+			"helm.sh/hook", "pre-upgrade",
+			"helm.sh/hook-weight", "-5",
+			"helm.sh/hook-delete-policy", "before-hook-creation"))
+	}
+
+	env := helm.NewList(
+		helm.NewMapping("name", "MIGRATIONS", "value", strings.TrimPrefix(strings.Join(migrations, ""), " ")),
+		helm.NewMapping("name", "NAME_PREFIX", "value", `{{ include "fissile.NamePrefix" $ | quote }}`),
+		helm.NewMapping("name", "NAMESPACE", "valueFrom",
+			helm.NewMapping("fieldRef", helm.NewMapping("fieldPath", "metadata.namespace"))))
+
+	container := helm.NewMapping(
+		"name", migrationName,
+		"image", "{{ .Values.migration.image }}",
+		"command", []string{"/bin/sh", "-c", migrationScript},
+		"env", env)
+
+	spec := helm.NewMapping()
+	spec.Add("containers", helm.NewList(container))
+	spec.Add("restartPolicy", "Never")
+	spec.Add("serviceAccountName", resourceName(migrationName, settings), authModeRBAC(settings))
+	spec.Sort()
+
+	cb = NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("batch/v1").
+		SetKind("Job").
+		SetName(migrationName).
+		AddModifier(helm.Comment("Migrates the stateful sets and volumes of renamed instance groups before upgrades"))
+	job, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	job.Get("metadata").(*helm.Mapping).Add("annotations", helm.NewMapping(
+		"helm.sh/hook", "pre-upgrade",
+		"helm.sh/hook-delete-policy", "before-hook-creation"))
+	job.Add("spec", helm.NewMapping(
+		"backoffLimit", 0,
+		"template", helm.NewMapping("spec", spec)))
+
+	return []helm.Node{serviceAccount, role, clusterRole, binding, clusterBinding, job}, nil
+}
@@ -0,0 +1,113 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewInstanceGroupMigrationJob(t *testing.T) {
+	t.Parallel()
+
+	manifest := &model.RoleManifest{
+		InstanceGroups: model.InstanceGroups{
+			&model.InstanceGroup{
+				Name:          "database",
+				Type:          model.RoleTypeBosh,
+				PreviousNames: []string{"mysql"},
+				Run: &model.RoleRun{
+					Volumes: []*model.RoleRunVolume{
+						{Type: model.VolumeTypePersistent, Tag: "data"},
+						{Type: model.VolumeTypeEmptyDir, Tag: "tmp"},
+						{Type: model.VolumeTypeShared, Tag: "blobs"},
+					},
+				},
+			},
+			&model.InstanceGroup{
+				Name:          "api",
+				Type:          model.RoleTypeBosh,
+				IfFeature:     "api",
+				PreviousNames: []string{"web"},
+				Run:           &model.RoleRun{},
+			},
+			&model.InstanceGroup{
+				Name: "router",
+				Type: model.RoleTypeBosh,
+				Run:  &model.RoleRun{},
+			},
+		},
+	}
+
+	_, err := NewInstanceGroupMigrationJob(ExportSettings{RoleManifest: manifest})
+	assert.Error(t, err, "Should require a helm chart")
+
+	nodes, err := NewInstanceGroupMigrationJob(ExportSettings{
+		CreateHelmChart: true,
+		RoleManifest:    &model.RoleManifest{InstanceGroups: manifest.InstanceGroups[2:]},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, nodes, "Should not be generated without renamed instance groups")
+
+	nodes, err = NewInstanceGroupMigrationJob(ExportSettings{
+		CreateHelmChart: true,
+		RoleManifest:    manifest,
+	})
+	require.NoError(t, err)
+	require.Len(t, nodes, 6)
+
+	t.Run("RBAC", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.kube.auth":  "rbac",
+			"Release.Namespace": "scf",
+		}
+		for i, kind := range []string{"ServiceAccount", "Role", "ClusterRole", "RoleBinding", "ClusterRoleBinding"} {
+			actual, err := RoundtripNode(nodes[i], config)
+			require.NoError(t, err)
+			mapping := actual.(map[interface{}]interface{})
+			assert.Equal(t, kind, mapping["kind"])
+			annotations := mapping["metadata"].(map[interface{}]interface{})["annotations"].(map[interface{}]interface{})
+			assert.Equal(t, "pre-upgrade", annotations["helm.sh/hook"])
+			assert.Equal(t, "-5", annotations["helm.sh/hook-weight"], "RBAC resources must be created before the job")
+		}
+
+		actual, err := RoundtripNode(nodes[0], map[string]interface{}{"Release.Namespace": "scf"})
+		require.NoError(t, err)
+		assert.Nil(t, actual, "RBAC resources require RBAC")
+	})
+
+	for _, api := range []bool{false, true} {
+		api := api
+		t.Run("Job", func(t *testing.T) {
+			t.Parallel()
+			actual, err := RoundtripNode(nodes[5], map[string]interface{}{
+				"Values.enable.api":          api,
+				"Values.migration.image":     "kubectl",
+				"Values.name_prefix.enabled": true,
+			})
+			require.NoError(t, err)
+
+			job := actual.(map[interface{}]interface{})
+			assert.Equal(t, "Job", job["kind"])
+			annotations := job["metadata"].(map[interface{}]interface{})["annotations"].(map[interface{}]interface{})
+			assert.Equal(t, "pre-upgrade", annotations["helm.sh/hook"])
+
+			spec := job["spec"].(map[interface{}]interface{})["template"].(map[interface{}]interface{})["spec"].(map[interface{}]interface{})
+			container := spec["containers"].([]interface{})[0].(map[interface{}]interface{})
+			assert.Equal(t, "kubectl", container["image"])
+			env := map[interface{}]interface{}{}
+			for _, variable := range container["env"].([]interface{}) {
+				variable := variable.(map[interface{}]interface{})
+				env[variable["name"]] = variable["value"]
+			}
+			if api {
+				assert.Equal(t, "mysql:database:data,blobs web:api:", env["MIGRATIONS"])
+			} else {
+				assert.Equal(t, "mysql:database:data,blobs", env["MIGRATIONS"])
+			}
+			assert.Equal(t, "MyRelease-", env["NAME_PREFIX"])
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+const (
+	// IntegrationHelmfile selects the helmfile.yaml integration snippet
+	IntegrationHelmfile = "helmfile"
+	// IntegrationTerraform selects the terraform helm_release integration snippet
+	IntegrationTerraform = "terraform"
+)
+
+// RequiredValue describes a values.yaml key that must be set by the user
+// because the chart will fail to render without it.
+type RequiredValue struct {
+	// Section is the top-level values key, either "env" or "secrets"
+	Section     string
+	Name        string
+	Description string
+	Secret      bool
+}
+
+// Key returns the dotted path of the value below .Values
+func (v RequiredValue) Key() string {
+	return v.Section + "." + v.Name
+}
+
+// RequiredValues returns the list of values that must be supplied to install
+// the helm chart, sorted by key. These are the required variables that have no
+// default value (including one from a url template) and are not generated.
+func RequiredValues(settings ExportSettings) []RequiredValue {
+	var required []RequiredValue
+
+	for name, cv := range model.MakeMapOfVariables(settings.RoleManifest) {
+		if !cv.CVOptions.Required || strings.HasPrefix(name, "KUBE_SIZING_") || cv.CVOptions.Type == model.CVTypeEnv {
+			continue
+		}
+		if ok, _ := cv.Value(); ok {
+			continue
+		}
+		if cv.CVOptions.URLTemplate != "" {
+			// The url template supplies the default
+			continue
+		}
+		value := RequiredValue{Name: name, Description: cv.CVOptions.Description}
+		if cv.CVOptions.Secret {
+			// Generated secrets always have a value, and external ones are
+			// in their store
+			if cv.Type != "" || !independentSecret(name) || isExternalSecret(cv, settings.RoleManifest) {
+				continue
+			}
+			value.Section = "secrets"
+			value.Secret = true
+		} else {
+			value.Section = "env"
+		}
+		required = append(required, value)
+	}
+
+	sort.Slice(required, func(i, j int) bool {
+		return required[i].Key() < required[j].Key()
+	})
+	return required
+}
+
+// ValidateIntegrationSnippets checks that all requested snippets are known
+func ValidateIntegrationSnippets(snippets []string) error {
+	for _, snippet := range snippets {
+		switch snippet {
+		case IntegrationHelmfile, IntegrationTerraform:
+		default:
+			return fmt.Errorf("Unknown integration snippet %q; must be one of %q or %q",
+				snippet, IntegrationHelmfile, IntegrationTerraform)
+		}
+	}
+	return nil
+}
+
+// ValidateIntegrationDir checks that the integration snippets have a
+// directory of their own outside of the chart, so they are neither packaged
+// with it nor overwritten by the snippets of other charts.
+func ValidateIntegrationDir(snippets []string, outputDir, integrationDir string) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+	if integrationDir == "" {
+		return fmt.Errorf("The integration snippets require an integration directory")
+	}
+	// Swapping the directories gives the path of the integration directory
+	// relative to the chart, which must lead out of it
+	relativePath, err := integrationChartPath(integrationDir, outputDir)
+	if err != nil {
+		return err
+	}
+	if relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("The integration directory %s must not be inside the chart directory %s", integrationDir, outputDir)
+	}
+	return nil
+}
+
+// integrationChartPath returns the path of the chart directory relative to
+// the integration directory.
+func integrationChartPath(outputDir, integrationDir string) (string, error) {
+	outputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return "", err
+	}
+	integrationDir, err = filepath.Abs(integrationDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(integrationDir, outputDir)
+}
+
+// releaseName derives the helm release name from the name of the chart
+// output directory.
+func releaseName(settings ExportSettings) (string, error) {
+	outputDir, err := filepath.Abs(settings.OutputDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(outputDir), nil
+}
+
+// MakeHelmfile returns a helmfile.yaml document with a single release for the
+// generated chart. The values section lists all required values with empty
+// placeholders. The file is written to the integration directory; the chart
+// path is relative to it.
+func MakeHelmfile(settings ExportSettings) (helm.Node, error) {
+	name, err := releaseName(settings)
+	if err != nil {
+		return nil, err
+	}
+	chartPath, err := integrationChartPath(settings.OutputDir, settings.IntegrationDir)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := map[string]*helm.Mapping{}
+	for _, value := range RequiredValues(settings) {
+		if _, ok := sections[value.Section]; !ok {
+			sections[value.Section] = helm.NewMapping()
+		}
+		sections[value.Section].Add(value.Name, nil, helm.Comment(value.Description))
+	}
+
+	values := helm.NewMapping()
+	for _, section := range []string{"env", "secrets"} {
+		if mapping, ok := sections[section]; ok {
+			values.Add(section, mapping)
+		}
+	}
+
+	release := helm.NewMapping("name", name, "namespace", name, "chart", filepath.ToSlash(chartPath))
+	if len(values.Names()) > 0 {
+		release.Add("values", helm.NewList(values))
+	}
+
+	root := helm.NewMapping("releases", helm.NewList(release))
+	root.Set(helm.Comment("Generated by fissile; fill in the required values before running `helmfile apply`"))
+	return root, nil
+}
+
+var terraformIdentifier = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// terraformString quotes a string for use in HCL, escaping interpolations
+func terraformString(value string) string {
+	value = strconv.Quote(value)
+	value = strings.Replace(value, "${", "$${", -1)
+	return strings.Replace(value, "%{", "%%{", -1)
+}
+
+// MakeTerraformRelease returns a terraform helm_release resource for the
+// generated chart, together with the variable declarations for all required
+// values. Secret values are set via set_sensitive and sensitive variables.
+// The file is written to the integration directory, which is the terraform
+// module; the chart path is relative to it.
+func MakeTerraformRelease(settings ExportSettings) (string, error) {
+	name, err := releaseName(settings)
+	if err != nil {
+		return "", err
+	}
+	chartPath, err := integrationChartPath(settings.OutputDir, settings.IntegrationDir)
+	if err != nil {
+		return "", err
+	}
+	chartPath = terraformString(filepath.ToSlash(chartPath))
+	required := RequiredValues(settings)
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# Generated by fissile; declare the variables below to install the chart")
+	fmt.Fprintf(&buf, "resource \"helm_release\" %s {\n", terraformString(terraformIdentifier.ReplaceAllString(name, "_")))
+	fmt.Fprintf(&buf, "  name      = %s\n", terraformString(name))
+	fmt.Fprintf(&buf, "  namespace = %s\n", terraformString(name))
+	fmt.Fprintf(&buf, "  chart     = \"${path.module}/%s\"\n", chartPath[1:len(chartPath)-1])
+	for _, value := range required {
+		block := "set"
+		if value.Secret {
+			block = "set_sensitive"
+		}
+		fmt.Fprintf(&buf, "\n  %s {\n", block)
+		fmt.Fprintf(&buf, "    name  = %s\n", terraformString(value.Key()))
+		fmt.Fprintf(&buf, "    value = var.%s\n", strings.ToLower(value.Name))
+		fmt.Fprintln(&buf, "  }")
+	}
+	fmt.Fprintln(&buf, "}")
+
+	for _, value := range required {
+		fmt.Fprintf(&buf, "\nvariable %s {\n", terraformString(strings.ToLower(value.Name)))
+		if value.Description != "" {
+			fmt.Fprintf(&buf, "  description = %s\n", terraformString(value.Description))
+		}
+		fmt.Fprintln(&buf, "  type        = string")
+		if value.Secret {
+			fmt.Fprintln(&buf, "  sensitive   = true")
+		}
+		fmt.Fprintln(&buf, "}")
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,83 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func integrationTestSettings(t *testing.T) ExportSettings {
+	settings := testExportSettings(t, "integration.yml")
+	settings.IntegrationDir = "/tmp/integration"
+	return settings
+}
+
+func TestRequiredValues(t *testing.T) {
+	t.Parallel()
+
+	required := RequiredValues(integrationTestSettings(t))
+	require.Len(t, required, 2)
+	assert.Equal(t, "env.NATS_USER", required[0].Key())
+	assert.False(t, required[0].Secret)
+	assert.Equal(t, "secrets.NATS_PASSWORD", required[1].Key())
+	assert.True(t, required[1].Secret)
+}
+
+func TestValidateIntegrationSnippets(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, ValidateIntegrationSnippets(nil))
+	assert.NoError(t, ValidateIntegrationSnippets([]string{IntegrationHelmfile, IntegrationTerraform}))
+	assert.Error(t, ValidateIntegrationSnippets([]string{"ansible"}))
+}
+
+func TestValidateIntegrationDir(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, ValidateIntegrationDir(nil, "/tmp/helm/my-chart", ""))
+	assert.NoError(t, ValidateIntegrationDir([]string{IntegrationHelmfile}, "/tmp/helm/my-chart", "/tmp/helm/my-chart-integration"))
+	assert.Error(t, ValidateIntegrationDir([]string{IntegrationHelmfile}, "/tmp/helm/my-chart", ""))
+	assert.Error(t, ValidateIntegrationDir([]string{IntegrationHelmfile}, "/tmp/helm/my-chart", "/tmp/helm/my-chart"))
+	assert.Error(t, ValidateIntegrationDir([]string{IntegrationTerraform}, "/tmp/helm/my-chart", "/tmp/helm/my-chart/integration"))
+}
+
+func TestMakeHelmfile(t *testing.T) {
+	t.Parallel()
+
+	helmfile, err := MakeHelmfile(integrationTestSettings(t))
+	require.NoError(t, err)
+
+	actual, err := RoundtripNode(helmfile, nil)
+	require.NoError(t, err)
+
+	yamltest.IsYAMLEqualString(assert.New(t), `---
+		releases:
+		-	name: my-release
+			namespace: my-release
+			chart: ../output/my-release
+			values:
+			-	env:
+					NATS_USER: ~
+				secrets:
+					NATS_PASSWORD: ~
+	`, actual)
+}
+
+func TestMakeTerraformRelease(t *testing.T) {
+	t.Parallel()
+
+	release, err := MakeTerraformRelease(integrationTestSettings(t))
+	require.NoError(t, err)
+
+	assert.Contains(t, release, `resource "helm_release" "my_release" {`)
+	assert.Contains(t, release, `  name      = "my-release"`)
+	assert.Contains(t, release, `  chart     = "${path.module}/../output/my-release"`)
+	assert.Contains(t, release, "  set {\n    name  = \"env.NATS_USER\"\n    value = var.nats_user\n  }")
+	assert.Contains(t, release, "  set_sensitive {\n    name  = \"secrets.NATS_PASSWORD\"\n    value = var.nats_password\n  }")
+	assert.Contains(t, release, `  description = "Password for $${NATS}"`)
+	assert.Contains(t, release, "  sensitive   = true")
+	assert.NotContains(t, release, "WITH_DEFAULT")
+	assert.NotContains(t, release, "generated_password")
+}
@@ -0,0 +1,118 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// istioAPIVersion is the API version of the Istio networking resources
+const istioAPIVersion = "networking.istio.io/v1alpha3"
+
+// istioHTTPProtocols are the application protocols that Istio routes as HTTP;
+// all other ports are routed as TCP
+var istioHTTPProtocols = map[string]bool{
+	"http":  true,
+	"http2": true,
+	"grpc":  true,
+}
+
+// NewIstioResources returns a VirtualService and a DestinationRule for the
+// private service of each job of an istio-managed instance group, routing
+// the exposed ports of the job to the service and requiring mutual TLS
+// between the sidecars. They are only created if .Values.config.use_istio is
+// set. UDP ports are not routed, as Istio doesn't support them.
+func NewIstioResources(instanceGroup *model.InstanceGroup, settings ExportSettings) ([]helm.Node, error) {
+	if !settings.CreateHelmChart {
+		return nil, fmt.Errorf("Istio resources require a helm chart")
+	}
+	if !instanceGroup.HasTag(model.RoleTagIstioManaged) {
+		return nil, nil
+	}
+
+	block := "if .Values.config.use_istio"
+	if featureBlock := featureCheckBlock(instanceGroup); featureBlock != "" {
+		block = fmt.Sprintf("if and .Values.config.use_istio (%s)", strings.TrimPrefix(featureBlock, "if "))
+	}
+
+	var nodes []helm.Node
+	for _, job := range instanceGroup.JobReferences {
+		serviceName := jobServiceName(instanceGroup, job)
+		host := resourceName(serviceName, settings)
+
+		httpRoutes := helm.NewList()
+		tcpRoutes := helm.NewList()
+		for _, port := range job.ContainerProperties.BoshContainerization.Ports {
+			if strings.ToUpper(port.Protocol) == "UDP" {
+				continue
+			}
+			for _, servicePort := range createPorts(settings, newServiceTypePrivate, instanceGroup.Name, port) {
+				route := newIstioRoute(host, servicePort)
+				if istioHTTPProtocols[port.AppProtocol] {
+					httpRoutes.Add(route)
+				} else {
+					tcpRoutes.Add(route)
+				}
+			}
+		}
+		if len(httpRoutes.Values()) == 0 && len(tcpRoutes.Values()) == 0 {
+			continue
+		}
+
+		cb := NewConfigBuilder().
+			SetSettings(&settings).
+			SetAPIVersion(istioAPIVersion).
+			SetKind("VirtualService").
+			SetName(serviceName).
+			AddModifier(helm.Block(block)).
+			AddModifier(helm.Comment(fmt.Sprintf("Routes of the %s service of the %s instance group", serviceName, instanceGroup.Name)))
+		virtualService, err := cb.Build()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+		}
+		spec := helm.NewMapping("hosts", helm.NewList(host))
+		if len(httpRoutes.Values()) > 0 {
+			spec.Add("http", httpRoutes)
+		}
+		if len(tcpRoutes.Values()) > 0 {
+			spec.Add("tcp", tcpRoutes)
+		}
+		virtualService.Add("spec", spec)
+
+		cb = NewConfigBuilder().
+			SetSettings(&settings).
+			SetAPIVersion(istioAPIVersion).
+			SetKind("DestinationRule").
+			SetName(serviceName).
+			AddModifier(helm.Block(block)).
+			AddModifier(helm.Comment(fmt.Sprintf("Traffic policy of the %s service of the %s instance group", serviceName, instanceGroup.Name)))
+		destinationRule, err := cb.Build()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+		}
+		spec = helm.NewMapping("host", host)
+		spec.Add("trafficPolicy", helm.NewMapping("tls", helm.NewMapping("mode", "ISTIO_MUTUAL")))
+		destinationRule.Add("spec", spec)
+
+		nodes = append(nodes, virtualService, destinationRule)
+	}
+
+	return nodes, nil
+}
+
+// newIstioRoute returns the route of a VirtualService for a port of a
+// service, as generated by createPorts; ports generated for a configurable
+// count keep their range block.
+func newIstioRoute(host string, servicePort helm.Node) *helm.Mapping {
+	portNumber := servicePort.Get("port")
+	destination := helm.NewMapping("host", host, "port", helm.NewMapping("number", portNumber))
+	route := helm.NewMapping(
+		"match", helm.NewList(helm.NewMapping("port", portNumber)),
+		"route", helm.NewList(helm.NewMapping("destination", destination)))
+	if block := servicePort.Block(); block != "" {
+		route.Set(helm.Block(block))
+	}
+	return route
+}
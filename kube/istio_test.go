@@ -0,0 +1,136 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIstioResources(t *testing.T) {
+	t.Parallel()
+
+	instanceGroup := &model.InstanceGroup{
+		Name: "api",
+		Type: model.RoleTypeBosh,
+		Tags: []model.RoleTag{model.RoleTagIstioManaged},
+		Run:  &model.RoleRun{FlightStage: model.FlightStageFlight},
+		JobReferences: model.JobReferences{
+			&model.JobReference{
+				Name: "cloud_controller_ng",
+				ContainerProperties: model.JobContainerProperties{
+					BoshContainerization: model.JobBoshContainerization{
+						ServiceName: "api-cc",
+						Ports: []model.JobExposedPort{
+							{Name: "api", Protocol: "TCP", AppProtocol: "http", InternalPort: 9022, ExternalPort: 9022, Count: 1, Max: 1},
+							{Name: "uploads", Protocol: "TCP", InternalPort: 9023, ExternalPort: 9023, Count: 1, Max: 1},
+							{Name: "metrics", Protocol: "UDP", InternalPort: 9024, ExternalPort: 9024, Count: 1, Max: 1},
+							{Name: "workers", Protocol: "TCP", InternalPort: 9030, ExternalPort: 9030, Count: 2, Max: 4, CountIsConfigurable: true},
+						},
+					},
+				},
+			},
+			&model.JobReference{Name: "no_ports"},
+		},
+	}
+	settings := ExportSettings{CreateHelmChart: true}
+
+	_, err := NewIstioResources(instanceGroup, ExportSettings{})
+	assert.Error(t, err, "Should require a helm chart")
+
+	nodes, err := NewIstioResources(&model.InstanceGroup{Name: "nats", Run: &model.RoleRun{}}, settings)
+	require.NoError(t, err)
+	assert.Empty(t, nodes, "Instance groups not managed by istio should have no resources")
+
+	nodes, err = NewIstioResources(instanceGroup, settings)
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		for _, node := range nodes {
+			actual, err := RoundtripNode(node, map[string]interface{}{})
+			require.NoError(t, err)
+			assert.Nil(t, actual)
+		}
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.config.use_istio":               true,
+			"Values.sizing.api.ports.workers.count": 2,
+		}
+		actual, err := RoundtripNode(nodes[0], config)
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			apiVersion: networking.istio.io/v1alpha3
+			kind: VirtualService
+			metadata:
+				name: api-cc
+			spec:
+				hosts: [api-cc]
+				http:
+				-	match:
+					-	port: 9022
+					route:
+					-	destination:
+							host: api-cc
+							port:
+								number: 9022
+				tcp:
+				-	match:
+					-	port: 9023
+					route:
+					-	destination:
+							host: api-cc
+							port:
+								number: 9023
+				-	match:
+					-	port: 9030
+					route:
+					-	destination:
+							host: api-cc
+							port:
+								number: 9030
+				-	match:
+					-	port: 9031
+					route:
+					-	destination:
+							host: api-cc
+							port:
+								number: 9031
+		`, actual)
+
+		actual, err = RoundtripNode(nodes[1], config)
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			apiVersion: networking.istio.io/v1alpha3
+			kind: DestinationRule
+			metadata:
+				name: api-cc
+			spec:
+				host: api-cc
+				trafficPolicy:
+					tls:
+						mode: ISTIO_MUTUAL
+		`, actual)
+	})
+
+	t.Run("NamePrefix", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(nodes[1], map[string]interface{}{
+			"Values.config.use_istio":    true,
+			"Values.name_prefix.enabled": true,
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			metadata:
+				name: MyRelease-api-cc
+			spec:
+				host: MyRelease-api-cc
+		`, actual)
+	})
+}
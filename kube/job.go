@@ -16,32 +16,108 @@ func NewJob(instanceGroup *model.InstanceGroup, settings ExportSettings, grapher
 	}
 
 	// Jobs must have a restart policy that isn't "always"
-	switch instanceGroup.Run.FlightStage {
-	case model.FlightStageManual:
-		podTemplate.Get("spec", "restartPolicy").SetValue("Never")
-	case model.FlightStageFlight, model.FlightStagePreFlight, model.FlightStagePostFlight:
-		podTemplate.Get("spec", "restartPolicy").SetValue("OnFailure")
-	default:
-		return nil, fmt.Errorf("Instance group %s has unexpected flight stage %s", instanceGroup.Name, instanceGroup.Run.FlightStage)
+	restartPolicy, err := jobRestartPolicy(instanceGroup, settings)
+	if err != nil {
+		return nil, err
 	}
+	podTemplate.Get("spec", "restartPolicy").SetValue(restartPolicy)
 
-	name := instanceGroup.Name
-	if settings.CreateHelmChart {
-		name += "-{{ .Release.Revision }}"
+	err = addPodAffinity(instanceGroup, podTemplate.Get("spec").(*helm.Mapping), settings)
+	if err != nil {
+		return nil, err
 	}
 
 	cb := NewConfigBuilder().
 		SetSettings(&settings).
 		SetAPIVersion("batch/v1").
 		SetKind("Job").
-		SetName(name).
 		AddModifier(helm.Comment(instanceGroup.GetLongDescription()))
+	if settings.CreateHelmChart {
+		cb.SetNameHelmExpression(resourceNameExpression(
+			fmt.Sprintf(`(printf "%s-%%v" $.Release.Revision)`, instanceGroup.Name)))
+	} else {
+		cb.SetName(instanceGroup.Name)
+	}
 	job, err := cb.Build()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
 	}
-	job.Add("spec", helm.NewMapping("template", podTemplate))
+	spec := helm.NewMapping("template", podTemplate)
+	addJobSettings(instanceGroup, spec, settings, "activeDeadlineSeconds", "backoffLimit", "ttlSecondsAfterFinished")
+	job.Add("spec", spec)
 	addFeatureCheck(instanceGroup, job)
 
 	return job.Sort(), nil
 }
+
+// jobRestartPolicy returns the restart policy of the pods of a bosh-task
+// instance group: Never for manual tasks and OnFailure for all others, unless
+// the role manifest sets another. Helm charts read it from the values.
+func jobRestartPolicy(instanceGroup *model.InstanceGroup, settings ExportSettings) (string, error) {
+	var restartPolicy string
+	switch instanceGroup.Run.FlightStage {
+	case model.FlightStageManual:
+		restartPolicy = model.RestartPolicyNever
+	case model.FlightStageFlight, model.FlightStagePreFlight, model.FlightStagePostFlight:
+		restartPolicy = model.RestartPolicyOnFailure
+	default:
+		return "", fmt.Errorf("Instance group %s has unexpected flight stage %s", instanceGroup.Name, instanceGroup.Run.FlightStage)
+	}
+	if instanceGroup.Run.Job != nil && instanceGroup.Run.Job.RestartPolicy != "" {
+		restartPolicy = instanceGroup.Run.Job.RestartPolicy
+	}
+	if hasJobValues(instanceGroup, settings) {
+		return fmt.Sprintf("{{ default %q %s }}", restartPolicy, jobValue(instanceGroup, "restart_policy")), nil
+	}
+	return restartPolicy, nil
+}
+
+// jobSetting is a setting of the spec of a job or pod, with its key in the
+// values
+type jobSetting struct {
+	key   string
+	value *int
+}
+
+// jobSettings returns the settings of the role manifest for the job of an
+// instance group, by the name of their field in the spec
+func jobSettings(instanceGroup *model.InstanceGroup) map[string]jobSetting {
+	job := instanceGroup.Run.Job
+	if job == nil {
+		job = &model.RoleRunJob{}
+	}
+	return map[string]jobSetting{
+		"activeDeadlineSeconds":   {"active_deadline_seconds", job.ActiveDeadlineSeconds},
+		"backoffLimit":            {"backoff_limit", job.BackoffLimit},
+		"ttlSecondsAfterFinished": {"ttl_seconds_after_finished", job.TTLSecondsAfterFinished},
+	}
+}
+
+// hasJobValues returns true if the helm chart has job settings in the sizing
+// values of the instance group; manual tasks have no sizing values.
+func hasJobValues(instanceGroup *model.InstanceGroup, settings ExportSettings) bool {
+	return settings.CreateHelmChart && instanceGroup.Run.FlightStage != model.FlightStageManual
+}
+
+// jobValue returns the template of the job setting of the values with the
+// given key. Values reused from charts without job settings have none, which
+// leaves the setting unset.
+func jobValue(instanceGroup *model.InstanceGroup, key string) string {
+	return fmt.Sprintf("(default (dict) .Values.sizing.%s.job).%s", makeVarName(instanceGroup.Name), key)
+}
+
+// addJobSettings adds the named job settings of the instance group to the
+// spec of its job or pod; helm charts read them from the values, which
+// default to the role manifest.
+func addJobSettings(instanceGroup *model.InstanceGroup, spec *helm.Mapping, settings ExportSettings, fields ...string) {
+	all := jobSettings(instanceGroup)
+	for _, field := range fields {
+		setting := all[field]
+		if hasJobValues(instanceGroup, settings) {
+			value := jobValue(instanceGroup, setting.key)
+			spec.Add(field, fmt.Sprintf("{{ %s }}", value), helm.Block(fmt.Sprintf(`if ne (typeOf %s) "<nil>"`, value)))
+		} else if setting.value != nil {
+			spec.Add(field, *setting.value)
+		}
+	}
+}
@@ -7,7 +7,7 @@ import (
 
 	"code.cloudfoundry.org/fissile/model"
 	"code.cloudfoundry.org/fissile/model/loader"
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -56,7 +56,7 @@ func TestJobPreFlight(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLSubsetString(assert, `---
+	yamltest.IsYAMLSubsetString(assert, `---
 		apiVersion: batch/v1
 		kind: Job
 		metadata:
@@ -93,7 +93,7 @@ func TestJobPostFlight(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLSubsetString(assert, `---
+	yamltest.IsYAMLSubsetString(assert, `---
 		apiVersion: batch/v1
 		kind: Job
 		metadata:
@@ -144,8 +144,10 @@ func TestJobHelm(t *testing.T) {
 	//       (and add tests demonstrating that)
 
 	config := map[string]interface{}{
-		"Capabilities.KubeVersion.Major": "1",
-		"Capabilities.KubeVersion.Minor": "6",
+		"Values.sizing.pre_role.debug.command": nil,
+		"Values.sizing.pre_role.job":           map[string]interface{}{},
+		"Capabilities.KubeVersion.Major":       "1",
+		"Capabilities.KubeVersion.Minor":       "6",
 		// Fake location for a fake `secrets.yaml`.
 		"Template.BasePath":                    fakeTemplateDir,
 		"Release.Revision":                     "42",
@@ -159,7 +161,7 @@ func TestJobHelm(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: batch/v1
 		kind: "Job"
 		metadata:
@@ -167,7 +169,7 @@ func TestJobHelm(t *testing.T) {
 			labels:
 				app.kubernetes.io/component: pre-role-42
 				app.kubernetes.io/instance: MyRelease
-				app.kubernetes.io/managed-by: Tiller
+				app.kubernetes.io/managed-by: fissile
 				app.kubernetes.io/name: MyChart
 				app.kubernetes.io/version: 1.22.333.4444
 				helm.sh/chart: MyChart-42.1_foo
@@ -179,7 +181,7 @@ func TestJobHelm(t *testing.T) {
 					labels:
 						app.kubernetes.io/component: pre-role
 						app.kubernetes.io/instance: MyRelease
-						app.kubernetes.io/managed-by: Tiller
+						app.kubernetes.io/managed-by: fissile
 						app.kubernetes.io/name: MyChart
 						app.kubernetes.io/version: 1.22.333.4444
 						helm.sh/chart: MyChart-42.1_foo
@@ -206,6 +208,8 @@ func TestJobHelm(t *testing.T) {
 							value: "2048"
 						-	name: "VCAP_SOFT_NPROC"
 							value: "1024"
+						-	name: "TERMINATION_GRACE_PERIOD_SECONDS"
+							value: "600"
 						image: "docker.suse.fake/splat/the_repos-pre-role:b0668a0daba46290566d99ee97d7b45911a53293"
 						lifecycle:
 							preStop:
@@ -237,3 +241,124 @@ func TestJobHelm(t *testing.T) {
 							secretName: deployment-manifest
 	`, actual)
 }
+
+func TestJobSettings(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Kube", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+		instanceGroup := jobTestLoadRole(assert, "errand", "job-settings.yml")
+		if instanceGroup == nil {
+			return
+		}
+		job, err := NewJob(instanceGroup, ExportSettings{Opinions: model.NewEmptyOpinions()}, nil)
+		if !assert.NoError(err) {
+			return
+		}
+		actual, err := RoundtripKube(job)
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLSubsetString(assert, `---
+			spec:
+				activeDeadlineSeconds: 600
+				backoffLimit: 0
+				ttlSecondsAfterFinished: 3600
+				template:
+					spec:
+						restartPolicy: Never
+		`, actual)
+	})
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+		instanceGroup := jobTestLoadRole(assert, "errand", "job-settings.yml")
+		if instanceGroup == nil {
+			return
+		}
+		job, err := NewJob(instanceGroup, ExportSettings{
+			Opinions:        model.NewEmptyOpinions(),
+			CreateHelmChart: true,
+		}, nil)
+		if !assert.NoError(err) {
+			return
+		}
+
+		// The values override the role manifest, and can unset its settings
+		actual, err := RoundtripNode(job, map[string]interface{}{
+			"Values.sizing.errand.debug.command":                  nil,
+			"Values.sizing.errand.job.active_deadline_seconds":    nil,
+			"Values.sizing.errand.job.backoff_limit":              2,
+			"Values.sizing.errand.job.ttl_seconds_after_finished": 60,
+			"Values.sizing.errand.job.restart_policy":             "OnFailure",
+		})
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLSubsetString(assert, `---
+			spec:
+				backoffLimit: 2
+				ttlSecondsAfterFinished: 60
+				template:
+					spec:
+						restartPolicy: OnFailure
+		`, actual)
+		assert.NotContains(actual.(map[interface{}]interface{})["spec"], "activeDeadlineSeconds")
+
+		// The restart policy defaults to the role manifest
+		actual, err = RoundtripNode(job, map[string]interface{}{
+			"Values.sizing.errand.debug.command": nil,
+			"Values.sizing.errand.job":           map[string]interface{}{},
+		})
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLSubsetString(assert, `---
+			spec:
+				template:
+					spec:
+						restartPolicy: Never
+		`, actual)
+
+		// Values reused from charts without job settings fall back to the
+		// role manifest
+		actual, err = RoundtripNode(job, map[string]interface{}{
+			"Values.sizing.errand.debug.command": nil,
+			"Values.sizing.errand.job":           nil,
+		})
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLSubsetString(assert, `---
+			spec:
+				template:
+					spec:
+						restartPolicy: Never
+		`, actual)
+	})
+
+	t.Run("Pod", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+		instanceGroup := jobTestLoadRole(assert, "smoke-tests", "job-settings.yml")
+		if instanceGroup == nil {
+			return
+		}
+		pod, err := NewPod(instanceGroup, ExportSettings{Opinions: model.NewEmptyOpinions()}, nil)
+		if !assert.NoError(err) {
+			return
+		}
+		actual, err := RoundtripKube(pod)
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLSubsetString(assert, `---
+			kind: Pod
+			spec:
+				activeDeadlineSeconds: 300
+				restartPolicy: OnFailure
+		`, actual)
+	})
+}
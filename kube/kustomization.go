@@ -0,0 +1,24 @@
+package kube
+
+import (
+	"sort"
+
+	"code.cloudfoundry.org/fissile/helm"
+)
+
+// MakeKustomization returns a kustomization.yaml document that lists the
+// generated resource files, relative to the output directory.
+func MakeKustomization(resources []string) helm.Node {
+	sorted := append([]string{}, resources...)
+	sort.Strings(sorted)
+
+	list := helm.NewList()
+	for _, resource := range sorted {
+		list.Add(resource)
+	}
+
+	return helm.NewMapping(
+		"apiVersion", "kustomize.config.k8s.io/v1beta1",
+		"kind", "Kustomization",
+		"resources", list)
+}
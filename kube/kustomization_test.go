@@ -0,0 +1,26 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeKustomization(t *testing.T) {
+	t.Parallel()
+
+	kustomization := MakeKustomization([]string{"secrets/secret.yaml", "auth/cluster-role.yaml"})
+
+	actual, err := RoundtripNode(kustomization, nil)
+	require.NoError(t, err)
+
+	yamltest.IsYAMLEqualString(assert.New(t), `---
+		apiVersion: kustomize.config.k8s.io/v1beta1
+		kind: Kustomization
+		resources:
+		-	auth/cluster-role.yaml
+		-	secrets/secret.yaml
+	`, actual)
+}
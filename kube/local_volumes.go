@@ -0,0 +1,171 @@
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LocalVolumesFile is the name of the file the local persistent volumes are
+// written to
+const LocalVolumesFile = "local-volumes.yaml"
+
+// Labels of the local persistent volumes, naming the instance group and the
+// volume they back
+const (
+	LocalVolumeInstanceGroupLabel = "fissile.cloudfoundry.org/instance-group"
+	LocalVolumeTagLabel           = "fissile.cloudfoundry.org/volume-tag"
+)
+
+// LocalVolumes maps the persistent volumes of the pods of the instance groups
+// onto directories of the nodes of the cluster, for clusters without dynamic
+// provisioning.
+type LocalVolumes struct {
+	// Namespace is the namespace of the claims; helm charts use the
+	// namespace of the release instead.
+	Namespace string        `yaml:"namespace"`
+	Volumes   []LocalVolume `yaml:"volumes"`
+}
+
+// LocalVolume is the directory backing the volume of a pod of an instance
+// group
+type LocalVolume struct {
+	InstanceGroup string `yaml:"instance_group"`
+	Tag           string `yaml:"tag"`
+	Index         int    `yaml:"index"`
+	Node          string `yaml:"node"`
+	Path          string `yaml:"path"`
+}
+
+// ReadLocalVolumes reads the mapping of the persistent volumes onto the
+// directories of the nodes.
+func ReadLocalVolumes(path string) (*LocalVolumes, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var volumes LocalVolumes
+	if err := yaml.Unmarshal(contents, &volumes); err != nil {
+		return nil, fmt.Errorf("Error parsing local volumes file %s: %v", path, err)
+	}
+	return &volumes, nil
+}
+
+// NewLocalPersistentVolumes returns the local PersistentVolumes pinned to
+// their nodes, with the size, access mode and storage class of the claims of
+// the stateful sets, and reserved for the claim of their pod.
+func NewLocalPersistentVolumes(settings ExportSettings) ([]helm.Node, error) {
+	if settings.LocalVolumes == nil {
+		return nil, nil
+	}
+
+	seen := map[string]bool{}
+	var nodes []helm.Node
+	for _, local := range settings.LocalVolumes.Volumes {
+		instanceGroup := settings.RoleManifest.LookupInstanceGroup(local.InstanceGroup)
+		if instanceGroup == nil {
+			return nil, fmt.Errorf("Local volume %s of unknown instance group %s", local.Tag, local.InstanceGroup)
+		}
+		if instanceGroup.Type != model.RoleTypeBosh {
+			return nil, fmt.Errorf("Local volume %s of instance group %s: only %s instance groups have persistent volumes",
+				local.Tag, local.InstanceGroup, model.RoleTypeBosh)
+		}
+		var volume *model.RoleRunVolume
+		for _, candidate := range instanceGroup.Run.Volumes {
+			if candidate.Tag == local.Tag {
+				volume = candidate
+				break
+			}
+		}
+		if volume == nil || volume.Type != model.VolumeTypePersistent {
+			return nil, fmt.Errorf("Local volume %s is not a persistent volume of instance group %s", local.Tag, local.InstanceGroup)
+		}
+		if local.Index < 0 {
+			return nil, fmt.Errorf("Local volume %s of instance group %s has negative index %d", local.Tag, local.InstanceGroup, local.Index)
+		}
+		if local.Node == "" || local.Path == "" {
+			return nil, fmt.Errorf("Local volume %s of instance group %s requires a node and a path", local.Tag, local.InstanceGroup)
+		}
+		key := fmt.Sprintf("%s-%s-%d", local.InstanceGroup, local.Tag, local.Index)
+		if seen[key] {
+			return nil, fmt.Errorf("Duplicate local volume %s of instance group %s for index %d", local.Tag, local.InstanceGroup, local.Index)
+		}
+		seen[key] = true
+
+		node, err := newLocalPersistentVolume(key, local, instanceGroup, volume, settings)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// newLocalPersistentVolume returns the PersistentVolume named name for the
+// local volume. PersistentVolumes are not namespaced, so the name is prefixed
+// with the namespace (and the release) of the claims.
+func newLocalPersistentVolume(name string, local LocalVolume, instanceGroup *model.InstanceGroup, volume *model.RoleRunVolume, settings ExportSettings) (helm.Node, error) {
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("v1").
+		SetKind("PersistentVolume").
+		AddModifier(helm.Comment(fmt.Sprintf("Volume %s of pod %d of the %s instance group on node %s",
+			local.Tag, local.Index, instanceGroup.Name, local.Node)))
+	if settings.CreateHelmChart {
+		cb.SetNameHelmExpression(fmt.Sprintf(`{{ template "fissile.SanitizeName" (printf "%%s-%%s-%%s" .Release.Namespace .Release.Name %q) }}`, name))
+	} else if settings.LocalVolumes.Namespace != "" {
+		cb.SetName(fmt.Sprintf("%s-%s", settings.LocalVolumes.Namespace, name))
+	} else {
+		cb.SetName(name)
+	}
+	pv, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	labels := pv.Get("metadata", "labels").(*helm.Mapping)
+	labels.Add(RoleNameLabel, instanceGroup.Name)
+	if settings.CreateHelmChart {
+		labels.Add("skiff-role-name", instanceGroup.Name)
+	}
+	labels.Add(LocalVolumeInstanceGroupLabel, instanceGroup.Name)
+	labels.Add(LocalVolumeTagLabel, volume.Tag)
+
+	// The size and storage class match the claim templates of getVolumeClaims
+	storageClass := string(volume.Type)
+	size := fmt.Sprintf("%dG", volume.Size)
+	if settings.CreateHelmChart {
+		storageClass = fmt.Sprintf("{{ .Values.kube.storage_class.%s | quote }}", storageClass)
+		size = fmt.Sprintf("{{ .Values.sizing.%s.disk_sizes.%s }}G", makeVarName(instanceGroup.Name), makeVarName(volume.Tag))
+	}
+
+	spec := helm.NewMapping()
+	spec.Add("capacity", helm.NewMapping("storage", size))
+	spec.Add("accessModes", helm.NewList("ReadWriteOnce"))
+	spec.Add("persistentVolumeReclaimPolicy", "Retain")
+	spec.Add("storageClassName", storageClass)
+	spec.Add("volumeMode", "Filesystem")
+	spec.Add("local", helm.NewMapping("path", local.Path))
+	hostname := helm.NewMapping("key", "kubernetes.io/hostname", "operator", "In", "values", helm.NewList(local.Node))
+	term := helm.NewMapping("matchExpressions", helm.NewList(hostname))
+	spec.Add("nodeAffinity", helm.NewMapping("required", helm.NewMapping("nodeSelectorTerms", helm.NewList(term))))
+
+	// Claims of stateful sets are named <claim template>-<stateful set>-<ordinal>
+	claimRef := helm.NewMapping()
+	if settings.CreateHelmChart {
+		claimRef.Add("namespace", "{{ .Release.Namespace }}")
+		claimRef.Add("name", fmt.Sprintf(`{{ printf "%%s-%%s-%%d" %q (include "fissile.Name" (list $ %q)) %d }}`,
+			volume.Tag, instanceGroup.Name, local.Index))
+	} else if settings.LocalVolumes.Namespace != "" {
+		claimRef.Add("namespace", settings.LocalVolumes.Namespace)
+		claimRef.Add("name", fmt.Sprintf("%s-%s-%d", volume.Tag, instanceGroup.Name, local.Index))
+	}
+	if len(claimRef.Names()) > 0 {
+		spec.Add("claimRef", claimRef)
+	}
+	pv.Add("spec", spec)
+
+	return pv, nil
+}
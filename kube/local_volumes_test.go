@@ -0,0 +1,181 @@
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLocalVolumes(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "fissile-local-volumes")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "local-volumes.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`---
+namespace: cf
+volumes:
+- instance_group: nats
+  tag: nats-data
+  index: 1
+  node: worker-2
+  path: /mnt/disks/nats-1
+`), 0644))
+	volumes, err := ReadLocalVolumes(path)
+	require.NoError(t, err)
+	assert.Equal(t, &LocalVolumes{
+		Namespace: "cf",
+		Volumes: []LocalVolume{
+			{InstanceGroup: "nats", Tag: "nats-data", Index: 1, Node: "worker-2", Path: "/mnt/disks/nats-1"},
+		},
+	}, volumes)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`volumes: {}`), 0644))
+	_, err = ReadLocalVolumes(path)
+	assert.Error(t, err)
+}
+
+func TestNewLocalPersistentVolumes(t *testing.T) {
+	t.Parallel()
+
+	manifest := &model.RoleManifest{InstanceGroups: model.InstanceGroups{
+		&model.InstanceGroup{
+			Name: "nats",
+			Type: model.RoleTypeBosh,
+			Run: &model.RoleRun{Volumes: []*model.RoleRunVolume{
+				{Type: model.VolumeTypePersistent, Tag: "nats-data", Path: "/var/vcap/store", Size: 5},
+				{Type: model.VolumeTypeShared, Tag: "nats-shared", Path: "/shared", Size: 1},
+			}},
+		},
+		&model.InstanceGroup{Name: "post-deployment", Type: model.RoleTypeBoshTask, Run: &model.RoleRun{}},
+	}}
+	local := LocalVolume{InstanceGroup: "nats", Tag: "nats-data", Index: 1, Node: "worker-2", Path: "/mnt/disks/nats-1"}
+
+	t.Run("Kube", func(t *testing.T) {
+		t.Parallel()
+		settings := ExportSettings{
+			RoleManifest: manifest,
+			LocalVolumes: &LocalVolumes{Namespace: "cf", Volumes: []LocalVolume{local}},
+		}
+		nodes, err := NewLocalPersistentVolumes(settings)
+		require.NoError(t, err)
+		require.Len(t, nodes, 1)
+
+		actual, err := RoundtripNode(nodes[0], nil)
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			apiVersion: v1
+			kind: PersistentVolume
+			metadata:
+				name: cf-nats-nats-data-1
+				labels:
+					app.kubernetes.io/component: nats
+					fissile.cloudfoundry.org/instance-group: nats
+					fissile.cloudfoundry.org/volume-tag: nats-data
+			spec:
+				capacity:
+					storage: 5G
+				accessModes: [ReadWriteOnce]
+				persistentVolumeReclaimPolicy: Retain
+				storageClassName: persistent
+				volumeMode: Filesystem
+				local:
+					path: /mnt/disks/nats-1
+				nodeAffinity:
+					required:
+						nodeSelectorTerms:
+						-	matchExpressions:
+							-	key: kubernetes.io/hostname
+								operator: In
+								values: [worker-2]
+				claimRef:
+					namespace: cf
+					name: nats-data-nats-1
+		`, actual)
+
+		settings.LocalVolumes.Namespace = ""
+		nodes, err = NewLocalPersistentVolumes(settings)
+		require.NoError(t, err)
+		actual, err = RoundtripNode(nodes[0], nil)
+		require.NoError(t, err)
+		assert.NotContains(t, actual.(map[interface{}]interface{})["spec"], "claimRef",
+			"Claims in an unknown namespace should not be referenced")
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			metadata:
+				name: nats-nats-data-1
+		`, actual)
+	})
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		settings := ExportSettings{
+			CreateHelmChart: true,
+			RoleManifest:    manifest,
+			LocalVolumes:    &LocalVolumes{Volumes: []LocalVolume{local}},
+		}
+		nodes, err := NewLocalPersistentVolumes(settings)
+		require.NoError(t, err)
+		require.Len(t, nodes, 1)
+
+		actual, err := RoundtripNode(nodes[0], map[string]interface{}{
+			"Release.Namespace":                       "scf",
+			"Values.name_prefix.enabled":              true,
+			"Values.kube.storage_class.persistent":    "local-storage",
+			"Values.sizing.nats.disk_sizes.nats_data": 20,
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			metadata:
+				name: scf-MyRelease-nats-nats-data-1
+				labels:
+					app.kubernetes.io/component: nats
+					skiff-role-name: nats
+			spec:
+				capacity:
+					storage: 20G
+				storageClassName: local-storage
+				claimRef:
+					namespace: scf
+					name: nats-data-MyRelease-nats-1
+		`, actual)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		for _, sample := range []struct {
+			volume LocalVolume
+			err    string
+		}{
+			{LocalVolume{InstanceGroup: "api", Tag: "data", Node: "n", Path: "/p"},
+				"Local volume data of unknown instance group api"},
+			{LocalVolume{InstanceGroup: "post-deployment", Tag: "data", Node: "n", Path: "/p"},
+				"Local volume data of instance group post-deployment: only bosh instance groups have persistent volumes"},
+			{LocalVolume{InstanceGroup: "nats", Tag: "nats-shared", Node: "n", Path: "/p"},
+				"Local volume nats-shared is not a persistent volume of instance group nats"},
+			{LocalVolume{InstanceGroup: "nats", Tag: "nats-data", Index: -1, Node: "n", Path: "/p"},
+				"Local volume nats-data of instance group nats has negative index -1"},
+			{LocalVolume{InstanceGroup: "nats", Tag: "nats-data", Node: "n"},
+				"Local volume nats-data of instance group nats requires a node and a path"},
+		} {
+			_, err := NewLocalPersistentVolumes(ExportSettings{
+				RoleManifest: manifest,
+				LocalVolumes: &LocalVolumes{Volumes: []LocalVolume{sample.volume}},
+			})
+			assert.EqualError(t, err, sample.err)
+		}
+
+		_, err := NewLocalPersistentVolumes(ExportSettings{
+			RoleManifest: manifest,
+			LocalVolumes: &LocalVolumes{Volumes: []LocalVolume{local, local}},
+		})
+		assert.EqualError(t, err, "Duplicate local volume nats-data of instance group nats for index 1")
+	})
+}
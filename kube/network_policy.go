@@ -0,0 +1,134 @@
+package kube
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// NewNetworkPolicy returns a NetworkPolicy restricting the ingress of the
+// pods of the instance group. Public ports are reachable from anywhere; all
+// other ports only from the pods of the instance group itself and of the
+// instance groups consuming the links of its jobs (or of its colocated
+// containers). It is only created if .Values.network_policies.enabled is set.
+func NewNetworkPolicy(instanceGroup *model.InstanceGroup, settings ExportSettings) (helm.Node, error) {
+	if !settings.CreateHelmChart {
+		return nil, fmt.Errorf("Network policies require a helm chart")
+	}
+
+	block := "if .Values.network_policies.enabled"
+	if featureBlock := featureCheckBlock(instanceGroup); featureBlock != "" {
+		block = fmt.Sprintf("if and .Values.network_policies.enabled (%s)", strings.TrimPrefix(featureBlock, "if "))
+	}
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("networking.k8s.io/v1").
+		SetKind("NetworkPolicy").
+		SetName(instanceGroup.Name).
+		AddModifier(helm.Block(block)).
+		AddModifier(helm.Comment(fmt.Sprintf("Ingress of the %s instance group, restricted to the consumers of its links", instanceGroup.Name)))
+	policy, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+
+	members := append(model.InstanceGroups{instanceGroup}, instanceGroup.GetColocatedRoles()...)
+	var publicPorts, privatePorts []helm.Node
+	for _, member := range members {
+		for _, job := range member.JobReferences {
+			for _, port := range job.ContainerProperties.BoshContainerization.Ports {
+				if port.Public {
+					publicPorts = append(publicPorts, networkPolicyPorts(member, port, settings)...)
+				} else {
+					privatePorts = append(privatePorts, networkPolicyPorts(member, port, settings)...)
+				}
+			}
+		}
+	}
+
+	ingress := helm.NewList()
+	if len(publicPorts) > 0 {
+		ingress.Add(helm.NewMapping("ports", helm.NewNode(publicPorts)))
+	}
+	if len(privatePorts) > 0 {
+		from := helm.NewList()
+		for _, consumer := range linkConsumers(instanceGroup, settings.RoleManifest) {
+			from.Add(helm.NewMapping("podSelector", helm.NewMapping("matchLabels", networkPolicySelector(consumer, settings))))
+		}
+		ingress.Add(helm.NewMapping("from", from, "ports", helm.NewNode(privatePorts)))
+	}
+
+	spec := helm.NewMapping()
+	spec.Add("podSelector", helm.NewMapping("matchLabels", networkPolicySelector(instanceGroup.Name, settings)))
+	spec.Add("policyTypes", helm.NewList("Ingress"))
+	spec.Add("ingress", ingress)
+	policy.Add("spec", spec)
+
+	return policy, nil
+}
+
+// networkPolicySelector returns the labels selecting the pods of an instance
+// group
+func networkPolicySelector(instanceGroupName string, settings ExportSettings) *helm.Mapping {
+	selector := helm.NewMapping(RoleNameLabel, instanceGroupName)
+	addInstanceSelector(selector, settings)
+	return selector
+}
+
+// networkPolicyPorts returns the ports of a network policy for an exposed
+// port, i.e. the container ports it is listening on
+func networkPolicyPorts(instanceGroup *model.InstanceGroup, port model.JobExposedPort, settings ExportSettings) []helm.Node {
+	if settings.CreateHelmChart && port.CountIsConfigurable {
+		sizing := fmt.Sprintf(".Values.sizing.%s.ports.%s", makeVarName(instanceGroup.Name), makeVarName(port.Name))
+		newPort := helm.NewMapping(
+			"port", fmt.Sprintf("{{ add %d $port }}", port.InternalPort),
+			"protocol", port.Protocol)
+		newPort.Set(helm.Block(fmt.Sprintf("range $port := until (int %s.count)", sizing)))
+		return []helm.Node{newPort}
+	}
+
+	var ports []helm.Node
+	for portNumber := port.InternalPort; portNumber < port.InternalPort+port.Count; portNumber++ {
+		ports = append(ports, helm.NewMapping("port", portNumber, "protocol", port.Protocol))
+	}
+	return ports
+}
+
+// linkConsumers returns the sorted names of the instance groups whose pods
+// consume the links of the jobs of the pod of the instance group, including
+// the instance group itself. Colocated containers are replaced by the
+// instance groups hosting them.
+func linkConsumers(instanceGroup *model.InstanceGroup, roleManifest *model.RoleManifest) []string {
+	consumers := map[string]bool{instanceGroup.Name: true}
+	for _, member := range append(model.InstanceGroups{instanceGroup}, instanceGroup.GetColocatedRoles()...) {
+		for _, job := range member.JobReferences {
+			for _, links := range job.ResolvedConsumedBy {
+				for _, link := range links {
+					consumer := roleManifest.LookupInstanceGroup(link.RoleName)
+					if consumer == nil || !consumer.IsColocated() {
+						consumers[link.RoleName] = true
+						continue
+					}
+					for _, host := range roleManifest.InstanceGroups {
+						for _, colocated := range host.GetColocatedRoles() {
+							if colocated.Name == consumer.Name {
+								consumers[host.Name] = true
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	var names []string
+	for name := range consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
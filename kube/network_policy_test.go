@@ -0,0 +1,112 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewNetworkPolicy(t *testing.T) {
+	t.Parallel()
+
+	provider := &model.InstanceGroup{
+		Name: "nats",
+		Type: model.RoleTypeBosh,
+		Run:  &model.RoleRun{FlightStage: model.FlightStageFlight},
+		JobReferences: model.JobReferences{
+			&model.JobReference{
+				Name: "nats",
+				ContainerProperties: model.JobContainerProperties{
+					BoshContainerization: model.JobBoshContainerization{
+						Ports: []model.JobExposedPort{
+							{Name: "nats", Protocol: "TCP", InternalPort: 4222, Count: 1},
+							{Name: "monitor", Protocol: "TCP", InternalPort: 8222, Count: 1, Public: true},
+						},
+					},
+				},
+				ResolvedConsumedBy: map[string][]model.JobLinkInfo{
+					"nats": {
+						{RoleName: "router", JobName: "gorouter"},
+						{RoleName: "api", JobName: "cloud_controller_ng"},
+					},
+				},
+			},
+		},
+	}
+	settings := ExportSettings{
+		CreateHelmChart: true,
+		RoleManifest: &model.RoleManifest{InstanceGroups: model.InstanceGroups{
+			provider,
+			&model.InstanceGroup{Name: "router", Type: model.RoleTypeBosh},
+			&model.InstanceGroup{Name: "api", Type: model.RoleTypeBosh},
+		}},
+	}
+
+	_, err := NewNetworkPolicy(provider, ExportSettings{RoleManifest: settings.RoleManifest})
+	assert.Error(t, err, "Should require a helm chart")
+
+	policy, err := NewNetworkPolicy(provider, settings)
+	require.NoError(t, err)
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(policy, map[string]interface{}{})
+		require.NoError(t, err)
+		assert.Nil(t, actual)
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(policy, map[string]interface{}{
+			"Values.network_policies.enabled": true,
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			apiVersion: networking.k8s.io/v1
+			kind: NetworkPolicy
+			metadata:
+				name: nats
+			spec:
+				podSelector:
+					matchLabels:
+						app.kubernetes.io/component: nats
+				policyTypes: [Ingress]
+				ingress:
+				-	ports:
+					-	port: 8222
+						protocol: TCP
+				-	from:
+					-	podSelector:
+							matchLabels:
+								app.kubernetes.io/component: api
+					-	podSelector:
+							matchLabels:
+								app.kubernetes.io/component: nats
+					-	podSelector:
+							matchLabels:
+								app.kubernetes.io/component: router
+					ports:
+					-	port: 4222
+						protocol: TCP
+		`, actual)
+	})
+
+	t.Run("NamePrefix", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(policy, map[string]interface{}{
+			"Values.network_policies.enabled": true,
+			"Values.name_prefix.enabled":      true,
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			spec:
+				podSelector:
+					matchLabels:
+						app.kubernetes.io/component: nats
+						app.kubernetes.io/instance: MyRelease
+		`, actual)
+	})
+}
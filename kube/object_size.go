@@ -0,0 +1,162 @@
+package kube
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Default thresholds of the size of rendered objects, in bytes
+const (
+	// DefaultObjectSizeWarning is the limit of the data of secrets and
+	// config maps; other objects this large are close to the hard limit
+	DefaultObjectSizeWarning = 1024 * 1024
+	// DefaultObjectSizeLimit is the default maximum request size of etcd;
+	// larger objects are rejected by the API server
+	DefaultObjectSizeLimit = 1536 * 1024
+)
+
+// ObjectSize is the size of a rendered object, as JSON
+type ObjectSize struct {
+	Kind string
+	Name string
+	Size int
+	// EnvSize is the size of the environment variables of the containers,
+	// for workloads
+	EnvSize int
+}
+
+// String returns the object as <kind>/<name>
+func (s ObjectSize) String() string {
+	return fmt.Sprintf("%s/%s", s.Kind, s.Name)
+}
+
+// Hint suggests how to reduce the size of the object
+func (s ObjectSize) Hint() string {
+	switch {
+	case s.Kind == "Secret" || s.Kind == "ConfigMap":
+		return fmt.Sprintf("split the data of the %s into several ones", s.Kind)
+	case s.EnvSize*2 >= s.Size:
+		return fmt.Sprintf("the environment variables take %s; move them into a config map or secret referenced by envFrom", formatObjectSize(s.EnvSize))
+	}
+	return "move large values into config maps or secrets mounted as files"
+}
+
+// MeasureRenderedObjects returns the sizes of the objects of rendered output,
+// which may contain several YAML documents
+func MeasureRenderedObjects(output []byte) ([]ObjectSize, error) {
+	var sizes []ObjectSize
+	decoder := yaml.NewDecoder(bytes.NewReader(output))
+	for {
+		var document interface{}
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			return sizes, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing the rendered objects: %v", err)
+		}
+		object, ok := toJSONValue(document).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// The items of lists are created as objects of their own
+		objects := []interface{}{object}
+		if object["kind"] == "List" {
+			objects, _ = object["items"].([]interface{})
+		}
+		for _, item := range objects {
+			item, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			objectSize, err := measureObject(item)
+			if err != nil {
+				return nil, err
+			}
+			sizes = append(sizes, objectSize)
+		}
+	}
+}
+
+// measureObject returns the size of an object
+func measureObject(object map[string]interface{}) (ObjectSize, error) {
+	size, err := jsonSize(object)
+	if err != nil {
+		return ObjectSize{}, err
+	}
+	objectSize := ObjectSize{Kind: fmt.Sprintf("%v", object["kind"]), Size: size}
+	if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+		objectSize.Name = fmt.Sprintf("%v", metadata["name"])
+	}
+	objectSize.EnvSize, err = podEnvSize(object)
+	return objectSize, err
+}
+
+// CheckObjectSizes measures the objects of rendered output. It returns a
+// warning for each object above the warning threshold, and an error if any
+// is above the limit; thresholds of 0 aren't checked.
+func CheckObjectSizes(output []byte, warning, limit int) ([]string, error) {
+	if warning <= 0 && limit <= 0 {
+		return nil, nil
+	}
+	sizes, err := MeasureRenderedObjects(output)
+	if err != nil {
+		return nil, err
+	}
+	var warnings []string
+	for _, size := range sizes {
+		if limit > 0 && size.Size > limit {
+			return warnings, fmt.Errorf("%s is %s, above the limit of %s; %s",
+				size, formatObjectSize(size.Size), formatObjectSize(limit), size.Hint())
+		}
+		if warning > 0 && size.Size > warning {
+			warnings = append(warnings, fmt.Sprintf("%s is %s, above %s; %s",
+				size, formatObjectSize(size.Size), formatObjectSize(warning), size.Hint()))
+		}
+	}
+	return warnings, nil
+}
+
+// podEnvSize returns the size of the environment variables of the containers
+// of a pod, or of the pod template of a workload
+func podEnvSize(object map[string]interface{}) (int, error) {
+	spec, _ := object["spec"].(map[string]interface{})
+	if object["kind"] != "Pod" {
+		template, _ := spec["template"].(map[string]interface{})
+		spec, _ = template["spec"].(map[string]interface{})
+	}
+	size := 0
+	for _, key := range []string{"initContainers", "containers"} {
+		containers, _ := spec[key].([]interface{})
+		for _, container := range containers {
+			container, _ := container.(map[string]interface{})
+			env, ok := container["env"]
+			if !ok {
+				continue
+			}
+			envSize, err := jsonSize(env)
+			if err != nil {
+				return 0, err
+			}
+			size += envSize
+		}
+	}
+	return size, nil
+}
+
+func jsonSize(value interface{}) (int, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("Error measuring the rendered objects: %v", err)
+	}
+	return len(encoded), nil
+}
+
+// formatObjectSize returns the size in KiB
+func formatObjectSize(size int) string {
+	return fmt.Sprintf("%.1fKiB", float64(size)/1024)
+}
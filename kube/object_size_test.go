@@ -0,0 +1,109 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasureRenderedObjects(t *testing.T) {
+	t.Parallel()
+
+	output := []byte(`---
+# The secrets
+apiVersion: "v1"
+kind: "Secret"
+metadata:
+  name: "secrets"
+data:
+  password: "c2VjcmV0"
+---
+apiVersion: "v1"
+kind: "List"
+items:
+- apiVersion: "v1"
+  kind: "Service"
+  metadata:
+    name: "api"
+---
+apiVersion: "apps/v1"
+kind: "StatefulSet"
+metadata:
+  name: "api"
+spec:
+  template:
+    spec:
+      containers:
+      - name: "api"
+        env:
+        - name: "KEY"
+          value: "value"
+`)
+
+	sizes, err := MeasureRenderedObjects(output)
+	require.NoError(t, err)
+	require.Len(t, sizes, 3)
+
+	assert.Equal(t, "Secret/secrets", sizes[0].String())
+	assert.Equal(t, len(`{"apiVersion":"v1","data":{"password":"c2VjcmV0"},"kind":"Secret","metadata":{"name":"secrets"}}`), sizes[0].Size)
+	assert.Equal(t, 0, sizes[0].EnvSize)
+
+	assert.Equal(t, "Service/api", sizes[1].String())
+
+	assert.Equal(t, "StatefulSet/api", sizes[2].String())
+	assert.Equal(t, len(`[{"name":"KEY","value":"value"}]`), sizes[2].EnvSize)
+
+	_, err = MeasureRenderedObjects([]byte("kind: [\n"))
+	assert.Error(t, err)
+}
+
+func TestCheckObjectSizes(t *testing.T) {
+	t.Parallel()
+
+	var env []string
+	for i := 0; i < 100; i++ {
+		env = append(env, fmt.Sprintf("        - name: \"KEY_%d\"\n          value: \"%s\"\n", i, strings.Repeat("x", 100)))
+	}
+	output := []byte(`---
+kind: "Secret"
+metadata:
+  name: "secrets"
+data:
+  password: "` + strings.Repeat("x", 5000) + `"
+---
+kind: "Deployment"
+metadata:
+  name: "api"
+spec:
+  template:
+    spec:
+      containers:
+      - name: "api"
+        env:
+` + strings.Join(env, "") + `---
+kind: "Service"
+metadata:
+  name: "api"
+`)
+
+	warnings, err := CheckObjectSizes(output, 4096, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, warnings, 2) {
+		assert.Contains(t, warnings[0], "Secret/secrets is 5.0KiB, above 4.0KiB; split the data of the Secret")
+		assert.Contains(t, warnings[1], "Deployment/api is")
+		assert.Contains(t, warnings[1], "move them into a config map or secret referenced by envFrom")
+	}
+
+	warnings, err = CheckObjectSizes(output, 0, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	_, err = CheckObjectSizes(output, 4096, 8192)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Deployment/api is")
+		assert.Contains(t, err.Error(), "above the limit of 8.0KiB")
+	}
+}
@@ -2,9 +2,11 @@ package kube
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"path"
 	"regexp"
 	"sort"
 	"strconv"
@@ -27,32 +29,80 @@ func NewPodTemplate(role *model.InstanceGroup, settings ExportSettings, grapher
 	}
 
 	containers := helm.NewList()
+	var containerMappings []*helm.Mapping
 	for _, candidate := range append([]*model.InstanceGroup{role}, role.GetColocatedRoles()...) {
 		containerMapping, err := getContainerMapping(candidate, settings, grapher)
 		if err != nil {
 			return nil, err
 		}
+		containerMappings = append(containerMappings, containerMapping)
+
+		// The pre-stop script learns its budget for draining the jobs
+		containerMapping.Get("env").(*helm.List).Add(helm.NewMapping(
+			"name", "TERMINATION_GRACE_PERIOD_SECONDS",
+			"value", strconv.Itoa(role.Run.GracePeriodSeconds())))
+
+		if settings.CreateHelmChart && candidate == role {
+			addExtensionListElements(containerMapping.Get("env").(*helm.List), ExtensionExtraEnv, role.Name)
+		}
+
+		// The shared sockets of the pod are mounted into all containers
+		for _, socket := range role.PodSharedSockets() {
+			mount := helm.NewMapping("mountPath", socket.Path, "name", socket.Tag)
+			containerMapping.Get("volumeMounts").(*helm.List).Add(mount)
+		}
 
 		node := helm.NewNode(containerMapping)
 		addFeatureCheck(candidate, node)
 		containers.Add(node)
 	}
 
-	imagePullSecrets := helm.NewMapping("name", "registry-credentials")
+	for _, sidecar := range role.Run.Sidecars {
+		containerMapping := getSidecarContainerMapping(role, sidecar, settings)
+		for _, socket := range role.PodSharedSockets() {
+			mount := helm.NewMapping("mountPath", socket.Path, "name", socket.Tag)
+			containerMapping.Get("volumeMounts").(*helm.List).Add(mount)
+		}
+		containers.Add(containerMapping)
+	}
+
+	initContainers := helm.NewList()
+	for _, candidate := range append([]*model.InstanceGroup{role}, role.GetColocatedRoles()...) {
+		seedContainer, err := getWritablePathSeedContainer(candidate, settings, grapher)
+		if err != nil {
+			return nil, err
+		}
+		if seedContainer != nil {
+			addFeatureCheck(candidate, seedContainer)
+			initContainers.Add(seedContainer)
+		}
+	}
+
+	volumes := getNonClaimVolumes(role, settings)
+	if settings.CreateHelmChart {
+		addExtensionListElements(containers, ExtensionExtraContainers, role.Name)
+		addExtensionListElements(volumes.(*helm.List), ExtensionExtraVolumes, role.Name)
+	}
+
+	imagePullSecrets := helm.NewMapping("name", resourceName(registryCredentialsName, settings))
 
 	spec := helm.NewMapping()
+	if len(initContainers.Values()) > 0 {
+		spec.Add("initContainers", initContainers)
+	}
 	spec.Add("containers", containers)
 	spec.Add("imagePullSecrets", helm.NewList(imagePullSecrets))
 	spec.Add("dnsPolicy", "ClusterFirst")
-	spec.Add("volumes", getNonClaimVolumes(role, settings))
+	spec.Add("volumes", volumes)
 	spec.Add("restartPolicy", "Always")
-	spec.Add("serviceAccountName", role.Run.ServiceAccount, authModeRBAC(settings))
+	spec.Add("serviceAccountName", serviceAccountName(role.Run.ServiceAccount, settings), authModeRBAC(settings))
+	if podSecurityContext := getPodSecurityContext(role); podSecurityContext != nil {
+		spec.Add("securityContext", podSecurityContext)
+	}
 	if settings.CreateHelmChart {
 		spec.Get("imagePullSecrets").Set(helm.Block(`if ne .Values.kube.registry.username ""`))
 	}
-	// BOSH can potentially have an infinite termination grace period; we don't
-	// really trust that, so we'll just go with ten minutes and hope it's enough
-	spec.Add("terminationGracePeriodSeconds", 600)
+	spec.Add("terminationGracePeriodSeconds", role.Run.GracePeriodSeconds())
 	spec.Sort()
 
 	podTemplate := helm.NewMapping()
@@ -68,12 +118,26 @@ func NewPodTemplate(role *model.InstanceGroup, settings ExportSettings, grapher
 		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
 	}
 	meta := pod.Get("metadata").(*helm.Mapping)
+	annotations := helm.NewMapping()
 	if settings.CreateHelmChart {
-		annotations := helm.NewMapping()
 		annotations.Add("checksum/config", `{{ include (print $.Template.BasePath "/secrets.yaml") . | sha256sum }}`)
 		if role.Type == model.RoleTypeBosh && !role.HasTag(model.RoleTagIstioManaged) {
 			annotations.Add("sidecar.istio.io/inject", "false", helm.Block("if .Values.config.use_istio"))
 		}
+	}
+	if err := addBackupAnnotations(annotations, role); err != nil {
+		return nil, err
+	}
+	if err := addVaultSecrets(role, annotations, containerMappings, volumes.(*helm.List), settings); err != nil {
+		return nil, err
+	}
+	if err := addExternalSecretsVolume(role, containerMappings, volumes.(*helm.List), settings); err != nil {
+		return nil, err
+	}
+	if settings.CreateHelmChart {
+		addExtensionMappingEntries(annotations, ExtensionPodAnnotations, role.Name)
+	}
+	if len(annotations.Names()) > 0 {
 		meta.Add("annotations", annotations)
 	}
 	podTemplate.Add("metadata", meta)
@@ -90,13 +154,16 @@ func NewPod(role *model.InstanceGroup, settings ExportSettings, grapher util.Mod
 	}
 
 	// Pod must have a restart policy that isn't "always"
-	switch role.Run.FlightStage {
-	case model.FlightStageManual:
-		podTemplate.Get("spec", "restartPolicy").SetValue("Never")
-	case model.FlightStageFlight, model.FlightStagePreFlight, model.FlightStagePostFlight:
-		podTemplate.Get("spec", "restartPolicy").SetValue("OnFailure")
-	default:
-		return nil, fmt.Errorf("Role %s has unexpected flight stage %s", role.Name, role.Run.FlightStage)
+	restartPolicy, err := jobRestartPolicy(role, settings)
+	if err != nil {
+		return nil, err
+	}
+	podTemplate.Get("spec", "restartPolicy").SetValue(restartPolicy)
+	addJobSettings(role, podTemplate.Get("spec").(*helm.Mapping), settings, "activeDeadlineSeconds")
+
+	err = addPodAffinity(role, podTemplate.Get("spec").(*helm.Mapping), settings)
+	if err != nil {
+		return nil, err
 	}
 
 	cb := NewConfigBuilder().
@@ -173,7 +240,7 @@ func getContainerMapping(role *model.InstanceGroup, settings ExportSettings, gra
 		}
 	}
 
-	securityContext := getSecurityContext(role)
+	securityContext := getSecurityContext(role, settings)
 	ports, err := getContainerPorts(role, settings)
 	if err != nil {
 		return nil, err
@@ -199,18 +266,64 @@ func getContainerMapping(role *model.InstanceGroup, settings ExportSettings, gra
 	container.Add("env", vars)
 	container.Add("resources", resources)
 	container.Add("securityContext", securityContext)
-	container.Add("livenessProbe", livenessProbe)
-	container.Add("readinessProbe", readinessProbe)
 	container.Add("lifecycle",
 		helm.NewMapping("preStop",
 			helm.NewMapping("exec",
 				helm.NewMapping("command",
 					[]string{"/opt/fissile/pre-stop.sh"}))))
+
+	if settings.CreateHelmChart {
+		addContainerDebugCommand(container, role, roleVarName, livenessProbe, readinessProbe)
+	} else {
+		container.Add("livenessProbe", livenessProbe)
+		container.Add("readinessProbe", readinessProbe)
+		if len(role.Run.Command) > 0 {
+			container.Add("command", role.Run.Command)
+		}
+		if len(role.Run.Args) > 0 {
+			container.Add("args", role.Run.Args)
+		}
+	}
 	container.Sort()
 
 	return container, nil
 }
 
+// addContainerDebugCommand adds the command and args of the role to the
+// container, allowing the command to be overridden via
+// sizing.<role>.debug.command to start the container in a diagnostic mode.
+// The probes are dropped in that case, to keep the pod from being restarted.
+func addContainerDebugCommand(container *helm.Mapping, role *model.InstanceGroup, roleVarName string, livenessProbe, readinessProbe helm.Node) {
+	debugCommand := fmt.Sprintf(".Values.sizing.%s.debug.command", roleVarName)
+
+	if len(role.Run.Command) > 0 {
+		var command []string
+		for _, arg := range role.Run.Command {
+			command = append(command, strconv.Quote(arg))
+		}
+		container.Add("command", fmt.Sprintf("{{ %s | default (list %s) | toJson }}",
+			debugCommand, strings.Join(command, " ")))
+	} else {
+		container.Add("command", fmt.Sprintf("{{ toJson %s }}", debugCommand), helm.Block("if "+debugCommand))
+	}
+	if len(role.Run.Args) > 0 {
+		container.Add("args", role.Run.Args, helm.Block("if not "+debugCommand))
+	}
+	container.Add("stdin", true, helm.Block("if "+debugCommand))
+	container.Add("tty", true, helm.Block("if "+debugCommand))
+
+	if livenessProbe == nil {
+		container.Add("livenessProbe", nil)
+	} else {
+		container.Add("livenessProbe", livenessProbe, helm.Block("if not "+debugCommand))
+	}
+	if readinessProbe == nil {
+		container.Add("readinessProbe", nil)
+	} else {
+		container.Add("readinessProbe", readinessProbe, helm.Block("if not "+debugCommand))
+	}
+}
+
 // getContainerImageName returns the name of the docker image to use for a role
 func getContainerImageName(role *model.InstanceGroup, settings ExportSettings, grapher util.ModelGrapher) (string, error) {
 	devVersion, err := role.GetRoleDevVersion(settings.Opinions, settings.TagExtra, settings.FissileVersion, grapher)
@@ -218,16 +331,27 @@ func getContainerImageName(role *model.InstanceGroup, settings ExportSettings, g
 		return "", err
 	}
 
-	var imageName string
+	registry := settings.Registry
+	org := settings.Organization
 	if settings.CreateHelmChart {
-		registry := "{{ .Values.kube.registry.hostname }}"
-		org := "{{ .Values.kube.organization }}"
-		imageName = builder.GetRoleDevImageName(registry, org, settings.Repository, role, devVersion)
-	} else {
-		imageName = builder.GetRoleDevImageName(settings.Registry, settings.Organization, settings.Repository, role, devVersion)
+		registry = "{{ .Values.kube.registry.hostname }}"
+		org = "{{ .Values.kube.organization }}"
+	}
+	imageName := builder.GetRoleDevImageName(registry, org, settings.Repository, role, devVersion)
+	if settings.ImageDigests == nil {
+		return imageName, nil
 	}
 
-	return imageName, nil
+	digest, ok := settings.ImageDigests[role.ImageName()]
+	if !ok {
+		return "", fmt.Errorf("No image digest for instance group %s", role.ImageName())
+	}
+	digestName := builder.GetRoleDevImageDigestName(registry, org, settings.Repository, role, digest)
+	if settings.CreateHelmChart {
+		// The tags remain available for development
+		return fmt.Sprintf("{{ if .Values.kube.pin_image_digests }}%s{{ else }}%s{{ end }}", digestName, imageName), nil
+	}
+	return digestName, nil
 }
 
 // getContainerPorts returns a list of ports for a role
@@ -251,9 +375,9 @@ func getContainerPorts(role *model.InstanceGroup, settings ExportSettings) (helm
 				newPort.Set(helm.Block(block))
 				newPort.Add("containerPort", fmt.Sprintf("{{ add %d $port }}", port.InternalPort))
 				if port.Max > 1 {
-					newPort.Add("name", fmt.Sprintf("%s-{{ $port }}", port.Name))
+					newPort.Add("name", fmt.Sprintf("%s-{{ $port }}", port.KubeName()))
 				} else {
-					newPort.Add("name", port.Name)
+					newPort.Add("name", port.KubeName())
 				}
 				newPort.Add("protocol", port.Protocol)
 				ports = append(ports, newPort)
@@ -262,9 +386,9 @@ func getContainerPorts(role *model.InstanceGroup, settings ExportSettings) (helm
 					newPort := helm.NewMapping()
 					newPort.Add("containerPort", portNumber)
 					if port.Max > 1 {
-						newPort.Add("name", fmt.Sprintf("%s-%d", port.Name, portNumber))
+						newPort.Add("name", fmt.Sprintf("%s-%d", port.KubeName(), portNumber))
 					} else {
-						newPort.Add("name", port.Name)
+						newPort.Add("name", port.KubeName())
 					}
 					newPort.Add("protocol", port.Protocol)
 					ports = append(ports, newPort)
@@ -284,6 +408,10 @@ func getVolumeMounts(role *model.InstanceGroup, settings ExportSettings) helm.No
 	var mount helm.Node
 	for _, volume := range role.Run.Volumes {
 		switch volume.Type {
+		case model.VolumeTypeSharedSocket:
+			// Mounted into all containers of the pod by NewPodTemplate
+			continue
+
 		case model.VolumeTypeEmptyDir:
 			mount = helm.NewMapping("mountPath", volume.Path, "name", volume.Tag)
 
@@ -297,23 +425,96 @@ func getVolumeMounts(role *model.InstanceGroup, settings ExportSettings) helm.No
 		mounts = append(mounts, mount)
 	}
 
+	if role.Run.ReadOnlyRootFilesystem {
+		for index, writablePath := range role.Run.WritablePaths {
+			mounts = append(mounts, helm.NewMapping("mountPath", writablePath.Path, "name", writablePathVolumeName(role, index)))
+		}
+	}
+
+	if role.Run.DownwardAPIPath != "" {
+		mounts = append(mounts, helm.NewMapping("mountPath", role.Run.DownwardAPIPath, "name", downwardAPIVolumeName, "readOnly", true))
+	}
+
+	for index, token := range role.Run.ServiceAccountTokens {
+		mounts = append(mounts, helm.NewMapping("mountPath", token.Path, "name", serviceAccountTokenVolumeName(role, index), "readOnly", true))
+	}
+
 	// Mount the bosh deployment manifest secret if it is available
-	mount = helm.NewMapping("mountPath", "/opt/fissile/config", "name", "deployment-manifest", "readOnly", true)
-	mounts = append(mounts, mount)
+	if !role.HasTag(model.RoleTagNoDeploymentManifest) {
+		mount = helm.NewMapping("mountPath", "/opt/fissile/config", "name", "deployment-manifest", "readOnly", true)
+		mounts = append(mounts, mount)
+	}
 
 	return helm.NewNode(mounts)
 }
 
+// downwardAPIVolumeName is the name of the volume exposing the metadata of
+// the pod as files
+const downwardAPIVolumeName = "downward-api"
+
+// writablePathVolumeName returns the name of the volume backing a writable
+// path of a role with a read-only root filesystem
+func writablePathVolumeName(role *model.InstanceGroup, index int) string {
+	return fmt.Sprintf("%s-writable-%d", role.Name, index)
+}
+
+// writablePathSeedDir is the directory the seed container mounts the
+// volumes of the seeded writable paths below
+const writablePathSeedDir = "/opt/fissile/seed"
+
+// getWritablePathSeedContainer returns an init container copying the content
+// of the image at the seeded writable paths of a role with a read-only root
+// filesystem into their volumes, or nil if there are none
+func getWritablePathSeedContainer(role *model.InstanceGroup, settings ExportSettings, grapher util.ModelGrapher) (helm.Node, error) {
+	if !role.Run.ReadOnlyRootFilesystem {
+		return nil, nil
+	}
+
+	script := []string{"set -o errexit"}
+	mounts := helm.NewList()
+	for index, writablePath := range role.Run.WritablePaths {
+		if !writablePath.Seed {
+			continue
+		}
+		name := writablePathVolumeName(role, index)
+		seedPath := path.Join(writablePathSeedDir, name)
+		script = append(script, fmt.Sprintf("cp -a %s/. %s/", writablePath.Path, seedPath))
+		mounts.Add(helm.NewMapping("mountPath", seedPath, "name", name))
+	}
+	if len(mounts.Values()) == 0 {
+		return nil, nil
+	}
+
+	image, err := getContainerImageName(role, settings, grapher)
+	if err != nil {
+		return nil, err
+	}
+	return helm.NewMapping(
+		"name", role.Name+"-seed",
+		"image", image,
+		"command", []string{"/bin/sh", "-c", strings.Join(script, "\n")},
+		"volumeMounts", mounts), nil
+}
+
+// serviceAccountTokenVolumeName returns the name of the volume projecting a
+// service account token of a role
+func serviceAccountTokenVolumeName(role *model.InstanceGroup, index int) string {
+	return fmt.Sprintf("%s-token-%d", role.Name, index)
+}
+
 const userSecretsName = "secrets"
 const versionSuffix = "{{ .Chart.Version }}-{{ .Values.kube.secrets_generation_counter }}"
-const generatedSecretsName = "secrets-" + versionSuffix
 
-func makeSecretVar(name string, generated bool, modifiers ...helm.NodeModifier) helm.Node {
+// generatedSecretsName is the name of the secret holding the generated
+// secrets of the current chart version and generation counter
+var generatedSecretsName = resourceNameExpression(`(printf "secrets-%s-%v" $.Chart.Version $.Values.kube.secrets_generation_counter)`)
+
+func makeSecretVar(name string, generated bool, settings ExportSettings, modifiers ...helm.NodeModifier) helm.Node {
 	secretKeyRef := helm.NewMapping("key", util.ConvertNameToKey(name))
 	if generated {
 		secretKeyRef.Add("name", generatedSecretsName)
 	} else {
-		secretKeyRef.Add("name", userSecretsName)
+		secretKeyRef.Add("name", resourceName(userSecretsName, settings))
 	}
 
 	envVar := helm.NewMapping("name", name, "valueFrom", helm.NewMapping("secretKeyRef", secretKeyRef))
@@ -344,12 +545,66 @@ func getNonClaimVolumes(role *model.InstanceGroup, settings ExportSettings) helm
 		}
 	}
 
-	// Mount the deployment manifest secret if it is available
-	mount := helm.NewMapping("name", "deployment-manifest")
-	items := helm.NewList(helm.NewMapping("key", "deployment-manifest", "path", "deployment-manifest.yml"))
-	secret := helm.NewMapping("secretName", "deployment-manifest", "items", items)
-	mount.Add("secret", secret)
-	mounts = append(mounts, mount)
+	for _, socket := range role.PodSharedSockets() {
+		mounts = append(mounts, helm.NewMapping("name", socket.Tag, "emptyDir", helm.NewMapping()))
+	}
+
+	// The writable paths of read-only containers are private to each container
+	for _, candidate := range append([]*model.InstanceGroup{role}, role.GetColocatedRoles()...) {
+		if !candidate.Run.ReadOnlyRootFilesystem {
+			continue
+		}
+		for index, writablePath := range candidate.Run.WritablePaths {
+			emptyDir := helm.NewMapping()
+			if writablePath.Tmpfs {
+				emptyDir.Add("medium", "Memory")
+			}
+			mounts = append(mounts, helm.NewMapping("name", writablePathVolumeName(candidate, index), "emptyDir", emptyDir))
+		}
+	}
+
+	// The pod metadata is shared by all containers mounting it
+	for _, candidate := range append([]*model.InstanceGroup{role}, role.GetColocatedRoles()...) {
+		if candidate.Run.DownwardAPIPath == "" {
+			continue
+		}
+		items := helm.NewList()
+		for _, field := range []string{"namespace", "name", "labels", "annotations"} {
+			items.Add(helm.NewMapping("path", field, "fieldRef", helm.NewMapping("fieldPath", "metadata."+field)))
+		}
+		mounts = append(mounts, helm.NewMapping("name", downwardAPIVolumeName, "downwardAPI", helm.NewMapping("items", items)))
+		break
+	}
+
+	// The service account tokens are requested for each container, as the
+	// audiences may differ
+	for _, candidate := range append([]*model.InstanceGroup{role}, role.GetColocatedRoles()...) {
+		for index, token := range candidate.Run.ServiceAccountTokens {
+			source := helm.NewMapping("audience", token.Audience)
+			if token.ExpirationSeconds != 0 {
+				source.Add("expirationSeconds", token.ExpirationSeconds)
+			}
+			source.Add("path", "token")
+			projected := helm.NewMapping("sources", helm.NewList(helm.NewMapping("serviceAccountToken", source)))
+			mounts = append(mounts, helm.NewMapping("name", serviceAccountTokenVolumeName(candidate, index), "projected", projected))
+		}
+	}
+
+	// Mount the deployment manifest secret if it is available, unless no
+	// container of the pod reads it
+	needsDeploymentManifest := false
+	for _, candidate := range append([]*model.InstanceGroup{role}, role.GetColocatedRoles()...) {
+		if !candidate.HasTag(model.RoleTagNoDeploymentManifest) {
+			needsDeploymentManifest = true
+		}
+	}
+	if needsDeploymentManifest {
+		mount := helm.NewMapping("name", "deployment-manifest")
+		items := helm.NewList(helm.NewMapping("key", "deployment-manifest", "path", "deployment-manifest.yml"))
+		secret := helm.NewMapping("secretName", resourceName(deploymentManifestName, settings), "items", items)
+		mount.Add("secret", secret)
+		mounts = append(mounts, mount)
+	}
 
 	return helm.NewNode(mounts)
 }
@@ -371,7 +626,7 @@ func getEnvVars(role *model.InstanceGroup, settings ExportSettings) (helm.Node,
 	configginUsedBy := role.Manifest().Configuration.Authorization.RoleUsedBy["configgin"]
 	if _, ok := configginUsedBy[role.Run.ServiceAccount]; !ok {
 		envVar := helm.NewMapping("name", "CONFIGGIN_SA_TOKEN")
-		secretKeyRef := helm.NewMapping("name", "configgin", "key", "token")
+		secretKeyRef := helm.NewMapping("name", resourceName("configgin", settings), "key", "token")
 		envVar.Add("valueFrom", helm.NewMapping("secretKeyRef", secretKeyRef))
 		env = append(env, envVar)
 	}
@@ -392,6 +647,8 @@ func getEnvVars(role *model.InstanceGroup, settings ExportSettings) (helm.Node,
 				// Create a link to each statefulset we want to import properties from.
 				// This makes sure our pods don't start until the secret is available.
 				// The environment variables are not actually used for anything else.
+				// configgin exports the properties into a secret named after the
+				// component label of the pods, which is never prefixed.
 				name := "CONFIGGIN_IMPORT_" + strings.ToUpper(makeVarName(roleName))
 				envVar := helm.NewMapping("name", name)
 				secretKeyRef := helm.NewMapping("name", roleName, "key", versionSuffix)
@@ -533,24 +790,39 @@ func getEnvVarsFromConfigs(configs model.Variables, settings ExportSettings) ([]
 			continue
 		}
 
+		if config.CVOptions.Secret && isExternalSecret(config, settings.RoleManifest) {
+			env = append(env, makeExternalSecretVar(config.Name, settings))
+			continue
+		}
+
 		if config.CVOptions.Secret {
+			var secretVars []helm.Node
 			if !settings.CreateHelmChart {
-				env = append(env, makeSecretVar(config.Name, false))
+				secretVars = append(secretVars, makeSecretVar(config.Name, false, settings))
 			} else {
 				if config.CVOptions.Immutable && config.Type != "" {
 					// Users cannot override immutable secrets that are generated
-					env = append(env, makeSecretVar(config.Name, true))
+					secretVars = append(secretVars, makeSecretVar(config.Name, true, settings))
 				} else if config.Type == "" && independentSecret(config.Name) {
-					env = append(env, makeSecretVar(config.Name, false))
+					secretVars = append(secretVars, makeSecretVar(config.Name, false, settings))
 				} else {
 					// Generated secrets can be overridden by the user (unless immutable)
 					block := helm.Block(fmt.Sprintf("if not .Values.secrets.%s", config.Name))
-					env = append(env, makeSecretVar(config.Name, true, block))
+					secretVars = append(secretVars, makeSecretVar(config.Name, true, settings, block))
 
 					block = helm.Block(fmt.Sprintf("if .Values.secrets.%s", config.Name))
-					env = append(env, makeSecretVar(config.Name, false, block))
+					secretVars = append(secretVars, makeSecretVar(config.Name, false, settings, block))
 				}
 			}
+			if SelectSecretBackend(SecretBackendKubernetes, settings, secretVars...) {
+				env = append(env, secretVars...)
+			}
+			// The secrets synced from Vault are all in the user secret; with
+			// the Vault Agent, the role images source them from a file instead
+			synced := makeSecretVar(config.Name, false, settings)
+			if SelectSecretBackend(SecretBackendVaultCSI, settings, synced) {
+				env = append(env, synced)
+			}
 			continue
 		}
 
@@ -560,6 +832,13 @@ func getEnvVarsFromConfigs(configs model.Variables, settings ExportSettings) ([]
 			if config.CVOptions.Required {
 				required = fmt.Sprintf(`{{fail "env.%s has not been set"}}`, config.Name)
 			}
+			if config.CVOptions.URLTemplate != "" {
+				var err error
+				required, err = urlTemplateValue(config.CVOptions.URLTemplate)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid url template of %s: %v", config.Name, err)
+				}
+			}
 			name := ".Values.env." + config.Name
 			if config.CVOptions.ImageName {
 				// Imagenames including a slash already include at least an org name.
@@ -581,6 +860,10 @@ func getEnvVarsFromConfigs(configs model.Variables, settings ExportSettings) ([]
 			if !ok && config.CVOptions.Type == model.CVTypeEnv {
 				continue
 			}
+			if !ok && config.CVOptions.URLTemplate != "" {
+				// The bare service name resolves within the namespace
+				stringifiedValue = config.CVOptions.URLTemplate
+			}
 		}
 		env = append(env, helm.NewMapping("name", config.Name, "value", stringifiedValue))
 	}
@@ -615,24 +898,100 @@ func getEnvVarsFromConfigs(configs model.Variables, settings ExportSettings) ([]
 	return env, nil
 }
 
-func getSecurityContext(instanceGroup *model.InstanceGroup) helm.Node {
+// urlTemplateValue returns the template rendering the default of a variable
+// with a url template, qualifying the service name with the namespace and the
+// cluster domain
+func urlTemplateValue(template string) (string, error) {
+	prefix, service, suffix, err := model.ParseURLTemplate(template)
+	if err != nil {
+		return "", err
+	}
+	format := strings.Replace(prefix, "%", "%%", -1) + "%s.%s.svc.%s" + strings.Replace(suffix, "%", "%%", -1)
+	return fmt.Sprintf(`{{ printf %q (include "fissile.Name" (list $ %q)) .Release.Namespace %s | quote }}`,
+		format, service, clusterDomainValue), nil
+}
+
+func getSecurityContext(instanceGroup *model.InstanceGroup, settings ExportSettings) helm.Node {
 	sc := helm.NewMapping()
-	if len(instanceGroup.Run.Capabilities) > 0 {
-		sc.Add("capabilities", helm.NewMapping("add", helm.NewNode(instanceGroup.Run.Capabilities)))
+	if capabilities := getCapabilities(instanceGroup, settings); capabilities != nil {
+		sc.Add("capabilities", capabilities)
 	}
 	if instanceGroup.Run.Privileged {
 		sc.Add("privileged", instanceGroup.Run.Privileged)
 	}
-	allowPrivilegeEscalation := instanceGroup.Run.Privileged
+	sc.Add("allowPrivilegeEscalation", allowPrivilegeEscalation(instanceGroup))
+	if instanceGroup.Run.ReadOnlyRootFilesystem {
+		sc.Add("readOnlyRootFilesystem", true)
+	}
+
+	return sc.Sort()
+}
+
+// allowPrivilegeEscalation returns true if the container of the instance
+// group is privileged, or has capabilities that allow escalation anyway
+func allowPrivilegeEscalation(instanceGroup *model.InstanceGroup) bool {
+	if instanceGroup.Run.Privileged {
+		return true
+	}
 	for _, cap := range instanceGroup.Run.Capabilities {
 		if cap == "ALL" || cap == "SYS_ADMIN" {
-			allowPrivilegeEscalation = true
-			break
+			return true
 		}
 	}
-	sc.Add("allowPrivilegeEscalation", allowPrivilegeEscalation)
+	return false
+}
 
-	return sc.Sort()
+// getCapabilities returns the capabilities added to and dropped from the
+// container, or nil if there are none. For helm charts, the dropped ones can
+// be overridden by .Values.sizing.<instance group>.drop_capabilities, and
+// default to ALL if .Values.config.drop_all_capabilities is set.
+func getCapabilities(instanceGroup *model.InstanceGroup, settings ExportSettings) helm.Node {
+	capabilities := helm.NewMapping()
+	if len(instanceGroup.Run.Capabilities) > 0 {
+		capabilities.Add("add", helm.NewNode(instanceGroup.Run.Capabilities))
+	}
+
+	drop := instanceGroup.Run.DropCapabilities
+	if !settings.CreateHelmChart {
+		if len(drop) > 0 {
+			capabilities.Add("drop", helm.NewNode(drop))
+		}
+		if len(capabilities.Names()) == 0 {
+			return nil
+		}
+		return capabilities
+	}
+
+	config := fmt.Sprintf(".Values.sizing.%s.drop_capabilities", makeVarName(instanceGroup.Name))
+	if len(drop) > 0 {
+		defaultDrop, _ := json.Marshal(drop)
+		capabilities.Add("drop", fmt.Sprintf(`{{ if %[1]s }}{{ toJson %[1]s }}{{ else }}%[2]s{{ end }}`, config, defaultDrop))
+		return capabilities
+	}
+
+	block := helm.Block(fmt.Sprintf("if or %s .Values.config.drop_all_capabilities", config))
+	capabilities.Add("drop", fmt.Sprintf(`{{ if %[1]s }}{{ toJson %[1]s }}{{ else }}["ALL"]{{ end }}`, config))
+	if len(capabilities.Names()) == 1 {
+		// Only the conditional drop list
+		capabilities.Set(block)
+	} else {
+		capabilities.Get("drop").Set(block)
+	}
+	return capabilities
+}
+
+// getPodSecurityContext returns the security context of the pod of the
+// instance group, or nil if it needs none. Sysctls are per pod, and include
+// those of the colocated containers.
+func getPodSecurityContext(instanceGroup *model.InstanceGroup) helm.Node {
+	sysctls := helm.NewList()
+	for _, sysctl := range instanceGroup.PodSysctls() {
+		sysctls.Add(helm.NewMapping("name", sysctl.Name, "value", sysctl.Value))
+	}
+	if len(sysctls.Values()) == 0 {
+		return nil
+	}
+	return helm.NewMapping("sysctls", sysctls)
 }
 
 func getContainerLivenessProbe(role *model.InstanceGroup) (helm.Node, error) {
@@ -641,7 +1000,7 @@ func getContainerLivenessProbe(role *model.InstanceGroup) (helm.Node, error) {
 	}
 
 	if role.Run.HealthCheck != nil && role.Run.HealthCheck.Liveness != nil {
-		probe, complete, err := configureContainerProbe(role, "liveness", role.Run.HealthCheck.Liveness)
+		probe, complete, err := configureContainerProbe(role, "liveness", role.Run.HealthCheck.Liveness.WithProfile(role.ProbeProfile()))
 
 		if probe.Get("initialDelaySeconds").String() == "0" {
 			probe.Add("initialDelaySeconds", defaultInitialDelaySeconds)
@@ -670,8 +1029,9 @@ func getContainerReadinessProbe(role *model.InstanceGroup) (helm.Node, error) {
 				"FISSILE_ACTIVE_PASSIVE_PROBE="+role.Run.ActivePassiveProbe)
 		}
 		probeCommand.Add("/opt/fissile/readiness-probe.sh")
-		if role.Run.HealthCheck != nil && role.Run.HealthCheck.Readiness != nil {
-			roleProbe := role.Run.HealthCheck.Readiness
+		if role.Run.HealthCheck != nil {
+			// Without a readiness probe, only the timings of the profile apply
+			roleProbe := role.Run.HealthCheck.Readiness.WithProfile(role.ProbeProfile())
 			for _, command := range roleProbe.Command {
 				probeCommand.Add(command)
 			}
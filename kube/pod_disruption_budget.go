@@ -0,0 +1,63 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// HasPodDisruptionBudget returns whether a PodDisruptionBudget is generated
+// for the instance group, i.e. whether it runs several replicas in HA mode.
+func HasPodDisruptionBudget(instanceGroup *model.InstanceGroup) bool {
+	return instanceGroup.Type == model.RoleTypeBosh && instanceGroup.Run.Scaling.HA > 1
+}
+
+// NewPodDisruptionBudget returns a PodDisruptionBudget limiting the voluntary
+// disruptions (e.g. node drains during cluster upgrades) of the pods of an HA
+// instance group, so that they are not all evicted at once. Helm charts take
+// the minAvailable or maxUnavailable pods from the
+// .Values.sizing.<instance group>.disruption_budget, and don't create it if
+// neither is set; otherwise, at most one pod may be unavailable.
+func NewPodDisruptionBudget(instanceGroup *model.InstanceGroup, settings ExportSettings) (helm.Node, error) {
+	if !HasPodDisruptionBudget(instanceGroup) {
+		return nil, nil
+	}
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetConditionalAPIVersion("policy/v1", "policy/v1beta1").
+		SetKind("PodDisruptionBudget").
+		SetName(instanceGroup.Name).
+		AddModifier(helm.Comment(fmt.Sprintf("Voluntary disruptions of the pods of the %s instance group", instanceGroup.Name)))
+
+	spec := helm.NewMapping()
+	if settings.CreateHelmChart {
+		budget := fmt.Sprintf(".Values.sizing.%s.disruption_budget", makeVarName(instanceGroup.Name))
+		minAvailable := budget + ".min_available"
+		maxUnavailable := budget + ".max_unavailable"
+
+		conditions := []string{fmt.Sprintf("(or %s %s)", notNil(minAvailable), notNil(maxUnavailable))}
+		if block := featureCheckBlock(instanceGroup); block != "" {
+			conditions = append(conditions, fmt.Sprintf("(%s)", strings.TrimPrefix(block, "if ")))
+		}
+		cb.AddModifier(helm.Block(fmt.Sprintf("if and %s", strings.Join(conditions, " "))))
+
+		// Kubernetes only allows one of them; the minimum takes precedence
+		spec.Add("minAvailable", fmt.Sprintf("{{ %s }}", minAvailable), helm.Block("if "+notNil(minAvailable)))
+		spec.Add("maxUnavailable", fmt.Sprintf("{{ %s }}", maxUnavailable),
+			helm.Block(fmt.Sprintf(`if and (eq (typeOf %s) "<nil>") %s`, minAvailable, notNil(maxUnavailable))))
+	} else {
+		spec.Add("maxUnavailable", 1)
+	}
+	spec.Add("selector", newSelector(instanceGroup, settings))
+
+	pdb, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	pdb.Add("spec", spec)
+
+	return pdb, nil
+}
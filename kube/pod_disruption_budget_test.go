@@ -0,0 +1,89 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPodDisruptionBudget(t *testing.T) {
+	t.Parallel()
+
+	instanceGroup := &model.InstanceGroup{
+		Name: "main-role",
+		Type: model.RoleTypeBosh,
+		Run:  &model.RoleRun{Scaling: &model.RoleRunScaling{Min: 1, Max: 3, HA: 2}},
+	}
+	manifest := &model.RoleManifest{InstanceGroups: model.InstanceGroups{instanceGroup}}
+
+	t.Run("NotHA", func(t *testing.T) {
+		t.Parallel()
+		single := &model.InstanceGroup{
+			Name: "single",
+			Type: model.RoleTypeBosh,
+			Run:  &model.RoleRun{Scaling: &model.RoleRunScaling{Min: 1, Max: 1}},
+		}
+		pdb, err := NewPodDisruptionBudget(single, ExportSettings{RoleManifest: manifest})
+		require.NoError(t, err)
+		assert.Nil(t, pdb)
+	})
+
+	t.Run("Kube", func(t *testing.T) {
+		t.Parallel()
+		pdb, err := NewPodDisruptionBudget(instanceGroup, ExportSettings{RoleManifest: manifest})
+		require.NoError(t, err)
+		actual, err := RoundtripKube(pdb)
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			apiVersion: policy/v1
+			kind: PodDisruptionBudget
+			metadata:
+				name: main-role
+				labels:
+					app.kubernetes.io/component: main-role
+			spec:
+				maxUnavailable: 1
+				selector:
+					matchLabels:
+						skiff-role-name: main-role
+		`, actual)
+	})
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		pdb, err := NewPodDisruptionBudget(instanceGroup, ExportSettings{RoleManifest: manifest, CreateHelmChart: true})
+		require.NoError(t, err)
+
+		actual, err := RoundtripNode(pdb, map[string]interface{}{
+			"Values.sizing.main_role.disruption_budget.max_unavailable": 1,
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			kind: PodDisruptionBudget
+			spec:
+				maxUnavailable: 1
+		`, actual)
+		assert.NotContains(t, actual.(map[interface{}]interface{})["spec"], "minAvailable")
+
+		actual, err = RoundtripNode(pdb, map[string]interface{}{
+			"Values.sizing.main_role.disruption_budget.min_available":   "50%",
+			"Values.sizing.main_role.disruption_budget.max_unavailable": 1,
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			spec:
+				minAvailable: 50%
+		`, actual)
+		assert.NotContains(t, actual.(map[interface{}]interface{})["spec"], "maxUnavailable",
+			"Only one of minAvailable and maxUnavailable may be set")
+
+		actual, err = RoundtripNode(pdb, map[string]interface{}{
+			"Values.sizing.main_role.disruption_budget.max_unavailable": nil,
+		})
+		require.NoError(t, err)
+		assert.Nil(t, actual, "The budget should not be created without limits")
+	})
+}
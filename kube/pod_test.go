@@ -3,15 +3,17 @@ package kube
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 
 	"code.cloudfoundry.org/fissile/helm"
 	"code.cloudfoundry.org/fissile/model"
 	"code.cloudfoundry.org/fissile/model/loader"
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	yaml "gopkg.in/yaml.v2"
@@ -94,7 +96,7 @@ func (sample *Sample) check(t *testing.T, helmConfig helm.Node, err error) {
 			assert.Fail(actualYAML.String())
 			return
 		}
-		if !testhelpers.IsYAMLSubset(assert, expected, actual) {
+		if !yamltest.IsYAMLSubset(assert, expected, actual) {
 			assert.Fail("Not a proper YAML subset", "*Actual*\n%s\n*Expected*\n%s\n", actualYAML.String(), expectedYAML)
 		}
 	})
@@ -119,7 +121,7 @@ func TestPodGetNonClaimVolumes(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "host-volume"
 			hostPath:
 				path: "/sys/fs/cgroup"
@@ -245,7 +247,7 @@ func TestPodGetVolumesHelm(t *testing.T) {
 
 	actual, err := RoundtripNode(persistentClaim, config)
 	if assert.NoError(err) {
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 		metadata:
 			name: "persistent-volume"
 			annotations:
@@ -261,7 +263,7 @@ func TestPodGetVolumesHelm(t *testing.T) {
 
 	actual, err = RoundtripNode(sharedClaim, config)
 	if assert.NoError(err) {
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 		metadata:
 			name: "shared-volume"
 			annotations:
@@ -368,7 +370,10 @@ func TestPodGetEnvVarsConfiggin(t *testing.T) {
 		return
 	}
 
-	actual, err := RoundtripNode(helm.NewNode(ev), nil)
+	// configgin exports the properties under the unprefixed name
+	actual, err := RoundtripNode(helm.NewNode(ev), map[string]interface{}{
+		"Values.name_prefix.enabled": true,
+	})
 	if !assert.NoError(err) {
 		return
 	}
@@ -381,7 +386,7 @@ func TestPodGetEnvVarsConfiggin(t *testing.T) {
 			importMyRole = true
 		case "CONFIGGIN_IMPORT_PROVIDER":
 			importProvider = true
-			testhelpers.IsYAMLEqualString(assert, `---
+			yamltest.IsYAMLEqualString(assert, `---
 				name: CONFIGGIN_IMPORT_PROVIDER
 				valueFrom:
 					secretKeyRef:
@@ -424,7 +429,7 @@ func TestPodGetEnvVarsFromConfigSizingCountKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "KUBERNETES_NAMESPACE"
 			valueFrom:
 				fieldRef:
@@ -474,7 +479,7 @@ func TestPodGetEnvVarsFromConfigSizingCountHelm(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "KUBERNETES_NAMESPACE"
 			valueFrom:
 				fieldRef:
@@ -496,7 +501,7 @@ func TestPodGetEnvVarsFromConfigSizingCountHelm(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "KUBERNETES_NAMESPACE"
 			valueFrom:
 				fieldRef:
@@ -518,7 +523,7 @@ func TestPodGetEnvVarsFromConfigSizingCountHelm(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "KUBERNETES_NAMESPACE"
 			valueFrom:
 				fieldRef:
@@ -577,7 +582,7 @@ func TestPodGetEnvVarsFromConfigSizingPortsKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "KUBERNETES_NAMESPACE"
 			valueFrom:
 				fieldRef:
@@ -641,7 +646,7 @@ func TestPodGetEnvVarsFromConfigSizingPortsHelm(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "KUBERNETES_NAMESPACE"
 			valueFrom:
 				fieldRef:
@@ -682,7 +687,7 @@ func TestPodGetEnvVarsFromConfigGenerationCounterKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "KUBERNETES_NAMESPACE"
 			valueFrom:
 				fieldRef:
@@ -726,7 +731,7 @@ func TestPodGetEnvVarsFromConfigGenerationCounterHelm(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "KUBERNETES_NAMESPACE"
 			valueFrom:
 				fieldRef:
@@ -765,7 +770,7 @@ func TestPodGetEnvVarsFromConfigGenerationNameKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "KUBERNETES_NAMESPACE"
 			valueFrom:
 				fieldRef:
@@ -810,7 +815,7 @@ func TestPodGetEnvVarsFromConfigGenerationNameHelm(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "KUBERNETES_NAMESPACE"
 			valueFrom:
 				fieldRef:
@@ -851,7 +856,7 @@ func TestPodGetEnvVarsFromConfigSecretsKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "A_SECRET"
 			valueFrom:
 				secretKeyRef:
@@ -902,7 +907,7 @@ func TestPodGetEnvVarsFromConfigSecretsHelm(t *testing.T) {
 			return
 		}
 
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			-	name: "A_SECRET"
 				valueFrom:
 					secretKeyRef:
@@ -949,7 +954,7 @@ func TestPodGetEnvVarsFromConfigSecretsHelm(t *testing.T) {
 			if !assert.NoError(err) {
 				return
 			}
-			testhelpers.IsYAMLEqualString(assert, `---
+			yamltest.IsYAMLEqualString(assert, `---
 				-	name: "A_SECRET"
 					valueFrom:
 						secretKeyRef:
@@ -976,7 +981,7 @@ func TestPodGetEnvVarsFromConfigSecretsHelm(t *testing.T) {
 			if !assert.NoError(err) {
 				return
 			}
-			testhelpers.IsYAMLEqualString(assert, `---
+			yamltest.IsYAMLEqualString(assert, `---
 				-	name: "A_SECRET"
 					valueFrom:
 						secretKeyRef:
@@ -1005,7 +1010,7 @@ func TestPodGetEnvVarsFromConfigSecretsHelm(t *testing.T) {
 			if !assert.NoError(err) {
 				return
 			}
-			testhelpers.IsYAMLEqualString(assert, `---
+			yamltest.IsYAMLEqualString(assert, `---
 				-	name: "A_SECRET"
 					valueFrom:
 						secretKeyRef:
@@ -1057,7 +1062,7 @@ func TestPodGetEnvVarsFromConfigNonSecretKube(t *testing.T) {
 			return
 		}
 
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			-	name: "KUBERNETES_NAMESPACE"
 				valueFrom:
 					fieldRef:
@@ -1089,7 +1094,7 @@ func TestPodGetEnvVarsFromConfigNonSecretKube(t *testing.T) {
 		if !assert.NoError(err) {
 			return
 		}
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			-	name: "KUBERNETES_NAMESPACE"
 				valueFrom:
 					fieldRef:
@@ -1139,7 +1144,7 @@ func TestPodGetEnvVarsFromConfigNonSecretHelmUserOptional(t *testing.T) {
 			return
 		}
 
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			-	name: "KUBERNETES_NAMESPACE"
 				valueFrom:
 					fieldRef:
@@ -1164,7 +1169,7 @@ func TestPodGetEnvVarsFromConfigNonSecretHelmUserOptional(t *testing.T) {
 			return
 		}
 
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			-	name: "KUBERNETES_NAMESPACE"
 				valueFrom:
 					fieldRef:
@@ -1179,6 +1184,87 @@ func TestPodGetEnvVarsFromConfigNonSecretHelmUserOptional(t *testing.T) {
 	})
 }
 
+func TestPodGetEnvVarsFromConfigURLTemplate(t *testing.T) {
+	t.Parallel()
+
+	variables := model.Variables{
+		&model.VariableDefinition{
+			Name: "UAA_URL",
+			CVOptions: model.CVOptions{
+				Type:        model.CVTypeUser,
+				URLTemplate: "https://uaa-public:2793/%2F",
+			},
+		},
+	}
+	roleManifest := &model.RoleManifest{
+		InstanceGroups: []*model.InstanceGroup{
+			&model.InstanceGroup{
+				Name: "uaa",
+			},
+		},
+	}
+
+	t.Run("Kube", func(t *testing.T) {
+		t.Parallel()
+		ev, err := getEnvVarsFromConfigs(variables, ExportSettings{RoleManifest: roleManifest})
+		require.NoError(t, err)
+		actual, err := RoundtripKube(helm.NewNode(ev))
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			-	name: "UAA_URL"
+				value: "https://uaa-public:2793/%2F"
+		`, actual.([]interface{})[1:2])
+	})
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		ev, err := getEnvVarsFromConfigs(variables, ExportSettings{
+			CreateHelmChart: true,
+			RoleManifest:    roleManifest,
+		})
+		require.NoError(t, err)
+
+		for _, sample := range []struct {
+			name   string
+			config map[string]interface{}
+			value  string
+		}{
+			{
+				name: "Default",
+				config: map[string]interface{}{
+					"Release.Namespace":                    "scf",
+					"Values.env.KUBERNETES_CLUSTER_DOMAIN": nil,
+					"Values.env.UAA_URL":                   nil,
+				},
+				value: "https://uaa-public.scf.svc.cluster.local:2793/%2F",
+			},
+			{
+				name: "Cluster domain",
+				config: map[string]interface{}{
+					"Release.Namespace":                    "scf",
+					"Values.env.KUBERNETES_CLUSTER_DOMAIN": "example.org",
+					"Values.env.UAA_URL":                   nil,
+					"Values.name_prefix.enabled":           true,
+				},
+				value: "https://MyRelease-uaa-public.scf.svc.example.org:2793/%2F",
+			},
+			{
+				name: "Override",
+				config: map[string]interface{}{
+					"Values.env.UAA_URL": "https://uaa.example.org",
+				},
+				value: "https://uaa.example.org",
+			},
+		} {
+			actual, err := RoundtripNode(helm.NewNode(ev), sample.config)
+			if assert.NoError(t, err, sample.name) {
+				assert.Equal(t, map[interface{}]interface{}{"name": "UAA_URL", "value": sample.value},
+					actual.([]interface{})[1], sample.name)
+			}
+		}
+	})
+}
+
 func TestPodGetEnvVarsFromConfigNonSecretHelmUserRequired(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
@@ -1231,7 +1317,7 @@ func TestPodGetEnvVarsFromConfigNonSecretHelmUserRequired(t *testing.T) {
 			return
 		}
 
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			-	name: "KUBERNETES_NAMESPACE"
 				valueFrom:
 					fieldRef:
@@ -1256,7 +1342,7 @@ func TestPodGetEnvVarsFromConfigNonSecretHelmUserRequired(t *testing.T) {
 			return
 		}
 
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			-	name: "KUBERNETES_NAMESPACE"
 				valueFrom:
 					fieldRef:
@@ -1308,7 +1394,7 @@ func TestPodGetEnvVarsFromConfigNonSecretHelmImagename(t *testing.T) {
 			return
 		}
 
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			-	name: "IMAGENAME"
 				value: "docker.io/my-org/my-image:my-tag"
 			-	name: "KUBERNETES_NAMESPACE"
@@ -1334,7 +1420,7 @@ func TestPodGetEnvVarsFromConfigNonSecretHelmImagename(t *testing.T) {
 			return
 		}
 
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			-	name: "IMAGENAME"
 				value: "org/image:tag"
 			-	name: "KUBERNETES_NAMESPACE"
@@ -1589,6 +1675,54 @@ func TestPodGetContainerLivenessProbe(t *testing.T) {
 	}
 }
 
+func TestPodGetContainerProbeProfile(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	role := podTestLoadRoleFrom(assert, "myrole", "probe-profiles.yml")
+	if role == nil {
+		return
+	}
+
+	liveness, err := getContainerLivenessProbe(role)
+	require.NoError(t, err)
+	require.NotNil(t, liveness)
+	actual, err := RoundtripKube(liveness)
+	require.NoError(t, err)
+	yamltest.IsYAMLEqualString(assert, `---
+		exec:
+			command: [ /bin/true ]
+		failureThreshold: 10
+		initialDelaySeconds: 300
+		periodSeconds: 30
+		successThreshold: 0
+		timeoutSeconds: 0
+	`, actual)
+
+	// The timings of the probe take precedence over the profile
+	readiness, err := getContainerReadinessProbe(role)
+	require.NoError(t, err)
+	require.NotNil(t, readiness)
+	actual, err = RoundtripKube(readiness)
+	require.NoError(t, err)
+	yamltest.IsYAMLEqualString(assert, `---
+		exec:
+			command: [ /opt/fissile/readiness-probe.sh, /bin/true ]
+		failureThreshold: 10
+		initialDelaySeconds: 300
+		periodSeconds: 5
+	`, actual)
+
+	// Without a readiness probe, the profile applies to the default one
+	role.Run.HealthCheck.Readiness = nil
+	readiness, err = getContainerReadinessProbe(role)
+	require.NoError(t, err)
+	actual, err = RoundtripKube(readiness)
+	require.NoError(t, err)
+	yamltest.IsYAMLSubsetString(assert, `---
+		periodSeconds: 30
+	`, actual)
+}
+
 func TestPodGetContainerReadinessProbe(t *testing.T) {
 	t.Parallel()
 
@@ -1912,7 +2046,7 @@ func TestPodGetContainerReadinessProbe(t *testing.T) {
 						if assert.NoError(t, err) {
 							// We use subset testing here because we don't want to bother with the
 							// default timeout lengths
-							testhelpers.IsYAMLSubsetString(assert.New(t), sample.boshExpected, actual)
+							yamltest.IsYAMLSubsetString(assert.New(t), sample.boshExpected, actual)
 						}
 					})
 					t.Run("helm", func(t *testing.T) {
@@ -1921,7 +2055,7 @@ func TestPodGetContainerReadinessProbe(t *testing.T) {
 						if assert.NoError(t, err) {
 							// We use subset testing here because we don't want to bother with the
 							// default timeout lengths
-							testhelpers.IsYAMLSubsetString(assert.New(t), sample.boshExpected, actual)
+							yamltest.IsYAMLSubsetString(assert.New(t), sample.boshExpected, actual)
 						}
 					})
 				})
@@ -1959,6 +2093,124 @@ func podTestLoadRole(assert *assert.Assertions, roleName string) *model.Instance
 	return podTestLoadRoleFrom(assert, roleName, "pods.yml")
 }
 
+func TestPodDebugCommand(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Kube", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+		role := podTestLoadRole(assert, "pre-role")
+		if role == nil {
+			return
+		}
+		role.Run.Command = []string{"/bin/bash", "-c"}
+		role.Run.Args = []string{"echo hello"}
+
+		container, err := getContainerMapping(role, ExportSettings{
+			Opinions: model.NewEmptyOpinions(),
+		}, nil)
+		if !assert.NoError(err) {
+			return
+		}
+		actual, err := RoundtripKube(container)
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLSubsetString(assert, `---
+			command: ["/bin/bash", "-c"]
+			args: ["echo hello"]
+		`, actual)
+	})
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+		role := podTestLoadRole(assert, "istio-managed-role")
+		if role == nil {
+			return
+		}
+		role.Run.Command = []string{"/bin/bash", "-c"}
+		role.Run.Args = []string{"echo hello"}
+
+		container, err := getContainerMapping(role, ExportSettings{
+			CreateHelmChart: true,
+			Opinions:        model.NewEmptyOpinions(),
+		}, nil)
+		if !assert.NoError(err) {
+			return
+		}
+
+		t.Run("Default", func(t *testing.T) {
+			t.Parallel()
+			actual, err := RoundtripNode(container, map[string]interface{}{
+				"Values.sizing.istio_managed_role.debug.command": nil,
+			})
+			if !assert.NoError(err) {
+				return
+			}
+			yamltest.IsYAMLSubsetString(assert, `---
+				command: ["/bin/bash", "-c"]
+				args: ["echo hello"]
+			`, actual)
+			assert.NotContains(actual, "stdin")
+			assert.NotContains(actual, "tty")
+			assert.NotNil(actual.(map[interface{}]interface{})["readinessProbe"])
+		})
+
+		t.Run("Override", func(t *testing.T) {
+			t.Parallel()
+			actual, err := RoundtripNode(container, map[string]interface{}{
+				"Values.sizing.istio_managed_role.debug.command": []string{"sleep", "infinity"},
+			})
+			if !assert.NoError(err) {
+				return
+			}
+			yamltest.IsYAMLSubsetString(assert, `---
+				command: ["sleep", "infinity"]
+				stdin: true
+				tty: true
+			`, actual)
+			assert.NotContains(actual, "args")
+			assert.Nil(actual.(map[interface{}]interface{})["readinessProbe"])
+		})
+	})
+
+	t.Run("HelmWithoutCommand", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+		role := podTestLoadRole(assert, "pre-role")
+		if role == nil {
+			return
+		}
+
+		container, err := getContainerMapping(role, ExportSettings{
+			CreateHelmChart: true,
+			Opinions:        model.NewEmptyOpinions(),
+		}, nil)
+		if !assert.NoError(err) {
+			return
+		}
+
+		actual, err := RoundtripNode(container, map[string]interface{}{
+			"Values.sizing.pre_role.debug.command": nil,
+		})
+		if !assert.NoError(err) {
+			return
+		}
+		assert.NotContains(actual, "command")
+
+		actual, err = RoundtripNode(container, map[string]interface{}{
+			"Values.sizing.pre_role.debug.command": []string{"/bin/sh"},
+		})
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLSubsetString(assert, `---
+			command: ["/bin/sh"]
+		`, actual)
+	})
+}
+
 func TestPodPreFlightKube(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
@@ -1978,7 +2230,7 @@ func TestPodPreFlightKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLSubsetString(assert, `---
+	yamltest.IsYAMLSubsetString(assert, `---
 		apiVersion: v1
 		kind: Pod
 		metadata:
@@ -2010,6 +2262,8 @@ func TestPodPreFlightHelm(t *testing.T) {
 	assert.NotNil(pod)
 
 	config := map[string]interface{}{
+		"Values.sizing.pre_role.debug.command": nil,
+		"Values.sizing.pre_role.job":           map[string]interface{}{},
 		"Values.kube.registry.hostname":        "R",
 		"Values.kube.registry.username":        "U",
 		"Values.kube.organization":             "O",
@@ -2020,7 +2274,7 @@ func TestPodPreFlightHelm(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "v1"
 		kind: "Pod"
 		metadata:
@@ -2028,7 +2282,7 @@ func TestPodPreFlightHelm(t *testing.T) {
 			labels:
 				app.kubernetes.io/component: pre-role
 				app.kubernetes.io/instance: MyRelease
-				app.kubernetes.io/managed-by: Tiller
+				app.kubernetes.io/managed-by: fissile
 				app.kubernetes.io/name: MyChart
 				app.kubernetes.io/version: 1.22.333.4444
 				helm.sh/chart: MyChart-42.1_foo
@@ -2048,6 +2302,8 @@ func TestPodPreFlightHelm(t *testing.T) {
 					value: "2048"
 				-	name: "VCAP_SOFT_NPROC"
 					value: "1024"
+				-	name: "TERMINATION_GRACE_PERIOD_SECONDS"
+					value: "600"
 				image: "R/O/theRepo-pre-role:b0668a0daba46290566d99ee97d7b45911a53293"
 				lifecycle:
 					preStop:
@@ -2100,7 +2356,7 @@ func TestPodPostFlightKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLSubsetString(assert, `---
+	yamltest.IsYAMLSubsetString(assert, `---
 		apiVersion: v1
 		kind: Pod
 		metadata:
@@ -2132,17 +2388,19 @@ func TestPodPostFlightHelm(t *testing.T) {
 	assert.NotNil(pod)
 
 	config := map[string]interface{}{
-		"Values.kube.registry.hostname":        "R",
-		"Values.kube.registry.username":        "U",
-		"Values.kube.organization":             "O",
-		"Values.env.KUBERNETES_CLUSTER_DOMAIN": "cluster.local",
+		"Values.sizing.post_role.debug.command": nil,
+		"Values.sizing.post_role.job":           map[string]interface{}{},
+		"Values.kube.registry.hostname":         "R",
+		"Values.kube.registry.username":         "U",
+		"Values.kube.organization":              "O",
+		"Values.env.KUBERNETES_CLUSTER_DOMAIN":  "cluster.local",
 	}
 
 	actual, err := RoundtripNode(pod, config)
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "v1"
 		kind: "Pod"
 		metadata:
@@ -2150,7 +2408,7 @@ func TestPodPostFlightHelm(t *testing.T) {
 			labels:
 				app.kubernetes.io/component: post-role
 				app.kubernetes.io/instance: MyRelease
-				app.kubernetes.io/managed-by: Tiller
+				app.kubernetes.io/managed-by: fissile
 				app.kubernetes.io/name: MyChart
 				app.kubernetes.io/version: 1.22.333.4444
 				helm.sh/chart: MyChart-42.1_foo
@@ -2170,6 +2428,8 @@ func TestPodPostFlightHelm(t *testing.T) {
 					value: "2048"
 				-	name: "VCAP_SOFT_NPROC"
 					value: "1024"
+				-	name: "TERMINATION_GRACE_PERIOD_SECONDS"
+					value: "600"
 				image: "R/O/theRepo-post-role:e9f459d3c3576bf1129a6b18ca2763f73fa19645"
 				lifecycle:
 					preStop:
@@ -2224,7 +2484,7 @@ func TestPodMemoryKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLSubsetString(assert, `---
+	yamltest.IsYAMLSubsetString(assert, `---
 		apiVersion: v1
 		kind: Pod
 		metadata:
@@ -2262,6 +2522,8 @@ func TestPodMemoryHelmDisabled(t *testing.T) {
 	assert.NotNil(pod)
 
 	config := map[string]interface{}{
+		"Values.sizing.pre_role.debug.command":  nil,
+		"Values.sizing.pre_role.job":            map[string]interface{}{},
 		"Values.config.memory.requests":         nil,
 		"Values.kube.registry.hostname":         "R",
 		"Values.kube.registry.username":         "U",
@@ -2274,7 +2536,7 @@ func TestPodMemoryHelmDisabled(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "v1"
 		kind: "Pod"
 		metadata:
@@ -2282,7 +2544,7 @@ func TestPodMemoryHelmDisabled(t *testing.T) {
 			labels:
 				app.kubernetes.io/component: pre-role
 				app.kubernetes.io/instance: MyRelease
-				app.kubernetes.io/managed-by: Tiller
+				app.kubernetes.io/managed-by: fissile
 				app.kubernetes.io/name: MyChart
 				app.kubernetes.io/version: 1.22.333.4444
 				helm.sh/chart: MyChart-42.1_foo
@@ -2302,6 +2564,8 @@ func TestPodMemoryHelmDisabled(t *testing.T) {
 					value: "2048"
 				-	name: "VCAP_SOFT_NPROC"
 					value: "1024"
+				-	name: "TERMINATION_GRACE_PERIOD_SECONDS"
+					value: "600"
 				image: "R/O/theRepo-pre-role:b0668a0daba46290566d99ee97d7b45911a53293"
 				lifecycle:
 					preStop:
@@ -2355,6 +2619,8 @@ func TestPodMemoryHelmActive(t *testing.T) {
 	assert.NotNil(pod)
 
 	config := map[string]interface{}{
+		"Values.sizing.pre_role.debug.command":  nil,
+		"Values.sizing.pre_role.job":            map[string]interface{}{},
 		"Values.config.memory.limits":           "true",
 		"Values.config.memory.requests":         "true",
 		"Values.env.KUBERNETES_CLUSTER_DOMAIN":  "cluster.local",
@@ -2369,7 +2635,7 @@ func TestPodMemoryHelmActive(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "v1"
 		kind: "Pod"
 		metadata:
@@ -2377,7 +2643,7 @@ func TestPodMemoryHelmActive(t *testing.T) {
 			labels:
 				app.kubernetes.io/component: pre-role
 				app.kubernetes.io/instance: MyRelease
-				app.kubernetes.io/managed-by: Tiller
+				app.kubernetes.io/managed-by: fissile
 				app.kubernetes.io/name: MyChart
 				app.kubernetes.io/version: 1.22.333.4444
 				helm.sh/chart: MyChart-42.1_foo
@@ -2397,6 +2663,8 @@ func TestPodMemoryHelmActive(t *testing.T) {
 					value: "2048"
 				-	name: "VCAP_SOFT_NPROC"
 					value: "1024"
+				-	name: "TERMINATION_GRACE_PERIOD_SECONDS"
+					value: "600"
 				image: "R/O/theRepo-pre-role:b0668a0daba46290566d99ee97d7b45911a53293"
 				lifecycle:
 					preStop:
@@ -2453,7 +2721,7 @@ func TestPodCPUKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLSubsetString(assert, `---
+	yamltest.IsYAMLSubsetString(assert, `---
 		apiVersion: v1
 		kind: Pod
 		metadata:
@@ -2491,6 +2759,8 @@ func TestPodCPUHelmDisabled(t *testing.T) {
 	assert.NotNil(pod)
 
 	config := map[string]interface{}{
+		"Values.sizing.pre_role.debug.command": nil,
+		"Values.sizing.pre_role.job":           map[string]interface{}{},
 		"Values.config.cpu.requests":           nil,
 		"Values.env.KUBERNETES_CLUSTER_DOMAIN": "cluster.local",
 		"Values.kube.organization":             "O",
@@ -2503,7 +2773,7 @@ func TestPodCPUHelmDisabled(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "v1"
 		kind: "Pod"
 		metadata:
@@ -2511,7 +2781,7 @@ func TestPodCPUHelmDisabled(t *testing.T) {
 			labels:
 				app.kubernetes.io/component: pre-role
 				app.kubernetes.io/instance: MyRelease
-				app.kubernetes.io/managed-by: Tiller
+				app.kubernetes.io/managed-by: fissile
 				app.kubernetes.io/name: MyChart
 				app.kubernetes.io/version: 1.22.333.4444
 				helm.sh/chart: MyChart-42.1_foo
@@ -2531,6 +2801,8 @@ func TestPodCPUHelmDisabled(t *testing.T) {
 					value: "2048"
 				-	name: "VCAP_SOFT_NPROC"
 					value: "1024"
+				-	name: "TERMINATION_GRACE_PERIOD_SECONDS"
+					value: "600"
 				image: "R/O/theRepo-pre-role:b0668a0daba46290566d99ee97d7b45911a53293"
 				lifecycle:
 					preStop:
@@ -2584,6 +2856,8 @@ func TestPodCPUHelmActive(t *testing.T) {
 	assert.NotNil(pod)
 
 	config := map[string]interface{}{
+		"Values.sizing.pre_role.debug.command": nil,
+		"Values.sizing.pre_role.job":           map[string]interface{}{},
 		"Values.config.cpu.limits":             "true",
 		"Values.config.cpu.requests":           "true",
 		"Values.env.KUBERNETES_CLUSTER_DOMAIN": "cluster.local",
@@ -2598,7 +2872,7 @@ func TestPodCPUHelmActive(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "v1"
 		kind: "Pod"
 		metadata:
@@ -2606,7 +2880,7 @@ func TestPodCPUHelmActive(t *testing.T) {
 			labels:
 				app.kubernetes.io/component: pre-role
 				app.kubernetes.io/instance: MyRelease
-				app.kubernetes.io/managed-by: Tiller
+				app.kubernetes.io/managed-by: fissile
 				app.kubernetes.io/name: MyChart
 				app.kubernetes.io/version: 1.22.333.4444
 				helm.sh/chart: MyChart-42.1_foo
@@ -2626,6 +2900,8 @@ func TestPodCPUHelmActive(t *testing.T) {
 					value: "2048"
 				-	name: "VCAP_SOFT_NPROC"
 					value: "1024"
+				-	name: "TERMINATION_GRACE_PERIOD_SECONDS"
+					value: "600"
 				image: "R/O/theRepo-pre-role:b0668a0daba46290566d99ee97d7b45911a53293"
 				lifecycle:
 					preStop:
@@ -2671,7 +2947,7 @@ func TestGetSecurityContextCapList(t *testing.T) {
 		return
 	}
 
-	sc := getSecurityContext(role)
+	sc := getSecurityContext(role, ExportSettings{})
 	if !assert.NotNil(sc) {
 		return
 	}
@@ -2680,7 +2956,7 @@ func TestGetSecurityContextCapList(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		allowPrivilegeEscalation: false
 		capabilities:
 			add:
@@ -2701,7 +2977,7 @@ func TestGetSecurityContextNil(t *testing.T) {
 
 	role.Run.Capabilities = []string{}
 
-	sc := getSecurityContext(role)
+	sc := getSecurityContext(role, ExportSettings{})
 	if !assert.NotNil(sc) {
 		return
 	}
@@ -2710,7 +2986,7 @@ func TestGetSecurityContextNil(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		allowPrivilegeEscalation: false
 	`, actual)
 
@@ -2733,7 +3009,7 @@ func TestGetSecurityContextPrivileged(t *testing.T) {
 	role.Run.Capabilities[0] = "ALL"
 	role.Run.Privileged = false
 
-	sc := getSecurityContext(role)
+	sc := getSecurityContext(role, ExportSettings{})
 	if !assert.NotNil(sc) {
 		return
 	}
@@ -2742,7 +3018,7 @@ func TestGetSecurityContextPrivileged(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		allowPrivilegeEscalation: true
 		capabilities:
 			add:
@@ -2750,6 +3026,526 @@ func TestGetSecurityContextPrivileged(t *testing.T) {
 	`, actual)
 }
 
+func TestGetSecurityContextDropCapabilities(t *testing.T) {
+	t.Parallel()
+
+	role := podTemplateTestLoadRole(assert.New(t))
+	if role == nil {
+		return
+	}
+
+	// The sizing values always have an entry for each instance group
+	noOverride := map[string]interface{}{"Values.sizing.myrole.drop_capabilities": nil}
+
+	t.Run("Kube", func(t *testing.T) {
+		t.Parallel()
+		run := *role.Run
+		run.DropCapabilities = []string{"NET_RAW"}
+		instanceGroup := *role
+		instanceGroup.Run = &run
+
+		actual, err := RoundtripKube(getSecurityContext(&instanceGroup, ExportSettings{}))
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			allowPrivilegeEscalation: false
+			capabilities:
+				add:
+				-	"SOMETHING"
+				drop:
+				-	"NET_RAW"
+		`, actual)
+	})
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		run := *role.Run
+		run.DropCapabilities = []string{"NET_RAW"}
+		instanceGroup := *role
+		instanceGroup.Run = &run
+		sc := getSecurityContext(&instanceGroup, ExportSettings{CreateHelmChart: true})
+
+		actual, err := RoundtripNode(sc, noOverride)
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			allowPrivilegeEscalation: false
+			capabilities:
+				add:
+				-	"SOMETHING"
+				drop:
+				-	"NET_RAW"
+		`, actual)
+
+		actual, err = RoundtripNode(sc, map[string]interface{}{
+			"Values.sizing.myrole.drop_capabilities": []interface{}{"ALL"},
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			allowPrivilegeEscalation: false
+			capabilities:
+				add:
+				-	"SOMETHING"
+				drop:
+				-	"ALL"
+		`, actual)
+	})
+
+	t.Run("HelmHardened", func(t *testing.T) {
+		t.Parallel()
+		sc := getSecurityContext(role, ExportSettings{CreateHelmChart: true})
+
+		actual, err := RoundtripNode(sc, noOverride)
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			allowPrivilegeEscalation: false
+			capabilities:
+				add:
+				-	"SOMETHING"
+		`, actual)
+
+		actual, err = RoundtripNode(sc, map[string]interface{}{
+			"Values.config.drop_all_capabilities":    true,
+			"Values.sizing.myrole.drop_capabilities": nil,
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			allowPrivilegeEscalation: false
+			capabilities:
+				add:
+				-	"SOMETHING"
+				drop:
+				-	"ALL"
+		`, actual)
+
+		run := *role.Run
+		run.Capabilities = nil
+		instanceGroup := *role
+		instanceGroup.Run = &run
+		sc = getSecurityContext(&instanceGroup, ExportSettings{CreateHelmChart: true})
+
+		actual, err = RoundtripNode(sc, noOverride)
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			allowPrivilegeEscalation: false
+		`, actual)
+
+		actual, err = RoundtripNode(sc, map[string]interface{}{
+			"Values.sizing.myrole.drop_capabilities": []interface{}{"NET_RAW", "MKNOD"},
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			allowPrivilegeEscalation: false
+			capabilities:
+				drop:
+				-	"NET_RAW"
+				-	"MKNOD"
+		`, actual)
+	})
+}
+
+func TestPodReadOnlyRootFilesystem(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	role := podTemplateTestLoadRole(assert)
+	if role == nil {
+		return
+	}
+
+	role.Run.ReadOnlyRootFilesystem = true
+	role.Run.WritablePaths = model.DefaultWritablePaths()
+
+	actual, err := RoundtripKube(getSecurityContext(role, ExportSettings{}))
+	if !assert.NoError(err) {
+		return
+	}
+	yamltest.IsYAMLSubsetString(assert, `---
+		readOnlyRootFilesystem: true
+	`, actual)
+
+	volumes, err := RoundtripKube(getNonClaimVolumes(role, ExportSettings{}))
+	if !assert.NoError(err) {
+		return
+	}
+	for index, writablePath := range role.Run.WritablePaths {
+		emptyDir := map[interface{}]interface{}{}
+		if writablePath.Tmpfs {
+			emptyDir["medium"] = "Memory"
+		}
+		assert.Contains(volumes, map[interface{}]interface{}{
+			"name":     fmt.Sprintf("myrole-writable-%d", index),
+			"emptyDir": emptyDir,
+		})
+	}
+
+	mounts, err := RoundtripKube(getVolumeMounts(role, ExportSettings{}))
+	if !assert.NoError(err) {
+		return
+	}
+	for index, writablePath := range role.Run.WritablePaths {
+		assert.Contains(mounts, map[interface{}]interface{}{
+			"mountPath": writablePath.Path,
+			"name":      fmt.Sprintf("myrole-writable-%d", index),
+		})
+	}
+
+	podTemplate, err := NewPodTemplate(role, ExportSettings{}, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	actual, err = RoundtripKube(podTemplate.Get("spec", "initContainers"))
+	if !assert.NoError(err) {
+		return
+	}
+	yamltest.IsYAMLSubsetString(assert, `---
+		-	name: myrole-seed
+			command:
+			-	/bin/sh
+			-	-c
+			-	|-
+				set -o errexit
+				cp -a /var/vcap/jobs/. /opt/fissile/seed/myrole-writable-5/
+				cp -a /var/vcap/monit/. /opt/fissile/seed/myrole-writable-6/
+				cp -a /var/log/. /opt/fissile/seed/myrole-writable-7/
+				cp -a /var/spool/cron/. /opt/fissile/seed/myrole-writable-8/
+				cp -a /etc/. /opt/fissile/seed/myrole-writable-9/
+			volumeMounts:
+			-	mountPath: /opt/fissile/seed/myrole-writable-5
+				name: myrole-writable-5
+			-	mountPath: /opt/fissile/seed/myrole-writable-6
+				name: myrole-writable-6
+			-	mountPath: /opt/fissile/seed/myrole-writable-7
+				name: myrole-writable-7
+			-	mountPath: /opt/fissile/seed/myrole-writable-8
+				name: myrole-writable-8
+			-	mountPath: /opt/fissile/seed/myrole-writable-9
+				name: myrole-writable-9
+	`, actual)
+}
+
+// TestPodReadOnlyRootFilesystemRunScript checks that the default writable
+// paths cover everything run.sh, and configgin on its behalf, writes to
+func TestPodReadOnlyRootFilesystemRunScript(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	role := podTemplateTestLoadRole(assert)
+	if role == nil {
+		return
+	}
+	role.Run.ReadOnlyRootFilesystem = true
+	role.Run.WritablePaths = model.DefaultWritablePaths()
+
+	script, err := ioutil.ReadFile("../scripts/dockerfiles/run.sh")
+	require.NoError(t, err)
+
+	variables := map[string]string{}
+	for _, match := range regexp.MustCompile(`(?m)^\s*(\w+)="(/[^"]*)"`).FindAllStringSubmatch(string(script), -1) {
+		variables[match[1]] = match[2]
+	}
+
+	// The files configgin renders, see builder.generateJobsConfig
+	written := []string{"/var/vcap/jobs/tor/bin/run", "/var/vcap/monit/tor.monitrc", "/etc/monitrc"}
+	for _, pattern := range []string{
+		`(?m)^\s*(?:mkdir -p|rm -f|chmod \S+|chown \S+|ln -s \S+)\s+"?([^\s";]+)`,
+		`(?m)^\s*find (\S+) .*-delete`,
+		`(?m)^\s*sed -i .* "?([^\s"]+)"?$`,
+		`>\s*"?(/[^\s";]+)`,
+	} {
+		for _, match := range regexp.MustCompile(pattern).FindAllStringSubmatch(string(script), -1) {
+			written = append(written, os.Expand(match[1], func(name string) string { return variables[name] }))
+		}
+	}
+	assert.Contains(written, "/var/vcap/instance/name")
+	assert.Contains(written, "/etc/security/limits.conf")
+	assert.Contains(written, "/var/spool/cron/tabs/")
+
+	mounts, err := RoundtripKube(getVolumeMounts(role, ExportSettings{}))
+	require.NoError(t, err)
+	// The container runtime mounts /dev itself
+	mountPaths := []string{"/dev"}
+	for _, mount := range mounts.([]interface{}) {
+		mountPaths = append(mountPaths, mount.(map[interface{}]interface{})["mountPath"].(string))
+	}
+	for _, writtenPath := range written {
+		covered := false
+		for _, mountPath := range mountPaths {
+			if strings.HasPrefix(filepath.Clean(writtenPath)+"/", mountPath+"/") {
+				covered = true
+			}
+		}
+		assert.True(covered, "run.sh writes to %s, which isn't writable", writtenPath)
+	}
+}
+
+func TestPodSysctls(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	role := podTemplateTestLoadRole(assert)
+	if role == nil {
+		return
+	}
+
+	assert.Nil(getPodSecurityContext(role))
+
+	role.Run.Sysctls = []*model.RoleRunSysctl{
+		{Name: "net.ipv4.tcp_syncookies", Value: "1"},
+		{Name: "net.core.somaxconn", Value: "1024"},
+	}
+	assert.Equal([]string{"net.core.somaxconn"}, role.UnsafeSysctls())
+
+	actual, err := RoundtripKube(getPodSecurityContext(role))
+	if !assert.NoError(err) {
+		return
+	}
+	yamltest.IsYAMLEqualString(assert, `---
+		sysctls:
+		-	name: "net.core.somaxconn"
+			value: "1024"
+		-	name: "net.ipv4.tcp_syncookies"
+			value: "1"
+	`, actual)
+
+	podTemplate, err := NewPodTemplate(role, ExportSettings{}, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	actual, err = RoundtripKube(podTemplate.Get("spec", "securityContext"))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Len(actual.(map[interface{}]interface{})["sysctls"], 2)
+}
+
+func TestPodTerminationGracePeriod(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	role := podTemplateTestLoadRole(assert)
+	if role == nil {
+		return
+	}
+	gracePeriod := 1800
+	role.Run.TerminationGracePeriodSeconds = &gracePeriod
+
+	podTemplate, err := NewPodTemplate(role, ExportSettings{}, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	actual, err := RoundtripKube(podTemplate.Get("spec"))
+	if !assert.NoError(err) {
+		return
+	}
+	yamltest.IsYAMLSubsetString(assert, `---
+		terminationGracePeriodSeconds: 1800
+	`, actual)
+
+	env, err := RoundtripKube(podTemplate.Get("spec", "containers").(*helm.List).Values()[0].(*helm.Mapping).Get("env"))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Contains(env, map[interface{}]interface{}{
+		"name":  "TERMINATION_GRACE_PERIOD_SECONDS",
+		"value": "1800",
+	})
+}
+
+func TestPodSidecars(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	role := podTemplateTestLoadRole(assert)
+	if role == nil {
+		return
+	}
+	memory := int64(64)
+	role.Run.Volumes = append(role.Run.Volumes, &model.RoleRunVolume{Type: model.VolumeTypeEmptyDir, Tag: "envoy-config", Path: "/etc/envoy"})
+	role.Run.Sidecars = []*model.RoleRunSidecar{{
+		Name:         "envoy",
+		Image:        "envoyproxy/envoy:v1.14.1",
+		Args:         []string{"-c", "/etc/envoy/envoy.yaml"},
+		Ports:        []*model.RoleRunSidecarPort{{Name: "admin", Port: 9901, Protocol: "TCP"}},
+		Env:          []*model.RoleRunSidecarEnv{{Name: "ENVOY_UID", Value: "0"}},
+		VolumeMounts: []*model.RoleRunSidecarVolumeMount{{Tag: "envoy-config", Path: "/etc/envoy", ReadOnly: true}},
+		Memory:       &model.RoleRunMemory{Request: &memory},
+	}}
+
+	t.Run("Kube", func(t *testing.T) {
+		t.Parallel()
+		podTemplate, err := NewPodTemplate(role, ExportSettings{UseMemoryLimits: true}, nil)
+		if !assert.NoError(err) {
+			return
+		}
+		containers := podTemplate.Get("spec", "containers").(*helm.List).Values()
+		if !assert.Len(containers, 2) {
+			return
+		}
+		actual, err := RoundtripKube(containers[1])
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLEqualString(assert, `---
+			name: envoy
+			image: envoyproxy/envoy:v1.14.1
+			args: ["-c", "/etc/envoy/envoy.yaml"]
+			ports:
+			- containerPort: 9901
+			  name: admin
+			  protocol: TCP
+			env:
+			- name: ENVOY_UID
+			  value: "0"
+			volumeMounts:
+			- mountPath: /etc/envoy
+			  name: envoy-config
+			  readOnly: true
+			resources:
+			  requests:
+			    memory: 64Mi
+		`, actual)
+	})
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		podTemplate, err := NewPodTemplate(role, ExportSettings{CreateHelmChart: true, UseMemoryLimits: true}, nil)
+		if !assert.NoError(err) {
+			return
+		}
+		// The extra containers of the helm extensions follow the sidecars
+		containers := podTemplate.Get("spec", "containers").(*helm.List).Values()
+		if !assert.Len(containers, 3) {
+			return
+		}
+		config := map[string]interface{}{"Values.config.memory.requests": false}
+		actual, err := RoundtripNode(containers[1], config)
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLSubsetString(assert, `---
+			name: envoy
+			resources:
+			  requests: ~
+		`, actual)
+	})
+}
+
+func TestPodDownwardAPIVolume(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	role := podTemplateTestLoadRole(assert)
+	if role == nil {
+		return
+	}
+	role.Run.DownwardAPIPath = "/etc/podinfo"
+
+	volumes, err := RoundtripKube(getNonClaimVolumes(role, ExportSettings{}))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Contains(volumes, map[interface{}]interface{}{
+		"name": "downward-api",
+		"downwardAPI": map[interface{}]interface{}{
+			"items": []interface{}{
+				map[interface{}]interface{}{"path": "namespace", "fieldRef": map[interface{}]interface{}{"fieldPath": "metadata.namespace"}},
+				map[interface{}]interface{}{"path": "name", "fieldRef": map[interface{}]interface{}{"fieldPath": "metadata.name"}},
+				map[interface{}]interface{}{"path": "labels", "fieldRef": map[interface{}]interface{}{"fieldPath": "metadata.labels"}},
+				map[interface{}]interface{}{"path": "annotations", "fieldRef": map[interface{}]interface{}{"fieldPath": "metadata.annotations"}},
+			},
+		},
+	})
+
+	mounts, err := RoundtripKube(getVolumeMounts(role, ExportSettings{}))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Contains(mounts, map[interface{}]interface{}{
+		"mountPath": "/etc/podinfo",
+		"name":      "downward-api",
+		"readOnly":  true,
+	})
+}
+
+func TestPodServiceAccountTokens(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	role := podTemplateTestLoadRole(assert)
+	if role == nil {
+		return
+	}
+	role.Run.ServiceAccountTokens = []*model.RoleRunServiceAccountToken{
+		{Path: "/var/run/secrets/vault", Audience: "vault"},
+		{Path: "/var/run/secrets/sts.amazonaws.com", Audience: "sts.amazonaws.com", ExpirationSeconds: 86400},
+	}
+
+	volumes, err := RoundtripKube(getNonClaimVolumes(role, ExportSettings{}))
+	if !assert.NoError(err) {
+		return
+	}
+	// The token volumes follow the host volume of the role
+	if !assert.Len(volumes, 4) {
+		return
+	}
+	yamltest.IsYAMLEqualString(assert, `---
+		-	name: myrole-token-0
+			projected:
+				sources:
+				-	serviceAccountToken:
+						audience: vault
+						path: token
+		-	name: myrole-token-1
+			projected:
+				sources:
+				-	serviceAccountToken:
+						audience: sts.amazonaws.com
+						expirationSeconds: 86400
+						path: token
+	`, volumes.([]interface{})[1:3])
+
+	mounts, err := RoundtripKube(getVolumeMounts(role, ExportSettings{}))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Contains(mounts, map[interface{}]interface{}{
+		"mountPath": "/var/run/secrets/vault",
+		"name":      "myrole-token-0",
+		"readOnly":  true,
+	})
+	assert.Contains(mounts, map[interface{}]interface{}{
+		"mountPath": "/var/run/secrets/sts.amazonaws.com",
+		"name":      "myrole-token-1",
+		"readOnly":  true,
+	})
+}
+
+func TestPodNoDeploymentManifest(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	role := podTemplateTestLoadRole(assert)
+	if role == nil {
+		return
+	}
+	role.Tags = append(role.Tags, model.RoleTagNoDeploymentManifest)
+
+	volumes, err := RoundtripKube(getNonClaimVolumes(role, ExportSettings{}))
+	if !assert.NoError(err) {
+		return
+	}
+	for _, volume := range volumes.([]interface{}) {
+		assert.NotEqual("deployment-manifest", volume.(map[interface{}]interface{})["name"])
+	}
+
+	mounts, err := RoundtripKube(getVolumeMounts(role, ExportSettings{}))
+	if !assert.NoError(err) {
+		return
+	}
+	for _, mount := range mounts.([]interface{}) {
+		assert.NotEqual("/opt/fissile/config", mount.(map[interface{}]interface{})["mountPath"])
+	}
+}
+
 func TestPodGetContainerImageNameKube(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
@@ -2808,11 +3604,61 @@ func TestPodGetContainerImageNameHelm(t *testing.T) {
 		return
 	}
 
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		R/O/theRepo-myrole:d0aca33ba5bc55dce697d9d57b46e1b23688659c
 	`, actual)
 }
 
+func TestPodGetContainerImageNameDigests(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	role := podTemplateTestLoadRole(assert)
+	if role == nil {
+		return
+	}
+
+	digest := "sha256:a886ed76c6d6e5a96ad5c37fb208368a430a29d770f1d149a78e1e6e8091eb12"
+	settings := ExportSettings{
+		Repository:   "theRepo",
+		Opinions:     model.NewEmptyOpinions(),
+		Organization: "O",
+		Registry:     "R",
+		ImageDigests: map[string]string{"myrole": digest},
+	}
+	grapher := FakeGrapher{}
+
+	name, err := getContainerImageName(role, settings, grapher)
+	assert.NoError(err)
+	assert.Equal("R/O/theRepo-myrole@"+digest, name)
+
+	settings.ImageDigests = map[string]string{"other": digest}
+	_, err = getContainerImageName(role, settings, grapher)
+	assert.EqualError(err, "No image digest for instance group myrole")
+
+	settings.CreateHelmChart = true
+	settings.ImageDigests = map[string]string{"myrole": digest}
+	name, err = getContainerImageName(role, settings, grapher)
+	if !assert.NoError(err) {
+		return
+	}
+
+	for _, pinned := range []bool{true, false} {
+		actual, err := RoundtripNode(helm.NewNode(name), map[string]interface{}{
+			"Values.kube.registry.hostname": "R",
+			"Values.kube.organization":      "O",
+			"Values.kube.pin_image_digests": pinned,
+		})
+		if !assert.NoError(err) {
+			return
+		}
+		if pinned {
+			assert.Equal("R/O/theRepo-myrole@"+digest, actual)
+		} else {
+			assert.Equal("R/O/theRepo-myrole:d0aca33ba5bc55dce697d9d57b46e1b23688659c", actual)
+		}
+	}
+}
+
 func TestPodGetContainerPortsKube(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
@@ -2831,7 +3677,7 @@ func TestPodGetContainerPortsKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	containerPort: 8080
 			name: "http"
 			protocol: "TCP"
@@ -2861,7 +3707,7 @@ func TestPodGetContainerPortsHelm(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	containerPort: 8080
 			name: "http"
 			protocol: "TCP"
@@ -2871,6 +3717,35 @@ func TestPodGetContainerPortsHelm(t *testing.T) {
 	`, actual)
 }
 
+func TestPodGetContainerPortsAppProtocol(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	role := podTestLoadRoleFrom(assert, "myrole", "app-protocol.yml")
+	if role == nil {
+		return
+	}
+
+	ports, err := getContainerPorts(role, ExportSettings{})
+	assert.Nil(err)
+	assert.NotNil(ports)
+
+	actual, err := RoundtripKube(ports)
+	if !assert.NoError(err) {
+		return
+	}
+	yamltest.IsYAMLEqualString(assert, `---
+		-	containerPort: 8080
+			name: "http-api"
+			protocol: "TCP"
+		-	containerPort: 9090
+			name: "grpc-rpc"
+			protocol: "TCP"
+		-	containerPort: 9100
+			name: "metrics"
+			protocol: "TCP"
+	`, actual)
+}
+
 func TestPodGetContainerPortsHelmCountConfigurable(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
@@ -2895,7 +3770,7 @@ func TestPodGetContainerPortsHelmCountConfigurable(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	containerPort: 20000
 			name: "tcp-route-0"
 			protocol: "TCP"
@@ -2918,13 +3793,13 @@ func TestPodMakeSecretVarPlain(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
 
-	sv := makeSecretVar("foo", false)
+	sv := makeSecretVar("foo", false, ExportSettings{CreateHelmChart: true})
 
 	actual, err := RoundtripNode(sv, nil)
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		name: "foo"
 		valueFrom:
 			secretKeyRef:
@@ -2937,7 +3812,7 @@ func TestPodMakeSecretVarGenerated(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
 
-	sv := makeSecretVar("foo", true)
+	sv := makeSecretVar("foo", true, ExportSettings{CreateHelmChart: true})
 
 	config := map[string]interface{}{
 		"Chart.Version":                          "CV",
@@ -2948,7 +3823,7 @@ func TestPodMakeSecretVarGenerated(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		name: "foo"
 		valueFrom:
 			secretKeyRef:
@@ -2985,7 +3860,7 @@ func TestPodVolumeTypeEmptyDir(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		-	name: "shared-data"
 			emptyDir: {}
 		-	name: deployment-manifest
@@ -3006,7 +3881,7 @@ func TestPodVolumeTypeEmptyDir(t *testing.T) {
 		if !assert.NoError(err) {
 			return
 		}
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			-	mountPath: "/var/vcap/store"
 				name: "shared-data"
 			-	mountPath: /opt/fissile/config
@@ -3016,6 +3891,79 @@ func TestPodVolumeTypeEmptyDir(t *testing.T) {
 	}
 }
 
+func TestPodVolumeTypeSharedSocket(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	ntpReleasePath := filepath.Join(workDir, "../test-assets/ntp-release")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/kube/colocated-containers-with-shared-socket.yml")
+	roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{torReleasePath, ntpReleasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../test-assets/.final_releases")},
+		ValidationOptions: model.RoleManifestValidationOptions{
+			AllowMissingScripts: true,
+		}})
+	if !assert.NoError(err) {
+		return
+	}
+	role := roleManifest.LookupInstanceGroup("main-role")
+	settings := ExportSettings{
+		CreateHelmChart: true,
+		Repository:      "theRepo",
+		Opinions:        model.NewEmptyOpinions(),
+		RoleManifest:    roleManifest,
+	}
+
+	// The socket is a single emptyDir volume of the pod
+	volumes, err := RoundtripNode(getNonClaimVolumes(role, settings), nil)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Contains(volumes, map[interface{}]interface{}{
+		"name":     "agent-socket",
+		"emptyDir": map[interface{}]interface{}{},
+	})
+
+	// The socket is mounted into the main container and the colocated one
+	podTemplate, err := NewPodTemplate(role, settings, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	// The last element is the extraContainers extension point
+	containers := podTemplate.Get("spec", "containers").(*helm.List).Values()
+	if !assert.Len(containers, 3) {
+		return
+	}
+	for _, container := range containers[:2] {
+		mounts, err := RoundtripNode(container.(*helm.Mapping).Get("volumeMounts"), nil)
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Contains(mounts, map[interface{}]interface{}{
+			"mountPath": "/var/vcap/sys/run/agent",
+			"name":      "agent-socket",
+		})
+	}
+
+	// The socket is not a volume claim of its own
+	for _, candidate := range []*model.InstanceGroup{role, roleManifest.LookupInstanceGroup("to-be-colocated")} {
+		mounts, err := RoundtripNode(getVolumeMounts(candidate, settings), nil)
+		if !assert.NoError(err) {
+			return
+		}
+		assert.NotContains(mounts, map[interface{}]interface{}{
+			"mountPath": "/var/vcap/sys/run/agent",
+			"name":      "agent-socket",
+		})
+	}
+	assert.Empty(getVolumeClaims(role, true))
+}
+
 func TestPodIstioManagedHelm(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
@@ -3034,19 +3982,21 @@ func TestPodIstioManagedHelm(t *testing.T) {
 	assert.NotNil(pod)
 
 	config := map[string]interface{}{
-		"Values.config.use_istio":                       "true",
-		"Values.kube.registry.hostname":                 "R",
-		"Values.kube.registry.username":                 "U",
-		"Values.kube.organization":                      "O",
-		"Values.env.KUBERNETES_CLUSTER_DOMAIN":          "cluster.local",
-		"Values.sizing.istio_managed_role.capabilities": []interface{}{},
+		"Values.sizing.istio_managed_role.debug.command": nil,
+		"Values.sizing.istio_managed_role.job":           map[string]interface{}{},
+		"Values.config.use_istio":                        "true",
+		"Values.kube.registry.hostname":                  "R",
+		"Values.kube.registry.username":                  "U",
+		"Values.kube.organization":                       "O",
+		"Values.env.KUBERNETES_CLUSTER_DOMAIN":           "cluster.local",
+		"Values.sizing.istio_managed_role.capabilities":  []interface{}{},
 	}
 
 	actual, err := RoundtripNode(pod, config)
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "v1"
 		kind: "Pod"
 		metadata:
@@ -3055,7 +4005,7 @@ func TestPodIstioManagedHelm(t *testing.T) {
 				app: istio-managed-role
 				app.kubernetes.io/component: istio-managed-role
 				app.kubernetes.io/instance: MyRelease
-				app.kubernetes.io/managed-by: Tiller
+				app.kubernetes.io/managed-by: fissile
 				app.kubernetes.io/name: MyChart
 				app.kubernetes.io/version: 1.22.333.4444
 				helm.sh/chart: MyChart-42.1_foo
@@ -3078,6 +4028,8 @@ func TestPodIstioManagedHelm(t *testing.T) {
 					value: "2048"
 				-	name: "VCAP_SOFT_NPROC"
 					value: "1024"
+				-	name: "TERMINATION_GRACE_PERIOD_SECONDS"
+					value: "600"
 				image: "R/O/theRepo-istio-managed-role:e9f459d3c3576bf1129a6b18ca2763f73fa19645"
 				lifecycle:
 					preStop:
@@ -3112,3 +4064,70 @@ func TestPodIstioManagedHelm(t *testing.T) {
 					secretName: deployment-manifest
 	`, actual)
 }
+
+func TestPodExtensionPoints(t *testing.T) {
+	t.Parallel()
+	role := podTestLoadRole(assert.New(t), "pre-role")
+	if role == nil {
+		return
+	}
+	podTemplate, err := NewPodTemplate(role, ExportSettings{
+		CreateHelmChart: true,
+		Opinions:        model.NewEmptyOpinions(),
+	}, nil)
+	require.NoError(t, err)
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+		actual, err := RoundtripNode(podTemplate, map[string]interface{}{
+			"Values.sizing.pre_role.debug": map[string]interface{}{},
+		})
+		require.NoError(t, err)
+		spec := actual.(map[interface{}]interface{})["spec"].(map[interface{}]interface{})
+		assert.Len(spec["containers"], 1)
+		annotations := actual.(map[interface{}]interface{})["metadata"].(map[interface{}]interface{})["annotations"]
+		assert.Len(annotations, 1)
+	})
+
+	t.Run("Values", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+		actual, err := RoundtripNode(podTemplate, map[string]interface{}{
+			"Values.sizing.pre_role.debug": map[string]interface{}{},
+			"Values.extensions.extra_volumes": []interface{}{
+				map[string]interface{}{"name": "extra", "emptyDir": map[string]interface{}{}},
+			},
+			"Values.extensions.extra_env": []interface{}{
+				map[string]interface{}{"name": "EXTRA", "value": "yes"},
+			},
+			"Values.extensions.extra_containers": []interface{}{
+				map[string]interface{}{"name": "sidecar", "image": "busybox"},
+			},
+			"Values.extensions.pod_annotations": map[string]interface{}{"example.com/extra": 1},
+		})
+		require.NoError(t, err)
+
+		spec := actual.(map[interface{}]interface{})["spec"].(map[interface{}]interface{})
+		volumes := spec["volumes"].([]interface{})
+		yamltest.IsYAMLEqualString(assert, `---
+			name: extra
+			emptyDir: {}
+		`, volumes[len(volumes)-1])
+
+		containers := spec["containers"].([]interface{})
+		require.Len(t, containers, 2)
+		yamltest.IsYAMLEqualString(assert, `---
+			name: sidecar
+			image: busybox
+		`, containers[1])
+		env := containers[0].(map[interface{}]interface{})["env"].([]interface{})
+		yamltest.IsYAMLEqualString(assert, `---
+			name: EXTRA
+			value: "yes"
+		`, env[len(env)-1])
+
+		annotations := actual.(map[interface{}]interface{})["metadata"].(map[interface{}]interface{})["annotations"].(map[interface{}]interface{})
+		assert.Equal("1", annotations["example.com/extra"])
+	})
+}
@@ -0,0 +1,333 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// PolicyBundleDir is the directory below the output directory the policy
+// bundle is written to
+const PolicyBundleDir = "policy"
+
+// PolicySummaryFile is the name of the summary of the security-relevant
+// settings of the workloads, in the policy bundle
+const PolicySummaryFile = "workloads.json"
+
+// PolicyExemptionsFile is the name of the exemption requests for the
+// Gatekeeper constraints the workloads violate, in the policy bundle
+const PolicyExemptionsFile = "exemption-requests.yaml"
+
+// PolicySummary is the summary of the security-relevant settings of the
+// workloads of a release, as input for policy engines like OPA.
+type PolicySummary struct {
+	Workloads []*WorkloadSecurity `json:"workloads"`
+}
+
+// WorkloadSecurity holds the security-relevant settings of a workload, along
+// with the labels and annotations of the workload and of its pods, which
+// policies match on. For instance groups, the name is the name of the
+// instance group, i.e. without the name prefix of helm charts, and the
+// containers are described by the role manifest. For helm charts, labels and
+// annotations are the templates as written; entries depending on the values
+// are left out.
+type WorkloadSecurity struct {
+	Kind           string               `json:"kind"`
+	Name           string               `json:"name"`
+	InstanceGroup  string               `json:"instanceGroup,omitempty"`
+	ServiceAccount string               `json:"serviceAccount"`
+	Labels         map[string]string    `json:"labels"`
+	Annotations    map[string]string    `json:"annotations"`
+	PodLabels      map[string]string    `json:"podLabels"`
+	PodAnnotations map[string]string    `json:"podAnnotations"`
+	UnsafeSysctls  []string             `json:"unsafeSysctls"`
+	Containers     []*ContainerSecurity `json:"containers"`
+}
+
+// ContainerSecurity holds the security-relevant settings of a container of a
+// workload. The dropped capabilities of instance groups are those of the role
+// manifest, which helm charts allow to override.
+type ContainerSecurity struct {
+	Name                     string   `json:"name"`
+	Privileged               bool     `json:"privileged"`
+	AllowPrivilegeEscalation bool     `json:"allowPrivilegeEscalation"`
+	AddCapabilities          []string `json:"addCapabilities"`
+	DropCapabilities         []string `json:"dropCapabilities"`
+	ReadOnlyRootFilesystem   bool     `json:"readOnlyRootFilesystem"`
+	HostPaths                []string `json:"hostPaths"`
+}
+
+// PolicyExemptionRequest asks for an exemption of a workload from a
+// constraint of the Gatekeeper policy library.
+type PolicyExemptionRequest struct {
+	Constraint string   `yaml:"constraint"`
+	Kind       string   `yaml:"kind"`
+	Name       string   `yaml:"name"`
+	Containers []string `yaml:"containers,omitempty"`
+	Reason     string   `yaml:"reason"`
+}
+
+// podTemplatePaths are the paths of the pod templates of the kinds of
+// workloads creating pods; pods are their own template
+var podTemplatePaths = map[string][]string{
+	"Pod":               {},
+	"Job":               {"spec", "template"},
+	"StatefulSet":       {"spec", "template"},
+	"Deployment":        {"spec", "template"},
+	"DaemonSet":         {"spec", "template"},
+	"ReplicaSet":        {"spec", "template"},
+	"CronJob":           {"spec", "jobTemplate", "spec", "template"},
+	"QuarksStatefulSet": {"spec", "template", "spec", "template"},
+	"QuarksJob":         {"spec", "template", "spec", "template"},
+}
+
+// PolicyWorkloads returns the workloads creating pods among the generated
+// nodes, including the items of lists. Their security-relevant settings are
+// read from the nodes; see MakePolicySummary for those of instance groups.
+func PolicyWorkloads(nodes ...helm.Node) []*WorkloadSecurity {
+	var workloads []*WorkloadSecurity
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		kind, ok := node.Get("kind").(*helm.Scalar)
+		if !ok {
+			continue
+		}
+		if kind.String() == "List" {
+			if items, ok := node.Get("items").(*helm.List); ok {
+				workloads = append(workloads, PolicyWorkloads(items.Values()...)...)
+			}
+			continue
+		}
+		templatePath, ok := podTemplatePaths[kind.String()]
+		if !ok {
+			continue
+		}
+		podTemplate := node
+		if len(templatePath) > 0 {
+			podTemplate = node.Get(templatePath...)
+		}
+		if podTemplate == nil {
+			continue
+		}
+
+		workload := &WorkloadSecurity{
+			Kind:           kind.String(),
+			Name:           scalarString(node.Get("metadata", "name")),
+			ServiceAccount: scalarString(podTemplate.Get("spec", "serviceAccountName")),
+			Labels:         stringEntries(node.Get("metadata", "labels")),
+			Annotations:    stringEntries(node.Get("metadata", "annotations")),
+			PodLabels:      stringEntries(podTemplate.Get("metadata", "labels")),
+			PodAnnotations: stringEntries(podTemplate.Get("metadata", "annotations")),
+			UnsafeSysctls:  []string{},
+		}
+		if name, ok := workload.PodLabels["skiff-role-name"]; ok {
+			workload.Name = name
+		}
+		hostPaths := map[string]string{}
+		if volumes, ok := podTemplate.Get("spec", "volumes").(*helm.List); ok {
+			for _, volume := range volumes.Values() {
+				if path := scalarString(volume.Get("hostPath", "path")); path != "" {
+					hostPaths[scalarString(volume.Get("name"))] = path
+				}
+			}
+		}
+		for _, key := range []string{"initContainers", "containers"} {
+			containers, ok := podTemplate.Get("spec", key).(*helm.List)
+			if !ok {
+				continue
+			}
+			for _, container := range containers.Values() {
+				workload.Containers = append(workload.Containers, containerSecurity(container, hostPaths))
+			}
+		}
+		workloads = append(workloads, workload)
+	}
+	return workloads
+}
+
+// containerSecurity returns the security-relevant settings of a container
+// node; hostPaths maps the names of the host path volumes of the pod to their
+// paths. As in Kubernetes, privilege escalation is allowed unless disabled.
+func containerSecurity(container helm.Node, hostPaths map[string]string) *ContainerSecurity {
+	securityContext := container.Get("securityContext")
+	result := &ContainerSecurity{
+		Name:                     scalarString(container.Get("name")),
+		AllowPrivilegeEscalation: true,
+		AddCapabilities:          []string{},
+		DropCapabilities:         []string{},
+		HostPaths:                []string{},
+	}
+	if securityContext != nil {
+		result.Privileged = scalarString(securityContext.Get("privileged")) == "true"
+		if allow := scalarString(securityContext.Get("allowPrivilegeEscalation")); allow != "" {
+			result.AllowPrivilegeEscalation = allow == "true"
+		}
+		result.ReadOnlyRootFilesystem = scalarString(securityContext.Get("readOnlyRootFilesystem")) == "true"
+		if add, ok := securityContext.Get("capabilities", "add").(*helm.List); ok {
+			for _, capability := range add.Values() {
+				result.AddCapabilities = append(result.AddCapabilities, scalarString(capability))
+			}
+		}
+		if drop, ok := securityContext.Get("capabilities", "drop").(*helm.List); ok {
+			for _, capability := range drop.Values() {
+				result.DropCapabilities = append(result.DropCapabilities, scalarString(capability))
+			}
+		}
+	}
+	if mounts, ok := container.Get("volumeMounts").(*helm.List); ok {
+		for _, mount := range mounts.Values() {
+			if path, ok := hostPaths[scalarString(mount.Get("name"))]; ok {
+				result.HostPaths = append(result.HostPaths, path)
+			}
+		}
+	}
+	return result
+}
+
+// scalarString returns the value of a scalar node, or "" for other nodes
+func scalarString(node helm.Node) string {
+	if scalar, ok := node.(*helm.Scalar); ok {
+		return scalar.String()
+	}
+	return ""
+}
+
+// stringEntries returns the scalar entries of a mapping node, leaving out
+// those depending on a condition
+func stringEntries(node helm.Node) map[string]string {
+	entries := map[string]string{}
+	mapping, ok := node.(*helm.Mapping)
+	if !ok {
+		return entries
+	}
+	for _, name := range mapping.Names() {
+		value := mapping.Get(name)
+		if value.Block() != "" {
+			continue
+		}
+		if scalar, ok := value.(*helm.Scalar); ok {
+			entries[name] = scalar.String()
+		}
+	}
+	return entries
+}
+
+// MakePolicySummary returns the summary of the workloads generated for the
+// role manifest, as returned by PolicyWorkloads for the generated nodes. The
+// security-relevant settings of instance groups are taken from the role
+// manifest.
+func MakePolicySummary(settings ExportSettings, workloads []*WorkloadSecurity) *PolicySummary {
+	summary := &PolicySummary{Workloads: []*WorkloadSecurity{}}
+	for _, workload := range workloads {
+		instanceGroup := settings.RoleManifest.LookupInstanceGroup(workload.Name)
+		if instanceGroup != nil && instanceGroup.Run != nil && !instanceGroup.IsColocated() {
+			workload.InstanceGroup = instanceGroup.Name
+			workload.ServiceAccount = instanceGroup.Run.ServiceAccount
+			workload.UnsafeSysctls = instanceGroup.UnsafeSysctls()
+			if workload.UnsafeSysctls == nil {
+				workload.UnsafeSysctls = []string{}
+			}
+			workload.Containers = nil
+			for _, candidate := range append([]*model.InstanceGroup{instanceGroup}, instanceGroup.GetColocatedRoles()...) {
+				container := &ContainerSecurity{
+					Name:                     candidate.Name,
+					Privileged:               candidate.Run.Privileged,
+					AllowPrivilegeEscalation: allowPrivilegeEscalation(candidate),
+					AddCapabilities:          append([]string{}, candidate.Run.Capabilities...),
+					DropCapabilities:         append([]string{}, candidate.Run.DropCapabilities...),
+					ReadOnlyRootFilesystem:   candidate.Run.ReadOnlyRootFilesystem,
+					HostPaths:                []string{},
+				}
+				for _, volume := range candidate.Run.Volumes {
+					if volume.Type == model.VolumeTypeHost {
+						container.HostPaths = append(container.HostPaths, volume.Path)
+					}
+				}
+				workload.Containers = append(workload.Containers, container)
+			}
+		}
+		summary.Workloads = append(summary.Workloads, workload)
+	}
+	return summary
+}
+
+// policyConstraint is a constraint of the Gatekeeper policy library, with
+// the reason a container violates it, if any
+type policyConstraint struct {
+	kind   string
+	reason func(*ContainerSecurity) string
+}
+
+var policyConstraints = []policyConstraint{
+	{"K8sPSPPrivilegedContainer", func(c *ContainerSecurity) string {
+		if c.Privileged {
+			return "privileged container"
+		}
+		return ""
+	}},
+	{"K8sPSPAllowPrivilegeEscalationContainer", func(c *ContainerSecurity) string {
+		if c.AllowPrivilegeEscalation {
+			return "allows privilege escalation"
+		}
+		return ""
+	}},
+	{"K8sPSPCapabilities", func(c *ContainerSecurity) string {
+		if len(c.AddCapabilities) > 0 {
+			return "adds capabilities " + strings.Join(c.AddCapabilities, ", ")
+		}
+		return ""
+	}},
+	{"K8sPSPHostFilesystem", func(c *ContainerSecurity) string {
+		if len(c.HostPaths) > 0 {
+			return "mounts host paths " + strings.Join(c.HostPaths, ", ")
+		}
+		return ""
+	}},
+	{"K8sPSPReadOnlyRootFilesystem", func(c *ContainerSecurity) string {
+		if !c.ReadOnlyRootFilesystem {
+			return "writable root filesystem"
+		}
+		return ""
+	}},
+}
+
+// MakePolicyExemptionRequests returns the exemptions the workloads of the
+// summary need from the constraints of the Gatekeeper policy library, by
+// workload and constraint.
+func MakePolicyExemptionRequests(summary *PolicySummary) []PolicyExemptionRequest {
+	var requests []PolicyExemptionRequest
+	for _, workload := range summary.Workloads {
+		for _, constraint := range policyConstraints {
+			var containers, reasons []string
+			for _, container := range workload.Containers {
+				if reason := constraint.reason(container); reason != "" {
+					containers = append(containers, container.Name)
+					reasons = append(reasons, fmt.Sprintf("%s: %s", container.Name, reason))
+				}
+			}
+			if len(containers) == 0 {
+				continue
+			}
+			requests = append(requests, PolicyExemptionRequest{
+				Constraint: constraint.kind,
+				Kind:       workload.Kind,
+				Name:       workload.Name,
+				Containers: containers,
+				Reason:     strings.Join(reasons, "; "),
+			})
+		}
+		if len(workload.UnsafeSysctls) > 0 {
+			requests = append(requests, PolicyExemptionRequest{
+				Constraint: "K8sPSPForbiddenSysctls",
+				Kind:       workload.Kind,
+				Name:       workload.Name,
+				Reason:     "sets unsafe sysctls " + strings.Join(workload.UnsafeSysctls, ", "),
+			})
+		}
+	}
+	return requests
+}
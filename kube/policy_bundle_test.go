@@ -0,0 +1,155 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyWorkloads(t *testing.T) {
+	t.Parallel()
+
+	podTemplate := func(name string) *helm.Mapping {
+		labels := helm.NewMapping("skiff-role-name", name)
+		labels.Add("app.kubernetes.io/version", "{{ .Chart.Version }}", helm.Block("if .Values.labels"))
+		return helm.NewMapping(
+			"metadata", helm.NewMapping(
+				"labels", labels,
+				"annotations", helm.NewMapping("checksum/config", "abc")),
+			"spec", helm.NewMapping(
+				"serviceAccountName", "default",
+				"volumes", helm.NewList(
+					helm.NewMapping("name", "cgroup", "hostPath", helm.NewMapping("path", "/sys/fs/cgroup"))),
+				"containers", helm.NewList(helm.NewMapping(
+					"name", name,
+					"volumeMounts", helm.NewList(helm.NewMapping("name", "cgroup", "mountPath", "/sys/fs/cgroup")),
+					"securityContext", helm.NewMapping(
+						"allowPrivilegeEscalation", false,
+						"capabilities", helm.NewMapping("add", helm.NewList("NET_ADMIN"))))),
+			))
+	}
+
+	workloads := PolicyWorkloads(
+		helm.NewMapping(
+			"kind", "QuarksStatefulSet",
+			"metadata", helm.NewMapping("name", "prefix-router", "labels", helm.NewMapping("app", "router")),
+			"spec", helm.NewMapping("template", helm.NewMapping("spec", helm.NewMapping("template", podTemplate("router"))))),
+		helm.NewMapping(
+			"kind", "List",
+			"items", helm.NewList(
+				helm.NewMapping("kind", "ServiceAccount", "metadata", helm.NewMapping("name", "default")),
+				helm.NewMapping(
+					"kind", "CronJob",
+					"metadata", helm.NewMapping("name", "cleanup"),
+					"spec", helm.NewMapping("jobTemplate", helm.NewMapping("spec", helm.NewMapping("template", podTemplate("cleanup"))))))),
+		helm.NewMapping("kind", "Service", "metadata", helm.NewMapping("name", "router")),
+	)
+
+	require.Len(t, workloads, 2, "Only workloads creating pods are summarized")
+	assert.Equal(t, &WorkloadSecurity{
+		Kind:           "QuarksStatefulSet",
+		Name:           "router",
+		ServiceAccount: "default",
+		Labels:         map[string]string{"app": "router"},
+		Annotations:    map[string]string{},
+		PodLabels:      map[string]string{"skiff-role-name": "router"},
+		PodAnnotations: map[string]string{"checksum/config": "abc"},
+		UnsafeSysctls:  []string{},
+		Containers: []*ContainerSecurity{{
+			Name:             "router",
+			AddCapabilities:  []string{"NET_ADMIN"},
+			DropCapabilities: []string{},
+			HostPaths:        []string{"/sys/fs/cgroup"},
+		}},
+	}, workloads[0])
+	assert.Equal(t, "CronJob", workloads[1].Kind)
+	assert.Equal(t, "cleanup", workloads[1].Name)
+}
+
+func TestMakePolicySummary(t *testing.T) {
+	t.Parallel()
+
+	settings := ExportSettings{
+		CreateHelmChart: true,
+		RoleManifest: &model.RoleManifest{
+			InstanceGroups: model.InstanceGroups{
+				&model.InstanceGroup{
+					Name: "router",
+					Type: model.RoleTypeBosh,
+					Run: &model.RoleRun{
+						ServiceAccount: "router",
+						Capabilities:   []string{"NET_ADMIN"},
+						Volumes: []*model.RoleRunVolume{
+							{Type: model.VolumeTypeHost, Path: "/sys/fs/cgroup", Tag: "cgroup"},
+							{Type: model.VolumeTypePersistent, Path: "/var/vcap/store", Tag: "store"},
+						},
+						Sysctls: []*model.RoleRunSysctl{
+							{Name: "net.ipv4.tcp_syncookies", Value: "1"},
+							{Name: "net.core.somaxconn", Value: "1024"},
+						},
+					},
+				},
+				&model.InstanceGroup{
+					Name: "smoke-tests",
+					Type: model.RoleTypeBoshTask,
+					Run: &model.RoleRun{
+						DropCapabilities:       []string{"ALL"},
+						ReadOnlyRootFilesystem: true,
+					},
+				},
+			},
+		},
+	}
+
+	labels := map[string]string{"skiff-role-name": "router"}
+	prePull := &ContainerSecurity{
+		Name:                     "pull-router",
+		AllowPrivilegeEscalation: true,
+		AddCapabilities:          []string{},
+		DropCapabilities:         []string{},
+		HostPaths:                []string{},
+	}
+	summary := MakePolicySummary(settings, []*WorkloadSecurity{
+		{Kind: "StatefulSet", Name: "router", PodLabels: labels, UnsafeSysctls: []string{}},
+		{Kind: "QuarksJob", Name: "smoke-tests", UnsafeSysctls: []string{}},
+		{Kind: "DaemonSet", Name: "image-prepull", UnsafeSysctls: []string{},
+			Containers: []*ContainerSecurity{prePull}},
+	})
+	require.Len(t, summary.Workloads, 3)
+	assert.Equal(t, &WorkloadSecurity{
+		Kind:           "StatefulSet",
+		Name:           "router",
+		InstanceGroup:  "router",
+		ServiceAccount: "router",
+		PodLabels:      labels,
+		UnsafeSysctls:  []string{"net.core.somaxconn"},
+		Containers: []*ContainerSecurity{{
+			Name:             "router",
+			AddCapabilities:  []string{"NET_ADMIN"},
+			DropCapabilities: []string{},
+			HostPaths:        []string{"/sys/fs/cgroup"},
+		}},
+	}, summary.Workloads[0])
+	assert.Equal(t, "QuarksJob", summary.Workloads[1].Kind)
+	assert.Equal(t, []string{"ALL"}, summary.Workloads[1].Containers[0].DropCapabilities)
+	assert.Empty(t, summary.Workloads[2].InstanceGroup)
+	assert.Equal(t, []*ContainerSecurity{prePull}, summary.Workloads[2].Containers)
+
+	assert.Equal(t, []PolicyExemptionRequest{
+		{Constraint: "K8sPSPCapabilities", Kind: "StatefulSet", Name: "router", Containers: []string{"router"},
+			Reason: "router: adds capabilities NET_ADMIN"},
+		{Constraint: "K8sPSPHostFilesystem", Kind: "StatefulSet", Name: "router", Containers: []string{"router"},
+			Reason: "router: mounts host paths /sys/fs/cgroup"},
+		{Constraint: "K8sPSPReadOnlyRootFilesystem", Kind: "StatefulSet", Name: "router", Containers: []string{"router"},
+			Reason: "router: writable root filesystem"},
+		{Constraint: "K8sPSPForbiddenSysctls", Kind: "StatefulSet", Name: "router",
+			Reason: "sets unsafe sysctls net.core.somaxconn"},
+		{Constraint: "K8sPSPAllowPrivilegeEscalationContainer", Kind: "DaemonSet", Name: "image-prepull",
+			Containers: []string{"pull-router"}, Reason: "pull-router: allows privilege escalation"},
+		{Constraint: "K8sPSPReadOnlyRootFilesystem", Kind: "DaemonSet", Name: "image-prepull",
+			Containers: []string{"pull-router"}, Reason: "pull-router: writable root filesystem"},
+	}, MakePolicyExemptionRequests(summary))
+}
@@ -0,0 +1,80 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// prometheusOperatorAPIVersion is the API version of the monitors of the
+// Prometheus operator
+const prometheusOperatorAPIVersion = "monitoring.coreos.com/v1"
+
+// NewPrometheusMonitor returns the monitor of the Prometheus operator
+// scraping the metrics of the instance group, or nil if it isn't monitored.
+// Ports of the jobs are scraped through the private service of their job, by
+// a ServiceMonitor; ports of sidecars, which aren't part of any service, are
+// scraped from the pods by a PodMonitor. It is only created if
+// .Values.config.prometheus.enabled is set and the cluster provides the
+// resources of the operator.
+func NewPrometheusMonitor(instanceGroup *model.InstanceGroup, settings ExportSettings) (helm.Node, error) {
+	monitoring := instanceGroup.Monitoring
+	if monitoring == nil {
+		return nil, nil
+	}
+	if !settings.CreateHelmChart {
+		return nil, fmt.Errorf("Prometheus monitors require a helm chart")
+	}
+
+	kind := "PodMonitor"
+	endpointsKey := "podMetricsEndpoints"
+	selector := newSelector(instanceGroup, settings)
+	portName := monitoring.Port
+	for _, jobReference := range instanceGroup.JobReferences {
+		for _, port := range jobReference.ContainerProperties.BoshContainerization.Ports {
+			if port.Name != monitoring.Port {
+				continue
+			}
+			kind = "ServiceMonitor"
+			endpointsKey = "endpoints"
+			matchLabels := helm.NewMapping(RoleNameLabel, jobServiceName(instanceGroup, jobReference))
+			addInstanceSelector(matchLabels, settings)
+			selector = helm.NewMapping("matchLabels", matchLabels)
+			portName = port.KubeName()
+		}
+	}
+
+	endpoint := helm.NewMapping("port", portName, "path", monitoring.MetricsPath())
+	if monitoring.Interval != "" {
+		endpoint.Add("interval", monitoring.Interval)
+	}
+
+	conditions := []string{
+		".Values.config.prometheus.enabled",
+		fmt.Sprintf("(.Capabilities.APIVersions.Has %q)", prometheusOperatorAPIVersion),
+	}
+	if block := featureCheckBlock(instanceGroup); block != "" {
+		conditions = append(conditions, fmt.Sprintf("(%s)", strings.TrimPrefix(block, "if ")))
+	}
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion(prometheusOperatorAPIVersion).
+		SetKind(kind).
+		SetName(instanceGroup.Name).
+		AddModifier(helm.Block(fmt.Sprintf("if and %s", strings.Join(conditions, " ")))).
+		AddModifier(helm.Comment(fmt.Sprintf("Prometheus scraping of the metrics of the %s instance group", instanceGroup.Name)))
+	monitor, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+
+	spec := helm.NewMapping()
+	spec.Add("selector", selector)
+	spec.Add(endpointsKey, helm.NewList(endpoint))
+	monitor.Add("spec", spec)
+
+	return monitor, nil
+}
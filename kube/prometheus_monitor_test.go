@@ -0,0 +1,117 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPrometheusMonitor(t *testing.T) {
+	t.Parallel()
+
+	instanceGroup := &model.InstanceGroup{
+		Name:      "main-role",
+		Type:      model.RoleTypeBosh,
+		IfFeature: "extra",
+		JobReferences: model.JobReferences{&model.JobReference{
+			Name: "api",
+			ContainerProperties: model.JobContainerProperties{BoshContainerization: model.JobBoshContainerization{
+				Ports: []model.JobExposedPort{{Name: "metrics", AppProtocol: model.AppProtocolHTTP, Count: 1, Max: 1}},
+			}},
+		}},
+		Monitoring: &model.InstanceGroupMonitoring{Port: "metrics", Interval: "30s"},
+		Run: &model.RoleRun{
+			FlightStage: model.FlightStageFlight,
+			Sidecars: []*model.RoleRunSidecar{{
+				Name:  "exporter",
+				Ports: []*model.RoleRunSidecarPort{{Name: "exporter", Port: 9100}},
+			}},
+		},
+	}
+	settings := ExportSettings{
+		CreateHelmChart: true,
+		RoleManifest:    &model.RoleManifest{InstanceGroups: model.InstanceGroups{instanceGroup}},
+	}
+
+	_, err := NewPrometheusMonitor(instanceGroup, ExportSettings{RoleManifest: settings.RoleManifest})
+	assert.Error(t, err, "Should require a helm chart")
+
+	monitor, err := NewPrometheusMonitor(instanceGroup, settings)
+	require.NoError(t, err)
+
+	enabled := map[string]interface{}{
+		"Values.config.prometheus.enabled": true,
+		"Values.enable.extra":              true,
+		"Capabilities.APIVersions":         &APIVersions{prometheusOperatorAPIVersion: true},
+	}
+
+	t.Run("Unmonitored", func(t *testing.T) {
+		t.Parallel()
+		monitor, err := NewPrometheusMonitor(&model.InstanceGroup{Name: "other-role"}, settings)
+		require.NoError(t, err)
+		assert.Nil(t, monitor)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(monitor, map[string]interface{}{})
+		require.NoError(t, err)
+		assert.Nil(t, actual)
+	})
+
+	t.Run("MissingAPI", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(monitor, map[string]interface{}{
+			"Values.config.prometheus.enabled": true,
+			"Values.enable.extra":              true,
+		})
+		require.NoError(t, err)
+		assert.Nil(t, actual)
+	})
+
+	t.Run("ServiceMonitor", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(monitor, enabled)
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			apiVersion: monitoring.coreos.com/v1
+			kind: ServiceMonitor
+			metadata:
+				name: main-role
+			spec:
+				selector:
+					matchLabels:
+						app.kubernetes.io/component: main-role-api
+				endpoints:
+				-	port: http-metrics
+					path: /metrics
+					interval: 30s
+		`, actual)
+	})
+
+	t.Run("PodMonitor", func(t *testing.T) {
+		t.Parallel()
+		sidecarMonitored := *instanceGroup
+		sidecarMonitored.Monitoring = &model.InstanceGroupMonitoring{Port: "exporter", Path: "/stats"}
+		monitor, err := NewPrometheusMonitor(&sidecarMonitored, settings)
+		require.NoError(t, err)
+		actual, err := RoundtripNode(monitor, enabled)
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			apiVersion: monitoring.coreos.com/v1
+			kind: PodMonitor
+			metadata:
+				name: main-role
+			spec:
+				selector:
+					matchLabels:
+						skiff-role-name: main-role
+				podMetricsEndpoints:
+				-	port: exporter
+					path: /stats
+		`, actual)
+	})
+}
@@ -0,0 +1,254 @@
+package kube
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// QuarksAPIVersion is the API version of the custom resources of the
+// cf-operator
+const QuarksAPIVersion = "quarks.cloudfoundry.org/v1alpha1"
+
+// BOSHDeploymentFile is the name of the file the BOSHDeployment is written to
+const BOSHDeploymentFile = "bosh-deployment.yaml"
+
+// Trigger strategies of QuarksJobs
+const (
+	QuarksJobStrategyManual = "manual"
+	QuarksJobStrategyOnce   = "once"
+)
+
+// NewQuarksStatefulSet wraps the stateful set of an instance group into a
+// QuarksStatefulSet, which restarts the pods when their configuration changes.
+func NewQuarksStatefulSet(instanceGroup *model.InstanceGroup, statefulSet helm.Node, settings ExportSettings) (helm.Node, error) {
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion(QuarksAPIVersion).
+		SetKind("QuarksStatefulSet").
+		SetName(instanceGroup.Name).
+		AddModifier(helm.Comment(instanceGroup.GetLongDescription()))
+	quarksStatefulSet, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+
+	spec := helm.NewMapping()
+	spec.Add("updateOnConfigChange", true)
+	spec.Add("template", statefulSet)
+	quarksStatefulSet.Add("spec", spec)
+
+	return quarksStatefulSet, nil
+}
+
+// NewQuarksJob wraps the job of a bosh-task instance group into a QuarksJob.
+// Manual tasks (i.e. errands) are only run when triggered; all others run
+// once, and again when their configuration changes.
+func NewQuarksJob(instanceGroup *model.InstanceGroup, job helm.Node, settings ExportSettings) (helm.Node, error) {
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion(QuarksAPIVersion).
+		SetKind("QuarksJob").
+		SetName(instanceGroup.Name).
+		AddModifier(helm.Comment(instanceGroup.GetLongDescription()))
+	quarksJob, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+
+	strategy := QuarksJobStrategyOnce
+	if instanceGroup.Run.FlightStage == model.FlightStageManual {
+		strategy = QuarksJobStrategyManual
+	}
+
+	spec := helm.NewMapping()
+	spec.Add("trigger", helm.NewMapping("strategy", strategy))
+	spec.Add("updateOnConfigChange", strategy == QuarksJobStrategyOnce)
+	spec.Add("template", helm.NewMapping("spec", job.Get("spec")))
+	quarksJob.Add("spec", spec)
+
+	return quarksJob, nil
+}
+
+// boshManifest is the BOSH deployment manifest of a BOSHDeployment
+type boshManifest struct {
+	Name           string                 `yaml:"name"`
+	Releases       []boshManifestRelease  `yaml:"releases"`
+	InstanceGroups []boshManifestGroup    `yaml:"instance_groups"`
+	Variables      []boshManifestVariable `yaml:"variables,omitempty"`
+}
+
+type boshManifestRelease struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+}
+
+type boshManifestGroup struct {
+	Name           string            `yaml:"name"`
+	Instances      int               `yaml:"instances"`
+	Lifecycle      string            `yaml:"lifecycle,omitempty"`
+	PersistentDisk int               `yaml:"persistent_disk,omitempty"`
+	Jobs           []boshManifestJob `yaml:"jobs"`
+}
+
+type boshManifestJob struct {
+	Name    string `yaml:"name"`
+	Release string `yaml:"release"`
+}
+
+type boshManifestVariable struct {
+	Name    string                 `yaml:"name"`
+	Type    string                 `yaml:"type"`
+	Options map[string]interface{} `yaml:"options,omitempty"`
+}
+
+// NewBOSHDeployment returns a BOSHDeployment of the role manifest for the
+// cf-operator, along with the config map holding its deployment manifest.
+// The deployment manifest has the releases, the instance groups with their
+// jobs, persistent disks and errands, and the generated variables; it has no
+// job properties, which are left to ops files.
+func NewBOSHDeployment(settings ExportSettings) ([]helm.Node, error) {
+	manifest, err := makeBOSHManifest(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestName := fmt.Sprintf("%s-manifest", settings.QuarksDeployment)
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("v1").
+		SetKind("ConfigMap").
+		SetName(manifestName)
+	configMap, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	configMap.Add("data", helm.NewMapping("manifest", manifest))
+
+	cb = NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion(QuarksAPIVersion).
+		SetKind("BOSHDeployment").
+		SetName(settings.QuarksDeployment)
+	deployment, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	spec := helm.NewMapping()
+	spec.Add("manifest", helm.NewMapping("name", manifestName, "type", "configmap"))
+	deployment.Add("spec", spec)
+
+	return []helm.Node{configMap, deployment}, nil
+}
+
+// makeBOSHManifest returns the BOSH deployment manifest of the role manifest.
+// The jobs of colocated containers are part of the instance groups using
+// them, as they share their pods.
+func makeBOSHManifest(settings ExportSettings) (string, error) {
+	manifest := boshManifest{Name: settings.QuarksDeployment}
+
+	for _, release := range settings.RoleManifest.LoadedReleases {
+		manifest.Releases = append(manifest.Releases, boshManifestRelease{
+			Name:    release.Name,
+			Version: release.Version,
+		})
+	}
+
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		if instanceGroup.IsColocated() {
+			continue
+		}
+		switch instanceGroup.Type {
+		case model.RoleTypeBosh, model.RoleTypeBoshTask:
+		default:
+			continue
+		}
+
+		group := boshManifestGroup{
+			Name:      instanceGroup.Name,
+			Instances: instanceGroup.Run.Scaling.Min,
+		}
+		if instanceGroup.Type == model.RoleTypeBoshTask {
+			group.Lifecycle = "errand"
+		}
+		for _, volume := range instanceGroup.Run.Volumes {
+			if volume.Type == model.VolumeTypePersistent {
+				// The size of the volumes is in GB, that of the disk in MB
+				group.PersistentDisk += volume.Size * 1024
+			}
+		}
+		for _, member := range append(model.InstanceGroups{instanceGroup}, instanceGroup.GetColocatedRoles()...) {
+			for _, jobReference := range member.JobReferences {
+				group.Jobs = append(group.Jobs, boshManifestJob{
+					Name:    jobReference.Name,
+					Release: jobReference.ReleaseName,
+				})
+			}
+		}
+		manifest.InstanceGroups = append(manifest.InstanceGroups, group)
+	}
+
+	var cas []string
+	for _, cv := range settings.RoleManifest.Variables {
+		if cv.Type == "certificate" && cv.CVOptions.IsCA {
+			cas = append(cas, cv.Name)
+		}
+	}
+	for _, cv := range settings.RoleManifest.Variables {
+		if cv.Type == "" {
+			continue
+		}
+		variable := boshManifestVariable{Name: cv.Name, Type: cv.Type}
+		if cv.Type == "certificate" {
+			options, err := boshCertificateOptions(cv, cas)
+			if err != nil {
+				return "", err
+			}
+			variable.Options = options
+		}
+		manifest.Variables = append(manifest.Variables, variable)
+	}
+
+	buf, err := yaml.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("Error marshalling the BOSH deployment manifest: %v", err)
+	}
+	return string(buf), nil
+}
+
+// boshCertificateOptions returns the options of the BOSH variable generating
+// the certificate; cas are the names of the CA certificates of the role
+// manifest. The common name is the service of the role_name option, which is
+// also an alternative name as clients ignore the common name; CAs without one
+// are named after their variable. Certificates other than CAs are signed by
+// the CA of the ca option, or the only CA.
+func boshCertificateOptions(cv *model.VariableDefinition, cas []string) (map[string]interface{}, error) {
+	options := map[string]interface{}{"common_name": cv.Name}
+	var altNames []string
+	if cv.CVOptions.RoleName != "" {
+		options["common_name"] = cv.CVOptions.RoleName
+		altNames = append(altNames, cv.CVOptions.RoleName)
+	}
+	altNames = append(altNames, cv.CVOptions.AltNames...)
+	if len(altNames) > 0 {
+		options["alternative_names"] = altNames
+	}
+	if cv.CVOptions.IsCA {
+		options["is_ca"] = true
+		if cv.CVOptions.CA != "" {
+			options["ca"] = cv.CVOptions.CA
+		}
+		return options, nil
+	}
+	switch {
+	case cv.CVOptions.CA != "":
+		options["ca"] = cv.CVOptions.CA
+	case len(cas) == 1:
+		options["ca"] = cas[0]
+	default:
+		return nil, fmt.Errorf("Certificate %s needs the ca option naming the CA signing it, the role manifest has %d CAs", cv.Name, len(cas))
+	}
+	return options, nil
+}
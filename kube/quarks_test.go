@@ -0,0 +1,193 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestNewQuarksStatefulSet(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	manifest, instanceGroup := statefulSetTestLoadManifest(assert, "quarks.yml")
+	if manifest == nil || instanceGroup == nil {
+		return
+	}
+
+	settings := ExportSettings{
+		RoleManifest:     manifest,
+		Opinions:         model.NewEmptyOpinions(),
+		QuarksDeployment: "cf",
+	}
+	statefulSet, _, err := NewStatefulSet(instanceGroup, settings, nil)
+	require.NoError(t, err)
+	quarksStatefulSet, err := NewQuarksStatefulSet(instanceGroup, statefulSet, settings)
+	require.NoError(t, err)
+
+	actual, err := RoundtripKube(quarksStatefulSet)
+	require.NoError(t, err)
+	yamltest.IsYAMLSubsetString(assert, `---
+		apiVersion: quarks.cloudfoundry.org/v1alpha1
+		kind: QuarksStatefulSet
+		metadata:
+			name: myrole
+		spec:
+			updateOnConfigChange: true
+			template:
+				kind: StatefulSet
+				metadata:
+					name: myrole
+				spec:
+					replicas: 2
+					volumeClaimTemplates:
+					-	metadata:
+							name: persistent-volume
+	`, actual)
+}
+
+func TestNewQuarksJob(t *testing.T) {
+	t.Parallel()
+
+	for _, sample := range []struct {
+		instanceGroup string
+		expected      string
+	}{
+		{"post-role", `---
+			spec:
+				trigger:
+					strategy: once
+				updateOnConfigChange: true
+				template:
+					spec:
+						template:
+							spec:
+								containers:
+								-	name: post-role
+								restartPolicy: OnFailure
+		`},
+		{"smoke-tests", `---
+			spec:
+				trigger:
+					strategy: manual
+				updateOnConfigChange: false
+				template:
+					spec:
+						template:
+							spec:
+								restartPolicy: Never
+		`},
+	} {
+		assert := assert.New(t)
+		instanceGroup := jobTestLoadRole(assert, sample.instanceGroup, "quarks.yml")
+		if instanceGroup == nil {
+			return
+		}
+
+		settings := ExportSettings{Opinions: model.NewEmptyOpinions(), QuarksDeployment: "cf"}
+		job, err := NewJob(instanceGroup, settings, nil)
+		require.NoError(t, err)
+		quarksJob, err := NewQuarksJob(instanceGroup, job, settings)
+		require.NoError(t, err)
+
+		actual, err := RoundtripKube(quarksJob)
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert, `---
+			apiVersion: quarks.cloudfoundry.org/v1alpha1
+			kind: QuarksJob
+			metadata:
+				name: `+sample.instanceGroup+`
+		`, actual)
+		yamltest.IsYAMLSubsetString(assert, sample.expected, actual)
+	}
+}
+
+func TestNewBOSHDeployment(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	manifest, _ := statefulSetTestLoadManifest(assert, "quarks.yml")
+	if manifest == nil {
+		return
+	}
+
+	nodes, err := NewBOSHDeployment(ExportSettings{RoleManifest: manifest, QuarksDeployment: "cf"})
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+
+	actual, err := RoundtripKube(nodes[1])
+	require.NoError(t, err)
+	yamltest.IsYAMLEqualString(assert, `---
+		apiVersion: quarks.cloudfoundry.org/v1alpha1
+		kind: BOSHDeployment
+		metadata:
+			name: cf
+			labels:
+				app.kubernetes.io/component: cf
+		spec:
+			manifest:
+				name: cf-manifest
+				type: configmap
+	`, actual)
+
+	actual, err = RoundtripKube(nodes[0])
+	require.NoError(t, err)
+	yamltest.IsYAMLSubsetString(assert, `---
+		apiVersion: v1
+		kind: ConfigMap
+		metadata:
+			name: cf-manifest
+	`, actual)
+	data := actual.(map[interface{}]interface{})["data"].(map[interface{}]interface{})
+	var boshManifest interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(data["manifest"].(string)), &boshManifest))
+	yamltest.IsYAMLEqualString(assert, `---
+		name: cf
+		releases:
+		-	name: tor
+			version: "0.3.5+dev.5"
+		instance_groups:
+		-	name: myrole
+			instances: 2
+			persistent_disk: 5120
+			jobs:
+			-	name: tor
+				release: tor
+		-	name: post-role
+			instances: 1
+			lifecycle: errand
+			jobs:
+			-	name: new_hostname
+				release: tor
+		-	name: smoke-tests
+			instances: 1
+			lifecycle: errand
+			jobs:
+			-	name: tor
+				release: tor
+		variables:
+		-	name: INTERNAL_CA_CERT
+			type: certificate
+			options:
+				common_name: INTERNAL_CA_CERT
+				is_ca: true
+		-	name: TOR_CERT
+			type: certificate
+			options:
+				common_name: myrole
+				alternative_names: [myrole, tor.example.com]
+				ca: INTERNAL_CA_CERT
+		-	name: TOR_PASSWORD
+			type: password
+	`, boshManifest)
+
+	manifest.Variables = append(manifest.Variables, &model.VariableDefinition{
+		Name:      "OTHER_CA_CERT",
+		Type:      "certificate",
+		CVOptions: model.CVOptions{IsCA: true},
+	})
+	_, err = NewBOSHDeployment(ExportSettings{RoleManifest: manifest, QuarksDeployment: "cf"})
+	assert.EqualError(err, "Certificate TOR_CERT needs the ca option naming the CA signing it, the role manifest has 2 CAs")
+}
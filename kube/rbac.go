@@ -7,6 +7,7 @@ import (
 
 	"code.cloudfoundry.org/fissile/helm"
 	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/util"
 )
 
 // RBACRoleKind enumerations are for NewRBACRole
@@ -60,6 +61,15 @@ func NewRBACAccount(accountName string, config *model.Configuration, settings Ex
 		if err != nil {
 			return nil, fmt.Errorf("failed to build a new kube config: %v", err)
 		}
+		if settings.CreateHelmChart {
+			// Annotations binding the account to a cloud identity, e.g. for
+			// EKS IAM roles for service accounts or GKE workload identity
+			values := fmt.Sprintf("index .Values.kube.service_account_annotations %q", accountName)
+			annotations := helm.NewMapping()
+			annotations.Add("{{ $key }}", "{{ $value | quote }}", helm.Block(fmt.Sprintf("range $key, $value := %s", values)))
+			annotations.Set(helm.Block(fmt.Sprintf("if %s", values)))
+			serviceAccount.Get("metadata").(*helm.Mapping).Add("annotations", annotations)
+		}
 		resources = append(resources, serviceAccount)
 	}
 
@@ -98,12 +108,12 @@ func NewRBACAccount(accountName string, config *model.Configuration, settings Ex
 		}
 		subjects := helm.NewList(helm.NewMapping(
 			"kind", "ServiceAccount",
-			"name", accountName))
+			"name", serviceAccountName(accountName, settings)))
 		binding.Add("subjects", subjects)
 		binding.Add("roleRef", helm.NewMapping(
 			"apiGroup", "rbac.authorization.k8s.io",
 			"kind", "Role",
-			"name", roleName))
+			"name", resourceName(roleName, settings)))
 		resources = append(resources, binding)
 	}
 
@@ -143,10 +153,10 @@ func NewRBACAccount(accountName string, config *model.Configuration, settings Ex
 				accountName,
 				clusterRoleName)))
 		if settings.CreateHelmChart {
-			cb.SetNameHelmExpression(
-				fmt.Sprintf(`{{ template "fissile.SanitizeName" (printf "%%s-%s-%s-cluster-binding" .Release.Namespace) }}`,
+			cb.SetNameHelmExpression(resourceNameExpression(
+				fmt.Sprintf(`(printf "%%s-%s-%s-cluster-binding" $.Release.Namespace)`,
 					accountName,
-					clusterRoleName))
+					clusterRoleName)))
 		} else {
 			cb.SetName(fmt.Sprintf("%s-%s-cluster-binding", accountName, clusterRoleName))
 		}
@@ -156,14 +166,14 @@ func NewRBACAccount(accountName string, config *model.Configuration, settings Ex
 		}
 		subjects := helm.NewList(helm.NewMapping(
 			"kind", "ServiceAccount",
-			"name", accountName,
+			"name", serviceAccountName(accountName, settings),
 			"namespace", namespace))
 		binding.Add("subjects", subjects)
 		roleRef := helm.NewMapping(
 			"kind", "ClusterRole",
 			"apiGroup", "rbac.authorization.k8s.io")
 		if settings.CreateHelmChart {
-			roleRef.Add("name", fmt.Sprintf(`{{ template "fissile.SanitizeName" (printf "%%s-cluster-role-%s" .Release.Namespace) }}`, clusterRoleName))
+			roleRef.Add("name", clusterRoleNameExpression(clusterRoleName))
 		} else {
 			roleRef.Add("name", clusterRoleName)
 		}
@@ -189,7 +199,7 @@ func NewRBACRole(name string, kind RBACRoleKind, authRole model.AuthRole, settin
 					// When creating helm charts for PSPs, let the user override it
 					resourceNames.Add(fmt.Sprintf(
 						`{{ if .Values.kube.psp.%[1]s }}{{ .Values.kube.psp.%[1]s }}{{ else }}`+
-							`{{ template "fissile.SanitizeName" (printf "%%s-psp-%[1]s" .Release.Namespace) }}{{ end }}`, resourceName))
+							`%[2]s{{ end }}`, resourceName, pspNameExpression(resourceName)))
 				} else {
 					resourceNames.Add(resourceName)
 				}
@@ -205,7 +215,7 @@ func NewRBACRole(name string, kind RBACRoleKind, authRole model.AuthRole, settin
 		SetKind(string(kind)).
 		AddModifier(authModeRBAC(settings))
 	if kind == RBACRoleKindClusterRole && settings.CreateHelmChart {
-		cb.SetNameHelmExpression(fmt.Sprintf(`{{ template "fissile.SanitizeName" (printf "%%s-cluster-role-%s" .Release.Namespace) }}`, name))
+		cb.SetNameHelmExpression(clusterRoleNameExpression(name))
 	} else {
 		cb.SetName(name)
 	}
@@ -214,10 +224,28 @@ func NewRBACRole(name string, kind RBACRoleKind, authRole model.AuthRole, settin
 		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
 	}
 	role.Add("rules", rules)
+	if kind == RBACRoleKindClusterRole {
+		addRBACAggregationLabels(role, name, settings)
+	}
 
 	return role.Sort(), nil
 }
 
+// addRBACAggregationLabels labels a cluster role to have its rules aggregated
+// into the builtin cluster roles listed in the authorization config, so that
+// e.g. users with the "view" role can see the resources it grants access to
+func addRBACAggregationLabels(role *helm.Mapping, name string, settings ExportSettings) {
+	if settings.RoleManifest == nil || settings.RoleManifest.Configuration == nil {
+		return
+	}
+	targets := append([]string{}, settings.RoleManifest.Configuration.Authorization.AggregateTo[name]...)
+	sort.Strings(targets)
+	labels := role.Get("metadata", "labels").(*helm.Mapping)
+	for _, target := range targets {
+		labels.Add(fmt.Sprintf("rbac.authorization.k8s.io/aggregate-to-%s", target), "true")
+	}
+}
+
 // NewRBACPSP creates a (Kubernetes RBAC) pod security policy
 func NewRBACPSP(name string, psp *model.PodSecurityPolicy, settings ExportSettings) (helm.Node, error) {
 	cb := NewConfigBuilder().
@@ -228,7 +256,7 @@ func NewRBACPSP(name string, psp *model.PodSecurityPolicy, settings ExportSettin
 	if settings.CreateHelmChart {
 		cb.AddModifier(helm.Block(fmt.Sprintf(`if and (%s) (not .Values.kube.psp.%s)`,
 			`eq (printf "%s" .Values.kube.auth) "rbac"`, name)))
-		cb.SetNameHelmExpression(fmt.Sprintf(`{{ template "fissile.SanitizeName" (printf "%%s-psp-%s" .Release.Namespace) }}`, name))
+		cb.SetNameHelmExpression(pspNameExpression(name))
 	} else {
 		cb.SetName(name)
 	}
@@ -236,10 +264,75 @@ func NewRBACPSP(name string, psp *model.PodSecurityPolicy, settings ExportSettin
 	if err != nil {
 		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
 	}
-	node.Add("spec", helm.NewNode(psp.Definition))
+	spec := helm.NewNode(psp.Definition)
+	if mapping, ok := spec.(*helm.Mapping); ok {
+		addPSPUnsafeSysctls(mapping, name, psp, settings)
+	}
+	node.Add("spec", spec)
 	return node, nil
 }
 
+// addPSPUnsafeSysctls adds the unsafe sysctls of the instance groups using the
+// pod security policy to its allowed unsafe sysctls, as pods with sysctls not
+// allowed by their policy are rejected.
+func addPSPUnsafeSysctls(spec *helm.Mapping, name string, psp *model.PodSecurityPolicy, settings ExportSettings) {
+	if settings.RoleManifest == nil || settings.RoleManifest.Configuration == nil {
+		return
+	}
+
+	var allowed []string
+	if definition, ok := psp.Definition.(map[interface{}]interface{}); ok {
+		if sysctls, ok := definition["allowedUnsafeSysctls"].([]interface{}); ok {
+			for _, sysctl := range sysctls {
+				allowed = append(allowed, fmt.Sprintf("%v", sysctl))
+			}
+		}
+	}
+	if util.StringInSlice("*", allowed) {
+		return
+	}
+
+	auth := settings.RoleManifest.Configuration.Authorization
+	var users []string
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		if instanceGroup.Run == nil || instanceGroup.IsColocated() {
+			continue
+		}
+		if !util.StringInSlice(name, auth.AccountPodSecurityPolicies(instanceGroup.Run.ServiceAccount)) {
+			continue
+		}
+		unsafe := instanceGroup.UnsafeSysctls()
+		for _, sysctl := range unsafe {
+			if !util.StringInSlice(sysctl, allowed) {
+				allowed = append(allowed, sysctl)
+			}
+		}
+		if len(unsafe) > 0 {
+			users = append(users, instanceGroup.Name)
+		}
+	}
+	if len(users) == 0 {
+		return
+	}
+
+	sort.Strings(allowed)
+	spec.Add("allowedUnsafeSysctls", allowed,
+		helm.Comment(fmt.Sprintf("Unsafe sysctls required by instance groups %s", strings.Join(users, ", "))))
+	spec.Sort()
+}
+
+// clusterRoleNameExpression returns the name of a cluster role of a helm
+// chart; cluster roles are not namespaced, so the name includes the namespace
+func clusterRoleNameExpression(name string) string {
+	return resourceNameExpression(fmt.Sprintf(`(printf "%%s-cluster-role-%s" $.Release.Namespace)`, name))
+}
+
+// pspNameExpression returns the name of a pod security policy of a helm
+// chart; like cluster roles, it includes the namespace
+func pspNameExpression(name string) string {
+	return resourceNameExpression(fmt.Sprintf(`(printf "%%s-psp-%s" $.Release.Namespace)`, name))
+}
+
 // authModeRBAC returns a block condition checking for RBAC
 func authModeRBAC(settings ExportSettings) helm.NodeModifier {
 	if settings.CreateHelmChart {
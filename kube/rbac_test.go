@@ -4,7 +4,7 @@ import (
 	"testing"
 
 	"code.cloudfoundry.org/fissile/model"
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -35,7 +35,7 @@ func TestNewRBACAccountPSPKube(t *testing.T) {
 	if assert.NotNil(t, account, "service account not found") {
 		actualAccount, err := RoundtripKube(account)
 		if assert.NoError(t, err) {
-			testhelpers.IsYAMLEqualString(assert.New(t), `---
+			yamltest.IsYAMLEqualString(assert.New(t), `---
 			apiVersion: "v1"
 			kind: "ServiceAccount"
 			metadata:
@@ -50,7 +50,7 @@ func TestNewRBACAccountPSPKube(t *testing.T) {
 	if assert.NotNil(t, roleBinding, "role binding not found") {
 		actualRole, err := RoundtripKube(roleBinding)
 		if assert.NoError(t, err) {
-			testhelpers.IsYAMLEqualString(assert.New(t), `---
+			yamltest.IsYAMLEqualString(assert.New(t), `---
 				apiVersion: "rbac.authorization.k8s.io/v1"
 				kind: "RoleBinding"
 				metadata:
@@ -72,7 +72,7 @@ func TestNewRBACAccountPSPKube(t *testing.T) {
 	if assert.NotNil(t, role, "role not found") {
 		actualRole, err := RoundtripKube(role)
 		if assert.NoError(t, err) {
-			testhelpers.IsYAMLEqualString(assert.New(t), `---
+			yamltest.IsYAMLEqualString(assert.New(t), `---
 				apiVersion: rbac.authorization.k8s.io/v1
 				kind: Role
 				metadata:
@@ -88,7 +88,7 @@ func TestNewRBACAccountPSPKube(t *testing.T) {
 	if assert.NotNil(t, clusterRoleBinding, "cluster role binding not found") {
 		actualBinding, err := RoundtripKube(clusterRoleBinding)
 		if assert.NoError(t, err) {
-			testhelpers.IsYAMLEqualString(assert.New(t), `---
+			yamltest.IsYAMLEqualString(assert.New(t), `---
 			apiVersion: "rbac.authorization.k8s.io/v1"
 			kind: "ClusterRoleBinding"
 			metadata:
@@ -111,7 +111,7 @@ func TestNewRBACAccountPSPKube(t *testing.T) {
 	if assert.NotNil(t, clusterRole, "cluster role not found") {
 		actualClusterRole, err := RoundtripKube(clusterRole)
 		if assert.NoError(t, err) {
-			testhelpers.IsYAMLEqualString(assert.New(t), `---
+			yamltest.IsYAMLEqualString(assert.New(t), `---
 				apiVersion: rbac.authorization.k8s.io/v1
 				kind: ClusterRole
 				metadata:
@@ -179,34 +179,93 @@ func TestNewRBACAccountHelm(t *testing.T) {
 		}
 		actualAccount, err := RoundtripNode(account, config)
 		if assert.NoError(t, err) {
-			testhelpers.IsYAMLEqualString(assert.New(t), `---
+			yamltest.IsYAMLEqualString(assert.New(t), `---
 			`, actualAccount)
 		}
 
 		actualRoleBinding, err := RoundtripNode(roleBinding, config)
 		if assert.NoError(t, err) {
-			testhelpers.IsYAMLEqualString(assert.New(t), `---
+			yamltest.IsYAMLEqualString(assert.New(t), `---
 			`, actualRoleBinding)
 		}
 
 		actualRole, err := RoundtripNode(role, config)
 		if assert.NoError(t, err) {
-			testhelpers.IsYAMLEqualString(assert.New(t), `---
+			yamltest.IsYAMLEqualString(assert.New(t), `---
 			`, actualRole)
 		}
 
 		actualClusterRoleBinding, err := RoundtripNode(clusterRoleBinding, config)
 		if assert.NoError(t, err) {
-			testhelpers.IsYAMLEqualString(assert.New(t), `---
+			yamltest.IsYAMLEqualString(assert.New(t), `---
 			`, actualClusterRoleBinding)
 		}
 
 		actualClusterRole, err := RoundtripNode(clusterRole, config)
 		if assert.NoError(t, err) {
-			testhelpers.IsYAMLEqualString(assert.New(t), `---
+			yamltest.IsYAMLEqualString(assert.New(t), `---
 			`, actualClusterRole)
 		}
 	})
+
+	t.Run("NamePrefix", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.kube.auth":           "rbac",
+			"Values.name_prefix.enabled": true,
+			"Release.Namespace":          "namespace",
+		}
+		actualRoleBinding, err := RoundtripNode(roleBinding, config)
+		if assert.NoError(t, err) {
+			yamltest.IsYAMLSubsetString(assert.New(t), `---
+				metadata:
+					name: MyRelease-the-name-a-role-binding
+				subjects:
+				-	kind: ServiceAccount
+					name: MyRelease-the-name
+				roleRef:
+					kind: Role
+					name: MyRelease-a-role
+			`, actualRoleBinding)
+		}
+
+		actualClusterRoleBinding, err := RoundtripNode(clusterRoleBinding, config)
+		if assert.NoError(t, err) {
+			yamltest.IsYAMLSubsetString(assert.New(t), `---
+				metadata:
+					name: MyRelease-namespace-the-name-nonprivileged-cluster-binding
+				roleRef:
+					kind: ClusterRole
+					name: MyRelease-namespace-cluster-role-nonprivileged
+			`, actualClusterRoleBinding)
+		}
+	})
+
+	t.Run("Annotations", func(t *testing.T) {
+		t.Parallel()
+		actualAccount, err := RoundtripNode(account, map[string]interface{}{
+			"Values.kube.auth": "rbac",
+		})
+		if assert.NoError(t, err) {
+			assert.NotContains(t, actualAccount.(map[interface{}]interface{})["metadata"], "annotations")
+		}
+
+		actualAccount, err = RoundtripNode(account, map[string]interface{}{
+			"Values.kube.auth": "rbac",
+			"Values.kube.service_account_annotations": map[string]interface{}{
+				"the-name": map[string]interface{}{
+					"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:role/the-role",
+				},
+			},
+		})
+		if assert.NoError(t, err) {
+			yamltest.IsYAMLSubsetString(assert.New(t), `---
+				metadata:
+					annotations:
+						eks.amazonaws.com/role-arn: arn:aws:iam::123456789012:role/the-role
+			`, actualAccount)
+		}
+	})
 }
 
 func TestNewRBACRoleKube(t *testing.T) {
@@ -227,7 +286,7 @@ func TestNewRBACRoleKube(t *testing.T) {
 
 	actual, err := RoundtripKube(rbacRole)
 	require.NoError(t, err)
-	testhelpers.IsYAMLEqualString(assert.New(t), `---
+	yamltest.IsYAMLEqualString(assert.New(t), `---
 		apiVersion: "rbac.authorization.k8s.io/v1"
 		kind: "Role"
 		metadata:
@@ -271,7 +330,7 @@ func TestNewRBACRoleHelm(t *testing.T) {
 		actual, err := RoundtripNode(rbacRole, config)
 		require.NoError(t, err)
 
-		testhelpers.IsYAMLEqualString(assert.New(t), `---
+		yamltest.IsYAMLEqualString(assert.New(t), `---
 		`, actual)
 	})
 
@@ -284,7 +343,7 @@ func TestNewRBACRoleHelm(t *testing.T) {
 		actual, err := RoundtripNode(rbacRole, config)
 		require.NoError(t, err)
 
-		testhelpers.IsYAMLEqualString(assert.New(t), `---
+		yamltest.IsYAMLEqualString(assert.New(t), `---
 			apiVersion: "rbac.authorization.k8s.io/v1"
 			kind: "Role"
 			metadata:
@@ -292,7 +351,7 @@ func TestNewRBACRoleHelm(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: the-name
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -324,7 +383,7 @@ func TestNewRBACClusterRolePSPKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "rbac.authorization.k8s.io/v1"
 		kind: "ClusterRole"
 		metadata:
@@ -364,7 +423,7 @@ func TestNewRBACClusterRolePSPHelm(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "rbac.authorization.k8s.io/v1"
 		kind: "ClusterRole"
 		metadata:
@@ -372,7 +431,7 @@ func TestNewRBACClusterRolePSPHelm(t *testing.T) {
 			labels:
 				app.kubernetes.io/component: namespace-psp-role-the_name
 				app.kubernetes.io/instance: MyRelease
-				app.kubernetes.io/managed-by: Tiller
+				app.kubernetes.io/managed-by: fissile
 				app.kubernetes.io/name: MyChart
 				app.kubernetes.io/version: 1.22.333.4444
 				helm.sh/chart: MyChart-42.1_foo
@@ -389,3 +448,110 @@ func TestNewRBACClusterRolePSPHelm(t *testing.T) {
 	`, actualCR)
 }
 */
+
+func TestNewRBACClusterRoleAggregation(t *testing.T) {
+	t.Parallel()
+
+	settings := ExportSettings{
+		RoleManifest: &model.RoleManifest{
+			Configuration: &model.Configuration{
+				Authorization: model.ConfigurationAuthorization{
+					AggregateTo: map[string][]string{
+						"the-name": {"view", "edit"},
+					},
+				},
+			},
+		},
+	}
+	rbacRole, err := NewRBACRole("the-name", RBACRoleKindClusterRole, model.AuthRole{}, settings)
+	require.NoError(t, err)
+
+	actual, err := RoundtripKube(rbacRole)
+	require.NoError(t, err)
+	yamltest.IsYAMLSubsetString(assert.New(t), `---
+		kind: "ClusterRole"
+		metadata:
+			labels:
+				app.kubernetes.io/component: the-name
+				rbac.authorization.k8s.io/aggregate-to-edit: "true"
+				rbac.authorization.k8s.io/aggregate-to-view: "true"
+	`, actual)
+
+	// Namespaced roles cannot be aggregated
+	rbacRole, err = NewRBACRole("the-name", RBACRoleKindRole, model.AuthRole{}, settings)
+	require.NoError(t, err)
+	actual, err = RoundtripKube(rbacRole)
+	require.NoError(t, err)
+	labels := actual.(map[interface{}]interface{})["metadata"].(map[interface{}]interface{})["labels"]
+	assert.NotContains(t, labels, "rbac.authorization.k8s.io/aggregate-to-view")
+}
+
+func TestNewRBACPSPUnsafeSysctls(t *testing.T) {
+	t.Parallel()
+
+	pspRule := model.AuthRule{
+		APIGroups:     []string{"policy"},
+		Resources:     []string{"podsecuritypolicies"},
+		ResourceNames: []string{"restricted"},
+		Verbs:         []string{"use"},
+	}
+	settings := ExportSettings{
+		RoleManifest: &model.RoleManifest{
+			InstanceGroups: model.InstanceGroups{
+				{
+					Name: "router",
+					Run: &model.RoleRun{
+						ServiceAccount: "router",
+						Sysctls: []*model.RoleRunSysctl{
+							{Name: "net.core.somaxconn", Value: "1024"},
+							{Name: "net.ipv4.tcp_syncookies", Value: "1"},
+						},
+					},
+				},
+				{
+					Name: "api",
+					Run: &model.RoleRun{
+						ServiceAccount: "default",
+						Sysctls:        []*model.RoleRunSysctl{{Name: "net.ipv4.tcp_fin_timeout", Value: "30"}},
+					},
+				},
+			},
+			Configuration: &model.Configuration{
+				Authorization: model.ConfigurationAuthorization{
+					Accounts: map[string]model.AuthAccount{
+						"router":  {ClusterRoles: []string{"psp-restricted"}},
+						"default": {},
+					},
+					ClusterRoles: map[string]model.AuthRole{
+						"psp-restricted": {pspRule},
+					},
+				},
+			},
+		},
+	}
+
+	psp := &model.PodSecurityPolicy{Definition: map[interface{}]interface{}{
+		"allowedUnsafeSysctls": []interface{}{"kernel.msgmax"},
+		"privileged":           false,
+	}}
+	node, err := NewRBACPSP("restricted", psp, settings)
+	require.NoError(t, err)
+	actual, err := RoundtripKube(node)
+	require.NoError(t, err)
+	yamltest.IsYAMLSubsetString(assert.New(t), `---
+		kind: "PodSecurityPolicy"
+		spec:
+			allowedUnsafeSysctls:
+			-	"kernel.msgmax"
+			-	"net.core.somaxconn"
+			privileged: false
+	`, actual)
+
+	// Policies not used by instance groups with unsafe sysctls are unchanged
+	node, err = NewRBACPSP("other", psp, settings)
+	require.NoError(t, err)
+	actual, err = RoundtripKube(node)
+	require.NoError(t, err)
+	spec := actual.(map[interface{}]interface{})["spec"].(map[interface{}]interface{})
+	assert.Equal(t, []interface{}{"kernel.msgmax"}, spec["allowedUnsafeSysctls"])
+}
@@ -6,6 +6,10 @@ import (
 	"code.cloudfoundry.org/fissile/helm"
 )
 
+// registryCredentialsName is the name of the secret with the credentials of
+// the docker registry
+const registryCredentialsName = "registry-credentials"
+
 // MakeRegistryCredentials generates a template that contains Docker Registry credentials
 func MakeRegistryCredentials(settings ExportSettings) (helm.Node, error) {
 
@@ -36,7 +40,7 @@ func MakeRegistryCredentials(settings ExportSettings) (helm.Node, error) {
 		SetSettings(&settings).
 		SetAPIVersion("v1").
 		SetKind("Secret").
-		SetName("registry-credentials")
+		SetName(registryCredentialsName)
 	if settings.CreateHelmChart {
 		cb.AddModifier(helm.Block(`if ne .Values.kube.registry.username ""`))
 	}
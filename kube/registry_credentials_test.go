@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"testing"
 
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,7 +22,7 @@ func TestMakeRegistryCredentialsKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "v1"
 		data:
 			.dockercfg: ""
@@ -70,7 +70,7 @@ func TestMakeRegistryCredentialsHelm(t *testing.T) {
 		return
 	}
 
-	testhelpers.IsYAMLEqualString(assert, fmt.Sprintf(`---
+	yamltest.IsYAMLEqualString(assert, fmt.Sprintf(`---
 		apiVersion: "v1"
 		data:
 			.dockercfg: %s
@@ -80,7 +80,7 @@ func TestMakeRegistryCredentialsHelm(t *testing.T) {
 			labels:
 				app.kubernetes.io/component: registry-credentials
 				app.kubernetes.io/instance: MyRelease
-				app.kubernetes.io/managed-by: Tiller
+				app.kubernetes.io/managed-by: fissile
 				app.kubernetes.io/name: MyChart
 				app.kubernetes.io/version: 42.1+foo
 				helm.sh/chart: MyChart-42.1_foo
@@ -0,0 +1,395 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"github.com/Masterminds/sprig"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RenderOptions describes how the helm templates are interpolated when
+// writing plain Kubernetes configuration files with the values already
+// applied. When set in the ExportSettings, the templates of the helm chart are
+// generated and rendered instead of being written as they are.
+type RenderOptions struct {
+	// Values override the default values of the chart
+	Values map[string]interface{}
+	// Environ holds the environment variables (as NAME=value) applied on top
+	// of the values by ValuesFromEnv, if any
+	Environ      []string
+	ReleaseName  string
+	Namespace    string
+	ChartName    string
+	ChartVersion string
+	// ObjectSizeWarning and ObjectSizeLimit are the sizes in bytes above
+	// which rendered objects are warned about, or fail the generation; 0
+	// disables the check
+	ObjectSizeWarning int
+	ObjectSizeLimit   int
+	// KubeVersion is the version ("1.16") of the cluster the templates are
+	// rendered for, i.e. `.Capabilities.KubeVersion`; defaults to
+	// DefaultRenderKubeVersion
+	KubeVersion string
+	// APIVersions are the API versions ("batch/v1") enabled on the cluster,
+	// as checked by `.Capabilities.APIVersions.Has`; defaults to
+	// DefaultRenderAPIVersions
+	APIVersions []string
+}
+
+// DefaultRenderKubeVersion is the cluster version templates are rendered for,
+// unless the render options set one
+const DefaultRenderKubeVersion = "1.16"
+
+// DefaultRenderAPIVersions are the API versions of a plain cluster of the
+// DefaultRenderKubeVersion. Extensions like the Prometheus operator or the
+// vertical pod autoscaler are not included; their resources are only
+// rendered if the render options list their API versions.
+var DefaultRenderAPIVersions = []string{
+	"v1",
+	"apps/v1",
+	"autoscaling/v1",
+	"autoscaling/v2beta2",
+	"batch/v1",
+	"batch/v1beta1",
+	"networking.k8s.io/v1",
+	"networking.k8s.io/v1beta1",
+	"policy/v1beta1",
+	"rbac.authorization.k8s.io/v1",
+	"scheduling.k8s.io/v1",
+	"storage.k8s.io/v1",
+}
+
+// renderKubeVersionPattern matches the cluster versions of the render
+// options; as on GKE, the minor version may have a "+" suffix
+var renderKubeVersionPattern = regexp.MustCompile(`^(\d+)\.(\d+\+?)$`)
+
+// renderReleaseService is the service managing the release of the rendered
+// templates, i.e. `.Release.Service`
+const renderReleaseService = "fissile"
+
+// Renderer interpolates the templates of a helm chart with a set of values.
+// It implements the subset of the helm template engine used by the templates
+// fissile generates, so that no helm installation is required.
+type Renderer struct {
+	context   map[string]interface{}
+	template  *template.Template
+	overrides []ValuesOverride
+	// objectSizeWarning and objectSizeLimit are the thresholds of the
+	// render options
+	objectSizeWarning int
+	objectSizeLimit   int
+}
+
+// NewRenderer returns a renderer for the templates generated with the given
+// settings, using the default values of the chart overridden by the values
+// of the render options.
+func NewRenderer(settings ExportSettings) (*Renderer, error) {
+	if settings.Render == nil {
+		return nil, fmt.Errorf("Rendering requires render options")
+	}
+	values, err := ValuesFromNode(MakeValues(settings))
+	if err != nil {
+		return nil, err
+	}
+	values = MergeValues(values, settings.Render.Values)
+	overlay, overrides, err := ValuesFromEnv(settings.Render.Environ, values)
+	if err != nil {
+		return nil, err
+	}
+	values = MergeValues(values, overlay)
+
+	kubeVersion := settings.Render.KubeVersion
+	if kubeVersion == "" {
+		kubeVersion = DefaultRenderKubeVersion
+	}
+	version := renderKubeVersionPattern.FindStringSubmatch(kubeVersion)
+	if version == nil {
+		return nil, fmt.Errorf("Invalid kube version %s to render for, expected <major>.<minor>", kubeVersion)
+	}
+	enabled := settings.Render.APIVersions
+	if len(enabled) == 0 {
+		enabled = DefaultRenderAPIVersions
+	}
+	apiVersions := APIVersions{}
+	for _, apiVersion := range enabled {
+		apiVersions[apiVersion] = true
+	}
+
+	r := &Renderer{
+		overrides:         overrides,
+		objectSizeWarning: settings.Render.ObjectSizeWarning,
+		objectSizeLimit:   settings.Render.ObjectSizeLimit,
+		context: map[string]interface{}{
+			"Values": values,
+			"Capabilities": map[string]interface{}{
+				"KubeVersion": map[string]interface{}{
+					"Major": version[1],
+					"Minor": version[2],
+				},
+				"APIVersions": &apiVersions,
+			},
+			"Template": map[string]interface{}{
+				"BasePath": "templates",
+			},
+			"Chart": map[string]interface{}{
+				"Name":    settings.Render.ChartName,
+				"Version": settings.Render.ChartVersion,
+			},
+			"Release": map[string]interface{}{
+				"Name":      settings.Render.ReleaseName,
+				"Namespace": settings.Render.Namespace,
+				"Service":   renderReleaseService,
+				"IsInstall": true,
+				"Revision":  1,
+			},
+		},
+	}
+
+	functions := RenderFuncMap()
+	functions["include"] = r.include
+	r.template = template.New("").Option("missingkey=zero").Funcs(functions)
+
+	var source bytes.Buffer
+	helpers := append(GetHelmTemplateHelpers(), GetHelmExtensionHelpers(settings.ExtensionSnippets)...)
+	for _, helper := range helpers {
+		if err := helm.NewEncoder(&source).Encode(helper); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := r.template.Parse(source.String()); err != nil {
+		return nil, fmt.Errorf("Error parsing the template helpers: %v", err)
+	}
+	if _, err := r.template.Parse(MakeExtensionSnippetsTemplate(settings.ExtensionSnippets)); err != nil {
+		return nil, fmt.Errorf("Error parsing the extension snippets: %v", err)
+	}
+	return r, nil
+}
+
+// EnvOverrides returns the values set from the environment variables of the
+// render options
+func (r *Renderer) EnvOverrides() []ValuesOverride {
+	return r.overrides
+}
+
+// Render interpolates the nodes of the named template file, e.g.
+// "templates/secrets.yaml". The file remains available to later templates
+// including it.
+func (r *Renderer) Render(name string, nodes ...helm.Node) ([]byte, error) {
+	var source bytes.Buffer
+	if err := helm.NewEncoder(&source, helm.EmptyLines(true)).EncodeAll(nodes...); err != nil {
+		return nil, err
+	}
+	if _, err := r.template.New(name).Parse(source.String()); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", name, err)
+	}
+
+	var output bytes.Buffer
+	if err := r.template.ExecuteTemplate(&output, name, r.context); err != nil {
+		return nil, fmt.Errorf("Error rendering %s: %v", name, err)
+	}
+	return output.Bytes(), nil
+}
+
+// CheckObjectSizes returns warnings for the rendered objects above the
+// warning threshold of the render options, and an error if any is above the
+// limit
+func (r *Renderer) CheckObjectSizes(output []byte) ([]string, error) {
+	return CheckObjectSizes(output, r.objectSizeWarning, r.objectSizeLimit)
+}
+
+// include executes a named template, and returns the result as a string
+func (r *Renderer) include(name string, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := r.template.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// IsEmptyRender returns true if rendered output contains no resources, i.e.
+// only document separators and whitespace.
+func IsEmptyRender(output []byte) bool {
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && line != "---" && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
+
+// RenderFuncMap returns the functions available to fissile-generated
+// templates. While most of them come from sprig, some are implemented by helm
+// itself; `include` needs access to the templates and is left to the caller.
+func RenderFuncMap() template.FuncMap {
+	functions := sprig.TxtFuncMap()
+	functions["required"] = renderRequired
+	functions["toYaml"] = renderToYaml
+	functions["fromYaml"] = renderFromYaml
+	return functions
+}
+
+func renderRequired(msg string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return v, fmt.Errorf("%s", msg)
+	} else if _, ok := v.(string); ok {
+		if v == "" {
+			return v, fmt.Errorf("%s", msg)
+		}
+	}
+	return v, nil
+}
+
+func renderToYaml(data interface{}) (string, error) {
+	yml, err := yaml.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(yml)), nil
+}
+
+// renderFromYaml parses a YAML mapping. As in helm, errors are reported in
+// the "Error" key of the result instead of failing the template, and nested
+// mappings have string keys so they can be passed to toJson.
+func renderFromYaml(str string) map[string]interface{} {
+	var data map[string]interface{}
+	if err := yaml.Unmarshal([]byte(str), &data); err != nil {
+		return map[string]interface{}{"Error": err.Error()}
+	}
+	for key, value := range data {
+		data[key] = toJSONValue(value)
+	}
+	return data
+}
+
+// toJSONValue converts the mappings in a value read from YAML, recursively,
+// to have string keys
+func toJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		for i, item := range v {
+			v[i] = toJSONValue(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprintf("%v", key)] = toJSONValue(item)
+		}
+		return result
+	}
+	return value
+}
+
+// ValuesFromNode converts a helm node, e.g. the result of MakeValues, into
+// plain values as used by the templates.
+func ValuesFromNode(node helm.Node) (map[string]interface{}, error) {
+	var convertNode func(node helm.Node, path []string) (interface{}, error)
+	convertNode = func(node helm.Node, path []string) (interface{}, error) {
+		switch n := node.(type) {
+		case *helm.Scalar:
+			var v interface{}
+			buffer := &bytes.Buffer{}
+			err := helm.NewEncoder(buffer).Encode(n)
+			if err != nil {
+				return nil, fmt.Errorf("Error encoding node at %s: %s", strings.Join(path, "."), err)
+			}
+			err = yaml.Unmarshal(buffer.Bytes(), &v)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing node at %s: %s", strings.Join(path, "."), err)
+			}
+			return v, nil
+		case *helm.List:
+			var values []interface{}
+			for i, v := range n.Values() {
+				converted, err := convertNode(v, append(path, fmt.Sprintf("%d", i)))
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, converted)
+			}
+			return values, nil
+		case *helm.Mapping:
+			values := make(map[string]interface{}, len(n.Names()))
+			for _, k := range n.Names() {
+				converted, err := convertNode(n.Get(k), append(path, k))
+				if err != nil {
+					return nil, err
+				}
+				values[k] = converted
+			}
+			return values, nil
+		default:
+			return nil, fmt.Errorf("Invalid node type at %s", strings.Join(path, "."))
+		}
+	}
+
+	converted, err := convertNode(node, nil)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := converted.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Values must be a mapping")
+	}
+	return values, nil
+}
+
+// ReadValuesFile returns the values of a helm values file
+func ReadValuesFile(path string) (map[string]interface{}, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(contents, &values); err != nil {
+		return nil, fmt.Errorf("Error parsing values file %s: %v", path, err)
+	}
+	return values, nil
+}
+
+// MergeValues merges the overrides into the values the way helm coalesces a
+// values file with the chart defaults: mappings are merged recursively, and
+// all other values are replaced. A nil override deletes the default.
+func MergeValues(values, overrides map[string]interface{}) map[string]interface{} {
+	for key, override := range overrides {
+		if override == nil {
+			delete(values, key)
+			continue
+		}
+		if overrideMap, ok := toStringMap(override); ok {
+			if valueMap, ok := toStringMap(values[key]); ok {
+				values[key] = MergeValues(valueMap, overrideMap)
+				continue
+			}
+			values[key] = overrideMap
+			continue
+		}
+		values[key] = override
+	}
+	return values
+}
+
+// toStringMap returns the mapping with string keys, as read from YAML
+func toStringMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if nested, ok := toStringMap(item); ok {
+				item = nested
+			}
+			result[fmt.Sprintf("%v", key)] = item
+		}
+		return result, true
+	}
+	return nil, false
+}
@@ -0,0 +1,220 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"code.cloudfoundry.org/fissile/helm"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// APIVersions are the API versions of the fake cluster of RenderNode. They
+// exist to hang the `.Capabilities.APIVersions.Has` method off our fake Helm
+// context.
+type APIVersions map[string]interface{}
+
+// Has indicates whether a version ("batch/v1") is enabled on the cluster.
+func (v *APIVersions) Has(name string) bool {
+	_, ok := (*v)[name]
+	return ok
+}
+
+// RenderNode renders a helm node given the configuration, without requiring
+// helm. The node is rendered along with the helper templates of the chart,
+// with the basic values of fissile charts, for a fake release "MyRelease" of
+// the chart "MyChart". Other templates are not rendered by include, which
+// returns their base name instead.
+// The configuration may be nil, or map[string]interface{}
+// If it is nil, default values are used.
+// Otherwise, if the keys contains dots, they are interpreted as the paths
+// to the elements to override.  If they do not contain dots, the map itself
+// is considered the override.
+func RenderNode(node helm.Node, config interface{}) ([]byte, error) {
+
+	basicConfig, err := getBasicConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	actualConfig := map[string]interface{}{
+		"Values": basicConfig,
+		"Capabilities": map[string]interface{}{
+			"KubeVersion": map[string]interface{}{
+				"Major": "1",
+				"Minor": "8",
+			},
+			"APIVersions": &APIVersions{
+				"apps/v1":                      true,
+				"rbac.authorization.k8s.io/v1": true,
+				"networking.k8s.io/v1":         true,
+				"policy/v1beta1":               true,
+			},
+		},
+		"Template": map[string]interface{}{
+			"BasePath": "",
+		},
+		"Chart": map[string]interface{}{
+			"AppVersion": "1.22.333.4444",
+			"Name":       "MyChart",
+			"Version":    "42.1+foo",
+		},
+		"Release": map[string]interface{}{
+			"Name":    "MyRelease",
+			"Service": renderReleaseService,
+		},
+	}
+	if overrides, ok := config.(map[string]interface{}); ok {
+		for k, v := range overrides {
+			actualConfig, err = mergeMap(actualConfig, v, 0, strings.Split(k, ".")...)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if config != nil {
+		return nil, fmt.Errorf("Invalid config %+v", config)
+	}
+
+	var helmConfig, yamlConfig, helmHelpers bytes.Buffer
+
+	if node == nil {
+		node = helm.NewNode(nil)
+	}
+	if err := helm.NewEncoder(&helmConfig).Encode(node); err != nil {
+		return nil, err
+	}
+
+	helpers := append(GetHelmTemplateHelpers(), GetHelmExtensionHelpers(nil)...)
+	for _, helper := range helpers {
+		if err := helm.NewEncoder(&helmHelpers).Encode(helper); err != nil {
+			return nil, err
+		}
+	}
+
+	// The fake include is used instead of the one of the Renderer, as
+	// the tests render single nodes without the other templates. Only
+	// the helpers are actually included.
+
+	var tmpl *template.Template
+	functions := RenderFuncMap()
+	functions["include"] = func(name string, data interface{}) (string, error) {
+		if tmpl.Lookup(name) == nil {
+			return renderInclude(name, data)
+		}
+		var buf bytes.Buffer
+		err := tmpl.ExecuteTemplate(&buf, name, data)
+		return buf.String(), err
+	}
+
+	// Note: Replicate helm's behaviour on missing keys.
+	tmpl = template.New("").Option("missingkey=zero").Funcs(functions)
+
+	tmpl, err = tmpl.Parse(string(helmHelpers.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err = tmpl.Parse(string(helmConfig.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tmpl.Execute(&yamlConfig, actualConfig); err != nil {
+		return nil, err
+	}
+	return yamlConfig.Bytes(), nil
+}
+
+// RoundtripNode serializes and then unserializes a helm node.  The config
+// override is identical to RenderNode().
+func RoundtripNode(node helm.Node, config interface{}) (interface{}, error) {
+	actualBytes, err := RenderNode(node, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var actual interface{}
+	if err := yaml.Unmarshal(actualBytes, &actual); err != nil {
+		return nil, err
+	}
+	return actual, nil
+}
+
+// RenderNodeWithValues renders a helm node like RenderNode, with the values
+// merged over the basic values of fissile charts. Nested mappings of the
+// values are merged recursively, everything else replaces the basic value.
+func RenderNodeWithValues(node helm.Node, values map[string]interface{}) ([]byte, error) {
+	config := map[string]interface{}{}
+	valuesConfig("Values", values, config)
+	return RenderNode(node, config)
+}
+
+// RoundtripNodeWithValues serializes and then unserializes a helm node.  The
+// values are identical to RenderNodeWithValues().
+func RoundtripNodeWithValues(node helm.Node, values map[string]interface{}) (interface{}, error) {
+	config := map[string]interface{}{}
+	valuesConfig("Values", values, config)
+	return RoundtripNode(node, config)
+}
+
+// valuesConfig converts nested values into the overrides of RenderNode, with
+// keys being the paths to the leaves of the values
+func valuesConfig(prefix string, values map[string]interface{}, config map[string]interface{}) {
+	for key, value := range values {
+		path := prefix + "." + key
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			valuesConfig(path, nested, config)
+		} else {
+			config[path] = value
+		}
+	}
+}
+
+// mergeMap returns the input map, but with an override applied.  An override
+// is a key path and a value to replace with.
+func mergeMap(obj map[string]interface{}, value interface{}, index int, key ...string) (map[string]interface{}, error) {
+	if len(key) < 1 {
+		return nil, fmt.Errorf("No keys")
+	}
+	if index > len(key) || index < 0 {
+		return nil, fmt.Errorf("Invalid index %d in keys %v", index, key)
+	}
+	if index == len(key)-1 {
+		// This will only work for untyped nil values
+		if value == nil {
+			delete(obj, key[index])
+		} else {
+			obj[key[index]] = value
+		}
+		return obj, nil
+	}
+	if _, ok := obj[key[index]]; !ok {
+		obj[key[index]] = make(map[string]interface{})
+	}
+	nested, ok := obj[key[index]].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid object at %s: is not a map: %+v",
+			strings.Join(key[:index+1], "."), obj[key[index]])
+	}
+	nested, err := mergeMap(nested, value, index+1, key...)
+	if err != nil {
+		return nil, err
+	}
+	obj[key[index]] = nested
+	return obj, nil
+}
+
+func renderInclude(name string, data interface{}) (string, error) {
+	// Fake include -- Actually implementing this function would
+	// require adding the handling of `associated` templates.  A
+	// first run at this generated a stack overflow.  The fake
+	// simply shows what path/name would have been included.
+	return filepath.Base(name), nil
+}
+
+// getBasicConfig returns the built-in configuration
+func getBasicConfig() (map[string]interface{}, error) {
+	return ValuesFromNode(MakeBasicValues())
+}
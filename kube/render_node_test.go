@@ -0,0 +1,43 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderNodeWithValues(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	node := helm.NewMapping(
+		"registry", "{{ .Values.kube.registry.hostname }}",
+		"organization", "{{ .Values.kube.organization }}",
+		"prefix", `{{ include "fissile.NamePrefix" . }}`)
+
+	actual, err := RoundtripNodeWithValues(node, map[string]interface{}{
+		"kube": map[string]interface{}{
+			"organization": "splat",
+		},
+		"name_prefix": map[string]interface{}{
+			"enabled": true,
+		},
+	})
+	require.NoError(t, err)
+	yamltest.IsYAMLEqualString(assert, `---
+		registry: docker.io
+		organization: splat
+		prefix: MyRelease-
+	`, actual)
+
+	actual, err = RoundtripNodeWithValues(node, nil)
+	require.NoError(t, err)
+	yamltest.IsYAMLEqualString(assert, `---
+		registry: docker.io
+		organization: null
+		prefix: null
+	`, actual)
+}
@@ -0,0 +1,181 @@
+package kube
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func renderTestSettings(t *testing.T, values map[string]interface{}) ExportSettings {
+	settings := testExportSettings(t, "render.yml")
+	settings.Render = &RenderOptions{
+		Values:       values,
+		ReleaseName:  "my-release",
+		Namespace:    "my-namespace",
+		ChartName:    "my-chart",
+		ChartVersion: "1.2.3",
+	}
+	return settings
+}
+
+func renderTestSecrets(t *testing.T, settings ExportSettings) helm.Node {
+	cvs := model.MakeMapOfVariables(settings.RoleManifest)
+	secrets, err := MakeSecrets(cvs, settings)
+	require.NoError(t, err)
+	return secrets
+}
+
+func TestRenderer(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewRenderer(ExportSettings{})
+	assert.EqualError(t, err, "Rendering requires render options")
+
+	t.Run("Render", func(t *testing.T) {
+		t.Parallel()
+
+		// Values files have the keys of nested mappings typed as interface{}
+		settings := renderTestSettings(t, map[string]interface{}{
+			"secrets": map[interface{}]interface{}{"NEEDED": "needed"},
+		})
+		renderer, err := NewRenderer(settings)
+		require.NoError(t, err)
+
+		output, err := renderer.Render("templates/secrets.yaml", renderTestSecrets(t, settings))
+		require.NoError(t, err)
+		assert.NotContains(t, string(output), "{{")
+
+		var secret map[string]interface{}
+		require.NoError(t, yaml.Unmarshal(output, &secret))
+		data := secret["data"].(map[interface{}]interface{})
+		assert.Equal(t, "bmVlZGVk", data["needed"])
+		assert.Equal(t, "", data["plain"])
+
+		node := helm.NewMapping(
+			"checksum", `{{ include (print $.Template.BasePath "/secrets.yaml") . | sha256sum }}`,
+			"name", `{{ template "fissile.SanitizeName" (printf "%s-%s" .Release.Name .Release.Namespace) }}`,
+			"chart", "{{ .Chart.Name }}-{{ .Chart.Version }}")
+		output, err = renderer.Render("templates/pod.yaml", node)
+		require.NoError(t, err)
+
+		var actual map[string]interface{}
+		require.NoError(t, yaml.Unmarshal(output, &actual))
+		rendered, err := renderer.Render("templates/secrets-again.yaml", renderTestSecrets(t, settings))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(rendered)), actual["checksum"])
+		assert.Equal(t, "my-release-my-namespace", actual["name"])
+		assert.Equal(t, "my-chart-1.2.3", actual["chart"])
+	})
+
+	t.Run("MissingRequiredValue", func(t *testing.T) {
+		t.Parallel()
+
+		settings := renderTestSettings(t, nil)
+		renderer, err := NewRenderer(settings)
+		require.NoError(t, err)
+
+		_, err = renderer.Render("templates/secrets.yaml", renderTestSecrets(t, settings))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "secrets.NEEDED has not been set")
+	})
+
+	t.Run("ValuesFromEnv", func(t *testing.T) {
+		t.Parallel()
+
+		settings := renderTestSettings(t, map[string]interface{}{
+			"secrets": map[interface{}]interface{}{"NEEDED": "from file"},
+		})
+		settings.Render.Environ = []string{"FISSILE_VALUES_SECRETS__NEEDED=from env"}
+		renderer, err := NewRenderer(settings)
+		require.NoError(t, err)
+		if assert.Len(t, renderer.EnvOverrides(), 1) {
+			assert.Equal(t, "secrets.NEEDED", renderer.EnvOverrides()[0].Key)
+		}
+
+		output, err := renderer.Render("templates/secrets.yaml", renderTestSecrets(t, settings))
+		require.NoError(t, err)
+		var secret map[string]interface{}
+		require.NoError(t, yaml.Unmarshal(output, &secret))
+		assert.Equal(t, "ZnJvbSBlbnY=", secret["data"].(map[interface{}]interface{})["needed"])
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+
+		renderer, err := NewRenderer(renderTestSettings(t, nil))
+		require.NoError(t, err)
+
+		node := helm.NewMapping("kind", "Pod")
+		node.Set(helm.Block("if .Values.disabled"))
+		output, err := renderer.Render("templates/disabled.yaml", node)
+		require.NoError(t, err)
+		assert.True(t, IsEmptyRender(output))
+	})
+
+	t.Run("Capabilities", func(t *testing.T) {
+		t.Parallel()
+
+		node := helm.NewMapping("kind", "Pod")
+		node.Add("version", `{{ .Capabilities.KubeVersion.Major }}.{{ .Capabilities.KubeVersion.Minor }}`)
+		node.Add("monitoring", `{{ .Capabilities.APIVersions.Has "monitoring.coreos.com/v1" }}`)
+		node.Add("batch", `{{ .Capabilities.APIVersions.Has "batch/v1" }}`)
+
+		renderer, err := NewRenderer(renderTestSettings(t, nil))
+		require.NoError(t, err)
+		output, err := renderer.Render("templates/default.yaml", node)
+		require.NoError(t, err)
+		assert.Contains(t, string(output), "version: 1.16\n")
+		assert.Contains(t, string(output), "monitoring: false\n")
+		assert.Contains(t, string(output), "batch: true\n")
+
+		settings := renderTestSettings(t, nil)
+		settings.Render.KubeVersion = "1.19+"
+		settings.Render.APIVersions = []string{"monitoring.coreos.com/v1"}
+		renderer, err = NewRenderer(settings)
+		require.NoError(t, err)
+		output, err = renderer.Render("templates/custom.yaml", node)
+		require.NoError(t, err)
+		assert.Contains(t, string(output), "version: 1.19+\n")
+		assert.Contains(t, string(output), "monitoring: true\n")
+		assert.Contains(t, string(output), "batch: false\n")
+
+		settings.Render.KubeVersion = "latest"
+		_, err = NewRenderer(settings)
+		assert.EqualError(t, err, "Invalid kube version latest to render for, expected <major>.<minor>")
+	})
+}
+
+func TestMergeValues(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]interface{}{
+		"kept":     "default",
+		"replaced": "default",
+		"deleted":  "default",
+		"nested": map[string]interface{}{
+			"kept":     1,
+			"replaced": 2,
+		},
+	}
+	overrides := map[string]interface{}{
+		"replaced": "override",
+		"deleted":  nil,
+		"added":    []interface{}{"a"},
+		"nested":   map[interface{}]interface{}{"replaced": 3},
+	}
+	assert.Equal(t, map[string]interface{}{
+		"kept":     "default",
+		"replaced": "override",
+		"added":    []interface{}{"a"},
+		"nested": map[string]interface{}{
+			"kept":     1,
+			"replaced": 3,
+		},
+	}, MergeValues(values, overrides))
+}
@@ -0,0 +1,150 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// resourceQuotaName is the name of the ResourceQuota and LimitRange
+const resourceQuotaName = "fissile-quota"
+
+// quotaResource describes a compute resource covered by the ResourceQuota
+type quotaResource struct {
+	name string // Name of the resource, i.e. memory or cpu
+	kind string // Either "request" or "limit"
+	unit string // Suffix of the quantity
+}
+
+// NewResourceQuota returns a ResourceQuota and a LimitRange for the namespace
+// of the helm release. The quota is the sum of the requests and limits of all
+// containers, multiplied by their replica counts and increased by
+// .Values.resource_quota.headroom percent; it is calculated from the sizing
+// values when the chart is rendered. The LimitRange supplies the requests and
+// limits of containers without sizing values, as the quota requires them to
+// be set on every container.
+func NewResourceQuota(settings ExportSettings) ([]helm.Node, error) {
+	if !settings.CreateHelmChart {
+		return nil, fmt.Errorf("Resource quota requires a helm chart")
+	}
+
+	var resources []quotaResource
+	if settings.UseMemoryLimits {
+		resources = append(resources,
+			quotaResource{name: "memory", kind: "request", unit: "Mi"},
+			quotaResource{name: "memory", kind: "limit", unit: "Mi"})
+	}
+	if settings.UseCPULimits {
+		resources = append(resources,
+			quotaResource{name: "cpu", kind: "request", unit: "m"},
+			quotaResource{name: "cpu", kind: "limit", unit: "m"})
+	}
+
+	block := helm.Block("if .Values.resource_quota.enabled")
+
+	hard := helm.NewMapping()
+	hard.Add("pods", quotaTotal(settings.RoleManifest, nil))
+	for _, resource := range resources {
+		hard.Add(fmt.Sprintf("%ss.%s", resource.kind, resource.name), quotaTotal(settings.RoleManifest, &resource))
+	}
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("v1").
+		SetKind("ResourceQuota").
+		SetName(resourceQuotaName).
+		AddModifier(block).
+		AddModifier(helm.Comment("Quota of the namespace, sized from the instance group sizing values"))
+	quota, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	quota.Add("spec", helm.NewMapping("hard", hard))
+
+	defaultRequest := helm.NewMapping()
+	defaultLimit := helm.NewMapping()
+	for _, resource := range resources {
+		value := fmt.Sprintf("{{ int .Values.resource_quota.defaults.%s.%s }}%s", resource.name, resource.kind, resource.unit)
+		if resource.kind == "request" {
+			defaultRequest.Add(resource.name, value)
+		} else {
+			defaultLimit.Add(resource.name, value)
+		}
+	}
+	limit := helm.NewMapping("type", "Container")
+	if len(resources) > 0 {
+		limit.Add("defaultRequest", defaultRequest)
+		limit.Add("default", defaultLimit)
+	}
+
+	cb = NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("v1").
+		SetKind("LimitRange").
+		SetName(resourceQuotaName).
+		AddModifier(block).
+		AddModifier(helm.Comment("Defaults for containers without sizing values, as required by the quota"))
+	limitRange, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	limitRange.Add("spec", helm.NewMapping("limits", helm.NewList(limit)))
+
+	return []helm.Node{quota, limitRange}, nil
+}
+
+// quotaTotal returns the template calculating the total of a resource over
+// all pods of the release, including the headroom. Without a resource, it
+// calculates the number of pods.
+func quotaTotal(roleManifest *model.RoleManifest, resource *quotaResource) string {
+	var buf strings.Builder
+	buf.WriteString("{{ $total := 0 }}")
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		if instanceGroup.Run == nil || instanceGroup.IsColocated() ||
+			instanceGroup.Run.FlightStage == model.FlightStageManual {
+			continue
+		}
+
+		var count string
+		switch instanceGroup.Type {
+		case model.RoleTypeBosh:
+			sizingCount := fmt.Sprintf(".Values.sizing.%s.count", makeVarName(instanceGroup.Name))
+			// The quota values end with their unit, so they are emitted quoted
+			// and the double quotes of notNil would be escaped; use a raw string
+			count = fmt.Sprintf("(ternary (int %s) (ternary %d %d .Values.config.HA) (ne (typeOf %s) `<nil>`))",
+				sizingCount, instanceGroup.Run.Scaling.HA, instanceGroup.Run.Scaling.Min, sizingCount)
+		case model.RoleTypeBoshTask:
+			count = "1"
+		default:
+			continue
+		}
+
+		block := featureCheckBlock(instanceGroup)
+		if block != "" {
+			fmt.Fprintf(&buf, "{{ %s }}", block)
+		}
+		if resource == nil {
+			fmt.Fprintf(&buf, "{{ $total = add $total %s }}", count)
+		} else {
+			containers := append([]*model.InstanceGroup{instanceGroup}, instanceGroup.GetColocatedRoles()...)
+			for _, container := range containers {
+				value := fmt.Sprintf(".Values.sizing.%s.%s.%s", makeVarName(container.Name), resource.name, resource.kind)
+				fallback := fmt.Sprintf(".Values.resource_quota.defaults.%s.%s", resource.name, resource.kind)
+				fmt.Fprintf(&buf, "{{ $total = add $total (mul %s (int (ternary %s %s (and .Values.config.%s.%ss (not (empty %s)))))) }}",
+					count, value, fallback, resource.name, resource.kind, value)
+			}
+		}
+		if block != "" {
+			buf.WriteString("{{ end }}")
+		}
+	}
+
+	unit := ""
+	if resource != nil {
+		unit = resource.unit
+	}
+	fmt.Fprintf(&buf, "{{ div (mul $total (add 100 (int .Values.resource_quota.headroom))) 100 }}%s", unit)
+	return buf.String()
+}
@@ -0,0 +1,101 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewResourceQuota(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewResourceQuota(ExportSettings{RoleManifest: &model.RoleManifest{}})
+	assert.Error(t, err, "Should require a helm chart")
+
+	settings := testExportSettings(t, "resource-quota.yml")
+	settings.UseMemoryLimits = true
+	settings.UseCPULimits = true
+	nodes, err := NewResourceQuota(settings)
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+
+	defaults := map[string]interface{}{
+		"memory": map[string]interface{}{"request": 256, "limit": 1024},
+		"cpu":    map[string]interface{}{"request": 100, "limit": 2000},
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		for _, node := range nodes {
+			actual, err := RoundtripNode(node, map[string]interface{}{})
+			require.NoError(t, err)
+			assert.Nil(t, actual)
+		}
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.config.HA":               true,
+			"Values.config.memory.requests":  true,
+			"Values.config.memory.limits":    false,
+			"Values.config.cpu.requests":     true,
+			"Values.config.cpu.limits":       true,
+			"Values.enable.extra":            false,
+			"Values.resource_quota.enabled":  true,
+			"Values.resource_quota.headroom": 50,
+			"Values.resource_quota.defaults": defaults,
+			"Values.sizing.main.count":       3,
+			"Values.sizing.optional":         map[string]interface{}{"count": nil, "memory": map[string]interface{}{}, "cpu": map[string]interface{}{}},
+			"Values.sizing.main.memory":      map[string]interface{}{"request": 100, "limit": 200},
+			"Values.sizing.main.cpu":         map[string]interface{}{"request": 500, "limit": nil},
+			"Values.sizing.setup.memory":     map[string]interface{}{"request": nil, "limit": nil},
+			"Values.sizing.setup.cpu":        map[string]interface{}{"request": 1000, "limit": 1000},
+		}
+		actual, err := RoundtripNode(nodes[0], config)
+		require.NoError(t, err)
+
+		quota := actual.(map[interface{}]interface{})
+		assert.Equal(t, "ResourceQuota", quota["kind"])
+		hard := quota["spec"].(map[interface{}]interface{})["hard"].(map[interface{}]interface{})
+		// 3 main + 1 setup pods, plus 50% headroom
+		assert.Equal(t, 6, hard["pods"])
+		// main: 3 * 100; setup: 256 (default)
+		assert.Equal(t, "834Mi", hard["requests.memory"])
+		// limits disabled: 4 * 1024 (default)
+		assert.Equal(t, "6144Mi", hard["limits.memory"])
+		// main: 3 * 500; setup: 1000
+		assert.Equal(t, "3750m", hard["requests.cpu"])
+		// main: 3 * 2000 (default); setup: 1000
+		assert.Equal(t, "10500m", hard["limits.cpu"])
+
+		config["Values.config.HA"] = false
+		config["Values.sizing.main.count"] = nil
+		config["Values.enable.extra"] = true
+		config["Values.resource_quota.headroom"] = 0
+		actual, err = RoundtripNode(nodes[0], config)
+		require.NoError(t, err)
+		hard = actual.(map[interface{}]interface{})["spec"].(map[interface{}]interface{})["hard"].(map[interface{}]interface{})
+		// 1 main, 1 optional, 1 setup
+		assert.Equal(t, 3, hard["pods"])
+	})
+
+	t.Run("LimitRange", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.resource_quota.enabled":  true,
+			"Values.resource_quota.defaults": defaults,
+		}
+		actual, err := RoundtripNode(nodes[1], config)
+		require.NoError(t, err)
+
+		limitRange := actual.(map[interface{}]interface{})
+		assert.Equal(t, "LimitRange", limitRange["kind"])
+		limit := limitRange["spec"].(map[interface{}]interface{})["limits"].([]interface{})[0].(map[interface{}]interface{})
+		assert.Equal(t, "Container", limit["type"])
+		assert.Equal(t, map[interface{}]interface{}{"memory": "256Mi", "cpu": "100m"}, limit["defaultRequest"])
+		assert.Equal(t, map[interface{}]interface{}{"memory": "1024Mi", "cpu": "2000m"}, limit["default"])
+	})
+}
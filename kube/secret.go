@@ -11,16 +11,37 @@ import (
 )
 
 // MakeSecrets creates Secret KubeConfig filled with the
-// key/value pairs from the specified map.
+// key/value pairs from the specified map. With settings.SecretStringData the
+// values are emitted as stringData instead of base64-encoded data, except for
+// binary values read from files, which always go into data.
 func MakeSecrets(secrets model.CVMap, settings ExportSettings) (helm.Node, error) {
 	data := helm.NewMapping()
+	stringData := helm.NewMapping()
 	generated := helm.NewMapping()
+	generatedStringData := helm.NewMapping()
 
 	for name, cv := range secrets {
+		if isExternalSecret(cv, settings.RoleManifest) {
+			// Synced into the secret of the external secret store
+			continue
+		}
 		key := util.ConvertNameToKey(name)
 		var value interface{}
 		comment := cv.CVOptions.Description
 
+		if cv.CVOptions.File != "" {
+			encoded := base64.StdEncoding.EncodeToString(cv.CVOptions.FileContents)
+			comment += fmt.Sprintf("\nThis value is binary and defaults to the contents of %s.", cv.CVOptions.File)
+			if settings.CreateHelmChart {
+				name := ".Values.secrets." + cv.Name
+				value = fmt.Sprintf(`{{if ne (typeOf %s) "<nil>"}}{{%s | quote}}{{else}}%q{{end}}`, name, name, encoded)
+			} else {
+				value = encoded
+			}
+			data.Add(key, helm.NewNode(value, helm.Comment(comment)))
+			continue
+		}
+
 		if settings.CreateHelmChart {
 			// cv.Generator == nil
 			if cv.Type == "" && independentSecret(cv.Name) {
@@ -28,31 +49,50 @@ func MakeSecrets(secrets model.CVMap, settings ExportSettings) (helm.Node, error
 					comment += "\nThis value is immutable and must not be changed once set."
 				}
 				comment += formattedExample(cv.CVOptions.Example)
-				required := `{{"" | b64enc | quote}}`
+				encode := " | b64enc"
+				if settings.SecretStringData {
+					encode = ""
+				}
+				required := fmt.Sprintf(`{{""%s | quote}}`, encode)
 				if cv.CVOptions.Required {
 					required = fmt.Sprintf(`{{fail "secrets.%s has not been set"}}`, cv.Name)
 				}
 				name := ".Values.secrets." + cv.Name
 				tmpl := `{{if ne (typeOf %s) "<nil>"}}{{if has (kindOf %s) (list "map" "slice")}}` +
-					`{{%s | toJson | b64enc | quote}}{{else}}{{%s | b64enc | quote}}{{end}}{{else}}%s{{end}}`
-				value = fmt.Sprintf(tmpl, name, name, name, name, required)
-				data.Add(key, helm.NewNode(value, helm.Comment(comment)))
+					`{{%s | toJson%s | quote}}{{else}}{{%s%s | quote}}{{end}}{{else}}%s{{end}}`
+				value = fmt.Sprintf(tmpl, name, name, name, encode, name, encode, required)
+				if settings.SecretStringData {
+					stringData.Add(key, helm.NewNode(value, helm.Comment(comment)))
+				} else {
+					data.Add(key, helm.NewNode(value, helm.Comment(comment)))
+				}
 			} else if !cv.CVOptions.Immutable {
 				comment += formattedExample(cv.CVOptions.Example)
 				comment += "\nThis value uses a generated default."
-				value = fmt.Sprintf(`{{ default "" .Values.secrets.%s | b64enc | quote }}`, cv.Name)
-				generated.Add(key, helm.NewNode(value, helm.Comment(comment)))
+				if settings.SecretStringData {
+					value = fmt.Sprintf(`{{ default "" .Values.secrets.%s | quote }}`, cv.Name)
+					generatedStringData.Add(key, helm.NewNode(value, helm.Comment(comment)))
+				} else {
+					value = fmt.Sprintf(`{{ default "" .Values.secrets.%s | b64enc | quote }}`, cv.Name)
+					generated.Add(key, helm.NewNode(value, helm.Comment(comment)))
+				}
 			}
 			// Immutable secrets with a generator are not user-overridable and only included in the versioned secrets object
 		} else {
 			_, value := cv.Value()
-			value = base64.StdEncoding.EncodeToString([]byte(value))
 			comment += formattedExample(cv.CVOptions.Example)
-			data.Add(key, helm.NewNode(value, helm.Comment(comment)))
+			if settings.SecretStringData {
+				stringData.Add(key, helm.NewNode(value, helm.Comment(comment)))
+			} else {
+				value = base64.StdEncoding.EncodeToString([]byte(value))
+				data.Add(key, helm.NewNode(value, helm.Comment(comment)))
+			}
 		}
 	}
 	data.Sort()
 	data.Merge(generated.Sort())
+	stringData.Sort()
+	stringData.Merge(generatedStringData.Sort())
 
 	cb := NewConfigBuilder().
 		SetSettings(&settings).
@@ -63,7 +103,12 @@ func MakeSecrets(secrets model.CVMap, settings ExportSettings) (helm.Node, error
 	if err != nil {
 		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
 	}
-	secret.Add("data", data)
+	if !settings.SecretStringData || len(data.Names()) > 0 {
+		secret.Add("data", data)
+	}
+	if settings.SecretStringData {
+		secret.Add("stringData", stringData)
+	}
 
 	return secret.Sort(), nil
 }
@@ -6,7 +6,7 @@ import (
 	"testing"
 
 	"code.cloudfoundry.org/fissile/model"
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -33,7 +33,7 @@ func TestMakeSecretsEmpty(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: "secrets"
 		`
-		testhelpers.IsYAMLEqualString(assert, expected, actual)
+		yamltest.IsYAMLEqualString(assert, expected, actual)
 	})
 
 	t.Run("Helm", func(t *testing.T) {
@@ -57,13 +57,13 @@ func TestMakeSecretsEmpty(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: secrets
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
 					skiff-role-name: "secrets"
 		`
-		testhelpers.IsYAMLEqualString(assert, expected, actual)
+		yamltest.IsYAMLEqualString(assert, expected, actual)
 	})
 }
 
@@ -176,7 +176,7 @@ func TestMakeSecretsKube(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, fmt.Sprintf(`---
+	yamltest.IsYAMLEqualString(assert, fmt.Sprintf(`---
 		apiVersion: "v1"
 		data:
 			const: %q
@@ -288,7 +288,7 @@ func TestMakeSecretsHelm(t *testing.T) {
 			return
 		}
 
-		testhelpers.IsYAMLEqualString(assert, fmt.Sprintf(`---
+		yamltest.IsYAMLEqualString(assert, fmt.Sprintf(`---
 			apiVersion: "v1"
 			data:
 				const: %q
@@ -304,7 +304,7 @@ func TestMakeSecretsHelm(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: secrets
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -312,3 +312,132 @@ func TestMakeSecretsHelm(t *testing.T) {
 		`, varConstB64, varDescB64, varMinB64, varValuedB64, varStructuredB64, varGenieB64), actual)
 	})
 }
+
+func TestMakeSecretsStringData(t *testing.T) {
+	t.Parallel()
+
+	testCV := model.CVMap{
+		"valued": &model.VariableDefinition{
+			Name:      "valued",
+			CVOptions: model.CVOptions{Default: "plain text"},
+		},
+		"genie": &model.VariableDefinition{
+			Name: "genie",
+			Type: "password",
+		},
+	}
+
+	t.Run("Kube", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+
+		secret, err := MakeSecrets(testCV, ExportSettings{SecretStringData: true})
+		if !assert.NoError(err) {
+			return
+		}
+		actual, err := RoundtripKube(secret)
+		if !assert.NoError(err) {
+			return
+		}
+		yamltest.IsYAMLEqualString(assert, `---
+			apiVersion: "v1"
+			stringData:
+				genie: ""
+				valued: "plain text"
+			kind: "Secret"
+			metadata:
+				name: "secrets"
+				labels:
+					app.kubernetes.io/component: "secrets"
+		`, actual)
+	})
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+
+		secret, err := MakeSecrets(testCV, ExportSettings{
+			CreateHelmChart:  true,
+			SecretStringData: true,
+		})
+		if !assert.NoError(err) {
+			return
+		}
+		config := map[string]interface{}{
+			"Values.secrets.valued": map[string]string{"key": "value"},
+			"Values.secrets.genie":  "djinn",
+		}
+		actual, err := RoundtripNode(secret, config)
+		if !assert.NoError(err) {
+			return
+		}
+		spec := actual.(map[interface{}]interface{})
+		assert.NotContains(spec, "data")
+		assert.Equal(map[interface{}]interface{}{
+			"genie":  "djinn",
+			"valued": `{"key":"value"}`,
+		}, spec["stringData"])
+	})
+}
+
+func TestMakeSecretsFile(t *testing.T) {
+	t.Parallel()
+
+	contents := []byte{0x05, 0x02, 0x00, 0xff}
+	testCV := model.CVMap{
+		"keytab": &model.VariableDefinition{
+			Name: "keytab",
+			CVOptions: model.CVOptions{
+				Description:  "<<<kerberos>>>",
+				Secret:       true,
+				File:         "secrets/krb5.keytab",
+				FileContents: contents,
+			},
+		},
+	}
+	encoded := RenderEncodeBase64(string(contents))
+
+	for _, stringData := range []bool{false, true} {
+		stringData := stringData
+		t.Run("Kube", func(t *testing.T) {
+			t.Parallel()
+			assert := assert.New(t)
+
+			secret, err := MakeSecrets(testCV, ExportSettings{SecretStringData: stringData})
+			if !assert.NoError(err) {
+				return
+			}
+			renderedYAML, err := RenderNode(secret, nil)
+			if !assert.NoError(err) {
+				return
+			}
+			assert.Contains(string(renderedYAML), fmt.Sprintf(
+				"# <<<kerberos>>>\n  # This value is binary and defaults to the contents of secrets/krb5.keytab.\n  keytab: %q", encoded))
+		})
+	}
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+
+		secret, err := MakeSecrets(testCV, ExportSettings{CreateHelmChart: true})
+		if !assert.NoError(err) {
+			return
+		}
+
+		actual, err := RoundtripNode(secret, nil)
+		if !assert.NoError(err) {
+			return
+		}
+		data := actual.(map[interface{}]interface{})["data"].(map[interface{}]interface{})
+		assert.Equal(encoded, data["keytab"])
+
+		override := RenderEncodeBase64("other")
+		actual, err = RoundtripNode(secret, map[string]interface{}{"Values.secrets.keytab": override})
+		if !assert.NoError(err) {
+			return
+		}
+		data = actual.(map[interface{}]interface{})["data"].(map[interface{}]interface{})
+		assert.Equal(override, data["keytab"])
+	})
+}
@@ -2,12 +2,20 @@ package kube
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"code.cloudfoundry.org/fissile/helm"
 	"code.cloudfoundry.org/fissile/model"
 	"code.cloudfoundry.org/fissile/util"
 )
 
+// Annotations of public services read by external-dns
+const (
+	ExternalDNSHostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+	ExternalDNSTTLAnnotation      = "external-dns.alpha.kubernetes.io/ttl"
+)
+
 // NewServiceList creates a list of services
 // clustering should be true if a kubernetes headless service should be created
 // (for self-clustering roles, to reach each pod individually)
@@ -83,7 +91,7 @@ func createPorts(settings ExportSettings, serviceType newServiceType, roleName s
 
 		block := fmt.Sprintf("range $port := until (int %s.count)", sizing)
 
-		portName := port.Name
+		portName := port.KubeName()
 		if port.Max > 1 {
 			portName = fmt.Sprintf("%s-{{ $port }}", portName)
 		}
@@ -101,6 +109,7 @@ func createPorts(settings ExportSettings, serviceType newServiceType, roleName s
 			"protocol", port.Protocol,
 		)
 		newPort.Set(helm.Block(block))
+		addAppProtocol(settings, newPort, port)
 		if serviceType == newServiceTypeHeadless {
 			newPort.Add("targetPort", 0)
 		} else {
@@ -109,7 +118,7 @@ func createPorts(settings ExportSettings, serviceType newServiceType, roleName s
 		ports = append(ports, newPort)
 	} else {
 		for portIndex := 0; portIndex < port.Count; portIndex++ {
-			portName := port.Name
+			portName := port.KubeName()
 			if port.Max > 1 {
 				portName = fmt.Sprintf("%s-%d", portName, portIndex)
 			}
@@ -127,6 +136,7 @@ func createPorts(settings ExportSettings, serviceType newServiceType, roleName s
 				"port", portNumber,
 				"protocol", port.Protocol,
 			)
+			addAppProtocol(settings, newPort, port)
 
 			if serviceType == newServiceTypeHeadless {
 				newPort.Add("targetPort", 0)
@@ -142,6 +152,20 @@ func createPorts(settings ExportSettings, serviceType newServiceType, roleName s
 	return ports
 }
 
+// addAppProtocol sets the appProtocol of a service port, if the exposed port
+// has an application protocol. Helm charts only set it on clusters supporting
+// the field.
+func addAppProtocol(settings ExportSettings, servicePort *helm.Mapping, port model.JobExposedPort) {
+	if port.AppProtocol == "" {
+		return
+	}
+	if settings.CreateHelmChart {
+		servicePort.Add("appProtocol", port.AppProtocol, helm.Block("if "+minKubeVersion(1, 19)))
+	} else {
+		servicePort.Add("appProtocol", port.AppProtocol)
+	}
+}
+
 // newClusteringService creates a new k8s service for the overall instance group.
 // This allows individual pods to be addressed by their index.
 func newClusteringService(role *model.InstanceGroup, settings ExportSettings) (helm.Node, error) {
@@ -167,6 +191,7 @@ func newClusteringService(role *model.InstanceGroup, settings ExportSettings) (h
 	if role.HasTag(model.RoleTagIstioManaged) && settings.CreateHelmChart {
 		selector.Add(AppNameLabel, role.Name, helm.Block("if .Values.config.use_istio"))
 	}
+	addInstanceSelector(selector, settings)
 	spec.Add("selector", selector)
 
 	spec.Add("clusterIP", "None")
@@ -214,6 +239,7 @@ func newService(role *model.InstanceGroup, job *model.JobReference, serviceType
 	if role.HasTag(model.RoleTagIstioManaged) && settings.CreateHelmChart {
 		selector.Add(AppNameLabel, role.Name, helm.Block("if .Values.config.use_istio"))
 	}
+	addInstanceSelector(selector, settings)
 	spec.Add("selector", selector)
 
 	if serviceType == newServiceTypeHeadless {
@@ -229,11 +255,7 @@ func newService(role *model.InstanceGroup, job *model.JobReference, serviceType
 	}
 	spec.Add("ports", helm.NewNode(ports))
 
-	serviceName := job.ContainerProperties.BoshContainerization.ServiceName
-	if len(serviceName) == 0 {
-		serviceName = util.ConvertNameToKey(role.Name + "-" + job.Name)
-	}
-
+	serviceName := jobServiceName(role, job)
 	switch serviceType {
 	case newServiceTypeHeadless:
 		serviceName += "-set"
@@ -256,6 +278,10 @@ func newService(role *model.InstanceGroup, job *model.JobReference, serviceType
 	}
 	service.Add("spec", spec.Sort())
 
+	if serviceType == newServiceTypePublic {
+		addExternalDNSAnnotations(service, role, job, settings)
+	}
+
 	if settings.CreateHelmChart && serviceType == newServiceTypePublic {
 		block := `if and .Values.services.loadbalanced .Values.ingress.enabled`
 		fail := `{{ fail "services.loadbalanced and ingress.enabled cannot both be set" }}`
@@ -264,3 +290,56 @@ func newService(role *model.InstanceGroup, job *model.JobReference, serviceType
 
 	return service, nil
 }
+
+// addExternalDNSAnnotations annotates the public service of a job with the
+// hostnames of the external-dns entries of its public ports. As there is a
+// single entry per service, the TTL is the lowest of the ports setting one,
+// and left to the DNS provider if none does. Helm charts take both from the
+// sizing values of the ports, and leave out the annotations if no hostname is
+// set.
+func addExternalDNSAnnotations(service *helm.Mapping, role *model.InstanceGroup, job *model.JobReference, settings ExportSettings) {
+	var hostnames, hostnameValues, ttlValues []string
+	var ttl int
+	for _, port := range job.ContainerProperties.BoshContainerization.Ports {
+		if !port.Public || port.ExternalDNS == nil {
+			continue
+		}
+		portValues := fmt.Sprintf(".Values.sizing.%s.ports.%s", makeVarName(role.Name), makeVarName(port.Name))
+		hostnames = append(hostnames, port.ExternalDNS.Hostname)
+		hostnameValues = append(hostnameValues, portValues+".hostname")
+		ttlValues = append(ttlValues, portValues+".ttl")
+		if port.ExternalDNS.TTL > 0 && (ttl == 0 || port.ExternalDNS.TTL < ttl) {
+			ttl = port.ExternalDNS.TTL
+		}
+	}
+	if len(hostnames) == 0 {
+		return
+	}
+
+	meta := service.Get("metadata").(*helm.Mapping)
+	if !settings.CreateHelmChart {
+		annotations := helm.NewMapping(ExternalDNSHostnameAnnotation, strings.Join(hostnames, ","))
+		if ttl > 0 {
+			annotations.Add(ExternalDNSTTLAnnotation, strconv.Itoa(ttl))
+		}
+		meta.Add("annotations", annotations)
+		return
+	}
+
+	hostnameList := fmt.Sprintf("(compact (list %s))", strings.Join(hostnameValues, " "))
+	annotations := helm.NewMapping(ExternalDNSHostnameAnnotation, fmt.Sprintf(`{{ join "," %s | quote }}`, hostnameList))
+	ttlList := fmt.Sprintf("(compact (list %s))", strings.Join(ttlValues, " "))
+	lowestTTL := fmt.Sprintf(`{{ $ttl := 0 }}{{ range %s }}{{ if or (eq $ttl 0) (lt (int .) $ttl) }}{{ $ttl = int . }}{{ end }}{{ end }}{{ $ttl | quote }}`, ttlList)
+	annotations.Add(ExternalDNSTTLAnnotation, lowestTTL, helm.Block("if "+ttlList))
+	meta.Add("annotations", annotations, helm.Block("if "+hostnameList))
+}
+
+// jobServiceName returns the name of the private service of a job; the names
+// of its headless and public services are derived from it
+func jobServiceName(role *model.InstanceGroup, job *model.JobReference) string {
+	serviceName := job.ContainerProperties.BoshContainerization.ServiceName
+	if len(serviceName) == 0 {
+		serviceName = util.ConvertNameToKey(role.Name + "-" + job.Name)
+	}
+	return serviceName
+}
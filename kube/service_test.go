@@ -10,7 +10,7 @@ import (
 	"code.cloudfoundry.org/fissile/helm"
 	"code.cloudfoundry.org/fissile/model"
 	"code.cloudfoundry.org/fissile/model/loader"
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -58,7 +58,7 @@ func TestServiceKube(t *testing.T) {
 
 	actual, err := RoundtripKube(service)
 	require.NoError(t, err)
-	testhelpers.IsYAMLSubsetString(assert, `---
+	yamltest.IsYAMLSubsetString(assert, `---
 		metadata:
 			name: myrole-tor
 		spec:
@@ -100,7 +100,7 @@ func TestServiceHelm(t *testing.T) {
 		}
 		actual, err := RoundtripNode(service, config)
 		require.NoError(t, err)
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "v1"
 			kind: "Service"
 			metadata:
@@ -108,7 +108,7 @@ func TestServiceHelm(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: myrole-tor
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -136,7 +136,7 @@ func TestServiceHelm(t *testing.T) {
 
 		actual, err := RoundtripNode(service, config)
 		require.NoError(t, err)
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "v1"
 			kind: "Service"
 			metadata:
@@ -144,7 +144,7 @@ func TestServiceHelm(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: myrole-tor
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -171,7 +171,7 @@ func TestServiceHelm(t *testing.T) {
 		}
 		actual, err := RoundtripNode(service, config)
 		require.NoError(t, err)
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "v1"
 			kind: "Service"
 			metadata:
@@ -179,7 +179,7 @@ func TestServiceHelm(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: myrole-tor
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -228,7 +228,7 @@ func TestIstioManagedServiceHelm(t *testing.T) {
 		}
 		actual, err := RoundtripNode(service, config)
 		require.NoError(t, err)
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "v1"
 			kind: "Service"
 			metadata:
@@ -237,7 +237,7 @@ func TestIstioManagedServiceHelm(t *testing.T) {
 					app: myrole-tor
 					app.kubernetes.io/component: myrole-tor
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -267,7 +267,7 @@ func TestIstioManagedServiceHelm(t *testing.T) {
 
 		actual, err := RoundtripNode(service, config)
 		require.NoError(t, err)
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "v1"
 			kind: "Service"
 			metadata:
@@ -276,7 +276,7 @@ func TestIstioManagedServiceHelm(t *testing.T) {
 					app: myrole-tor
 					app.kubernetes.io/component: myrole-tor
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -298,6 +298,67 @@ func TestIstioManagedServiceHelm(t *testing.T) {
 	})
 }
 
+func TestServiceAppProtocol(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	manifest, role := serviceTestLoadRole(assert, "app-protocol.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	t.Run("Kube", func(t *testing.T) {
+		t.Parallel()
+		service, err := newService(role, role.JobReferences[0], newServiceTypePrivate, ExportSettings{})
+		require.NoError(t, err)
+		require.NotNil(t, service)
+
+		actual, err := RoundtripKube(service)
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert, `---
+			spec:
+				ports:
+				-	name: http-api
+					appProtocol: http
+					port: 80
+					targetPort: 8080
+				-	name: grpc-rpc
+					appProtocol: grpc
+					port: 9090
+					targetPort: 9090
+				-	name: metrics
+					port: 9100
+					targetPort: 9100
+		`, actual)
+		ports := actual.(map[interface{}]interface{})["spec"].(map[interface{}]interface{})["ports"].([]interface{})
+		assert.NotContains(ports[2], "appProtocol")
+	})
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		service, err := newService(role, role.JobReferences[0], newServiceTypePrivate, ExportSettings{
+			CreateHelmChart: true,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, service)
+
+		actual, err := RoundtripNode(service, map[string]interface{}{
+			"Capabilities.KubeVersion.Minor": "19",
+		})
+		require.NoError(t, err)
+		ports := actual.(map[interface{}]interface{})["spec"].(map[interface{}]interface{})["ports"].([]interface{})
+		assert.Equal("http-api", ports[0].(map[interface{}]interface{})["name"])
+		assert.Equal("http", ports[0].(map[interface{}]interface{})["appProtocol"])
+
+		// Older clusters don't know the field
+		actual, err = RoundtripNode(service, nil)
+		require.NoError(t, err)
+		ports = actual.(map[interface{}]interface{})["spec"].(map[interface{}]interface{})["ports"].([]interface{})
+		assert.Equal("http-api", ports[0].(map[interface{}]interface{})["name"])
+		assert.NotContains(ports[0], "appProtocol")
+	})
+}
+
 func TestHeadlessServiceKube(t *testing.T) {
 	t.Parallel()
 	assert := assert.New(t)
@@ -316,7 +377,7 @@ func TestHeadlessServiceKube(t *testing.T) {
 
 	actual, err := RoundtripKube(service)
 	require.NoError(t, err)
-	testhelpers.IsYAMLSubsetString(assert, `---
+	yamltest.IsYAMLSubsetString(assert, `---
 		metadata:
 			name: myservice-set
 		spec:
@@ -362,7 +423,7 @@ func TestHeadlessServiceHelm(t *testing.T) {
 		}
 		actual, err := RoundtripNode(service, config)
 		require.NoError(t, err)
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "v1"
 			kind: "Service"
 			metadata:
@@ -370,7 +431,7 @@ func TestHeadlessServiceHelm(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: myservice-set
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -399,7 +460,7 @@ func TestHeadlessServiceHelm(t *testing.T) {
 
 		actual, err := RoundtripNode(service, config)
 		require.NoError(t, err)
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "v1"
 			kind: "Service"
 			metadata:
@@ -407,7 +468,7 @@ func TestHeadlessServiceHelm(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: myservice-set
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -435,7 +496,7 @@ func TestHeadlessServiceHelm(t *testing.T) {
 		}
 		actual, err := RoundtripNode(service, config)
 		require.NoError(t, err)
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "v1"
 			kind: "Service"
 			metadata:
@@ -443,7 +504,7 @@ func TestHeadlessServiceHelm(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: myservice-set
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -483,7 +544,7 @@ func TestPublicServiceKube(t *testing.T) {
 
 	actual, err := RoundtripKube(service)
 	require.NoError(t, err)
-	testhelpers.IsYAMLSubsetString(assert, `---
+	yamltest.IsYAMLSubsetString(assert, `---
 		metadata:
 			name: myrole-tor-public
 		spec:
@@ -525,7 +586,7 @@ func TestPublicServiceHelm(t *testing.T) {
 
 		actual, err := RoundtripNode(service, config)
 		require.NoError(t, err)
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "v1"
 			kind: "Service"
 			metadata:
@@ -533,7 +594,7 @@ func TestPublicServiceHelm(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: myrole-tor-public
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -559,7 +620,7 @@ func TestPublicServiceHelm(t *testing.T) {
 
 		actual, err := RoundtripNode(service, config)
 		require.NoError(t, err)
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "v1"
 			kind: "Service"
 			metadata:
@@ -567,7 +628,7 @@ func TestPublicServiceHelm(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: myrole-tor-public
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -605,7 +666,7 @@ func TestPublicServiceHelm(t *testing.T) {
 
 		actual, err := RoundtripNode(service, config)
 		require.NoError(t, err)
-		testhelpers.IsYAMLEqualString(assert, `---
+		yamltest.IsYAMLEqualString(assert, `---
 			apiVersion: "v1"
 			kind: "Service"
 			metadata:
@@ -613,7 +674,7 @@ func TestPublicServiceHelm(t *testing.T) {
 				labels:
 					app.kubernetes.io/component: myrole-tor-public
 					app.kubernetes.io/instance: MyRelease
-					app.kubernetes.io/managed-by: Tiller
+					app.kubernetes.io/managed-by: fissile
 					app.kubernetes.io/name: MyChart
 					app.kubernetes.io/version: 1.22.333.4444
 					helm.sh/chart: MyChart-42.1_foo
@@ -630,6 +691,105 @@ func TestPublicServiceHelm(t *testing.T) {
 	})
 }
 
+func TestPublicServiceExternalDNS(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	manifest, role := serviceTestLoadRole(assert, "external-dns.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	t.Run("Kube", func(t *testing.T) {
+		t.Parallel()
+		service, err := newService(role, role.JobReferences[0], newServiceTypePublic, ExportSettings{})
+		require.NoError(t, err)
+		require.NotNil(t, service)
+
+		actual, err := RoundtripKube(service)
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert, `---
+			metadata:
+				annotations:
+					external-dns.alpha.kubernetes.io/hostname: "api.example.com,ssh.example.com"
+					external-dns.alpha.kubernetes.io/ttl: "60"
+		`, actual)
+
+		private, err := newService(role, role.JobReferences[0], newServiceTypePrivate, ExportSettings{})
+		require.NoError(t, err)
+		assert.Nil(private.Get("metadata", "annotations"), "Private services should not be annotated")
+	})
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		service, err := newService(role, role.JobReferences[0], newServiceTypePublic, ExportSettings{
+			CreateHelmChart: true,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, service)
+
+		actual, err := RoundtripNode(service, map[string]interface{}{
+			"Values.sizing.myrole.ports.https.hostname": "api.example.org",
+			"Values.sizing.myrole.ports.https.ttl":      300,
+			"Values.sizing.myrole.ports.ssh.hostname":   "ssh.example.org",
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert, `---
+			metadata:
+				annotations:
+					external-dns.alpha.kubernetes.io/hostname: "api.example.org,ssh.example.org"
+					external-dns.alpha.kubernetes.io/ttl: "300"
+		`, actual)
+
+		// The lowest TTL set applies to the service
+		actual, err = RoundtripNode(service, map[string]interface{}{
+			"Values.sizing.myrole.ports.https.hostname": "api.example.org",
+			"Values.sizing.myrole.ports.https.ttl":      300,
+			"Values.sizing.myrole.ports.ssh.hostname":   "ssh.example.org",
+			"Values.sizing.myrole.ports.ssh.ttl":        120,
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert, `---
+			metadata:
+				annotations:
+					external-dns.alpha.kubernetes.io/ttl: "120"
+		`, actual)
+
+		actual, err = RoundtripNode(service, map[string]interface{}{
+			"Values.sizing.myrole.ports.https.hostname": "",
+			"Values.sizing.myrole.ports.https.ttl":      0,
+			"Values.sizing.myrole.ports.ssh.hostname":   "ssh.example.org",
+		})
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert, `---
+			metadata:
+				annotations:
+					external-dns.alpha.kubernetes.io/hostname: "ssh.example.org"
+		`, actual)
+		annotations := actual.(map[interface{}]interface{})["metadata"].(map[interface{}]interface{})["annotations"]
+		assert.NotContains(annotations, ExternalDNSTTLAnnotation)
+
+		actual, err = RoundtripNode(service, map[string]interface{}{
+			"Values.sizing.myrole.ports.https.hostname": "",
+			"Values.sizing.myrole.ports.ssh.hostname":   "",
+		})
+		require.NoError(t, err)
+		assert.NotContains(actual.(map[interface{}]interface{})["metadata"], "annotations")
+	})
+
+	t.Run("Values", func(t *testing.T) {
+		t.Parallel()
+		values := MakeValues(ExportSettings{RoleManifest: manifest, CreateHelmChart: true})
+		require.NotNil(t, values)
+		ports := values.Get("sizing", "myrole", "ports")
+		require.NotNil(t, ports)
+		assert.Equal("api.example.com", ports.Get("https", "hostname").String())
+		assert.Equal("60", ports.Get("https", "ttl").String())
+		assert.Equal("0", ports.Get("ssh", "ttl").String())
+		assert.Nil(ports.Get("metrics"), "Ports without external-dns entries have no values")
+	})
+}
+
 func TestActivePassiveService(t *testing.T) {
 	t.Parallel()
 	manifest, role := serviceTestLoadRole(assert.New(t), "exposed-ports.yml")
@@ -686,7 +846,7 @@ func TestActivePassiveService(t *testing.T) {
 
 							var genericService, headlessService, privateService, publicService helm.Node
 							for _, service := range services.Get("items").Values() {
-								serviceName := service.Get("metadata", "name").String()
+								serviceName := service.Get("metadata", "labels", RoleNameLabel).String()
 								switch serviceName {
 								case "myrole-set":
 									genericService = service
@@ -713,7 +873,7 @@ func TestActivePassiveService(t *testing.T) {
 												labels:
 													app.kubernetes.io/component: myrole-set
 													app.kubernetes.io/instance: MyRelease
-													app.kubernetes.io/managed-by: Tiller
+													app.kubernetes.io/managed-by: fissile
 													app.kubernetes.io/name: MyChart
 													app.kubernetes.io/version: 1.22.333.4444
 													helm.sh/chart: MyChart-42.1_foo
@@ -735,7 +895,7 @@ func TestActivePassiveService(t *testing.T) {
 													app.kubernetes.io/component: myrole
 													skiff-role-active: "true"
 										`)
-										testhelpers.IsYAMLEqualString(assert.New(t), expected, actual)
+										yamltest.IsYAMLEqualString(assert.New(t), expected, actual)
 									}
 								}
 								if assert.NotNil(t, headlessService, "headless service not found") {
@@ -749,7 +909,7 @@ func TestActivePassiveService(t *testing.T) {
 												labels:
 													app.kubernetes.io/component: myrole-tor-set
 													app.kubernetes.io/instance: MyRelease
-													app.kubernetes.io/managed-by: Tiller
+													app.kubernetes.io/managed-by: fissile
 													app.kubernetes.io/name: MyChart
 													app.kubernetes.io/version: 1.22.333.4444
 													helm.sh/chart: MyChart-42.1_foo
@@ -771,7 +931,7 @@ func TestActivePassiveService(t *testing.T) {
 													app.kubernetes.io/component: myrole
 													skiff-role-active: "true"
 										`)
-										testhelpers.IsYAMLEqualString(assert.New(t), expected, actual)
+										yamltest.IsYAMLEqualString(assert.New(t), expected, actual)
 									}
 								}
 							} else {
@@ -790,7 +950,7 @@ func TestActivePassiveService(t *testing.T) {
 											labels:
 												app.kubernetes.io/component: myrole-tor
 												app.kubernetes.io/instance: MyRelease
-												app.kubernetes.io/managed-by: Tiller
+												app.kubernetes.io/managed-by: fissile
 												app.kubernetes.io/name: MyChart
 												app.kubernetes.io/version: 1.22.333.4444
 												helm.sh/chart: MyChart-42.1_foo
@@ -811,7 +971,7 @@ func TestActivePassiveService(t *testing.T) {
 												app.kubernetes.io/component: myrole
 												skiff-role-active: "true"
 									`)
-									testhelpers.IsYAMLEqualString(assert.New(t), expected, actual)
+									yamltest.IsYAMLEqualString(assert.New(t), expected, actual)
 								}
 							}
 
@@ -826,7 +986,7 @@ func TestActivePassiveService(t *testing.T) {
 											labels:
 												app.kubernetes.io/component: myrole-tor-public
 												app.kubernetes.io/instance: MyRelease
-												app.kubernetes.io/managed-by: Tiller
+												app.kubernetes.io/managed-by: fissile
 												app.kubernetes.io/name: MyChart
 												app.kubernetes.io/version: 1.22.333.4444
 												helm.sh/chart: MyChart-42.1_foo
@@ -849,7 +1009,7 @@ func TestActivePassiveService(t *testing.T) {
 									case withKube:
 										expected = strings.Replace(expected, "192.0.2.42", "192.168.77.77", 1)
 									}
-									testhelpers.IsYAMLEqualString(assert.New(t), expected, actual)
+									yamltest.IsYAMLEqualString(assert.New(t), expected, actual)
 								}
 							}
 
@@ -0,0 +1,101 @@
+package kube
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// getSidecarContainerMapping returns the container list entry of a sidecar of
+// the instance group. Sidecars run their image as is; fissile neither
+// configures them nor probes them.
+func getSidecarContainerMapping(role *model.InstanceGroup, sidecar *model.RoleRunSidecar, settings ExportSettings) *helm.Mapping {
+	container := helm.NewMapping()
+	container.Add("name", sidecar.Name)
+	container.Add("image", sidecar.Image)
+	if len(sidecar.Command) > 0 {
+		container.Add("command", sidecar.Command)
+	}
+	if len(sidecar.Args) > 0 {
+		container.Add("args", sidecar.Args)
+	}
+
+	if len(sidecar.Ports) > 0 {
+		ports := helm.NewList()
+		for _, port := range sidecar.Ports {
+			ports.Add(helm.NewMapping("containerPort", port.Port, "name", port.Name, "protocol", port.Protocol))
+		}
+		container.Add("ports", ports)
+	}
+
+	env := helm.NewList()
+	for _, envVar := range sidecar.Env {
+		env.Add(helm.NewMapping("name", envVar.Name, "value", envVar.Value))
+	}
+	container.Add("env", env)
+
+	volumeTypes := map[string]model.VolumeType{}
+	for _, volume := range role.Run.Volumes {
+		volumeTypes[volume.Tag] = volume.Type
+	}
+	mounts := helm.NewList()
+	for _, volumeMount := range sidecar.VolumeMounts {
+		mount := helm.NewMapping("mountPath", volumeMount.Path, "name", volumeMount.Tag, "readOnly", volumeMount.ReadOnly)
+		if volumeTypes[volumeMount.Tag] == model.VolumeTypeHost && settings.CreateHelmChart {
+			mount.Set(helm.Block("if .Values.kube.hostpath_available"))
+		}
+		mounts.Add(mount)
+	}
+	container.Add("volumeMounts", mounts)
+
+	if resources := getSidecarResources(sidecar, settings); resources != nil {
+		container.Add("resources", resources)
+	}
+
+	return container.Sort()
+}
+
+// getSidecarResources returns the resources of a sidecar, or nil if it has
+// none. Helm charts only include the requests and limits enabled by
+// .Values.config.<resource>.requests and limits.
+func getSidecarResources(sidecar *model.RoleRunSidecar, settings ExportSettings) helm.Node {
+	requests := helm.NewMapping()
+	limits := helm.NewMapping()
+	add := func(mapping *helm.Mapping, resource, kind, value string) {
+		if settings.CreateHelmChart {
+			mapping.Add(resource, value, helm.Block(fmt.Sprintf("if .Values.config.%s.%s", resource, kind)))
+		} else {
+			mapping.Add(resource, value)
+		}
+	}
+
+	if settings.UseMemoryLimits && sidecar.Memory != nil {
+		if sidecar.Memory.Request != nil {
+			add(requests, "memory", "requests", fmt.Sprintf("%dMi", *sidecar.Memory.Request))
+		}
+		if sidecar.Memory.Limit != nil {
+			add(limits, "memory", "limits", fmt.Sprintf("%dMi", *sidecar.Memory.Limit))
+		}
+	}
+	if settings.UseCPULimits && sidecar.CPU != nil {
+		if sidecar.CPU.Request != nil {
+			add(requests, "cpu", "requests", fmt.Sprintf("%dm", int(*sidecar.CPU.Request*1000+0.5)))
+		}
+		if sidecar.CPU.Limit != nil {
+			add(limits, "cpu", "limits", fmt.Sprintf("%dm", int(*sidecar.CPU.Limit*1000+0.5)))
+		}
+	}
+
+	if len(requests.Names()) == 0 && len(limits.Names()) == 0 {
+		return nil
+	}
+	resources := helm.NewMapping()
+	if len(requests.Names()) > 0 {
+		resources.Add("requests", requests)
+	}
+	if len(limits.Names()) > 0 {
+		resources.Add("limits", limits)
+	}
+	return resources
+}
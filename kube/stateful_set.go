@@ -29,7 +29,7 @@ func NewStatefulSet(role *model.InstanceGroup, settings ExportSettings, grapher
 	claims := getVolumeClaims(role, settings.CreateHelmChart)
 
 	spec := helm.NewMapping()
-	spec.Add("serviceName", fmt.Sprintf("%s-set", role.Name))
+	spec.Add("serviceName", resourceName(fmt.Sprintf("%s-set", role.Name), settings))
 	spec.Add("selector", newSelector(role, settings))
 	spec.Add("template", podTemplate)
 	// "updateStrategy" is new in kube 1.7, so we don't add anything to non-helm configs
@@ -73,7 +73,7 @@ func getVolumeClaims(role *model.InstanceGroup, createHelmChart bool) []helm.Nod
 	for _, volume := range role.Run.Volumes {
 		var accessMode string
 		switch volume.Type {
-		case model.VolumeTypeHost, model.VolumeTypeNone, model.VolumeTypeEmptyDir:
+		case model.VolumeTypeHost, model.VolumeTypeNone, model.VolumeTypeEmptyDir, model.VolumeTypeSharedSocket:
 			// These volume types don't have claims
 			continue
 		case model.VolumeTypePersistent:
@@ -9,7 +9,7 @@ import (
 	"code.cloudfoundry.org/fissile/helm"
 	"code.cloudfoundry.org/fissile/model"
 	"code.cloudfoundry.org/fissile/model/loader"
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -173,7 +173,7 @@ func TestStatefulSetPorts(t *testing.T) {
 								name: https
 								containerPort: 443
 	`
-	testhelpers.IsYAMLSubsetString(assert.New(t), expected, actual)
+	yamltest.IsYAMLSubsetString(assert.New(t), expected, actual)
 }
 
 // TestStatefulSetServices checks that the services associated with a service
@@ -229,7 +229,7 @@ func TestStatefulSetServices(t *testing.T) {
 								panic("Unexpected style " + style)
 							}
 							require.NoError(t, err)
-							testhelpers.IsYAMLEqualString(assert.New(t), `---
+							yamltest.IsYAMLEqualString(assert.New(t), `---
 							apiVersion: v1
 							kind: Service
 							metadata:
@@ -265,7 +265,7 @@ func TestStatefulSetServices(t *testing.T) {
 								panic("Unexpected style " + style)
 							}
 							require.NoError(t, err)
-							testhelpers.IsYAMLEqualString(assert.New(t), `---
+							yamltest.IsYAMLEqualString(assert.New(t), `---
 							apiVersion: v1
 							kind: Service
 							metadata:
@@ -301,7 +301,7 @@ func TestStatefulSetServices(t *testing.T) {
 								panic("Unexpected style " + style)
 							}
 							require.NoError(t, err)
-							testhelpers.IsYAMLEqualString(assert.New(t), `---
+							yamltest.IsYAMLEqualString(assert.New(t), `---
 							apiVersion: v1
 							kind: Service
 							metadata:
@@ -332,7 +332,7 @@ func TestStatefulSetServices(t *testing.T) {
 								panic("Unexpected style " + style)
 							}
 							require.NoError(t, err)
-							testhelpers.IsYAMLEqualString(assert.New(t), `---
+							yamltest.IsYAMLEqualString(assert.New(t), `---
 							apiVersion: v1
 							kind: Service
 							metadata:
@@ -391,7 +391,7 @@ func TestStatefulSetStartupPolicy(t *testing.T) {
 					spec:
 						podManagementPolicy: %s
 					`
-					testhelpers.IsYAMLSubsetString(assert.New(t), fmt.Sprintf(expected, policy), actual)
+					yamltest.IsYAMLSubsetString(assert.New(t), fmt.Sprintf(expected, policy), actual)
 				})
 
 				t.Run("helm", func(t *testing.T) {
@@ -404,6 +404,7 @@ func TestStatefulSetStartupPolicy(t *testing.T) {
 					actual, err := RoundtripNode(statefulset, map[string]interface{}{
 						"Values.sizing.myrole.count":                        "1",
 						"Values.sizing.myrole.affinity":                     map[string]interface{}{},
+						"Values.sizing.myrole.debug.command":                nil,
 						"Values.sizing.myrole.disk_sizes.persistent_volume": 1,
 					})
 					require.NoError(t, err)
@@ -411,7 +412,7 @@ func TestStatefulSetStartupPolicy(t *testing.T) {
 					spec:
 						podManagementPolicy: %s
 					`
-					testhelpers.IsYAMLSubsetString(assert.New(t), fmt.Sprintf(expected, policy), actual)
+					yamltest.IsYAMLSubsetString(assert.New(t), fmt.Sprintf(expected, policy), actual)
 				})
 			})
 		}(policy, tags)
@@ -502,7 +503,7 @@ func TestStatefulSetVolumesKube(t *testing.T) {
 							requests:
 								storage: 40G
 	`
-	testhelpers.IsYAMLSubsetString(assert, expected, actual)
+	yamltest.IsYAMLSubsetString(assert, expected, actual)
 }
 
 func TestStatefulSetVolumesWithAnnotationKube(t *testing.T) {
@@ -590,7 +591,7 @@ func TestStatefulSetVolumesWithAnnotationKube(t *testing.T) {
 							requests:
 								storage: 40G
 	`
-	testhelpers.IsYAMLSubsetString(assert, expected, actual)
+	yamltest.IsYAMLSubsetString(assert, expected, actual)
 }
 
 func TestStatefulSetVolumesHelm(t *testing.T) {
@@ -617,6 +618,7 @@ func TestStatefulSetVolumesHelm(t *testing.T) {
 		"Values.kube.storage_class.persistent":              "persistent",
 		"Values.kube.storage_class.shared":                  "shared",
 		"Values.sizing.myrole.affinity":                     map[string]interface{}{},
+		"Values.sizing.myrole.debug.command":                nil,
 		"Values.sizing.myrole.count":                        "1",
 		"Values.sizing.myrole.disk_sizes.persistent_volume": "5",
 		"Values.sizing.myrole.disk_sizes.shared_volume":     "40",
@@ -691,7 +693,7 @@ func TestStatefulSetVolumesHelm(t *testing.T) {
 							requests:
 								storage: 40G
 	`
-	testhelpers.IsYAMLSubsetString(assert, expected, actual)
+	yamltest.IsYAMLSubsetString(assert, expected, actual)
 
 	// Check that not having hostpath disables the hostpath volume
 	overrides := map[string]interface{}{
@@ -700,6 +702,7 @@ func TestStatefulSetVolumesHelm(t *testing.T) {
 		"Values.kube.registry.hostname":                     "",
 		"Values.kube.storage_class.persistent":              "persistent",
 		"Values.sizing.myrole.affinity":                     map[string]interface{}{},
+		"Values.sizing.myrole.debug.command":                nil,
 		"Values.sizing.myrole.count":                        "1",
 		"Values.sizing.myrole.disk_sizes.persistent_volume": "5",
 	}
@@ -715,6 +718,61 @@ func TestStatefulSetVolumesHelm(t *testing.T) {
 	assert.Equal("deployment-manifest", volumes.([]interface{})[0].(map[interface{}]interface{})["name"])
 }
 
+func TestStatefulSetNamePrefixHelm(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifest(assert, "volumes.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	statefulset, _, err := NewStatefulSet(role, ExportSettings{
+		Opinions:        model.NewEmptyOpinions(),
+		CreateHelmChart: true,
+	}, nil)
+	require.NoError(t, err)
+
+	config := map[string]interface{}{
+		"Values.name_prefix.enabled":                        true,
+		"Values.name_prefix.prefix":                         "blue",
+		"Values.env.ALL_VAR":                                "",
+		"Values.kube.hostpath_available":                    false,
+		"Values.kube.registry.username":                     "user",
+		"Values.kube.storage_class.persistent":              "persistent",
+		"Values.kube.storage_class.shared":                  "shared",
+		"Values.sizing.myrole.affinity":                     map[string]interface{}{},
+		"Values.sizing.myrole.debug.command":                nil,
+		"Values.sizing.myrole.count":                        "1",
+		"Values.sizing.myrole.disk_sizes.persistent_volume": "5",
+		"Values.sizing.myrole.disk_sizes.shared_volume":     "40",
+	}
+
+	actual, err := RoundtripNode(statefulset, config)
+	require.NoError(t, err)
+
+	yamltest.IsYAMLSubsetString(assert, `---
+		metadata:
+			name: blue-myrole
+			labels:
+				app.kubernetes.io/component: myrole
+		spec:
+			serviceName: blue-myrole-set
+			selector:
+				matchLabels:
+					app.kubernetes.io/instance: MyRelease
+					skiff-role-name: myrole
+			template:
+				spec:
+					imagePullSecrets:
+					-	name: blue-registry-credentials
+					volumes:
+					-	name: deployment-manifest
+						secret:
+							secretName: blue-deployment-manifest
+	`, actual)
+}
+
 func TestStatefulSetEmptyDirVolumesKube(t *testing.T) {
 	assert := assert.New(t)
 
@@ -799,5 +857,48 @@ func TestStatefulSetEmptyDirVolumesKube(t *testing.T) {
 							requests:
 								storage: 5G
 	`
-	testhelpers.IsYAMLSubsetString(assert, expected, actual)
+	yamltest.IsYAMLSubsetString(assert, expected, actual)
+}
+
+func TestStatefulSetZoneReplicaKube(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, role := statefulSetTestLoadManifest(assert, "exposed-ports.yml")
+	if manifest == nil || role == nil {
+		return
+	}
+
+	replica := role.ZoneReplica(&model.InstanceGroupZone{Name: "z1", Label: "us-east-1a"})
+	statefulset, _, err := NewStatefulSet(replica, ExportSettings{
+		Opinions: model.NewEmptyOpinions(),
+	}, nil)
+	if !assert.NoError(err) {
+		return
+	}
+
+	actual, err := RoundtripKube(statefulset)
+	if !assert.NoError(err) {
+		return
+	}
+
+	expected := `---
+		metadata:
+			name: myrole-z1
+		spec:
+			template:
+				metadata:
+					labels:
+						app.kubernetes.io/component: myrole-z1
+				spec:
+					containers:
+					-
+						name: myrole-z1
+					nodeSelector:
+						failure-domain.beta.kubernetes.io/zone: us-east-1a
+	`
+	yamltest.IsYAMLSubsetString(assert, expected, actual)
+
+	// The replica uses the image of the instance group it replicates
+	image := statefulset.Get("spec", "template", "spec", "containers").Values()[0].Get("image").String()
+	assert.Regexp(`^myrole:[0-9a-f]+$`, image)
 }
@@ -0,0 +1,85 @@
+package kube
+
+import (
+	"bytes"
+	"sort"
+
+	"code.cloudfoundry.org/fissile/helm"
+)
+
+// StreamOutputStdout is the stream output path writing to standard output
+const StreamOutputStdout = "-"
+
+// streamKindOrder ranks the resource kinds of a single YAML stream, so that
+// `kubectl apply -f -` creates the resources before the ones depending on
+// them. Kinds not listed are ranked with the workloads.
+var streamKindOrder = map[string]int{
+	"Namespace":          0,
+	"ResourceQuota":      0,
+	"LimitRange":         0,
+	"ServiceAccount":     1,
+	"Role":               1,
+	"ClusterRole":        1,
+	"RoleBinding":        1,
+	"ClusterRoleBinding": 1,
+	"PodSecurityPolicy":  1,
+	"Secret":             2,
+	"ConfigMap":          2,
+	"PersistentVolume":   2,
+	"Service":            3,
+	"Job":                5,
+	"Pod":                5,
+}
+
+// streamWorkloadOrder is the rank of the workloads, and of unknown kinds
+const streamWorkloadOrder = 4
+
+// StreamDocument is a single document of a YAML stream
+type StreamDocument struct {
+	Kind    string
+	Content []byte
+}
+
+// NodeKind returns the resource kind of a document root. The kind of a list
+// is the kind of its items.
+func NodeKind(node helm.Node) string {
+	kind, ok := node.Get("kind").(*helm.Scalar)
+	if !ok {
+		return ""
+	}
+	if kind.String() == "List" {
+		if items, ok := node.Get("items").(*helm.List); ok && len(items.Values()) > 0 {
+			return NodeKind(items.Values()[0])
+		}
+	}
+	return kind.String()
+}
+
+// MakeStream concatenates the documents into a single multi-document YAML
+// stream, in dependency order: namespaces, RBAC resources, secrets,
+// services, workloads and finally errands. Documents of the same rank keep
+// the order they were generated in.
+func MakeStream(documents []StreamDocument) []byte {
+	sorted := append([]StreamDocument{}, documents...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return streamRank(sorted[i].Kind) < streamRank(sorted[j].Kind)
+	})
+
+	var stream bytes.Buffer
+	for _, document := range sorted {
+		content := bytes.TrimSpace(document.Content)
+		if !bytes.HasPrefix(content, []byte("---")) {
+			stream.WriteString("---\n")
+		}
+		stream.Write(content)
+		stream.WriteString("\n")
+	}
+	return stream.Bytes()
+}
+
+func streamRank(kind string) int {
+	if rank, ok := streamKindOrder[kind]; ok {
+		return rank
+	}
+	return streamWorkloadOrder
+}
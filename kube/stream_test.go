@@ -0,0 +1,53 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeKind(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "StatefulSet", NodeKind(newTypeMeta("apps/v1", "StatefulSet")))
+
+	list := newTypeMeta("v1", "List")
+	list.Add("items", helm.NewList(newTypeMeta("v1", "Service")))
+	assert.Equal(t, "Service", NodeKind(list))
+
+	assert.Equal(t, "", NodeKind(helm.NewMapping("name", "value")))
+}
+
+func TestMakeStream(t *testing.T) {
+	t.Parallel()
+
+	stream := MakeStream([]StreamDocument{
+		{Kind: "Job", Content: []byte("---\nkind: Job\n")},
+		{Kind: "StatefulSet", Content: []byte("---\n# The first role\nkind: StatefulSet\nname: first\n")},
+		{Kind: "Service", Content: []byte("---\nkind: List\n")},
+		{Kind: "StatefulSet", Content: []byte("kind: StatefulSet\nname: second\n\n")},
+		{Kind: "Secret", Content: []byte("---\nkind: Secret\n")},
+		{Kind: "ClusterRole", Content: []byte("---\nkind: ClusterRole\n")},
+		{Kind: "Namespace", Content: []byte("---\nkind: Namespace\n")},
+	})
+
+	assert.Equal(t, `---
+kind: Namespace
+---
+kind: ClusterRole
+---
+kind: Secret
+---
+kind: List
+---
+# The first role
+kind: StatefulSet
+name: first
+---
+kind: StatefulSet
+name: second
+---
+kind: Job
+`, string(stream))
+}
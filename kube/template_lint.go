@@ -0,0 +1,59 @@
+package kube
+
+import (
+	"text/template"
+
+	"code.cloudfoundry.org/fissile/helm"
+)
+
+// TemplateLinter checks the templates of a helm chart for the errors helm
+// would only report on install: expressions that don't parse, functions helm
+// doesn't provide, and expressions failing with the default values of the
+// chart, e.g. because of mismatched types. The templates are rendered like by
+// a Renderer, but the checks of the chart (`fail` and `required`) pass, as
+// the default values lack the required ones.
+type TemplateLinter struct {
+	renderer *Renderer
+}
+
+// NewTemplateLinter returns a linter for the templates generated with the
+// given settings
+func NewTemplateLinter(settings ExportSettings) (*TemplateLinter, error) {
+	settings.Render = &RenderOptions{
+		Values:       map[string]interface{}{},
+		ReleaseName:  "lint",
+		Namespace:    "default",
+		ChartName:    settings.ChartName,
+		ChartVersion: settings.ChartVersion,
+	}
+	renderer, err := NewRenderer(settings)
+	if err != nil {
+		return nil, err
+	}
+	renderer.template.Funcs(template.FuncMap{
+		"fail":     lintFail,
+		"required": lintRequired,
+	})
+	return &TemplateLinter{renderer: renderer}, nil
+}
+
+// Lint parses and executes the nodes of the named template file, e.g.
+// "templates/secrets.yaml". The errors name the file, and the line within
+// it. The file remains available to later templates including it.
+func (l *TemplateLinter) Lint(name string, nodes ...helm.Node) error {
+	_, err := l.renderer.Render(name, nodes...)
+	return err
+}
+
+func lintFail(msg string) (string, error) {
+	return "", nil
+}
+
+// lintRequired passes missing values on as empty strings, so that the
+// expressions using them are checked as well
+func lintRequired(msg string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return "", nil
+	}
+	return v, nil
+}
@@ -0,0 +1,49 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateLinter(t *testing.T) {
+	t.Parallel()
+
+	settings := renderTestSettings(t, nil)
+	settings.Render = nil
+	linter, err := NewTemplateLinter(settings)
+	require.NoError(t, err)
+
+	t.Run("Secrets", func(t *testing.T) {
+		// The required secret is missing from the default values
+		secrets := renderTestSecrets(t, settings)
+		assert.NoError(t, linter.Lint("templates/secrets.yaml", secrets))
+	})
+
+	t.Run("Checks", func(t *testing.T) {
+		node := helm.NewMapping("kind", "ConfigMap")
+		node.Add("data", helm.NewMapping("value", `{{ required "needed" .Values.missing | b64enc | quote }}`))
+		node.Add("check", `{{ fail "always" }}`)
+		assert.NoError(t, linter.Lint("templates/checks.yaml", node))
+	})
+
+	t.Run("UnknownFunction", func(t *testing.T) {
+		node := helm.NewMapping("kind", "ConfigMap")
+		node.Add("data", helm.NewMapping("value", `{{ .Values.kube.registry.hostname | toUpperCase }}`))
+		err := linter.Lint("templates/unknown.yaml", node)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), `template: templates/unknown.yaml:4: function "toUpperCase" not defined`)
+		}
+	})
+
+	t.Run("InvalidExpression", func(t *testing.T) {
+		node := helm.NewMapping("kind", "ConfigMap")
+		node.Add("data", helm.NewMapping("value", `{{ index .Values.kube.registry.hostname 0 1 }}`))
+		err := linter.Lint("templates/invalid.yaml", node)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "template: templates/invalid.yaml:4:")
+		}
+	})
+}
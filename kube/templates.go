@@ -18,6 +18,18 @@ func GetHelmTemplateHelpers() []helm.Node {
 		`    {{- end }}`,
 		`{{ end }}`,
 	}
+	namePrefixHelper := []string{
+		`{{ define "fissile.NamePrefix" }}`,
+		`    {{- with .Values.name_prefix }}`,
+		`        {{- if .enabled }}{{ default $.Release.Name .prefix }}-{{ end }}`,
+		`    {{- end }}`,
+		`{{ end }}`,
+	}
+	nameHelper := []string{
+		`{{ define "fissile.Name" }}`,
+		`    {{- template "fissile.SanitizeName" (printf "%s%s" (include "fissile.NamePrefix" (index . 0)) (index . 1)) }}`,
+		`{{ end }}`,
+	}
 	return []helm.Node{
 		helm.NewNode(
 			strings.Join(sanitizeNameHelper, ""),
@@ -25,5 +37,19 @@ func GetHelmTemplateHelpers() []helm.Node {
 				fissile.SanitizeName returns the given parameter, up to 63 characters long.
 				This should be called as {{ template "fissile.SanitizeName" "foo" }}
 				`)),
+		helm.NewNode(
+			strings.Join(namePrefixHelper, ""),
+			helm.Comment(`
+				fissile.NamePrefix returns the prefix of the names of the resources, including
+				the separator, if .Values.name_prefix.enabled is set. It defaults to the release name.
+				This should be called as {{ include "fissile.NamePrefix" $ }}
+				`)),
+		helm.NewNode(
+			strings.Join(nameHelper, ""),
+			helm.Comment(`
+				fissile.Name returns the name of a resource of the chart, with the name prefix,
+				up to 63 characters long.
+				This should be called as {{ template "fissile.Name" (list $ "foo") }}
+				`)),
 	}
 }
@@ -38,4 +38,49 @@ func TestGetHelmTemplateHelpers(t *testing.T) {
 			}(testcase.length, testcase.expected)
 		}
 	})
+
+	t.Run("fissile.Name", func(t *testing.T) {
+		for _, testcase := range []struct {
+			description string
+			config      map[string]interface{}
+			expected    string
+		}{
+			{
+				description: "disabled",
+				expected:    "foo",
+			},
+			{
+				description: "release name",
+				config:      map[string]interface{}{"Values.name_prefix.enabled": true},
+				expected:    "MyRelease-foo",
+			},
+			{
+				description: "custom prefix",
+				config: map[string]interface{}{
+					"Values.name_prefix.enabled": true,
+					"Values.name_prefix.prefix":  "blue",
+				},
+				expected: "blue-foo",
+			},
+			{
+				description: "long prefix",
+				config: map[string]interface{}{
+					"Values.name_prefix.enabled": true,
+					"Values.name_prefix.prefix":  strings.Repeat("a", 60),
+				},
+				expected: strings.Repeat("a", 54) + "-7502e7c3",
+			},
+		} {
+			func(description string, config map[string]interface{}, expected string) {
+				t.Run(description, func(t *testing.T) {
+					t.Parallel()
+					node := helm.NewNode(`{{ template "fissile.Name" (list $ "foo") }}`)
+					rendered, err := RoundtripNode(node, config)
+					if assert.NoError(t, err) {
+						assert.Equal(t, expected, rendered.(string))
+					}
+				})
+			}(testcase.description, testcase.config, testcase.expected)
+		}
+	})
 }
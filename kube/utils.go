@@ -2,6 +2,7 @@ package kube
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"code.cloudfoundry.org/fissile/helm"
@@ -18,8 +19,17 @@ const (
 	AppVersionLabel = "version"
 	// VolumeStorageClassAnnotation is the annotation label for storage/v1beta1/StorageClass
 	VolumeStorageClassAnnotation = "volume.beta.kubernetes.io/storage-class"
+	// ZoneLabel is the well-known node label naming the availability zone of the node
+	ZoneLabel = "failure-domain.beta.kubernetes.io/zone"
+	// DefaultClusterDomain is the cluster domain of helm charts unless
+	// env.KUBERNETES_CLUSTER_DOMAIN is set
+	DefaultClusterDomain = "cluster.local"
 )
 
+// clusterDomainValue is the template of the cluster domain in helm charts; it
+// is also used in quoted strings, and so uses a raw string
+var clusterDomainValue = fmt.Sprintf("(default `%s` .Values.env.KUBERNETES_CLUSTER_DOMAIN)", DefaultClusterDomain)
+
 func newTypeMeta(apiVersion, kind string, modifiers ...helm.NodeModifier) *helm.Mapping {
 	mapping := helm.NewMapping("apiVersion", apiVersion, "kind", kind)
 	mapping.Set(modifiers...)
@@ -34,18 +44,56 @@ func newSelector(role *model.InstanceGroup, settings ExportSettings) *helm.Mappi
 		matchLabels.Add(AppVersionLabel, `{{ default .Chart.Version .Chart.AppVersion | quote }}`, helm.Block("if .Values.config.use_istio"))
 	}
 
+	addInstanceSelector(matchLabels, settings)
+
 	meta := helm.NewMapping("matchLabels", matchLabels)
 
 	return meta
 }
 
+// addInstanceSelector restricts a selector to the resources of the release
+// when the names are prefixed, as other releases in the namespace then use
+// the same component labels
+func addInstanceSelector(selector *helm.Mapping, settings ExportSettings) {
+	if settings.CreateHelmChart {
+		selector.Add("app.kubernetes.io/instance", `{{ .Release.Name | quote }}`, helm.Block("if .Values.name_prefix.enabled"))
+	}
+}
+
+// resourceName returns a reference to the name of a resource of the chart.
+// For helm charts, it is prefixed when .Values.name_prefix.enabled is set, so
+// that several releases can be installed into the same namespace.
+func resourceName(name string, settings ExportSettings) string {
+	if !settings.CreateHelmChart {
+		return name
+	}
+	return resourceNameExpression(strconv.Quote(name))
+}
+
+// resourceNameExpression returns a reference to the name of a resource of a
+// helm chart, where the name is given as a template pipeline.
+func resourceNameExpression(pipeline string) string {
+	return fmt.Sprintf(`{{ template "fissile.Name" (list $ %s) }}`, pipeline)
+}
+
+// serviceAccountName returns a reference to a service account; the default
+// service account of the namespace is not created by the chart.
+func serviceAccountName(name string, settings ExportSettings) string {
+	if name == "default" {
+		return name
+	}
+	return resourceName(name, settings)
+}
+
 // ConfigBuilder sets up a generic Kube resource structure with minimal metadata.
 type ConfigBuilder struct {
 	settings   *ExportSettings
 	apiVersion string
 	kind       string
 	name       string
-	modifiers  []helm.NodeModifier
+	// nameIsExpression is set if the name is a helm template expression
+	nameIsExpression bool
+	modifiers        []helm.NodeModifier
 
 	err error
 }
@@ -104,6 +152,7 @@ func (b *ConfigBuilder) SetName(name string) *ConfigBuilder {
 		b.setError(fmt.Errorf("kube name exceeds 63 characters"))
 	}
 	b.name = name
+	b.nameIsExpression = false
 	return b
 }
 
@@ -124,6 +173,7 @@ func (b *ConfigBuilder) SetNameHelmExpression(name string) *ConfigBuilder {
 		b.setError(fmt.Errorf(`name "%q" contains new line characters`, name))
 	}
 	b.name = name
+	b.nameIsExpression = true
 	return b
 }
 
@@ -173,7 +223,11 @@ func (b *ConfigBuilder) Build() (*helm.Mapping, error) {
 	}
 
 	config := newTypeMeta(b.apiVersion, b.kind, b.modifiers...)
-	config.Add("metadata", helm.NewMapping("name", b.name, "labels", labels))
+	name := b.name
+	if b.settings.CreateHelmChart && !b.nameIsExpression {
+		name = resourceName(name, *b.settings)
+	}
+	config.Add("metadata", helm.NewMapping("name", name, "labels", labels))
 
 	return config, nil
 }
@@ -208,7 +262,13 @@ func MakeBasicValues() *helm.Mapping {
 				"username", "",
 				"password", ""),
 			"organization", "",
+			"pin_image_digests", helm.NewNode(true, helm.Comment("Reference the role images by digest, if the chart was generated with image digests; disable to use the image tags for development")),
 			"auth", nil,
+			"service_account_annotations", helm.NewNode(helm.NewMapping(), helm.Comment(strings.Join(strings.Fields(`
+				Annotations of the service accounts, by account name, e.g.
+				eks.amazonaws.com/role-arn for EKS IAM roles for service accounts, or
+				iam.gke.io/gcp-service-account for GKE workload identity
+			`), " "))),
 			"limits", helm.NewMapping(
 				"nproc", helm.NewMapping(
 					"hard", "2048",
@@ -227,11 +287,48 @@ func MakeBasicValues() *helm.Mapping {
 				"requests", helm.NewNode(false, helm.Comment("Flag to activate cpu requests")),
 				"limits", helm.NewNode(false, helm.Comment("Flag to activate cpu limits")),
 			), helm.Comment("Global CPU configuration")),
-			"use_istio", helm.NewNode(false, helm.Comment("Flag to specify whether to add Istio related annotations and labels"))),
+			"use_istio", helm.NewNode(false, helm.Comment("Flag to specify whether to add Istio related annotations and labels, and the Istio routing resources of istio-managed instance groups")),
+			"prometheus", helm.NewMapping(
+				"enabled", helm.NewNode(false, helm.Comment("Create the ServiceMonitors and PodMonitors of the Prometheus operator for the instance groups with monitoring; requires the operator in the cluster"))),
+			"drop_all_capabilities", helm.NewNode(false, helm.Comment("Flag to drop all capabilities not added explicitly, for instance groups without drop-capabilities"))),
 		"bosh", helm.NewMapping("instance_groups", helm.NewList()),
+		"properties", helm.NewNode(helm.NewMapping(), helm.Comment(strings.Join(strings.Fields(`
+			BOSH job properties to override, as properties.<instance group>.<job>.<property>.
+			These take precedence over the opinions built into the images.
+		`), " "))),
 		"env", helm.NewMapping(),
 		"sizing", helm.NewMapping(),
 		"secrets", helm.NewMapping(),
+		"image_prepull", helm.NewMapping(
+			"enabled", helm.NewNode(false, helm.Comment("Create a DaemonSet pulling the images of all instance groups onto every node before installs and upgrades")),
+			"pause_image", helm.NewNode("k8s.gcr.io/pause:3.1", helm.Comment("Image of the container keeping the pre-pull pods running")),
+			"image", helm.NewNode("bitnami/kubectl:1.14", helm.Comment("Image of the job waiting for the images to be pulled; it must provide kubectl")),
+			"timeout", helm.NewNode(600, helm.Comment("Seconds the job waits for the images to be pulled before the release continues"))),
+		"resource_quota", helm.NewMapping(
+			"enabled", helm.NewNode(false, helm.Comment("Create a ResourceQuota and LimitRange for the namespace, sized from the sizing values")),
+			"headroom", helm.NewNode(20, helm.Comment("Percentage added to the totals of the quota")),
+			"defaults", helm.NewNode(helm.NewMapping(
+				"memory", helm.NewMapping("request", 256, "limit", 1024),
+				"cpu", helm.NewMapping("request", 100, "limit", 2000),
+			), helm.Comment("Requests and limits of containers without sizing values; memory in MiB, cpu in millicores"))),
+		"vertical_pod_autoscaler", helm.NewMapping(
+			"enabled", helm.NewNode(false, helm.Comment("Create a VerticalPodAutoscaler in recommendation mode for every instance group, to collect sizing recommendations; requires the VPA components in the cluster"))),
+		"network_policies", helm.NewMapping(
+			"enabled", helm.NewNode(false, helm.Comment("Create a NetworkPolicy for every instance group, restricting the ingress of its private ports to the instance groups consuming its links"))),
+		"verification", helm.NewMapping(
+			"enabled", helm.NewNode(false, helm.Comment("Run a job after installs and upgrades that fails the release unless all instance groups become ready")),
+			"timeout", helm.NewNode(600, helm.Comment("Time in seconds for the deployment to converge")),
+			"image", helm.NewNode("bitnami/kubectl:1.14", helm.Comment("Image of the verification job; it must provide kubectl and curl")),
+			"endpoints", helm.NewNode(helm.NewList(), helm.Comment("URLs that must respond successfully, e.g. http://router:8080/health"))),
+		"grafana_dashboard", helm.NewMapping(
+			"enabled", helm.NewNode(false, helm.Comment("Create a ConfigMap with a Grafana dashboard of the instance groups, labelled for the dashboard loader sidecar of Grafana")),
+			"datasource", helm.NewNode("Prometheus", helm.Comment("Name of the Prometheus data source of the dashboard"))),
+		"migration", helm.NewMapping(
+			"image", helm.NewNode("bitnami/kubectl:1.14", helm.Comment("Image of the job migrating the volumes of renamed instance groups before upgrades; it must provide kubectl"))),
+		"name_prefix", helm.NewMapping(
+			"enabled", helm.NewNode(false, helm.Comment("Prefix the names of all resources, so that several releases can be installed into the same namespace")),
+			"prefix", helm.NewNode("", helm.Comment("Prefix of the names; defaults to the release name"))),
+		"extensions", makeExtensionValues(),
 		"services", helm.NewMapping("loadbalanced", false),
 		"ingress", helm.NewMapping("enabled", false))
 }
@@ -6,7 +6,7 @@ import (
 
 	"code.cloudfoundry.org/fissile/helm"
 	"code.cloudfoundry.org/fissile/model"
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -20,7 +20,7 @@ func TestNewTypeMeta(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "the-api-version"
 		kind: "thekind"
 	`, actual)
@@ -42,7 +42,7 @@ func TestNewSelector(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		matchLabels:
 			skiff-role-name: "thename"
 	`, actual)
@@ -67,7 +67,7 @@ func TestNewSelectorIstioManaged(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		matchLabels:
 			skiff-role-name: "thename"
 			app: "thename"
@@ -93,7 +93,7 @@ func TestNewKubeConfig(t *testing.T) {
 	if !assert.NoError(err) {
 		return
 	}
-	testhelpers.IsYAMLEqualString(assert, `---
+	yamltest.IsYAMLEqualString(assert, `---
 		apiVersion: "theApiVersion"
 		kind: "thekind"
 		metadata:
@@ -159,6 +159,6 @@ func TestMinKubeVersion(t *testing.T) {
 		if !assert.NoError(err) {
 			return
 		}
-		testhelpers.IsYAMLEqualString(assert, testcase.Result, actual)
+		yamltest.IsYAMLEqualString(assert, testcase.Result, actual)
 	}
 }
@@ -23,6 +23,31 @@ func formattedExample(example string) string {
 	return example
 }
 
+// makeJobValues returns the default job settings of a bosh-task instance
+// group; tasks running as bare pods only have a deadline and a restart policy.
+func makeJobValues(instanceGroup *model.InstanceGroup) *helm.Mapping {
+	fields := []string{"activeDeadlineSeconds", "backoffLimit", "ttlSecondsAfterFinished"}
+	if instanceGroup.HasTag(model.RoleTagStopOnFailure) {
+		fields = fields[:1]
+	}
+	values := helm.NewMapping()
+	all := jobSettings(instanceGroup)
+	for _, field := range fields {
+		setting := all[field]
+		if setting.value == nil {
+			values.Add(setting.key, nil)
+		} else {
+			values.Add(setting.key, *setting.value)
+		}
+	}
+	var restartPolicy interface{}
+	if instanceGroup.Run.Job != nil && instanceGroup.Run.Job.RestartPolicy != "" {
+		restartPolicy = instanceGroup.Run.Job.RestartPolicy
+	}
+	values.Add("restart_policy", restartPolicy)
+	return values
+}
+
 // MakeValues returns a Mapping with all default values for the Helm chart.
 func MakeValues(settings ExportSettings) helm.Node {
 	values := MakeBasicValues()
@@ -39,6 +64,10 @@ func MakeValues(settings ExportSettings) helm.Node {
 		if cv.CVOptions.Immutable && cv.Type != "" {
 			continue
 		}
+		// Secrets of the external secret store are not values of the chart
+		if cv.CVOptions.Secret && isExternalSecret(cv, settings.RoleManifest) {
+			continue
+		}
 
 		var value interface{}
 		if !cv.CVOptions.Secret || cv.Type == "" {
@@ -48,6 +77,9 @@ func MakeValues(settings ExportSettings) helm.Node {
 			}
 		}
 		comment := cv.CVOptions.Description
+		if cv.CVOptions.Deprecated != nil {
+			comment += "\n" + cv.CVOptions.Deprecated.Notice(name) + "."
+		}
 		if cv.CVOptions.Secret {
 			thisValue := "This value"
 			if cv.Type != "" {
@@ -57,6 +89,10 @@ func MakeValues(settings ExportSettings) helm.Node {
 			if cv.CVOptions.Immutable {
 				comment += "\n" + thisValue + " is immutable and must not be changed once set."
 			}
+			if cv.CVOptions.File != "" {
+				comment += fmt.Sprintf("\n%s is binary and must be base64-encoded; it defaults to the contents of %s.",
+					thisValue, cv.CVOptions.File)
+			}
 			if cv.Type == "certificate" && !cv.CVOptions.IsCA {
 				comment += "\nThis certificate uses the "
 				if cv.CVOptions.RoleName != "" {
@@ -83,6 +119,10 @@ func MakeValues(settings ExportSettings) helm.Node {
 				generated.Add(name, helm.NewNode(value, helm.Comment(comment)))
 			}
 		} else {
+			if cv.CVOptions.URLTemplate != "" {
+				comment += fmt.Sprintf("\nIt defaults to %s, with the service name qualified by the namespace and the cluster domain.",
+					cv.CVOptions.URLTemplate)
+			}
 			comment += formattedExample(cv.CVOptions.Example)
 			env.Add(name, helm.NewNode(value, helm.Comment(comment)))
 		}
@@ -103,117 +143,7 @@ func MakeValues(settings ExportSettings) helm.Node {
 			continue
 		}
 
-		entry := helm.NewMapping()
-
-		var comment string
-		it := fmt.Sprintf("The %s instance group", makeVarName(instanceGroup.Name))
-
-		var feature string
-		enabled := "enabled"
-
-		if instanceGroup.IfFeature != "" {
-			feature = instanceGroup.IfFeature
-		} else if instanceGroup.DefaultFeature != "" {
-			feature = instanceGroup.DefaultFeature
-		} else if instanceGroup.UnlessFeature != "" {
-			feature = instanceGroup.UnlessFeature
-			enabled = "disabled"
-		}
-		if feature != "" {
-			canBe := "can be"
-			if settings.RoleManifest.Features[feature] {
-				canBe = "is"
-			}
-			comment = fmt.Sprintf("%s %s %s by the %s feature.\n", it, canBe, enabled, feature)
-			it = "It"
-		}
-
-		if instanceGroup.Run.Scaling.Min == instanceGroup.Run.Scaling.Max {
-			comment += fmt.Sprintf("%s cannot be scaled.", it)
-		} else {
-			comment += fmt.Sprintf("%s can scale between %d and %d instances.",
-				it, instanceGroup.Run.Scaling.Min, instanceGroup.Run.Scaling.Max)
-
-			if instanceGroup.Run.Scaling.MustBeOdd {
-				comment += "\nThe instance count must be an odd number (not divisible by 2)."
-			}
-			if instanceGroup.Run.Scaling.HA != instanceGroup.Run.Scaling.Min {
-				comment += fmt.Sprintf("\nFor high availability it needs at least %d instances.",
-					instanceGroup.Run.Scaling.HA)
-			}
-		}
-		entry.Add("count", nil, helm.Comment(comment))
-		if settings.UseMemoryLimits {
-			var request helm.Node
-			if instanceGroup.Run.Memory.Request == nil {
-				request = helm.NewNode(nil)
-			} else {
-				request = helm.NewNode(int(*instanceGroup.Run.Memory.Request))
-			}
-			var limit helm.Node
-			if instanceGroup.Run.Memory.Limit == nil {
-				limit = helm.NewNode(nil)
-			} else {
-				limit = helm.NewNode(int(*instanceGroup.Run.Memory.Limit))
-			}
-
-			entry.Add("memory", helm.NewMapping(
-				"request", request,
-				"limit", limit),
-				helm.Comment("Unit [MiB]"))
-		}
-		if settings.UseCPULimits {
-			var request helm.Node
-			if instanceGroup.Run.CPU.Request == nil {
-				request = helm.NewNode(nil)
-			} else {
-				request = helm.NewNode(1000. * *instanceGroup.Run.CPU.Request)
-			}
-			var limit helm.Node
-			if instanceGroup.Run.CPU.Limit == nil {
-				limit = helm.NewNode(nil)
-			} else {
-				limit = helm.NewNode(1000. * *instanceGroup.Run.CPU.Limit)
-			}
-
-			entry.Add("cpu", helm.NewMapping(
-				"request", request,
-				"limit", limit),
-				helm.Comment("Unit [millicore]"))
-		}
-
-		diskSizes := helm.NewMapping()
-		for _, volume := range instanceGroup.Run.Volumes {
-			switch volume.Type {
-			case model.VolumeTypePersistent, model.VolumeTypeShared:
-				diskSizes.Add(makeVarName(volume.Tag), volume.Size)
-			}
-		}
-		if len(diskSizes.Names()) > 0 {
-			entry.Add("disk_sizes", diskSizes.Sort())
-		}
-		ports := helm.NewMapping()
-		for _, job := range instanceGroup.JobReferences {
-			for _, port := range job.ContainerProperties.BoshContainerization.Ports {
-				config := helm.NewMapping()
-				if port.PortIsConfigurable {
-					config.Add("port", port.ExternalPort)
-				}
-				if port.CountIsConfigurable {
-					config.Add("count", port.Count)
-				}
-				if len(config.Names()) > 0 {
-					ports.Add(makeVarName(port.Name), config)
-				}
-			}
-		}
-		if len(ports.Names()) > 0 {
-			entry.Add("ports", ports.Sort())
-		}
-
-		entry.Add("affinity", helm.NewMapping(), helm.Comment("Node affinity rules can be specified here"))
-
-		sizing.Add(makeVarName(instanceGroup.Name), entry.Sort(), helm.Comment(instanceGroup.GetLongDescription()))
+		sizing.Add(makeVarName(instanceGroup.Name), MakeSizingValues(instanceGroup, settings), helm.Comment(instanceGroup.GetLongDescription()))
 	}
 	values.Add("sizing", sizing.Sort())
 
@@ -239,6 +169,17 @@ func MakeValues(settings ExportSettings) helm.Node {
 		psps.Add(pspName, nil)
 	}
 	kube.Add("psp", psps.Sort())
+	if secretBackend(settings) != SecretBackendKubernetes {
+		kube.Add("secrets", makeSecretBackendValues(settings))
+	}
+	accountAnnotations := kube.Get("service_account_annotations").(*helm.Mapping)
+	for accountName, account := range settings.RoleManifest.Configuration.Authorization.Accounts {
+		// Only the accounts created by the chart can be annotated
+		if accountName != "default" && len(account.UsedBy) > 0 {
+			accountAnnotations.Add(accountName, helm.NewMapping())
+		}
+	}
+	accountAnnotations.Sort()
 	kube.Add(
 		"limits", helm.NewMapping(
 			"nproc", helm.NewMapping(
@@ -288,3 +229,146 @@ func MakeValues(settings ExportSettings) helm.Node {
 
 	return values
 }
+
+// MakeSizingValues returns the sizing values of a single instance group, as
+// found under .Values.sizing in the chart.  Manual instance groups have none
+// in the chart; this is used to render them on their own.
+func MakeSizingValues(instanceGroup *model.InstanceGroup, settings ExportSettings) *helm.Mapping {
+	entry := helm.NewMapping()
+
+	var comment string
+	it := fmt.Sprintf("The %s instance group", makeVarName(instanceGroup.Name))
+
+	var feature string
+	enabled := "enabled"
+
+	if instanceGroup.IfFeature != "" {
+		feature = instanceGroup.IfFeature
+	} else if instanceGroup.DefaultFeature != "" {
+		feature = instanceGroup.DefaultFeature
+	} else if instanceGroup.UnlessFeature != "" {
+		feature = instanceGroup.UnlessFeature
+		enabled = "disabled"
+	}
+	if feature != "" {
+		canBe := "can be"
+		if settings.RoleManifest.Features[feature] {
+			canBe = "is"
+		}
+		comment = fmt.Sprintf("%s %s %s by the %s feature.\n", it, canBe, enabled, feature)
+		it = "It"
+	}
+
+	if instanceGroup.Run.Scaling.Min == instanceGroup.Run.Scaling.Max {
+		comment += fmt.Sprintf("%s cannot be scaled.", it)
+	} else {
+		comment += fmt.Sprintf("%s can scale between %d and %d instances.",
+			it, instanceGroup.Run.Scaling.Min, instanceGroup.Run.Scaling.Max)
+
+		if instanceGroup.Run.Scaling.MustBeOdd {
+			comment += "\nThe instance count must be an odd number (not divisible by 2)."
+		}
+		if instanceGroup.Run.Scaling.HA != instanceGroup.Run.Scaling.Min {
+			comment += fmt.Sprintf("\nFor high availability it needs at least %d instances.",
+				instanceGroup.Run.Scaling.HA)
+		}
+	}
+	entry.Add("count", nil, helm.Comment(comment))
+	if settings.UseMemoryLimits {
+		var request helm.Node
+		if instanceGroup.Run.Memory.Request == nil {
+			request = helm.NewNode(nil)
+		} else {
+			request = helm.NewNode(int(*instanceGroup.Run.Memory.Request))
+		}
+		var limit helm.Node
+		if instanceGroup.Run.Memory.Limit == nil {
+			limit = helm.NewNode(nil)
+		} else {
+			limit = helm.NewNode(int(*instanceGroup.Run.Memory.Limit))
+		}
+
+		entry.Add("memory", helm.NewMapping(
+			"request", request,
+			"limit", limit),
+			helm.Comment("Unit [MiB]"))
+	}
+	if settings.UseCPULimits {
+		var request helm.Node
+		if instanceGroup.Run.CPU.Request == nil {
+			request = helm.NewNode(nil)
+		} else {
+			request = helm.NewNode(1000. * *instanceGroup.Run.CPU.Request)
+		}
+		var limit helm.Node
+		if instanceGroup.Run.CPU.Limit == nil {
+			limit = helm.NewNode(nil)
+		} else {
+			limit = helm.NewNode(1000. * *instanceGroup.Run.CPU.Limit)
+		}
+
+		entry.Add("cpu", helm.NewMapping(
+			"request", request,
+			"limit", limit),
+			helm.Comment("Unit [millicore]"))
+	}
+
+	diskSizes := helm.NewMapping()
+	for _, volume := range instanceGroup.Run.Volumes {
+		switch volume.Type {
+		case model.VolumeTypePersistent, model.VolumeTypeShared:
+			diskSizes.Add(makeVarName(volume.Tag), volume.Size)
+		}
+	}
+	if len(diskSizes.Names()) > 0 {
+		entry.Add("disk_sizes", diskSizes.Sort())
+	}
+	ports := helm.NewMapping()
+	for _, job := range instanceGroup.JobReferences {
+		for _, port := range job.ContainerProperties.BoshContainerization.Ports {
+			config := helm.NewMapping()
+			if port.PortIsConfigurable {
+				config.Add("port", port.ExternalPort)
+			}
+			if port.CountIsConfigurable {
+				config.Add("count", port.Count)
+			}
+			if port.Public && port.ExternalDNS != nil {
+				config.Add("hostname", port.ExternalDNS.Hostname, helm.Comment("Hostname of the DNS entry created by external-dns"))
+				config.Add("ttl", port.ExternalDNS.TTL, helm.Comment("TTL of the DNS entry in seconds; the lowest of the ports of the service applies, 0 leaves it to the others or the DNS provider"))
+			}
+			if len(config.Names()) > 0 {
+				ports.Add(makeVarName(port.Name), config)
+			}
+		}
+	}
+	if len(ports.Names()) > 0 {
+		entry.Add("ports", ports.Sort())
+	}
+
+	entry.Add("affinity", helm.NewMapping(), helm.Comment("The nodeAffinity, podAffinity and podAntiAffinity rules set here replace those of the role manifest"))
+	if HasPodDisruptionBudget(instanceGroup) {
+		entry.Add("disruption_budget", helm.NewMapping("min_available", nil, "max_unavailable", 1), helm.Comment(strings.Join(strings.Fields(`
+			Pods (a number or a percentage) that must remain available, or may be
+			unavailable, during voluntary disruptions such as node drains; the
+			PodDisruptionBudget is not created if neither is set.
+		`), " ")))
+	}
+	if instanceGroup.Type == model.RoleTypeBoshTask {
+		entry.Add("job", makeJobValues(instanceGroup), helm.Comment(strings.Join(strings.Fields(`
+			Settings of the job running the task, replacing those of the role
+			manifest; the restart_policy is Never or OnFailure.
+		`), " ")))
+	}
+	entry.Add("drop_capabilities", nil, helm.Comment(strings.Join(strings.Fields(`
+		Capabilities to drop from the containers (e.g. ["ALL"]), replacing the
+		drop-capabilities of the role manifest.
+	`), " ")))
+	entry.Add("debug", helm.NewMapping("command", nil), helm.Comment(strings.Join(strings.Fields(`
+		Setting debug.command (as a list of strings) replaces the command of the
+		containers, e.g. with ["sleep", "infinity"] to start them in a diagnostic
+		mode; the probes are disabled in that case.
+	`), " ")))
+
+	return entry.Sort()
+}
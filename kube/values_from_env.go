@@ -0,0 +1,142 @@
+package kube
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ValuesEnvPrefix is the prefix of the environment variables mapped onto
+// values keys by ValuesFromEnv
+const ValuesEnvPrefix = "FISSILE_VALUES_"
+
+// valuesEnvSeparator separates the keys of the path of a value in the name
+// of an environment variable, as keys may contain single underscores
+const valuesEnvSeparator = "__"
+
+// ValuesOverride is a value set from an environment variable
+type ValuesOverride struct {
+	Variable string
+	Key      string
+	Value    interface{}
+}
+
+// Type returns the YAML type of the value
+func (o ValuesOverride) Type() string {
+	switch o.Value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int64, uint64:
+		return "int"
+	case float64:
+		return "float"
+	case []interface{}:
+		return "list"
+	default:
+		return "mapping"
+	}
+}
+
+// ValuesFromEnv returns a values overlay for the environment variables (as
+// NAME=value) named FISSILE_VALUES_<PATH>, where the keys of the path are
+// separated by double underscores, e.g. FISSILE_VALUES_NAME_PREFIX__ENABLED
+// for name_prefix.enabled. The keys are matched against the given values
+// ignoring case, so that e.g. env.KUBERNETES_CLUSTER_DOMAIN keeps its case;
+// unknown keys are lowercased.
+//
+// The values of env and secrets, values replacing strings, and empty values
+// are kept as strings; all others are parsed as YAML, so that "true", "42",
+// "null" (deleting the value), or "[a, b]" get their types. Setting both a key
+// and a key nested below it (e.g. A and A__B) is an error. The applied
+// overrides are returned sorted by key.
+func ValuesFromEnv(environ []string, values map[string]interface{}) (map[string]interface{}, []ValuesOverride, error) {
+	overlay := map[string]interface{}{}
+	var overrides []ValuesOverride
+	for _, entry := range environ {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], ValuesEnvPrefix) {
+			continue
+		}
+		name, raw := parts[0], parts[1]
+		path := strings.Split(strings.TrimPrefix(name, ValuesEnvPrefix), valuesEnvSeparator)
+
+		current, _ := toStringMap(values)
+		target := overlay
+		var keys []string
+		var existing interface{}
+		for index, component := range path {
+			if component == "" {
+				return nil, nil, fmt.Errorf("Invalid values key in environment variable %s", name)
+			}
+			key := matchValuesKey(current, component)
+			keys = append(keys, key)
+			existing = current[key]
+			current, _ = toStringMap(existing)
+
+			if index < len(path)-1 {
+				next, ok := target[key].(map[string]interface{})
+				if !ok {
+					next = map[string]interface{}{}
+					target[key] = next
+				}
+				target = next
+				continue
+			}
+
+			value, err := coerceEnvValue(raw, keys, existing)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid value of environment variable %s: %v", name, err)
+			}
+			target[key] = value
+			overrides = append(overrides, ValuesOverride{Variable: name, Key: strings.Join(keys, "."), Value: value})
+		}
+	}
+
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Key < overrides[j].Key })
+	for i, override := range overrides {
+		for _, other := range overrides[i+1:] {
+			if other.Key == override.Key || strings.HasPrefix(other.Key, override.Key+".") {
+				return nil, nil, fmt.Errorf("Environment variables %s and %s set conflicting values keys %s and %s",
+					override.Variable, other.Variable, override.Key, other.Key)
+			}
+		}
+	}
+	return overlay, overrides, nil
+}
+
+// matchValuesKey returns the key of the values matching the component of an
+// environment variable name, ignoring case, or the lowercased component
+func matchValuesKey(values map[string]interface{}, component string) string {
+	if _, ok := values[component]; ok {
+		return component
+	}
+	for key := range values {
+		if strings.EqualFold(key, component) {
+			return key
+		}
+	}
+	return strings.ToLower(component)
+}
+
+// coerceEnvValue returns the value of an environment variable replacing the
+// existing value at the keys
+func coerceEnvValue(raw string, keys []string, existing interface{}) (interface{}, error) {
+	if len(keys) == 2 && (keys[0] == "env" || keys[0] == "secrets") {
+		// Environment variables and secrets are strings, even with a null default
+		return raw, nil
+	}
+	if _, ok := existing.(string); ok || raw == "" {
+		return raw, nil
+	}
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
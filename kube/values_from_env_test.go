@@ -0,0 +1,61 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValuesFromEnv(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]interface{}{
+		"name_prefix": map[string]interface{}{"enabled": false, "prefix": ""},
+		"env":         map[interface{}]interface{}{"KUBERNETES_CLUSTER_DOMAIN": "", "COUNT": 3},
+		"kube":        map[string]interface{}{"organization": "cap"},
+	}
+
+	overlay, overrides, err := ValuesFromEnv([]string{
+		"PATH=/usr/bin",
+		"FISSILE_VALUES_NAME_PREFIX__ENABLED=true",
+		"FISSILE_VALUES_NAME_PREFIX__PREFIX=007",
+		"FISSILE_VALUES_ENV__KUBERNETES_CLUSTER_DOMAIN=cluster.local",
+		"FISSILE_VALUES_ENV__COUNT=5",
+		"FISSILE_VALUES_SECRETS__PASSWORD=true",
+		"FISSILE_VALUES_KUBE__ORGANIZATION=",
+		"FISSILE_VALUES_KUBE__EXTERNAL_IPS=[10.0.0.1, 10.0.0.2]",
+		"FISSILE_VALUES_INGRESS=null",
+	}, values)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"name_prefix": map[string]interface{}{"enabled": true, "prefix": "007"},
+		"env":         map[string]interface{}{"KUBERNETES_CLUSTER_DOMAIN": "cluster.local", "COUNT": "5"},
+		"secrets":     map[string]interface{}{"password": "true"},
+		"kube":        map[string]interface{}{"organization": "", "external_ips": []interface{}{"10.0.0.1", "10.0.0.2"}},
+		"ingress":     nil,
+	}, overlay)
+
+	var keys, types []string
+	for _, override := range overrides {
+		keys = append(keys, override.Key)
+		types = append(types, override.Type())
+	}
+	assert.Equal(t, []string{"env.COUNT", "env.KUBERNETES_CLUSTER_DOMAIN", "ingress", "kube.external_ips",
+		"kube.organization", "name_prefix.enabled", "name_prefix.prefix", "secrets.password"}, keys)
+	assert.Equal(t, []string{"string", "string", "null", "list", "string", "bool", "string", "string"}, types)
+	assert.Equal(t, "FISSILE_VALUES_ENV__COUNT", overrides[0].Variable)
+
+	_, _, err = ValuesFromEnv([]string{"FISSILE_VALUES_KUBE____ORGANIZATION=x"}, values)
+	assert.EqualError(t, err, "Invalid values key in environment variable FISSILE_VALUES_KUBE____ORGANIZATION")
+
+	_, _, err = ValuesFromEnv([]string{"FISSILE_VALUES_KUBE__EXTERNAL_IPS=[1"}, values)
+	assert.Error(t, err)
+
+	_, _, err = ValuesFromEnv([]string{
+		"FISSILE_VALUES_NAME_PREFIX__ENABLED=true",
+		"FISSILE_VALUES_NAME_PREFIX=null",
+	}, values)
+	assert.EqualError(t, err, "Environment variables FISSILE_VALUES_NAME_PREFIX and FISSILE_VALUES_NAME_PREFIX__ENABLED set conflicting values keys name_prefix and name_prefix.enabled")
+}
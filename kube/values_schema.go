@@ -0,0 +1,145 @@
+package kube
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/fissile/helm"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// MakeValuesSchema returns the values.schema.json of a helm chart, a JSON
+// schema with the types and descriptions of the default values, which helm 3
+// validates the values against. The values of variables (env and secrets)
+// may be of any scalar type, but required user variables without a default
+// must be set. The instance counts of the sizing are limited to the scaling
+// of the instance groups, their disruption budgets may be percentages, and
+// the job settings of tasks may be unset.
+// Unknown values are allowed.
+func MakeValuesSchema(settings ExportSettings) ([]byte, error) {
+	schema, err := valuesNodeSchema(MakeValues(settings), nil)
+	if err != nil {
+		return nil, err
+	}
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+
+	for _, value := range RequiredValues(settings) {
+		section := schemaProperty(schema, value.Section)
+		property := schemaProperty(section, value.Name)
+		if section == nil || property == nil {
+			continue
+		}
+		property["type"] = []string{"string", "number", "boolean"}
+		required, _ := section["required"].([]string)
+		section["required"] = append(required, value.Name)
+	}
+
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		sizing := schemaProperty(schemaProperty(schema, "sizing"), makeVarName(instanceGroup.Name))
+		// Disruption budgets are numbers or percentages of pods, or unset
+		budget := schemaProperty(sizing, "disruption_budget")
+		for _, name := range []string{"min_available", "max_unavailable"} {
+			if property := schemaProperty(budget, name); property != nil {
+				property["type"] = []string{"integer", "string", "null"}
+			}
+		}
+
+		// Job settings of the role manifest can be unset
+		job := schemaProperty(sizing, "job")
+		for _, name := range []string{"active_deadline_seconds", "backoff_limit", "ttl_seconds_after_finished"} {
+			if property := schemaProperty(job, name); property != nil {
+				property["type"] = []string{"integer", "null"}
+			}
+		}
+		if property := schemaProperty(job, "restart_policy"); property != nil {
+			property["enum"] = []interface{}{"Never", "OnFailure", nil}
+			delete(property, "type")
+		}
+
+		count := schemaProperty(sizing, "count")
+		if count == nil {
+			continue
+		}
+		// These match the replica checks of the templates; the default (null)
+		// is the minimal (or HA) count
+		scaling := instanceGroup.Run.Scaling
+		count["type"] = []string{"integer", "null"}
+		count["minimum"] = scaling.Min
+		count["maximum"] = scaling.Max
+		if scaling.MustBeOdd {
+			count["not"] = map[string]interface{}{"multipleOf": 2}
+		}
+	}
+
+	backend := schemaProperty(schemaProperty(schemaProperty(schema, "kube"), "secrets"), "backend")
+	if backend != nil {
+		backend["enum"] = secretBackends
+		delete(backend, "type")
+	}
+
+	buf, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, '\n'), nil
+}
+
+// schemaProperty returns the schema of the named property of an object
+// schema, or nil
+func schemaProperty(schema map[string]interface{}, name string) map[string]interface{} {
+	properties, _ := schema["properties"].(map[string]interface{})
+	property, _ := properties[name].(map[string]interface{})
+	return property
+}
+
+// valuesNodeSchema returns the schema of a node of the default values
+func valuesNodeSchema(node helm.Node, path []string) (map[string]interface{}, error) {
+	schema := map[string]interface{}{}
+	if comment := node.Comment(); comment != "" {
+		schema["description"] = comment
+	}
+
+	switch n := node.(type) {
+	case *helm.Mapping:
+		properties := map[string]interface{}{}
+		for _, name := range n.Names() {
+			property, err := valuesNodeSchema(n.Get(name), append(path, name))
+			if err != nil {
+				return nil, err
+			}
+			properties[name] = property
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+
+	case *helm.List:
+		schema["type"] = "array"
+
+	case *helm.Scalar:
+		if len(path) == 2 && (path[0] == "env" || path[0] == "secrets") {
+			schema["type"] = []string{"string", "number", "boolean", "null"}
+			break
+		}
+		var buf bytes.Buffer
+		if err := helm.NewEncoder(&buf).Encode(n); err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if err := yaml.Unmarshal(buf.Bytes(), &value); err != nil {
+			return nil, fmt.Errorf("Error parsing the default value of %v: %v", path, err)
+		}
+		switch value.(type) {
+		case bool:
+			schema["type"] = "boolean"
+		case int, int64, uint64:
+			schema["type"] = "integer"
+		case float64:
+			schema["type"] = "number"
+		case string:
+			schema["type"] = "string"
+		}
+	}
+
+	return schema, nil
+}
@@ -0,0 +1,71 @@
+package kube
+
+import (
+	"encoding/json"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeValuesSchema(t *testing.T) {
+	t.Parallel()
+
+	settings := renderTestSettings(t, nil)
+	settings.RoleManifest.Variables = append(settings.RoleManifest.Variables,
+		&model.VariableDefinition{Name: "DOMAIN", CVOptions: model.CVOptions{Required: true}},
+		&model.VariableDefinition{Name: "DEFAULTED", CVOptions: model.CVOptions{Required: true, Default: "x"}},
+		&model.VariableDefinition{Name: "NATS_URL", CVOptions: model.CVOptions{Required: true, URLTemplate: "nats://nats-nats:4222"}})
+	settings.RoleManifest.InstanceGroups = model.InstanceGroups{
+		&model.InstanceGroup{Name: "nats", Run: &model.RoleRun{Scaling: &model.RoleRunScaling{Min: 1, Max: 3, HA: 3}}},
+		&model.InstanceGroup{Name: "diego-cell", Run: &model.RoleRun{Scaling: &model.RoleRunScaling{Min: 1, Max: 9, HA: 3, MustBeOdd: true}}},
+	}
+	buf, err := MakeValuesSchema(settings)
+	require.NoError(t, err)
+
+	var schema map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf, &schema))
+	assert.Equal(t, "http://json-schema.org/draft-07/schema#", schema["$schema"])
+	assert.Equal(t, "object", schema["type"])
+
+	property := func(path ...string) map[string]interface{} {
+		node := schema
+		for _, name := range path {
+			node = node["properties"].(map[string]interface{})[name].(map[string]interface{})
+		}
+		return node
+	}
+	enabled := property("name_prefix", "enabled")
+	assert.Equal(t, "boolean", enabled["type"])
+	assert.Contains(t, enabled["description"], "Prefix the names of all resources")
+	assert.Equal(t, "string", property("name_prefix", "prefix")["type"])
+	assert.Equal(t, "integer", property("kube", "secrets_generation_counter")["type"])
+	assert.Equal(t, "array", property("kube", "external_ips")["type"])
+	assert.NotContains(t, schema, "required", "No top-level values should be required by the schema")
+
+	t.Run("Required", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, []interface{}{"string", "number", "boolean", "null"}, property("secrets", "PLAIN")["type"])
+		assert.Equal(t, []interface{}{"string", "number", "boolean"}, property("secrets", "NEEDED")["type"])
+		assert.Equal(t, []interface{}{"NEEDED"}, property("secrets")["required"])
+		assert.Equal(t, []interface{}{"string", "number", "boolean"}, property("env", "DOMAIN")["type"])
+		assert.Equal(t, []interface{}{"DOMAIN"}, property("env")["required"],
+			"Required variables with a default or url template should not be required by the schema")
+		assert.Equal(t, []interface{}{"string", "number", "boolean", "null"}, property("env", "NATS_URL")["type"])
+	})
+
+	t.Run("Sizing", func(t *testing.T) {
+		t.Parallel()
+		count := property("sizing", "nats", "count")
+		assert.Equal(t, []interface{}{"integer", "null"}, count["type"])
+		assert.Equal(t, float64(1), count["minimum"])
+		assert.Equal(t, float64(3), count["maximum"])
+		assert.NotContains(t, count, "not")
+		assert.Contains(t, count["description"], "can scale between 1 and 3 instances")
+
+		count = property("sizing", "diego_cell", "count")
+		assert.Equal(t, float64(9), count["maximum"])
+		assert.Equal(t, map[string]interface{}{"multipleOf": float64(2)}, count["not"])
+	})
+}
@@ -3,7 +3,9 @@ package kube
 import (
 	"testing"
 
+	"code.cloudfoundry.org/fissile/helm"
 	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -33,6 +35,115 @@ func TestMakeValues(t *testing.T) {
 		sizing := node.Get("sizing")
 		require.NotNil(t, sizing)
 		assert.Contains(t, sizing.Comment(), "underscore")
+
+		debugCommand := sizing.Get("arole", "debug", "command")
+		require.NotNil(t, debugCommand)
+		assert.Equal(t, "~", debugCommand.String())
+	})
+
+	t.Run("Deprecated variables", func(t *testing.T) {
+		t.Parallel()
+		settings := ExportSettings{
+			RoleManifest: &model.RoleManifest{
+				InstanceGroups: model.InstanceGroups{},
+				Configuration:  &model.Configuration{},
+				Variables: model.Variables{
+					&model.VariableDefinition{
+						Name: "OLD",
+						CVOptions: model.CVOptions{
+							Description: "An old variable.",
+							Deprecated: &model.CVDeprecation{
+								Message:        "Use NEW instead",
+								RemovalVersion: "3.0.0",
+							},
+						},
+					},
+				},
+			},
+		}
+
+		node := MakeValues(settings)
+		require.NotNil(t, node)
+
+		old := node.Get("env").Get("OLD")
+		require.NotNil(t, old)
+		assert.Equal(t, "An old variable.\nOLD is deprecated and will be removed in version 3.0.0: Use NEW instead.", old.Comment())
+	})
+
+	t.Run("URL templates", func(t *testing.T) {
+		t.Parallel()
+		settings := ExportSettings{
+			RoleManifest: &model.RoleManifest{
+				InstanceGroups: model.InstanceGroups{},
+				Configuration:  &model.Configuration{},
+				Variables: model.Variables{
+					&model.VariableDefinition{
+						Name: "UAA_URL",
+						CVOptions: model.CVOptions{
+							Description: "The URL of UAA.",
+							URLTemplate: "https://uaa-public:2793",
+						},
+					},
+				},
+			},
+		}
+
+		node := MakeValues(settings)
+		require.NotNil(t, node)
+
+		uaaURL := node.Get("env").Get("UAA_URL")
+		require.NotNil(t, uaaURL)
+		assert.Equal(t, "~", uaaURL.String())
+		assert.Equal(t, "The URL of UAA.\nIt defaults to https://uaa-public:2793, with the service name qualified by the namespace and the cluster domain.", uaaURL.Comment())
+	})
+
+	t.Run("Job settings", func(t *testing.T) {
+		t.Parallel()
+		deadline := 600
+		settings := ExportSettings{
+			RoleManifest: &model.RoleManifest{
+				InstanceGroups: model.InstanceGroups{
+					&model.InstanceGroup{
+						Name: "errand",
+						Type: model.RoleTypeBoshTask,
+						Run: &model.RoleRun{
+							Scaling:     &model.RoleRunScaling{Min: 1, Max: 1},
+							FlightStage: model.FlightStagePostFlight,
+							Job:         &model.RoleRunJob{ActiveDeadlineSeconds: &deadline, RestartPolicy: model.RestartPolicyNever},
+						},
+					},
+					&model.InstanceGroup{
+						Name: "smoke-tests",
+						Type: model.RoleTypeBoshTask,
+						Tags: []model.RoleTag{model.RoleTagStopOnFailure},
+						Run: &model.RoleRun{
+							Scaling:     &model.RoleRunScaling{Min: 1, Max: 1},
+							FlightStage: model.FlightStagePostFlight,
+						},
+					},
+				},
+				Configuration: &model.Configuration{},
+			},
+		}
+
+		node := MakeValues(settings)
+		require.NotNil(t, node)
+
+		actual, err := RoundtripKube(node.Get("sizing"))
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			errand:
+				job:
+					active_deadline_seconds: 600
+					backoff_limit: ~
+					ttl_seconds_after_finished: ~
+					restart_policy: Never
+			smoke_tests:
+				job:
+					active_deadline_seconds: ~
+					restart_policy: ~
+		`, actual)
+		assert.NotContains(t, node.Get("sizing", "smoke_tests", "job").(*helm.Mapping).Names(), "backoff_limit")
 	})
 
 	t.Run("Check Default Registry", func(t *testing.T) {
@@ -139,4 +250,29 @@ tls: {}
 
 		assert.Exactly(t, expected, actual)
 	})
+
+	t.Run("Service Account Annotations", func(t *testing.T) {
+		t.Parallel()
+		settings := ExportSettings{
+			RoleManifest: &model.RoleManifest{
+				InstanceGroups: model.InstanceGroups{},
+				Configuration: &model.Configuration{
+					Authorization: model.ConfigurationAuthorization{
+						Accounts: map[string]model.AuthAccount{
+							"default":  {UsedBy: map[string]struct{}{"foo": {}}},
+							"unused":   {},
+							"the-name": {UsedBy: map[string]struct{}{"bar": {}}},
+						},
+					},
+				},
+			},
+		}
+		node := MakeValues(settings)
+		require.NotNil(t, node)
+
+		annotations := node.Get("kube", "service_account_annotations")
+		require.NotNil(t, annotations)
+		assert.Contains(t, annotations.Comment(), "eks.amazonaws.com/role-arn")
+		assert.Equal(t, []string{"the-name"}, annotations.(*helm.Mapping).Names())
+	})
 }
@@ -0,0 +1,103 @@
+package kube
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// variableValidationCheck returns the template failing rendering if the
+// user supplied value of the variable breaks its validation rules, or "" if
+// it has none. The rules are checked in the same order, and with the same
+// messages, as by CVValidation.Check.
+func variableValidationCheck(cv *model.VariableDefinition) string {
+	rules := cv.CVOptions.Validation
+	if rules == nil {
+		return ""
+	}
+	field := "env." + cv.Name
+	if cv.CVOptions.Secret {
+		field = "secrets." + cv.Name
+	}
+	value := ".Values." + field
+	str := fmt.Sprintf("(toString %s)", value)
+	numeric := fmt.Sprintf(`(has (kindOf %s) (list "int" "int64" "float64"))`, value)
+
+	type check struct{ condition, message string }
+	var checks []check
+	if rules.Type != "" || rules.NumericType() != "" || len(rules.Enum) > 0 || rules.Pattern != "" {
+		checks = append(checks, check{
+			fmt.Sprintf(`has (kindOf %s) (list "map" "slice")`, value),
+			"must not be a map or a list"})
+	}
+	switch rules.Type {
+	case model.CVValidationTypeString:
+		checks = append(checks, check{fmt.Sprintf(`not (kindIs "string" %s)`, value), "must be a string"})
+	case model.CVValidationTypeBoolean:
+		checks = append(checks, check{fmt.Sprintf(`not (has %s (list "true" "false"))`, str), "must be a boolean"})
+	}
+	switch rules.NumericType() {
+	case model.CVValidationTypeInteger:
+		checks = append(checks, check{
+			fmt.Sprintf(`not (or (and %s (eq (float64 %s) (floor %s))) (regexMatch %q %s))`,
+				numeric, value, value, model.CVIntegerPattern, str),
+			"must be an integer"})
+	case model.CVValidationTypeNumber:
+		checks = append(checks, check{
+			fmt.Sprintf(`not (or %s (regexMatch %q %s))`, numeric, model.CVNumberPattern, str),
+			"must be a number"})
+	}
+	if rules.NumericType() != "" {
+		if rules.Min != nil {
+			checks = append(checks, check{
+				fmt.Sprintf("lt (float64 %s) %s", value, floatLiteral(*rules.Min)),
+				fmt.Sprintf("must be at least %v", *rules.Min)})
+		}
+		if rules.Max != nil {
+			checks = append(checks, check{
+				fmt.Sprintf("gt (float64 %s) %s", value, floatLiteral(*rules.Max)),
+				fmt.Sprintf("must be at most %v", *rules.Max)})
+		}
+	}
+	if len(rules.Enum) > 0 {
+		var enum []string
+		for _, allowed := range rules.Enum {
+			enum = append(enum, strconv.Quote(allowed))
+		}
+		checks = append(checks, check{
+			fmt.Sprintf("not (has %s (list %s))", str, strings.Join(enum, " ")),
+			fmt.Sprintf("must be one of %s", strings.Join(rules.Enum, ", "))})
+	}
+	if rules.Pattern != "" {
+		checks = append(checks, check{
+			fmt.Sprintf("not (regexMatch %q %s)", rules.AnchoredPattern(), str),
+			fmt.Sprintf("must match %s", rules.Pattern)})
+	}
+	if len(checks) == 0 {
+		return ""
+	}
+
+	var template strings.Builder
+	for i, check := range checks {
+		if i == 0 {
+			template.WriteString("{{ if ")
+		} else {
+			template.WriteString("{{ else if ")
+		}
+		fmt.Fprintf(&template, "%s }}{{ fail %q }}", check.condition, field+" "+check.message)
+	}
+	template.WriteString("{{ end }}")
+	return template.String()
+}
+
+// floatLiteral formats a number as a float literal of the templates, which
+// cannot compare floats with integers
+func floatLiteral(number float64) string {
+	literal := strconv.FormatFloat(number, 'f', -1, 64)
+	if !strings.Contains(literal, ".") {
+		literal += ".0"
+	}
+	return literal
+}
@@ -0,0 +1,269 @@
+package kube
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/util"
+)
+
+// Secret backends the containers can get the secret variables from
+const (
+	// SecretBackendKubernetes refers to the Kubernetes secrets of the
+	// configs, filled by the values and the secret generation job (the
+	// default)
+	SecretBackendKubernetes = "kubernetes"
+	// SecretBackendVaultAgent has the Vault Agent injector write the secrets
+	// of the pod into a file the role images source on start
+	SecretBackendVaultAgent = "vault-agent"
+	// SecretBackendVaultCSI has the secrets store CSI driver sync the secrets
+	// from Vault into the Kubernetes secret the containers refer to
+	SecretBackendVaultCSI = "vault-csi"
+)
+
+// Defaults of the Vault settings, which are values of helm charts
+const (
+	defaultVaultRole    = "fissile"
+	defaultVaultPath    = "secret/data/fissile"
+	defaultVaultAddress = "http://vault.vault:8200"
+)
+
+// vaultSecretsFile is the file the Vault Agent injector writes the secrets
+// into, below /vault/secrets; run.sh sources it
+const vaultSecretsFile = "fissile.env"
+
+// vaultSecretsVolumeName is the name of the CSI volume of the secrets synced
+// from Vault
+const vaultSecretsVolumeName = "vault-secrets"
+
+var secretBackends = []string{SecretBackendKubernetes, SecretBackendVaultAgent, SecretBackendVaultCSI}
+
+// ValidateSecretBackend returns an error unless the secret backend is known;
+// the empty backend is the kubernetes one
+func ValidateSecretBackend(backend string) error {
+	if backend == "" {
+		return nil
+	}
+	for _, known := range secretBackends {
+		if backend == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unsupported secret backend %s; use one of %s", backend, strings.Join(secretBackends, ", "))
+}
+
+// secretBackend returns the secret backend of the settings
+func secretBackend(settings ExportSettings) string {
+	if settings.SecretBackend == "" {
+		return SecretBackendKubernetes
+	}
+	return settings.SecretBackend
+}
+
+// SelectSecretBackend returns true if the nodes for the secret backend are
+// part of the generated configs. Helm charts generated for a Vault backend
+// support all backends, selected by kube.secrets.backend; the nodes are then
+// guarded by that value, in addition to their own block action.
+func SelectSecretBackend(backend string, settings ExportSettings, nodes ...helm.Node) bool {
+	if !settings.CreateHelmChart || secretBackend(settings) == SecretBackendKubernetes {
+		return backend == secretBackend(settings)
+	}
+	check := fmt.Sprintf("eq .Values.kube.secrets.backend %q", backend)
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		if block := node.Block(); strings.HasPrefix(block, "if ") {
+			node.Set(helm.Block(fmt.Sprintf("if and (%s) (%s)", check, strings.TrimPrefix(block, "if "))))
+		} else {
+			node.Set(helm.Block("if " + check))
+		}
+	}
+	return true
+}
+
+// IsSecretGenerationJob returns true if the instance group is a task
+// generating the secrets, i.e. one writing the secret named by
+// KUBE_SECRETS_GENERATION_NAME. Vault backends don't need it.
+func IsSecretGenerationJob(instanceGroup *model.InstanceGroup) bool {
+	if instanceGroup.Type != model.RoleTypeBoshTask {
+		return false
+	}
+	variables, err := instanceGroup.GetVariablesForRole()
+	if err != nil {
+		return false
+	}
+	for _, variable := range variables {
+		if variable.Name == "KUBE_SECRETS_GENERATION_NAME" {
+			return true
+		}
+	}
+	return false
+}
+
+// makeSecretBackendValues returns the kube.secrets values of helm charts
+// generated for a Vault backend
+func makeSecretBackendValues(settings ExportSettings) helm.Node {
+	vault := helm.NewMapping(
+		"role", helm.NewNode(defaultVaultRole, helm.Comment("Vault role of the Kubernetes auth method the pods log in with")),
+		"path", helm.NewNode(defaultVaultPath, helm.Comment("Path of the KV version 2 secret holding the secret variables, by their keys in the Kubernetes secret")),
+		"address", helm.NewNode(defaultVaultAddress, helm.Comment("Address of the Vault server, for the secrets store CSI driver")))
+	backend := helm.NewNode(secretBackend(settings), helm.Comment(fmt.Sprintf(
+		"Backend the containers get the secrets from: %s", strings.Join(secretBackends, ", "))))
+	return helm.NewMapping("backend", backend, "vault", vault)
+}
+
+// vaultSetting returns a reference to the Vault setting of a helm chart, or
+// its default
+func vaultSetting(name, defaultValue string, settings ExportSettings) string {
+	if settings.CreateHelmChart {
+		return fmt.Sprintf("{{ .Values.kube.secrets.vault.%s | quote }}", name)
+	}
+	return defaultValue
+}
+
+// podSecretVariables returns the sorted names of the secret variables used by
+// the containers of the pod of the instance group
+func podSecretVariables(role *model.InstanceGroup) ([]string, error) {
+	seen := map[string]bool{}
+	for _, candidate := range append([]*model.InstanceGroup{role}, role.GetColocatedRoles()...) {
+		variables, err := candidate.GetVariablesForRole()
+		if err != nil {
+			return nil, err
+		}
+		for _, variable := range variables {
+			if variable.CVOptions.Secret && !isExternalSecret(variable, role.Manifest()) {
+				seen[variable.Name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// addVaultSecrets adds the annotations of the Vault Agent injector, or the
+// volume and mounts of the secrets store CSI driver, to the pod template of an
+// instance group using secrets, for the backends of the settings
+func addVaultSecrets(role *model.InstanceGroup, annotations *helm.Mapping, containers []*helm.Mapping, volumes *helm.List, settings ExportSettings) error {
+	if secretBackend(settings) == SecretBackendKubernetes {
+		return nil
+	}
+	names, err := podSecretVariables(role)
+	if err != nil || len(names) == 0 {
+		return err
+	}
+
+	inject := helm.NewNode("true")
+	if SelectSecretBackend(SecretBackendVaultAgent, settings, inject) {
+		vaultRole := helm.NewNode(vaultSetting("role", defaultVaultRole, settings))
+		secret := helm.NewNode(vaultSetting("path", defaultVaultPath, settings))
+		template := helm.NewNode(vaultAgentTemplate(names, settings))
+		SelectSecretBackend(SecretBackendVaultAgent, settings, vaultRole, secret, template)
+		annotations.Add("vault.hashicorp.com/agent-inject", inject)
+		annotations.Add("vault.hashicorp.com/role", vaultRole)
+		annotations.Add("vault.hashicorp.com/agent-inject-secret-"+vaultSecretsFile, secret)
+		annotations.Add("vault.hashicorp.com/agent-inject-template-"+vaultSecretsFile, template)
+		if role.Type == model.RoleTypeBoshTask {
+			// A sidecar agent would keep the pods of the job from completing
+			prePopulateOnly := helm.NewNode("true")
+			SelectSecretBackend(SecretBackendVaultAgent, settings, prePopulateOnly)
+			annotations.Add("vault.hashicorp.com/agent-pre-populate-only", prePopulateOnly)
+		}
+	}
+
+	volume := helm.NewMapping("name", vaultSecretsVolumeName, "csi", helm.NewMapping(
+		"driver", "secrets-store.csi.k8s.io",
+		"readOnly", true,
+		"volumeAttributes", helm.NewMapping("secretProviderClass", resourceName(userSecretsName, settings))))
+	if SelectSecretBackend(SecretBackendVaultCSI, settings, volume) {
+		volumes.Add(volume)
+		for _, container := range containers {
+			// The driver only syncs the secret while it is mounted
+			mount := helm.NewMapping("mountPath", "/vault/secrets", "name", vaultSecretsVolumeName, "readOnly", true)
+			SelectSecretBackend(SecretBackendVaultCSI, settings, mount)
+			container.Get("volumeMounts").(*helm.List).Add(mount)
+		}
+	}
+	return nil
+}
+
+// vaultAgentTemplate returns the template the Vault Agent renders the secrets
+// file with; it exports the secret variables, base64-encoded on the way so
+// that any value survives the shell.
+func vaultAgentTemplate(names []string, settings ExportSettings) string {
+	path := "%s"
+	if !settings.CreateHelmChart {
+		path = defaultVaultPath
+	}
+	var exports []string
+	for _, name := range names {
+		exports = append(exports, fmt.Sprintf(
+			`export %s="$(echo '{{ index .Data.data "%s" | base64Encode }}' | base64 -d)"`,
+			name, util.ConvertNameToKey(name)))
+	}
+	template := fmt.Sprintf(`{{ with secret "%s" }}%s{{ end }}`, path, strings.Join(exports, "; "))
+	if settings.CreateHelmChart {
+		// The path is a value; the template itself is not one of the chart
+		return fmt.Sprintf("{{ printf %s .Values.kube.secrets.vault.path | quote }}", strconv.Quote(template+"\n"))
+	}
+	return template + "\n"
+}
+
+// MakeSecretProviderClass returns the SecretProviderClass of the secrets
+// store CSI driver, reading the secret variables from Vault and syncing them
+// into the Kubernetes secret the containers refer to, or nil if it isn't
+// needed.
+func MakeSecretProviderClass(secrets model.CVMap, settings ExportSettings) (helm.Node, error) {
+	if secretBackend(settings) == SecretBackendKubernetes {
+		return nil, nil
+	}
+
+	var keys []string
+	for name, cv := range secrets {
+		if !isExternalSecret(cv, settings.RoleManifest) {
+			keys = append(keys, util.ConvertNameToKey(name))
+		}
+	}
+	sort.Strings(keys)
+
+	path := strconv.Quote(defaultVaultPath)
+	if settings.CreateHelmChart {
+		path = `"{{ .Values.kube.secrets.vault.path }}"`
+	}
+	var objects []string
+	data := helm.NewList()
+	for _, key := range keys {
+		objects = append(objects, fmt.Sprintf(`{"objectName": %q, "secretPath": %s, "secretKey": %q}`, key, path, key))
+		data.Add(helm.NewMapping("objectName", key, "key", key))
+	}
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("secrets-store.csi.x-k8s.io/v1").
+		SetKind("SecretProviderClass").
+		SetName(userSecretsName)
+	providerClass, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	spec := helm.NewMapping("provider", "vault")
+	spec.Add("parameters", helm.NewMapping(
+		"vaultAddress", vaultSetting("address", defaultVaultAddress, settings),
+		"roleName", vaultSetting("role", defaultVaultRole, settings),
+		"objects", fmt.Sprintf("[%s]", strings.Join(objects, ", "))))
+	spec.Add("secretObjects", helm.NewList(helm.NewMapping(
+		"secretName", resourceName(userSecretsName, settings),
+		"type", "Opaque",
+		"data", data)))
+	providerClass.Add("spec", spec)
+
+	return providerClass.Sort(), nil
+}
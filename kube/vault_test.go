@@ -0,0 +1,216 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSecretBackend(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range []string{"", SecretBackendKubernetes, SecretBackendVaultAgent, SecretBackendVaultCSI} {
+		assert.NoError(t, ValidateSecretBackend(backend))
+	}
+	assert.EqualError(t, ValidateSecretBackend("vault"),
+		"Unsupported secret backend vault; use one of kubernetes, vault-agent, vault-csi")
+}
+
+func TestSecretBackendEnvVars(t *testing.T) {
+	t.Parallel()
+
+	variables := model.Variables{
+		&model.VariableDefinition{
+			Name:      "A_SECRET",
+			CVOptions: model.CVOptions{Secret: true},
+		},
+	}
+	secretVars := func(t *testing.T, settings ExportSettings, config interface{}) []interface{} {
+		ev, err := getEnvVarsFromConfigs(variables, settings)
+		require.NoError(t, err)
+		actual, err := RoundtripNode(helm.NewNode(ev), config)
+		require.NoError(t, err)
+		var found []interface{}
+		for _, envVar := range actual.([]interface{}) {
+			if envVar.(map[interface{}]interface{})["name"] == "A_SECRET" {
+				found = append(found, envVar)
+			}
+		}
+		return found
+	}
+
+	t.Run("KubeVaultAgent", func(t *testing.T) {
+		t.Parallel()
+		settings := ExportSettings{SecretBackend: SecretBackendVaultAgent}
+		assert.Empty(t, secretVars(t, settings, nil), "The secrets should be sourced from the agent's file")
+	})
+
+	t.Run("KubeVaultCSI", func(t *testing.T) {
+		t.Parallel()
+		settings := ExportSettings{SecretBackend: SecretBackendVaultCSI}
+		yamltest.IsYAMLEqualString(assert.New(t), `---
+			-	name: "A_SECRET"
+				valueFrom:
+					secretKeyRef:
+						key: "a-secret"
+						name: "secrets"
+		`, secretVars(t, settings, nil))
+	})
+
+	t.Run("Helm", func(t *testing.T) {
+		t.Parallel()
+		settings := ExportSettings{
+			CreateHelmChart: true,
+			SecretBackend:   SecretBackendVaultCSI,
+			RoleManifest:    &model.RoleManifest{},
+		}
+		for _, backend := range []string{SecretBackendKubernetes, SecretBackendVaultCSI} {
+			found := secretVars(t, settings, map[string]interface{}{"Values.kube.secrets.backend": backend})
+			assert.Len(t, found, 1, "The %s backend should refer to the secret", backend)
+		}
+		found := secretVars(t, settings, map[string]interface{}{"Values.kube.secrets.backend": SecretBackendVaultAgent})
+		assert.Empty(t, found)
+	})
+}
+
+func TestVaultSecretsPodTemplate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("KubeVaultAgent", func(t *testing.T) {
+		t.Parallel()
+		role := podTemplateTestLoadRole(assert.New(t))
+		require.NotNil(t, role)
+
+		podTemplate, err := NewPodTemplate(role, ExportSettings{SecretBackend: SecretBackendVaultAgent}, nil)
+		require.NoError(t, err)
+		actual, err := RoundtripKube(podTemplate.Get("metadata", "annotations"))
+		require.NoError(t, err)
+		annotations := actual.(map[interface{}]interface{})
+		assert.Equal(t, "true", annotations["vault.hashicorp.com/agent-inject"])
+		assert.Equal(t, "fissile", annotations["vault.hashicorp.com/role"])
+		assert.Equal(t, "secret/data/fissile", annotations["vault.hashicorp.com/agent-inject-secret-fissile.env"])
+		assert.Equal(t,
+			`{{ with secret "secret/data/fissile" }}`+
+				`export SECRET_VAR="$(echo '{{ index .Data.data "secret-var" | base64Encode }}' | base64 -d)"{{ end }}`+"\n",
+			annotations["vault.hashicorp.com/agent-inject-template-fissile.env"])
+		assert.NotContains(t, annotations, "vault.hashicorp.com/agent-pre-populate-only")
+	})
+
+	t.Run("KubeVaultAgentTask", func(t *testing.T) {
+		t.Parallel()
+		role := podTemplateTestLoadRole(assert.New(t))
+		require.NotNil(t, role)
+		role.Type = model.RoleTypeBoshTask
+
+		podTemplate, err := NewPodTemplate(role, ExportSettings{SecretBackend: SecretBackendVaultAgent}, nil)
+		require.NoError(t, err)
+		actual, err := RoundtripKube(podTemplate.Get("metadata", "annotations"))
+		require.NoError(t, err)
+		annotations := actual.(map[interface{}]interface{})
+		assert.Equal(t, "true", annotations["vault.hashicorp.com/agent-inject"])
+		assert.Equal(t, "true", annotations["vault.hashicorp.com/agent-pre-populate-only"],
+			"The agent of a task should only run as an init container")
+	})
+
+	t.Run("HelmVaultAgent", func(t *testing.T) {
+		t.Parallel()
+		role := podTemplateTestLoadRole(assert.New(t))
+		require.NotNil(t, role)
+
+		settings := ExportSettings{CreateHelmChart: true, SecretBackend: SecretBackendVaultAgent}
+		podTemplate, err := NewPodTemplate(role, settings, nil)
+		require.NoError(t, err)
+		actual, err := RoundtripNode(podTemplate.Get("metadata", "annotations"), map[string]interface{}{
+			"Values.kube.secrets.backend":    SecretBackendVaultAgent,
+			"Values.kube.secrets.vault.path": "kv/data/scf",
+			"Values.kube.secrets.vault.role": "scf",
+		})
+		require.NoError(t, err)
+		annotations := actual.(map[interface{}]interface{})
+		assert.Equal(t, "scf", annotations["vault.hashicorp.com/role"])
+		assert.Equal(t, "kv/data/scf", annotations["vault.hashicorp.com/agent-inject-secret-fissile.env"])
+		assert.Contains(t, annotations["vault.hashicorp.com/agent-inject-template-fissile.env"], `{{ with secret "kv/data/scf" }}`)
+
+		actual, err = RoundtripNode(podTemplate.Get("metadata", "annotations"), map[string]interface{}{
+			"Values.kube.secrets.backend": SecretBackendKubernetes,
+		})
+		require.NoError(t, err)
+		assert.NotContains(t, actual, "vault.hashicorp.com/agent-inject")
+	})
+
+	t.Run("HelmVaultCSI", func(t *testing.T) {
+		t.Parallel()
+		role := podTemplateTestLoadRole(assert.New(t))
+		require.NotNil(t, role)
+
+		settings := ExportSettings{CreateHelmChart: true, SecretBackend: SecretBackendVaultCSI}
+		podTemplate, err := NewPodTemplate(role, settings, nil)
+		require.NoError(t, err)
+		config := map[string]interface{}{"Values.kube.secrets.backend": SecretBackendVaultCSI}
+		actual, err := RoundtripNode(podTemplate.Get("spec", "volumes"), config)
+		require.NoError(t, err)
+		assert.Contains(t, actual, map[interface{}]interface{}{
+			"name": "vault-secrets",
+			"csi": map[interface{}]interface{}{
+				"driver":           "secrets-store.csi.k8s.io",
+				"readOnly":         true,
+				"volumeAttributes": map[interface{}]interface{}{"secretProviderClass": "secrets"},
+			},
+		})
+		container := podTemplate.Get("spec", "containers").Values()[0]
+		actual, err = RoundtripNode(container.Get("volumeMounts"), config)
+		require.NoError(t, err)
+		assert.Contains(t, actual,
+			map[interface{}]interface{}{"mountPath": "/vault/secrets", "name": "vault-secrets", "readOnly": true})
+
+		actual, err = RoundtripNode(podTemplate.Get("spec", "volumes"), map[string]interface{}{
+			"Values.kube.secrets.backend": SecretBackendKubernetes,
+		})
+		require.NoError(t, err)
+		assert.Len(t, actual, 1, "Only the deployment manifest should be mounted")
+	})
+}
+
+func TestMakeSecretProviderClass(t *testing.T) {
+	t.Parallel()
+
+	secrets := model.CVMap{
+		"B_SECRET": &model.VariableDefinition{Name: "B_SECRET"},
+		"A_SECRET": &model.VariableDefinition{Name: "A_SECRET"},
+	}
+
+	providerClass, err := MakeSecretProviderClass(secrets, ExportSettings{})
+	require.NoError(t, err)
+	assert.Nil(t, providerClass, "The kubernetes backend doesn't need a provider class")
+
+	providerClass, err = MakeSecretProviderClass(secrets, ExportSettings{SecretBackend: SecretBackendVaultCSI})
+	require.NoError(t, err)
+	actual, err := RoundtripKube(providerClass)
+	require.NoError(t, err)
+	yamltest.IsYAMLEqualString(assert.New(t), `---
+		apiVersion: secrets-store.csi.x-k8s.io/v1
+		kind: SecretProviderClass
+		metadata:
+			name: secrets
+			labels:
+				app.kubernetes.io/component: secrets
+		spec:
+			provider: vault
+			parameters:
+				vaultAddress: http://vault.vault:8200
+				roleName: fissile
+				objects: '[{"objectName": "a-secret", "secretPath": "secret/data/fissile", "secretKey": "a-secret"}, {"objectName": "b-secret", "secretPath": "secret/data/fissile", "secretKey": "b-secret"}]'
+			secretObjects:
+			-	secretName: secrets
+				type: Opaque
+				data:
+				-	objectName: a-secret
+					key: a-secret
+				-	objectName: b-secret
+					key: b-secret
+	`, actual)
+}
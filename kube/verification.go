@@ -0,0 +1,154 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// verificationName is the name of the post-deploy verification job and its
+// service account
+const verificationName = "post-deploy-verification"
+
+// verificationScript waits for the pods of each instance group to become
+// ready, and then for each endpoint to respond, failing once the timeout has
+// been exceeded.
+const verificationScript = `set -o errexit -o nounset
+deadline=$(( $(date +%s) + TIMEOUT ))
+check_deadline() {
+  if [ "$(date +%s)" -ge "${deadline}" ]; then
+    echo "Deployment did not converge within ${TIMEOUT} seconds: $1" >&2
+    exit 1
+  fi
+}
+for group in ${INSTANCE_GROUPS}; do
+  selector="app.kubernetes.io/component=${group},app.kubernetes.io/instance=${RELEASE_NAME}"
+  until kubectl wait pod --namespace "${NAMESPACE}" --selector "${selector}" --for condition=Ready --timeout 10s >/dev/null 2>&1; do
+    check_deadline "instance group ${group} is not ready"
+    sleep 5
+  done
+  echo "Instance group ${group} is ready"
+done
+for endpoint in ${ENDPOINTS}; do
+  until curl --fail --silent --show-error --output /dev/null --max-time 10 "${endpoint}"; do
+    check_deadline "endpoint ${endpoint} is not responding"
+    sleep 5
+  done
+  echo "Endpoint ${endpoint} is responding"
+done
+`
+
+// NewVerificationJob returns a Job verifying that a helm release converges:
+// it waits for the pods of all long-running instance groups to become ready
+// and for the endpoints in .Values.verification.endpoints to respond. The job
+// runs as a post-install and post-upgrade hook, so the release fails if the
+// deployment did not converge within .Values.verification.timeout seconds.
+// It is returned along with the RBAC resources allowing it to watch the pods.
+func NewVerificationJob(settings ExportSettings) ([]helm.Node, error) {
+	if !settings.CreateHelmChart {
+		return nil, fmt.Errorf("Post-deploy verification job requires a helm chart")
+	}
+
+	rbacBlock := helm.Block(fmt.Sprintf(`if and .Values.verification.enabled (%s) (%s)`,
+		`eq (printf "%s" .Values.kube.auth) "rbac"`,
+		`.Capabilities.APIVersions.Has "rbac.authorization.k8s.io/v1"`))
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("v1").
+		SetKind("ServiceAccount").
+		SetName(verificationName).
+		AddModifier(rbacBlock).
+		AddModifier(helm.Comment("Service account of the post-deploy verification job"))
+	serviceAccount, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+
+	role, err := NewRBACRole(verificationName, RBACRoleKindRole, model.AuthRole{
+		model.AuthRule{
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+			Verbs:     []string{"get", "list", "watch"},
+		},
+	}, settings)
+	if err != nil {
+		return nil, err
+	}
+	role.Set(rbacBlock)
+
+	cb = NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("rbac.authorization.k8s.io/v1").
+		SetKind("RoleBinding").
+		SetName(verificationName + "-binding").
+		AddModifier(rbacBlock)
+	binding, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	binding.Add("subjects", helm.NewList(helm.NewMapping(
+		"kind", "ServiceAccount",
+		"name", resourceName(verificationName, settings))))
+	binding.Add("roleRef", helm.NewMapping(
+		"apiGroup", "rbac.authorization.k8s.io",
+		"kind", "Role",
+		"name", resourceName(verificationName, settings)))
+
+	var instanceGroups []string
+	for _, instanceGroup := range settings.RoleManifest.InstanceGroups {
+		if instanceGroup.Run == nil || instanceGroup.IsColocated() ||
+			instanceGroup.Type != model.RoleTypeBosh ||
+			instanceGroup.Run.FlightStage != model.FlightStageFlight {
+			continue
+		}
+		name := " " + instanceGroup.Name
+		if block := featureCheckBlock(instanceGroup); block != "" {
+			name = fmt.Sprintf("{{ %s }}%s{{ end }}", block, name)
+		}
+		instanceGroups = append(instanceGroups, name)
+	}
+
+	env := helm.NewList(
+		helm.NewMapping("name", "TIMEOUT", "value", "{{ .Values.verification.timeout | quote }}"),
+		helm.NewMapping("name", "INSTANCE_GROUPS", "value", strings.TrimPrefix(strings.Join(instanceGroups, ""), " ")),
+		helm.NewMapping("name", "ENDPOINTS", "value", `{{ join " " .Values.verification.endpoints | quote }}`),
+		helm.NewMapping("name", "RELEASE_NAME", "value", "{{ .Release.Name | quote }}"),
+		helm.NewMapping("name", "NAMESPACE", "valueFrom",
+			helm.NewMapping("fieldRef", helm.NewMapping("fieldPath", "metadata.namespace"))))
+
+	container := helm.NewMapping(
+		"name", verificationName,
+		"image", "{{ .Values.verification.image }}",
+		"command", []string{"/bin/sh", "-c", verificationScript},
+		"env", env)
+
+	spec := helm.NewMapping()
+	spec.Add("containers", helm.NewList(container))
+	spec.Add("restartPolicy", "Never")
+	spec.Add("serviceAccountName", resourceName(verificationName, settings), authModeRBAC(settings))
+	spec.Sort()
+
+	cb = NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion("batch/v1").
+		SetKind("Job").
+		SetName(verificationName).
+		AddModifier(helm.Block("if .Values.verification.enabled")).
+		AddModifier(helm.Comment("Verifies that all instance groups become ready after installs and upgrades"))
+	job, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+	job.Get("metadata").(*helm.Mapping).Add("annotations", helm.NewMapping(
+		"helm.sh/hook", "post-install,post-upgrade",
+		"helm.sh/hook-delete-policy", "before-hook-creation"))
+	job.Add("spec", helm.NewMapping(
+		"backoffLimit", 0,
+		"activeDeadlineSeconds", "{{ add (int .Values.verification.timeout) 60 }}",
+		"template", helm.NewMapping("spec", spec)))
+
+	return []helm.Node{serviceAccount, role, binding, job}, nil
+}
@@ -0,0 +1,112 @@
+package kube
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/model/loader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewVerificationJob(t *testing.T) {
+	t.Parallel()
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	manifestPath := filepath.Join(workDir, "../test-assets/role-manifests/kube/pod-with-valid-pod-anti-affinity.yml")
+	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	manifest, err := loader.LoadRoleManifest(manifestPath, model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{releasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../test-assets/.final_releases")},
+		ValidationOptions: model.RoleManifestValidationOptions{
+			AllowMissingScripts: true,
+		}})
+	require.NoError(t, err)
+	manifest.LookupInstanceGroup("istio-managed-group").IfFeature = "istio"
+
+	settings := ExportSettings{
+		CreateHelmChart: true,
+		RoleManifest:    manifest,
+	}
+
+	_, err = NewVerificationJob(ExportSettings{RoleManifest: manifest})
+	assert.Error(t, err, "Should require a helm chart")
+
+	nodes, err := NewVerificationJob(settings)
+	require.NoError(t, err)
+	require.Len(t, nodes, 4)
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.kube.auth": "rbac",
+		}
+		for _, node := range nodes {
+			actual, err := RoundtripNode(node, config)
+			require.NoError(t, err)
+			assert.Nil(t, actual)
+		}
+	})
+
+	t.Run("RBAC", func(t *testing.T) {
+		t.Parallel()
+		config := map[string]interface{}{
+			"Values.kube.auth":            "rbac",
+			"Values.verification.enabled": true,
+		}
+		for i, kind := range []string{"ServiceAccount", "Role", "RoleBinding"} {
+			actual, err := RoundtripNode(nodes[i], config)
+			require.NoError(t, err)
+			assert.Equal(t, kind, actual.(map[interface{}]interface{})["kind"])
+		}
+	})
+
+	for _, istio := range []bool{false, true} {
+		istio := istio
+		t.Run("Job", func(t *testing.T) {
+			t.Parallel()
+			config := map[string]interface{}{
+				"Values.enable.istio":           istio,
+				"Values.verification.enabled":   true,
+				"Values.verification.endpoints": []interface{}{"http://a/health", "http://b/health"},
+				"Values.verification.timeout":   300,
+			}
+			actual, err := RoundtripNode(nodes[3], config)
+			require.NoError(t, err)
+
+			job := actual.(map[interface{}]interface{})
+			assert.Equal(t, "Job", job["kind"])
+			annotations := job["metadata"].(map[interface{}]interface{})["annotations"].(map[interface{}]interface{})
+			assert.Equal(t, "post-install,post-upgrade", annotations["helm.sh/hook"])
+
+			spec := job["spec"].(map[interface{}]interface{})
+			assert.Equal(t, 0, spec["backoffLimit"])
+			assert.Equal(t, 360, spec["activeDeadlineSeconds"])
+
+			podSpec := spec["template"].(map[interface{}]interface{})["spec"].(map[interface{}]interface{})
+			assert.Equal(t, "Never", podSpec["restartPolicy"])
+			assert.NotContains(t, podSpec, "serviceAccountName")
+
+			container := podSpec["containers"].([]interface{})[0].(map[interface{}]interface{})
+			env := map[interface{}]interface{}{}
+			for _, envVar := range container["env"].([]interface{}) {
+				envVar := envVar.(map[interface{}]interface{})
+				env[envVar["name"]] = envVar["value"]
+			}
+			assert.Equal(t, "300", env["TIMEOUT"])
+			assert.Equal(t, "http://a/health http://b/health", env["ENDPOINTS"])
+			assert.Equal(t, "MyRelease", env["RELEASE_NAME"])
+			if istio {
+				assert.Equal(t, "some-group istio-managed-group", env["INSTANCE_GROUPS"])
+			} else {
+				assert.Equal(t, "some-group", env["INSTANCE_GROUPS"])
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/helm"
+	"code.cloudfoundry.org/fissile/model"
+)
+
+// vpaAPIVersion is the API version of the VerticalPodAutoscaler resources
+const vpaAPIVersion = "autoscaling.k8s.io/v1"
+
+// NewVerticalPodAutoscaler returns a VerticalPodAutoscaler for the stateful
+// set of the instance group, in recommendation mode: it only collects sizing
+// recommendations for the containers of the pods, without evicting them. It
+// is only created if .Values.vertical_pod_autoscaler.enabled is set and the
+// cluster provides the VPA resources.
+func NewVerticalPodAutoscaler(instanceGroup *model.InstanceGroup, settings ExportSettings) (helm.Node, error) {
+	if !settings.CreateHelmChart {
+		return nil, fmt.Errorf("Vertical pod autoscalers require a helm chart")
+	}
+
+	conditions := []string{
+		".Values.vertical_pod_autoscaler.enabled",
+		fmt.Sprintf("(.Capabilities.APIVersions.Has %q)", vpaAPIVersion),
+	}
+	if block := featureCheckBlock(instanceGroup); block != "" {
+		conditions = append(conditions, fmt.Sprintf("(%s)", strings.TrimPrefix(block, "if ")))
+	}
+
+	cb := NewConfigBuilder().
+		SetSettings(&settings).
+		SetAPIVersion(vpaAPIVersion).
+		SetKind("VerticalPodAutoscaler").
+		SetName(instanceGroup.Name).
+		AddModifier(helm.Block(fmt.Sprintf("if and %s", strings.Join(conditions, " ")))).
+		AddModifier(helm.Comment(fmt.Sprintf("Sizing recommendations for the %s instance group", instanceGroup.Name)))
+	vpa, err := cb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build a new kube config: %v", err)
+	}
+
+	spec := helm.NewMapping()
+	spec.Add("targetRef", helm.NewMapping(
+		"apiVersion", "apps/v1",
+		"kind", "StatefulSet",
+		"name", resourceName(instanceGroup.Name, settings)))
+	// Off only records the recommendations, in the status of the resource
+	spec.Add("updatePolicy", helm.NewMapping("updateMode", "Off"))
+	vpa.Add("spec", spec)
+
+	return vpa, nil
+}
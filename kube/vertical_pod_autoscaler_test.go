@@ -0,0 +1,84 @@
+package kube
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/yamltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewVerticalPodAutoscaler(t *testing.T) {
+	t.Parallel()
+
+	instanceGroup := &model.InstanceGroup{
+		Name:      "main-role",
+		Type:      model.RoleTypeBosh,
+		IfFeature: "extra",
+		Run:       &model.RoleRun{FlightStage: model.FlightStageFlight},
+	}
+	settings := ExportSettings{
+		CreateHelmChart: true,
+		RoleManifest:    &model.RoleManifest{InstanceGroups: model.InstanceGroups{instanceGroup}},
+	}
+
+	_, err := NewVerticalPodAutoscaler(instanceGroup, ExportSettings{RoleManifest: settings.RoleManifest})
+	assert.Error(t, err, "Should require a helm chart")
+
+	vpa, err := NewVerticalPodAutoscaler(instanceGroup, settings)
+	require.NoError(t, err)
+
+	enabled := map[string]interface{}{
+		"Values.vertical_pod_autoscaler.enabled": true,
+		"Values.enable.extra":                    true,
+		"Capabilities.APIVersions":               &APIVersions{vpaAPIVersion: true},
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(vpa, map[string]interface{}{})
+		require.NoError(t, err)
+		assert.Nil(t, actual)
+	})
+
+	t.Run("MissingAPI", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(vpa, map[string]interface{}{
+			"Values.vertical_pod_autoscaler.enabled": true,
+			"Values.enable.extra":                    true,
+		})
+		require.NoError(t, err)
+		assert.Nil(t, actual)
+	})
+
+	t.Run("FeatureDisabled", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(vpa, map[string]interface{}{
+			"Values.vertical_pod_autoscaler.enabled": true,
+			"Values.enable.extra":                    false,
+			"Capabilities.APIVersions":               &APIVersions{vpaAPIVersion: true},
+		})
+		require.NoError(t, err)
+		assert.Nil(t, actual)
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		t.Parallel()
+		actual, err := RoundtripNode(vpa, enabled)
+		require.NoError(t, err)
+		yamltest.IsYAMLSubsetString(assert.New(t), `---
+			apiVersion: autoscaling.k8s.io/v1
+			kind: VerticalPodAutoscaler
+			metadata:
+				name: main-role
+			spec:
+				targetRef:
+					apiVersion: apps/v1
+					kind: StatefulSet
+					name: main-role
+				updatePolicy:
+					updateMode: "Off"
+		`, actual)
+	})
+}
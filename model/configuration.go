@@ -1,6 +1,8 @@
 package model
 
 import (
+	"sort"
+
 	"code.cloudfoundry.org/fissile/util"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -11,6 +13,30 @@ type Configuration struct {
 	Authorization ConfigurationAuthorization       `yaml:"auth,omitempty"`
 	RawTemplates  yaml.MapSlice                    `yaml:"templates"`
 	Templates     map[string]ConfigurationTemplate `yaml:"-"`
+	// TemplateSources records where the templates not written in the role
+	// manifest come from, e.g. the addons of a runtime config
+	TemplateSources map[string]string        `yaml:"-"`
+	ProbeProfiles   map[string]*ProbeProfile `yaml:"probe_profiles,omitempty"`
+	// ClusterScoped lists the kinds of cluster-scoped resources the
+	// generated configs may create, when auditing them; see
+	// ClusterScopedKinds
+	ClusterScoped []string `yaml:"cluster_scoped,omitempty"`
+}
+
+// ClusterScopedKinds are the kinds of cluster-scoped resources, which are
+// shared by all tenants of a cluster
+var ClusterScopedKinds = []string{
+	"APIService",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"CustomResourceDefinition",
+	"MutatingWebhookConfiguration",
+	"Namespace",
+	"PersistentVolume",
+	"PodSecurityPolicy",
+	"PriorityClass",
+	"StorageClass",
+	"ValidatingWebhookConfiguration",
 }
 
 // ConfigurationTemplate contains one entry in a configuration template; this is
@@ -18,6 +44,9 @@ type Configuration struct {
 type ConfigurationTemplate struct {
 	Value    string
 	IsGlobal bool
+	// Source is where the template comes from if it is not written in the
+	// role manifest, for validation errors
+	Source string
 }
 
 // MarshalYAML implements the yaml.Marshaler interface
@@ -34,8 +63,13 @@ type ConfigurationAuthorization struct {
 	ClusterRoleUsedBy   map[string]map[string]struct{} `yaml:"-"`
 	PodSecurityPolicies map[string]*PodSecurityPolicy  `yaml:"pod-security-policies,omitempty"`
 	Accounts            map[string]AuthAccount         `yaml:"accounts,omitempty"`
+	AggregateTo         map[string][]string            `yaml:"aggregate-to,omitempty"`
 }
 
+// AggregationTargets lists the builtin cluster roles the rules of a cluster
+// role can be aggregated into, via Configuration.Authorization.AggregateTo
+var AggregationTargets = []string{"admin", "edit", "view"}
+
 // Notes: It was decided to use a separate `RoleUse` map to hold the
 // usage count for the roles to keep the API to the role manifest
 // yaml.  Going to a structure for AuthRole, with a new field for the
@@ -73,6 +107,41 @@ func (rule *AuthRule) IsPodSecurityPolicyRule() bool {
 // An AuthRole is a role for RBAC authorization
 type AuthRole []AuthRule
 
+// AccountPodSecurityPolicies returns the sorted names of the pod security
+// policies the roles and cluster roles of the account may use
+func (auth *ConfigurationAuthorization) AccountPodSecurityPolicies(accountName string) []string {
+	account, ok := auth.Accounts[accountName]
+	if !ok {
+		return nil
+	}
+
+	var roles []AuthRole
+	for _, roleName := range account.Roles {
+		roles = append(roles, auth.Roles[roleName])
+	}
+	for _, roleName := range account.ClusterRoles {
+		roles = append(roles, auth.ClusterRoles[roleName])
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	for _, role := range roles {
+		for _, rule := range role {
+			if !rule.IsPodSecurityPolicyRule() {
+				continue
+			}
+			for _, name := range rule.ResourceNames {
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 // An AuthAccount is a service account for RBAC authorization
 // The NumGroups field records the number of instance groups
 // referencing the account in question.
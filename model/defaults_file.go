@@ -0,0 +1,74 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultsFile holds defaults of variables, e.g. for an environment, that
+// override the defaults of the role manifest. Files named *.yml or *.yaml are
+// YAML mappings of the variable names to their defaults; all others are
+// dotenv files of NAME=value lines.
+type DefaultsFile struct {
+	Path     string
+	Defaults map[string]interface{}
+}
+
+// LoadDefaultsFile reads a defaults file
+func LoadDefaultsFile(path string) (*DefaultsFile, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defaultsFile := &DefaultsFile{Path: path, Defaults: map[string]interface{}{}}
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(content, &defaultsFile.Defaults)
+	default:
+		err = parseDotenv(content, defaultsFile.Defaults)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing defaults file %s: %v", path, err)
+	}
+	return defaultsFile, nil
+}
+
+// parseDotenv reads the NAME=value lines of a dotenv file into defaults.
+// Blank lines and comments are skipped, and a leading "export" is ignored.
+// Values in double quotes may have escapes; values in single quotes are
+// taken literally.
+func parseDotenv(content []byte, defaults map[string]interface{}) error {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		separator := strings.Index(line, "=")
+		if separator < 1 {
+			return fmt.Errorf("line %d: expected NAME=value", number)
+		}
+		name := strings.TrimSpace(line[:separator])
+		value := strings.TrimSpace(line[separator+1:])
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid quoted value of %s", number, name)
+			}
+			value = unquoted
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		}
+		defaults[name] = value
+	}
+	return scanner.Err()
+}
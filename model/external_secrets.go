@@ -0,0 +1,50 @@
+package model
+
+// ExternalSecretsType is the kind of resources syncing the secret variables
+// from an external secret store into a Kubernetes secret
+type ExternalSecretsType string
+
+// These are the supported external secrets types
+const (
+	ExternalSecretsTypeExternalSecret      = ExternalSecretsType("external-secret")       // An ExternalSecret of external-secrets.io
+	ExternalSecretsTypeSecretProviderClass = ExternalSecretsType("secret-provider-class") // A SecretProviderClass of the secrets store CSI driver
+)
+
+// These are the providers of the secrets store CSI driver whose objects the
+// SecretProviderClass can list
+const (
+	ExternalSecretsProviderAWS   = "aws"
+	ExternalSecretsProviderAzure = "azure"
+)
+
+// ExternalSecrets describes the external secret store (e.g. AWS Secrets
+// Manager or Azure Key Vault) the secret variables with an external key are
+// read from, instead of the values and the secret generation job
+type ExternalSecrets struct {
+	Type ExternalSecretsType `yaml:"type"`
+	// Store is the name of the SecretStore an ExternalSecret reads from, of
+	// kind StoreKind (SecretStore or ClusterSecretStore)
+	Store     string `yaml:"store,omitempty"`
+	StoreKind string `yaml:"store_kind,omitempty"`
+	// RefreshInterval is how often an ExternalSecret is synced, e.g. 1h
+	RefreshInterval string `yaml:"refresh_interval,omitempty"`
+	// Provider is the CSI provider of a SecretProviderClass, with its
+	// provider specific Parameters, e.g. the region or keyvaultName
+	Provider   string            `yaml:"provider,omitempty"`
+	Parameters map[string]string `yaml:"parameters,omitempty"`
+}
+
+// ExternalSecretVariables returns the secret variables read from the external
+// secret store
+func (m *RoleManifest) ExternalSecretVariables() Variables {
+	var variables Variables
+	if m.ExternalSecrets == nil {
+		return variables
+	}
+	for _, variable := range m.Variables {
+		if variable.CVOptions.Secret && variable.CVOptions.ExternalKey != "" {
+			variables = append(variables, variable)
+		}
+	}
+	return variables
+}
@@ -33,25 +33,112 @@ func (igs InstanceGroups) Swap(i, j int) {
 	igs[i], igs[j] = igs[j], igs[i]
 }
 
+// WithoutZoneReplicas returns the instance groups with the zone replicas
+// replaced by the instance groups they were created from, i.e. one instance
+// group per role image
+func (igs InstanceGroups) WithoutZoneReplicas() InstanceGroups {
+	var result InstanceGroups
+	seen := map[*InstanceGroup]bool{}
+	for _, instanceGroup := range igs {
+		if instanceGroup.ReplicaOf != nil {
+			instanceGroup = instanceGroup.ReplicaOf
+		}
+		if !seen[instanceGroup] {
+			seen[instanceGroup] = true
+			result = append(result, instanceGroup)
+		}
+	}
+	return result
+}
+
+// GroupByStemcell groups the instance groups by the stemcell images they are
+// built on, using defaultStemcell for those without a stemcell of their own.
+// The stemcells are listed in the order of their first instance groups.
+func (igs InstanceGroups) GroupByStemcell(defaultStemcell string) ([]string, map[string]InstanceGroups) {
+	var stemcells []string
+	groups := map[string]InstanceGroups{}
+	for _, instanceGroup := range igs {
+		stemcell := instanceGroup.StemcellImage()
+		if stemcell == "" {
+			stemcell = defaultStemcell
+		}
+		if _, ok := groups[stemcell]; !ok {
+			stemcells = append(stemcells, stemcell)
+		}
+		groups[stemcell] = append(groups[stemcell], instanceGroup)
+	}
+	return stemcells, groups
+}
+
 // InstanceGroup represents a collection of jobs that are colocated on a container
 type InstanceGroup struct {
-	Name              string         `yaml:"name"`
-	DefaultFeature    string         `yaml:"default_feature"`
-	IfFeature         string         `yaml:"if_feature"`
-	UnlessFeature     string         `yaml:"unless_feature"`
-	Description       string         `yaml:"description"`
-	EnvironScripts    []string       `yaml:"environment_scripts"`
-	Scripts           []string       `yaml:"scripts"`
-	PostConfigScripts []string       `yaml:"post_config_scripts"`
-	Type              RoleType       `yaml:"type,omitempty"`
-	JobReferences     JobReferences  `yaml:"jobs"`
-	Configuration     *Configuration `yaml:"configuration"`
-	Tags              []RoleTag      `yaml:"tags"`
-	Run               *RoleRun       `yaml:"-"`
+	Name              string               `yaml:"name"`
+	DefaultFeature    string               `yaml:"default_feature"`
+	IfFeature         string               `yaml:"if_feature"`
+	UnlessFeature     string               `yaml:"unless_feature"`
+	Description       string               `yaml:"description"`
+	EnvironScripts    []string             `yaml:"environment_scripts"`
+	Scripts           []string             `yaml:"scripts"`
+	PostConfigScripts []string             `yaml:"post_config_scripts"`
+	Type              RoleType             `yaml:"type,omitempty"`
+	JobReferences     JobReferences        `yaml:"jobs"`
+	Configuration     *Configuration       `yaml:"configuration"`
+	Tags              []RoleTag            `yaml:"tags"`
+	Zones             []*InstanceGroupZone `yaml:"zones,omitempty"`
+	// PreviousNames are former names of the instance group; the stateful
+	// sets and volumes of these are migrated to the current name when
+	// upgrading a helm release
+	PreviousNames []string `yaml:"previous_names,omitempty"`
+	// Monitoring describes how Prometheus scrapes the metrics of the pods
+	Monitoring *InstanceGroupMonitoring `yaml:"monitoring,omitempty"`
+	// Stemcell is the stemcell image the packages of the instance group are
+	// compiled on, and its image is built on, instead of the stemcell of
+	// the build
+	Stemcell string   `yaml:"stemcell,omitempty"`
+	Run      *RoleRun `yaml:"-"`
+
+	// Zone and ReplicaOf are set on the replicas of instance groups with
+	// zones; the replicas replace the original instance group in the manifest
+	Zone      *InstanceGroupZone `yaml:"-"`
+	ReplicaOf *InstanceGroup     `yaml:"-"`
 
 	roleManifest *RoleManifest
 }
 
+// InstanceGroupZone describes a replica of an instance group pinned to an
+// availability zone, optionally overriding the run properties of the group
+type InstanceGroupZone struct {
+	Name    string          `yaml:"name"`
+	Label   string          `yaml:"label,omitempty"` // Value of the zone label of the nodes; defaults to the name
+	Scaling *RoleRunScaling `yaml:"scaling,omitempty"`
+	Memory  *RoleRunMemory  `yaml:"mem,omitempty"`
+	CPU     *RoleRunCPU     `yaml:"cpu,omitempty"`
+}
+
+// InstanceGroupMonitoring describes the metrics endpoint of the pods of an
+// instance group, scraped by the Prometheus operator
+type InstanceGroupMonitoring struct {
+	// Port is the name of a port of the jobs, or of a sidecar
+	Port string `yaml:"port"`
+	// Path of the metrics; defaults to /metrics
+	Path string `yaml:"path,omitempty"`
+	// Interval between scrapes, e.g. 30s; defaults to the interval of the
+	// Prometheus
+	Interval string `yaml:"interval,omitempty"`
+}
+
+// DefaultMonitoringPath is the path of the metrics of instance groups not
+// setting one
+const DefaultMonitoringPath = "/metrics"
+
+// MetricsPath returns the path of the metrics
+func (m *InstanceGroupMonitoring) MetricsPath() string {
+	if m.Path == "" {
+		return DefaultMonitoringPath
+	}
+	return m.Path
+}
+
 // RoleType is the type of the role; see the constants below
 type RoleType string
 
@@ -71,6 +158,9 @@ const (
 	RoleTagSequentialStartup = RoleTag("sequential-startup")
 	RoleTagActivePassive     = RoleTag("active-passive")
 	RoleTagIstioManaged      = RoleTag("istio-managed")
+	// RoleTagNoDeploymentManifest skips mounting the deployment-manifest
+	// secret into the pods, for jobs which never read it
+	RoleTagNoDeploymentManifest = RoleTag("no-deployment-manifest")
 )
 
 // SetRoleManifest adds a reference to the instance groups role manifest
@@ -83,6 +173,18 @@ func (g *InstanceGroup) Manifest() *RoleManifest {
 	return g.roleManifest
 }
 
+// ProbeProfile returns the probe profile referenced by the health check of
+// the instance group, or nil if there is none
+func (g *InstanceGroup) ProbeProfile() *ProbeProfile {
+	if g.Run == nil || g.Run.HealthCheck == nil || g.Run.HealthCheck.Profile == "" {
+		return nil
+	}
+	if g.roleManifest == nil || g.roleManifest.Configuration == nil {
+		return nil
+	}
+	return g.roleManifest.Configuration.ProbeProfiles[g.Run.HealthCheck.Profile]
+}
+
 // CalculateRoleRun collects properties from the jobs run properties and puts them on the instance group
 // It also validates where necessary and is run *before* validateRoleRun
 func (g *InstanceGroup) CalculateRoleRun() validation.ErrorList {
@@ -110,6 +212,20 @@ func (g *InstanceGroup) CalculateRoleRun() validation.ErrorList {
 
 	g.Run.mergeVolumes(jobReferences)
 
+	g.Run.mergeWritablePaths(jobReferences)
+
+	g.Run.mergeServiceAccountTokens(jobReferences)
+
+	g.Run.mergeTerminationGracePeriod(jobReferences)
+
+	for _, name := range g.Run.mergeSysctls(jobReferences) {
+		allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("instance_groups[%s].run.sysctls", g.Name), name, "Cannot set a sysctl to different values on jobs of the same instance group"))
+	}
+
+	for _, name := range g.Run.mergeSidecars(jobReferences) {
+		allErrs = append(allErrs, validation.Duplicate(fmt.Sprintf("instance_groups[%s].run.sidecars", g.Name), name))
+	}
+
 	g.Run.setMaxFields(jobReferences)
 
 	if ok := jobReferences.atMostOnce(healthCheckPresent); ok {
@@ -134,15 +250,132 @@ func (g *InstanceGroup) CalculateRoleRun() validation.ErrorList {
 		allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("instance_groups[%s]", g.Name), property, "Cannot specify Run.ServiceAccount properties on more than one job of the same instance group"))
 	}
 
+	if property, err := jobReferences.uniqueStringProperty(func(j JobReference) string {
+		return j.ContainerProperties.BoshContainerization.Run.DownwardAPIPath
+	}); err == nil {
+		g.Run.DownwardAPIPath = property
+	} else {
+		allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("instance_groups[%s]", g.Name), property, "Cannot specify Run.DownwardAPIPath properties on more than one job of the same instance group"))
+	}
+
 	if ok := jobReferences.atMostOnce(affinityPresent); ok {
 		g.Run.Affinity = jobReferences.firstAffinity()
 	} else {
 		allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("instance_groups[%s]", g.Name), jobReferences.firstHealthCheck(), "Cannot specify Run.HealthCheck properties on more than one job of the same instance group"))
 	}
 
+	if ok := jobReferences.atMostOnce(backupPresent); ok {
+		g.Run.Backup = jobReferences.firstBackup()
+	} else {
+		allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("instance_groups[%s]", g.Name), jobReferences.firstBackup(), "Cannot specify Run.Backup properties on more than one job of the same instance group"))
+	}
+
+	if ok := jobReferences.atMostOnce(jobSettingsPresent); ok {
+		g.Run.Job = jobReferences.firstJobSettings()
+	} else {
+		allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("instance_groups[%s]", g.Name), jobReferences.firstJobSettings(), "Cannot specify Run.Job properties on more than one job of the same instance group"))
+	}
+
+	if ok := jobReferences.atMostOnce(commandPresent); ok {
+		g.Run.Command, g.Run.Args = jobReferences.firstCommand()
+	} else {
+		command, _ := jobReferences.firstCommand()
+		allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("instance_groups[%s]", g.Name), command, "Cannot specify Run.Command or Run.Args properties on more than one job of the same instance group"))
+	}
+
 	return allErrs
 }
 
+// ZoneReplica returns a copy of the instance group named <group>-<zone>, to be
+// scheduled onto the nodes of the given zone. The run properties of the zone
+// override the ones of the instance group. The replica has copies of the job
+// references, so that links are resolved for each replica on its own.
+func (g *InstanceGroup) ZoneReplica(zone *InstanceGroupZone) *InstanceGroup {
+	replica := *g
+	replica.JobReferences = make(JobReferences, len(g.JobReferences))
+	for i, jobReference := range g.JobReferences {
+		replica.JobReferences[i] = jobReference.clone()
+	}
+	replica.Name = fmt.Sprintf("%s-%s", g.Name, zone.Name)
+	replica.Zones = nil
+	replica.Zone = zone
+	replica.ReplicaOf = g
+	replica.PreviousNames = nil
+	for _, previousName := range g.PreviousNames {
+		replica.PreviousNames = append(replica.PreviousNames, fmt.Sprintf("%s-%s", previousName, zone.Name))
+	}
+
+	if g.Run != nil {
+		run := *g.Run
+		if g.Run.Scaling != nil {
+			scaling := *g.Run.Scaling
+			run.Scaling = &scaling
+		}
+		if zone.Scaling != nil {
+			scaling := *zone.Scaling
+			if scaling.HA == 0 {
+				scaling.HA = scaling.Min
+			}
+			run.Scaling = &scaling
+		}
+		if zone.Memory != nil {
+			run.Memory = zone.Memory
+		}
+		if zone.CPU != nil {
+			run.CPU = zone.CPU
+		}
+		replica.Run = &run
+	}
+
+	return &replica
+}
+
+// ImageName returns the name the role image of the instance group is named
+// after; zone replicas share the image of the instance group they replicate
+func (g *InstanceGroup) ImageName() string {
+	if g.ReplicaOf != nil {
+		return g.ReplicaOf.Name
+	}
+	return g.Name
+}
+
+// StemcellImage returns the stemcell image the instance group is built on, as
+// declared for the instance group, or else for the releases of its jobs in
+// the release_stemcells of the role manifest. It is empty for instance groups
+// built on the stemcell of the build.
+func (g *InstanceGroup) StemcellImage() string {
+	if g.Stemcell != "" {
+		return g.Stemcell
+	}
+	stemcells := g.JobStemcells()
+	if len(stemcells) == 1 {
+		return stemcells[0]
+	}
+	return ""
+}
+
+// JobStemcells returns the sorted stemcell images of the releases of the jobs
+// of the instance group, with "" for those built on the stemcell of the build
+func (g *InstanceGroup) JobStemcells() []string {
+	seen := map[string]bool{}
+	var stemcells []string
+	for _, jobReference := range g.JobReferences {
+		if jobReference.Job == nil || jobReference.Job.Release == nil {
+			continue
+		}
+		var stemcell string
+		if g.roleManifest != nil {
+			stemcell = g.roleManifest.ReleaseStemcells[jobReference.Job.Release.Name]
+		}
+		if !seen[stemcell] {
+			seen[stemcell] = true
+			stemcells = append(stemcells, stemcell)
+		}
+	}
+	sort.Strings(stemcells)
+	return stemcells
+}
+
 // GetLongDescription returns the description of the instance group plus a list of all included jobs
 func (g *InstanceGroup) GetLongDescription() string {
 	desc := g.Description
@@ -260,6 +493,12 @@ func (g *InstanceGroup) GetRoleDevVersion(opinions *Opinions, tagExtra, fissileV
 		[]string{"version/fissile/", fissileVersion},
 		[]string{"extra/", tagExtra},
 	}
+	// Images built on a stemcell of their own are tagged apart from the
+	// ones built on the stemcell of the build
+	if stemcell := g.StemcellImage(); stemcell != "" {
+		signatures = append(signatures, stemcell)
+		extraGraphEdges = append(extraGraphEdges, []string{"stemcell/", stemcell})
+	}
 
 	if opinions != nil {
 		// Job order comes from the role manifest, and is sort of
@@ -383,6 +622,16 @@ func (g *InstanceGroup) getRoleJobAndPackagesSignature(grapher util.ModelGrapher
 	}
 	roleSignature = fmt.Sprintf("%s\n%s", roleSignature, sig)
 
+	// Overridden runtime scripts change the image; without them, the
+	// signature stays the same as before they were supported
+	if g.roleManifest != nil && g.roleManifest.RuntimeScripts != "" {
+		sig, err = g.roleManifest.GetRuntimeScriptSignatures()
+		if err != nil {
+			return "", nil, err
+		}
+		roleSignature = fmt.Sprintf("%s\n%s", roleSignature, sig)
+	}
+
 	// If there are templates, generate signature for them
 	if g.Configuration != nil && g.Configuration.Templates != nil {
 		sig, err = g.GetTemplateSignatures()
@@ -422,6 +671,7 @@ func (g *InstanceGroup) CalculateRoleConfigurationTemplates() {
 		g.Configuration.Templates[k] = ConfigurationTemplate{
 			Value:    v,
 			IsGlobal: false,
+			Source:   g.Configuration.TemplateSources[k],
 		}
 	}
 
@@ -480,6 +730,59 @@ func (g *InstanceGroup) GetColocatedRoles() InstanceGroups {
 	return result
 }
 
+// PodSysctls returns the sysctls of the pod of the instance group, i.e. those
+// of the instance group and of its colocated containers, sorted by name
+func (g *InstanceGroup) PodSysctls() []*RoleRunSysctl {
+	seen := map[string]bool{}
+	var sysctls []*RoleRunSysctl
+	for _, instanceGroup := range append(InstanceGroups{g}, g.GetColocatedRoles()...) {
+		if instanceGroup.Run == nil {
+			continue
+		}
+		for _, sysctl := range instanceGroup.Run.Sysctls {
+			if !seen[sysctl.Name] {
+				seen[sysctl.Name] = true
+				sysctls = append(sysctls, sysctl)
+			}
+		}
+	}
+	sort.Slice(sysctls, func(i, j int) bool { return sysctls[i].Name < sysctls[j].Name })
+	return sysctls
+}
+
+// PodSharedSockets returns the shared socket volumes of the pod of the
+// instance group, i.e. those of the instance group and of its colocated
+// containers, sorted by tag
+func (g *InstanceGroup) PodSharedSockets() []*RoleRunVolume {
+	seen := map[string]bool{}
+	var sockets []*RoleRunVolume
+	for _, instanceGroup := range append(InstanceGroups{g}, g.GetColocatedRoles()...) {
+		if instanceGroup.Run == nil {
+			continue
+		}
+		for _, volume := range instanceGroup.Run.Volumes {
+			if volume.Type == VolumeTypeSharedSocket && !seen[volume.Tag] {
+				seen[volume.Tag] = true
+				sockets = append(sockets, volume)
+			}
+		}
+	}
+	sort.Slice(sockets, func(i, j int) bool { return sockets[i].Tag < sockets[j].Tag })
+	return sockets
+}
+
+// UnsafeSysctls returns the sorted names of the sysctls of the pod of the
+// instance group which Kubernetes does not allow by default
+func (g *InstanceGroup) UnsafeSysctls() []string {
+	var names []string
+	for _, sysctl := range g.PodSysctls() {
+		if !sysctl.IsSafe() {
+			names = append(names, sysctl.Name)
+		}
+	}
+	return names
+}
+
 // PropertyDefaults is a map from property names to information about
 // it needed for validation.
 type PropertyDefaults map[string]*PropertyInfo
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func TestRolesSort(t *testing.T) {
@@ -114,3 +115,124 @@ func TestGetTemplateSignatures(t *testing.T) {
 	differentTemplateHash2, _ := differentTemplate2.GetTemplateSignatures()
 	assert.NotEqual(differentTemplateHash1, differentTemplateHash2, "template hash should be dependent on template contents")
 }
+
+func TestGetRuntimeScriptSignatures(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := ioutil.TempDir("", "fissile-test-")
+	assert.NoError(err)
+	defer os.RemoveAll(workDir)
+
+	scriptsDir := filepath.Join(workDir, "runtime")
+	assert.NoError(os.MkdirAll(filepath.Join(scriptsDir, "subdir"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(scriptsDir, "run.sh"), []byte("true\n"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(scriptsDir, "helper.sh"), []byte("true\n"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(scriptsDir, ".hidden"), []byte("true\n"), 0644))
+
+	roleManifest := &RoleManifest{ManifestFilePath: filepath.Join(workDir, "role.yml")}
+	instanceGroup := &InstanceGroup{Name: "aaa", roleManifest: roleManifest}
+	defaultHash, _, err := instanceGroup.getRoleJobAndPackagesSignature(nil)
+	assert.NoError(err)
+
+	roleManifest.RuntimeScripts = "runtime"
+	paths, err := roleManifest.GetRuntimeScriptPaths()
+	assert.NoError(err)
+	assert.Equal(map[string]string{
+		"run.sh":    filepath.Join(scriptsDir, "run.sh"),
+		"helper.sh": filepath.Join(scriptsDir, "helper.sh"),
+	}, paths)
+
+	overriddenHash, _, err := instanceGroup.getRoleJobAndPackagesSignature(nil)
+	assert.NoError(err)
+	assert.NotEqual(defaultHash, overriddenHash, "role hash should be dependent on the runtime scripts")
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(scriptsDir, "helper.sh"), []byte("false\n"), 0644))
+	changedHash, _, err := instanceGroup.getRoleJobAndPackagesSignature(nil)
+	assert.NoError(err)
+	assert.NotEqual(overriddenHash, changedHash, "role hash should be dependent on the runtime script contents")
+
+	roleManifest.RuntimeScripts = "missing"
+	_, err = roleManifest.GetRuntimeScriptPaths()
+	assert.Error(err)
+
+	// Names and contents are separated
+	for _, script := range []struct{ dir, name, contents string }{
+		{"split-name", "ab", "c"},
+		{"split-contents", "a", "bc"},
+	} {
+		assert.NoError(os.MkdirAll(filepath.Join(workDir, script.dir), 0755))
+		assert.NoError(ioutil.WriteFile(filepath.Join(workDir, script.dir, script.name), []byte(script.contents), 0644))
+	}
+	roleManifest.RuntimeScripts = "split-name"
+	splitName, err := roleManifest.GetRuntimeScriptSignatures()
+	assert.NoError(err)
+	roleManifest.RuntimeScripts = "split-contents"
+	splitContents, err := roleManifest.GetRuntimeScriptSignatures()
+	assert.NoError(err)
+	assert.NotEqual(splitName, splitContents)
+}
+
+func TestInstanceGroupStemcells(t *testing.T) {
+	assert := assert.New(t)
+
+	cflinuxfs := &Release{Name: "cflinuxfs"}
+	bionic := &Release{Name: "bionic"}
+	other := &Release{Name: "other"}
+	roleManifest := &RoleManifest{
+		ReleaseStemcells: map[string]string{
+			"cflinuxfs": "cflinuxfs3-stemcell",
+			"bionic":    "bionic-stemcell",
+		},
+	}
+	jobOf := func(release *Release) *JobReference {
+		return &JobReference{Job: &Job{Name: release.Name + "-job", Release: release}}
+	}
+	instanceGroups := InstanceGroups{
+		{Name: "default", JobReferences: JobReferences{jobOf(other)}},
+		{Name: "release", JobReferences: JobReferences{jobOf(cflinuxfs), jobOf(cflinuxfs)}},
+		{Name: "own", Stemcell: "own-stemcell", JobReferences: JobReferences{jobOf(cflinuxfs)}},
+		{Name: "mixed", JobReferences: JobReferences{jobOf(cflinuxfs), jobOf(bionic), jobOf(other)}},
+		{Name: "second", JobReferences: JobReferences{jobOf(bionic)}},
+	}
+	for _, instanceGroup := range instanceGroups {
+		instanceGroup.SetRoleManifest(roleManifest)
+	}
+
+	assert.Equal("", instanceGroups[0].StemcellImage())
+	assert.Equal("cflinuxfs3-stemcell", instanceGroups[1].StemcellImage())
+	assert.Equal("own-stemcell", instanceGroups[2].StemcellImage())
+	assert.Equal("", instanceGroups[3].StemcellImage())
+	assert.Equal([]string{"", "bionic-stemcell", "cflinuxfs3-stemcell"}, instanceGroups[3].JobStemcells())
+
+	stemcells, groups := instanceGroups.GroupByStemcell("default-stemcell")
+	assert.Equal([]string{"default-stemcell", "cflinuxfs3-stemcell", "own-stemcell", "bionic-stemcell"}, stemcells)
+	assert.Equal(InstanceGroups{instanceGroups[0], instanceGroups[3]}, groups["default-stemcell"])
+	assert.Equal(InstanceGroups{instanceGroups[4]}, groups["bionic-stemcell"])
+
+	// Images built on a stemcell of their own are tagged apart
+	defaultVersion, err := instanceGroups[0].GetRoleDevVersion(nil, "", "1.0", nil)
+	assert.NoError(err)
+	instanceGroups[0].Stemcell = "own-stemcell"
+	ownVersion, err := instanceGroups[0].GetRoleDevVersion(nil, "", "1.0", nil)
+	assert.NoError(err)
+	assert.NotEqual(defaultVersion, ownVersion)
+}
+
+func TestRoleRunSidecarPortProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	var sidecar RoleRunSidecar
+	assert.NoError(yaml.Unmarshal([]byte(`
+name: exporter
+ports:
+- name: metrics
+  port: 9100
+- name: syslog
+  port: 514
+  protocol: UDP
+`), &sidecar))
+	if assert.Len(sidecar.Ports, 2) {
+		assert.Equal("TCP", sidecar.Ports[0].Protocol, "The protocol should default to TCP")
+		assert.Equal("UDP", sidecar.Ports[1].Protocol)
+	}
+}
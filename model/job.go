@@ -304,21 +304,9 @@ type jobConfigTemplate struct {
 // GetPropertiesForJob returns the parameters for the given job, using its specs and opinions
 func (j *Job) GetPropertiesForJob(opinions *Opinions) (map[string]interface{}, error) {
 	props := make(map[string]interface{})
-	lightOpinions, ok := opinions.Light["properties"]
-	if !ok {
-		return nil, fmt.Errorf("getPropertiesForJob: no 'properties' key in light opinions")
-	}
-	darkOpinions, ok := opinions.Dark["properties"]
-	if !ok {
-		return nil, fmt.Errorf("getPropertiesForJob: no 'properties' key in dark opinions")
-	}
-	lightOpinionsByString, ok := lightOpinions.(map[interface{}]interface{})
-	if !ok {
-		return nil, fmt.Errorf("getPropertiesForJob: can't convert lightOpinions into a string map")
-	}
-	darkOpinionsByString, ok := darkOpinions.(map[interface{}]interface{})
-	if !ok {
-		return nil, fmt.Errorf("getPropertiesForJob: can't convert darkOpinions into a string map")
+	index, err := opinions.propertiesIndex()
+	if err != nil {
+		return nil, err
 	}
 	for _, property := range j.Properties {
 		keyPieces, err := getKeyGrams(property.Name)
@@ -337,7 +325,7 @@ func (j *Job) GetPropertiesForJob(opinions *Opinions) (map[string]interface{}, e
 		// or no value at all we consider the key to be an
 		// inner node which is not excluded.
 
-		darkValue, ok := getOpinionValue(darkOpinionsByString, keyPieces)
+		darkValue, ok := lookupOpinion(index.dark, keyPieces)
 		if ok {
 			if darkValue == nil {
 				// Ignore dark opinions
@@ -349,7 +337,7 @@ func (j *Job) GetPropertiesForJob(opinions *Opinions) (map[string]interface{}, e
 				continue
 			}
 		}
-		lightValue, hasLightValue := getOpinionValue(lightOpinionsByString, keyPieces)
+		lightValue, hasLightValue := lookupOpinion(index.light, keyPieces)
 		var finalValue interface{}
 		if hasLightValue && lightValue != nil {
 			finalValue = lightValue
@@ -3,8 +3,21 @@ package model
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 )
 
+// Application protocols of exposed ports, which service meshes and ingress
+// controllers use to decide how to handle the traffic
+const (
+	AppProtocolHTTP  = "http"
+	AppProtocolHTTPS = "https"
+	AppProtocolGRPC  = "grpc"
+	AppProtocolTCP   = "tcp"
+)
+
+// AppProtocols lists the supported application protocols of exposed ports
+var AppProtocols = []string{AppProtocolHTTP, AppProtocolHTTPS, AppProtocolGRPC, AppProtocolTCP}
+
 // JobReference from the deployment manifest, references a job spec from a release by ReleaseName
 type JobReference struct {
 	*Job                `yaml:"-"`                 // The resolved job
@@ -33,6 +46,7 @@ type JobBoshContainerization struct {
 type JobExposedPort struct {
 	Name                string `yaml:"name"`
 	Protocol            string `yaml:"protocol"`
+	AppProtocol         string `yaml:"app-protocol,omitempty"`
 	External            string `yaml:"external"`
 	Internal            string `yaml:"internal"`
 	Public              bool   `yaml:"public"`
@@ -40,8 +54,29 @@ type JobExposedPort struct {
 	Max                 int    `yaml:"max"`
 	PortIsConfigurable  bool   `yaml:"port-configurable"`
 	CountIsConfigurable bool   `yaml:"count-configurable"`
-	InternalPort        int
-	ExternalPort        int
+	// ExternalDNS is the DNS entry of a public port, created by external-dns
+	ExternalDNS  *JobExposedPortExternalDNS `yaml:"external-dns,omitempty"`
+	InternalPort int
+	ExternalPort int
+}
+
+// JobExposedPortExternalDNS describes the DNS entry external-dns creates for
+// a public port, via the annotations of the public service of the job
+type JobExposedPortExternalDNS struct {
+	Hostname string `yaml:"hostname"`
+	// TTL of the DNS records in seconds; the default of the DNS provider is
+	// used if unset
+	TTL int `yaml:"ttl,omitempty"`
+}
+
+// KubeName returns the name of the port in Kubernetes resources. Ports with
+// an application protocol are prefixed with it, following the port naming
+// convention of service meshes ("http-api").
+func (p JobExposedPort) KubeName() string {
+	if p.AppProtocol == "" || p.Name == p.AppProtocol || strings.HasPrefix(p.Name, p.AppProtocol+"-") {
+		return p.Name
+	}
+	return p.AppProtocol + "-" + p.Name
 }
 
 func runPropertyPresent(j JobReference) bool {
@@ -72,9 +107,60 @@ func affinityPresent(j JobReference) bool {
 	return true
 }
 
+func backupPresent(j JobReference) bool {
+	if j.ContainerProperties.BoshContainerization.Run.Backup == nil {
+		return false
+	}
+	return true
+}
+
+func jobSettingsPresent(j JobReference) bool {
+	if j.ContainerProperties.BoshContainerization.Run.Job == nil {
+		return false
+	}
+	return true
+}
+
+func commandPresent(j JobReference) bool {
+	run := j.ContainerProperties.BoshContainerization.Run
+	if len(run.Command) == 0 && len(run.Args) == 0 {
+		return false
+	}
+	return true
+}
+
 // JobReferences is a collection of pointers to job references
 type JobReferences []*JobReference
 
+// clone returns a copy of the job reference that doesn't share the links and
+// run properties with it; the resolved job itself is shared
+func (j *JobReference) clone() *JobReference {
+	clone := *j
+	if j.ExportedProvides != nil {
+		clone.ExportedProvides = make(map[string]JobProvidesInfo, len(j.ExportedProvides))
+		for name, info := range j.ExportedProvides {
+			clone.ExportedProvides[name] = info
+		}
+	}
+	if j.ResolvedConsumes != nil {
+		clone.ResolvedConsumes = make(map[string]JobConsumesInfo, len(j.ResolvedConsumes))
+		for name, info := range j.ResolvedConsumes {
+			clone.ResolvedConsumes[name] = info
+		}
+	}
+	if j.ResolvedConsumedBy != nil {
+		clone.ResolvedConsumedBy = make(map[string][]JobLinkInfo, len(j.ResolvedConsumedBy))
+		for name, infos := range j.ResolvedConsumedBy {
+			clone.ResolvedConsumedBy[name] = append([]JobLinkInfo(nil), infos...)
+		}
+	}
+	if run := j.ContainerProperties.BoshContainerization.Run; run != nil {
+		runCopy := *run
+		clone.ContainerProperties.BoshContainerization.Run = &runCopy
+	}
+	return &clone
+}
+
 // WithRunProperty returns all jobs with a BOSH containerization run property
 // could cache this on InstanceGroup if it turns out to be expensive
 func (jobs JobReferences) WithRunProperty() JobReferences {
@@ -171,8 +257,43 @@ func (jobs JobReferences) firstAffinity() *RoleRunAffinity {
 	return nil
 }
 
+func (jobs JobReferences) firstBackup() *RoleRunBackup {
+	for _, j := range jobs {
+		if backupPresent(*j) {
+			return j.ContainerProperties.BoshContainerization.Run.Backup
+		}
+	}
+	return nil
+}
+
+func (jobs JobReferences) firstJobSettings() *RoleRunJob {
+	for _, j := range jobs {
+		if jobSettingsPresent(*j) {
+			return j.ContainerProperties.BoshContainerization.Run.Job
+		}
+	}
+	return nil
+}
+
+func (jobs JobReferences) firstCommand() ([]string, []string) {
+	for _, j := range jobs {
+		if commandPresent(*j) {
+			run := j.ContainerProperties.BoshContainerization.Run
+			return run.Command, run.Args
+		}
+	}
+	return nil, nil
+}
+
 // WriteConfigs merges the job's spec with the opinions and returns the result as JSON.
-func (j *JobReference) WriteConfigs(instanceGroup *InstanceGroup, lightOpinionsPath, darkOpinionsPath string) ([]byte, error) {
+// The link fixtures, if any, are added to the consumed links they belong to,
+// for rendering the templates locally.
+func (j *JobReference) WriteConfigs(instanceGroup *InstanceGroup, lightOpinionsPath string, darkOpinionsPaths []string, fixtures *LinkFixtures) ([]byte, error) {
+	type consumesInfo struct {
+		JobLinkInfo
+		Fixture map[string]interface{} `json:"fixture,omitempty"`
+	}
+
 	var config struct {
 		Job struct {
 			Name string `json:"name"`
@@ -183,7 +304,7 @@ func (j *JobReference) WriteConfigs(instanceGroup *InstanceGroup, lightOpinionsP
 			Default map[string]string `json:"default"`
 		} `json:"networks"`
 		ExportedProperties []string                 `json:"exported_properties"`
-		Consumes           map[string]JobLinkInfo   `json:"consumes"`
+		Consumes           map[string]consumesInfo  `json:"consumes"`
 		ConsumedBy         map[string][]JobLinkInfo `json:"consumed_by"`
 	}
 
@@ -191,17 +312,21 @@ func (j *JobReference) WriteConfigs(instanceGroup *InstanceGroup, lightOpinionsP
 	config.Properties = make(map[string]interface{})
 	config.Networks.Default = make(map[string]string)
 	config.ExportedProperties = make([]string, 0)
-	config.Consumes = make(map[string]JobLinkInfo)
+	config.Consumes = make(map[string]consumesInfo)
 	config.ConsumedBy = make(map[string][]JobLinkInfo)
 
 	config.Job.Name = instanceGroup.Name
 
 	for _, consumer := range j.ResolvedConsumes {
-		config.Consumes[consumer.Name] = consumer.JobLinkInfo
+		consumes := consumesInfo{JobLinkInfo: consumer.JobLinkInfo}
+		if _, ok := fixtures.lookup(consumer.Name); ok {
+			consumes.Fixture = fixtures.LinkSpec(consumer, map[string]interface{}{})
+		}
+		config.Consumes[consumer.Name] = consumes
 	}
 	config.ConsumedBy = j.ResolvedConsumedBy
 
-	opinions, err := NewOpinions(lightOpinionsPath, darkOpinionsPath)
+	opinions, err := NewOpinionsWithDefaults(instanceGroup.SpecDefaults(), lightOpinionsPath, darkOpinionsPaths...)
 	if err != nil {
 		return nil, err
 	}
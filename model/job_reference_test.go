@@ -79,7 +79,7 @@ func TestWriteConfigs(t *testing.T) {
 	assert.NoError(err)
 	assert.NoError(tempFile.Close())
 
-	json, err := role.JobReferences[0].WriteConfigs(role, tempFile.Name(), tempFile.Name())
+	json, err := role.JobReferences[0].WriteConfigs(role, tempFile.Name(), []string{tempFile.Name()}, nil)
 	assert.NoError(err)
 
 	// `service_name` is empty because we never resolved links
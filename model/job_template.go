@@ -1,5 +1,20 @@
 package model
 
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// linkCallRegexp matches the properties looked up directly on a link,
+	// as in link('name').p('property')
+	linkCallRegexp = regexp.MustCompile(`\blink\(\s*['"]([^'"]+)['"]\s*\)\s*\.\s*(p|if_p)\s*\(([^)]*)\)`)
+	// linkVariableRegexp matches links bound to a variable, as in
+	// l = link('name') or if_link('name') do |l|
+	linkVariableRegexp = regexp.MustCompile(`(?:\b(\w+)\s*=\s*link|\bif_link)\(\s*['"]([^'"]+)['"]\s*\)(?:\s*(?:do|\{)\s*\|\s*(\w+)\s*\|)?`)
+)
+
 // JobTemplate represents a BOSH job template
 type JobTemplate struct {
 	SourcePath      string
@@ -22,3 +37,95 @@ func (t *JobTemplate) Marshal() (interface{}, error) {
 		"content":         t.Content,
 	}, nil
 }
+
+// LinkProperties returns the properties the template looks up on links, by
+// link name. The template is scanned for calls of p and if_p on the results
+// of link and if_link, directly or via a variable; properties built at
+// runtime are not found.
+func (t *JobTemplate) LinkProperties() map[string][]string {
+	result := map[string][]string{}
+	seen := map[string]bool{}
+	add := func(linkName, function, args string) {
+		arguments := splitArguments(args)
+		if function == "p" && len(arguments) > 1 {
+			// The second argument of p is the default value
+			arguments = arguments[:1]
+		}
+		for _, argument := range arguments {
+			property, ok := stringLiteral(argument)
+			if !ok {
+				continue
+			}
+			key := fmt.Sprintf("%s\x00%s", linkName, property)
+			if !seen[key] {
+				seen[key] = true
+				result[linkName] = append(result[linkName], property)
+			}
+		}
+	}
+
+	for _, match := range linkCallRegexp.FindAllStringSubmatch(t.Content, -1) {
+		add(match[1], match[2], match[3])
+	}
+
+	for _, match := range linkVariableRegexp.FindAllStringSubmatch(t.Content, -1) {
+		variable := match[1]
+		if variable == "" {
+			variable = match[3]
+		}
+		if variable == "" {
+			continue
+		}
+		callRegexp := regexp.MustCompile(`\b` + regexp.QuoteMeta(variable) + `\s*\.\s*(p|if_p)\s*\(([^)]*)\)`)
+		for _, call := range callRegexp.FindAllStringSubmatch(t.Content, -1) {
+			add(match[2], call[1], call[2])
+		}
+	}
+
+	return result
+}
+
+// splitArguments splits a list of arguments at the commas outside of string
+// literals
+func splitArguments(args string) []string {
+	var arguments []string
+	var quote rune
+	start := 0
+	for i, c := range args {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			arguments = append(arguments, strings.TrimSpace(args[start:i]))
+			start = i + 1
+		}
+	}
+	if rest := strings.TrimSpace(args[start:]); rest != "" || len(arguments) > 0 {
+		arguments = append(arguments, rest)
+	}
+	return arguments
+}
+
+// stringLiteral returns the value of an argument that is a single string
+// literal; interpolated strings are built at runtime, and aren't literals
+func stringLiteral(argument string) (string, bool) {
+	if len(argument) < 2 {
+		return "", false
+	}
+	quote := argument[0]
+	if (quote != '\'' && quote != '"') || argument[len(argument)-1] != quote {
+		return "", false
+	}
+	value := argument[1 : len(argument)-1]
+	if value == "" || strings.ContainsRune(value, rune(quote)) {
+		return "", false
+	}
+	if quote == '"' && strings.Contains(value, "#{") {
+		return "", false
+	}
+	return value, true
+}
@@ -77,3 +77,29 @@ func TestJobTemplateMarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestJobTemplateLinkProperties(t *testing.T) {
+	t.Parallel()
+
+	template := &JobTemplate{Content: `
+port=<%= link('db').p('db.port') %>
+host=<%= link("db").address %>
+<% if_link('nats') do |nats| -%>
+nats=<%= nats.p("nats.user", "admin") %>:<%= nats.p('nats.port') %>
+<% end.else do -%>
+nats=none
+<% end -%>
+<% cc = link('cloud_controller') %>
+<% cc.if_p('cc.internal_api_user', 'cc.internal_api_password') do |user, password| %><% end %>
+<% cc.p("cc.#{name}") %>
+<% cc.p("cc.#{name}", "x") %>
+<% cc.if_p("cc.#{name}", 'cc.external_host') do |name, host| %><% end %>
+<% link('db').p('db.address', 'localhost, 127.0.0.1') %>
+`}
+
+	assert.Equal(t, map[string][]string{
+		"db":               {"db.port", "db.address"},
+		"nats":             {"nats.user", "nats.port"},
+		"cloud_controller": {"cc.internal_api_user", "cc.internal_api_password", "cc.external_host"},
+	}, template.LinkProperties())
+}
@@ -9,8 +9,8 @@ import (
 	"strings"
 	"testing"
 
-	"code.cloudfoundry.org/fissile/testhelpers"
 	"code.cloudfoundry.org/fissile/util"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -423,7 +423,7 @@ func TestJobMarshal(t *testing.T) {
 			if !assert.NoError(yaml.Unmarshal(expectedBytes, &expected), "Error in expected input") {
 				return
 			}
-			testhelpers.IsYAMLSubset(assert, expected, unmarshalled)
+			yamltest.IsYAMLSubset(assert, expected, unmarshalled)
 		})
 	}
 }
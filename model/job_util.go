@@ -34,22 +34,6 @@ func insertConfig(config map[string]interface{}, name string, value interface{})
 	return nil
 }
 
-func getOpinionValue(parent map[interface{}]interface{}, keys []string) (interface{}, bool) {
-	var key string
-	for _, key = range keys[:len(keys)-1] {
-		child, ok := parent[key]
-		if !ok {
-			return nil, false
-		}
-		parent, ok = child.(map[interface{}]interface{})
-		if !ok {
-			return nil, false
-		}
-	}
-	val, ok := parent[keys[len(keys)-1]]
-	return val, ok
-}
-
 // valueToJSONable ensures that the given value can be converted to JSON
 func valueToJSONable(value interface{}) interface{} {
 	if valueMap, ok := value.(map[interface{}]interface{}); ok {
@@ -0,0 +1,212 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/validation"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LinkFixtures supply sample link data for rendering job templates locally,
+// keyed by the name of the consumed link. Links without a fixture are faked
+// as a single instance of the providing instance group.
+type LinkFixtures struct {
+	Links map[string]LinkFixture `yaml:"links"`
+}
+
+// LinkFixture is the sample data of a link; fields left empty are faked as
+// without a fixture
+type LinkFixture struct {
+	Address string `yaml:"address"`
+	// Instances are the BOSH instance specs of the link; their missing name,
+	// id, index, az, address and bootstrap keys are filled in
+	Instances []map[interface{}]interface{} `yaml:"instances"`
+	// Properties must be exported by the provider of the link
+	Properties map[interface{}]interface{} `yaml:"properties"`
+}
+
+// LoadLinkFixtures reads the link fixtures from a YAML file
+func LoadLinkFixtures(path string) (*LinkFixtures, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the link fixtures: %v", err)
+	}
+	var fixtures LinkFixtures
+	if err := yaml.UnmarshalStrict(contents, &fixtures); err != nil {
+		return nil, fmt.Errorf("Error loading the link fixtures %s: %v", path, err)
+	}
+	return &fixtures, nil
+}
+
+// Validate checks that the fixtures belong to links consumed by the jobs of
+// the instance group, and only set properties exported by their providers
+func (fixtures *LinkFixtures) Validate(roleManifest *RoleManifest, instanceGroup *InstanceGroup) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	consumed := map[string]JobConsumesInfo{}
+	for _, jobReference := range instanceGroup.JobReferences {
+		for name, consumes := range jobReference.ResolvedConsumes {
+			consumed[name] = consumes
+		}
+	}
+
+	var names []string
+	for name := range fixtures.Links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field := fmt.Sprintf("links.%s", name)
+		consumes, ok := consumed[name]
+		if !ok {
+			allErrs = append(allErrs, validation.NotFound(field,
+				fmt.Sprintf("No job of instance group %s consumes the link", instanceGroup.Name)))
+			continue
+		}
+
+		var exported []string
+		if provider := roleManifest.LookupInstanceGroup(consumes.RoleName); provider != nil {
+			if providerJob := provider.LookupJob(consumes.JobName); providerJob != nil {
+				for _, provides := range providerJob.Job.AvailableProviders {
+					if provides.Type == consumes.Type {
+						exported = append(exported, provides.Properties...)
+					}
+				}
+			}
+		}
+
+		properties := FlattenPropertyNames("", fixtures.Links[name].Properties)
+		sort.Strings(properties)
+		for _, property := range properties {
+			if !linkExportsProperty(exported, property) {
+				allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("%s.properties.%s", field, property), property,
+					fmt.Sprintf("Not exported by job %s of instance group %s", consumes.JobName, consumes.RoleName)))
+			}
+		}
+
+		for index, instance := range fixtures.Links[name].Instances {
+			if value, ok := instance["index"]; ok {
+				if _, ok := value.(int); !ok {
+					allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("%s.instances[%d].index", field, index), value, "Expected an integer"))
+				}
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// FlattenPropertyNames returns the dotted names of all leaf values of a nested
+// property map. Empty maps are considered leaves.
+func FlattenPropertyNames(prefix string, value interface{}) []string {
+	mapping, ok := value.(map[interface{}]interface{})
+	if !ok || len(mapping) == 0 {
+		if prefix == "" {
+			return nil
+		}
+		return []string{prefix}
+	}
+	var names []string
+	for key, child := range mapping {
+		name := fmt.Sprintf("%v", key)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		names = append(names, FlattenPropertyNames(name, child)...)
+	}
+	return names
+}
+
+// linkExportsProperty checks if the dotted property name is one of the
+// exported properties, or part of a hash valued one
+func linkExportsProperty(exported []string, name string) bool {
+	for _, property := range exported {
+		if property == name || strings.HasPrefix(name, property+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// LinkSpec returns the link data of the consumed link for rendering job
+// templates, with the fixture applied over the faked data. The properties of
+// the fixture are merged over the given properties of the providing job.
+func (fixtures *LinkFixtures) LinkSpec(consumes JobConsumesInfo, properties map[string]interface{}) map[string]interface{} {
+	link := map[string]interface{}{
+		"address": consumes.ServiceName,
+		"instances": []interface{}{
+			fakeLinkInstance(consumes, 0, consumes.ServiceName),
+		},
+		"properties": properties,
+	}
+	fixture, ok := fixtures.lookup(consumes.Name)
+	if !ok {
+		return link
+	}
+
+	if fixture.Address != "" {
+		link["address"] = fixture.Address
+	}
+	if len(fixture.Instances) > 0 {
+		instances := make([]interface{}, 0, len(fixture.Instances))
+		for i, fixtureInstance := range fixture.Instances {
+			instance := fakeLinkInstance(consumes, i, link["address"].(string))
+			for key, value := range fixtureInstance {
+				instance[fmt.Sprintf("%v", key)] = valueToJSONable(value)
+			}
+			instances = append(instances, instance)
+		}
+		link["instances"] = instances
+	}
+	link["properties"] = mergeLinkProperties(properties, fixture.Properties)
+	return link
+}
+
+// lookup returns the fixture of the named link, if any
+func (fixtures *LinkFixtures) lookup(name string) (LinkFixture, bool) {
+	if fixtures == nil {
+		return LinkFixture{}, false
+	}
+	fixture, ok := fixtures.Links[name]
+	return fixture, ok
+}
+
+// mergeLinkProperties returns the properties with the overrides merged over
+// them; nested maps are merged recursively, anything else is replaced
+func mergeLinkProperties(properties map[string]interface{}, overrides map[interface{}]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(properties)+len(overrides))
+	for key, value := range properties {
+		result[key] = value
+	}
+	for key, override := range overrides {
+		name := fmt.Sprintf("%v", key)
+		overrideMap, ok := override.(map[interface{}]interface{})
+		if !ok {
+			result[name] = valueToJSONable(override)
+			continue
+		}
+		valueMap, ok := result[name].(map[string]interface{})
+		if !ok {
+			valueMap = map[string]interface{}{}
+		}
+		result[name] = mergeLinkProperties(valueMap, overrideMap)
+	}
+	return result
+}
+
+// fakeLinkInstance returns the instance spec of the providing instance group
+// at the index
+func fakeLinkInstance(consumes JobConsumesInfo, index int, address string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":      consumes.RoleName,
+		"index":     index,
+		"id":        fmt.Sprintf("%s-%d", consumes.RoleName, index),
+		"az":        "az0",
+		"address":   address,
+		"bootstrap": index == 0,
+	}
+}
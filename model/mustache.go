@@ -50,7 +50,7 @@ func (g *InstanceGroup) GetVariablesForRole() (Variables, error) {
 
 				varsInTemplate, err := ParseTemplate(template.Value)
 				if err != nil {
-					return nil, err
+					return nil, fmt.Errorf("Error parsing template %s of instance group %s: %v", templatePropName, g.Name, err)
 				}
 
 				for _, envVar := range varsInTemplate {
@@ -100,16 +100,58 @@ func (g *InstanceGroup) GetVariablesForRole() (Variables, error) {
 	return result, nil
 }
 
-// ParseTemplate parses a mustache template and returns the template variables
+// ParseTemplate parses a mustache template and returns the sorted names of
+// the template variables. Parsing is strict: partials and unterminated raw
+// tags are errors. Errors are mustache.ParseError, with the line of the
+// template.
 func ParseTemplate(template string) ([]string, error) {
 
-	parsed, err := mustache.ParseString(fmt.Sprintf("{{=(( ))=}}%s", template))
+	parsed, err := mustache.ParseStringStrict(fmt.Sprintf("{{=(( ))=}}%s", template))
 
 	if err != nil {
 		return nil, err
 	}
 
-	return parsed.GetTemplateVariables(), nil
+	seen := map[string]bool{}
+	var variables []string
+	for _, variable := range parsed.GetTemplateVariables() {
+		if !seen[variable] {
+			seen[variable] = true
+			variables = append(variables, variable)
+		}
+	}
+	sort.Strings(variables)
+
+	return variables, nil
+}
+
+// ParsedTemplate is a configuration template of an instance group, with the
+// variables it references, or the error parsing it
+type ParsedTemplate struct {
+	Property  string
+	IsGlobal  bool
+	Variables []string
+	Err       error
+}
+
+// ParseTemplates parses all configuration templates of the instance group,
+// including the global ones, sorted by property
+func (g *InstanceGroup) ParseTemplates() []ParsedTemplate {
+	var templates []ParsedTemplate
+	if g.Configuration == nil {
+		return templates
+	}
+	for property, template := range g.Configuration.Templates {
+		variables, err := ParseTemplate(template.Value)
+		templates = append(templates, ParsedTemplate{
+			Property:  property,
+			IsGlobal:  template.IsGlobal,
+			Variables: variables,
+			Err:       err,
+		})
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Property < templates[j].Property })
+	return templates
 }
 
 func builtins() Variables {
@@ -29,3 +29,22 @@ func TestParsing(t *testing.T) {
 	assert.Contains(pieces, "FISSILE_CLUSTER_DOMAIN")
 	assert.NotContains(pieces, "FOO")
 }
+
+func TestParsingStrict(t *testing.T) {
+	assert := assert.New(t)
+
+	pieces, err := ParseTemplate("((#A))((B))((/A))((^A))((B))((/A))")
+	assert.NoError(err)
+	assert.Equal([]string{"A", "B"}, pieces, "Variables should be listed once, sorted")
+
+	for template, message := range map[string]string{
+		"((> partial))":     "line 1: partials are not supported: partial",
+		"x\n(( {RAW ))":     "line 2: unmatched raw tag: {RAW",
+		"x\ny\n((#A))((B))": "line 3: Section A has no closing tag",
+	} {
+		_, err := ParseTemplate(template)
+		if assert.Error(err, template) {
+			assert.Equal(message, err.Error(), template)
+		}
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 
+	"code.cloudfoundry.org/fissile/validation"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -12,8 +16,29 @@ import (
 type Opinions struct {
 	Light map[string]interface{}
 	Dark  map[string]interface{}
+	// DarkConflicts lists, as warnings, the dark opinions given differently
+	// by several dark opinions files; the one from the last file is used
+	DarkConflicts validation.ErrorList
+
+	// index holds the properties of the opinions by path; it is built on
+	// first use, and shared by all jobs of all instance groups
+	index     *opinionsIndex
+	indexOnce sync.Once
+}
+
+// opinionsIndex is the flattened form of the properties of the light and
+// dark opinions. Every path of a property, inner nodes included, maps to the
+// value at that path, so that looking up a property doesn't walk the trees.
+type opinionsIndex struct {
+	light map[string]interface{}
+	dark  map[string]interface{}
+	err   error
 }
 
+// opinionsIndexSeparator separates the key pieces of the paths of the index;
+// keys of the opinions may contain dots, so those can't be used
+const opinionsIndexSeparator = "\x00"
+
 // NewEmptyOpinions returns an empty opinions object, used for testing and
 // generating the version of the package layer, that doesn't change if opinions
 // change
@@ -30,31 +55,156 @@ func NewEmptyOpinions() *Opinions {
 	return result
 }
 
-// NewOpinions returns the json opinions for the light and dark opinion files
-func NewOpinions(lightFile, darkFile string) (*Opinions, error) {
+// NewOpinions returns the json opinions for the light opinion file and the
+// dark opinion files. The light opinions may be given as a patch, which is
+// then applied without the spec defaults (see ReadLightOpinions); use
+// NewOpinionsWithDefaults where the role manifest is known.
+func NewOpinions(lightFile string, darkFiles ...string) (*Opinions, error) {
+	return NewOpinionsWithDefaults(nil, lightFile, darkFiles...)
+}
+
+// NewOpinionsWithDefaults returns the json opinions for the light opinion file
+// and the dark opinion files, applying a light opinions patch over the given
+// spec defaults (see SpecDefaults). Several dark opinion files, e.g. shared
+// ones and ones per environment, are layered in order: their mappings are
+// merged, and the values of later files take precedence. Values replaced by a
+// different one are reported in DarkConflicts.
+func NewOpinionsWithDefaults(defaults map[string]interface{}, lightFile string, darkFiles ...string) (*Opinions, error) {
+	var err error
 	result := &Opinions{}
 
-	manifestContents, err := ioutil.ReadFile(lightFile)
+	result.Light, err = ReadLightOpinions(lightFile, defaults)
 	if err != nil {
 		return nil, err
 	}
 
-	err = yaml.Unmarshal([]byte(manifestContents), &result.Light)
+	if len(darkFiles) == 0 {
+		return nil, fmt.Errorf("No dark opinions file given")
+	}
+
+	origins := map[string]string{}
+	for _, darkFile := range darkFiles {
+		manifestContents, err := ioutil.ReadFile(darkFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var dark map[interface{}]interface{}
+		err = yaml.Unmarshal([]byte(manifestContents), &dark)
+		if err != nil {
+			return nil, err
+		}
+
+		if result.Dark == nil {
+			result.Dark = map[string]interface{}{}
+		}
+		var keys []string
+		for key := range dark {
+			keys = append(keys, fmt.Sprintf("%v", key))
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := dark[key]
+			if existing, ok := result.Dark[key]; ok {
+				value = result.mergeDarkOpinion(key, existing, value, darkFile, origins)
+			} else {
+				recordDarkOpinionOrigins(key, value, darkFile, origins)
+			}
+			result.Dark[key] = value
+		}
+	}
+
+	return result, nil
+}
+
+// ReadLightOpinions returns the light opinions of a file, which holds either a
+// tree of properties, or a patch against the spec defaults (see OpinionsPatch).
+// The patch is applied over the given defaults, or leniently without them.
+func ReadLightOpinions(lightFile string, defaults map[string]interface{}) (map[string]interface{}, error) {
+	manifestContents, err := ioutil.ReadFile(lightFile)
 	if err != nil {
 		return nil, err
 	}
 
-	manifestContents, err = ioutil.ReadFile(darkFile)
+	patch, isPatch, err := parseOpinionsPatch(manifestContents)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Error parsing the opinions patch %s: %v", lightFile, err)
+	}
+	if isPatch {
+		return patch.Apply(defaults)
 	}
 
-	err = yaml.Unmarshal([]byte(manifestContents), &result.Dark)
+	var light map[string]interface{}
+	err = yaml.Unmarshal(manifestContents, &light)
 	if err != nil {
 		return nil, err
 	}
+	return light, nil
+}
 
-	return result, nil
+// parseOpinionsPatch parses the contents of an opinions file in patch form,
+// i.e. a list of operations rather than a mapping
+func parseOpinionsPatch(contents []byte) (OpinionsPatch, bool, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(contents, &document); err != nil {
+		return nil, false, err
+	}
+	if _, ok := document.([]interface{}); !ok {
+		return nil, false, nil
+	}
+	var patch OpinionsPatch
+	if err := yaml.UnmarshalStrict(contents, &patch); err != nil {
+		return nil, true, err
+	}
+	return patch, true, nil
+}
+
+// mergeDarkOpinion merges the value of a dark opinion from a later file into
+// the existing one, recording conflicting values
+func (o *Opinions) mergeDarkOpinion(path string, existing, value interface{}, file string, origins map[string]string) interface{} {
+	existingMap, existingIsMap := existing.(map[interface{}]interface{})
+	valueMap, valueIsMap := value.(map[interface{}]interface{})
+	if existingIsMap && valueIsMap {
+		var keys []string
+		byName := map[string]interface{}{}
+		for key := range valueMap {
+			keys = append(keys, fmt.Sprintf("%v", key))
+			byName[fmt.Sprintf("%v", key)] = key
+		}
+		sort.Strings(keys)
+		for _, name := range keys {
+			key := byName[name]
+			if existingValue, ok := existingMap[key]; ok {
+				existingMap[key] = o.mergeDarkOpinion(path+"."+name, existingValue, valueMap[key], file, origins)
+			} else {
+				recordDarkOpinionOrigins(path+"."+name, valueMap[key], file, origins)
+				existingMap[key] = valueMap[key]
+			}
+		}
+		return existingMap
+	}
+
+	if existingIsMap != valueIsMap || !reflect.DeepEqual(existing, value) {
+		// Overriding shared opinions per environment is intended, so the
+		// conflicts only warn
+		conflict := validation.Invalid(path, value,
+			fmt.Sprintf("dark opinion in %s overrides a different one in %s", file, origins[path]))
+		conflict.Warning = true
+		o.DarkConflicts = append(o.DarkConflicts, conflict)
+	}
+	recordDarkOpinionOrigins(path, value, file, origins)
+	return value
+}
+
+// recordDarkOpinionOrigins remembers the file the dark opinion at the given
+// path, and all nested ones, come from
+func recordDarkOpinionOrigins(path string, value interface{}, file string, origins map[string]string) {
+	origins[path] = file
+	if valueMap, ok := value.(map[interface{}]interface{}); ok {
+		for key, nested := range valueMap {
+			recordDarkOpinionOrigins(fmt.Sprintf("%s.%v", path, key), nested, file, origins)
+		}
+	}
 }
 
 // FlattenOpinions converts the incoming nested map of opinions into a
@@ -114,6 +264,65 @@ func flattenOpinionsRecurse(result map[string]string, prefix string, value inter
 	result[prefix] = fmt.Sprintf("%v", value)
 }
 
+// propertiesIndex returns the index of the properties of the opinions,
+// building it on first use. The opinions must not be changed afterwards.
+func (o *Opinions) propertiesIndex() (*opinionsIndex, error) {
+	o.indexOnce.Do(func() {
+		o.index = &opinionsIndex{}
+		lightProperties, ok := o.Light["properties"]
+		if !ok {
+			o.index.err = fmt.Errorf("propertiesIndex: no 'properties' key in light opinions")
+			return
+		}
+		darkProperties, ok := o.Dark["properties"]
+		if !ok {
+			o.index.err = fmt.Errorf("propertiesIndex: no 'properties' key in dark opinions")
+			return
+		}
+		lightMap, ok := lightProperties.(map[interface{}]interface{})
+		if !ok {
+			o.index.err = fmt.Errorf("propertiesIndex: can't convert lightOpinions into a string map")
+			return
+		}
+		darkMap, ok := darkProperties.(map[interface{}]interface{})
+		if !ok {
+			o.index.err = fmt.Errorf("propertiesIndex: can't convert darkOpinions into a string map")
+			return
+		}
+		o.index.light = map[string]interface{}{}
+		indexOpinions(o.index.light, "", lightMap)
+		o.index.dark = map[string]interface{}{}
+		indexOpinions(o.index.dark, "", darkMap)
+	})
+	return o.index, o.index.err
+}
+
+// indexOpinions records the values of the opinions, and of all nested ones,
+// by their path below the prefix. Keys other than strings can't be looked up
+// by property name, and are skipped.
+func indexOpinions(index map[string]interface{}, prefix string, opinions map[interface{}]interface{}) {
+	for key, value := range opinions {
+		name, ok := key.(string)
+		if !ok {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + opinionsIndexSeparator + name
+		}
+		index[path] = value
+		if nested, ok := value.(map[interface{}]interface{}); ok {
+			indexOpinions(index, path, nested)
+		}
+	}
+}
+
+// lookupOpinion returns the opinion at the path given by the key pieces
+func lookupOpinion(index map[string]interface{}, keyPieces []string) (interface{}, bool) {
+	value, ok := index[strings.Join(keyPieces, opinionsIndexSeparator)]
+	return value, ok
+}
+
 // GetOpinionForKey pulls an opinion out of the holding container.
 func (o *Opinions) GetOpinionForKey(opinions map[string]interface{}, keyPieces []string) (result interface{}) {
 	return getDeepValueFromManifest(opinions, keyPieces)
@@ -0,0 +1,366 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operations of opinions patches, as defined by RFC 6902 (JSON Patch)
+const (
+	PatchOpAdd     = "add"
+	PatchOpRemove  = "remove"
+	PatchOpReplace = "replace"
+	PatchOpMove    = "move"
+	PatchOpCopy    = "copy"
+	PatchOpTest    = "test"
+)
+
+// OpinionsPatchOperation is a single operation of an opinions patch. The
+// paths are JSON pointers into the opinions, e.g. /properties/nats/port.
+type OpinionsPatchOperation struct {
+	Op    string      `yaml:"op" json:"op"`
+	Path  string      `yaml:"path" json:"path"`
+	From  string      `yaml:"from,omitempty" json:"from,omitempty"`
+	Value interface{} `yaml:"value,omitempty" json:"value,omitempty"`
+}
+
+// OpinionsPatch expresses light opinions as a JSON Patch against the defaults
+// of the job specs, instead of a tree of properties.
+type OpinionsPatch []OpinionsPatchOperation
+
+// Apply applies the patch over the spec defaults, keyed by property name (see
+// SpecDefaults), and returns the light opinions for all properties modified by
+// the patch. The opinions hold the complete value of each property, even if
+// the patch only modifies some of its elements.
+//
+// Without spec defaults the patch is applied leniently, as the properties
+// that exist are unknown: replacing a missing property adds it, and removing
+// it does nothing. Paths into arrays and hashes then need to exist in the
+// patch itself. In all cases missing mappings are added as needed, but
+// missing arrays are not.
+func (patch OpinionsPatch) Apply(defaults map[string]interface{}) (map[string]interface{}, error) {
+	strict := defaults != nil
+	tree := map[interface{}]interface{}{}
+	for name, value := range defaults {
+		keyPieces, err := getKeyGrams(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := patchOpinionValue(tree, keyPieces, copyOpinionValue(value), true); err != nil {
+			return nil, fmt.Errorf("Error collecting the default of %s: %v", name, err)
+		}
+	}
+	var doc interface{} = map[interface{}]interface{}{"properties": tree}
+
+	var touched [][]string
+	for index, operation := range patch {
+		fail := func(format string, args ...interface{}) error {
+			return fmt.Errorf("Opinions patch operation %d (%s %s): %s", index, operation.Op, operation.Path, fmt.Sprintf(format, args...))
+		}
+
+		path, err := parseOpinionsPointer(operation.Path)
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+		if operation.Op != PatchOpTest {
+			touched = append(touched, path)
+		}
+
+		var from []string
+		if operation.Op == PatchOpMove || operation.Op == PatchOpCopy {
+			from, err = parseOpinionsPointer(operation.From)
+			if err != nil {
+				return nil, fail("%v", err)
+			}
+			if operation.Op == PatchOpMove {
+				touched = append(touched, from)
+			}
+		}
+
+		switch operation.Op {
+		case PatchOpAdd:
+			doc, err = patchOpinionValue(doc, path, operation.Value, true)
+		case PatchOpReplace:
+			if _, ok := lookupOpinionValue(doc, path); !ok && strict {
+				err = fmt.Errorf("path not found")
+			} else {
+				doc, err = patchOpinionValue(doc, path, operation.Value, false)
+			}
+		case PatchOpRemove:
+			if _, ok := lookupOpinionValue(doc, path); ok {
+				doc, err = removeOpinionValue(doc, path)
+			} else if strict {
+				err = fmt.Errorf("path not found")
+			}
+		case PatchOpMove, PatchOpCopy:
+			value, ok := lookupOpinionValue(doc, from)
+			if !ok {
+				return nil, fail("path %s not found", operation.From)
+			}
+			if operation.Op == PatchOpMove {
+				doc, err = removeOpinionValue(doc, from)
+			} else {
+				value = copyOpinionValue(value)
+			}
+			if err == nil {
+				doc, err = patchOpinionValue(doc, path, value, true)
+			}
+		case PatchOpTest:
+			value, ok := lookupOpinionValue(doc, path)
+			if !ok {
+				err = fmt.Errorf("path not found")
+			} else if !reflect.DeepEqual(value, operation.Value) {
+				err = fmt.Errorf("value is %v, not %v", value, operation.Value)
+			}
+		default:
+			err = fmt.Errorf("unknown operation")
+		}
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+	}
+
+	light := map[interface{}]interface{}{}
+	for _, path := range touched {
+		property := opinionsPropertyPath(doc, path, defaults)
+		value, ok := lookupOpinionValue(doc, property)
+		if !ok {
+			if _, isDefault := defaults[strings.Join(property[1:], ".")]; isDefault {
+				return nil, fmt.Errorf("Opinions patch removes the spec default of %s, which light opinions cannot express",
+					strings.Join(property[1:], "."))
+			}
+			continue
+		}
+		if _, err := patchOpinionValue(light, property[1:], copyOpinionValue(value), true); err != nil {
+			return nil, err
+		}
+	}
+
+	return map[string]interface{}{"properties": light}, nil
+}
+
+// OpinionsToPatch converts light opinions into a patch adding each of their
+// properties, sorted by path.
+func OpinionsToPatch(opinions map[string]interface{}) OpinionsPatch {
+	patch := OpinionsPatch{}
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		mapping, ok := value.(map[interface{}]interface{})
+		if !ok || (len(mapping) == 0 && prefix != "/properties") {
+			patch = append(patch, OpinionsPatchOperation{Op: PatchOpAdd, Path: prefix, Value: value})
+			return
+		}
+		var keys []string
+		for key := range mapping {
+			keys = append(keys, fmt.Sprintf("%v", key))
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			escaped := strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+			walk(prefix+"/"+escaped, mapping[key])
+		}
+	}
+	walk("/properties", opinions["properties"])
+	return patch
+}
+
+// SpecDefaults returns the defaults of the properties of all jobs of the
+// instance groups, keyed by property name. Where jobs disagree on the default
+// of a property, the first one wins.
+func SpecDefaults(instanceGroups InstanceGroups) map[string]interface{} {
+	defaults := map[string]interface{}{}
+	for _, instanceGroup := range instanceGroups {
+		for _, jobReference := range instanceGroup.JobReferences {
+			if jobReference.Job == nil {
+				continue
+			}
+			for _, property := range jobReference.Job.Properties {
+				if _, ok := defaults[property.Name]; !ok {
+					defaults[property.Name] = property.Default
+				}
+			}
+		}
+	}
+	return defaults
+}
+
+// SpecDefaults returns the defaults of the properties of all jobs of the role
+// manifest of the instance group, see SpecDefaults; without a role manifest,
+// there are none.
+func (g *InstanceGroup) SpecDefaults() map[string]interface{} {
+	if g.roleManifest == nil {
+		return nil
+	}
+	return SpecDefaults(g.roleManifest.InstanceGroups)
+}
+
+// parseOpinionsPointer splits a JSON pointer into the tokens of the path,
+// which must address a property
+func parseOpinionsPointer(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/properties/") {
+		return nil, fmt.Errorf("path %q does not address a property below /properties", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// opinionsPropertyPath returns the prefix of the path up to the property the
+// path addresses. For properties unknown to the spec defaults, that is the
+// path without any array elements.
+func opinionsPropertyPath(doc interface{}, path []string, defaults map[string]interface{}) []string {
+	for end := 2; end <= len(path); end++ {
+		if _, ok := defaults[strings.Join(path[1:end], ".")]; ok {
+			return path[:end]
+		}
+	}
+	current := doc
+	for i, token := range path {
+		mapping, ok := current.(map[interface{}]interface{})
+		if !ok {
+			return path[:i]
+		}
+		current = mapping[token]
+	}
+	return path
+}
+
+// lookupOpinionValue returns the value at the path
+func lookupOpinionValue(doc interface{}, path []string) (interface{}, bool) {
+	current := doc
+	for _, token := range path {
+		switch node := current.(type) {
+		case map[interface{}]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// patchOpinionValue sets the value at the path, and returns the updated
+// document. Missing mappings are added. With insert, the value is inserted
+// into arrays, and "-" appends to them; otherwise the element is replaced.
+func patchOpinionValue(doc interface{}, path []string, value interface{}, insert bool) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token := path[0]
+	switch node := doc.(type) {
+	case map[interface{}]interface{}:
+		if token == "-" {
+			return nil, fmt.Errorf("cannot append to a value that is not an array")
+		}
+		child := node[token]
+		if child == nil && len(path) > 1 {
+			// Array elements can only be addressed in existing arrays
+			if isOpinionsArrayToken(path[1]) {
+				return nil, fmt.Errorf("%s is not an array", token)
+			}
+			child = map[interface{}]interface{}{}
+		}
+		updated, err := patchOpinionValue(child, path[1:], value, insert)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = updated
+		return node, nil
+	case []interface{}:
+		if token == "-" && insert && len(path) == 1 {
+			return append(node, value), nil
+		}
+		index, err := strconv.Atoi(token)
+		if err != nil || index < 0 || index > len(node) || (index == len(node) && !(insert && len(path) == 1)) {
+			return nil, fmt.Errorf("invalid array index %s", token)
+		}
+		if insert && len(path) == 1 {
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		updated, err := patchOpinionValue(node[index], path[1:], value, insert)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = updated
+		return node, nil
+	}
+	return nil, fmt.Errorf("cannot set %s on a value that is neither a mapping nor an array", token)
+}
+
+// isOpinionsArrayToken returns true if the token of a path addresses an
+// element of an array
+func isOpinionsArrayToken(token string) bool {
+	if token == "-" {
+		return true
+	}
+	_, err := strconv.Atoi(token)
+	return err == nil
+}
+
+// removeOpinionValue removes the existing value at the path, and returns the
+// updated document
+func removeOpinionValue(doc interface{}, path []string) (interface{}, error) {
+	token := path[0]
+	switch node := doc.(type) {
+	case map[interface{}]interface{}:
+		if len(path) == 1 {
+			delete(node, token)
+			return node, nil
+		}
+		updated, err := removeOpinionValue(node[token], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		node[token] = updated
+		return node, nil
+	case []interface{}:
+		index, _ := strconv.Atoi(token)
+		if len(path) == 1 {
+			return append(node[:index], node[index+1:]...), nil
+		}
+		updated, err := removeOpinionValue(node[index], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		node[index] = updated
+		return node, nil
+	}
+	return nil, fmt.Errorf("path not found")
+}
+
+// copyOpinionValue returns a deep copy of the mappings and arrays of a value
+func copyOpinionValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[interface{}]interface{}, len(v))
+		for key, item := range v {
+			result[key] = copyOpinionValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = copyOpinionValue(item)
+		}
+		return result
+	}
+	return value
+}
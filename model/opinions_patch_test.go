@@ -0,0 +1,189 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestOpinionsPatchApply(t *testing.T) {
+	t.Parallel()
+
+	defaults := map[string]interface{}{
+		"tor.port":  9050,
+		"tor.hosts": []interface{}{"a", "b"},
+		"tor.limits": map[interface{}]interface{}{
+			"cpu": 1,
+		},
+		"tor.name": "tor",
+	}
+
+	t.Run("Strict", func(t *testing.T) {
+		t.Parallel()
+		patch := OpinionsPatch{
+			{Op: PatchOpReplace, Path: "/properties/tor/port", Value: 9051},
+			{Op: PatchOpAdd, Path: "/properties/tor/hosts/1", Value: "c"},
+			{Op: PatchOpAdd, Path: "/properties/tor/limits/memory", Value: 2},
+			{Op: PatchOpTest, Path: "/properties/tor/name", Value: "tor"},
+		}
+		light, err := patch.Apply(defaults)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"properties": map[interface{}]interface{}{
+				"tor": map[interface{}]interface{}{
+					"port":  9051,
+					"hosts": []interface{}{"a", "c", "b"},
+					"limits": map[interface{}]interface{}{
+						"cpu":    1,
+						"memory": 2,
+					},
+				},
+			},
+		}, light)
+		assert.Equal(t, []interface{}{"a", "b"}, defaults["tor.hosts"], "defaults must not be modified")
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Parallel()
+		cases := []struct {
+			patch OpinionsPatch
+			err   string
+		}{
+			{
+				OpinionsPatch{{Op: PatchOpReplace, Path: "/properties/tor/missing", Value: 1}},
+				"Opinions patch operation 0 (replace /properties/tor/missing): path not found",
+			},
+			{
+				OpinionsPatch{{Op: PatchOpRemove, Path: "/properties/tor/port"}},
+				"Opinions patch removes the spec default of tor.port, which light opinions cannot express",
+			},
+			{
+				OpinionsPatch{{Op: PatchOpTest, Path: "/properties/tor/name", Value: "nginx"}},
+				"Opinions patch operation 0 (test /properties/tor/name): value is tor, not nginx",
+			},
+			{
+				OpinionsPatch{{Op: PatchOpAdd, Path: "/tor/port", Value: 1}},
+				`Opinions patch operation 0 (add /tor/port): path "/tor/port" does not address a property below /properties`,
+			},
+			{
+				OpinionsPatch{{Op: "frobnicate", Path: "/properties/tor/port"}},
+				"Opinions patch operation 0 (frobnicate /properties/tor/port): unknown operation",
+			},
+		}
+		for _, c := range cases {
+			_, err := c.patch.Apply(defaults)
+			assert.EqualError(t, err, c.err)
+		}
+	})
+
+	t.Run("Lenient", func(t *testing.T) {
+		t.Parallel()
+		patch := OpinionsPatch{
+			{Op: PatchOpReplace, Path: "/properties/tor/port", Value: 9051},
+			{Op: PatchOpRemove, Path: "/properties/tor/missing"},
+			{Op: PatchOpAdd, Path: "/properties/tor/hosts", Value: []interface{}{"a"}},
+			{Op: PatchOpAdd, Path: "/properties/tor/hosts/-", Value: "b"},
+		}
+		light, err := patch.Apply(nil)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"properties": map[interface{}]interface{}{
+				"tor": map[interface{}]interface{}{
+					"port":  9051,
+					"hosts": []interface{}{"a", "b"},
+				},
+			},
+		}, light)
+	})
+}
+
+func TestOpinionsPatchApplyArrays(t *testing.T) {
+	t.Parallel()
+
+	// The example of docs/configuration.md
+	patch := OpinionsPatch{
+		{Op: PatchOpReplace, Path: "/properties/nats/port", Value: 4222},
+		{Op: PatchOpAdd, Path: "/properties/nats/machines/-", Value: "nats.example.com"},
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		t.Parallel()
+		light, err := patch.Apply(map[string]interface{}{
+			"nats.port":     4221,
+			"nats.machines": []interface{}{"nats-0"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"properties": map[interface{}]interface{}{
+				"nats": map[interface{}]interface{}{
+					"port":     4222,
+					"machines": []interface{}{"nats-0", "nats.example.com"},
+				},
+			},
+		}, light)
+	})
+
+	t.Run("Lenient", func(t *testing.T) {
+		t.Parallel()
+		_, err := patch.Apply(nil)
+		assert.EqualError(t, err, "Opinions patch operation 1 (add /properties/nats/machines/-): machines is not an array")
+
+		_, err = OpinionsPatch{
+			{Op: PatchOpAdd, Path: "/properties/nats/machines/0", Value: "nats.example.com"},
+		}.Apply(nil)
+		assert.EqualError(t, err, "Opinions patch operation 0 (add /properties/nats/machines/0): machines is not an array")
+
+		_, err = OpinionsPatch{
+			{Op: PatchOpAdd, Path: "/properties/nats", Value: map[interface{}]interface{}{}},
+			{Op: PatchOpAdd, Path: "/properties/nats/-", Value: "nats.example.com"},
+		}.Apply(nil)
+		assert.EqualError(t, err, "Opinions patch operation 1 (add /properties/nats/-): cannot append to a value that is not an array")
+	})
+}
+
+func TestOpinionsToPatch(t *testing.T) {
+	t.Parallel()
+
+	var light map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+properties:
+  tor:
+    port: 9051
+    hosts: [a, b]
+    private_key:
+      /dev/null: nothing
+`), &light))
+
+	patch := OpinionsToPatch(light)
+	assert.Equal(t, OpinionsPatch{
+		{Op: PatchOpAdd, Path: "/properties/tor/hosts", Value: []interface{}{"a", "b"}},
+		{Op: PatchOpAdd, Path: "/properties/tor/port", Value: 9051},
+		{Op: PatchOpAdd, Path: "/properties/tor/private_key/~1dev~1null", Value: "nothing"},
+	}, patch)
+
+	roundtrip, err := patch.Apply(nil)
+	require.NoError(t, err)
+	assert.Equal(t, light, roundtrip)
+}
+
+func TestOpinionsLoadPatch(t *testing.T) {
+	t.Parallel()
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	opinionsFile := filepath.Join(workDir, "../test-assets/test-opinions/opinions-patch.yml")
+	opinionsFileDark := filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml")
+
+	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, confOpinions.GetOpinionForKey(confOpinions.Light, []string{"tor", "int_opinion"}))
+	assert.Equal(t, 1, confOpinions.GetOpinionForKey(confOpinions.Light, []string{"tor", "copied_opinion"}))
+	assert.Equal(t, "hashed", confOpinions.GetOpinionForKey(confOpinions.Light, []string{"tor", "hashed_control_password"}))
+	assert.Equal(t, "nothing", confOpinions.GetOpinionForKey(confOpinions.Light, []string{"tor", "private_key", "/dev/null"}))
+}
@@ -105,3 +105,92 @@ func TestOpinionsFlattenOpinions(t *testing.T) {
 		assert.Contains(light, property)
 	}
 }
+
+func TestOpinionsLoadDarkLayers(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.Nil(err)
+
+	opinionsFile := filepath.Join(workDir, "../test-assets/test-opinions/opinions.yml")
+	opinionsFileDark := filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml")
+	opinionsFileDarkEnv := filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions-env.yml")
+
+	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark, opinionsFileDarkEnv)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(map[string]string{
+		"properties.tor.dark-opinion":   "this is a dark opinion",
+		"properties.tor.masked_opinion": "masked differently",
+		"properties.tor.env_opinion":    "only in this environment",
+	}, FlattenOpinions(confOpinions.Dark, false))
+
+	if assert.Len(confOpinions.DarkConflicts, 1) {
+		assert.Equal(`properties.tor.masked_opinion: Invalid value: "masked differently": dark opinion in `+
+			opinionsFileDarkEnv+` overrides a different one in `+opinionsFileDark,
+			confOpinions.DarkConflicts[0].Error())
+		assert.True(confOpinions.DarkConflicts[0].Warning)
+	}
+
+	// Repeating a dark opinion with the same value is no conflict
+	confOpinions, err = NewOpinions(opinionsFile, opinionsFileDark, opinionsFileDark)
+	assert.NoError(err)
+	assert.Empty(confOpinions.DarkConflicts)
+
+	_, err = NewOpinions(opinionsFile)
+	assert.EqualError(err, "No dark opinions file given")
+}
+
+func TestOpinionsPropertiesIndex(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	opinions := &Opinions{
+		Light: map[string]interface{}{
+			"properties": map[interface{}]interface{}{
+				"tor": map[interface{}]interface{}{
+					"int_opinion": 31,
+					"nested":      map[interface{}]interface{}{"key": "value"},
+				},
+				"dotted.key": "flat",
+				42:           "not a property",
+			},
+		},
+		Dark: map[string]interface{}{
+			"properties": map[interface{}]interface{}{
+				"tor": map[interface{}]interface{}{"masked_opinion": nil},
+			},
+		},
+	}
+
+	index, err := opinions.propertiesIndex()
+	if !assert.NoError(err) {
+		return
+	}
+
+	value, ok := lookupOpinion(index.light, []string{"tor", "int_opinion"})
+	assert.True(ok)
+	assert.Equal(31, value)
+
+	value, ok = lookupOpinion(index.light, []string{"tor", "nested"})
+	assert.True(ok, "Inner nodes should be indexed")
+	assert.Equal(map[interface{}]interface{}{"key": "value"}, value)
+
+	_, ok = lookupOpinion(index.light, []string{"tor", "nested", "key", "missing"})
+	assert.False(ok)
+
+	_, ok = lookupOpinion(index.light, []string{"dotted", "key"})
+	assert.False(ok, "Keys containing dots should not match nested properties")
+
+	value, ok = lookupOpinion(index.dark, []string{"tor", "masked_opinion"})
+	assert.True(ok)
+	assert.Nil(value)
+
+	again, err := opinions.propertiesIndex()
+	assert.NoError(err)
+	assert.True(index == again, "The index should be built once")
+
+	_, err = (&Opinions{Light: map[string]interface{}{}}).propertiesIndex()
+	assert.EqualError(err, "propertiesIndex: no 'properties' key in light opinions")
+}
@@ -7,8 +7,8 @@ import (
 	"strings"
 	"testing"
 
-	"code.cloudfoundry.org/fissile/testhelpers"
 	"code.cloudfoundry.org/fissile/util"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -152,6 +152,6 @@ func TestPackageMarshal(t *testing.T) {
 
 	actual, err := sample.Marshal()
 	if assert.NoError(err) {
-		testhelpers.IsYAMLSubset(assert, expected, actual)
+		yamltest.IsYAMLSubset(assert, expected, actual)
 	}
 }
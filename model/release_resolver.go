@@ -1,5 +1,7 @@
 package model
 
+import "code.cloudfoundry.org/fissile/util"
+
 // ReleaseOptions for releases
 type ReleaseOptions struct {
 	ReleasePaths     []string
@@ -7,6 +9,7 @@ type ReleaseOptions struct {
 	ReleaseVersions  []string
 	BOSHCacheDir     string
 	FinalReleasesDir string
+	HTTPOptions      util.HTTPOptions // Proxy and CA settings for downloading final releases
 }
 
 // ReleaseResolver loads job specs from releases and acts as a registry for
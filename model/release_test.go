@@ -6,7 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -396,6 +396,6 @@ func TestReleaseMarshal(t *testing.T) {
 	}
 	actual, err := sample.Marshal()
 	if assert.NoError(err) {
-		testhelpers.IsYAMLSubset(assert, expected, actual)
+		yamltest.IsYAMLSubset(assert, expected, actual)
 	}
 }
@@ -67,7 +67,7 @@ func isFinalReleasePath(releasePath string) (bool, error) {
 
 // downloadReleaseReferences downloads/builds and loads releases referenced in the
 // manifest
-func downloadReleaseReferences(releaseRefs []*model.ReleaseRef, finalReleasesDir string) ([]*model.Release, error) {
+func downloadReleaseReferences(releaseRefs []*model.ReleaseRef, finalReleasesDir string, httpOptions util.HTTPOptions) ([]*model.Release, error) {
 	releases := []*model.Release{}
 
 	var allErrs error
@@ -118,7 +118,7 @@ func downloadReleaseReferences(releaseRefs []*model.ReleaseRef, finalReleasesDir
 				lastPercentage := 0
 
 				// download the release in a directory next to the role manifest
-				err = util.DownloadFile(finalReleaseTarballPath, releaseRef.URL, func(percentage int) {
+				err = util.DownloadFile(finalReleaseTarballPath, releaseRef.URL, httpOptions, func(percentage int) {
 					if isaTTY {
 						bar.IncrBy(percentage - lastPercentage)
 					}
@@ -0,0 +1,71 @@
+package resolver
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/validation"
+)
+
+// loadDefaultsFiles reads the defaults files listed in the role manifest,
+// followed by the given ones, in the order they are applied
+func loadDefaultsFiles(m *model.RoleManifest, paths []string) ([]*model.DefaultsFile, error) {
+	allErrs := validation.ErrorList{}
+	var allPaths []string
+	for _, path := range m.DefaultsFiles {
+		if filepath.IsAbs(path) {
+			allErrs = append(allErrs, validation.Invalid("defaults_files", path,
+				"Path must be relative to the role manifest"))
+			continue
+		}
+		allPaths = append(allPaths, filepath.Join(filepath.Dir(m.ManifestFilePath), path))
+	}
+	if len(allErrs) != 0 {
+		return nil, allErrs
+	}
+
+	var defaultsFiles []*model.DefaultsFile
+	for _, path := range append(allPaths, paths...) {
+		defaultsFile, err := model.LoadDefaultsFile(path)
+		if err != nil {
+			return nil, err
+		}
+		defaultsFiles = append(defaultsFiles, defaultsFile)
+	}
+	return defaultsFiles, nil
+}
+
+// applyDefaultsFiles overrides the defaults of the variables with those of
+// the defaults files; later files take precedence. The variables are recorded
+// with the file as the source of their default.
+func applyDefaultsFiles(m *model.RoleManifest, defaultsFiles []*model.DefaultsFile) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	variables := map[string]*model.VariableDefinition{}
+	for _, cv := range m.Variables {
+		variables[cv.Name] = cv
+	}
+
+	for _, defaultsFile := range defaultsFiles {
+		var names []string
+		for name := range defaultsFile.Defaults {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			cv, ok := variables[name]
+			if !ok {
+				allErrs = append(allErrs, validation.NotFound(
+					fmt.Sprintf("defaults_file[%s]", defaultsFile.Path), name))
+				continue
+			}
+			cv.CVOptions.Default = defaultsFile.Defaults[name]
+			cv.CVOptions.DefaultSource = defaultsFile.Path
+		}
+	}
+
+	return allErrs
+}
@@ -42,6 +42,13 @@ func NewResolver(
 func (r *Resolver) Resolve() (*model.RoleManifest, error) {
 	var err error
 	m := r.roleManifest
+
+	// Reject manifests of a newer schema early, before they fail in
+	// obscure ways
+	if errs := validateSchemaVersion(m).WithCode(validation.CodeSchemaVersion); len(errs) != 0 {
+		return nil, errs
+	}
+
 	// Releases
 	m.LoadedReleases, err = r.releaseResolver.Load(
 		r.options.ReleaseOptions,
@@ -71,6 +78,31 @@ func (r *Resolver) Resolve() (*model.RoleManifest, error) {
 		m.Variables[i].CVOptions = v.CVOptions
 	}
 
+	// The errors of the variable files, defaults files and runtime configs
+	// are reported together
+	allErrs := loadVariableFiles(m).WithCode(validation.CodeVariableFilesLoad)
+
+	// Defaults files
+	defaultsFiles, err := loadDefaultsFiles(m, r.options.DefaultsFiles)
+	if err != nil {
+		return nil, err
+	}
+	allErrs = append(allErrs, applyDefaultsFiles(m, defaultsFiles).WithCode(validation.CodeDefaultsFiles)...)
+
+	// Runtime configs
+	var runtimeConfigs []*model.RuntimeConfig
+	for _, path := range r.options.RuntimeConfigs {
+		runtimeConfig, err := model.LoadRuntimeConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		runtimeConfigs = append(runtimeConfigs, runtimeConfig)
+	}
+	allErrs = append(allErrs, applyRuntimeConfigs(m, runtimeConfigs).WithCode(validation.CodeRuntimeConfigs)...)
+	if len(allErrs) != 0 {
+		return nil, allErrs
+	}
+
 	// Resolve manifest
 	err = r.ResolveRoleManifest()
 	if err != nil {
@@ -89,10 +121,22 @@ func (r *Resolver) ResolveRoleManifest() error {
 	allErrs := validation.ErrorList{}
 
 	// If template keys are not strings, we need to stop early to avoid panics
-	allErrs = append(allErrs, validateTemplateKeysAndValues(m)...)
+	allErrs = append(allErrs, validateTemplateKeysAndValues(m).WithCode(validation.CodeTemplateKeys)...)
 	if len(allErrs) != 0 {
 		return allErrs
 	}
+	allErrs = append(allErrs, validateProbeProfiles(m).WithCode(validation.CodeProbeProfiles)...)
+
+	// The variables don't depend on the jobs, so their errors are reported
+	// along with those of the instance groups
+	allErrs = append(allErrs, validateVariableType(m.Variables).WithCode(validation.CodeVariableType)...)
+	allErrs = append(allErrs, validateVariablePreviousNames(m.Variables).WithCode(validation.CodeVariablePreviousNames)...)
+	allErrs = append(allErrs, validateVariableDeprecations(m.Variables).WithCode(validation.CodeVariableDeprecations)...)
+	allErrs = append(allErrs, validateVariableValidations(m.Variables).WithCode(validation.CodeVariableValidations)...)
+	allErrs = append(allErrs, validateVariableFiles(m).WithCode(validation.CodeVariableFiles)...)
+	allErrs = append(allErrs, validateExternalSecrets(m).WithCode(validation.CodeExternalSecrets)...)
+	allErrs = append(allErrs, validateMinimumFissileVersion(m).WithCode(validation.CodeMinimumFissileVersion)...)
+	allErrs = append(allErrs, validateVariableDescriptions(m).WithCode(validation.CodeVariableDescriptions)...)
 
 	err := r.releaseResolver.MapReleases(m.LoadedReleases)
 	if err != nil {
@@ -129,7 +173,7 @@ func (r *Resolver) ResolveRoleManifest() error {
 		default:
 			allErrs = append(allErrs, validation.Invalid(
 				fmt.Sprintf("instance_groups[%s].type", instanceGroup.Name),
-				instanceGroup.Type, "Expected one of bosh, bosh-task, or colocated-container"))
+				instanceGroup.Type, "Expected one of bosh, bosh-task, or colocated-container").WithCode(validation.CodeInstanceGroupType))
 		}
 
 		// default_feature, if_feature, and unless_feature all all mutually exclusive
@@ -139,17 +183,17 @@ func (r *Resolver) ResolveRoleManifest() error {
 			allErrs = append(allErrs, validation.Forbidden(
 				fmt.Sprintf("instance_groups[%s]", instanceGroup.Name),
 				fmt.Sprintf("default_feature[%s], if_feature[%s], and unless_feature[%s] are all mutually exclusive",
-					instanceGroup.DefaultFeature, instanceGroup.IfFeature, instanceGroup.UnlessFeature)))
+					instanceGroup.DefaultFeature, instanceGroup.IfFeature, instanceGroup.UnlessFeature)).WithCode(validation.CodeFeatureExclusivity))
 		}
 
 		m.AddFeature(instanceGroup.DefaultFeature, true)
 		m.AddFeature(instanceGroup.IfFeature, false)
 		m.AddFeature(instanceGroup.UnlessFeature, false)
 
-		allErrs = append(allErrs, instanceGroup.CalculateRoleRun()...)
-		allErrs = append(allErrs, validateRoleTags(instanceGroup)...)
-		allErrs = append(allErrs, validateRoleRun(instanceGroup, m)...)
-		allErrs = append(allErrs, validateJobReferences(instanceGroup)...)
+		allErrs = append(allErrs, instanceGroup.CalculateRoleRun().WithCode(validation.CodeRoleRunCalculation)...)
+		allErrs = append(allErrs, validateRoleTags(instanceGroup).WithCode(validation.CodeRoleTags)...)
+		allErrs = append(allErrs, validateRoleRun(instanceGroup, m).WithCode(validation.CodeRoleRun)...)
+		allErrs = append(allErrs, validateJobReferences(instanceGroup).WithCode(validation.CodeJobReferences)...)
 
 		// Count how many instance groups use a particular
 		// service account. And its roles.
@@ -179,13 +223,16 @@ func (r *Resolver) ResolveRoleManifest() error {
 		}
 	}
 
+	allErrs = append(allErrs, validateInstanceGroupPreviousNames(m).WithCode(validation.CodeInstanceGroupPrevNames)...)
+	allErrs = append(allErrs, replicateZones(m).WithCode(validation.CodeZones)...)
+
 	if len(allErrs) != 0 {
 		return allErrs
 	}
 
 	for _, instanceGroup := range m.InstanceGroups {
 		instanceGroup.SetRoleManifest(m)
-		errorList := validateInstanceGroup(m, instanceGroup, r.releaseResolver)
+		errorList := validateInstanceGroup(m, instanceGroup, r.releaseResolver).WithCode(validation.CodeInstanceGroupJobs)
 		if len(errorList) != 0 {
 			allErrs = append(allErrs, errorList...)
 		}
@@ -205,17 +252,22 @@ func (r *Resolver) ResolveRoleManifest() error {
 		r.calculateConfigurationTemplates(m)
 
 		if !r.releaseResolver.CanValidate() {
-			allErrs = append(allErrs, r.ResolveLinks()...)
+			allErrs = append(allErrs, r.ResolveLinks().WithCode(validation.CodeLinks)...)
 		}
-		allErrs = append(allErrs, validateVariableType(m.Variables)...)
-		allErrs = append(allErrs, validateVariablePreviousNames(m.Variables)...)
-		allErrs = append(allErrs, validateServiceAccounts(m)...)
-		allErrs = append(allErrs, validateUnusedColocatedContainerRoles(m)...)
-		allErrs = append(allErrs, validateColocatedContainerPortCollisions(m)...)
-		allErrs = append(allErrs, validateColocatedContainerVolumeShares(m)...)
-		allErrs = append(allErrs, validateVariableDescriptions(m)...)
+		allErrs = append(allErrs, validateVariableURLTemplates(m).WithCode(validation.CodeVariableURLTemplates)...)
+		allErrs = append(allErrs, validateServiceAccounts(m).WithCode(validation.CodeServiceAccounts)...)
+		allErrs = append(allErrs, validateAuthAggregation(m).WithCode(validation.CodeAuthAggregation)...)
+		allErrs = append(allErrs, validateUnusedColocatedContainerRoles(m).WithCode(validation.CodeUnusedColocated)...)
+		allErrs = append(allErrs, validateColocatedContainerPortCollisions(m).WithCode(validation.CodeColocatedPorts)...)
+		allErrs = append(allErrs, validateColocatedContainerVolumeShares(m).WithCode(validation.CodeColocatedVolumes)...)
+		allErrs = append(allErrs, validateColocatedContainerSysctls(m).WithCode(validation.CodeColocatedSysctls)...)
+		allErrs = append(allErrs, validateColocatedContainerSharedSockets(m).WithCode(validation.CodeColocatedSockets)...)
+		allErrs = append(allErrs, validateInstanceGroupMonitoring(m).WithCode(validation.CodeMonitoring)...)
+		allErrs = append(allErrs, validateInstanceGroupStemcells(m).WithCode(validation.CodeStemcells)...)
+		allErrs = append(allErrs, validateRuntimeScripts(m).WithCode(validation.CodeRuntimeScripts)...)
+		allErrs = append(allErrs, validateClusterScoped(m).WithCode(validation.CodeClusterScoped)...)
 		if !r.releaseResolver.CanValidate() {
-			allErrs = append(allErrs, validateScripts(m, r.options.ValidationOptions)...)
+			allErrs = append(allErrs, validateScripts(m, r.options.ValidationOptions).WithCode(validation.CodeScripts)...)
 		}
 	}
 
@@ -237,7 +289,22 @@ func (r *Resolver) ResolveLinks() validation.ErrorList {
 	// involved here are the aliases, where appropriate.
 	providersByName := make(map[string]model.JobProvidesInfo)
 	providersByType := make(map[string][]model.JobProvidesInfo)
+	// Colocated containers resolve links from the providers of their host
+	// group first, including the ones that are not exported; these are
+	// recorded per instance group.
+	groupProvidersByName := make(map[string]map[string]model.JobProvidesInfo)
+	groupProvidersByType := make(map[string]map[string][]model.JobProvidesInfo)
+	// The replicas of an instance group with zones provide the same links;
+	// only the replica of the first zone provides them to the deployment
+	replicated := make(map[*model.InstanceGroup]bool)
 	for _, instanceGroup := range m.InstanceGroups {
+		shared := true
+		if instanceGroup.ReplicaOf != nil {
+			shared = !replicated[instanceGroup.ReplicaOf]
+			replicated[instanceGroup.ReplicaOf] = true
+		}
+		groupProvidersByName[instanceGroup.Name] = make(map[string]model.JobProvidesInfo)
+		groupProvidersByType[instanceGroup.Name] = make(map[string][]model.JobProvidesInfo)
 		for _, jobReference := range instanceGroup.JobReferences {
 			var availableProviders []string
 			serviceName := jobReference.ContainerProperties.BoshContainerization.ServiceName
@@ -246,17 +313,22 @@ func (r *Resolver) ResolveLinks() validation.ErrorList {
 			}
 			for availableName, availableProvider := range jobReference.Job.AvailableProviders {
 				availableProviders = append(availableProviders, availableName)
+				info := model.JobProvidesInfo{
+					JobLinkInfo: model.JobLinkInfo{
+						Name:        availableProvider.Name,
+						Type:        availableProvider.Type,
+						RoleName:    instanceGroup.Name,
+						JobName:     jobReference.Name,
+						ServiceName: serviceName,
+					},
+					Properties: availableProvider.Properties,
+				}
+				groupProvidersByName[instanceGroup.Name][availableName] = info
 				if availableProvider.Type != "" {
-					providersByType[availableProvider.Type] = append(providersByType[availableProvider.Type], model.JobProvidesInfo{
-						JobLinkInfo: model.JobLinkInfo{
-							Name:        availableProvider.Name,
-							Type:        availableProvider.Type,
-							RoleName:    instanceGroup.Name,
-							JobName:     jobReference.Name,
-							ServiceName: serviceName,
-						},
-						Properties: availableProvider.Properties,
-					})
+					if shared {
+						providersByType[availableProvider.Type] = append(providersByType[availableProvider.Type], info)
+					}
+					groupProvidersByType[instanceGroup.Name][availableProvider.Type] = append(groupProvidersByType[instanceGroup.Name][availableProvider.Type], info)
 				}
 			}
 			for name, provider := range jobReference.ExportedProvides {
@@ -270,7 +342,7 @@ func (r *Resolver) ResolveLinks() validation.ErrorList {
 				if provider.Alias != "" {
 					name = provider.Alias
 				}
-				providersByName[name] = model.JobProvidesInfo{
+				provider := model.JobProvidesInfo{
 					JobLinkInfo: model.JobLinkInfo{
 						Name:        info.Name,
 						Type:        info.Type,
@@ -280,9 +352,45 @@ func (r *Resolver) ResolveLinks() validation.ErrorList {
 					},
 					Properties: info.Properties,
 				}
+				if shared {
+					providersByName[name] = provider
+				}
+				groupProvidersByName[instanceGroup.Name][name] = provider
+			}
+		}
+	}
+
+	// Find the host group of each colocated container; containers shared by
+	// several host groups resolve their links from the whole deployment only
+	hostGroups := make(map[string][]string)
+	for _, instanceGroup := range m.InstanceGroups {
+		for _, colocated := range instanceGroup.ColocatedContainers() {
+			hosts := hostGroups[colocated]
+			if len(hosts) == 0 || hosts[len(hosts)-1] != instanceGroup.Name {
+				hostGroups[colocated] = append(hosts, instanceGroup.Name)
 			}
 		}
 	}
+	lookupByName := func(instanceGroup *model.InstanceGroup, name string) (model.JobProvidesInfo, bool) {
+		if hosts := hostGroups[instanceGroup.Name]; len(hosts) == 1 {
+			if provider, ok := groupProvidersByName[hosts[0]][name]; ok {
+				return provider, true
+			}
+		}
+		provider, ok := providersByName[name]
+		return provider, ok
+	}
+	lookupByType := func(instanceGroup *model.InstanceGroup, linkType string) (model.JobProvidesInfo, bool) {
+		if hosts := hostGroups[instanceGroup.Name]; len(hosts) == 1 {
+			if providers := groupProvidersByType[hosts[0]][linkType]; len(providers) == 1 {
+				return providers[0], true
+			}
+		}
+		if len(providersByType[linkType]) == 1 {
+			return providersByType[linkType][0], true
+		}
+		return model.JobProvidesInfo{}, false
+	}
 
 	// Resolve the consumers
 	for _, instanceGroup := range m.InstanceGroups {
@@ -303,7 +411,7 @@ func (r *Resolver) ResolveLinks() validation.ErrorList {
 						fmt.Sprintf("consumer has no name")))
 					continue
 				}
-				provider, ok := providersByName[consumerAlias]
+				provider, ok := lookupByName(instanceGroup, consumerAlias)
 				if !ok {
 					errors = append(errors, validation.NotFound(
 						fmt.Sprintf(`instance_group[%s].job[%s].consumes[%s]`, instanceGroup.Name, jobReference.Name, consumerName),
@@ -328,15 +436,15 @@ func (r *Resolver) ResolveLinks() validation.ErrorList {
 			for _, consumerInfo := range expectedConsumers {
 				// Consumers don't _have_ to be listed; they can be automatically
 				// matched to a published name, or to the only provider of the
-				// same type in the whole deployment
+				// same type in the host group (for colocated containers) or in
+				// the whole deployment
 				var provider model.JobProvidesInfo
 				var ok bool
 				if consumerInfo.Name != "" {
-					provider, ok = providersByName[consumerInfo.Name]
+					provider, ok = lookupByName(instanceGroup, consumerInfo.Name)
 				}
-				if !ok && len(providersByType[consumerInfo.Type]) == 1 {
-					provider = providersByType[consumerInfo.Type][0]
-					ok = true
+				if !ok {
+					provider, ok = lookupByType(instanceGroup, consumerInfo.Type)
 				}
 				if ok {
 					name := consumerInfo.Name
@@ -360,6 +468,9 @@ func (r *Resolver) ResolveLinks() validation.ErrorList {
 	}
 
 	errors = append(errors, r.recordJobConsumers(m)...)
+	if m.Supports(model.SchemaFeatureLinkPropertyValidation) {
+		errors = append(errors, validateLinkProperties(m)...)
+	}
 
 	return errors
 }
@@ -1,6 +1,7 @@
 package resolver_test
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -9,6 +10,7 @@ import (
 	"code.cloudfoundry.org/fissile/model"
 	"code.cloudfoundry.org/fissile/model/loader"
 	"code.cloudfoundry.org/fissile/model/resolver"
+	"code.cloudfoundry.org/fissile/validation"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -183,6 +185,28 @@ func TestNonBoshRolesAreNotAllowed(t *testing.T) {
 	assert.Nil(t, roleManifest)
 }
 
+func TestLoadRoleManifestReportsAllStages(t *testing.T) {
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../../test-assets/tor-boshrelease")
+	roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/multiple-stages-bad.yml")
+	roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{torReleasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases")}})
+	require.Error(t, err)
+	assert.Nil(t, roleManifest)
+
+	errs, ok := err.(validation.ErrorList)
+	require.True(t, ok, "expected a list of validation errors, got %v", err)
+	assert.Equal(t, []string{
+		`variables[BAR].options.type: Invalid value: "bogus": Expected one of user, or environment`,
+		`instance_groups[dockerrole].type: Invalid value: "docker": Expected one of bosh, bosh-task, or colocated-container`,
+	}, errs.ErrorStrings())
+}
+
 func TestLoadRoleManifestVariablesPreviousNamesError(t *testing.T) {
 	workDir, err := os.Getwd()
 	assert.NoError(t, err)
@@ -203,6 +227,198 @@ func TestLoadRoleManifestVariablesPreviousNamesError(t *testing.T) {
 	assert.Nil(t, roleManifest)
 }
 
+func TestLoadRoleManifestVariablesDeprecationError(t *testing.T) {
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../../test-assets/tor-boshrelease")
+	roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/variables-with-bad-deprecation.yml")
+	roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{torReleasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases")}})
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `variables[BAR].options.deprecated.removal_version: Invalid value: "not-a-version"`)
+	assert.Contains(t, err.Error(), `variables[FOO].options.deprecated.removal_version: Required value: fail_after_removal requires a removal version`)
+	assert.NotContains(t, err.Error(), `variables[QUX]`)
+	assert.Nil(t, roleManifest)
+}
+
+func TestLoadRoleManifestVariablesExternalSecretsError(t *testing.T) {
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../../test-assets/tor-boshrelease")
+	roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/variables-with-bad-external-secrets.yml")
+	roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{torReleasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases")}})
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `external_secrets.store: Required value: The secret store of the ExternalSecret`)
+	assert.Contains(t, err.Error(), `external_secrets.store_kind: Unsupported value: "VaultStore": supported values: SecretStore, ClusterSecretStore`)
+	assert.Contains(t, err.Error(), `variables[BAR].options.external_key: Invalid value: "prod/bar": Only secrets can be read from an external secret store`)
+	assert.NotContains(t, err.Error(), `variables[FOO]`)
+	assert.Nil(t, roleManifest)
+}
+
+func TestLoadRoleManifestVariablesValidationError(t *testing.T) {
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../../test-assets/tor-boshrelease")
+	roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/variables-with-bad-validation.yml")
+	roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{torReleasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases")}})
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `variables[BAD_TYPE].options.validation.type: Unsupported value: "float"`)
+	assert.Contains(t, err.Error(), `variables[BAD_PATTERN].options.validation.pattern: Invalid value: "[a-z"`)
+	assert.Contains(t, err.Error(), `variables[BAD_RANGE].options.validation.min: Invalid value: 10: Must not exceed the max of 1`)
+	assert.Contains(t, err.Error(), `variables[BAD_MIN].options.validation.type: Invalid value: "string": min and max require a numeric type`)
+	assert.Contains(t, err.Error(), `variables[BAD_DEFAULT].options.default: Invalid value: "trace": The default must be one of debug, info`)
+	assert.NotContains(t, err.Error(), `variables[GOOD]`)
+	assert.Nil(t, roleManifest)
+}
+
+func TestLoadRoleManifestVariablesFiles(t *testing.T) {
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../../test-assets/tor-boshrelease")
+	releaseOptions := model.ReleaseOptions{
+		ReleasePaths:     []string{torReleasePath},
+		BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+		FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases")}
+
+	t.Run("Valid", func(t *testing.T) {
+		roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/variables-with-files.yml")
+		roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
+			ReleaseOptions: releaseOptions,
+			ValidationOptions: model.RoleManifestValidationOptions{
+				AllowMissingScripts: true,
+			}})
+		require.NoError(t, err)
+
+		binary := roleManifest.Variables[0]
+		assert.Equal(t, "BINARY", binary.Name)
+		assert.Equal(t, []byte("\x05\x02\x3cfissile\xff\xfe"), binary.CVOptions.FileContents)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/variables-with-missing-file.yml")
+		roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
+			ReleaseOptions: releaseOptions,
+			ValidationOptions: model.RoleManifestValidationOptions{
+				AllowMissingScripts: true,
+			}})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `variables[MISSING].options.file: Invalid value: "secrets/missing.keytab": open `)
+		assert.Nil(t, roleManifest)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/variables-with-bad-files.yml")
+		roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
+			ReleaseOptions: releaseOptions,
+			ValidationOptions: model.RoleManifestValidationOptions{
+				AllowMissingScripts: true,
+			}})
+		require.Error(t, err)
+
+		assert.Contains(t, err.Error(), `variables[DEFAULTED].options.file: Invalid value: "secrets/test.keytab": Secrets read from a file can't have a default`)
+		assert.Contains(t, err.Error(), `variables[GENERATED].options.file: Invalid value: "secrets/test.keytab": Secrets read from a file can't have the generator type password`)
+		assert.Contains(t, err.Error(), `variables[KEYTAB].options.file: Invalid value: "secrets/test.keytab": File contains NUL bytes, which environment variables can't hold`)
+		assert.Contains(t, err.Error(), `variables[PUBLIC].options.file: Invalid value: "secrets/test.keytab": Only secrets can be read from a file`)
+		assert.Nil(t, roleManifest)
+	})
+}
+
+func TestLoadRoleManifestDefaultsFiles(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	loadOptions := func(defaultsFiles ...string) model.LoadRoleManifestOptions {
+		return model.LoadRoleManifestOptions{
+			ReleaseOptions: model.ReleaseOptions{
+				ReleasePaths:     []string{filepath.Join(workDir, "../../test-assets/tor-boshrelease")},
+				BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+				FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases"),
+			},
+			ValidationOptions: model.RoleManifestValidationOptions{
+				AllowMissingScripts: true,
+			},
+			DefaultsFiles: defaultsFiles,
+		}
+	}
+	defaultsDir := filepath.Join(workDir, "../../test-assets/defaults-files")
+	roleManifestPath := filepath.Join(defaultsDir, "role-manifest.yml")
+
+	t.Run("Good", func(t *testing.T) {
+		t.Parallel()
+		staging := filepath.Join(defaultsDir, "staging.env")
+		roleManifest, err := loader.LoadRoleManifest(roleManifestPath, loadOptions(staging))
+		require.NoError(t, err)
+
+		base := filepath.Join(defaultsDir, "base.yml")
+		defaults := map[string][2]interface{}{}
+		for _, cv := range roleManifest.Variables {
+			defaults[cv.Name] = [2]interface{}{cv.CVOptions.Default, cv.CVOptions.DefaultSource}
+		}
+		assert.Equal(t, map[string][2]interface{}{
+			"CLIENT_KEYS": {"alice", ""},
+			"HOSTNAME":    {"staging.onion", staging},
+			"PASSWORD":    {"s3cr#t", staging},
+			"PRIVATE_KEY": {"base-key", base},
+		}, defaults)
+	})
+
+	t.Run("Bad", func(t *testing.T) {
+		t.Parallel()
+		unknown := filepath.Join(defaultsDir, "unknown.env")
+		roleManifest, err := loader.LoadRoleManifest(roleManifestPath, loadOptions(unknown))
+		assert.Nil(t, roleManifest)
+		assert.EqualError(t, err, "defaults_file["+unknown+`]: Not found: "MISSING"`)
+
+		_, err = loader.LoadRoleManifest(roleManifestPath, loadOptions(filepath.Join(defaultsDir, "bad.env")))
+		assert.EqualError(t, err, "Error parsing defaults file "+filepath.Join(defaultsDir, "bad.env")+": line 1: expected NAME=value")
+
+		_, err = loader.LoadRoleManifest(roleManifestPath, loadOptions(filepath.Join(defaultsDir, "missing.env")))
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadRoleManifestVariablesURLTemplates(t *testing.T) {
+	workDir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../../test-assets/tor-boshrelease")
+	roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/variables-with-url-templates.yml")
+	roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{torReleasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases")},
+		ValidationOptions: model.RoleManifestValidationOptions{
+			AllowMissingScripts: true,
+		}})
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `variables[DEFAULTED].options.url_template: Invalid value: "http://myrole-tor:9050": Variables with a url template can't have a default`)
+	assert.Contains(t, err.Error(), `variables[NO_SCHEME].options.url_template: Invalid value: "myrole-tor:9050": `)
+	assert.Contains(t, err.Error(), `variables[SECRET].options.url_template: Invalid value: "http://myrole-tor:9050": Only user variables that are neither secrets nor image names can have a url template`)
+	assert.Contains(t, err.Error(), `variables[UNKNOWN].options.url_template: Invalid value: "http://myrole-api:9050/v2": No instance group has a service named myrole-api`)
+	assert.NotContains(t, err.Error(), `variables[VALID]`)
+	assert.Nil(t, roleManifest)
+}
+
 func TestLoadRoleManifestVariablesSSH(t *testing.T) {
 	workDir, err := os.Getwd()
 	assert.NoError(t, err)
@@ -241,6 +457,8 @@ func TestLoadRoleManifestBadType(t *testing.T) {
 		`variables[BAR].type: Invalid value: "invalid": Expected one of certificate, password, rsa, ssh or empty`)
 	require.Contains(t, err.Error(),
 		`variables[FOO].type: Invalid value: "rsa": The rsa type is not yet supported by the secret generator`)
+	require.Contains(t, err.Error(),
+		`variables[BAZ].options.ca: Invalid value: "HOME": Expected the name of a certificate variable with is_ca set`)
 	assert.Nil(t, roleManifest)
 }
 
@@ -340,6 +558,34 @@ func TestLoadRoleManifestRunGeneral(t *testing.T) {
 				`instance_groups[myrole].jobs[tor].properties.bosh_containerization.ports[https].protocol: Unsupported value: "AA": supported values: TCP, UDP`,
 			},
 		},
+		{
+			"probe-profiles-bad.yml", []string{
+				`configuration.probe_profiles[fast].period: Invalid value: -1: must be greater than or equal to 0`,
+				`instance_groups[myrole].run.healthcheck.profile: Not found: "missing"`,
+				`instance_groups[otherrole].run.healthcheck.liveness.success_threshold: Invalid value: 2: Liveness probes require a success threshold of 1`,
+			},
+		},
+		{
+			"rbac-bad-aggregation.yml", []string{
+				`configuration.auth.aggregate-to[missing-role]: Not found: "missing-role"`,
+				`configuration.auth.aggregate-to[namespaced-role]: Invalid value: "namespaced-role": only cluster roles can be aggregated`,
+				`configuration.auth.aggregate-to[viewer]: Unsupported value: "cluster-admin": supported values: admin, edit, view`,
+			},
+		},
+		{
+			"bosh-run-bad-app-protocol.yml", []string{
+				`instance_groups[myrole].jobs[tor].properties.bosh_containerization.ports[https].app-protocol: Unsupported value: "h2c": supported values: http, https, grpc, tcp`,
+				`instance_groups[myrole].jobs[tor].properties.bosh_containerization.ports[dns].app-protocol: Invalid value: "tcp": application protocols require the TCP protocol`,
+				`instance_groups[myrole].jobs[tor].properties.bosh_containerization.ports[admin-api].name: Invalid value: "https-admin-api": user configurable port name must be no more than 9 characters`,
+			},
+		},
+		{
+			"bosh-run-bad-external-dns.yml", []string{
+				`instance_groups[myrole].jobs[tor].properties.bosh_containerization.ports[https].external-dns: Invalid value: "api.example.com": external-dns entries require a public port`,
+				`instance_groups[myrole].jobs[tor].properties.bosh_containerization.ports[ssh].external-dns.hostname: Required value`,
+				`instance_groups[myrole].jobs[tor].properties.bosh_containerization.ports[ssh].external-dns.ttl: Invalid value: -5: must be greater than or equal to 0`,
+			},
+		},
 		{
 			"bosh-run-bad-port-names.yml", []string{
 				`instance_groups[myrole].jobs[tor].properties.bosh_containerization.ports[a--b].name: Invalid value: "a--b": port names must be lowercase words separated by hyphens`,
@@ -384,6 +630,104 @@ func TestLoadRoleManifestRunGeneral(t *testing.T) {
 				`instance_groups[myrole].run.virtual-cpus: Invalid value: -2: must be greater than or equal to 0`,
 			},
 		},
+		{
+			"bosh-run-bad-writable-paths.yml", []string{
+				`instance_groups[myrole].run.writable-paths: Invalid value: "var/vcap/sys": Writable paths must be absolute paths other than /`,
+				`instance_groups[myrole].run.writable-paths: Invalid value: "/": Writable paths must be absolute paths other than /`,
+			},
+		},
+		{
+			"bosh-run-bad-sysctls.yml", []string{
+				`instance_groups[myrole].run.sysctls: Invalid value: "Net..core": Invalid sysctl name`,
+				`instance_groups[myrole].run.sysctls: Invalid value: "vm.max_map_count": Only namespaced sysctls (kernel.shm*, kernel.msg*, kernel.sem, fs.mqueue.*, net.*) can be set for a pod`,
+				`instance_groups[myrole].run.sysctls[net.ipv4.tcp_syncookies].value: Required value`,
+			},
+		},
+		{
+			"bosh-run-bad-downward-api-path.yml", []string{
+				`instance_groups[myrole].run.downward-api-path: Invalid value: "etc/podinfo": The downward API path must be an absolute path other than /`,
+			},
+		},
+		{
+			"bosh-run-bad-service-account-tokens.yml", []string{
+				`instance_groups[myrole].run.service-account-tokens: Invalid value: "var/run/secrets/vault": Service account tokens must be mounted at absolute paths other than /`,
+				`instance_groups[myrole].run.service-account-tokens[/var/run/secrets/sts].audience: Required value`,
+				`instance_groups[myrole].run.service-account-tokens[/var/run/secrets/sts.amazonaws.com].audience: Invalid value: "vault": Only one service account token per audience can be mounted`,
+				`instance_groups[myrole].run.service-account-tokens[/var/run/secrets/sts.amazonaws.com].expirationSeconds: Invalid value: 60: Service account tokens must expire after at least 600 seconds`,
+			},
+		},
+		{
+			"bosh-run-bad-backup.yml", []string{
+				`instance_groups[myrole].run.backup.volumes: Not found: "missing"`,
+				`instance_groups[myrole].run.backup.jobs: Not found: "ntpd"`,
+				`instance_groups[myrole].run.backup.artifact-path: Invalid value: "var/vcap/store/bbr": The artifact path must be an absolute path other than /`,
+				`instance_groups[myrole].run.backup.timeout: Invalid value: "five minutes": The timeout must be a duration, e.g. 5m`,
+				`instance_groups[myrole].run.backup.on-error: Invalid value: "Ignore": Must be Fail or Continue`,
+			},
+		},
+		{
+			"bosh-run-bad-job.yml", []string{
+				`instance_groups[myrole].run.job: Invalid value: "bosh": Only instance groups of type bosh-task run as jobs`,
+				`instance_groups[mytask].run.job.active-deadline-seconds: Invalid value: 0: Must be at least 1`,
+				`instance_groups[mytask].run.job.backoff-limit: Invalid value: -1: Must be at least 0`,
+				`instance_groups[mytask].run.job: Invalid value: "stop-on-failure": Instance groups tagged stop-on-failure run as pods, which have neither a backoff-limit nor a ttl-seconds-after-finished`,
+				`instance_groups[mytask].run.job.restart-policy: Invalid value: "Always": Must be Never or OnFailure`,
+			},
+		},
+		{
+			"bosh-run-bad-termination-grace-period.yml", []string{
+				`instance_groups[myrole].jobs[tor].properties.bosh_containerization.run.drain-timeout-seconds: Invalid value: 850: The drain script doesn't fit into the 810 seconds the pre-stop hook waits for it (90% of the termination grace period of 900 seconds); raise run.termination-grace-period-seconds`,
+				`instance_groups[otherrole].run.termination-grace-period-seconds: Invalid value: 0: Must be at least 1`,
+				`instance_groups[otherrole].jobs[tor].properties.bosh_containerization.run.drain-timeout-seconds: Invalid value: -1: Must be at least 0`,
+			},
+		},
+		{
+			"bosh-run-bad-sidecars.yml", []string{
+				`instance_groups[myrole].run.sidecars: Duplicate value: "exporter"`,
+				`instance_groups[myrole].run.sidecars[myrole].name: Duplicate value: "myrole"`,
+				`instance_groups[myrole].run.sidecars[exporter].image: Required value`,
+				`instance_groups[myrole].run.sidecars[exporter].ports[metrics].port: Invalid value: 70000: must be between 1 and 65535, inclusive`,
+				`instance_groups[myrole].run.sidecars[exporter].ports[metrics].protocol: Unsupported value: "SCTP": supported values: TCP, UDP`,
+				`instance_groups[myrole].run.sidecars[exporter].volume-mounts[missing].tag: Not found: "missing"`,
+				`instance_groups[myrole].run.sidecars[exporter].volume-mounts[missing].path: Invalid value: "var/run": Volumes must be mounted at absolute paths`,
+			},
+		},
+		{
+			"bosh-run-bad-monitoring.yml", []string{
+				`instance_groups[myrole].monitoring.port: Not found: "missing"`,
+				`instance_groups[myrole].monitoring.path: Invalid value: "metrics": The path must be absolute`,
+				`instance_groups[myrole].monitoring.interval: Invalid value: "often": The interval must be a duration, e.g. 30s`,
+				`instance_groups[otherrole].monitoring.port: Invalid value: "http": Ports with several instances cannot be monitored`,
+			},
+		},
+		{
+			"bosh-run-bad-stemcells.yml", []string{
+				`release_stemcells[missing]: Not found: "missing"`,
+				`release_stemcells[tor]: Required value: The stemcell image of the release`,
+			},
+		},
+		{
+			"bosh-run-bad-drop-capabilities.yml", []string{
+				`instance_groups[myrole].run.drop-capabilities: Invalid value: "CAP_SYS_ADMIN": Unknown capability; use the name without the CAP_ prefix, or ALL`,
+			},
+		},
+		{
+			"bosh-run-bad-runtime-scripts.yml", []string{
+				`runtime_scripts: Invalid value: "/opt/scripts": Path must be relative to the role manifest`,
+			},
+		},
+		{
+			"bosh-run-bad-previous-names.yml", []string{
+				`instance_groups[myrole].previous_names: Invalid value: "foorole": Previous name is also the name of an instance group`,
+				`instance_groups[otherrole].previous_names: Invalid value: "oldrole": Previous name also claimed by 'myrole'`,
+				`instance_groups[foorole].previous_names: Invalid value: "bosh-task": Only instance groups of type bosh can have previous names`,
+			},
+		},
+		{
+			"cluster-scoped-bad.yml", []string{
+				`configuration.cluster_scoped: Unsupported value: "Deployment": supported values: APIService, ClusterRole, ClusterRoleBinding, CustomResourceDefinition, MutatingWebhookConfiguration, Namespace, PersistentVolume, PodSecurityPolicy, PriorityClass, StorageClass, ValidatingWebhookConfiguration`,
+			},
+		},
 		{
 			"bosh-run-ok.yml", []string{},
 		},
@@ -719,6 +1063,123 @@ func TestRoleResolveLinksMultipleProvider(t *testing.T) {
 	}
 }
 
+func TestResolveLinksColocatedContainers(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Every main group provides a database (of the same type) and a
+	// non-exported cache
+	mainJob := &model.Job{
+		Name: "main-job",
+		AvailableProviders: map[string]model.JobProvidesInfo{
+			"database": {JobLinkInfo: model.JobLinkInfo{Name: "database", Type: "db"}},
+			"cache":    {JobLinkInfo: model.JobLinkInfo{Name: "cache", Type: "cache"}},
+		},
+	}
+	sidecarJob := &model.Job{
+		Name: "sidecar-job",
+		DesiredConsumers: []model.JobConsumesInfo{
+			// Multiple providers of this type exist in the deployment
+			{JobLinkInfo: model.JobLinkInfo{Name: "db", Type: "db"}},
+			// Not exported by the host group
+			{JobLinkInfo: model.JobLinkInfo{Name: "cache", Type: "cache"}},
+			// Only available from another instance group
+			{JobLinkInfo: model.JobLinkInfo{Name: "other", Type: "other"}},
+			// Resolved via an alias
+			{JobLinkInfo: model.JobLinkInfo{Name: "aliased", Type: "db"}},
+		},
+	}
+	otherJob := &model.Job{
+		Name: "other-job",
+		AvailableProviders: map[string]model.JobProvidesInfo{
+			"other": {JobLinkInfo: model.JobLinkInfo{Name: "other", Type: "other"}},
+		},
+	}
+	hostContainerization := model.JobContainerProperties{
+		BoshContainerization: model.JobBoshContainerization{
+			ColocatedContainers: []string{"sidecar"},
+		},
+	}
+
+	roleManifest := &model.RoleManifest{
+		InstanceGroups: model.InstanceGroups{
+			&model.InstanceGroup{
+				Name: "first",
+				JobReferences: model.JobReferences{{
+					Job: mainJob,
+					ExportedProvides: map[string]model.JobProvidesInfo{
+						"database": {Alias: "first-database"},
+					},
+				}},
+			},
+			&model.InstanceGroup{
+				Name: "host",
+				JobReferences: model.JobReferences{{
+					Job: mainJob,
+					ExportedProvides: map[string]model.JobProvidesInfo{
+						"database": {Alias: "host-database"},
+					},
+					ContainerProperties: hostContainerization,
+				}},
+			},
+			&model.InstanceGroup{
+				Name:          "other",
+				JobReferences: model.JobReferences{{Job: otherJob}},
+			},
+			&model.InstanceGroup{
+				Name: "sidecar",
+				Type: model.RoleTypeColocatedContainer,
+				JobReferences: model.JobReferences{{
+					Job: sidecarJob,
+					ResolvedConsumes: map[string]model.JobConsumesInfo{
+						"aliased": {Alias: "host-database"},
+					},
+				}},
+			},
+		},
+	}
+	for _, r := range roleManifest.InstanceGroups {
+		for _, jobReference := range r.JobReferences {
+			jobReference.Name = jobReference.Job.Name
+			if jobReference.ResolvedConsumes == nil {
+				jobReference.ResolvedConsumes = make(map[string]model.JobConsumesInfo)
+			}
+			jobReference.ResolvedConsumedBy = make(map[string][]model.JobLinkInfo)
+		}
+	}
+
+	errors := resolver.NewResolver(roleManifest, nil, model.LoadRoleManifestOptions{}).ResolveLinks()
+	require.Empty(errors)
+
+	consumes := roleManifest.LookupInstanceGroup("sidecar").LookupJob("sidecar-job").ResolvedConsumes
+	hostProvider := func(name, linkType string) model.JobConsumesInfo {
+		return model.JobConsumesInfo{JobLinkInfo: model.JobLinkInfo{
+			Name:        name,
+			Type:        linkType,
+			RoleName:    "host",
+			JobName:     "main-job",
+			ServiceName: "host-main-job",
+		}}
+	}
+	assert.Equal(hostProvider("database", "db"), consumes["db"], "should resolve the type from the host group")
+	assert.Equal(hostProvider("cache", "cache"), consumes["cache"], "should resolve non-exported providers of the host group")
+	assert.Equal(hostProvider("database", "db"), consumes["aliased"], "should resolve the alias")
+	assert.Equal("other", consumes["other"].RoleName, "should fall back to the whole deployment")
+
+	consumedBy := roleManifest.LookupInstanceGroup("host").LookupJob("main-job").ResolvedConsumedBy
+	assert.Len(consumedBy["cache"], 1)
+
+	// Containers shared by several host groups can't prefer either of them
+	roleManifest.LookupInstanceGroup("first").JobReferences[0].ContainerProperties = hostContainerization
+	sidecar := roleManifest.LookupInstanceGroup("sidecar").LookupJob("sidecar-job")
+	sidecar.ResolvedConsumes = map[string]model.JobConsumesInfo{"aliased": {Alias: "host-database"}}
+	errors = resolver.NewResolver(roleManifest, nil, model.LoadRoleManifestOptions{}).ResolveLinks()
+	assert.Contains(errors.Error(), "instance_group[sidecar].job[sidecar-job].consumes[db]: Required value: failed to resolve provider db (type db)")
+	assert.Contains(errors.Error(), "instance_group[sidecar].job[sidecar-job].consumes[cache]: Required value: failed to resolve provider cache (type cache)")
+	assert.NotContains(errors.Error(), "consumes[aliased]")
+	assert.NotContains(errors.Error(), "consumes[other]")
+}
+
 func TestLoadRoleManifestColocatedContainers(t *testing.T) {
 	assert := assert.New(t)
 
@@ -894,15 +1355,68 @@ func TestLoadRoleManifestColocatedContainersValidationOfMultipleColocatedContain
 	assert.Nil(err)
 }
 
+func TestLoadRoleManifestColocatedContainersValidationSysctls(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../../test-assets/tor-boshrelease")
+	ntpReleasePath := filepath.Join(workDir, "../../test-assets/ntp-release")
+	roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/colocated-containers-with-sysctl-conflict.yml")
+	options := model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{torReleasePath, ntpReleasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases")},
+		ValidationOptions: model.RoleManifestValidationOptions{
+			AllowMissingScripts: true,
+		}}
+	roleManifest, err := loader.LoadRoleManifest(roleManifestPath, options)
+	assert.Nil(roleManifest)
+	assert.EqualError(err, `instance_group[to-be-colocated]: Invalid value: "net.core.somaxconn": colocated instance group sets sysctl to "4096", which conflicts with "1024" set for the pod of main-role`)
+}
+
+func TestLoadRoleManifestColocatedContainersValidationSharedSockets(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../../test-assets/tor-boshrelease")
+	ntpReleasePath := filepath.Join(workDir, "../../test-assets/ntp-release")
+	roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/colocated-containers-with-shared-socket-issues.yml")
+	options := model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{torReleasePath, ntpReleasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases")},
+		ValidationOptions: model.RoleManifestValidationOptions{
+			AllowMissingScripts: true,
+		}}
+	roleManifest, err := loader.LoadRoleManifest(roleManifestPath, options)
+	assert.Nil(roleManifest)
+	assert.EqualError(err, strings.Join([]string{
+		`instance_group[to-be-colocated]: Invalid value: "agent-socket": shared socket is already declared by instance group main-role, and mounted into all containers of the pod of main-role`,
+		`instance_group[to-be-colocated]: Invalid value: "metrics-socket": shared socket path /var/vcap/store/main/ is also used by volume main-store of instance group main-role`,
+		`instance_group[lonely-role]: Invalid value: "lonely-socket": shared sockets can only be used by instance groups with colocated containers`,
+	}, "\n"))
+}
+
 func TestLoadRoleManifestWithReleaseReferences(t *testing.T) {
 	workDir, err := os.Getwd()
 	assert.NoError(t, err)
 
+	// The releases are downloaded, keep them out of the source tree
+	finalReleasesDir, err := ioutil.TempDir("", "fissile-final-releases")
+	require.NoError(t, err)
+	defer os.RemoveAll(finalReleasesDir)
+
 	roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/online-release-references.yml")
 	roleManifest, err := loader.LoadRoleManifest(roleManifestPath, model.LoadRoleManifestOptions{
 		ReleaseOptions: model.ReleaseOptions{
 			BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
-			FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases")},
+			FinalReleasesDir: finalReleasesDir},
 		ValidationOptions: model.RoleManifestValidationOptions{
 			AllowMissingScripts: true,
 		}})
@@ -912,3 +1426,248 @@ func TestLoadRoleManifestWithReleaseReferences(t *testing.T) {
 	assert.Equal(t, roleManifestPath, roleManifest.ManifestFilePath)
 	assert.Len(t, roleManifest.InstanceGroups, 1)
 }
+
+func TestLoadRoleManifestSchemaVersion(t *testing.T) {
+	t.Parallel()
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../../test-assets/tor-boshrelease")
+	options := model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{torReleasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases")},
+		ValidationOptions: model.RoleManifestValidationOptions{
+			AllowMissingScripts: true,
+		}}
+
+	t.Run("Unsupported", func(t *testing.T) {
+		t.Parallel()
+		roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/schema-version-unsupported.yml")
+		roleManifest, err := loader.LoadRoleManifest(roleManifestPath, options)
+		require.Error(t, err)
+		assert.Equal(t, `schema_version: Invalid value: 3: This fissile supports role manifest schema versions up to 2; the role manifest requires a newer fissile (9.0.0 or later)`, err.Error())
+		assert.Nil(t, roleManifest)
+	})
+
+	t.Run("Features", func(t *testing.T) {
+		t.Parallel()
+		roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/schema-version-features.yml")
+		roleManifest, err := loader.LoadRoleManifest(roleManifestPath, options)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `instance_groups[myrole].zones: Forbidden: zones requires schema_version 2 or later`)
+		assert.Nil(t, roleManifest)
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		roleManifest := &model.RoleManifest{}
+		assert.Equal(t, 1, roleManifest.EffectiveSchemaVersion())
+		assert.False(t, roleManifest.Supports(model.SchemaFeatureZones))
+		roleManifest.SchemaVersion = model.CurrentSchemaVersion
+		for feature := range model.SchemaFeatures {
+			assert.True(t, roleManifest.Supports(feature), "%s should be supported by the current schema", feature)
+		}
+	})
+}
+
+func TestLoadRoleManifestZones(t *testing.T) {
+	t.Parallel()
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../../test-assets/tor-boshrelease")
+	ntpReleasePath := filepath.Join(workDir, "../../test-assets/ntp-release")
+	options := model.LoadRoleManifestOptions{
+		ReleaseOptions: model.ReleaseOptions{
+			ReleasePaths:     []string{torReleasePath, ntpReleasePath},
+			BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+			FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases")},
+		ValidationOptions: model.RoleManifestValidationOptions{
+			AllowMissingScripts: true,
+		}}
+
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/zones.yml")
+		roleManifest, err := loader.LoadRoleManifest(roleManifestPath, options)
+		require.NoError(t, err)
+
+		require.Len(t, roleManifest.InstanceGroups, 2)
+		assert.Nil(t, roleManifest.LookupInstanceGroup("myrole"))
+
+		z1 := roleManifest.LookupInstanceGroup("myrole-z1")
+		require.NotNil(t, z1)
+		assert.Equal(t, "us-east-1a", z1.Zone.Label)
+		assert.Equal(t, "myrole", z1.ImageName())
+		assert.Equal(t, &model.RoleRunScaling{Min: 1, Max: 3, HA: 1}, z1.Run.Scaling)
+
+		z2 := roleManifest.LookupInstanceGroup("myrole-z2")
+		require.NotNil(t, z2)
+		assert.Equal(t, "z2", z2.Zone.Label)
+		assert.Equal(t, &model.RoleRunScaling{Min: 2, Max: 5, HA: 2}, z2.Run.Scaling)
+		assert.Equal(t, roleManifest, z2.Manifest())
+
+		instanceGroups := roleManifest.InstanceGroups.WithoutZoneReplicas()
+		require.Len(t, instanceGroups, 1)
+		assert.Equal(t, "myrole", instanceGroups[0].Name)
+		assert.Equal(t, "myrole", instanceGroups[0].ImageName())
+
+		assert.Equal(t, map[string]struct{}{"myrole-z1": {}, "myrole-z2": {}},
+			roleManifest.Configuration.Authorization.Accounts["default"].UsedBy)
+	})
+
+	t.Run("Links", func(t *testing.T) {
+		t.Parallel()
+		roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/zones-links.yml")
+		roleManifest, err := loader.LoadRoleManifest(roleManifestPath, options)
+		require.NoError(t, err, "The ntp-server link should resolve by type despite the replicas")
+
+		z1 := roleManifest.LookupInstanceGroup("myrole-z1").LookupJob("ntpd")
+		z2 := roleManifest.LookupInstanceGroup("myrole-z2").LookupJob("ntpd")
+		require.NotNil(t, z1)
+		require.NotNil(t, z2)
+		assert.False(t, z1 == z2, "The replicas should not share their job references")
+		for _, jobReference := range []*model.JobReference{z1, z2} {
+			require.Contains(t, jobReference.ResolvedConsumes, "ntp-server")
+			assert.Equal(t, "myrole-z1", jobReference.ResolvedConsumes["ntp-server"].RoleName)
+		}
+		assert.ElementsMatch(t, []model.JobLinkInfo{
+			{Name: "ntp-server", Type: "ntpd", RoleName: "myrole-z1", JobName: "ntpd", ServiceName: "myrole-z1-ntpd"},
+			{Name: "ntp-server", Type: "ntpd", RoleName: "myrole-z2", JobName: "ntpd", ServiceName: "myrole-z1-ntpd"},
+		}, z1.ResolvedConsumedBy["ntp-server"])
+		assert.Empty(t, z2.ResolvedConsumedBy)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/zones-bad.yml")
+		_, err := loader.LoadRoleManifest(roleManifestPath, options)
+		assert.EqualError(t, err, strings.Join([]string{
+			`instance_groups[myrole].zones[Z1].name: Invalid value: "Z1": zone names must be lowercase words separated by hyphens`,
+			`instance_groups[myrole].zones[z2].scaling.max: Invalid value: 1: maximum number of instances is smaller than the minimum 2`,
+			`instance_groups[myrole].zones[z2]: Duplicate value: "myrole-z2"`,
+			`instance_groups[mytask].zones: Invalid value: "bosh-task": Only instance groups of type bosh can be replicated across zones`,
+		}, "\n"))
+	})
+}
+
+func TestResolveLinksValidatesLinkProperties(t *testing.T) {
+	t.Parallel()
+
+	provider := &model.Job{
+		Name: "provider",
+		AvailableProviders: map[string]model.JobProvidesInfo{
+			"db": {
+				JobLinkInfo: model.JobLinkInfo{Name: "db", Type: "database"},
+				Properties:  []string{"db.port", "db.tls"},
+			},
+		},
+	}
+	consumer := &model.Job{
+		Name: "consumer",
+		Templates: []*model.JobTemplate{
+			{Content: `port=<%= link('db').p('db.port') %>`},
+			{Content: `<% if_link('db') do |db| %>user=<%= db.p('db.user', 'admin') %><% end %>`},
+			{Content: `<% db = link("db") %><% db.if_p("db.tls.ca", "db.password") do |ca, password| %><% end %>`},
+		},
+		DesiredConsumers: []model.JobConsumesInfo{
+			{JobLinkInfo: model.JobLinkInfo{Name: "db", Type: "database"}},
+		},
+	}
+
+	roleManifest := &model.RoleManifest{
+		SchemaVersion: 2,
+		InstanceGroups: model.InstanceGroups{
+			&model.InstanceGroup{
+				Name: "database",
+				JobReferences: model.JobReferences{{
+					Job:              provider,
+					ExportedProvides: map[string]model.JobProvidesInfo{"db": {}},
+				}},
+			},
+			&model.InstanceGroup{
+				Name:          "app",
+				JobReferences: model.JobReferences{{Job: consumer}},
+			},
+		},
+	}
+	for _, r := range roleManifest.InstanceGroups {
+		for _, jobReference := range r.JobReferences {
+			jobReference.Name = jobReference.Job.Name
+			jobReference.ResolvedConsumes = make(map[string]model.JobConsumesInfo)
+			jobReference.ResolvedConsumedBy = make(map[string][]model.JobLinkInfo)
+		}
+	}
+
+	errors := resolver.NewResolver(roleManifest, nil, model.LoadRoleManifestOptions{}).ResolveLinks()
+	assert.Equal(t, []string{
+		`instance_groups[app].jobs[consumer].consumes[db]: Invalid value: "db.password": Property is not exported by job provider of instance group database`,
+		`instance_groups[app].jobs[consumer].consumes[db]: Invalid value: "db.user": Property is not exported by job provider of instance group database`,
+	}, errors.ErrorStrings())
+
+	// The validation is only active from schema version 2 on
+	roleManifest.SchemaVersion = 1
+	errors = resolver.NewResolver(roleManifest, nil, model.LoadRoleManifestOptions{}).ResolveLinks()
+	assert.Empty(t, errors)
+}
+
+func TestLoadRoleManifestRuntimeConfigs(t *testing.T) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	loadOptions := func(runtimeConfig string) model.LoadRoleManifestOptions {
+		return model.LoadRoleManifestOptions{
+			ReleaseOptions: model.ReleaseOptions{
+				ReleasePaths:     []string{filepath.Join(workDir, "../../test-assets/tor-boshrelease")},
+				BOSHCacheDir:     filepath.Join(workDir, "../../test-assets/bosh-cache"),
+				FinalReleasesDir: filepath.Join(workDir, "../../test-assets/.final_releases"),
+			},
+			ValidationOptions: model.RoleManifestValidationOptions{
+				AllowMissingScripts: true,
+			},
+			RuntimeConfigs: []string{runtimeConfig},
+		}
+	}
+	roleManifestPath := filepath.Join(workDir, "../../test-assets/role-manifests/model/tor-good.yml")
+
+	t.Run("Good", func(t *testing.T) {
+		t.Parallel()
+		runtimeConfigPath := filepath.Join(workDir, "../../test-assets/runtime-configs/tor-addons.yml")
+		roleManifest, err := loader.LoadRoleManifest(roleManifestPath, loadOptions(runtimeConfigPath))
+		require.NoError(t, err)
+
+		source := "runtime_config[" + runtimeConfigPath + "].addons[tor-defaults]"
+		assert.Equal(t, map[string]model.ConfigurationTemplate{
+			"properties.tor.client_keys": {Value: `["alice","bob"]`, Source: source},
+			"properties.tor.hostname":    {Value: "example.onion", Source: source},
+		}, roleManifest.LookupInstanceGroup("myrole").Configuration.Templates)
+
+		source = "runtime_config[" + runtimeConfigPath + "].addons[tor-errands]"
+		assert.Equal(t, map[string]model.ConfigurationTemplate{
+			"properties.tor.private_key": {Value: "((TOR_PRIVATE_KEY))", Source: source},
+		}, roleManifest.LookupInstanceGroup("foorole").Configuration.Templates)
+	})
+
+	t.Run("Bad", func(t *testing.T) {
+		t.Parallel()
+		runtimeConfigPath := filepath.Join(workDir, "../../test-assets/runtime-configs/tor-addons-bad.yml")
+		roleManifest, err := loader.LoadRoleManifest(roleManifestPath, loadOptions(runtimeConfigPath))
+		assert.Nil(t, roleManifest)
+		prefix := "runtime_config[" + runtimeConfigPath + "].addons"
+		assert.EqualError(t, err, strings.Join([]string{
+			prefix + `[missing-group].include.instance_groups: Invalid value: "missing": No such instance group`,
+			prefix + `[missing-group].include.lifecycle: Invalid value: "sometimes": Expected one of service or errand`,
+			prefix + `[other-hostname][properties.tor.hostname]: Forbidden: Conflicts with ` + prefix + `[tor-hostname] for instance group myrole`,
+		}, "\n"))
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		t.Parallel()
+		_, err := loader.LoadRoleManifest(roleManifestPath, loadOptions(filepath.Join(workDir, "missing.yml")))
+		assert.Error(t, err)
+	})
+}
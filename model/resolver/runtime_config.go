@@ -0,0 +1,182 @@
+package resolver
+
+import (
+	"fmt"
+	"sort"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/util"
+	"code.cloudfoundry.org/fissile/validation"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// applyRuntimeConfigs merges the properties of the addons of the runtime
+// configs into the configuration templates of the instance groups they are
+// placed on. The properties of the addon jobs take precedence over the ones
+// of the addon itself. The templates are recorded with the addon as their
+// source, which is reported in later validation errors.
+func applyRuntimeConfigs(m *model.RoleManifest, runtimeConfigs []*model.RuntimeConfig) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, runtimeConfig := range runtimeConfigs {
+		for index, addon := range runtimeConfig.Addons {
+			name := addon.Name
+			if name == "" {
+				name = fmt.Sprintf("%d", index)
+			}
+			source := fmt.Sprintf("runtime_config[%s].addons[%s]", runtimeConfig.Path, name)
+			allErrs = append(allErrs, validateRuntimeConfigAddon(m, source, addon)...)
+
+			templates := map[string]string{}
+			allErrs = append(allErrs, flattenAddonProperties(source+".properties", "properties", addon.Properties, templates)...)
+			for _, job := range addon.Jobs {
+				jobSource := fmt.Sprintf("%s.jobs[%s].properties", source, job.Name)
+				allErrs = append(allErrs, flattenAddonProperties(jobSource, "properties", job.Properties, templates)...)
+			}
+
+			for _, instanceGroup := range m.InstanceGroups {
+				if !addon.PlacedOn(instanceGroup) {
+					continue
+				}
+				allErrs = append(allErrs, addAddonTemplates(m, instanceGroup, source, templates)...)
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// validateRuntimeConfigAddon checks that the placement rules of the addon
+// refer to existing instance groups
+func validateRuntimeConfigAddon(m *model.RoleManifest, source string, addon *model.RuntimeConfigAddon) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	for _, ruleName := range []string{"include", "exclude"} {
+		rule := addon.Include
+		if ruleName == "exclude" {
+			rule = addon.Exclude
+		}
+		if rule == nil {
+			continue
+		}
+		for _, name := range rule.InstanceGroups {
+			if m.LookupInstanceGroup(name) == nil {
+				allErrs = append(allErrs, validation.Invalid(
+					fmt.Sprintf("%s.%s.instance_groups", source, ruleName),
+					name, "No such instance group"))
+			}
+		}
+		switch rule.Lifecycle {
+		case "", model.RuntimeConfigLifecycleService, model.RuntimeConfigLifecycleErrand:
+		default:
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("%s.%s.lifecycle", source, ruleName),
+				rule.Lifecycle, "Expected one of service or errand"))
+		}
+	}
+	return allErrs
+}
+
+// flattenAddonProperties converts nested addon properties into template
+// keys using dotted names. Lists are rendered as JSON, which is also valid
+// YAML for the templates.
+func flattenAddonProperties(source, prefix string, properties map[interface{}]interface{}, templates map[string]string) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	var keys []string
+	for key := range properties {
+		keyString, ok := key.(string)
+		if !ok {
+			allErrs = append(allErrs, validation.Invalid(source, key, "Property name must be a string"))
+			continue
+		}
+		keys = append(keys, keyString)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := prefix + "." + key
+		value := properties[key]
+		switch value := value.(type) {
+		case map[interface{}]interface{}:
+			allErrs = append(allErrs, flattenAddonProperties(source, name, value, templates)...)
+		case string:
+			templates[name] = value
+		case []interface{}:
+			buf, err := util.JSONMarshal(value)
+			if err != nil {
+				allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("%s[%s]", source, name), value, err.Error()))
+				continue
+			}
+			templates[name] = string(buf)
+		case nil:
+			templates[name] = ""
+		default:
+			templates[name] = fmt.Sprintf("%v", value)
+		}
+	}
+	return allErrs
+}
+
+// addAddonTemplates adds the templates of an addon to the instance group.
+// Conflicts with the templates of the role manifest, or of other addons,
+// are errors. The templates are inserted in sorted order, so as to keep
+// sorted manifests sorted.
+func addAddonTemplates(m *model.RoleManifest, instanceGroup *model.InstanceGroup, source string, templates map[string]string) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if instanceGroup.Configuration == nil {
+		instanceGroup.Configuration = &model.Configuration{}
+	}
+	configuration := instanceGroup.Configuration
+	if configuration.TemplateSources == nil {
+		configuration.TemplateSources = map[string]string{}
+	}
+
+	var names []string
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := templates[name]
+		field := fmt.Sprintf("%s[%s]", source, name)
+
+		if _, ok := findTemplate(m.Configuration.RawTemplates, name); ok {
+			allErrs = append(allErrs, validation.Forbidden(field,
+				"Conflicts with configuration.templates of the role manifest"))
+			continue
+		}
+		if existing, ok := findTemplate(configuration.RawTemplates, name); ok {
+			existingSource, fromAddon := configuration.TemplateSources[name]
+			if !fromAddon {
+				allErrs = append(allErrs, validation.Forbidden(field,
+					fmt.Sprintf("Conflicts with instance_groups[%s].configuration.templates of the role manifest", instanceGroup.Name)))
+			} else if fmt.Sprintf("%v", existing) != value {
+				allErrs = append(allErrs, validation.Forbidden(field,
+					fmt.Sprintf("Conflicts with %s for instance group %s", existingSource, instanceGroup.Name)))
+			}
+			continue
+		}
+
+		position := sort.Search(len(configuration.RawTemplates), func(i int) bool {
+			key, ok := configuration.RawTemplates[i].Key.(string)
+			return ok && key > name
+		})
+		configuration.RawTemplates = append(configuration.RawTemplates, yaml.MapItem{})
+		copy(configuration.RawTemplates[position+1:], configuration.RawTemplates[position:])
+		configuration.RawTemplates[position] = yaml.MapItem{Key: name, Value: value}
+		configuration.TemplateSources[name] = source
+	}
+
+	return allErrs
+}
+
+// findTemplate returns the value of the template with the given key
+func findTemplate(templates yaml.MapSlice, name string) (interface{}, bool) {
+	for _, template := range templates {
+		if template.Key == name {
+			return template.Value, true
+		}
+	}
+	return nil, false
+}
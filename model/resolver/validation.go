@@ -1,16 +1,25 @@
 package resolver
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/util"
 	"code.cloudfoundry.org/fissile/validation"
+	"github.com/Masterminds/semver"
 )
 
+// maxSecretSize is the maximum size of a Kubernetes secret
+const maxSecretSize = 1024 * 1024
+
 // Validate implements several checks for the instance group and its job references. It's run after the
 // instance groups are filtered and i.e. Run has been calculated.
 // It adds the releases Job spec to the instance groups JobReferences
@@ -82,6 +91,71 @@ func validateInstanceGroup(roleManifest *model.RoleManifest, g *model.InstanceGr
 	return allErrs
 }
 
+// validateProbeProfiles reports probe profiles with negative timings
+func validateProbeProfiles(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if roleManifest.Configuration == nil {
+		return allErrs
+	}
+
+	var names []string
+	for name := range roleManifest.Configuration.ProbeProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		profile := roleManifest.Configuration.ProbeProfiles[name]
+		field := fmt.Sprintf("configuration.probe_profiles[%s]", name)
+		if profile == nil {
+			allErrs = append(allErrs, validation.Required(field, "probe profile requires timings"))
+			continue
+		}
+		allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(profile.InitialDelay), field+".initial_delay")...)
+		allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(profile.Period), field+".period")...)
+		allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(profile.Timeout), field+".timeout")...)
+		allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(profile.SuccessThreshold), field+".success_threshold")...)
+		allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(profile.FailureThreshold), field+".failure_threshold")...)
+	}
+
+	return allErrs
+}
+
+// validateAuthAggregation checks that only existing cluster roles are
+// aggregated, and only into the builtin cluster roles
+func validateAuthAggregation(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if roleManifest.Configuration == nil {
+		return allErrs
+	}
+	auth := roleManifest.Configuration.Authorization
+
+	var names []string
+	for name := range auth.AggregateTo {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := fmt.Sprintf("configuration.auth.aggregate-to[%s]", name)
+		if _, ok := auth.ClusterRoles[name]; !ok {
+			if _, ok := auth.Roles[name]; ok {
+				allErrs = append(allErrs, validation.Invalid(field, name, "only cluster roles can be aggregated"))
+			} else {
+				allErrs = append(allErrs, validation.NotFound(field, name))
+			}
+			continue
+		}
+		for _, target := range auth.AggregateTo[name] {
+			if !util.StringInSlice(target, model.AggregationTargets) {
+				allErrs = append(allErrs, validation.NotSupported(field, target, model.AggregationTargets))
+			}
+		}
+	}
+
+	return allErrs
+}
+
 // validateTemplateKeys tests whether all template keys are strings and that
 // global template values are strings
 func validateTemplateKeysAndValues(roleManifest *model.RoleManifest) validation.ErrorList {
@@ -129,15 +203,17 @@ func validateRoleTags(instanceGroup *model.InstanceGroup) validation.ErrorList {
 	var allErrs validation.ErrorList
 
 	acceptableRoleTypes := map[model.RoleTag][]model.RoleType{
-		model.RoleTagActivePassive:     []model.RoleType{model.RoleTypeBosh},
-		model.RoleTagSequentialStartup: []model.RoleType{model.RoleTypeBosh},
-		model.RoleTagStopOnFailure:     []model.RoleType{model.RoleTypeBoshTask},
-		model.RoleTagIstioManaged:      []model.RoleType{model.RoleTypeBosh},
+		model.RoleTagActivePassive:        []model.RoleType{model.RoleTypeBosh},
+		model.RoleTagSequentialStartup:    []model.RoleType{model.RoleTypeBosh},
+		model.RoleTagStopOnFailure:        []model.RoleType{model.RoleTypeBoshTask},
+		model.RoleTagIstioManaged:         []model.RoleType{model.RoleTypeBosh},
+		model.RoleTagNoDeploymentManifest: []model.RoleType{model.RoleTypeBosh, model.RoleTypeBoshTask},
 	}
 
 	for tagNum, tag := range instanceGroup.Tags {
 		switch tag {
 		case model.RoleTagIstioManaged:
+		case model.RoleTagNoDeploymentManifest:
 		case model.RoleTagStopOnFailure:
 		case model.RoleTagSequentialStartup:
 		case model.RoleTagActivePassive:
@@ -189,6 +265,11 @@ func validateRoleTags(instanceGroup *model.InstanceGroup) validation.ErrorList {
 func validateVariableType(variables model.Variables) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 
+	byName := map[string]*model.VariableDefinition{}
+	for _, cv := range variables {
+		byName[cv.Name] = cv
+	}
+
 	for _, cv := range variables {
 		switch cv.Type {
 		case "":
@@ -205,6 +286,19 @@ func validateVariableType(variables model.Variables) validation.ErrorList {
 				cv.Type, "Expected one of certificate, password, rsa, ssh or empty"))
 		}
 
+		if cv.CVOptions.CA != "" {
+			ca := byName[cv.CVOptions.CA]
+			if cv.Type != "certificate" {
+				allErrs = append(allErrs, validation.Invalid(
+					fmt.Sprintf("variables[%s].options.ca", cv.Name),
+					cv.CVOptions.CA, "Only certificates are signed by a CA"))
+			} else if ca == nil || ca.Type != "certificate" || !ca.CVOptions.IsCA {
+				allErrs = append(allErrs, validation.Invalid(
+					fmt.Sprintf("variables[%s].options.ca", cv.Name),
+					cv.CVOptions.CA, "Expected the name of a certificate variable with is_ca set"))
+			}
+		}
+
 		switch cv.CVOptions.Type {
 		case "":
 			cv.CVOptions.Type = model.CVTypeUser
@@ -252,6 +346,428 @@ func validateVariablePreviousNames(variables model.Variables) validation.ErrorLi
 	return allErrs
 }
 
+// validateClusterScoped checks that the allowed cluster-scoped kinds are
+// known to be cluster-scoped
+func validateClusterScoped(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, kind := range roleManifest.Configuration.ClusterScoped {
+		if !util.StringInSlice(kind, model.ClusterScopedKinds) {
+			allErrs = append(allErrs, validation.NotSupported("configuration.cluster_scoped", kind, model.ClusterScopedKinds))
+		}
+	}
+
+	return allErrs
+}
+
+// validateInstanceGroupPreviousNames checks that the previous names of the
+// instance groups are not in use, and that only instance groups generating
+// stateful sets have them.
+func validateInstanceGroupPreviousNames(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	claimedBy := map[string]string{}
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		if len(instanceGroup.PreviousNames) == 0 {
+			continue
+		}
+		fieldName := fmt.Sprintf("instance_groups[%s].previous_names", instanceGroup.Name)
+		if instanceGroup.Type != model.RoleTypeBosh {
+			allErrs = append(allErrs, validation.Invalid(fieldName, instanceGroup.Type,
+				"Only instance groups of type bosh can have previous names"))
+			continue
+		}
+		for _, previousName := range instanceGroup.PreviousNames {
+			if roleManifest.LookupInstanceGroup(previousName) != nil {
+				allErrs = append(allErrs, validation.Invalid(fieldName, previousName,
+					"Previous name is also the name of an instance group"))
+				continue
+			}
+			if other, ok := claimedBy[previousName]; ok {
+				allErrs = append(allErrs, validation.Invalid(fieldName, previousName,
+					fmt.Sprintf("Previous name also claimed by '%s'", other)))
+				continue
+			}
+			claimedBy[previousName] = instanceGroup.Name
+		}
+	}
+
+	return allErrs
+}
+
+// validateInstanceGroupStemcells checks that the release stemcells are for
+// loaded releases, and that instance groups with jobs of releases with
+// different stemcells have a stemcell of their own
+func validateInstanceGroupStemcells(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	loaded := map[string]bool{}
+	for _, release := range roleManifest.LoadedReleases {
+		loaded[release.Name] = true
+	}
+	var releaseNames []string
+	for releaseName := range roleManifest.ReleaseStemcells {
+		releaseNames = append(releaseNames, releaseName)
+	}
+	sort.Strings(releaseNames)
+	for _, releaseName := range releaseNames {
+		field := fmt.Sprintf("release_stemcells[%s]", releaseName)
+		if !loaded[releaseName] {
+			allErrs = append(allErrs, validation.NotFound(field, releaseName))
+		} else if roleManifest.ReleaseStemcells[releaseName] == "" {
+			allErrs = append(allErrs, validation.Required(field, "The stemcell image of the release"))
+		}
+	}
+
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		if instanceGroup.Stemcell != "" {
+			continue
+		}
+		stemcells := instanceGroup.JobStemcells()
+		if len(stemcells) < 2 {
+			continue
+		}
+		for i, stemcell := range stemcells {
+			if stemcell == "" {
+				stemcells[i] = "the stemcell of the build"
+			}
+		}
+		allErrs = append(allErrs, validation.Required(
+			fmt.Sprintf("instance_groups[%s].stemcell", instanceGroup.Name),
+			fmt.Sprintf("The jobs are of releases with different stemcells (%s)", strings.Join(stemcells, ", "))))
+	}
+
+	return allErrs
+}
+
+// validateInstanceGroupMonitoring checks that the monitored ports of the
+// instance groups exist, as a single port of a job or a port of a sidecar
+func validateInstanceGroupMonitoring(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		monitoring := instanceGroup.Monitoring
+		if monitoring == nil {
+			continue
+		}
+		field := fmt.Sprintf("instance_groups[%s].monitoring", instanceGroup.Name)
+
+		if instanceGroup.Type != model.RoleTypeBosh {
+			allErrs = append(allErrs, validation.Invalid(field, instanceGroup.Type,
+				fmt.Sprintf("Only instance groups of type %s can be monitored", model.RoleTypeBosh)))
+			continue
+		}
+
+		found := false
+		for _, jobReference := range instanceGroup.JobReferences {
+			for _, port := range jobReference.ContainerProperties.BoshContainerization.Ports {
+				if port.Name != monitoring.Port {
+					continue
+				}
+				found = true
+				if port.Max > 1 {
+					allErrs = append(allErrs, validation.Invalid(field+".port", monitoring.Port,
+						"Ports with several instances cannot be monitored"))
+				}
+			}
+		}
+		if instanceGroup.Run != nil {
+			for _, sidecar := range instanceGroup.Run.Sidecars {
+				for _, port := range sidecar.Ports {
+					found = found || port.Name == monitoring.Port
+				}
+			}
+		}
+		if monitoring.Port == "" {
+			allErrs = append(allErrs, validation.Required(field+".port", ""))
+		} else if !found {
+			allErrs = append(allErrs, validation.NotFound(field+".port", monitoring.Port))
+		}
+
+		if !strings.HasPrefix(monitoring.MetricsPath(), "/") {
+			allErrs = append(allErrs, validation.Invalid(field+".path", monitoring.Path, "The path must be absolute"))
+		}
+		if monitoring.Interval != "" {
+			if _, err := time.ParseDuration(monitoring.Interval); err != nil {
+				allErrs = append(allErrs, validation.Invalid(field+".interval", monitoring.Interval,
+					"The interval must be a duration, e.g. 30s"))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// validateVariableDeprecations checks that removal versions of deprecated
+// variables are valid semantic versions, as they are compared against the
+// chart version at render time.
+func validateVariableDeprecations(variables model.Variables) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, cv := range variables {
+		deprecation := cv.CVOptions.Deprecated
+		if deprecation == nil {
+			continue
+		}
+		if deprecation.RemovalVersion == "" {
+			if deprecation.FailAfterRemoval {
+				allErrs = append(allErrs, validation.Required(
+					fmt.Sprintf("variables[%s].options.deprecated.removal_version", cv.Name),
+					"fail_after_removal requires a removal version"))
+			}
+			continue
+		}
+		if _, err := semver.NewVersion(deprecation.RemovalVersion); err != nil {
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("variables[%s].options.deprecated.removal_version", cv.Name),
+				deprecation.RemovalVersion, err.Error()))
+		}
+	}
+
+	return allErrs
+}
+
+// validateVariableValidations checks that the validation rules of variables
+// are well formed, and that the defaults of the variables follow them
+func validateVariableValidations(variables model.Variables) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, cv := range variables {
+		rules := cv.CVOptions.Validation
+		if rules == nil {
+			continue
+		}
+		field := fmt.Sprintf("variables[%s].options.validation", cv.Name)
+
+		if rules.Type != "" {
+			known := false
+			for _, validationType := range model.CVValidationTypes {
+				known = known || rules.Type == validationType
+			}
+			if !known {
+				allErrs = append(allErrs, validation.NotSupported(field+".type", rules.Type, model.CVValidationTypes))
+				continue
+			}
+		}
+		if (rules.Min != nil || rules.Max != nil) && rules.NumericType() == "" {
+			allErrs = append(allErrs, validation.Invalid(field+".type", rules.Type,
+				"min and max require a numeric type"))
+			continue
+		}
+		if rules.Min != nil && rules.Max != nil && *rules.Min > *rules.Max {
+			allErrs = append(allErrs, validation.Invalid(field+".min", *rules.Min,
+				fmt.Sprintf("Must not exceed the max of %v", *rules.Max)))
+			continue
+		}
+		if rules.Pattern != "" {
+			if _, err := regexp.Compile(rules.AnchoredPattern()); err != nil {
+				allErrs = append(allErrs, validation.Invalid(field+".pattern", rules.Pattern, err.Error()))
+				continue
+			}
+		}
+
+		if cv.CVOptions.Default != nil {
+			if err := rules.Check(cv.CVOptions.Default); err != nil {
+				allErrs = append(allErrs, validation.Invalid(
+					fmt.Sprintf("variables[%s].options.default", cv.Name), cv.CVOptions.Default,
+					fmt.Sprintf("The default %s", err)))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// validateExternalSecrets checks the external secret store of the role
+// manifest, and that only secrets are read from it
+func validateExternalSecrets(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if external := roleManifest.ExternalSecrets; external != nil {
+		switch external.Type {
+		case model.ExternalSecretsTypeExternalSecret:
+			if external.Store == "" {
+				allErrs = append(allErrs, validation.Required("external_secrets.store",
+					"The secret store of the ExternalSecret"))
+			}
+			if external.StoreKind != "" && external.StoreKind != "SecretStore" && external.StoreKind != "ClusterSecretStore" {
+				allErrs = append(allErrs, validation.NotSupported("external_secrets.store_kind",
+					external.StoreKind, []string{"SecretStore", "ClusterSecretStore"}))
+			}
+		case model.ExternalSecretsTypeSecretProviderClass:
+			providers := []string{model.ExternalSecretsProviderAWS, model.ExternalSecretsProviderAzure}
+			if !util.StringInSlice(external.Provider, providers) {
+				allErrs = append(allErrs, validation.NotSupported("external_secrets.provider", external.Provider, providers))
+			}
+		default:
+			allErrs = append(allErrs, validation.NotSupported("external_secrets.type", external.Type, []string{
+				string(model.ExternalSecretsTypeExternalSecret),
+				string(model.ExternalSecretsTypeSecretProviderClass),
+			}))
+		}
+	}
+
+	for _, cv := range roleManifest.Variables {
+		if cv.CVOptions.ExternalKey == "" {
+			continue
+		}
+		field := fmt.Sprintf("variables[%s].options.external_key", cv.Name)
+		if roleManifest.ExternalSecrets == nil {
+			allErrs = append(allErrs, validation.Required("external_secrets",
+				fmt.Sprintf("The external secret store of %s", field)))
+		}
+		if !cv.CVOptions.Secret {
+			allErrs = append(allErrs, validation.Invalid(field, cv.CVOptions.ExternalKey,
+				"Only secrets can be read from an external secret store"))
+		}
+		if cv.CVOptions.File != "" {
+			allErrs = append(allErrs, validation.Invalid(field, cv.CVOptions.ExternalKey,
+				"Secrets read from a file can't be read from an external secret store"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateVariableFiles checks that variables with a value from a file are
+// secrets without default or generator. Kubernetes limits the size of a
+// secret to 1 MiB, and the values reach the jobs through environment
+// variables, which can't hold NUL bytes.
+func validateVariableFiles(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, cv := range roleManifest.Variables {
+		if cv.CVOptions.File == "" {
+			continue
+		}
+		field := fmt.Sprintf("variables[%s].options.file", cv.Name)
+		if errs := validateSchemaFeature(roleManifest, field, model.SchemaFeatureVariableFiles); len(errs) != 0 {
+			allErrs = append(allErrs, errs...)
+			continue
+		}
+		if !cv.CVOptions.Secret {
+			allErrs = append(allErrs, validation.Invalid(field, cv.CVOptions.File,
+				"Only secrets can be read from a file"))
+		}
+		if cv.Type != "" {
+			allErrs = append(allErrs, validation.Invalid(field, cv.CVOptions.File,
+				fmt.Sprintf("Secrets read from a file can't have the generator type %s", cv.Type)))
+		}
+		if cv.CVOptions.Default != nil {
+			allErrs = append(allErrs, validation.Invalid(field, cv.CVOptions.File,
+				"Secrets read from a file can't have a default"))
+		}
+		if len(cv.CVOptions.FileContents) > maxSecretSize {
+			allErrs = append(allErrs, validation.Invalid(field, cv.CVOptions.File,
+				fmt.Sprintf("File is larger than the maximum secret size of %d bytes", maxSecretSize)))
+		}
+		if bytes.IndexByte(cv.CVOptions.FileContents, 0) >= 0 {
+			allErrs = append(allErrs, validation.Invalid(field, cv.CVOptions.File,
+				"File contains NUL bytes, which environment variables can't hold"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateVariableURLTemplates checks that variables with a url template are
+// plain user variables without default, and that the host of the template
+// names a service of the role manifest.
+func validateVariableURLTemplates(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	var services map[string]bool
+	for _, cv := range roleManifest.Variables {
+		if cv.CVOptions.URLTemplate == "" {
+			continue
+		}
+		field := fmt.Sprintf("variables[%s].options.url_template", cv.Name)
+		if cv.CVOptions.Secret || cv.CVOptions.Type != model.CVTypeUser || cv.CVOptions.ImageName {
+			allErrs = append(allErrs, validation.Invalid(field, cv.CVOptions.URLTemplate,
+				"Only user variables that are neither secrets nor image names can have a url template"))
+		}
+		if cv.CVOptions.Default != nil {
+			allErrs = append(allErrs, validation.Invalid(field, cv.CVOptions.URLTemplate,
+				"Variables with a url template can't have a default"))
+		}
+		_, service, _, err := model.ParseURLTemplate(cv.CVOptions.URLTemplate)
+		if err != nil {
+			allErrs = append(allErrs, validation.Invalid(field, cv.CVOptions.URLTemplate, err.Error()))
+			continue
+		}
+		if services == nil {
+			services = serviceNames(roleManifest)
+		}
+		if !services[service] {
+			allErrs = append(allErrs, validation.Invalid(field, cv.CVOptions.URLTemplate,
+				fmt.Sprintf("No instance group has a service named %s", service)))
+		}
+	}
+
+	return allErrs
+}
+
+// serviceNames returns the names of all services the instance groups may
+// have: the headless service of stateful sets, and the services of the jobs.
+func serviceNames(roleManifest *model.RoleManifest) map[string]bool {
+	services := map[string]bool{}
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		services[instanceGroup.Name+"-set"] = true
+		for _, jobReference := range instanceGroup.JobReferences {
+			name := jobReference.ContainerProperties.BoshContainerization.ServiceName
+			if name == "" {
+				name = util.ConvertNameToKey(instanceGroup.Name + "-" + jobReference.Name)
+			}
+			for _, suffix := range []string{"", "-set", "-public"} {
+				services[name+suffix] = true
+			}
+		}
+	}
+	return services
+}
+
+// validateSchemaVersion checks that the schema version of the role manifest
+// is supported by this version of fissile
+func validateSchemaVersion(roleManifest *model.RoleManifest) validation.ErrorList {
+	version := roleManifest.SchemaVersion
+	if version < 0 {
+		return validation.ErrorList{validation.Invalid("schema_version", version,
+			"Expected a non-negative schema version")}
+	}
+	if version > model.CurrentSchemaVersion {
+		message := fmt.Sprintf("This fissile supports role manifest schema versions up to %d; the role manifest requires a newer fissile",
+			model.CurrentSchemaVersion)
+		if roleManifest.MinimumFissileVersion != "" {
+			message += fmt.Sprintf(" (%s or later)", roleManifest.MinimumFissileVersion)
+		}
+		return validation.ErrorList{validation.Invalid("schema_version", version, message)}
+	}
+	return nil
+}
+
+// validateSchemaFeature reports the use of a feature that is not active for
+// the schema version of the role manifest
+func validateSchemaFeature(roleManifest *model.RoleManifest, field string, feature model.SchemaFeature) validation.ErrorList {
+	if roleManifest.Supports(feature) {
+		return nil
+	}
+	return validation.ErrorList{validation.Forbidden(field,
+		fmt.Sprintf("%s requires schema_version %d or later", feature, model.SchemaFeatures[feature]))}
+}
+
+// validateMinimumFissileVersion checks that the minimum fissile version of
+// the role manifest is a valid semantic version
+func validateMinimumFissileVersion(roleManifest *model.RoleManifest) validation.ErrorList {
+	if roleManifest.MinimumFissileVersion == "" {
+		return nil
+	}
+	if _, err := semver.NewVersion(roleManifest.MinimumFissileVersion); err != nil {
+		return validation.ErrorList{validation.Invalid("minimum_fissile_version",
+			roleManifest.MinimumFissileVersion, err.Error())}
+	}
+	return nil
+}
+
 func validateServiceAccounts(roleManifest *model.RoleManifest) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 	for accountName, accountInfo := range roleManifest.Configuration.Authorization.Accounts {
@@ -266,6 +782,81 @@ func validateServiceAccounts(roleManifest *model.RoleManifest) validation.ErrorL
 	return allErrs
 }
 
+// validateLinkProperties checks that the properties the job templates look up
+// on their links are exported by the providing jobs; other properties are
+// not available at runtime.
+func validateLinkProperties(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		for _, jobReference := range instanceGroup.JobReferences {
+			used := map[string]map[string]bool{}
+			for _, template := range jobReference.Templates {
+				for linkName, properties := range template.LinkProperties() {
+					if used[linkName] == nil {
+						used[linkName] = map[string]bool{}
+					}
+					for _, property := range properties {
+						used[linkName][property] = true
+					}
+				}
+			}
+
+			var linkNames []string
+			for linkName := range used {
+				linkNames = append(linkNames, linkName)
+			}
+			sort.Strings(linkNames)
+
+			for _, linkName := range linkNames {
+				consumer, ok := jobReference.ResolvedConsumes[linkName]
+				if !ok {
+					// Unresolved optional links are not available at all
+					continue
+				}
+				providerInstanceGroup := roleManifest.LookupInstanceGroup(consumer.RoleName)
+				if providerInstanceGroup == nil {
+					continue
+				}
+				providerJob := providerInstanceGroup.LookupJob(consumer.JobName)
+				if providerJob == nil {
+					continue
+				}
+				exported := providerJob.Job.AvailableProviders[consumer.Name].Properties
+
+				var properties []string
+				for property := range used[linkName] {
+					properties = append(properties, property)
+				}
+				sort.Strings(properties)
+
+				for _, property := range properties {
+					if !isExportedProperty(property, exported) {
+						allErrs = append(allErrs, validation.Invalid(
+							fmt.Sprintf("instance_groups[%s].jobs[%s].consumes[%s]", instanceGroup.Name, jobReference.Name, linkName),
+							property,
+							fmt.Sprintf("Property is not exported by job %s of instance group %s", consumer.JobName, consumer.RoleName)))
+					}
+				}
+			}
+		}
+	}
+	return allErrs
+}
+
+// isExportedProperty returns whether a property looked up on a link is
+// available from the exported properties; this includes the parents and the
+// children of exported properties.
+func isExportedProperty(property string, exported []string) bool {
+	for _, exportedProperty := range exported {
+		if property == exportedProperty ||
+			strings.HasPrefix(property, exportedProperty+".") ||
+			strings.HasPrefix(exportedProperty, property+".") {
+			return true
+		}
+	}
+	return false
+}
+
 func validateUnusedColocatedContainerRoles(roleManifest *model.RoleManifest) validation.ErrorList {
 	counterMap := map[string]int{}
 	for _, instanceGroup := range roleManifest.InstanceGroups {
@@ -415,6 +1006,111 @@ func validateColocatedContainerVolumeShares(roleManifest *model.RoleManifest) va
 	return allErrs
 }
 
+// validateColocatedContainerSysctls tests that colocated containers do not
+// set the sysctls of the pod to different values than their main instance
+// group, as sysctls are shared by all containers of a pod.
+func validateColocatedContainerSysctls(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		if instanceGroup.Run == nil || len(instanceGroup.ColocatedContainers()) == 0 {
+			continue
+		}
+		values := map[string]string{}
+		for _, sysctl := range instanceGroup.Run.Sysctls {
+			values[sysctl.Name] = sysctl.Value
+		}
+		for _, colocatedInstanceGroup := range instanceGroup.GetColocatedRoles() {
+			if colocatedInstanceGroup.Run == nil {
+				continue
+			}
+			for _, sysctl := range colocatedInstanceGroup.Run.Sysctls {
+				if value, ok := values[sysctl.Name]; !ok {
+					values[sysctl.Name] = sysctl.Value
+				} else if value != sysctl.Value {
+					allErrs = append(allErrs, validation.Invalid(
+						fmt.Sprintf("instance_group[%s]", colocatedInstanceGroup.Name),
+						sysctl.Name,
+						fmt.Sprintf("colocated instance group sets sysctl to %q, which conflicts with %q set for the pod of %s",
+							sysctl.Value, value, instanceGroup.Name)))
+				}
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// validateColocatedContainerSharedSockets tests that the shared sockets of a
+// pod are declared only once, by either the main instance group or one of its
+// colocated containers, and that they don't conflict with the other volumes
+// mounted into the containers of the pod.
+func validateColocatedContainerSharedSockets(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	reported := map[string]bool{}
+	report := func(err *validation.Error) {
+		// Colocated containers may be part of several pods
+		if !reported[err.Error()] {
+			reported[err.Error()] = true
+			allErrs = append(allErrs, err)
+		}
+	}
+
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		if instanceGroup.Run == nil || instanceGroup.Type == model.RoleTypeColocatedContainer {
+			continue
+		}
+		members := append(model.InstanceGroups{instanceGroup}, instanceGroup.GetColocatedRoles()...)
+
+		owners := map[string]string{}
+		for _, member := range members {
+			if member.Run == nil {
+				continue
+			}
+			for _, volume := range member.Run.Volumes {
+				if volume.Type != model.VolumeTypeSharedSocket {
+					continue
+				}
+				field := fmt.Sprintf("instance_group[%s]", member.Name)
+				if owner, ok := owners[volume.Tag]; ok && owner != member.Name {
+					report(validation.Invalid(field, volume.Tag,
+						fmt.Sprintf("shared socket is already declared by instance group %s, and mounted into all containers of the pod of %s", owner, instanceGroup.Name)))
+					continue
+				}
+				owners[volume.Tag] = member.Name
+				if len(members) == 1 {
+					report(validation.Invalid(field, volume.Tag,
+						"shared sockets can only be used by instance groups with colocated containers"))
+				}
+			}
+		}
+
+		for _, member := range members {
+			if member.Run == nil {
+				continue
+			}
+			for _, volume := range member.Run.Volumes {
+				if volume.Type == model.VolumeTypeSharedSocket {
+					continue
+				}
+				for _, socket := range instanceGroup.PodSharedSockets() {
+					field := fmt.Sprintf("instance_group[%s]", owners[socket.Tag])
+					if volume.Tag == socket.Tag {
+						report(validation.Invalid(field, socket.Tag,
+							fmt.Sprintf("shared socket has the same tag as a volume of instance group %s", member.Name)))
+					}
+					if path.Clean(volume.Path) == path.Clean(socket.Path) {
+						report(validation.Invalid(field, socket.Tag,
+							fmt.Sprintf("shared socket path %s is also used by volume %s of instance group %s", socket.Path, volume.Tag, member.Name)))
+					}
+				}
+			}
+		}
+	}
+
+	return allErrs
+}
+
 // validateVariableDescriptions tests whether all variables have descriptions
 func validateVariableDescriptions(roleManifest *model.RoleManifest) validation.ErrorList {
 	allErrs := validation.ErrorList{}
@@ -503,6 +1199,23 @@ func validateScripts(roleManifest *model.RoleManifest, validationOptions model.R
 	return allErrs
 }
 
+// validateRuntimeScripts tests that the runtime scripts directory, if any,
+// is a directory next to the role manifest.
+func validateRuntimeScripts(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	if roleManifest.RuntimeScripts == "" {
+		return allErrs
+	}
+	if filepath.IsAbs(roleManifest.RuntimeScripts) {
+		return append(allErrs, validation.Invalid("runtime_scripts", roleManifest.RuntimeScripts,
+			"Path must be relative to the role manifest"))
+	}
+	if _, err := roleManifest.GetRuntimeScriptPaths(); err != nil {
+		allErrs = append(allErrs, validation.Invalid("runtime_scripts", roleManifest.RuntimeScripts, err.Error()))
+	}
+	return allErrs
+}
+
 // validateHealthProbe reports a instance group with conflicting health checks
 // in the specified probe.
 func validateHealthProbe(instanceGroup model.InstanceGroup, probeName string, probe *model.HealthProbe) validation.ErrorList {
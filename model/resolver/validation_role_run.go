@@ -2,7 +2,9 @@ package resolver
 
 import (
 	"fmt"
+	"path"
 	"regexp"
+	"time"
 
 	"code.cloudfoundry.org/fissile/model"
 	"code.cloudfoundry.org/fissile/validation"
@@ -15,10 +17,14 @@ import (
 func validateRoleRun(instanceGroup *model.InstanceGroup, roleManifest *model.RoleManifest) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 
-	allErrs = append(allErrs, normalizeFlightStage(*instanceGroup)...)
-	allErrs = append(allErrs, validateHealthCheck(*instanceGroup)...)
-	allErrs = append(allErrs, validateRoleMemory(*instanceGroup)...)
-	allErrs = append(allErrs, validateRoleCPU(*instanceGroup)...)
+	allErrs = append(allErrs, normalizeFlightStage(*instanceGroup).WithCode(validation.CodeFlightStage)...)
+	allErrs = append(allErrs, validateHealthCheck(*instanceGroup, roleManifest).WithCode(validation.CodeHealthCheck)...)
+	allErrs = append(allErrs, validateRoleMemory(*instanceGroup).WithCode(validation.CodeMemory)...)
+	allErrs = append(allErrs, validateRoleCPU(*instanceGroup).WithCode(validation.CodeCPU)...)
+	allErrs = append(allErrs, validateBackup(*instanceGroup).WithCode(validation.CodeBackup)...)
+	allErrs = append(allErrs, validateJobSettings(*instanceGroup).WithCode(validation.CodeJobSettings)...)
+	allErrs = append(allErrs, validateTerminationGracePeriod(*instanceGroup).WithCode(validation.CodeTerminationGracePeriod)...)
+	allErrs = append(allErrs, validateSidecars(*instanceGroup).WithCode(validation.CodeSidecars)...)
 
 	if instanceGroup.Run.ServiceAccount != "" {
 		accountName := instanceGroup.Run.ServiceAccount
@@ -38,6 +44,7 @@ func validateRoleRun(instanceGroup *model.InstanceGroup, roleManifest *model.Rol
 		case model.VolumeTypeHost:
 		case model.VolumeTypeNone:
 		case model.VolumeTypeEmptyDir:
+		case model.VolumeTypeSharedSocket:
 		default:
 			allErrs = append(allErrs, validation.Invalid(
 				fmt.Sprintf("instance_groups[%s].run.volumes[%s]", instanceGroup.Name, volume.Tag),
@@ -46,9 +53,72 @@ func validateRoleRun(instanceGroup *model.InstanceGroup, roleManifest *model.Rol
 		}
 	}
 
+	for _, writablePath := range instanceGroup.Run.WritablePaths {
+		if !path.IsAbs(writablePath.Path) || path.Clean(writablePath.Path) == "/" {
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("instance_groups[%s].run.writable-paths", instanceGroup.Name),
+				writablePath.Path,
+				"Writable paths must be absolute paths other than /"))
+		}
+	}
+
+	if downwardAPIPath := instanceGroup.Run.DownwardAPIPath; downwardAPIPath != "" {
+		if !path.IsAbs(downwardAPIPath) || path.Clean(downwardAPIPath) == "/" {
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("instance_groups[%s].run.downward-api-path", instanceGroup.Name),
+				downwardAPIPath,
+				"The downward API path must be an absolute path other than /"))
+		}
+	}
+
+	audiences := map[string]bool{}
+	for _, token := range instanceGroup.Run.ServiceAccountTokens {
+		field := fmt.Sprintf("instance_groups[%s].run.service-account-tokens", instanceGroup.Name)
+		if !path.IsAbs(token.Path) || path.Clean(token.Path) == "/" {
+			allErrs = append(allErrs, validation.Invalid(field, token.Path,
+				"Service account tokens must be mounted at absolute paths other than /"))
+		}
+		if token.Audience == "" {
+			allErrs = append(allErrs, validation.Required(fmt.Sprintf("%s[%s].audience", field, token.Path), ""))
+		} else if audiences[token.Audience] {
+			allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("%s[%s].audience", field, token.Path), token.Audience,
+				"Only one service account token per audience can be mounted"))
+		}
+		audiences[token.Audience] = true
+		if token.ExpirationSeconds != 0 && token.ExpirationSeconds < model.MinServiceAccountTokenExpirationSeconds {
+			allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("%s[%s].expirationSeconds", field, token.Path), token.ExpirationSeconds,
+				fmt.Sprintf("Service account tokens must expire after at least %d seconds", model.MinServiceAccountTokenExpirationSeconds)))
+		}
+	}
+
+	for _, capability := range instanceGroup.Run.DropCapabilities {
+		if !model.IsKnownCapability(capability) {
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("instance_groups[%s].run.drop-capabilities", instanceGroup.Name),
+				capability,
+				"Unknown capability; use the name without the CAP_ prefix, or ALL"))
+		}
+	}
+
+	for _, sysctl := range instanceGroup.Run.Sysctls {
+		field := fmt.Sprintf("instance_groups[%s].run.sysctls", instanceGroup.Name)
+		if !sysctlNamePattern.MatchString(sysctl.Name) {
+			allErrs = append(allErrs, validation.Invalid(field, sysctl.Name, "Invalid sysctl name"))
+		} else if !sysctl.IsNamespaced() {
+			allErrs = append(allErrs, validation.Invalid(field, sysctl.Name,
+				"Only namespaced sysctls (kernel.shm*, kernel.msg*, kernel.sem, fs.mqueue.*, net.*) can be set for a pod"))
+		}
+		if sysctl.Value == "" {
+			allErrs = append(allErrs, validation.Required(fmt.Sprintf("%s[%s].value", field, sysctl.Name), ""))
+		}
+	}
+
 	return allErrs
 }
 
+// sysctlNamePattern matches valid sysctl names, as accepted by Kubernetes
+var sysctlNamePattern = regexp.MustCompile(`^([a-z0-9]([-_a-z0-9]*[a-z0-9])?[./])*[a-z0-9]([-_a-z0-9]*[a-z0-9])?$`)
+
 func validateJobReferences(instanceGroup *model.InstanceGroup) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 	for _, job := range instanceGroup.JobReferences {
@@ -84,9 +154,255 @@ func normalizeFlightStage(instanceGroup model.InstanceGroup) validation.ErrorLis
 	return allErrs
 }
 
+// validateBackup reports invalid Velero backup settings of an instance group
+func validateBackup(instanceGroup model.InstanceGroup) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	backup := instanceGroup.Run.Backup
+	if backup == nil {
+		return allErrs
+	}
+	field := fmt.Sprintf("instance_groups[%s].run.backup", instanceGroup.Name)
+
+	if instanceGroup.Type != model.RoleTypeBosh {
+		allErrs = append(allErrs, validation.Invalid(field, instanceGroup.Type,
+			fmt.Sprintf("Only instance groups of type %s can be backed up", model.RoleTypeBosh)))
+	}
+
+	for _, tag := range backup.Volumes {
+		found := false
+		for _, volume := range instanceGroup.Run.Volumes {
+			switch volume.Type {
+			case model.VolumeTypePersistent, model.VolumeTypeShared, model.VolumeTypeEmptyDir:
+				found = found || volume.Tag == tag
+			}
+		}
+		if !found {
+			allErrs = append(allErrs, validation.NotFound(fmt.Sprintf("%s.volumes", field), tag))
+		}
+	}
+
+	for _, jobName := range backup.Jobs {
+		found := false
+		for _, job := range instanceGroup.JobReferences {
+			found = found || job.Name == jobName
+		}
+		if !found {
+			allErrs = append(allErrs, validation.NotFound(fmt.Sprintf("%s.jobs", field), jobName))
+		}
+	}
+
+	if artifactPath := backup.ArtifactPath; artifactPath != "" {
+		if !path.IsAbs(artifactPath) || path.Clean(artifactPath) == "/" {
+			allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("%s.artifact-path", field), artifactPath,
+				"The artifact path must be an absolute path other than /"))
+		}
+	}
+
+	if backup.Timeout != "" {
+		if _, err := time.ParseDuration(backup.Timeout); err != nil {
+			allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("%s.timeout", field), backup.Timeout,
+				"The timeout must be a duration, e.g. 5m"))
+		}
+	}
+
+	switch backup.OnError {
+	case "", model.BackupOnErrorFail, model.BackupOnErrorContinue:
+	default:
+		allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("%s.on-error", field), backup.OnError,
+			fmt.Sprintf("Must be %s or %s", model.BackupOnErrorFail, model.BackupOnErrorContinue)))
+	}
+
+	return allErrs
+}
+
+// validateTerminationGracePeriod reports invalid termination grace periods,
+// and drain timeouts of jobs not fitting into the part of the grace period of
+// their instance group the pre-stop hook waits for them
+func validateTerminationGracePeriod(instanceGroup model.InstanceGroup) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	gracePeriod := instanceGroup.Run.GracePeriodSeconds()
+	if gracePeriod < 1 {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("instance_groups[%s].run.termination-grace-period-seconds", instanceGroup.Name),
+			gracePeriod, "Must be at least 1"))
+	}
+
+	// The pre-stop hook stops waiting for the drain scripts at the
+	// PRE_STOP_DEADLINE, leaving a tenth of the grace period for stopping the
+	// processes
+	drainDeadline := gracePeriod - gracePeriod/10
+
+	for _, jobReference := range instanceGroup.JobReferences {
+		run := jobReference.ContainerProperties.BoshContainerization.Run
+		if run == nil || run.DrainTimeoutSeconds == nil {
+			continue
+		}
+		field := fmt.Sprintf("instance_groups[%s].jobs[%s].properties.bosh_containerization.run.drain-timeout-seconds",
+			instanceGroup.Name, jobReference.Name)
+		drainTimeout := *run.DrainTimeoutSeconds
+		if drainTimeout < 0 {
+			allErrs = append(allErrs, validation.Invalid(field, drainTimeout, "Must be at least 0"))
+		} else if drainTimeout > drainDeadline {
+			allErrs = append(allErrs, validation.Invalid(field, drainTimeout,
+				fmt.Sprintf("The drain script doesn't fit into the %d seconds the pre-stop hook waits for it (90%% of the termination grace period of %d seconds); raise run.termination-grace-period-seconds",
+					drainDeadline, gracePeriod)))
+		}
+	}
+	return allErrs
+}
+
+// sidecarNamePattern matches valid container names
+var sidecarNamePattern = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
+// validateSidecars reports invalid sidecars of an instance group; their names
+// must be unique within the pod, and they can only mount the volumes of the
+// instance group
+func validateSidecars(instanceGroup model.InstanceGroup) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	if len(instanceGroup.Run.Sidecars) == 0 {
+		return allErrs
+	}
+	field := fmt.Sprintf("instance_groups[%s].run.sidecars", instanceGroup.Name)
+
+	if instanceGroup.Type != model.RoleTypeBosh {
+		allErrs = append(allErrs, validation.Invalid(field, instanceGroup.Type,
+			fmt.Sprintf("Only instance groups of type %s can have sidecars", model.RoleTypeBosh)))
+	}
+
+	containers := map[string]bool{instanceGroup.Name: true}
+	for _, name := range instanceGroup.ColocatedContainers() {
+		containers[name] = true
+	}
+	volumes := map[string]model.VolumeType{}
+	for _, volume := range instanceGroup.Run.Volumes {
+		volumes[volume.Tag] = volume.Type
+	}
+
+	for _, sidecar := range instanceGroup.Run.Sidecars {
+		sidecarField := fmt.Sprintf("%s[%s]", field, sidecar.Name)
+		if !sidecarNamePattern.MatchString(sidecar.Name) {
+			allErrs = append(allErrs, validation.Invalid(sidecarField+".name", sidecar.Name,
+				"Sidecar names must consist of lower case alphanumeric characters and dashes"))
+		} else if containers[sidecar.Name] {
+			allErrs = append(allErrs, validation.Duplicate(sidecarField+".name", sidecar.Name))
+		}
+		containers[sidecar.Name] = true
+
+		if sidecar.Image == "" {
+			allErrs = append(allErrs, validation.Required(sidecarField+".image", ""))
+		}
+
+		ports := map[string]bool{}
+		for _, port := range sidecar.Ports {
+			portField := fmt.Sprintf("%s.ports[%s]", sidecarField, port.Name)
+			if port.Name == "" {
+				allErrs = append(allErrs, validation.Required(portField+".name", ""))
+			} else if ports[port.Name] {
+				allErrs = append(allErrs, validation.Duplicate(portField+".name", port.Name))
+			}
+			ports[port.Name] = true
+			if err := validation.IsValidPortNum(port.Port); err != nil {
+				allErrs = append(allErrs, validation.Invalid(portField+".port", port.Port, err.Error()))
+			}
+			allErrs = append(allErrs, validation.ValidateProtocol(port.Protocol, portField+".protocol")...)
+		}
+
+		for _, env := range sidecar.Env {
+			if env.Name == "" {
+				allErrs = append(allErrs, validation.Required(sidecarField+".env.name", ""))
+			}
+		}
+
+		for _, mount := range sidecar.VolumeMounts {
+			mountField := fmt.Sprintf("%s.volume-mounts[%s]", sidecarField, mount.Tag)
+			switch volumes[mount.Tag] {
+			case "", model.VolumeTypeNone:
+				allErrs = append(allErrs, validation.NotFound(mountField+".tag", mount.Tag))
+			case model.VolumeTypeSharedSocket:
+				allErrs = append(allErrs, validation.Invalid(mountField+".tag", mount.Tag,
+					"Shared sockets are mounted into all containers of the pod"))
+			}
+			if !path.IsAbs(mount.Path) {
+				allErrs = append(allErrs, validation.Invalid(mountField+".path", mount.Path,
+					"Volumes must be mounted at absolute paths"))
+			}
+		}
+
+		allErrs = append(allErrs, validateSidecarResources(sidecarField, sidecar)...)
+	}
+	return allErrs
+}
+
+// validateSidecarResources reports negative resources of a sidecar
+func validateSidecarResources(field string, sidecar *model.RoleRunSidecar) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	if memory := sidecar.Memory; memory != nil {
+		if memory.Request != nil {
+			allErrs = append(allErrs, validation.ValidateNonnegativeField(*memory.Request, field+".mem.request")...)
+		}
+		if memory.Limit != nil {
+			allErrs = append(allErrs, validation.ValidateNonnegativeField(*memory.Limit, field+".mem.limit")...)
+		}
+	}
+	if cpu := sidecar.CPU; cpu != nil {
+		if cpu.Request != nil {
+			allErrs = append(allErrs, validation.ValidateNonnegativeFieldFloat(*cpu.Request, field+".cpu.request")...)
+		}
+		if cpu.Limit != nil {
+			allErrs = append(allErrs, validation.ValidateNonnegativeFieldFloat(*cpu.Limit, field+".cpu.limit")...)
+		}
+	}
+	return allErrs
+}
+
+// validateJobSettings reports invalid Kubernetes job settings of an instance
+// group
+func validateJobSettings(instanceGroup model.InstanceGroup) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	job := instanceGroup.Run.Job
+	if job == nil {
+		return allErrs
+	}
+	field := fmt.Sprintf("instance_groups[%s].run.job", instanceGroup.Name)
+
+	if instanceGroup.Type != model.RoleTypeBoshTask {
+		allErrs = append(allErrs, validation.Invalid(field, instanceGroup.Type,
+			fmt.Sprintf("Only instance groups of type %s run as jobs", model.RoleTypeBoshTask)))
+	}
+
+	for _, setting := range []struct {
+		name  string
+		value *int
+		min   int
+	}{
+		{"active-deadline-seconds", job.ActiveDeadlineSeconds, 1},
+		{"backoff-limit", job.BackoffLimit, 0},
+		{"ttl-seconds-after-finished", job.TTLSecondsAfterFinished, 0},
+	} {
+		if setting.value != nil && *setting.value < setting.min {
+			allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("%s.%s", field, setting.name), *setting.value,
+				fmt.Sprintf("Must be at least %d", setting.min)))
+		}
+	}
+
+	if instanceGroup.HasTag(model.RoleTagStopOnFailure) && (job.BackoffLimit != nil || job.TTLSecondsAfterFinished != nil) {
+		allErrs = append(allErrs, validation.Invalid(field, model.RoleTagStopOnFailure,
+			"Instance groups tagged stop-on-failure run as pods, which have neither a backoff-limit nor a ttl-seconds-after-finished"))
+	}
+
+	switch job.RestartPolicy {
+	case "", model.RestartPolicyNever, model.RestartPolicyOnFailure:
+	default:
+		allErrs = append(allErrs, validation.Invalid(fmt.Sprintf("%s.restart-policy", field), job.RestartPolicy,
+			fmt.Sprintf("Must be %s or %s", model.RestartPolicyNever, model.RestartPolicyOnFailure)))
+	}
+
+	return allErrs
+}
+
 // validateHealthCheck reports a instance group with conflicting health checks
 // in its probes
-func validateHealthCheck(instanceGroup model.InstanceGroup) validation.ErrorList {
+func validateHealthCheck(instanceGroup model.InstanceGroup, roleManifest *model.RoleManifest) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 
 	if instanceGroup.Run.HealthCheck == nil {
@@ -94,6 +410,15 @@ func validateHealthCheck(instanceGroup model.InstanceGroup) validation.ErrorList
 		return allErrs
 	}
 
+	var probeProfile *model.ProbeProfile
+	if profile := instanceGroup.Run.HealthCheck.Profile; profile != "" {
+		var ok bool
+		if probeProfile, ok = roleManifest.Configuration.ProbeProfiles[profile]; !ok {
+			allErrs = append(allErrs, validation.NotFound(
+				fmt.Sprintf("instance_groups[%s].run.healthcheck.profile", instanceGroup.Name), profile))
+		}
+	}
+
 	// Ensure that we don't have conflicting health checks
 	if instanceGroup.Run.HealthCheck.Readiness != nil {
 		allErrs = append(allErrs,
@@ -104,6 +429,14 @@ func validateHealthCheck(instanceGroup model.InstanceGroup) validation.ErrorList
 		allErrs = append(allErrs,
 			validateHealthProbe(instanceGroup, "liveness",
 				instanceGroup.Run.HealthCheck.Liveness)...)
+
+		// Kubernetes only accepts a success threshold of 1 for liveness probes
+		successThreshold := instanceGroup.Run.HealthCheck.Liveness.WithProfile(probeProfile).SuccessThreshold
+		if successThreshold > 1 {
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("instance_groups[%s].run.healthcheck.liveness.success_threshold", instanceGroup.Name),
+				successThreshold, "Liveness probes require a success threshold of 1"))
+		}
 	}
 
 	return allErrs
@@ -195,18 +528,50 @@ func validateExposedPorts(name, jobName string, exposedPorts *model.JobExposedPo
 		allErrs = append(allErrs, validation.Invalid(fieldName+".name", exposedPorts.Name,
 			"port names must be lowercase words separated by hyphens"))
 	}
-	if len(exposedPorts.Name) > 15 {
-		allErrs = append(allErrs, validation.Invalid(fieldName+".name", exposedPorts.Name,
+	// The limits apply to the name including the application protocol prefix
+	if len(exposedPorts.KubeName()) > 15 {
+		allErrs = append(allErrs, validation.Invalid(fieldName+".name", exposedPorts.KubeName(),
 			"port name must be no more than 15 characters"))
-	} else if len(exposedPorts.Name) > 9 && exposedPorts.CountIsConfigurable {
+	} else if len(exposedPorts.KubeName()) > 9 && exposedPorts.CountIsConfigurable {
 		// need to be able to append "-12345" and still be 15 chars or less
-		allErrs = append(allErrs, validation.Invalid(fieldName+".name", exposedPorts.Name,
+		allErrs = append(allErrs, validation.Invalid(fieldName+".name", exposedPorts.KubeName(),
 			"user configurable port name must be no more than 9 characters"))
 	}
 
 	// Validate Protocol
 	allErrs = append(allErrs, validation.ValidateProtocol(exposedPorts.Protocol, fieldName+".protocol")...)
 
+	// Validate AppProtocol
+	if exposedPorts.AppProtocol != "" {
+		supported := false
+		for _, appProtocol := range model.AppProtocols {
+			if exposedPorts.AppProtocol == appProtocol {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			allErrs = append(allErrs, validation.NotSupported(fieldName+".app-protocol",
+				exposedPorts.AppProtocol, model.AppProtocols))
+		} else if exposedPorts.Protocol != validation.TCP {
+			allErrs = append(allErrs, validation.Invalid(fieldName+".app-protocol",
+				exposedPorts.AppProtocol, "application protocols require the TCP protocol"))
+		}
+	}
+
+	// Validate ExternalDNS
+	if exposedPorts.ExternalDNS != nil {
+		if !exposedPorts.Public {
+			allErrs = append(allErrs, validation.Invalid(fieldName+".external-dns", exposedPorts.ExternalDNS.Hostname,
+				"external-dns entries require a public port"))
+		}
+		if exposedPorts.ExternalDNS.Hostname == "" {
+			allErrs = append(allErrs, validation.Required(fieldName+".external-dns.hostname", ""))
+		}
+		allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(exposedPorts.ExternalDNS.TTL),
+			fieldName+".external-dns.ttl")...)
+	}
+
 	// Validate Internal
 	firstPort, lastPort, errs := validation.ValidatePortRange(exposedPorts.Internal, fieldName+".internal")
 	allErrs = append(allErrs, errs...)
@@ -250,3 +615,86 @@ func validateExposedPorts(name, jobName string, exposedPorts *model.JobExposedPo
 
 	return allErrs
 }
+
+// replicateZones replaces each instance group with zones by one replica per
+// zone, see model.InstanceGroup.ZoneReplica, and validates the zones.
+func replicateZones(roleManifest *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	names := map[string]bool{}
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		names[instanceGroup.Name] = true
+	}
+
+	var instanceGroups model.InstanceGroups
+	for _, instanceGroup := range roleManifest.InstanceGroups {
+		if len(instanceGroup.Zones) == 0 {
+			instanceGroups = append(instanceGroups, instanceGroup)
+			continue
+		}
+		if errs := validateSchemaFeature(roleManifest, fmt.Sprintf("instance_groups[%s].zones", instanceGroup.Name), model.SchemaFeatureZones); len(errs) != 0 {
+			allErrs = append(allErrs, errs...)
+			continue
+		}
+		if instanceGroup.Type != model.RoleTypeBosh {
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("instance_groups[%s].zones", instanceGroup.Name),
+				instanceGroup.Type, "Only instance groups of type bosh can be replicated across zones"))
+			continue
+		}
+
+		// The replicas use the service account instead of the instance group
+		usedBy := roleManifest.Configuration.Authorization.Accounts[instanceGroup.Run.ServiceAccount].UsedBy
+		delete(usedBy, instanceGroup.Name)
+
+		instanceGroup.SetRoleManifest(roleManifest)
+		for _, zone := range instanceGroup.Zones {
+			fieldName := fmt.Sprintf("instance_groups[%s].zones[%s]", instanceGroup.Name, zone.Name)
+			if regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$").FindString(zone.Name) == "" {
+				allErrs = append(allErrs, validation.Invalid(fieldName+".name", zone.Name,
+					"zone names must be lowercase words separated by hyphens"))
+				continue
+			}
+			if zone.Label == "" {
+				zone.Label = zone.Name
+			}
+
+			replica := instanceGroup.ZoneReplica(zone)
+			if names[replica.Name] {
+				allErrs = append(allErrs, validation.Duplicate(fieldName, replica.Name))
+				continue
+			}
+			names[replica.Name] = true
+			usedBy[replica.Name] = struct{}{}
+
+			if zone.Scaling != nil {
+				allErrs = append(allErrs, validation.ValidateNonnegativeField(int64(zone.Scaling.Min), fieldName+".scaling.min")...)
+				if zone.Scaling.Max < zone.Scaling.Min {
+					allErrs = append(allErrs, validation.Invalid(fieldName+".scaling.max", zone.Scaling.Max,
+						fmt.Sprintf("maximum number of instances is smaller than the minimum %d", zone.Scaling.Min)))
+				}
+			}
+			if zone.Memory != nil {
+				if zone.Memory.Request != nil {
+					allErrs = append(allErrs, validation.ValidateNonnegativeField(*zone.Memory.Request, fieldName+".mem.request")...)
+				}
+				if zone.Memory.Limit != nil {
+					allErrs = append(allErrs, validation.ValidateNonnegativeField(*zone.Memory.Limit, fieldName+".mem.limit")...)
+				}
+			}
+			if zone.CPU != nil {
+				if zone.CPU.Request != nil {
+					allErrs = append(allErrs, validation.ValidateNonnegativeFieldFloat(*zone.CPU.Request, fieldName+".cpu.request")...)
+				}
+				if zone.CPU.Limit != nil {
+					allErrs = append(allErrs, validation.ValidateNonnegativeFieldFloat(*zone.CPU.Limit, fieldName+".cpu.limit")...)
+				}
+			}
+
+			instanceGroups = append(instanceGroups, replica)
+		}
+	}
+	roleManifest.InstanceGroups = instanceGroups
+
+	return allErrs
+}
@@ -0,0 +1,33 @@
+package resolver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"code.cloudfoundry.org/fissile/model"
+	"code.cloudfoundry.org/fissile/validation"
+)
+
+// loadVariableFiles reads the files of the variables with a value from a
+// file, relative to the role manifest; validateVariableFiles checks them
+func loadVariableFiles(m *model.RoleManifest) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	for _, cv := range m.Variables {
+		if cv.CVOptions.File == "" {
+			continue
+		}
+		path := cv.CVOptions.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(m.ManifestFilePath), path)
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("variables[%s].options.file", cv.Name), cv.CVOptions.File, err.Error()))
+			continue
+		}
+		cv.CVOptions.FileContents = contents
+	}
+	return allErrs
+}
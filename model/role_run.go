@@ -4,8 +4,11 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+
+	"code.cloudfoundry.org/fissile/validation"
 )
 
 // RoleRun describes how a role should behave at runtime
@@ -25,6 +28,222 @@ type RoleRun struct {
 	ActivePassiveProbe string           `yaml:"active-passive-probe,omitempty"`
 	ServiceAccount     string           `yaml:"service-account,omitempty"`
 	Affinity           *RoleRunAffinity `yaml:"affinity,omitempty"`
+	Command            []string         `yaml:"command,omitempty"`
+	Args               []string         `yaml:"args,omitempty"`
+	// ReadOnlyRootFilesystem mounts the root filesystem of the container
+	// read-only; the WritablePaths are backed by emptyDir volumes instead.
+	ReadOnlyRootFilesystem bool                   `yaml:"read-only-root-filesystem,omitempty"`
+	WritablePaths          []*RoleRunWritablePath `yaml:"writable-paths,omitempty"`
+	// Sysctls are the kernel parameters set for the pod; Kubernetes only
+	// supports them per pod, so they are shared with colocated containers.
+	Sysctls []*RoleRunSysctl `yaml:"sysctls,omitempty"`
+	// DownwardAPIPath is the directory the metadata of the pod (namespace,
+	// name, labels and annotations) is mounted at, as files
+	DownwardAPIPath string `yaml:"downward-api-path,omitempty"`
+	// DropCapabilities are the capabilities removed from the container,
+	// e.g. ALL to only keep the added Capabilities
+	DropCapabilities []string `yaml:"drop-capabilities,omitempty"`
+	// ServiceAccountTokens are tokens of the service account of the pod
+	// projected into the container, for external services trusting the
+	// cluster as an OIDC identity provider
+	ServiceAccountTokens []*RoleRunServiceAccountToken `yaml:"service-account-tokens,omitempty"`
+	// Backup describes how Velero backs up the pods of the instance group
+	Backup *RoleRunBackup `yaml:"backup,omitempty"`
+	// Job describes the retries and the cleanup of the Kubernetes job (or
+	// pod) of a bosh-task instance group
+	Job *RoleRunJob `yaml:"job,omitempty"`
+	// TerminationGracePeriodSeconds is the time the pods get to run the
+	// drain scripts and stop their processes before they are killed; the
+	// longest of the jobs applies
+	TerminationGracePeriodSeconds *int `yaml:"termination-grace-period-seconds,omitempty"`
+	// DrainTimeoutSeconds is the longest time the drain script of the job
+	// takes, which has to fit into the termination grace period
+	DrainTimeoutSeconds *int `yaml:"drain-timeout-seconds,omitempty"`
+	// Sidecars are containers of arbitrary images added to the pods of the
+	// instance group, e.g. proxies or metrics exporters
+	Sidecars []*RoleRunSidecar `yaml:"sidecars,omitempty"`
+}
+
+// DefaultTerminationGracePeriodSeconds is the termination grace period of
+// instance groups not setting one. BOSH can potentially have an infinite
+// termination grace period; we don't really trust that, so we'll just go
+// with ten minutes and hope it's enough.
+const DefaultTerminationGracePeriodSeconds = 600
+
+// GracePeriodSeconds returns the termination grace period of the pods
+func (r *RoleRun) GracePeriodSeconds() int {
+	if r == nil || r.TerminationGracePeriodSeconds == nil {
+		return DefaultTerminationGracePeriodSeconds
+	}
+	return *r.TerminationGracePeriodSeconds
+}
+
+// RoleRunJob describes the Kubernetes job of a bosh-task instance group;
+// instance groups tagged stop-on-failure run as bare pods, which only support
+// ActiveDeadlineSeconds and RestartPolicy.
+type RoleRunJob struct {
+	// ActiveDeadlineSeconds limits how long the job may run
+	ActiveDeadlineSeconds *int `yaml:"active-deadline-seconds,omitempty"`
+	// BackoffLimit is the number of retries before the job fails
+	BackoffLimit *int `yaml:"backoff-limit,omitempty"`
+	// TTLSecondsAfterFinished is how long finished jobs are kept
+	TTLSecondsAfterFinished *int `yaml:"ttl-seconds-after-finished,omitempty"`
+	// RestartPolicy of the pods, Never or OnFailure; defaults to Never for
+	// manual tasks, and OnFailure for all others
+	RestartPolicy string `yaml:"restart-policy,omitempty"`
+}
+
+// Restart policies of the pods of jobs
+const (
+	RestartPolicyNever     = "Never"
+	RestartPolicyOnFailure = "OnFailure"
+)
+
+// RoleRunBackup describes the Velero backups of the pods of an instance
+// group. The lock scripts of the BOSH backup and restore (BBR) jobs are run
+// by the hooks around the backup of each pod.
+type RoleRunBackup struct {
+	// Volumes are the tags of the volumes included in the file system
+	// backups; defaults to the persistent volumes
+	Volumes []string `yaml:"volumes,omitempty"`
+	// Jobs are the jobs whose BBR scripts are run, in order
+	Jobs []string `yaml:"jobs,omitempty"`
+	// ArtifactPath is the directory the bin/bbr/backup scripts of the jobs
+	// write their artifacts to, in a subdirectory per job; the scripts are
+	// only run if it is set. It should be on one of the backed up volumes.
+	ArtifactPath string `yaml:"artifact-path,omitempty"`
+	// Timeout is the longest time the hooks may run, e.g. 5m
+	Timeout string `yaml:"timeout,omitempty"`
+	// OnError is what Velero does if a hook fails, Fail or Continue
+	OnError string `yaml:"on-error,omitempty"`
+}
+
+// Actions of Velero when a backup hook fails
+const (
+	BackupOnErrorFail     = "Fail"
+	BackupOnErrorContinue = "Continue"
+)
+
+// RoleRunServiceAccountToken describes a service account token for an
+// audience, mounted as the file named token in the directory Path
+type RoleRunServiceAccountToken struct {
+	Path     string `yaml:"path"`
+	Audience string `yaml:"audience"`
+	// ExpirationSeconds is the requested lifetime of the token, which the
+	// kubelet rotates; Kubernetes defaults to one hour
+	ExpirationSeconds int `yaml:"expirationSeconds,omitempty"`
+}
+
+// MinServiceAccountTokenExpirationSeconds is the shortest lifetime Kubernetes
+// accepts for projected service account tokens
+const MinServiceAccountTokenExpirationSeconds = 600
+
+// RoleRunSidecar describes a container running an arbitrary image next to
+// the container of the instance group, without BOSH jobs of its own
+type RoleRunSidecar struct {
+	Name    string   `yaml:"name"`
+	Image   string   `yaml:"image"`
+	Command []string `yaml:"command,omitempty"`
+	Args    []string `yaml:"args,omitempty"`
+	// Ports are only exposed on the pod; they are not part of the services
+	// of the instance group
+	Ports []*RoleRunSidecarPort `yaml:"ports,omitempty"`
+	Env   []*RoleRunSidecarEnv  `yaml:"env,omitempty"`
+	// VolumeMounts mount volumes of the instance group, by tag
+	VolumeMounts []*RoleRunSidecarVolumeMount `yaml:"volume-mounts,omitempty"`
+	Memory       *RoleRunMemory               `yaml:"mem,omitempty"`
+	CPU          *RoleRunCPU                  `yaml:"cpu,omitempty"`
+}
+
+// RoleRunSidecarPort describes a port a sidecar listens on
+type RoleRunSidecarPort struct {
+	Name     string `yaml:"name"`
+	Port     int    `yaml:"port"`
+	Protocol string `yaml:"protocol,omitempty"` // Defaults to TCP
+}
+
+// UnmarshalYAML implements the yaml.v2/Unmarshaler interface, defaulting the
+// protocol of the port to TCP.
+func (port *RoleRunSidecarPort) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plainPort RoleRunSidecarPort
+	loaded := plainPort{Protocol: validation.TCP}
+	if err := unmarshal(&loaded); err != nil {
+		return err
+	}
+	*port = RoleRunSidecarPort(loaded)
+	return nil
+}
+
+// RoleRunSidecarEnv describes an environment variable of a sidecar
+type RoleRunSidecarEnv struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// RoleRunSidecarVolumeMount describes a volume of the instance group mounted
+// into a sidecar
+type RoleRunSidecarVolumeMount struct {
+	Tag      string `yaml:"tag"`
+	Path     string `yaml:"path"`
+	ReadOnly bool   `yaml:"read-only,omitempty"`
+}
+
+// RoleRunSysctl describes a (namespaced) kernel parameter set for a pod
+type RoleRunSysctl struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// safeSysctls are the sysctls Kubernetes considers safe, i.e. isolated
+// between pods, and allows by default. All others need to be allowed by the
+// kubelet and the pod security policy.
+var safeSysctls = map[string]bool{
+	"kernel.shm_rmid_forced":              true,
+	"net.ipv4.ip_local_port_range":        true,
+	"net.ipv4.ip_unprivileged_port_start": true,
+	"net.ipv4.ping_group_range":           true,
+	"net.ipv4.tcp_syncookies":             true,
+}
+
+// knownCapabilities are the Linux capabilities (see `man 7 capabilities`),
+// without the CAP_ prefix
+var knownCapabilities = map[string]bool{
+	"AUDIT_CONTROL": true, "AUDIT_READ": true, "AUDIT_WRITE": true, "BLOCK_SUSPEND": true,
+	"BPF": true, "CHECKPOINT_RESTORE": true, "CHOWN": true, "DAC_OVERRIDE": true,
+	"DAC_READ_SEARCH": true, "FOWNER": true, "FSETID": true, "IPC_LOCK": true,
+	"IPC_OWNER": true, "KILL": true, "LEASE": true, "LINUX_IMMUTABLE": true,
+	"MAC_ADMIN": true, "MAC_OVERRIDE": true, "MKNOD": true, "NET_ADMIN": true,
+	"NET_BIND_SERVICE": true, "NET_BROADCAST": true, "NET_RAW": true, "PERFMON": true,
+	"SETFCAP": true, "SETGID": true, "SETPCAP": true, "SETUID": true,
+	"SYS_ADMIN": true, "SYS_BOOT": true, "SYS_CHROOT": true, "SYS_MODULE": true,
+	"SYS_NICE": true, "SYS_PACCT": true, "SYS_PTRACE": true, "SYS_RAWIO": true,
+	"SYS_RESOURCE": true, "SYS_TIME": true, "SYS_TTY_CONFIG": true, "SYSLOG": true,
+	"WAKE_ALARM": true,
+}
+
+// IsKnownCapability returns whether the name (in upper case, without the CAP_
+// prefix) is a Linux capability, or ALL
+func IsKnownCapability(name string) bool {
+	return name == "ALL" || knownCapabilities[name]
+}
+
+// namespacedSysctlPrefixes are the prefixes of the sysctls isolated by the
+// kernel namespaces of a pod; only those can be set per pod
+var namespacedSysctlPrefixes = []string{"kernel.shm", "kernel.msg", "kernel.sem", "fs.mqueue.", "net."}
+
+// IsSafe returns whether Kubernetes allows the sysctl by default
+func (s *RoleRunSysctl) IsSafe() bool {
+	return safeSysctls[s.Name]
+}
+
+// IsNamespaced returns whether the sysctl can be set per pod
+func (s *RoleRunSysctl) IsNamespaced() bool {
+	for _, prefix := range namespacedSysctlPrefixes {
+		if strings.HasPrefix(s.Name, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 // RoleRunAffinity describes how a role should behave with regard to node / pod selection
@@ -34,6 +253,33 @@ type RoleRunAffinity struct {
 	NodeAffinity    interface{} `yaml:"nodeAffinity,omitempty"`
 }
 
+// RoleRunWritablePath describes a path that remains writable when the root
+// filesystem of a role is read-only
+type RoleRunWritablePath struct {
+	Path  string `yaml:"path"`
+	Tmpfs bool   `yaml:"tmpfs,omitempty"` // Back the path with memory instead of node storage
+	Seed  bool   `yaml:"seed,omitempty"`  // Copy the content of the image at the path into the volume
+}
+
+// DefaultWritablePaths returns the paths run.sh, configgin and BOSH jobs
+// write to at runtime, used for roles with a read-only root filesystem that
+// do not list any paths. The paths with content in the image, like the job
+// templates rendered by configgin and /etc/monitrc, are seeded from it.
+func DefaultWritablePaths() []*RoleRunWritablePath {
+	return []*RoleRunWritablePath{
+		{Path: "/var/vcap/sys"},
+		{Path: "/var/vcap/data"},
+		{Path: "/tmp", Tmpfs: true},
+		{Path: "/run", Tmpfs: true},
+		{Path: "/var/vcap/instance"},
+		{Path: "/var/vcap/jobs", Seed: true},
+		{Path: "/var/vcap/monit", Seed: true},
+		{Path: "/var/log", Seed: true},
+		{Path: "/var/spool/cron", Seed: true},
+		{Path: "/etc", Seed: true},
+	}
+}
+
 // RoleRunMemory describes how a role should behave with regard to memory usage.
 type RoleRunMemory struct {
 	Request *int64 `yaml:"request"`
@@ -83,6 +329,11 @@ const (
 	VolumeTypeHost       = VolumeType("host")       // A volume that is a mount of a host directory
 	VolumeTypeNone       = VolumeType("none")       // A volume that isn't mounted to anything
 	VolumeTypeEmptyDir   = VolumeType("emptyDir")   // A volume that is shared between containers
+
+	// VolumeTypeSharedSocket is an emptyDir volume holding unix sockets. It is
+	// declared once, by the instance group providing the sockets, and mounted
+	// at its path into all containers of the pod.
+	VolumeTypeSharedSocket = VolumeType("shared-socket")
 )
 
 // FlightStage describes when a role should be executed
@@ -100,6 +351,7 @@ const (
 type HealthCheck struct {
 	Liveness  *HealthProbe `yaml:"liveness,omitempty"`  // Details of liveness probe configuration
 	Readiness *HealthProbe `yaml:"readiness,omitempty"` // Ditto for readiness probe
+	Profile   string       `yaml:"profile,omitempty"`   // Name of the probe profile with the default timings of both probes
 }
 
 // HealthProbe holds the configuration for liveness and readiness
@@ -116,6 +368,43 @@ type HealthProbe struct {
 	FailureThreshold int               `yaml:"failure_threshold,omitempty"` // Failure threshold in seconds, default 3, minimum 1
 }
 
+// ProbeProfile holds the timings of health probes under a name, so that they
+// are defined once in the role manifest configuration and shared by the
+// health checks referencing it. The timings a probe sets itself take
+// precedence.
+type ProbeProfile struct {
+	InitialDelay     int `yaml:"initial_delay,omitempty"`
+	Period           int `yaml:"period,omitempty"`
+	Timeout          int `yaml:"timeout,omitempty"`
+	SuccessThreshold int `yaml:"success_threshold,omitempty"`
+	FailureThreshold int `yaml:"failure_threshold,omitempty"`
+}
+
+// WithProfile returns a copy of the probe, with the timings it does not set
+// taken from the profile. A nil probe results in a probe with the timings only.
+func (p *HealthProbe) WithProfile(profile *ProbeProfile) *HealthProbe {
+	probe := HealthProbe{}
+	if p != nil {
+		probe = *p
+	}
+	if profile == nil {
+		return &probe
+	}
+	// withDefault is a helper returning the profile value for an unset timing
+	withDefault := func(value, defaultValue int) int {
+		if value == 0 {
+			return defaultValue
+		}
+		return value
+	}
+	probe.InitialDelay = withDefault(probe.InitialDelay, profile.InitialDelay)
+	probe.Period = withDefault(probe.Period, profile.Period)
+	probe.Timeout = withDefault(probe.Timeout, profile.Timeout)
+	probe.SuccessThreshold = withDefault(probe.SuccessThreshold, profile.SuccessThreshold)
+	probe.FailureThreshold = withDefault(probe.FailureThreshold, profile.FailureThreshold)
+	return &probe
+}
+
 func maxInteger(jobs JobReferences, getProperty jobReferenceIntegerProperty) int {
 	max := 1
 	for _, j := range jobs {
@@ -163,10 +452,17 @@ func (r *RoleRun) setScaling(jobReferences JobReferences) {
 // setCapabilities merges from all jobs and normalizes capabilities to upper case
 func (r *RoleRun) mergeCapabilities(jobReferences JobReferences) {
 	seen := map[string]int{}
+	dropped := map[string]bool{}
+	for _, c := range r.DropCapabilities {
+		dropped[strings.ToUpper(c)] = true
+	}
 	for _, j := range jobReferences {
 		for _, c := range j.ContainerProperties.BoshContainerization.Run.Capabilities {
 			seen[strings.ToUpper(c)] = 1
 		}
+		for _, c := range j.ContainerProperties.BoshContainerization.Run.DropCapabilities {
+			dropped[strings.ToUpper(c)] = true
+		}
 		if j.ContainerProperties.BoshContainerization.Run.Privileged {
 			r.Privileged = true
 		}
@@ -175,6 +471,12 @@ func (r *RoleRun) mergeCapabilities(jobReferences JobReferences) {
 	for k := range seen {
 		r.Capabilities = append(r.Capabilities, k)
 	}
+
+	r.DropCapabilities = nil
+	for k := range dropped {
+		r.DropCapabilities = append(r.DropCapabilities, k)
+	}
+	sort.Strings(r.DropCapabilities)
 }
 
 // setVolumes collects uniq volumes from every job using a fingerprint, also
@@ -216,6 +518,90 @@ func (r *RoleRun) mergeVolumes(jobReferences JobReferences) {
 	}
 }
 
+// mergeWritablePaths collects the unique writable paths from every job, using
+// the default paths if the root filesystem is read-only and none are given
+func (r *RoleRun) mergeWritablePaths(jobReferences JobReferences) {
+	seen := map[string]bool{}
+	for _, j := range jobReferences {
+		run := j.ContainerProperties.BoshContainerization.Run
+		if run.ReadOnlyRootFilesystem {
+			r.ReadOnlyRootFilesystem = true
+		}
+		for _, p := range run.WritablePaths {
+			if !seen[p.Path] {
+				seen[p.Path] = true
+				r.WritablePaths = append(r.WritablePaths, p)
+			}
+		}
+	}
+
+	if r.ReadOnlyRootFilesystem && len(r.WritablePaths) == 0 {
+		r.WritablePaths = DefaultWritablePaths()
+	}
+}
+
+// mergeServiceAccountTokens collects the service account tokens from every
+// job, mounting only the first token for each path
+func (r *RoleRun) mergeServiceAccountTokens(jobReferences JobReferences) {
+	seen := map[string]bool{}
+	for _, j := range jobReferences {
+		for _, token := range j.ContainerProperties.BoshContainerization.Run.ServiceAccountTokens {
+			if !seen[token.Path] {
+				seen[token.Path] = true
+				r.ServiceAccountTokens = append(r.ServiceAccountTokens, token)
+			}
+		}
+	}
+}
+
+// mergeTerminationGracePeriod sets the longest termination grace period of
+// the jobs
+func (r *RoleRun) mergeTerminationGracePeriod(jobReferences JobReferences) {
+	for _, j := range jobReferences {
+		period := j.ContainerProperties.BoshContainerization.Run.TerminationGracePeriodSeconds
+		if period != nil && (r.TerminationGracePeriodSeconds == nil || *period > *r.TerminationGracePeriodSeconds) {
+			r.TerminationGracePeriodSeconds = period
+		}
+	}
+}
+
+// mergeSidecars collects the sidecars from every job, and returns the names
+// of the sidecars declared by more than one job
+func (r *RoleRun) mergeSidecars(jobReferences JobReferences) []string {
+	seen := map[string]bool{}
+	var duplicates []string
+	for _, j := range jobReferences {
+		for _, sidecar := range j.ContainerProperties.BoshContainerization.Run.Sidecars {
+			if seen[sidecar.Name] {
+				duplicates = append(duplicates, sidecar.Name)
+				continue
+			}
+			seen[sidecar.Name] = true
+			r.Sidecars = append(r.Sidecars, sidecar)
+		}
+	}
+	return duplicates
+}
+
+// mergeSysctls collects the sysctls from every job, and returns the names of
+// the sysctls set to different values by different jobs
+func (r *RoleRun) mergeSysctls(jobReferences JobReferences) []string {
+	values := map[string]string{}
+	var conflicts []string
+	for _, j := range jobReferences {
+		for _, sysctl := range j.ContainerProperties.BoshContainerization.Run.Sysctls {
+			value, ok := values[sysctl.Name]
+			if !ok {
+				values[sysctl.Name] = sysctl.Value
+				r.Sysctls = append(r.Sysctls, sysctl)
+			} else if value != sysctl.Value {
+				conflicts = append(conflicts, sysctl.Name)
+			}
+		}
+	}
+	return conflicts
+}
+
 func (r *RoleRun) setMaxFields(jobReferences JobReferences) {
 	var maxMem, maxMemLimit, maxMemRequest *int64
 	var maxVirtualCPUs, maxCPULimit, maxCPURequest *float64
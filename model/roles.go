@@ -1,8 +1,13 @@
 package model
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"code.cloudfoundry.org/fissile/util"
 	yaml "gopkg.in/yaml.v2"
@@ -14,6 +19,26 @@ type RoleManifest struct {
 	Configuration  *Configuration `yaml:"configuration"`
 	Variables      Variables
 	Releases       []*ReleaseRef `yaml:"releases"`
+	// SchemaVersion is the version of the role manifest format; see
+	// SchemaFeatures
+	SchemaVersion int `yaml:"schema_version,omitempty"`
+	// MinimumFissileVersion is the oldest fissile version that supports the
+	// role manifest
+	MinimumFissileVersion string `yaml:"minimum_fissile_version,omitempty"`
+	// RuntimeScripts is a directory, relative to the role manifest, whose
+	// files replace or augment the runtime scripts in /opt/fissile of the
+	// role images; see RuntimeScriptNames
+	RuntimeScripts string `yaml:"runtime_scripts,omitempty"`
+	// DefaultsFiles are paths, relative to the role manifest, of files
+	// overriding the defaults of the variables; see DefaultsFile
+	DefaultsFiles []string `yaml:"defaults_files,omitempty"`
+	// ReleaseStemcells maps release names to the stemcell images the
+	// instance groups with their jobs are built on, instead of the stemcell
+	// of the build; see InstanceGroup.StemcellImage
+	ReleaseStemcells map[string]string `yaml:"release_stemcells,omitempty"`
+	// ExternalSecrets is the external secret store of the secret variables
+	// with an external key
+	ExternalSecrets *ExternalSecrets `yaml:"external_secrets,omitempty"`
 
 	LoadedReleases   Releases
 	Features         map[string]bool
@@ -31,6 +56,12 @@ type LoadRoleManifestOptions struct {
 	ReleaseOptions
 	Grapher           util.ModelGrapher
 	ValidationOptions RoleManifestValidationOptions
+	// RuntimeConfigs are paths to BOSH runtime configs whose addon
+	// properties are merged into the instance groups; see RuntimeConfig
+	RuntimeConfigs []string
+	// DefaultsFiles are paths of files overriding the defaults of the
+	// variables, after those listed in the role manifest; see DefaultsFile
+	DefaultsFiles []string
 }
 
 // NewRoleManifest returns a new role manifest struct
@@ -94,3 +125,59 @@ func (m *RoleManifest) SelectInstanceGroups(roleNames []string) (InstanceGroups,
 
 	return results, nil
 }
+
+// RuntimeScriptNames are the runtime scripts fissile generates for the role
+// images. Files of the runtime scripts directory with these names replace
+// them, and are rendered as the same templates; other files are added as
+// they are.
+var RuntimeScriptNames = []string{"run.sh", "pre-stop.sh", "readiness-probe.sh"}
+
+// GetRuntimeScriptPaths returns the paths of the files of the runtime scripts
+// directory, keyed by their names. Hidden files and subdirectories are
+// ignored.
+func (m *RoleManifest) GetRuntimeScriptPaths() (map[string]string, error) {
+	result := map[string]string{}
+	if m.RuntimeScripts == "" {
+		return result, nil
+	}
+
+	dir := filepath.Join(filepath.Dir(m.ManifestFilePath), m.RuntimeScripts)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading runtime scripts directory: %v", err)
+	}
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		result[info.Name()] = filepath.Join(dir, info.Name())
+	}
+	return result, nil
+}
+
+// GetRuntimeScriptSignatures returns the SHA1 of the names and contents of
+// the files of the runtime scripts directory
+func (m *RoleManifest) GetRuntimeScriptSignatures() (string, error) {
+	paths, err := m.GetRuntimeScriptPaths()
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hasher := sha1.New()
+	for _, name := range names {
+		contents, err := ioutil.ReadFile(paths[name])
+		if err != nil {
+			return "", err
+		}
+		hasher.Write([]byte(name))
+		hasher.Write([]byte("\x00"))
+		hasher.Write(contents)
+		hasher.Write([]byte("\x00"))
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
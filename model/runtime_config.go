@@ -0,0 +1,112 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RuntimeConfig is a BOSH runtime config. Fissile consumes it as a
+// compatibility shim only: the properties of its addons are merged into the
+// configuration templates of the instance groups the addons are placed on,
+// so that they can be propagated via the environment like any other
+// template. The jobs of the addons are not added to the instance groups.
+type RuntimeConfig struct {
+	Path   string                `yaml:"-"`
+	Addons []*RuntimeConfigAddon `yaml:"addons"`
+}
+
+// RuntimeConfigAddon is an addon of a BOSH runtime config
+type RuntimeConfigAddon struct {
+	Name       string                      `yaml:"name"`
+	Jobs       []RuntimeConfigAddonJob     `yaml:"jobs"`
+	Properties map[interface{}]interface{} `yaml:"properties"`
+	Include    *RuntimeConfigPlacement     `yaml:"include"`
+	Exclude    *RuntimeConfigPlacement     `yaml:"exclude"`
+}
+
+// RuntimeConfigAddonJob is a job of a runtime config addon, or a job used to
+// place an addon
+type RuntimeConfigAddonJob struct {
+	Name       string                      `yaml:"name"`
+	Release    string                      `yaml:"release"`
+	Properties map[interface{}]interface{} `yaml:"properties"`
+}
+
+// RuntimeConfigPlacement is an include or exclude rule of a runtime config
+// addon. All of the criteria given must match.
+type RuntimeConfigPlacement struct {
+	InstanceGroups []string                `yaml:"instance_groups"`
+	Jobs           []RuntimeConfigAddonJob `yaml:"jobs"`
+	// Lifecycle is either "service" or "errand"
+	Lifecycle string `yaml:"lifecycle"`
+}
+
+// Runtime config addon lifecycles
+const (
+	RuntimeConfigLifecycleService = "service"
+	RuntimeConfigLifecycleErrand  = "errand"
+)
+
+// LoadRuntimeConfig reads a BOSH runtime config from a file
+func LoadRuntimeConfig(path string) (*RuntimeConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	runtimeConfig := &RuntimeConfig{Path: path}
+	if err := yaml.Unmarshal(content, runtimeConfig); err != nil {
+		return nil, fmt.Errorf("Error parsing runtime config %s: %v", path, err)
+	}
+	return runtimeConfig, nil
+}
+
+// Matches checks if the instance group satisfies all criteria of the rule
+func (p *RuntimeConfigPlacement) Matches(instanceGroup *InstanceGroup) bool {
+	if len(p.InstanceGroups) > 0 {
+		found := false
+		for _, name := range p.InstanceGroups {
+			if name == instanceGroup.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, job := range p.Jobs {
+		found := false
+		for _, jobReference := range instanceGroup.JobReferences {
+			if jobReference.Name == job.Name && (job.Release == "" || jobReference.ReleaseName == job.Release) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	switch p.Lifecycle {
+	case RuntimeConfigLifecycleService:
+		return instanceGroup.Type != RoleTypeBoshTask
+	case RuntimeConfigLifecycleErrand:
+		return instanceGroup.Type == RoleTypeBoshTask
+	}
+	return true
+}
+
+// PlacedOn checks if the addon applies to the instance group, according to
+// its include and exclude rules
+func (a *RuntimeConfigAddon) PlacedOn(instanceGroup *InstanceGroup) bool {
+	if a.Include != nil && !a.Include.Matches(instanceGroup) {
+		return false
+	}
+	if a.Exclude != nil && a.Exclude.Matches(instanceGroup) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package model
+
+// CurrentSchemaVersion is the newest role manifest schema version supported
+// by this version of fissile
+const CurrentSchemaVersion = 2
+
+// SchemaFeature is a role manifest feature, or a validation, that is only
+// active from a certain schema version on
+type SchemaFeature string
+
+const (
+	// SchemaFeatureZones allows replicating instance groups across zones
+	SchemaFeatureZones = SchemaFeature("zones")
+	// SchemaFeatureVariableFiles allows reading secrets from files
+	SchemaFeatureVariableFiles = SchemaFeature("variable files")
+	// SchemaFeatureLinkPropertyValidation checks that the properties job
+	// templates look up on links are exported by the providers
+	SchemaFeatureLinkPropertyValidation = SchemaFeature("link property validation")
+)
+
+// SchemaFeatures is the compatibility table of the role manifest schema,
+// mapping each feature to the schema version it is active from
+var SchemaFeatures = map[SchemaFeature]int{
+	SchemaFeatureZones:                  2,
+	SchemaFeatureVariableFiles:          2,
+	SchemaFeatureLinkPropertyValidation: 2,
+}
+
+// EffectiveSchemaVersion returns the schema version of the role manifest;
+// manifests without a schema_version use version 1
+func (m *RoleManifest) EffectiveSchemaVersion() int {
+	if m.SchemaVersion == 0 {
+		return 1
+	}
+	return m.SchemaVersion
+}
+
+// Supports tests whether a schema feature is active for the role manifest
+func (m *RoleManifest) Supports(feature SchemaFeature) bool {
+	return m.EffectiveSchemaVersion() >= SchemaFeatures[feature]
+}
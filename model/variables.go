@@ -3,6 +3,8 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -48,6 +50,191 @@ type CVOptions struct {
 	IsCA          bool        `yaml:"is_ca,omitempty"`
 	RoleName      string      `yaml:"role_name,omitempty"`
 	AltNames      []string    `yaml:"alternative_names,omitempty"`
+	// CA is the name of the CA certificate variable signing a certificate;
+	// it defaults to the only CA of the role manifest
+	CA         string         `yaml:"ca,omitempty"`
+	Deprecated *CVDeprecation `yaml:"deprecated,omitempty"`
+	// Validation are the rules user supplied values must follow; they are
+	// checked at render time and by validating a values file
+	Validation *CVValidation `yaml:"validation,omitempty"`
+	// File is the path, relative to the role manifest, of a file holding the
+	// (possibly binary) value of a secret, e.g. a keytab
+	File string `yaml:"file,omitempty"`
+	// FileContents is the content of File, read while resolving the manifest
+	FileContents []byte `yaml:"-"`
+	// URLTemplate is the URL of a service of the role manifest, e.g.
+	// https://uaa-public:2793/; the default of the variable is generated at
+	// render time by qualifying the service name with the namespace and the
+	// cluster domain
+	URLTemplate string `yaml:"url_template,omitempty"`
+	// ExternalKey is the key of a secret in the external secret store of
+	// the role manifest, e.g. prod/uaa/admin-password; the secret is read
+	// from there, even if it has a generator
+	ExternalKey string `yaml:"external_key,omitempty"`
+	// DefaultSource is the path of the defaults file that set the default,
+	// if it doesn't come from the role manifest
+	DefaultSource string `yaml:"-"`
+}
+
+// CVDeprecation marks a variable as deprecated. The notice is shown in the
+// helm values file and by validation of user supplied values. With
+// FailAfterRemoval set, rendering a chart with a version at or above the
+// RemovalVersion fails while the variable is still set.
+type CVDeprecation struct {
+	Message          string `yaml:"message"`
+	RemovalVersion   string `yaml:"removal_version,omitempty"`
+	FailAfterRemoval bool   `yaml:"fail_after_removal,omitempty"`
+}
+
+// Notice returns a human readable deprecation notice for the named variable
+func (deprecation *CVDeprecation) Notice(name string) string {
+	notice := fmt.Sprintf("%s is deprecated", name)
+	if deprecation.RemovalVersion != "" {
+		notice += fmt.Sprintf(" and will be removed in version %s", deprecation.RemovalVersion)
+	}
+	if deprecation.Message != "" {
+		notice += ": " + deprecation.Message
+	}
+	return notice
+}
+
+// Types of values checked by the validation rules of variables
+const (
+	CVValidationTypeString  = "string"
+	CVValidationTypeInteger = "integer"
+	CVValidationTypeNumber  = "number"
+	CVValidationTypeBoolean = "boolean"
+)
+
+// CVValidationTypes lists the supported types of validation rules
+var CVValidationTypes = []string{CVValidationTypeString, CVValidationTypeInteger, CVValidationTypeNumber, CVValidationTypeBoolean}
+
+// Patterns of the string forms of numbers, shared with the checks of the
+// helm templates
+const (
+	CVIntegerPattern = `^-?[0-9]+$`
+	CVNumberPattern  = `^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`
+)
+
+// CVValidation are the rules the values of a variable must follow. Values are
+// compared as strings against the enum, and the pattern has to match the
+// whole value. Min and Max imply a numeric type.
+type CVValidation struct {
+	Type    string   `yaml:"type,omitempty"`
+	Enum    []string `yaml:"enum,omitempty"`
+	Pattern string   `yaml:"pattern,omitempty"`
+	Min     *float64 `yaml:"min,omitempty"`
+	Max     *float64 `yaml:"max,omitempty"`
+}
+
+// NumericType returns the numeric type of the values, or "" if they aren't
+// numeric
+func (v *CVValidation) NumericType() string {
+	switch {
+	case v.Type == CVValidationTypeInteger || v.Type == CVValidationTypeNumber:
+		return v.Type
+	case v.Type == "" && (v.Min != nil || v.Max != nil):
+		return CVValidationTypeNumber
+	}
+	return ""
+}
+
+// AnchoredPattern returns the pattern, matching whole values
+func (v *CVValidation) AnchoredPattern() string {
+	return fmt.Sprintf("^(?:%s)$", v.Pattern)
+}
+
+// Check returns an error describing the first rule the value breaks. Values
+// of maps and lists only pass rules without a type.
+func (v *CVValidation) Check(value interface{}) error {
+	var stringValue string
+	switch value := value.(type) {
+	case map[interface{}]interface{}, map[string]interface{}, []interface{}:
+		if v.Type != "" || v.NumericType() != "" || len(v.Enum) > 0 || v.Pattern != "" {
+			return fmt.Errorf("must not be a map or a list")
+		}
+		return nil
+	case string:
+		stringValue = value
+	default:
+		stringValue = fmt.Sprintf("%v", value)
+	}
+
+	switch v.Type {
+	case CVValidationTypeString:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("must be a string")
+		}
+	case CVValidationTypeBoolean:
+		if stringValue != "true" && stringValue != "false" {
+			return fmt.Errorf("must be a boolean")
+		}
+	}
+
+	if numericType := v.NumericType(); numericType != "" {
+		pattern, kind := CVNumberPattern, "a number"
+		if numericType == CVValidationTypeInteger {
+			pattern, kind = CVIntegerPattern, "an integer"
+		}
+		if !regexp.MustCompile(pattern).MatchString(stringValue) {
+			return fmt.Errorf("must be %s", kind)
+		}
+		number, err := strconv.ParseFloat(stringValue, 64)
+		if err != nil {
+			return fmt.Errorf("must be %s", kind)
+		}
+		if v.Min != nil && number < *v.Min {
+			return fmt.Errorf("must be at least %v", *v.Min)
+		}
+		if v.Max != nil && number > *v.Max {
+			return fmt.Errorf("must be at most %v", *v.Max)
+		}
+	}
+
+	if len(v.Enum) > 0 {
+		found := false
+		for _, allowed := range v.Enum {
+			if allowed == stringValue {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("must be one of %s", strings.Join(v.Enum, ", "))
+		}
+	}
+
+	if v.Pattern != "" {
+		pattern, err := regexp.Compile(v.AnchoredPattern())
+		if err != nil {
+			return fmt.Errorf("has an invalid pattern: %v", err)
+		}
+		if !pattern.MatchString(stringValue) {
+			return fmt.Errorf("must match %s", v.Pattern)
+		}
+	}
+
+	return nil
+}
+
+// ParseURLTemplate splits the url template of a variable at the service
+// name, which is the host of the URL
+func ParseURLTemplate(template string) (prefix, service, suffix string, err error) {
+	u, err := url.Parse(template)
+	if err != nil {
+		return "", "", "", err
+	}
+	if u.Scheme == "" || u.Hostname() == "" {
+		return "", "", "", fmt.Errorf("The URL must have a scheme and a service name as its host")
+	}
+	if u.User != nil {
+		return "", "", "", fmt.Errorf("The URL must not have user information")
+	}
+	prefix = u.Scheme + "://"
+	service = u.Hostname()
+	if !strings.HasPrefix(template, prefix+service) {
+		return "", "", "", fmt.Errorf("The URL must start with %s%s", prefix, service)
+	}
+	return prefix, service, strings.TrimPrefix(template, prefix+service), nil
 }
 
 // CVType is the type of the configuration variable; see the constants below
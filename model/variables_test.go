@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCVValidationCheck(t *testing.T) {
+	t.Parallel()
+
+	min, max := 1.0, 10.0
+	for _, testCase := range []struct {
+		name       string
+		validation CVValidation
+		value      interface{}
+		err        string
+	}{
+		{"string", CVValidation{Type: CVValidationTypeString}, "abc", ""},
+		{"not a string", CVValidation{Type: CVValidationTypeString}, 1, "must be a string"},
+		{"boolean", CVValidation{Type: CVValidationTypeBoolean}, true, ""},
+		{"boolean string", CVValidation{Type: CVValidationTypeBoolean}, "false", ""},
+		{"not a boolean", CVValidation{Type: CVValidationTypeBoolean}, "yes", "must be a boolean"},
+		{"integer", CVValidation{Type: CVValidationTypeInteger}, 42, ""},
+		{"integer string", CVValidation{Type: CVValidationTypeInteger}, "-42", ""},
+		{"not an integer", CVValidation{Type: CVValidationTypeInteger}, 4.2, "must be an integer"},
+		{"number", CVValidation{Type: CVValidationTypeNumber}, "4.2", ""},
+		{"not a number", CVValidation{Type: CVValidationTypeNumber}, "four", "must be a number"},
+		{"in range", CVValidation{Min: &min, Max: &max}, 5, ""},
+		{"below min", CVValidation{Min: &min, Max: &max}, 0, "must be at least 1"},
+		{"above max", CVValidation{Min: &min, Max: &max}, "11", "must be at most 10"},
+		{"in enum", CVValidation{Enum: []string{"a", "b"}}, "b", ""},
+		{"not in enum", CVValidation{Enum: []string{"a", "b"}}, "c", "must be one of a, b"},
+		{"matching", CVValidation{Pattern: "[a-z]+"}, "abc", ""},
+		{"matching partially", CVValidation{Pattern: "[a-z]+"}, "abc1", "must match [a-z]+"},
+		{"map", CVValidation{Pattern: "[a-z]+"}, map[interface{}]interface{}{}, "must not be a map or a list"},
+		{"map without rules", CVValidation{}, []interface{}{}, ""},
+	} {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			err := testCase.validation.Check(testCase.value)
+			if testCase.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, testCase.err)
+			}
+		})
+	}
+}
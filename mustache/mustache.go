@@ -37,14 +37,17 @@ type Template struct {
 	curline int
 	dir     string
 	elems   []interface{}
+	// strict templates reject partials and unterminated raw tags
+	strict bool
 }
 
-type parseError struct {
-	line    int
-	message string
+// ParseError is an error parsing a template, at a line of the template
+type ParseError struct {
+	Line    int
+	Message string
 }
 
-func (p parseError) Error() string { return fmt.Sprintf("line %d: %s", p.line, p.message) }
+func (p ParseError) Error() string { return fmt.Sprintf("line %d: %s", p.Line, p.Message) }
 
 var (
 	esc_quot = []byte("&quot;")
@@ -132,7 +135,7 @@ func (tmpl *Template) parseSection(section *sectionElement) error {
 		text, err := tmpl.readString(tmpl.otag)
 
 		if err == io.EOF {
-			return parseError{section.startline, "Section " + section.name + " has no closing tag"}
+			return ParseError{section.startline, "Section " + section.name + " has no closing tag"}
 		}
 
 		// put text into an item
@@ -146,14 +149,14 @@ func (tmpl *Template) parseSection(section *sectionElement) error {
 
 		if err == io.EOF {
 			//put the remaining text in a block
-			return parseError{tmpl.curline, "unmatched open tag"}
+			return ParseError{tmpl.curline, "unmatched open tag"}
 		}
 
 		//trim the close tag off the text
 		tag := strings.TrimSpace(text[0 : len(text)-len(tmpl.ctag)])
 
 		if len(tag) == 0 {
-			return parseError{tmpl.curline, "empty tag"}
+			return ParseError{tmpl.curline, "empty tag"}
 		}
 		switch tag[0] {
 		case '!':
@@ -178,12 +181,15 @@ func (tmpl *Template) parseSection(section *sectionElement) error {
 		case '/':
 			name := strings.TrimSpace(tag[1:])
 			if name != section.name {
-				return parseError{tmpl.curline, "interleaved closing tag: " + name}
+				return ParseError{tmpl.curline, "interleaved closing tag: " + name}
 			} else {
 				return nil
 			}
 		case '>':
 			name := strings.TrimSpace(tag[1:])
+			if tmpl.strict {
+				return ParseError{tmpl.curline, "partials are not supported: " + name}
+			}
 			partial, err := tmpl.parsePartial(name)
 			if err != nil {
 				return err
@@ -191,7 +197,7 @@ func (tmpl *Template) parseSection(section *sectionElement) error {
 			section.elems = append(section.elems, partial)
 		case '=':
 			if tag[len(tag)-1] != '=' {
-				return parseError{tmpl.curline, "Invalid meta tag"}
+				return ParseError{tmpl.curline, "Invalid meta tag"}
 			}
 			tag = strings.TrimSpace(tag[1 : len(tag)-1])
 			newtags := strings.SplitN(tag, " ", 2)
@@ -203,6 +209,8 @@ func (tmpl *Template) parseSection(section *sectionElement) error {
 			if tag[len(tag)-1] == '}' {
 				//use a raw tag
 				section.elems = append(section.elems, &varElement{tag[1 : len(tag)-1], true})
+			} else if tmpl.strict {
+				return ParseError{tmpl.curline, "unmatched raw tag: " + tag}
 			}
 		default:
 			section.elems = append(section.elems, &varElement{tag, false})
@@ -233,13 +241,13 @@ func (tmpl *Template) parse() error {
 
 		if err == io.EOF {
 			//put the remaining text in a block
-			return parseError{tmpl.curline, "unmatched open tag"}
+			return ParseError{tmpl.curline, "unmatched open tag"}
 		}
 
 		//trim the close tag off the text
 		tag := strings.TrimSpace(text[0 : len(text)-len(tmpl.ctag)])
 		if len(tag) == 0 {
-			return parseError{tmpl.curline, "empty tag"}
+			return ParseError{tmpl.curline, "empty tag"}
 		}
 		switch tag[0] {
 		case '!':
@@ -261,9 +269,12 @@ func (tmpl *Template) parse() error {
 			}
 			tmpl.elems = append(tmpl.elems, &se)
 		case '/':
-			return parseError{tmpl.curline, "unmatched close tag"}
+			return ParseError{tmpl.curline, "unmatched close tag"}
 		case '>':
 			name := strings.TrimSpace(tag[1:])
+			if tmpl.strict {
+				return ParseError{tmpl.curline, "partials are not supported: " + name}
+			}
 			partial, err := tmpl.parsePartial(name)
 			if err != nil {
 				return err
@@ -271,7 +282,7 @@ func (tmpl *Template) parse() error {
 			tmpl.elems = append(tmpl.elems, partial)
 		case '=':
 			if tag[len(tag)-1] != '=' {
-				return parseError{tmpl.curline, "Invalid meta tag"}
+				return ParseError{tmpl.curline, "Invalid meta tag"}
 			}
 			tag = strings.TrimSpace(tag[1 : len(tag)-1])
 			newtags := strings.SplitN(tag, " ", 2)
@@ -283,6 +294,8 @@ func (tmpl *Template) parse() error {
 			//use a raw tag
 			if tag[len(tag)-1] == '}' {
 				tmpl.elems = append(tmpl.elems, &varElement{tag[1 : len(tag)-1], true})
+			} else if tmpl.strict {
+				return ParseError{tmpl.curline, "unmatched raw tag: " + tag}
 			}
 		default:
 			tmpl.elems = append(tmpl.elems, &varElement{tag, false})
@@ -549,7 +562,22 @@ func getTemplateVariables(elements []interface{}, vars []string) []string {
 
 func ParseString(data string) (*Template, error) {
 	cwd := os.Getenv("CWD")
-	tmpl := Template{data, "{{", "}}", 0, 1, cwd, []interface{}{}}
+	tmpl := Template{data, "{{", "}}", 0, 1, cwd, []interface{}{}, false}
+	err := tmpl.parse()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tmpl, err
+}
+
+// ParseStringStrict parses a template like ParseString, but fails on the
+// constructs ParseString ignores or resolves outside of the template: raw tags
+// missing their closing brace, and partials.
+func ParseStringStrict(data string) (*Template, error) {
+	cwd := os.Getenv("CWD")
+	tmpl := Template{data, "{{", "}}", 0, 1, cwd, []interface{}{}, true}
 	err := tmpl.parse()
 
 	if err != nil {
@@ -567,7 +595,7 @@ func ParseFile(filename string) (*Template, error) {
 
 	dirname, _ := path.Split(filename)
 
-	tmpl := Template{string(data), "{{", "}}", 0, 1, dirname, []interface{}{}}
+	tmpl := Template{string(data), "{{", "}}", 0, 1, dirname, []interface{}{}, false}
 	err = tmpl.parse()
 
 	if err != nil {
@@ -0,0 +1,332 @@
+// Package oci assembles container images as OCI image layouts on disk, and
+// pushes them to registries, without the help of a docker daemon.
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	_ "crypto/sha256" // required by go-digest
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Layout is an OCI image layout directory, holding content addressed blobs
+// and an index of the named images.
+type Layout struct {
+	Path string
+
+	// mutex serializes the updates of the index
+	mutex sync.Mutex
+}
+
+// Layer is a layer blob written to a layout
+type Layer struct {
+	// Descriptor describes the compressed blob
+	Descriptor v1.Descriptor
+	// DiffID is the digest of the uncompressed layer, as used by image configs
+	DiffID digest.Digest
+}
+
+// Image is an image of a layout
+type Image struct {
+	Descriptor v1.Descriptor
+	Manifest   v1.Manifest
+	Config     v1.Image
+}
+
+// NewLayout opens the image layout in the directory, creating an empty one if
+// it does not exist yet.
+func NewLayout(path string) (*Layout, error) {
+	l := &Layout{Path: path}
+
+	if err := os.MkdirAll(filepath.Join(path, "blobs", string(digest.Canonical)), 0755); err != nil {
+		return nil, err
+	}
+
+	layoutFile := filepath.Join(path, v1.ImageLayoutFile)
+	if _, err := os.Stat(layoutFile); os.IsNotExist(err) {
+		contents, err := json.Marshal(v1.ImageLayout{Version: v1.ImageLayoutVersion})
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(layoutFile, contents, 0644); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(l.indexPath()); os.IsNotExist(err) {
+		if err := l.writeIndex(v1.Index{Versioned: specs.Versioned{SchemaVersion: 2}}); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// OpenLayout opens an existing image layout
+func OpenLayout(path string) (*Layout, error) {
+	if _, err := os.Stat(filepath.Join(path, v1.ImageLayoutFile)); err != nil {
+		return nil, fmt.Errorf("%s is not an OCI image layout: %v", path, err)
+	}
+	return &Layout{Path: path}, nil
+}
+
+func (l *Layout) indexPath() string {
+	return filepath.Join(l.Path, "index.json")
+}
+
+// BlobPath returns the path of the blob with the digest
+func (l *Layout) BlobPath(d digest.Digest) string {
+	return filepath.Join(l.Path, "blobs", string(d.Algorithm()), d.Encoded())
+}
+
+// HasBlob returns whether the layout holds the blob with the digest
+func (l *Layout) HasBlob(d digest.Digest) bool {
+	_, err := os.Stat(l.BlobPath(d))
+	return err == nil
+}
+
+// ReadBlob returns the contents of a blob
+func (l *Layout) ReadBlob(d digest.Digest) ([]byte, error) {
+	return ioutil.ReadFile(l.BlobPath(d))
+}
+
+// WriteBlob writes a blob with the contents, and returns its descriptor
+func (l *Layout) WriteBlob(mediaType string, contents []byte) (v1.Descriptor, error) {
+	desc := v1.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(contents),
+		Size:      int64(len(contents)),
+	}
+	if l.HasBlob(desc.Digest) {
+		return desc, nil
+	}
+	return desc, l.writeFile(l.BlobPath(desc.Digest), func(w io.Writer) error {
+		_, err := w.Write(contents)
+		return err
+	})
+}
+
+// CopyBlob copies a blob from another layout, unless it is already present
+func (l *Layout) CopyBlob(from *Layout, desc v1.Descriptor) error {
+	if l.HasBlob(desc.Digest) {
+		return nil
+	}
+	source, err := os.Open(from.BlobPath(desc.Digest))
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	return l.writeFile(l.BlobPath(desc.Digest), func(w io.Writer) error {
+		_, err := io.Copy(w, source)
+		return err
+	})
+}
+
+// WriteLayer writes a gzip compressed layer, with the contents written to the
+// tar stream by the populator.
+func (l *Layout) WriteLayer(populate func(*tar.Writer) error) (Layer, error) {
+	temp, err := ioutil.TempFile(filepath.Join(l.Path, "blobs"), "layer-")
+	if err != nil {
+		return Layer{}, err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	compressedDigester := digest.Canonical.Digester()
+	counter := &countingWriter{writer: io.MultiWriter(temp, compressedDigester.Hash())}
+	gzipWriter := gzip.NewWriter(counter)
+	diffIDDigester := digest.Canonical.Digester()
+	tarWriter := tar.NewWriter(io.MultiWriter(gzipWriter, diffIDDigester.Hash()))
+
+	if err := populate(tarWriter); err != nil {
+		return Layer{}, err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return Layer{}, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return Layer{}, err
+	}
+	if err := temp.Close(); err != nil {
+		return Layer{}, err
+	}
+
+	layer := Layer{
+		Descriptor: v1.Descriptor{
+			MediaType: v1.MediaTypeImageLayerGzip,
+			Digest:    compressedDigester.Digest(),
+			Size:      counter.count,
+		},
+		DiffID: diffIDDigester.Digest(),
+	}
+	if err := os.Rename(temp.Name(), l.BlobPath(layer.Descriptor.Digest)); err != nil {
+		return Layer{}, err
+	}
+	return layer, nil
+}
+
+// Image returns the image with the reference name, e.g. "fissile-nats:1.0"
+func (l *Layout) Image(ref string) (*Image, error) {
+	index, err := l.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[v1.AnnotationRefName] != ref {
+			continue
+		}
+
+		image := &Image{Descriptor: desc}
+		contents, err := l.ReadBlob(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(contents, &image.Manifest); err != nil {
+			return nil, fmt.Errorf("Error reading the manifest of %s: %v", ref, err)
+		}
+		contents, err = l.ReadBlob(image.Manifest.Config.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(contents, &image.Config); err != nil {
+			return nil, fmt.Errorf("Error reading the config of %s: %v", ref, err)
+		}
+		return image, nil
+	}
+	return nil, fmt.Errorf("Image %s not found in %s", ref, l.Path)
+}
+
+// HasImage returns whether the layout holds an image with the reference name
+func (l *Layout) HasImage(ref string) (bool, error) {
+	index, err := l.readIndex()
+	if err != nil {
+		return false, err
+	}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[v1.AnnotationRefName] == ref {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// WriteImage writes the config and manifest of an image with the layers,
+// which must already be in the layout, and adds it to the index under the
+// reference name, replacing any image of the same name.
+func (l *Layout) WriteImage(ref string, config v1.Image, layers []v1.Descriptor) (*Image, error) {
+	contents, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	configDesc, err := l.WriteBlob(v1.MediaTypeImageConfig, contents)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := v1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Config:    configDesc,
+		Layers:    layers,
+	}
+	contents, err = json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	manifestDesc, err := l.WriteBlob(v1.MediaTypeImageManifest, contents)
+	if err != nil {
+		return nil, err
+	}
+	manifestDesc.Annotations = map[string]string{v1.AnnotationRefName: ref}
+	manifestDesc.Platform = &v1.Platform{Architecture: config.Architecture, OS: config.OS}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	index, err := l.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	var manifests []v1.Descriptor
+	for _, desc := range index.Manifests {
+		if desc.Annotations[v1.AnnotationRefName] != ref {
+			manifests = append(manifests, desc)
+		}
+	}
+	index.Manifests = append(manifests, manifestDesc)
+	if err := l.writeIndex(index); err != nil {
+		return nil, err
+	}
+
+	return &Image{Descriptor: manifestDesc, Manifest: manifest, Config: config}, nil
+}
+
+func (l *Layout) readIndex() (v1.Index, error) {
+	var index v1.Index
+	contents, err := ioutil.ReadFile(l.indexPath())
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(contents, &index); err != nil {
+		return index, fmt.Errorf("Error reading the index of %s: %v", l.Path, err)
+	}
+	return index, nil
+}
+
+func (l *Layout) writeIndex(index v1.Index) error {
+	contents, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return l.writeFile(l.indexPath(), func(w io.Writer) error {
+		_, err := w.Write(contents)
+		return err
+	})
+}
+
+// writeFile writes a file through a temporary file, so that concurrent
+// readers never see partial contents
+func (l *Layout) writeFile(path string, write func(io.Writer) error) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	if err := write(temp); err != nil {
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
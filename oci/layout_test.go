@@ -0,0 +1,126 @@
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestLayer(t *testing.T, layout *Layout, name, contents string) Layer {
+	layer, err := layout.WriteLayer(func(tarWriter *tar.Writer) error {
+		err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))})
+		if err != nil {
+			return err
+		}
+		_, err = tarWriter.Write([]byte(contents))
+		return err
+	})
+	require.NoError(t, err)
+	return layer
+}
+
+func TestLayoutWriteLayer(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := ioutil.TempDir("", "fissile-oci-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	layout, err := NewLayout(workDir)
+	require.NoError(t, err)
+	assert.FileExists(workDir + "/oci-layout")
+	assert.FileExists(workDir + "/index.json")
+
+	layer := writeTestLayer(t, layout, "etc/motd", "hello")
+	assert.Equal(v1.MediaTypeImageLayerGzip, layer.Descriptor.MediaType)
+	assert.True(layout.HasBlob(layer.Descriptor.Digest))
+
+	info, err := os.Stat(layout.BlobPath(layer.Descriptor.Digest))
+	require.NoError(t, err)
+	assert.Equal(info.Size(), layer.Descriptor.Size)
+
+	// The blob is the compressed layer, the diff ID the uncompressed one
+	blob, err := os.Open(layout.BlobPath(layer.Descriptor.Digest))
+	require.NoError(t, err)
+	defer blob.Close()
+	verifier := layer.Descriptor.Digest.Verifier()
+	gzipReader, err := gzip.NewReader(io.TeeReader(blob, verifier))
+	require.NoError(t, err)
+	diffID, err := digest.Canonical.FromReader(gzipReader)
+	require.NoError(t, err)
+	assert.Equal(layer.DiffID, diffID)
+	assert.True(verifier.Verified())
+}
+
+func TestLayoutWriteImage(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := ioutil.TempDir("", "fissile-oci-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	layout, err := NewLayout(workDir)
+	require.NoError(t, err)
+
+	hasImage, err := layout.HasImage("fissile-nats:1.0")
+	require.NoError(t, err)
+	assert.False(hasImage)
+	_, err = layout.Image("fissile-nats:1.0")
+	assert.Error(err)
+
+	layer := writeTestLayer(t, layout, "etc/motd", "hello")
+	config := v1.Image{Architecture: "amd64", OS: "linux"}
+	config.RootFS.Type = "layers"
+	config.RootFS.DiffIDs = []digest.Digest{layer.DiffID}
+	_, err = layout.WriteImage("fissile-nats:1.0", config, []v1.Descriptor{layer.Descriptor})
+	require.NoError(t, err)
+
+	// Writing an image of the same name replaces it
+	otherLayer := writeTestLayer(t, layout, "etc/motd", "bye")
+	config.RootFS.DiffIDs = []digest.Digest{otherLayer.DiffID}
+	written, err := layout.WriteImage("fissile-nats:1.0", config, []v1.Descriptor{otherLayer.Descriptor})
+	require.NoError(t, err)
+
+	// Other instances of the layout see the image
+	reopened, err := OpenLayout(workDir)
+	require.NoError(t, err)
+	hasImage, err = reopened.HasImage("fissile-nats:1.0")
+	require.NoError(t, err)
+	assert.True(hasImage)
+	image, err := reopened.Image("fissile-nats:1.0")
+	require.NoError(t, err)
+	assert.Equal(written.Descriptor.Digest, image.Descriptor.Digest)
+	assert.Equal([]v1.Descriptor{otherLayer.Descriptor}, image.Manifest.Layers)
+	assert.Equal(config.RootFS.DiffIDs, image.Config.RootFS.DiffIDs)
+
+	index, err := reopened.readIndex()
+	require.NoError(t, err)
+	assert.Len(index.Manifests, 1)
+
+	// Blobs can be copied between layouts
+	copyDir, err := ioutil.TempDir("", "fissile-oci-")
+	require.NoError(t, err)
+	defer os.RemoveAll(copyDir)
+	copied, err := NewLayout(copyDir)
+	require.NoError(t, err)
+	require.NoError(t, copied.CopyBlob(layout, otherLayer.Descriptor))
+	assert.True(copied.HasBlob(otherLayer.Descriptor.Digest))
+	assert.False(copied.HasBlob(layer.Descriptor.Digest))
+}
+
+func TestOpenLayoutMissing(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "fissile-oci-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	_, err = OpenLayout(workDir)
+	assert.Error(t, err)
+}
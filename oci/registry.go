@@ -0,0 +1,301 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"code.cloudfoundry.org/fissile/util"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DefaultRegistry is the registry of image names without a registry host
+const DefaultRegistry = "registry-1.docker.io"
+
+// Reference is a parsed image name, e.g. docker.io/org/fissile-nats:1.0
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+}
+
+// ParseReference parses an image name the way docker does: the first
+// component is the registry host if it looks like one, and images of the
+// default registry without an organization are in "library".
+func ParseReference(name string) (Reference, error) {
+	var ref Reference
+
+	remainder := name
+	if slash := strings.Index(remainder, "/"); slash >= 0 {
+		host := remainder[:slash]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.Registry = host
+			remainder = remainder[slash+1:]
+		}
+	}
+	if ref.Registry == "" || ref.Registry == "docker.io" || ref.Registry == "index.docker.io" {
+		ref.Registry = DefaultRegistry
+		if !strings.Contains(remainder, "/") {
+			remainder = "library/" + remainder
+		}
+	}
+
+	ref.Tag = "latest"
+	if colon := strings.LastIndex(remainder, ":"); colon > strings.LastIndex(remainder, "/") {
+		ref.Tag = remainder[colon+1:]
+		remainder = remainder[:colon]
+	}
+	ref.Repository = remainder
+
+	if ref.Repository == "" || ref.Tag == "" {
+		return ref, fmt.Errorf("Invalid image name %s", name)
+	}
+	return ref, nil
+}
+
+// Registry pushes images to a registry using the HTTP API V2 of the docker
+// distribution specification.
+type Registry struct {
+	Host     string
+	Username string
+	Password string
+	// Insecure uses plain HTTP instead of HTTPS
+	Insecure bool
+	Client   *http.Client
+
+	// token is the bearer token of the last authentication challenge
+	token string
+}
+
+// NewRegistry returns a client of the registry, using plain HTTP for
+// registries on the local host. The proxy and CA settings of the options
+// apply to all requests.
+func NewRegistry(host, username, password string, options util.HTTPOptions) (*Registry, error) {
+	transport, err := util.NewHTTPTransport(options)
+	if err != nil {
+		return nil, err
+	}
+	hostname := strings.Split(host, ":")[0]
+	return &Registry{
+		Host:     host,
+		Username: username,
+		Password: password,
+		Insecure: hostname == "localhost" || hostname == "127.0.0.1",
+		Client:   &http.Client{Transport: transport},
+	}, nil
+}
+
+func (r *Registry) url(path string) string {
+	scheme := "https"
+	if r.Insecure {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s", scheme, r.Host, path)
+}
+
+// Push uploads the image of the layout to the repository of the registry,
+// tagged with the given tag. Blobs already present in the registry are
+// skipped.
+func (r *Registry) Push(layout *Layout, image *Image, repository, tag string) error {
+	blobs := append([]v1.Descriptor{image.Manifest.Config}, image.Manifest.Layers...)
+	for _, blob := range blobs {
+		if err := r.pushBlob(layout, repository, blob); err != nil {
+			return fmt.Errorf("Error pushing blob %s to %s: %v", blob.Digest, repository, err)
+		}
+	}
+
+	manifest, err := layout.ReadBlob(image.Descriptor.Digest)
+	if err != nil {
+		return err
+	}
+	response, err := r.do("PUT", r.url(fmt.Sprintf("%s/manifests/%s", repository, tag)), repository,
+		v1.MediaTypeImageManifest, func() io.Reader { return bytes.NewReader(manifest) })
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error pushing the manifest of %s:%s: %s", repository, tag, responseError(response))
+	}
+	return nil
+}
+
+// pushBlob uploads a blob in a single request, unless the registry has it
+func (r *Registry) pushBlob(layout *Layout, repository string, blob v1.Descriptor) error {
+	response, err := r.do("HEAD", r.url(fmt.Sprintf("%s/blobs/%s", repository, blob.Digest)), repository, "", nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	response, err = r.do("POST", r.url(fmt.Sprintf("%s/blobs/uploads/", repository)), repository, "", nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("Error starting the upload: %s", responseError(response))
+	}
+
+	location, err := response.Request.URL.Parse(response.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("Invalid upload location: %v", err)
+	}
+	query := location.Query()
+	query.Set("digest", blob.Digest.String())
+	location.RawQuery = query.Encode()
+
+	var file *os.File
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+	response, err = r.do("PUT", location.String(), repository, "application/octet-stream", func() io.Reader {
+		if file != nil {
+			file.Close()
+		}
+		file, err = os.Open(layout.BlobPath(blob.Digest))
+		if err != nil {
+			return &errorReader{err}
+		}
+		return file
+	})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		return fmt.Errorf("Error uploading: %s", responseError(response))
+	}
+	return nil
+}
+
+// do sends a request, authenticating as required by the registry. The body
+// function is called for each attempt.
+func (r *Registry) do(method, target, repository, contentType string, body func() io.Reader) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		var reader io.Reader
+		if body != nil {
+			reader = body()
+		}
+		request, err := http.NewRequest(method, target, reader)
+		if err != nil {
+			return nil, err
+		}
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		if r.token != "" {
+			request.Header.Set("Authorization", "Bearer "+r.token)
+		} else if r.Username != "" {
+			request.SetBasicAuth(r.Username, r.Password)
+		}
+		return r.Client.Do(request)
+	}
+
+	response, err := send()
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+	challenge := response.Header.Get("WWW-Authenticate")
+	response.Body.Close()
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return nil, fmt.Errorf("Unauthorized to access %s", repository)
+	}
+	if err := r.authenticate(challenge, repository); err != nil {
+		return nil, err
+	}
+	return send()
+}
+
+// authenticate fetches a bearer token as requested by the challenge
+func (r *Registry) authenticate(challenge, repository string) error {
+	params := map[string]string{}
+	for _, param := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		parts := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(parts) == 2 {
+			params[parts[0]] = strings.Trim(parts[1], `"`)
+		}
+	}
+	if params["realm"] == "" {
+		return fmt.Errorf("Invalid authentication challenge %q", challenge)
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull,push", repository)
+	}
+	query.Set("scope", scope)
+
+	request, err := http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if r.Username != "" {
+		request.SetBasicAuth(r.Username, r.Password)
+	}
+	response, err := r.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error authenticating to %s: %s", r.Host, responseError(response))
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return fmt.Errorf("Error reading the token of %s: %v", r.Host, err)
+	}
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+	return nil
+}
+
+// responseError describes an unexpected response
+func responseError(response *http.Response) string {
+	contents, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+	return strings.TrimSpace(fmt.Sprintf("%s %s", response.Status, contents))
+}
+
+// errorReader fails all reads with the error
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// PushImage pushes the image of the layout under the image name, e.g.
+// "docker.io/org/fissile-nats:1.0", to the registry named by it.
+func PushImage(layout *Layout, image *Image, name, username, password string, options util.HTTPOptions) error {
+	ref, err := ParseReference(name)
+	if err != nil {
+		return err
+	}
+	registry, err := NewRegistry(ref.Registry, username, password, options)
+	if err != nil {
+		return err
+	}
+	return registry.Push(layout, image, ref.Repository, ref.Tag)
+}
@@ -0,0 +1,211 @@
+package oci
+
+import (
+	"archive/tar"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"code.cloudfoundry.org/fissile/util"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReference(t *testing.T) {
+	for name, expected := range map[string]Reference{
+		"fissile-nats":                     {DefaultRegistry, "library/fissile-nats", "latest"},
+		"org/fissile-nats:1.0":             {DefaultRegistry, "org/fissile-nats", "1.0"},
+		"docker.io/org/fissile-nats:1.0":   {DefaultRegistry, "org/fissile-nats", "1.0"},
+		"localhost:5000/fissile-nats:1.0":  {"localhost:5000", "fissile-nats", "1.0"},
+		"registry.example.com/a/b/c":       {"registry.example.com", "a/b/c", "latest"},
+		"registry.example.com:443/org/x:y": {"registry.example.com:443", "org/x", "y"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ref, err := ParseReference(name)
+			require.NoError(t, err)
+			assert.Equal(t, expected, ref)
+		})
+	}
+
+	_, err := ParseReference("org/fissile-nats:")
+	assert.Error(t, err)
+}
+
+// testRegistry is a minimal registry storing blobs and manifests in memory,
+// requiring a bearer token from its token endpoint
+type testRegistry struct {
+	mutex     sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if request.URL.Path == "/token" {
+		username, password, _ := request.BasicAuth()
+		if username != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token": "sesame"}`)
+		return
+	}
+	if request.Header.Get("Authorization") != "Bearer sesame" {
+		scheme := "http"
+		if request.TLS != nil {
+			scheme = "https"
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s://%s/token",service="test"`, scheme, request.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(request.URL.Path, "/v2/org/nats/")
+	switch {
+	case request.Method == "HEAD" && strings.HasPrefix(path, "blobs/"):
+		if _, ok := r.blobs[strings.TrimPrefix(path, "blobs/")]; ok {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case request.Method == "POST" && path == "blobs/uploads/":
+		w.Header().Set("Location", "/v2/org/nats/blobs/uploads/1234?state=x")
+		w.WriteHeader(http.StatusAccepted)
+	case request.Method == "PUT" && path == "blobs/uploads/1234":
+		contents, _ := ioutil.ReadAll(request.Body)
+		d := request.URL.Query().Get("digest")
+		if request.URL.Query().Get("state") != "x" || digest.FromBytes(contents).String() != d {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[d] = contents
+		r.uploads++
+		w.WriteHeader(http.StatusCreated)
+	case request.Method == "PUT" && strings.HasPrefix(path, "manifests/"):
+		if request.Header.Get("Content-Type") != v1.MediaTypeImageManifest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		contents, _ := ioutil.ReadAll(request.Body)
+		r.manifests[strings.TrimPrefix(path, "manifests/")] = contents
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRegistryPush(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := ioutil.TempDir("", "fissile-oci-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	layout, err := NewLayout(workDir)
+	require.NoError(t, err)
+	layer := writeTestLayer(t, layout, "etc/motd", "hello")
+	config := v1.Image{Architecture: "amd64", OS: "linux"}
+	config.RootFS.DiffIDs = []digest.Digest{layer.DiffID}
+	image, err := layout.WriteImage("org/nats:1.0", config, []v1.Descriptor{layer.Descriptor})
+	require.NoError(t, err)
+
+	registry := &testRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	client, err := NewRegistry(serverURL.Host, "user", "secret", util.HTTPOptions{})
+	require.NoError(t, err)
+	assert.True(client.Insecure)
+	require.NoError(t, client.Push(layout, image, "org/nats", "1.0"))
+
+	assert.Equal(2, registry.uploads)
+	assert.Contains(registry.blobs, layer.Descriptor.Digest.String())
+	assert.Contains(registry.blobs, image.Manifest.Config.Digest.String())
+	manifest, err := layout.ReadBlob(image.Descriptor.Digest)
+	require.NoError(t, err)
+	assert.Equal(manifest, registry.manifests["1.0"])
+
+	// Blobs already in the registry are not uploaded again
+	require.NoError(t, client.Push(layout, image, "org/nats", "1.1"))
+	assert.Equal(2, registry.uploads)
+	assert.Contains(registry.manifests, "1.1")
+
+	// Wrong credentials fail
+	client, err = NewRegistry(serverURL.Host, "user", "wrong", util.HTTPOptions{})
+	require.NoError(t, err)
+	assert.Error(client.Push(layout, image, "org/nats", "1.2"))
+}
+
+func TestRegistryPushCACert(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "fissile-oci-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	layout, err := NewLayout(workDir)
+	require.NoError(t, err)
+	layer := writeTestLayer(t, layout, "etc/motd", "hello")
+	image, err := layout.WriteImage("org/nats:1.0", v1.Image{}, []v1.Descriptor{layer.Descriptor})
+	require.NoError(t, err)
+
+	registry := &testRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	// The test server uses a self-signed certificate, not trusted by default
+	client, err := NewRegistry(serverURL.Host, "user", "secret", util.HTTPOptions{})
+	require.NoError(t, err)
+	client.Insecure = false
+	assert.Error(t, client.Push(layout, image, "org/nats", "1.0"))
+
+	caFile := filepath.Join(workDir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, ioutil.WriteFile(caFile, caPEM, 0600))
+
+	client, err = NewRegistry(serverURL.Host, "user", "secret", util.HTTPOptions{CACertFile: caFile})
+	require.NoError(t, err)
+	client.Insecure = false
+	require.NoError(t, client.Push(layout, image, "org/nats", "1.0"))
+	assert.Contains(t, registry.manifests, "1.0")
+}
+
+func TestRegistryPushMissingBlob(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "fissile-oci-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	layout, err := NewLayout(workDir)
+	require.NoError(t, err)
+	layer, err := layout.WriteLayer(func(*tar.Writer) error { return nil })
+	require.NoError(t, err)
+	image, err := layout.WriteImage("org/nats:1.0", v1.Image{}, []v1.Descriptor{layer.Descriptor})
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(layout.BlobPath(layer.Descriptor.Digest)))
+
+	registry := &testRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	client, err := NewRegistry(serverURL.Host, "user", "secret", util.HTTPOptions{})
+	require.NoError(t, err)
+	assert.Error(t, client.Push(layout, image, "org/nats", "1.0"))
+	assert.Empty(t, registry.manifests)
+}
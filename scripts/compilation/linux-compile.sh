@@ -48,5 +48,16 @@ fi
 cd "${BOSH_COMPILE_TARGET}"
 bash ./packaging
 
+# Report the peak memory of the compilation container (cgroup v2, or v1) for
+# the auto-tuning of later runs; fissile removes the file
+if test -d "/fissile-out" ; then
+  for peak in /sys/fs/cgroup/memory.peak /sys/fs/cgroup/memory/memory.max_usage_in_bytes ; do
+    if test -r "${peak}" ; then
+      cat "${peak}" > "/fissile-out/.fissile-peak-memory"
+      break
+    fi
+  done
+fi
+
 chown -R "${HOST_USERID}:${HOST_USERGID}" "$(readlink --canonicalize "${BOSH_INSTALL_TARGET}")" 2>/dev/null \
   || echo "Warning - could not change ownership of compiled artifacts" 1>&2
@@ -31,10 +31,15 @@ if test -n "${1:-}" ; then
         # stdout is expected to be a number, possibly followed by a new line
         # If it is >= 0, wait that many seconds and go to next script
         # If it is < 0, sleep for that many seconds, then retry
-        if test "${output}" -lt 0 ; then
-            sleep $(( 0 - output ))
-        else
-            sleep "${output}"
+        wait="${output#-}"
+        if test -n "${PRE_STOP_DEADLINE:-}" && test "$(( $(date +%s) + wait ))" -gt "${PRE_STOP_DEADLINE}" ; then
+            # Waiting would run out the termination grace period before the
+            # processes are stopped
+            printf "Drain script for %s exceeds the termination grace period of %s seconds\n" "$1" "${TERMINATION_GRACE_PERIOD_SECONDS}" >&2
+            break
+        fi
+        sleep "${wait}"
+        if test "${output}" -ge 0 ; then
             break
         fi
     done
@@ -44,6 +49,13 @@ fi
 set -o errexit
 echo "Running pre-stop script..."
 
+# The drain scripts have to finish within the termination grace period of the
+# pod, leaving some time for stopping the processes
+if test -n "${TERMINATION_GRACE_PERIOD_SECONDS:-}" ; then
+    export PRE_STOP_DEADLINE="$(( $(date +%s) + TERMINATION_GRACE_PERIOD_SECONDS - TERMINATION_GRACE_PERIOD_SECONDS / 10 ))"
+    echo "Termination grace period: ${TERMINATION_GRACE_PERIOD_SECONDS} seconds"
+fi
+
 {{ if ne .instance_group.Type "bosh-task" }}
     processes=($(/var/vcap/bosh/bin/monit summary | awk '$1 == "Process" { print $2 }' | tr -d "'"))
 
@@ -131,6 +131,11 @@ ln -s /var/vcap/jobs /var/vcap/data/jobs
 ln -s /var/vcap/packages /var/vcap/data/packages
 ln -s /var/vcap/sys /var/vcap/data/sys
 
+# Load the secrets written by the Vault Agent injector, if any.
+if [ -f /vault/secrets/fissile.env ]; then
+  source /vault/secrets/fissile.env
+fi
+
 # Run custom environment scripts (that are sourced).
 {{- range $script := .instance_group.EnvironScripts }}
 source {{ script_path $script }}
@@ -141,10 +146,16 @@ source {{ script_path $script }}
 bash {{ script_path $script }}
 {{- end }}
 
+# Instance groups tagged no-deployment-manifest don't mount the manifest.
+deployment_manifest_args=()
+if [ -f /opt/fissile/config/deployment-manifest.yml ]; then
+  deployment_manifest_args=(--bosh-deployment-manifest /opt/fissile/config/deployment-manifest.yml)
+fi
+
 configgin \
   --jobs /opt/fissile/job_config.json \
   --env2conf /opt/fissile/env2conf.yml \
-  --bosh-deployment-manifest /opt/fissile/config/deployment-manifest.yml
+  "${deployment_manifest_args[@]}"
 
 # Unset all secrets
 {{- range $secret := .secrets }}
@@ -2,8 +2,13 @@ package util
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 
@@ -12,26 +17,105 @@ import (
 
 type progressDelegate func(int)
 
+// HTTPOptions describes how remote servers are reached when downloading
+type HTTPOptions struct {
+	// Proxy is the URL of the HTTP(S) proxy to use; if empty, the usual
+	// http_proxy / https_proxy / no_proxy environment variables are honored
+	Proxy string
+	// CACertFile is the path to a PEM bundle of additional trusted CAs
+	CACertFile string
+}
+
+// NewHTTPTransport returns a transport configured with the given proxy and
+// certificate authority settings. Local files can be fetched via file:// URLs.
+func NewHTTPTransport(options HTTPOptions) (*http.Transport, error) {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+
+	if options.Proxy != "" {
+		proxyURL, err := url.Parse(options.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy URL %s: %s", options.Proxy, err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if options.CACertFile != "" {
+		pool, err := caCertPool(options.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return transport, nil
+}
+
+// TrustCACertsByDefault adds the CA certificates of the options to the
+// trusted CAs of http.DefaultTransport, for the clients of libraries which
+// don't take a transport, e.g. those of the package cache stores.
+func TrustCACertsByDefault(options HTTPOptions) error {
+	if options.CACertFile == "" {
+		return nil
+	}
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("Unexpected default HTTP transport %T", http.DefaultTransport)
+	}
+	pool, err := caCertPool(options.CACertFile)
+	if err != nil {
+		return err
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.RootCAs = pool
+	return nil
+}
+
+// caCertPool returns the system CAs, plus those of the PEM bundle
+func caCertPool(caCertFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading CA certificates: %s", err.Error())
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in %s", caCertFile)
+	}
+	return pool, nil
+}
+
 // DownloadFile will download a url to a local file. It's efficient because it will
 // write as it downloads and not load the whole file into memory.
-func DownloadFile(filepath string, url string, progressEvent progressDelegate) error {
+func DownloadFile(filepath string, url string, options HTTPOptions, progressEvent progressDelegate) error {
 
-	// Create the file
-	out, err := os.Create(filepath)
+	transport, err := NewHTTPTransport(options)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
 
-	// Get the data
-	transport := &http.Transport{}
-	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	// Get the data before creating the file, so that failed downloads don't
+	// leave empty files behind
 	httpClient := &http.Client{Transport: transport}
 	resp, err := httpClient.Get(url)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error downloading %s: %s", url, resp.Status)
+	}
+
+	// Create the file
+	out, err := os.Create(filepath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
 
 	size := resp.ContentLength
 	reader := progress.NewReader(resp.Body)
@@ -48,6 +132,8 @@ func DownloadFile(filepath string, url string, progressEvent progressDelegate) e
 	// Write the body to file
 	_, err = io.Copy(out, reader)
 	if err != nil {
+		out.Close()
+		os.Remove(filepath)
 		return err
 	}
 
@@ -2,9 +2,12 @@ package util
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/pem"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
@@ -71,7 +74,7 @@ func TestDownloadFile(test *testing.T) {
 
 	go server.Serve(listener)
 
-	downloaderr := DownloadFile(tempFile, u.String(), func(i int) {})
+	downloaderr := DownloadFile(tempFile, u.String(), HTTPOptions{}, func(i int) {})
 	if downloaderr != nil {
 		test.Fatal(downloaderr)
 	}
@@ -93,6 +96,83 @@ func TestDownloadFile(test *testing.T) {
 	}
 }
 
+func TestDownloadFileWithCACert(test *testing.T) {
+	var testData = "Fissile test data"
+
+	dir, err := ioutil.TempDir("", "test_download_ca")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewTLSServer(servetestdata([]byte(testData)))
+	defer server.Close()
+
+	tempFile := path.Join(dir, "test_download")
+
+	// The test server uses a self-signed certificate, which is not trusted by default
+	err = DownloadFile(tempFile, server.URL, HTTPOptions{}, func(i int) {})
+	if err == nil {
+		test.Fatal("Expected download with an untrusted certificate to fail")
+	}
+	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
+		test.Fatal("Expected a failed download to leave no file behind")
+	}
+
+	caFile := path.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err = ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		test.Fatal(err)
+	}
+
+	err = DownloadFile(tempFile, server.URL, HTTPOptions{CACertFile: caFile}, func(i int) {})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(tempFile)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if string(b) != testData {
+		test.Fatal("File corrupted: " + string(b))
+	}
+
+	_, err = NewHTTPTransport(HTTPOptions{CACertFile: path.Join(dir, "missing.pem")})
+	if err == nil {
+		test.Fatal("Expected a missing CA bundle to be reported")
+	}
+}
+
+func TestTrustCACertsByDefault(test *testing.T) {
+	dir, err := ioutil.TempDir("", "test_default_ca")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewTLSServer(servetestdata([]byte("Fissile test data")))
+	defer server.Close()
+
+	caFile := path.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err = ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		test.Fatal(err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport)
+	defer func(config *tls.Config) { transport.TLSClientConfig = config }(transport.TLSClientConfig)
+
+	if err = TrustCACertsByDefault(HTTPOptions{CACertFile: caFile}); err != nil {
+		test.Fatal(err)
+	}
+	response, err := (&http.Client{}).Get(server.URL)
+	if err != nil {
+		test.Fatal(err)
+	}
+	response.Body.Close()
+}
+
 func servetestdata(b []byte) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write(b)
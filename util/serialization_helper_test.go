@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"testing"
 
-	"code.cloudfoundry.org/fissile/testhelpers"
+	"code.cloudfoundry.org/fissile/yamltest"
 	"github.com/stretchr/testify/assert"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -89,7 +89,7 @@ func TestMarshalAdapter(t *testing.T) {
 	if yamlResult, err := yaml.Marshal(adapter); assert.NoError(err) {
 		var unmarshalled interface{}
 		if assert.NoError(yaml.Unmarshal(yamlResult, &unmarshalled)) {
-			testhelpers.IsYAMLSubset(assert, 3, unmarshalled)
+			yamltest.IsYAMLSubset(assert, 3, unmarshalled)
 		}
 	}
 }
@@ -1,4 +1,4 @@
-// +build windows unix !darwin
+// +build !darwin
 
 package util
 
@@ -0,0 +1,82 @@
+package validation
+
+// Codes of the individual validations. Errors carry the code of the
+// validation reporting them, and fall back to the code of their error type
+// (FISSILE-V001 to FISSILE-V010) otherwise. Codes are stable, so that known
+// exceptions can be suppressed; they must never be reused for a different
+// validation.
+const (
+	// Loading the role manifest
+
+	CodeSchemaVersion          = "FISSILE-V101"
+	CodeVariableFilesLoad      = "FISSILE-V102"
+	CodeDefaultsFiles          = "FISSILE-V103"
+	CodeRuntimeConfigs         = "FISSILE-V104"
+	CodeTemplateKeys           = "FISSILE-V105"
+	CodeProbeProfiles          = "FISSILE-V106"
+	CodeInstanceGroupType      = "FISSILE-V107"
+	CodeFeatureExclusivity     = "FISSILE-V108"
+	CodeRoleRunCalculation     = "FISSILE-V109"
+	CodeRoleTags               = "FISSILE-V110"
+	CodeJobReferences          = "FISSILE-V111"
+	CodeInstanceGroupPrevNames = "FISSILE-V112"
+	CodeZones                  = "FISSILE-V113"
+	CodeInstanceGroupJobs      = "FISSILE-V114"
+	CodeLinks                  = "FISSILE-V115"
+	CodeVariableType           = "FISSILE-V116"
+	CodeVariablePreviousNames  = "FISSILE-V117"
+	CodeVariableDeprecations   = "FISSILE-V118"
+	CodeVariableValidations    = "FISSILE-V119"
+	CodeVariableFiles          = "FISSILE-V120"
+	CodeExternalSecrets        = "FISSILE-V121"
+	CodeVariableURLTemplates   = "FISSILE-V122"
+	CodeMinimumFissileVersion  = "FISSILE-V123"
+	CodeServiceAccounts        = "FISSILE-V124"
+	CodeAuthAggregation        = "FISSILE-V125"
+	CodeUnusedColocated        = "FISSILE-V126"
+	CodeColocatedPorts         = "FISSILE-V127"
+	CodeColocatedVolumes       = "FISSILE-V128"
+	CodeColocatedSysctls       = "FISSILE-V129"
+	CodeColocatedSockets       = "FISSILE-V130"
+	CodeMonitoring             = "FISSILE-V131"
+	CodeStemcells              = "FISSILE-V132"
+	CodeVariableDescriptions   = "FISSILE-V133"
+	CodeRuntimeScripts         = "FISSILE-V134"
+	CodeClusterScoped          = "FISSILE-V135"
+	CodeScripts                = "FISSILE-V136"
+
+	// The run section of instance groups
+
+	CodeRoleRun                = "FISSILE-V140"
+	CodeFlightStage            = "FISSILE-V141"
+	CodeHealthCheck            = "FISSILE-V142"
+	CodeMemory                 = "FISSILE-V143"
+	CodeCPU                    = "FISSILE-V144"
+	CodeBackup                 = "FISSILE-V145"
+	CodeJobSettings            = "FISSILE-V146"
+	CodeTerminationGracePeriod = "FISSILE-V147"
+	CodeSidecars               = "FISSILE-V148"
+
+	// Consistency of the role manifest, opinions and releases
+
+	CodeUnsortedTemplates       = "FISSILE-V160"
+	CodeUndefinedProperty       = "FISSILE-V161"
+	CodeUndefinedVariable       = "FISSILE-V162"
+	CodeUnusedVariable          = "FISSILE-V163"
+	CodeUntemplatedDarkOpinion  = "FISSILE-V164"
+	CodeDarkOpinionInLight      = "FISSILE-V165"
+	CodeDarkOpinionConflict     = "FISSILE-V166"
+	CodeDuplicateOpinion        = "FISSILE-V167"
+	CodeLightOpinionDefault     = "FISSILE-V168"
+	CodeTemplateExpansion       = "FISSILE-V169"
+	CodeConstantTemplate        = "FISSILE-V170"
+	CodeUnsortedVariables       = "FISSILE-V171"
+	CodeDeploymentManifestMount = "FISSILE-V172"
+	CodeClusterDomain           = "FISSILE-V173"
+	CodeValidatorPlugin         = "FISSILE-V174"
+
+	// Helm values files
+
+	CodeValuesVariables  = "FISSILE-V180"
+	CodeValuesProperties = "FISSILE-V181"
+)
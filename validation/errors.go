@@ -3,6 +3,8 @@ package validation
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -13,6 +15,15 @@ type Error struct {
 	Field    string
 	BadValue interface{}
 	Detail   string
+	// PluginCode is the code of the error chosen by the validator plugin
+	// reporting it
+	PluginCode string
+	// ValidationCode is the code of the validation reporting the error; see
+	// WithCode
+	ValidationCode string
+	// Warning is set for errors which are reported, but don't fail the
+	// validation
+	Warning bool
 }
 
 // Error implements the error interface.
@@ -43,6 +54,64 @@ func (v *Error) ErrorBody() string {
 	return s
 }
 
+// Code returns the stable error code of the validation reporting the error, or
+// the code chosen by the validator plugin reporting it. Errors not tagged with
+// either use the code of their type.
+func (v *Error) Code() string {
+	if v.PluginCode != "" {
+		return v.PluginCode
+	}
+	if v.ValidationCode != "" {
+		return v.ValidationCode
+	}
+	return v.Type.Code()
+}
+
+// WithCode tags the error with the code of the validation reporting it, unless
+// it already has one.
+func (v *Error) WithCode(code string) *Error {
+	if v.ValidationCode == "" && v.PluginCode == "" {
+		v.ValidationCode = code
+	}
+	return v
+}
+
+var instanceGroupField = regexp.MustCompile(`^instance_groups?\[([^\]]+)\]`)
+
+// Group returns a name to group related errors by, either the instance group
+// the field belongs to, or else the first segment of the field name.
+func (v *Error) Group() string {
+	if match := instanceGroupField.FindStringSubmatch(v.Field); match != nil {
+		return "instance_groups[" + match[1] + "]"
+	}
+	if index := strings.IndexAny(v.Field, ".["); index > 0 {
+		return v.Field[:index]
+	}
+	return v.Field
+}
+
+// MarshalJSON implements the encoding/json.Marshaler interface
+func (v *Error) MarshalJSON() ([]byte, error) {
+	// Values read from YAML may not be representable as JSON
+	value := v.BadValue
+	if _, err := json.Marshal(value); err != nil {
+		value = fmt.Sprintf("%v", value)
+	}
+	result := map[string]interface{}{
+		"code":    v.Code(),
+		"type":    string(v.Type),
+		"group":   v.Group(),
+		"field":   v.Field,
+		"value":   value,
+		"detail":  v.Detail,
+		"message": v.Error(),
+	}
+	if v.Warning {
+		result["warning"] = true
+	}
+	return json.Marshal(result)
+}
+
 // ErrorType is a machine readable value providing more detail about why
 // a field is invalid.
 type ErrorType string
@@ -76,6 +145,9 @@ const (
 	// ErrorTypeGeneral is used to report general errors without additional
 	// details.
 	ErrorTypeGeneral ErrorType = "GeneralError"
+	// ErrorTypePlugin is used to report violations of site policies found by
+	// validator plugins.  See PluginError().
+	ErrorTypePlugin ErrorType = "PluginError"
 	// ErrorTypeInternal is used to report other errors that are not related
 	// to user input.  See InternalError().
 	ErrorTypeInternal ErrorType = "InternalError"
@@ -100,6 +172,8 @@ func (t ErrorType) String() string {
 		return "Too long"
 	case ErrorTypeGeneral:
 		return "Error"
+	case ErrorTypePlugin:
+		return "Policy violation"
 	case ErrorTypeInternal:
 		return "Internal error"
 	default:
@@ -107,29 +181,56 @@ func (t ErrorType) String() string {
 	}
 }
 
+// errorCodes maps each error type to a stable code that can be used to refer
+// to the error type, e.g. for suppressing known exceptions. Codes must never be
+// reused for a different type.
+var errorCodes = map[ErrorType]string{
+	ErrorTypeNotFound:     "FISSILE-V001",
+	ErrorTypeRequired:     "FISSILE-V002",
+	ErrorTypeDuplicate:    "FISSILE-V003",
+	ErrorTypeInvalid:      "FISSILE-V004",
+	ErrorTypeNotSupported: "FISSILE-V005",
+	ErrorTypeForbidden:    "FISSILE-V006",
+	ErrorTypeTooLong:      "FISSILE-V007",
+	ErrorTypeGeneral:      "FISSILE-V008",
+	ErrorTypeInternal:     "FISSILE-V009",
+	ErrorTypePlugin:       "FISSILE-V010",
+}
+
+// unknownErrorCode is the code of errors of unrecognized types
+const unknownErrorCode = "FISSILE-V000"
+
+// Code returns the stable error code of the error type.
+func (t ErrorType) Code() string {
+	if code, ok := errorCodes[t]; ok {
+		return code
+	}
+	return unknownErrorCode
+}
+
 // NotFound returns a *Error indicating "value not found".  This is
 // used to report failure to find a requested value (e.g. looking up an ID).
 func NotFound(field string, value interface{}) *Error {
-	return &Error{ErrorTypeNotFound, field, value, ""}
+	return &Error{Type: ErrorTypeNotFound, Field: field, BadValue: value, Detail: ""}
 }
 
 // Required returns a *Error indicating "value required".  This is used
 // to report required values that are not provided (e.g. empty strings, null
 // values, or empty arrays).
 func Required(field string, detail string) *Error {
-	return &Error{ErrorTypeRequired, field, "", detail}
+	return &Error{Type: ErrorTypeRequired, Field: field, BadValue: "", Detail: detail}
 }
 
 // Duplicate returns a *Error indicating "duplicate value".  This is
 // used to report collisions of values that must be unique (e.g. names or IDs).
 func Duplicate(field string, value interface{}) *Error {
-	return &Error{ErrorTypeDuplicate, field, value, ""}
+	return &Error{Type: ErrorTypeDuplicate, Field: field, BadValue: value, Detail: ""}
 }
 
 // Invalid returns a *Error indicating "invalid value".  This is used
 // to report malformed values (e.g. failed regex match, too long, out of bounds).
 func Invalid(field string, value interface{}, detail string) *Error {
-	return &Error{ErrorTypeInvalid, field, value, detail}
+	return &Error{Type: ErrorTypeInvalid, Field: field, BadValue: value, Detail: detail}
 }
 
 // NotSupported returns a *Error indicating "unsupported value".
@@ -140,7 +241,7 @@ func NotSupported(field string, value interface{}, validValues []string) *Error
 	if validValues != nil && len(validValues) > 0 {
 		detail = "supported values: " + strings.Join(validValues, ", ")
 	}
-	return &Error{ErrorTypeNotSupported, field, value, detail}
+	return &Error{Type: ErrorTypeNotSupported, Field: field, BadValue: value, Detail: detail}
 }
 
 // Forbidden returns a *Error indicating "forbidden".  This is used to
@@ -148,7 +249,7 @@ func NotSupported(field string, value interface{}, validValues []string) *Error
 // some conditions, but which are not permitted by current conditions (e.g.
 // security policy).
 func Forbidden(field string, detail string) *Error {
-	return &Error{ErrorTypeForbidden, field, "", detail}
+	return &Error{Type: ErrorTypeForbidden, Field: field, BadValue: "", Detail: detail}
 }
 
 // TooLong returns a *Error indicating "too long".  This is used to
@@ -156,21 +257,28 @@ func Forbidden(field string, detail string) *Error {
 // Invalid, but the returned error will not include the too-long
 // value.
 func TooLong(field string, value interface{}, maxLength int) *Error {
-	return &Error{ErrorTypeTooLong, field, value, fmt.Sprintf("must have at most %d characters", maxLength)}
+	return &Error{Type: ErrorTypeTooLong, Field: field, BadValue: value, Detail: fmt.Sprintf("must have at most %d characters", maxLength)}
 }
 
 // GeneralError returns a *Error for a general failure.  This is used
 // to signal that an error was found that has no structured details.  The
 // err argument must be non-nil.
 func GeneralError(field string, err error) *Error {
-	return &Error{ErrorTypeGeneral, field, nil, err.Error()}
+	return &Error{Type: ErrorTypeGeneral, Field: field, BadValue: nil, Detail: err.Error()}
+}
+
+// PluginError returns a *Error reported by a validator plugin, with the
+// code chosen by the plugin.  If warning is set, the error doesn't fail the
+// validation.
+func PluginError(code, field string, value interface{}, detail string, warning bool) *Error {
+	return &Error{Type: ErrorTypePlugin, Field: field, BadValue: value, Detail: detail, PluginCode: code, Warning: warning}
 }
 
 // InternalError returns a *Error indicating "internal error".  This is used
 // to signal that an error was found that was not directly related to user
 // input.  The err argument must be non-nil.
 func InternalError(field string, err error) *Error {
-	return &Error{ErrorTypeInternal, field, nil, err.Error()}
+	return &Error{Type: ErrorTypeInternal, Field: field, BadValue: nil, Detail: err.Error()}
 }
 
 // ErrorList holds a set of Errors.  It is plausible that we might one day have
@@ -183,6 +291,15 @@ func (v ErrorList) Error() string {
 	return strings.Join(v.ErrorStrings(), "\n")
 }
 
+// WithCode tags all errors of the list without a code with the code of the
+// validation reporting them; see Error.WithCode.
+func (v ErrorList) WithCode(code string) ErrorList {
+	for _, item := range v {
+		item.WithCode(code)
+	}
+	return v
+}
+
 // ErrorStrings returns the underlying errors as a string slice, for testing
 func (v ErrorList) ErrorStrings() []string {
 	values := make([]string, 0, len(v))
@@ -193,3 +310,31 @@ func (v ErrorList) ErrorStrings() []string {
 
 	return values
 }
+
+// Failures returns the errors which fail the validation, i.e. all but the
+// warnings.
+func (v ErrorList) Failures() ErrorList {
+	failures := ErrorList{}
+	for _, item := range v {
+		if !item.Warning {
+			failures = append(failures, item)
+		}
+	}
+	return failures
+}
+
+// Groups returns the errors grouped by Error.Group(), and the sorted list of
+// group names.
+func (v ErrorList) Groups() ([]string, map[string]ErrorList) {
+	groups := map[string]ErrorList{}
+	var names []string
+	for _, item := range v {
+		group := item.Group()
+		if _, ok := groups[group]; !ok {
+			names = append(names, group)
+		}
+		groups[group] = append(groups[group], item)
+	}
+	sort.Strings(names)
+	return names, groups
+}
@@ -1,10 +1,17 @@
 package validation
 
 import (
+	"encoding/json"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMakeFuncs(t *testing.T) {
@@ -74,3 +81,82 @@ func TestErrorUsefulMessage(t *testing.T) {
 		assert.Contains(t, s, part)
 	}
 }
+
+func TestErrorCodes(t *testing.T) {
+	codes := map[string]ErrorType{}
+	for errorType := range errorCodes {
+		code := errorType.Code()
+		assert.Regexp(t, `^FISSILE-V\d{3}$`, code)
+		assert.NotContains(t, codes, code, "Code %s used for both %s and %s", code, codes[code], errorType)
+		codes[code] = errorType
+		// Every error type needs a code
+		assert.NotPanics(t, func() { _ = errorType.String() })
+	}
+	assert.Equal(t, "FISSILE-V004", Invalid("a", "b", "c").Code())
+	assert.Equal(t, "FISSILE-V000", ErrorType("Bogus").Code())
+}
+
+func TestValidationCodes(t *testing.T) {
+	// Every validation needs a code of its own, distinct from the codes of the
+	// error types
+	file, err := parser.ParseFile(token.NewFileSet(), "codes.go", nil, 0)
+	require.NoError(t, err)
+	codes := map[string]string{}
+	for _, code := range errorCodes {
+		codes[code] = "error type"
+	}
+	for _, decl := range file.Decls {
+		for _, spec := range decl.(*ast.GenDecl).Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			name := valueSpec.Names[0].Name
+			code, err := strconv.Unquote(valueSpec.Values[0].(*ast.BasicLit).Value)
+			require.NoError(t, err)
+			assert.Regexp(t, `^FISSILE-V\d{3}$`, code)
+			assert.NotContains(t, codes, code, "Code %s used for both %s and %s", code, codes[code], name)
+			codes[code] = name
+		}
+	}
+}
+
+func TestErrorWithCode(t *testing.T) {
+	errs := ErrorList{
+		Invalid("a", "b", "c").WithCode(CodeMemory),
+		Invalid("a", "b", "c"),
+		PluginError("ACME-001", "a", "b", "c", false),
+	}.WithCode(CodeRoleRun)
+
+	assert.Equal(t, CodeMemory, errs[0].Code())
+	assert.Equal(t, CodeRoleRun, errs[1].Code())
+	assert.Equal(t, "ACME-001", errs[2].Code())
+}
+
+func TestErrorGroups(t *testing.T) {
+	errs := ErrorList{
+		Invalid("variables", "FOO", "bad"),
+		NotFound("instance_groups[nats].run.memory", "x"),
+		Required("instance_group[nats]", "missing"),
+		Forbidden("configuration.templates[foo]", "no"),
+		Invalid("instance_groups[api].jobs[a]", "y", ""),
+	}
+
+	names, groups := errs.Groups()
+	assert.Equal(t, []string{"configuration", "instance_groups[api]", "instance_groups[nats]", "variables"}, names)
+	assert.Len(t, groups["instance_groups[nats]"], 2)
+	assert.Len(t, groups["variables"], 1)
+}
+
+func TestErrorMarshalJSON(t *testing.T) {
+	buf, err := json.Marshal(ErrorList{Invalid("variables", math.Inf(1), "detail")})
+	assert.NoError(t, err)
+
+	var actual []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf, &actual))
+	if assert.Len(t, actual, 1) {
+		assert.Equal(t, "FISSILE-V004", actual[0]["code"])
+		assert.Equal(t, "FieldValueInvalid", actual[0]["type"])
+		assert.Equal(t, "variables", actual[0]["group"])
+		assert.Equal(t, "variables", actual[0]["field"])
+		assert.Equal(t, "+Inf", actual[0]["value"])
+		assert.Equal(t, "detail", actual[0]["detail"])
+	}
+}
@@ -0,0 +1,82 @@
+package validation
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Suppression describes a known exception for validation errors. Errors with
+// the given code are suppressed; if Field is set the field of the error must
+// match it as well, where "*" matches any sequence of characters.
+type Suppression struct {
+	Code   string `yaml:"code"`
+	Field  string `yaml:"field,omitempty"`
+	Reason string `yaml:"reason,omitempty"`
+}
+
+// LoadSuppressions reads the list of suppressions from a YAML file of the form
+//
+//	suppress:
+//	- code: FISSILE-V001
+//	  field: instance_groups[nats].*
+//	  reason: Known issue
+func LoadSuppressions(path string) ([]Suppression, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Suppress []Suppression `yaml:"suppress"`
+	}
+	err = yaml.Unmarshal(contents, &config)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing suppressions file %s: %v", path, err)
+	}
+
+	for _, suppression := range config.Suppress {
+		if suppression.Code == "" {
+			return nil, fmt.Errorf("Error in suppressions file %s: every entry needs a code", path)
+		}
+	}
+
+	return config.Suppress, nil
+}
+
+// matches checks if the suppression applies to the error
+func (s Suppression) matches(err *Error) bool {
+	if s.Code != err.Code() {
+		return false
+	}
+	if s.Field == "" {
+		return true
+	}
+	parts := strings.Split(s.Field, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	pattern := "^" + strings.Join(parts, ".*") + "$"
+	return regexp.MustCompile(pattern).MatchString(err.Field)
+}
+
+// Suppress returns the errors not matched by any of the suppressions.
+func (v ErrorList) Suppress(suppressions []Suppression) ErrorList {
+	result := ErrorList{}
+	for _, item := range v {
+		suppressed := false
+		for _, suppression := range suppressions {
+			if suppression.matches(item) {
+				suppressed = true
+				break
+			}
+		}
+		if !suppressed {
+			result = append(result, item)
+		}
+	}
+	return result
+}
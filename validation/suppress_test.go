@@ -0,0 +1,56 @@
+package validation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuppress(t *testing.T) {
+	errs := ErrorList{
+		Invalid("variables", "FOO", "bad"),
+		NotFound("instance_groups[nats].run.memory", "x"),
+		NotFound("instance_groups[api].run.memory", "x"),
+		Required("configuration.templates", "missing"),
+	}
+
+	suppressed := errs.Suppress([]Suppression{
+		{Code: "FISSILE-V001", Field: "instance_groups[nats].*"},
+		{Code: "FISSILE-V002"},
+	})
+	assert.Equal(t, []string{
+		`variables: Invalid value: "FOO": bad`,
+		`instance_groups[api].run.memory: Not found: "x"`,
+	}, suppressed.ErrorStrings())
+
+	assert.Equal(t, errs, errs.Suppress(nil))
+}
+
+func TestLoadSuppressions(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "fissile-suppressions")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	path := filepath.Join(workDir, "suppress.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+suppress:
+- code: FISSILE-V001
+  field: instance_groups[nats].*
+  reason: Known issue
+`), 0644))
+
+	suppressions, err := LoadSuppressions(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Suppression{{Code: "FISSILE-V001", Field: "instance_groups[nats].*", Reason: "Known issue"}}, suppressions)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("suppress:\n- field: foo\n"), 0644))
+	_, err = LoadSuppressions(path)
+	assert.EqualError(t, err, "Error in suppressions file "+path+": every entry needs a code")
+
+	_, err = LoadSuppressions(filepath.Join(workDir, "missing.yml"))
+	assert.Error(t, err)
+}
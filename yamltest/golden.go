@@ -0,0 +1,90 @@
+package yamltest
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// UpdateFlag is the name of the boolean flag of the test binary that makes
+// IsYAMLEqualGolden rewrite the golden files, as in `go test -update`. The
+// flag is looked up instead of being defined here, so that it doesn't clash
+// with an -update flag of the test packages importing yamltest; packages
+// without one define it themselves:
+//
+//	var _ = flag.Bool(yamltest.UpdateFlag, false, "update the golden files")
+const UpdateFlag = "update"
+
+// Update makes IsYAMLEqualGolden rewrite the golden files from the actual
+// documents instead of comparing them, like the -update flag. It is also set
+// by running the tests with the YAMLTEST_UPDATE environment variable set.
+var Update = os.Getenv("YAMLTEST_UPDATE") != ""
+
+// updating returns whether the golden files are rewritten, either by Update
+// or by the -update flag of the test binary
+func updating() bool {
+	if Update {
+		return true
+	}
+	updateFlag := flag.Lookup(UpdateFlag)
+	if updateFlag == nil {
+		return false
+	}
+	getter, ok := updateFlag.Value.(flag.Getter)
+	if !ok {
+		return false
+	}
+	update, _ := getter.Get().(bool)
+	return update
+}
+
+// IsYAMLEqualGolden asserts that the actual properties are equal to the YAML
+// document stored in the golden file. With -update or Update, the golden file is
+// written from the actual properties instead, creating its directory as
+// needed.
+func IsYAMLEqualGolden(assert *assert.Assertions, path string, actual interface{}) bool {
+	if updating() {
+		return assert.NoError(writeGolden(path, actual), "failed to update golden file %s", path)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return assert.Fail("Missing golden file", "%s does not exist; run the tests with -update to create it", path)
+	}
+	if !assert.NoError(err) {
+		return false
+	}
+	var expected interface{}
+	if !assert.NoError(yaml.Unmarshal(content, &expected), "invalid golden file %s", path) {
+		return false
+	}
+
+	// Roundtrip the actual properties, so that structs compare like the
+	// generic YAML of the golden file
+	buf, err := yaml.Marshal(actual)
+	if !assert.NoError(err) {
+		return false
+	}
+	var actualYAML interface{}
+	if !assert.NoError(yaml.Unmarshal(buf, &actualYAML)) {
+		return false
+	}
+	return IsYAMLEqual(assert, expected, actualYAML)
+}
+
+// writeGolden stores the YAML serialization of the actual properties in the
+// golden file
+func writeGolden(path string, actual interface{}) error {
+	buf, err := yaml.Marshal(actual)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0644)
+}
@@ -1,11 +1,20 @@
-package testhelpers
+// Package yamltest provides assertions comparing YAML documents, for use in
+// the tests of fissile as well as in the tests of the consumers of the
+// charts and configs it generates.
+//
+// The documents are compared structurally, and failures are reported with
+// the YAML path of each mismatch, followed by a unified diff of the expected
+// and actual documents. Expected documents can be kept in golden files,
+// which are rewritten from the actual documents when the tests are run with
+// the -update flag.
+package yamltest
 
 import (
 	"fmt"
-	"os"
 	"reflect"
 	"strings"
 
+	"github.com/pmezard/go-difflib/difflib"
 	"github.com/stretchr/testify/assert"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -15,7 +24,6 @@ import (
 func Dedent(x string) string {
 	x = strings.Replace(x, "-\t", "-  ", -1)
 	x = strings.Replace(x, "\t", "   ", -1)
-	// fmt.Printf("YAML_________\n%s\n_____END\n", x)
 	return x
 }
 
@@ -25,9 +33,7 @@ func Dedent(x string) string {
 // the string (tabs) is replaced with proper YAML indentation.
 func IsYAMLEqualString(assert *assert.Assertions, expected string, actual interface{}) bool {
 	var expectedYAML interface{}
-	if !assert.NoError(yaml.Unmarshal([]byte(Dedent(expected)), &expectedYAML)) {
-		_, err := os.Stderr.WriteString(expected)
-		assert.NoError(err)
+	if !assert.NoError(yaml.Unmarshal([]byte(Dedent(expected)), &expectedYAML), "invalid expected YAML:\n%s", expected) {
 		return false
 	}
 	return IsYAMLEqual(assert, expectedYAML, actual)
@@ -37,20 +43,11 @@ func IsYAMLEqualString(assert *assert.Assertions, expected string, actual interf
 // in the actual properties, and vice versa.
 func IsYAMLEqual(assert *assert.Assertions, expected, actual interface{}) bool {
 	result := isYAMLSubsetInner(assert, expected, actual, nil)
-	if !result {
-		buf, err := yaml.Marshal(actual)
-		if assert.NoError(err) {
-			_, err := os.Stderr.Write(buf)
-			assert.NoError(err)
-		}
+	if !isYAMLSubsetInner(assert, actual, expected, nil) {
+		result = false
 	}
-	result = isYAMLSubsetInner(assert, actual, expected, nil)
 	if !result {
-		buf, err := yaml.Marshal(actual)
-		if assert.NoError(err) {
-			_, err := os.Stderr.Write(buf)
-			assert.NoError(err)
-		}
+		failWithDiff(assert, expected, actual)
 	}
 	return result
 }
@@ -62,27 +59,89 @@ func IsYAMLEqual(assert *assert.Assertions, expected, actual interface{}) bool {
 // (tabs) is replaced with proper YAML indentation.
 func IsYAMLSubsetString(assert *assert.Assertions, expected string, actual interface{}) bool {
 	var expectedYAML interface{}
-	if !assert.NoError(yaml.Unmarshal([]byte(Dedent(expected)),
-		&expectedYAML)) {
+	if !assert.NoError(yaml.Unmarshal([]byte(Dedent(expected)), &expectedYAML), "invalid expected YAML:\n%s", expected) {
 		return false
 	}
 	return IsYAMLSubset(assert, expectedYAML, actual)
 }
 
 // IsYAMLSubset asserts that all items in the expected properties are in the actual properties.
-// Note, the actual properties may contain more than expected.
+// Note, the actual properties may contain more than expected. The diff
+// reported on failure only covers the parts of the actual properties that
+// are expected.
 func IsYAMLSubset(assert *assert.Assertions, expected, actual interface{}) bool {
 	result := isYAMLSubsetInner(assert, expected, actual, nil)
 	if !result {
-		buf, err := yaml.Marshal(actual)
-		if assert.NoError(err) {
-			_, err := os.Stderr.Write(buf)
-			assert.NoError(err)
-		}
+		failWithDiff(assert, expected, restrict(expected, actual))
 	}
 	return result
 }
 
+// Diff returns a unified diff of the YAML serializations of the expected and
+// actual documents. It is empty if they serialize identically.
+func Diff(expected, actual interface{}) (string, error) {
+	expectedYAML, err := yaml.Marshal(expected)
+	if err != nil {
+		return "", err
+	}
+	actualYAML, err := yaml.Marshal(actual)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(expectedYAML)),
+		B:        difflib.SplitLines(string(actualYAML)),
+		FromFile: "Expected",
+		ToFile:   "Actual",
+		Context:  3,
+	})
+}
+
+// failWithDiff records a failure showing the differences of the documents
+func failWithDiff(assert *assert.Assertions, expected, actual interface{}) {
+	diff, err := Diff(expected, actual)
+	if assert.NoError(err) {
+		assert.Fail("YAML documents differ", "Diff:\n%s", diff)
+	}
+}
+
+// restrict returns the parts of the actual document which are present in the
+// expected document, so that the diff of a subset is not cluttered with the
+// unexpected parts.
+func restrict(expected, actual interface{}) interface{} {
+	expectedValue := reflect.ValueOf(expected)
+	actualValue := reflect.ValueOf(actual)
+	if !expectedValue.IsValid() || !actualValue.IsValid() {
+		return actual
+	}
+
+	switch {
+	case expectedValue.Kind() == reflect.Map && actualValue.Kind() == reflect.Map:
+		result := map[interface{}]interface{}{}
+		for _, keyValue := range actualValue.MapKeys() {
+			key := keyValue.Interface()
+			for _, expectedKeyValue := range expectedValue.MapKeys() {
+				if fmt.Sprintf("%v", expectedKeyValue.Interface()) == fmt.Sprintf("%v", key) {
+					result[key] = restrict(expectedValue.MapIndex(expectedKeyValue).Interface(), actualValue.MapIndex(keyValue).Interface())
+					break
+				}
+			}
+		}
+		return result
+	case expectedValue.Kind() == reflect.Slice && actualValue.Kind() == reflect.Slice:
+		result := make([]interface{}, actualValue.Len())
+		for i := range result {
+			if i < expectedValue.Len() {
+				result[i] = restrict(expectedValue.Index(i).Interface(), actualValue.Index(i).Interface())
+			} else {
+				result[i] = actualValue.Index(i).Interface()
+			}
+		}
+		return result
+	}
+	return actual
+}
+
 func isYAMLSubsetInner(assert *assert.Assertions, expected, actual interface{}, prefix []string) bool {
 	yamlPath := strings.Join(prefix, ".")
 	if yamlPath == "" {
@@ -0,0 +1,105 @@
+package yamltest
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ = flag.Bool(UpdateFlag, false, "update the golden files")
+
+// recordingT records the failures of assertions, to check failing cases
+// without failing the test
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestIsYAMLSubset(t *testing.T) {
+	t.Parallel()
+
+	actual := map[string]interface{}{
+		"kind": "Pod",
+		"spec": map[interface{}]interface{}{
+			"containers": []interface{}{
+				map[interface{}]interface{}{"name": "nats", "image": "nats:1"},
+			},
+		},
+	}
+
+	assert.True(t, IsYAMLSubsetString(assert.New(t), `
+		spec:
+			containers:
+			-	name: nats
+	`, actual))
+
+	recorder := &recordingT{}
+	assert.False(t, IsYAMLSubsetString(assert.New(recorder), `
+		spec:
+			containers:
+			-	name: mysql
+	`, actual))
+	output := strings.Join(recorder.errors, "\n")
+	assert.Contains(t, output, "unexpected value at YAML path spec.containers.0.name")
+	assert.Contains(t, output, "-  - name: mysql")
+	assert.Contains(t, output, "+  - name: nats")
+	assert.NotContains(t, output, "image", "The diff should be restricted to the expected keys")
+}
+
+func TestIsYAMLEqual(t *testing.T) {
+	t.Parallel()
+
+	actual := map[interface{}]interface{}{"a": 1, "b": []interface{}{"c"}}
+	assert.True(t, IsYAMLEqualString(assert.New(t), "{ a: 1, b: [c] }", actual))
+
+	recorder := &recordingT{}
+	assert.False(t, IsYAMLEqualString(assert.New(recorder), "{ a: 1 }", actual))
+	output := strings.Join(recorder.errors, "\n")
+	assert.Contains(t, output, "missing key b in YAML path <root>")
+	assert.Contains(t, output, "+b:")
+}
+
+func TestIsYAMLEqualGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fissile-yamltest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "golden", "pod.yaml")
+
+	actual := struct {
+		Kind string `yaml:"kind"`
+	}{Kind: "Pod"}
+
+	recorder := &recordingT{}
+	assert.False(t, IsYAMLEqualGolden(assert.New(recorder), path, actual))
+	assert.Contains(t, strings.Join(recorder.errors, "\n"), "run the tests with -update")
+
+	// This test is not run in parallel, as it changes Update
+	Update = true
+	defer func() { Update = false }()
+	assert.True(t, IsYAMLEqualGolden(assert.New(t), path, actual))
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "kind: Pod\n", string(content))
+
+	Update = false
+	assert.True(t, IsYAMLEqualGolden(assert.New(t), path, actual))
+	assert.False(t, IsYAMLEqualGolden(assert.New(&recordingT{}), path, map[string]interface{}{"kind": "Job"}))
+
+	// The -update flag rewrites the golden file as well
+	require.NoError(t, flag.Set(UpdateFlag, "true"))
+	defer flag.Set(UpdateFlag, "false")
+	assert.True(t, IsYAMLEqualGolden(assert.New(t), path, map[string]interface{}{"kind": "Job"}))
+	content, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "kind: Job\n", string(content))
+}